@@ -13,25 +13,177 @@ import (
 	"io/fs"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 )
 
+// reproducibleModTime is the modification time recorded for all entries in a reproducible archive.
+var reproducibleModTime = time.Unix(0, 0)
+
+// readLinkFS is implemented by file systems that support reading symbolic links.
+type readLinkFS interface {
+	fs.FS
+
+	// ReadLink returns the destination of the named symbolic link.
+	ReadLink(name string) (string, error)
+
+	// Lstat returns a FileInfo describing the named file, without following a final symbolic link.
+	Lstat(name string) (fs.FileInfo, error)
+}
+
+// maxSymlinks is the maximum number of symbolic links followed when resolving a path.
+const maxSymlinks = 40
+
 type archiver struct {
-	fs       fs.FS
-	w        *tar.Writer
-	archived map[string]struct{}
+	fs               fs.FS
+	w                *tar.Writer
+	archived         map[string]struct{}
+	reproducible     bool
+	pending          map[string]*tar.Header // Entries to be written on Close (reproducible only).
+	preserveSymlinks bool
+	root             string // Root of the tree currently being archived.
+}
+
+type archiverOption func(*archiver)
+
+// optArchiveReproducible specifies whether the archive should be reproducible. When set, entries
+// are written in lexical order, and metadata that varies between hosts and checkouts (modification
+// times and ownership) is normalized, so that the archive is a pure function of the paths, modes
+// and contents of the files it contains.
+func optArchiveReproducible(b bool) archiverOption {
+	return func(ar *archiver) {
+		ar.reproducible = b
+	}
+}
+
+// optArchivePreserveSymlinks specifies whether symbolic links should be preserved. When set, a
+// symbolic link is written as such if it resolves to a path within the tree being archived.
+// Symbolic links that escape the tree are followed. Preserving symbolic links requires a file
+// system that implements ReadLink and Lstat.
+func optArchivePreserveSymlinks(b bool) archiverOption {
+	return func(ar *archiver) {
+		ar.preserveSymlinks = b
+	}
 }
 
 // newArchiver returns an archiver that will write an archive to w.
-func newArchiver(fsys fs.FS, w io.Writer) *archiver {
-	return &archiver{
+func newArchiver(fsys fs.FS, w io.Writer, opts ...archiverOption) *archiver {
+	ar := &archiver{
 		fs:       fsys,
 		w:        tar.NewWriter(w),
 		archived: make(map[string]struct{}),
+		pending:  make(map[string]*tar.Header),
+	}
+
+	for _, opt := range opts {
+		opt(ar)
+	}
+
+	return ar
+}
+
+var (
+	errUnsupportedType     = errors.New("unsupported file type")
+	errSymlinkLoop         = errors.New("too many levels of symbolic links")
+	errSymlinksUnsupported = errors.New("file system does not support reading symbolic links")
+)
+
+// withinRoot returns true if name is within the tree rooted at root.
+func withinRoot(root, name string) bool {
+	if root == "." {
+		return name != ".." && !strings.HasPrefix(name, "../")
 	}
+	return name == root || strings.HasPrefix(name, root+"/")
 }
 
-var errUnsupportedType = errors.New("unsupported file type")
+// resolveLink resolves the chain of symbolic links starting at name, and reports whether each link
+// in the chain is relative, and resolves to a path within the tree rooted at ar.root. If the chain
+// contains a loop, errSymlinkLoop is returned.
+func (ar *archiver) resolveLink(lfs readLinkFS, name string) (bool, error) {
+	within := true
+
+	for i := 0; i < maxSymlinks; i++ {
+		target, err := lfs.ReadLink(name)
+		if err != nil {
+			return false, err
+		}
+
+		// An absolute target cannot be resolved relative to the file system, so leave it to the
+		// file system to follow.
+		if path.IsAbs(target) {
+			return false, nil
+		}
+
+		name = path.Join(path.Dir(name), target)
+		if !withinRoot(ar.root, name) {
+			within = false
+		}
+
+		fi, err := lfs.Lstat(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			return within, nil
+		} else if err != nil {
+			return false, err
+		}
+
+		if fi.Mode()&fs.ModeSymlink == 0 {
+			return within, nil
+		}
+	}
+
+	return false, errSymlinkLoop
+}
+
+// stat returns a FileInfo describing the named file. If the named file is a symbolic link that is
+// to be preserved, the FileInfo describes the link, and its destination is returned. Otherwise, the
+// link is followed.
+//
+// If the file system supports reading symbolic links, links are resolved before being followed, so
+// that loops are reported as an error.
+func (ar *archiver) stat(name string) (fs.FileInfo, string, error) {
+	lfs, ok := ar.fs.(readLinkFS)
+	if !ok {
+		if ar.preserveSymlinks {
+			return nil, "", errSymlinksUnsupported
+		}
+		fi, err := fs.Stat(ar.fs, name)
+		return fi, "", err
+	}
+
+	fi, err := lfs.Lstat(name)
+	if err != nil || fi.Mode()&fs.ModeSymlink == 0 {
+		return fi, "", err
+	}
+
+	within, err := ar.resolveLink(lfs, name)
+	if err != nil {
+		return nil, "", fmt.Errorf("%v: %w", name, err)
+	}
+
+	if ar.preserveSymlinks && within {
+		target, err := lfs.ReadLink(name)
+		return fi, target, err
+	}
+
+	fi, err = fs.Stat(ar.fs, name)
+	return fi, "", err
+}
+
+// glob returns the names of all files matching pattern. Patterns without meta characters are
+// checked without following symbolic links, since resolving a loop may not terminate on some file
+// systems.
+func (ar *archiver) glob(pattern string) ([]string, error) {
+	lfs, ok := ar.fs.(readLinkFS)
+	if !ok || strings.ContainsAny(pattern, `*?[\`) {
+		return fs.Glob(ar.fs, pattern)
+	}
+
+	if _, err := lfs.Lstat(pattern); err != nil {
+		return nil, nil //nolint:nilerr
+	}
+	return []string{pattern}, nil
+}
 
 // writeEntry writes the named path from the file system to the archive.
 func (ar *archiver) writeEntry(name string) (err error) {
@@ -46,13 +198,13 @@ func (ar *archiver) writeEntry(name string) (err error) {
 	}()
 
 	// Get file info.
-	fi, err := fs.Stat(ar.fs, name)
+	fi, link, err := ar.stat(name)
 	if err != nil {
 		return err
 	}
 
 	// Populate TAR header based on file info, and normalize name.
-	h, err := tar.FileInfoHeader(fi, "")
+	h, err := tar.FileInfoHeader(fi, link)
 	if err != nil {
 		return err
 	}
@@ -64,10 +216,12 @@ func (ar *archiver) writeEntry(name string) (err error) {
 		// Nothing to do.
 
 	case tar.TypeSymlink:
-		// Always follow symbolic links.
-		h.Typeflag = tar.TypeReg
-		h.Linkname = ""
-		h.Size = fi.Size()
+		// Follow symbolic links, unless the link is to be preserved.
+		if link == "" {
+			h.Typeflag = tar.TypeReg
+			h.Linkname = ""
+			h.Size = fi.Size()
+		}
 
 	case tar.TypeDir:
 		// Normalize name.
@@ -79,6 +233,27 @@ func (ar *archiver) writeEntry(name string) (err error) {
 		return fmt.Errorf("%v: %w (%v)", name, errUnsupportedType, h.Typeflag)
 	}
 
+	// If the archive is to be reproducible, normalize the header and defer writing the entry until
+	// the full set of entries is known.
+	if ar.reproducible {
+		h.ModTime = reproducibleModTime
+		h.AccessTime = time.Time{}
+		h.ChangeTime = time.Time{}
+		h.Uid = 0
+		h.Gid = 0
+		h.Uname = ""
+		h.Gname = ""
+
+		ar.pending[name] = h
+		return nil
+	}
+
+	return ar.writeHeaderAndContents(name, h)
+}
+
+// writeHeaderAndContents writes h to the archive, followed by the contents of the named path from
+// the file system, if applicable.
+func (ar *archiver) writeHeaderAndContents(name string, h *tar.Header) error {
 	// Write TAR header.
 	if err := ar.w.WriteHeader(h); err != nil {
 		return err
@@ -136,7 +311,7 @@ func (ar *archiver) writeDirAll(name string) error {
 // WriteFiles writes all files matching pattern from the file system to the archive. If the named
 // path is a directory, its contents are recursively added using fs.WalkDir.
 func (ar *archiver) WriteFiles(pattern string) error {
-	names, err := fs.Glob(ar.fs, pattern)
+	names, err := ar.glob(pattern)
 	if err != nil {
 		return err
 	}
@@ -146,12 +321,15 @@ func (ar *archiver) WriteFiles(pattern string) error {
 	}
 
 	for _, name := range names {
+		// Symbolic links are only preserved within the tree rooted at name.
+		ar.root = name
+
 		// Ensure parent directory exists in archive.
 		if err := ar.writeDirAll(path.Dir(name)); err != nil {
 			return err
 		}
 
-		fi, err := fs.Stat(ar.fs, name)
+		fi, _, err := ar.stat(name)
 		if err != nil {
 			return err
 		}
@@ -169,7 +347,24 @@ func (ar *archiver) WriteFiles(pattern string) error {
 	return nil
 }
 
-// Close closes the archive.
+// Close writes any pending entries, and closes the archive.
 func (ar *archiver) Close() error {
+	names := make([]string, 0, len(ar.pending))
+	for name := range ar.pending {
+		names = append(names, name)
+	}
+
+	// Directories sort before their contents, since a name sorts before any name it prefixes.
+	sort.Strings(names)
+
+	for _, name := range names {
+		h := ar.pending[name]
+		delete(ar.pending, name)
+
+		if err := ar.writeHeaderAndContents(name, h); err != nil {
+			return err
+		}
+	}
+
 	return ar.w.Close()
 }
@@ -8,6 +8,7 @@ package client
 import (
 	"bytes"
 	"errors"
+	"io"
 	"io/fs"
 	"testing"
 	"testing/fstest"
@@ -224,3 +225,194 @@ func Test_archiver_WriteFiles(t *testing.T) {
 		})
 	}
 }
+
+func Test_archiver_WriteFilesReproducible(t *testing.T) {
+	tests := []struct {
+		name  string
+		fs    fs.FS
+		paths []string
+	}{
+		{
+			name: "Sorted",
+			fs: fstest.MapFS{
+				"a": &fstest.MapFile{
+					Mode:    0o755 | fs.ModeDir,
+					ModTime: testTime,
+				},
+				"a/b": &fstest.MapFile{
+					Data:    []byte("hello"),
+					Mode:    0o644,
+					ModTime: testTime,
+				},
+				"c": &fstest.MapFile{
+					Data:    []byte("goodbye"),
+					Mode:    0o755,
+					ModTime: testTime,
+				},
+			},
+			paths: []string{"a", "c"},
+		},
+		{
+			name: "Unsorted",
+			fs: fstest.MapFS{
+				"a": &fstest.MapFile{
+					Mode:    0o755 | fs.ModeDir,
+					ModTime: testTime,
+				},
+				"a/b": &fstest.MapFile{
+					Data:    []byte("hello"),
+					Mode:    0o644,
+					ModTime: testTime,
+				},
+				"c": &fstest.MapFile{
+					Data:    []byte("goodbye"),
+					Mode:    0o755,
+					ModTime: testTime,
+				},
+			},
+			paths: []string{"c", "a/b"},
+		},
+		{
+			name: "ModTime",
+			fs: fstest.MapFS{
+				"a": &fstest.MapFile{
+					Mode:    0o755 | fs.ModeDir,
+					ModTime: testTime.Add(time.Hour),
+				},
+				"a/b": &fstest.MapFile{
+					Data:    []byte("hello"),
+					Mode:    0o644,
+					ModTime: time.Now(),
+				},
+				"c": &fstest.MapFile{
+					Data:    []byte("goodbye"),
+					Mode:    0o755,
+					ModTime: time.Now(),
+				},
+			},
+			paths: []string{"*"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := bytes.Buffer{}
+
+			ar := newArchiver(tt.fs, &b, optArchiveReproducible(true))
+
+			for _, path := range tt.paths {
+				if err := ar.WriteFiles(path); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := ar.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// All cases must produce a byte-identical archive.
+			g := goldie.New(t, goldie.WithTestNameForDir(true))
+			g.Assert(t, "Reproducible", b.Bytes())
+		})
+	}
+}
+
+func Test_archiver_WriteFilesSymlinks(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a": &fstest.MapFile{
+			Mode:    0o755 | fs.ModeDir,
+			ModTime: testTime,
+		},
+		"a/b": &fstest.MapFile{
+			Data:    []byte("hello"),
+			Mode:    0o644,
+			ModTime: testTime,
+		},
+		"a/inside": &fstest.MapFile{
+			Data:    []byte("b"),
+			Mode:    0o777 | fs.ModeSymlink,
+			ModTime: testTime,
+		},
+		"a/chain": &fstest.MapFile{
+			Data:    []byte("inside"),
+			Mode:    0o777 | fs.ModeSymlink,
+			ModTime: testTime,
+		},
+		"a/escapes": &fstest.MapFile{
+			Data:    []byte("../c"),
+			Mode:    0o777 | fs.ModeSymlink,
+			ModTime: testTime,
+		},
+		"c": &fstest.MapFile{
+			Data:    []byte("goodbye"),
+			Mode:    0o644,
+			ModTime: testTime,
+		},
+		"loop": &fstest.MapFile{
+			Mode:    0o755 | fs.ModeDir,
+			ModTime: testTime,
+		},
+		"loop/x": &fstest.MapFile{
+			Data:    []byte("y"),
+			Mode:    0o777 | fs.ModeSymlink,
+			ModTime: testTime,
+		},
+		"loop/y": &fstest.MapFile{
+			Data:    []byte("x"),
+			Mode:    0o777 | fs.ModeSymlink,
+			ModTime: testTime,
+		},
+	}
+
+	tests := []struct {
+		name     string
+		preserve bool
+		paths    []string
+		wantErr  error
+	}{
+		{"FollowTree", false, []string{"a"}, nil},
+		{"FollowFile", false, []string{"a/inside"}, nil},
+		{"FollowLoop", false, []string{"loop"}, errSymlinkLoop},
+		{"PreserveTree", true, []string{"a"}, nil},
+		{"PreserveFile", true, []string{"a/inside"}, nil},
+		{"PreserveLoop", true, []string{"loop"}, errSymlinkLoop},
+		{"PreserveLoopFile", true, []string{"loop/x"}, errSymlinkLoop},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := bytes.Buffer{}
+
+			ar := newArchiver(fsys, &b, optArchivePreserveSymlinks(tt.preserve))
+
+			for _, path := range tt.paths {
+				if got, want := ar.WriteFiles(path), tt.wantErr; !errors.Is(got, want) {
+					t.Fatalf("got error %v, want %v", got, want)
+				}
+			}
+
+			if err := ar.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.wantErr == nil {
+				g := goldie.New(t, goldie.WithTestNameForDir(true))
+				g.Assert(t, tt.name, b.Bytes())
+			}
+		})
+	}
+}
+
+func Test_archiver_WriteFilesSymlinksUnsupported(t *testing.T) {
+	// Wrap file system to hide its ReadLink and Lstat methods.
+	fsys := struct{ fs.FS }{fstest.MapFS{
+		"a": &fstest.MapFile{
+			Data: []byte("hello"),
+			Mode: 0o644,
+		},
+	}}
+
+	ar := newArchiver(fsys, io.Discard, optArchivePreserveSymlinks(true))
+
+	if got, want := ar.WriteFiles("a"), errSymlinksUnsupported; !errors.Is(got, want) {
+		t.Errorf("got error %v, want %v", got, want)
+	}
+}
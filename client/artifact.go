@@ -0,0 +1,74 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	errArtifactSizeMismatch     = errors.New("artifact size mismatch")
+	errArtifactChecksumMismatch = errors.New("artifact checksum mismatch")
+)
+
+// GetArtifact streams the image built by the build with the specified ID from the Build Service to
+// w. The context controls the lifetime of the request.
+//
+// The image is verified against the Content-Length of the response and, if reported, the sha256
+// checksum in the BuildInfo of the build. Since the image is streamed, w may have been written to
+// when verification fails, and its contents should then be discarded.
+func (c *Client) GetArtifact(ctx context.Context, buildID string, w io.Writer) error {
+	bi, err := c.GetStatus(ctx, buildID)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	ref := &url.URL{
+		Path: "v1/image/" + buildID,
+	}
+
+	req, err := c.newRequest(ctx, http.MethodGet, ref, nil)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	res, err := c.doWithRefresh(c.httpClient, req)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 { // non-2xx status code
+		return fmt.Errorf("%w", c.errorFromResponse(res))
+	}
+
+	h := sha256.New()
+
+	n, err := io.Copy(io.MultiWriter(w, h), res.Body)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if res.ContentLength >= 0 && n != res.ContentLength {
+		return fmt.Errorf("%w (expecting %v bytes, got %v)", errArtifactSizeMismatch, res.ContentLength, n)
+	}
+
+	if algo, sum, ok := strings.Cut(bi.ImageChecksum(), "."); ok && strings.EqualFold(algo, "sha256") {
+		if got := hex.EncodeToString(h.Sum(nil)); got != sum {
+			return fmt.Errorf("%w (expecting %v, got %v)", errArtifactChecksumMismatch, sum, got)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	jsonresp "github.com/sylabs/json-resp"
+)
+
+type mockGetArtifact struct {
+	t             *testing.T
+	checksum      string // Checksum reported in build info.
+	contentLength int    // Content-Length header, if non-zero.
+	body          []byte // Image contents served.
+	code          int    // Status code returned by the image endpoint.
+}
+
+func (m *mockGetArtifact) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/v1/build/id":
+		rbi := rawBuildInfo{ID: "id", IsComplete: true, ImageChecksum: m.checksum}
+		if err := jsonresp.WriteResponse(w, rbi, http.StatusOK); err != nil {
+			m.t.Fatal(err)
+		}
+
+	case "/v1/image/id":
+		if m.code != http.StatusOK {
+			w.WriteHeader(m.code)
+			return
+		}
+
+		if m.contentLength != 0 {
+			w.Header().Set("Content-Length", strconv.Itoa(m.contentLength))
+		}
+
+		if _, err := w.Write(m.body); err != nil {
+			m.t.Fatal(err)
+		}
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestClient_GetArtifact(t *testing.T) {
+	image := []byte(imageContents)
+	checksum := fmt.Sprintf("sha256.%x", sha256.Sum256(image))
+
+	tests := []struct {
+		name          string
+		checksum      string
+		contentLength int
+		body          []byte
+		code          int
+		wantErr       error
+	}{
+		{
+			name:     "Success",
+			checksum: checksum,
+			body:     image,
+			code:     http.StatusOK,
+		},
+		{
+			name: "NoChecksum",
+			body: image,
+			code: http.StatusOK,
+		},
+		{
+			name:          "PartialRead",
+			checksum:      checksum,
+			contentLength: len(image) + 1,
+			body:          image,
+			code:          http.StatusOK,
+			wantErr:       io.ErrUnexpectedEOF,
+		},
+		{
+			name:     "ChecksumMismatch",
+			checksum: checksum,
+			body:     image[1:],
+			code:     http.StatusOK,
+			wantErr:  errArtifactChecksumMismatch,
+		},
+		{
+			name:    "NotFound",
+			code:    http.StatusNotFound,
+			wantErr: &httpError{Code: http.StatusNotFound},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := httptest.NewServer(&mockGetArtifact{
+				t:             t,
+				checksum:      tt.checksum,
+				contentLength: tt.contentLength,
+				body:          tt.body,
+				code:          tt.code,
+			})
+			t.Cleanup(s.Close)
+
+			c, err := NewClient(OptBaseURL(s.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var b bytes.Buffer
+
+			err = c.GetArtifact(context.Background(), "id", &b)
+
+			if tt.wantErr != nil {
+				if got, want := err, tt.wantErr; !errors.Is(got, want) {
+					t.Fatalf("got error %v, want %v", got, want)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := b.Bytes(), image; !bytes.Equal(got, want) {
+				t.Errorf("got image %q, want %q", got, want)
+			}
+		})
+	}
+}
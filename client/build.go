@@ -9,6 +9,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -16,18 +17,46 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
+	"time"
 
 	jsonresp "github.com/sylabs/json-resp"
+	library "github.com/sylabs/scs-library-client/client"
 )
 
-// rawBuildInfo contains the details of an individual build.
+// BuildState describes the progress of a build, as reported by the Build Service. Values other
+// than those defined here may be reported.
+type BuildState string
+
+const (
+	BuildStateUnknown   BuildState = ""          // Not reported, such as by older Build Service versions.
+	BuildStateQueued    BuildState = "queued"    // Awaiting a builder.
+	BuildStateBuilding  BuildState = "building"  // Running on a builder.
+	BuildStatePushing   BuildState = "pushing"   // Image being pushed to the library.
+	BuildStateSucceeded BuildState = "succeeded" // Completed, producing an image.
+	BuildStateFailed    BuildState = "failed"    // Completed without producing an image.
+)
+
+// rawBuildInfo contains the details of an individual build. Fields other than ID, IsComplete and
+// library details are optional, since older Build Service versions do not report them.
 type rawBuildInfo struct {
-	ID            string `json:"id"`
-	IsComplete    bool   `json:"isComplete"`
-	ImageSize     int64  `json:"imageSize,omitempty"`
-	ImageChecksum string `json:"imageChecksum,omitempty"`
-	LibraryRef    string `json:"libraryRef"`
-	LibraryURL    string `json:"libraryURL"`
+	ID            string     `json:"id"`
+	IsComplete    bool       `json:"isComplete"`
+	ImageSize     int64      `json:"imageSize,omitempty"`
+	ImageChecksum string     `json:"imageChecksum,omitempty"`
+	LibraryRef    string     `json:"libraryRef"`
+	LibraryURL    string     `json:"libraryURL"`
+	State         BuildState `json:"state,omitempty"`
+	Arch          string     `json:"arch,omitempty"`
+	QueuePosition *int       `json:"queuePosition,omitempty"`
+	SubmitTime    *time.Time `json:"submitTime,omitempty"`
+	StartTime     *time.Time `json:"startTime,omitempty"`
+	CompleteTime  *time.Time `json:"completeTime,omitempty"`
+	ExitCode      *int       `json:"exitCode,omitempty"`
+
+	DefinitionChecksum string `json:"definitionChecksum,omitempty"`
+	DefinitionRaw      []byte `json:"definitionRaw,omitempty"`
 }
 
 // BuildInfo contains the details of an individual build.
@@ -42,28 +71,145 @@ func (bi *BuildInfo) ImageChecksum() string { return bi.raw.ImageChecksum }
 func (bi *BuildInfo) LibraryRef() string    { return bi.raw.LibraryRef }
 func (bi *BuildInfo) LibraryURL() string    { return bi.raw.LibraryURL }
 
+// State returns the state of the build, or BuildStateUnknown if not reported.
+func (bi *BuildInfo) State() BuildState { return bi.raw.State }
+
+// Arch returns the architecture of the build, or an empty string if not reported.
+func (bi *BuildInfo) Arch() string { return bi.raw.Arch }
+
+// QueuePosition returns the position of the build in the queue, where 1 is next to be started. If
+// the position is not reported, such as when the build is not queued, ok is false.
+func (bi *BuildInfo) QueuePosition() (pos int, ok bool) { return derefOK(bi.raw.QueuePosition) }
+
+// SubmitTime returns the time the build was submitted, or the zero time if not reported.
+func (bi *BuildInfo) SubmitTime() time.Time { return derefTime(bi.raw.SubmitTime) }
+
+// StartTime returns the time the build started on a builder, or the zero time if not reported.
+func (bi *BuildInfo) StartTime() time.Time { return derefTime(bi.raw.StartTime) }
+
+// CompleteTime returns the time the build completed, or the zero time if not reported.
+func (bi *BuildInfo) CompleteTime() time.Time { return derefTime(bi.raw.CompleteTime) }
+
+// ExitCode returns the exit code of the build. If the exit code is not reported, such as when the
+// build is not complete, ok is false.
+func (bi *BuildInfo) ExitCode() (code int, ok bool) { return derefOK(bi.raw.ExitCode) }
+
+// DefinitionChecksum returns the checksum of the definition built, in "<algorithm>.<hex>" format,
+// or an empty string if not reported.
+func (bi *BuildInfo) DefinitionChecksum() string { return bi.raw.DefinitionChecksum }
+
+// DefinitionRaw returns the definition built, or nil if not reported. Where the Build Service
+// reports the definition, it may report DefinitionChecksum in its place.
+func (bi *BuildInfo) DefinitionRaw() []byte { return bi.raw.DefinitionRaw }
+
+// Failed returns true if the completed build failed. The exit code and state are used when
+// reported. Otherwise, as with older Build Service versions, a build that produced no image is
+// considered to have failed.
+func (bi *BuildInfo) Failed() bool {
+	if code, ok := bi.ExitCode(); ok {
+		return code != 0
+	}
+
+	switch bi.State() {
+	case BuildStateSucceeded:
+		return false
+	case BuildStateFailed:
+		return true
+	}
+
+	return bi.ImageSize() <= 0
+}
+
+// derefOK returns the value p points to, and true, or the zero value and false if p is nil.
+func derefOK[T any](p *T) (v T, ok bool) {
+	if p == nil {
+		return v, false
+	}
+	return *p, true
+}
+
+// derefTime returns the time p points to, or the zero time if p is nil.
+func derefTime(p *time.Time) time.Time {
+	t, _ := derefOK(p)
+	return t
+}
+
 type buildOptions struct {
 	libraryRef    string
-	arch          string
+	requirements  map[string]string
 	libraryURL    string
 	contextDigest string
 	workingDir    string
+	output        io.Writer
 }
 
 type BuildOption func(*buildOptions) error
 
-// OptBuildLibraryRef sets the Library image ref to push to.
+var errInvalidLibraryRef = errors.New("invalid library ref")
+
+// parseLibraryRef parses imageRef as a Library image ref, as accepted by OptBuildLibraryRef, and
+// returns it in normalized form.
+func parseLibraryRef(imageRef string) (string, error) {
+	ref, err := library.ParseAmbiguous(imageRef)
+	if err != nil {
+		return "", fmt.Errorf("%w %q: %w", errInvalidLibraryRef, imageRef, err)
+	}
+
+	if ref.Host != "" {
+		return "", fmt.Errorf("%w %q: must not contain a host", errInvalidLibraryRef, imageRef)
+	}
+
+	if parts := strings.Split(ref.Path, "/"); len(parts) != 3 || slices.Contains(parts, "") {
+		return "", fmt.Errorf("%w %q: must be in entity/collection/container form", errInvalidLibraryRef, imageRef)
+	}
+
+	if len(ref.Tags) == 0 || slices.Contains(ref.Tags, "") {
+		return "", fmt.Errorf("%w %q: must contain at least one tag", errInvalidLibraryRef, imageRef)
+	}
+
+	return ref.String(), nil
+}
+
+// OptBuildLibraryRef sets the Library image ref to push to, in hostless form
+// ("library:entity/collection/container:tag"), since the image is pushed to the Library associated
+// with the Build Service. The ref must name an entity, collection and container, and at least one
+// tag. An empty ref results in the image being pushed to an ephemeral location.
 func OptBuildLibraryRef(imageRef string) BuildOption {
 	return func(bo *buildOptions) error {
-		bo.libraryRef = imageRef
+		if imageRef == "" {
+			bo.libraryRef = ""
+			return nil
+		}
+
+		ref, err := parseLibraryRef(imageRef)
+		if err != nil {
+			return err
+		}
+
+		bo.libraryRef = ref
 		return nil
 	}
 }
 
-// OptBuildArchitecture sets the build architecture to arch.
+// OptBuildArchitecture sets the build architecture to arch. This is equivalent to
+// OptBuildRequirement("arch", arch), except that an empty arch removes the requirement.
 func OptBuildArchitecture(arch string) BuildOption {
 	return func(bo *buildOptions) error {
-		bo.arch = arch
+		if arch == "" {
+			delete(bo.requirements, "arch")
+			return nil
+		}
+		bo.requirements["arch"] = arch
+		return nil
+	}
+}
+
+// OptBuildRequirement adds a requirement that the builder must satisfy, such as the availability
+// of a GPU, or a node label. The supported keys and values depend on the Build Service. If the same
+// key is specified more than once, the last value takes effect.
+func OptBuildRequirement(key, value string) BuildOption {
+	return func(bo *buildOptions) error {
+		bo.requirements[key] = value
 		return nil
 	}
 }
@@ -99,14 +245,32 @@ func OptBuildWorkingDirectory(dir string) BuildOption {
 	}
 }
 
+// OptBuildOutput instructs BuildToWriter to stream build output to w. It has no effect on Submit.
+func OptBuildOutput(w io.Writer) BuildOption {
+	return func(bo *buildOptions) error {
+		bo.output = w
+		return nil
+	}
+}
+
+// ErrEmptyDefinition is returned when a definition to be submitted contains only whitespace.
+var ErrEmptyDefinition = errors.New("definition is empty")
+
 // Submit sends a build job to the Build Service. The context controls the lifetime of the request.
 //
+// The definition is read in full before any request is made, and a definition that contains only
+// whitespace is rejected with an error wrapping ErrEmptyDefinition. This detects a reader that was
+// consumed by an earlier attempt, such as an *os.File whose offset is at the end of the file. Where
+// the request is repeated, such as following a redirect or a token refresh, the definition read is
+// sent again, rather than being re-read from the reader.
+//
 // By default, the built image will be pushed to an ephemeral location in the Library associated
 // with the Remote Builder. To publish to a non-ephemeral location, consider using
 // OptBuildLibraryRef.
 //
 // By default, the image will be built for the architecture returned by runtime.GOARCH. To override
-// this behaviour, consider using OptBuildArchitecture.
+// this behaviour, consider using OptBuildArchitecture. To place additional requirements on the
+// builder, consider using OptBuildRequirement.
 //
 // By default, if definition involves pulling one or more images from a Library reference that does
 // not contain a hostname, they will be pulled from the Library associated with the Remote Builder.
@@ -119,10 +283,18 @@ func OptBuildWorkingDirectory(dir string) BuildOption {
 // may include paths that are relative to it. By default, the client attempts to derive the current
 // working directory using os.Getwd(), falling back to "/" on error. To override this behaviour,
 // consider using OptBuildWorkingDirectory.
-func (c *Client) Submit(ctx context.Context, definition io.Reader, opts ...BuildOption) (*BuildInfo, error) {
+func (c *Client) Submit(ctx context.Context, definition io.Reader, opts ...BuildOption) (bi *BuildInfo, err error) {
+	ctx, span := c.startSpan(ctx, "Submit")
+	defer func() {
+		if bi != nil {
+			span.SetAttribute(AttributeBuildID, bi.ID())
+		}
+		span.End(err)
+	}()
+
 	bo := buildOptions{
-		arch:       runtime.GOARCH,
-		workingDir: "/",
+		requirements: map[string]string{"arch": runtime.GOARCH},
+		workingDir:   "/",
 	}
 
 	if dir, err := os.Getwd(); err == nil {
@@ -135,11 +307,19 @@ func (c *Client) Submit(ctx context.Context, definition io.Reader, opts ...Build
 		}
 	}
 
+	if bo.contextDigest != "" {
+		span.SetAttribute(AttributeContextDigest, bo.contextDigest)
+	}
+
 	raw, err := io.ReadAll(definition)
 	if err != nil {
 		return nil, fmt.Errorf("%w", err)
 	}
 
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return nil, fmt.Errorf("%w", ErrEmptyDefinition)
+	}
+
 	v := struct {
 		DefinitionRaw       []byte            `json:"definitionRaw"`
 		LibraryRef          string            `json:"libraryRef"`
@@ -155,10 +335,8 @@ func (c *Client) Submit(ctx context.Context, definition io.Reader, opts ...Build
 		WorkingDir:    bo.workingDir,
 	}
 
-	if bo.arch != "" {
-		v.BuilderRequirements = map[string]string{
-			"arch": bo.arch,
-		}
+	if len(bo.requirements) > 0 {
+		v.BuilderRequirements = bo.requirements
 	}
 
 	b, err := json.Marshal(v)
@@ -176,14 +354,15 @@ func (c *Client) Submit(ctx context.Context, definition io.Reader, opts ...Build
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	res, err := c.httpClient.Do(req)
+	// The request body is a bytes.Reader, so the request may be repeated using req.GetBody.
+	res, err := c.doWithRefresh(c.httpClient, req)
 	if err != nil {
 		return nil, fmt.Errorf("%w", err)
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode/100 != 2 { // non-2xx status code
-		return nil, fmt.Errorf("%w", errorFromResponse(res))
+		return nil, fmt.Errorf("%w", c.errorFromResponse(res))
 	}
 
 	var rbi rawBuildInfo
@@ -212,7 +391,7 @@ func (c *Client) Cancel(ctx context.Context, buildID string) error {
 	defer res.Body.Close()
 
 	if res.StatusCode/100 != 2 { // non-2xx status code
-		return fmt.Errorf("%w", errorFromResponse(res))
+		return fmt.Errorf("%w", c.errorFromResponse(res))
 	}
 
 	return nil
@@ -18,18 +18,63 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	jsonresp "github.com/sylabs/json-resp"
 )
 
-// writeArchive writes a compressed archive containing paths read from fsys to w.
+// Compression specifies the compression algorithm applied to a build context archive.
+type Compression string
+
+const (
+	// CompressionGzip compresses build context archives using gzip.
+	CompressionGzip Compression = "gzip"
+	// CompressionZstd compresses build context archives using Zstandard, which is typically faster
+	// and produces smaller archives than gzip.
+	CompressionZstd Compression = "zstd"
+)
+
+var errUnsupportedCompression = errors.New("unsupported compression")
+
+// contentType returns the content type of an archive compressed using c.
+func (c Compression) contentType() string {
+	if c == CompressionZstd {
+		return "application/zstd"
+	}
+	return "application/octet-stream"
+}
+
+// newCompressor returns a WriteCloser that writes data compressed using c to w.
+//
+// The gzip header is left zero-valued, so that it does not record a name or modification time.
+func newCompressor(w io.Writer, c Compression) (io.WriteCloser, error) {
+	switch c {
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedDefault))
+	default:
+		return nil, fmt.Errorf("%w: %v", errUnsupportedCompression, c)
+	}
+}
+
+// writeArchive writes an archive containing paths read from fsys to w, compressed using c.
 //
 // Paths must be specified in the rootless format specified by the io/fs package. If a path
 // contains a glob, it will be evaluated as per fs.Glob. If a path specifies a directory, its
 // contents will be walked as per fs.WalkDir.
-func writeArchive(w io.Writer, fsys fs.FS, paths []string) error {
-	gw := gzip.NewWriter(w)
-	defer gw.Close()
+func writeArchive(w io.Writer, fsys fs.FS, paths []string, c Compression, opts ...archiverOption) error {
+	cw, err := newCompressor(w, c)
+	if err != nil {
+		return err
+	}
+	defer cw.Close()
 
-	ar := newArchiver(fsys, gw)
+	ar := newArchiver(fsys, cw, opts...)
 	defer ar.Close()
 
 	for _, path := range paths {
@@ -38,26 +83,53 @@ func writeArchive(w io.Writer, fsys fs.FS, paths []string) error {
 		}
 	}
 
-	return nil
+	if err := ar.Close(); err != nil {
+		return err
+	}
+
+	return cw.Close()
 }
 
-var errContextAlreadyPresent = errors.New("build context already present")
+var (
+	errContextAlreadyPresent   = errors.New("build context already present")
+	errMalformedUploadResponse = errors.New("malformed build context upload response")
+)
+
+// uploadLocation describes where a build context is to be uploaded.
+type uploadLocation struct {
+	url       *url.URL         // Location to which the build context is uploaded in full.
+	multipart *multipartUpload // Set if the Build Service accepts the build context in parts.
+}
 
 // getBuildContextUploadLocation obtains an upload location for a build context.
 //
 // If errContextAlreadyPresent is returned, (re)upload of build context is not required.
-func (c *Client) getBuildContextUploadLocation(ctx context.Context, size int64, digest string) (*url.URL, error) {
+//
+// If partSize is greater than zero and less than size, a multipart upload is requested. Where the
+// Build Service does not advertise one in its response, the build context is uploaded in full.
+//
+// The compression algorithm is only included in the request when it is not gzip, for compatibility
+// with servers that predate support for other algorithms.
+func (c *Client) getBuildContextUploadLocation(ctx context.Context, size int64, digest string, comp Compression, partSize int64) (*uploadLocation, error) {
 	ref := &url.URL{
 		Path: "v1/build-context",
 	}
 
 	body := struct {
-		Size   int64  `json:"size"`
-		Digest string `json:"digest"`
+		Size        int64       `json:"size"`
+		Digest      string      `json:"digest"`
+		Compression Compression `json:"compression,omitempty"`
+		PartSize    int64       `json:"partSize,omitempty"`
 	}{
 		Size:   size,
 		Digest: digest,
 	}
+	if comp != CompressionGzip {
+		body.Compression = comp
+	}
+	if partSize > 0 && size > partSize {
+		body.PartSize = partSize
+	}
 
 	b, err := json.Marshal(body)
 	if err != nil {
@@ -70,14 +142,29 @@ func (c *Client) getBuildContextUploadLocation(ctx context.Context, size int64,
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	res, err := c.buildContextHTTPClient.Do(req)
+	res, err := c.doWithRefresh(c.buildContextHTTPClient, req)
 	if err != nil {
 		return nil, fmt.Errorf("%w", err)
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode/100 != 2 { // non-2xx status code
-		return nil, fmt.Errorf("%w", errorFromResponse(res))
+		return nil, fmt.Errorf("%w", c.errorFromResponse(res))
+	}
+
+	// A multipart upload is advertised in the response body.
+	if body.PartSize > 0 && strings.HasPrefix(res.Header.Get("Content-Type"), "application/json") {
+		var mu multipartUpload
+		if err := jsonresp.ReadResponse(res.Body, &mu); err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+
+		if mu.ID != "" {
+			if err := mu.validate(size); err != nil {
+				return nil, err
+			}
+			return &uploadLocation{multipart: &mu}, nil
+		}
 	}
 
 	if res.Header.Get("Location") == "" {
@@ -85,47 +172,164 @@ func (c *Client) getBuildContextUploadLocation(ctx context.Context, size int64,
 		return nil, errContextAlreadyPresent
 	}
 
-	return url.Parse(res.Header.Get("Location"))
+	loc, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	return &uploadLocation{url: loc}, nil
 }
 
-// putBuildContext uploads the build context read from r to the specified location.
-func (c *Client) putBuildContext(ctx context.Context, loc *url.URL, r io.Reader, size int64) error {
+// putBuildContext uploads the build context, or part thereof, read from r to the specified
+// location, and returns the entity tag reported for it, if any. If size is -1, the size is
+// unknown, and the build context is uploaded using chunked transfer encoding.
+func (c *Client) putBuildContext(ctx context.Context, loc *url.URL, r io.Reader, size int64, uo uploadBuildContextOptions) (etag string, err error) {
+	if uo.sent != nil {
+		r = newCountingReader(r, uo.sent)
+	}
+
 	req, err := c.newRequest(ctx, http.MethodPut, loc, r)
 	if err != nil {
-		return err
+		return "", err
 	}
-	req.Header.Set("Content-Type", "application/octet-stream")
+
+	// The location is pre-signed, and may be served by a third party, so credentials are not sent.
 	req.Header.Del("Authorization")
+	if !c.presignedHeaders {
+		for k := range c.headers {
+			req.Header.Del(k)
+		}
+	}
+	req.Header.Set("Content-Type", uo.compression.contentType())
 
 	req.ContentLength = size
 
 	res, err := c.buildContextHTTPClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return "", fmt.Errorf("%w", err)
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode/100 != 2 {
-		return fmt.Errorf("%w", errorFromResponse(res))
+		return "", fmt.Errorf("%w", c.errorFromResponse(res))
+	}
+	return res.Header.Get("ETag"), nil
+}
+
+const (
+	// defaultUploadAttempts is the number of attempts made to upload a build context archive
+	// before failing. See OptUploadAttempts.
+	defaultUploadAttempts = 3
+
+	// defaultUploadBackoff is the delay before the second attempt to upload a build context
+	// archive, which doubles with each subsequent attempt.
+	defaultUploadBackoff = time.Second
+)
+
+// retryableUploadError returns true if err, returned by putBuildContext, describes a failure that
+// may be transient, such as a connection reset or a server error.
+func retryableUploadError(err error) bool {
+	var he *httpError
+	if errors.As(err, &he) {
+		return he.Code == http.StatusRequestTimeout || he.Code == http.StatusTooManyRequests || he.Code/100 == 5
+	}
+
+	var ue *url.Error
+	return errors.As(err, &ue)
+}
+
+// uploadRetryWait returns the period to wait before retrying an upload that failed with err. The
+// delay requested by the server, if any, takes precedence over backoff, up to c.rateLimitMaxWait.
+// If the upload was rejected as rate limited, c.rateLimitFunc is notified of the wait.
+func (c *Client) uploadRetryWait(err error, backoff time.Duration) time.Duration {
+	wait := backoff
+	if d, ok := RetryAfter(err); ok {
+		wait = min(d, c.rateLimitMaxWait)
+	}
+
+	if c.rateLimitFunc != nil && errors.Is(err, &httpError{Code: http.StatusTooManyRequests}) {
+		c.rateLimitFunc(wait)
+	}
+	return wait
+}
+
+// putBuildContextRetry uploads the archive in rs, of the specified size and digest, to loc, as
+// per putBuildContext. The archive is re-read from the start on each attempt, so that a transient
+// failure, such as a connection reset partway through the upload, does not require the archive to
+// be regenerated. Up to uo.attempts attempts are made, with exponential backoff between them, or
+// the delay requested by the server, if any.
+//
+// Pre-signed upload locations expire, so if the location is rejected as unauthorized or
+// forbidden, a fresh location is obtained before retrying. If the upload is rejected as
+// conflicting, an earlier attempt may have completed despite the failure being reported, so the
+// Build Service is asked again whether upload is required.
+func (c *Client) putBuildContextRetry(ctx context.Context, loc *url.URL, rs io.ReadSeeker, size int64, digest string, uo uploadBuildContextOptions) error {
+	backoff := uo.backoff
+
+	for attempt := 1; ; attempt++ {
+		if _, err := rs.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to seek: %w", err)
+		}
+
+		// The HTTP client closes request bodies that implement io.Closer, so prevent it from closing
+		// the archive before subsequent attempts.
+		_, err := c.putBuildContext(ctx, loc, io.NopCloser(rs), size, uo)
+		if err == nil {
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return err
+		}
+
+		relocate := errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrForbidden) || errors.Is(err, &httpError{Code: http.StatusConflict})
+		if !relocate && !retryableUploadError(err) {
+			return err
+		}
+
+		if relocate {
+			ul, lerr := c.getBuildContextUploadLocation(ctx, size, digest, uo.compression, 0)
+			if errors.Is(lerr, errContextAlreadyPresent) {
+				return nil
+			}
+			if lerr != nil {
+				return fmt.Errorf("%w (failed to get new upload location: %w)", err, lerr)
+			}
+			loc = ul.url
+		}
+
+		if attempt >= uo.attempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(c.uploadRetryWait(err, backoff)):
+		}
+		backoff *= 2
 	}
-	return nil
 }
 
-// uploadBuildContext generates an archive in rw containing the files at the specified paths in
-// fsys, and uploads it to the Build Service.
+// uploadBuildContext generates an archive in f containing the files at the specified paths in
+// uo.fsys, and uploads it to the Build Service. Where the archive is larger than uo.partSize, and
+// the Build Service supports it, the archive is uploaded in parts.
 //
 // Paths must be specified in the rootless format specified by the io/fs package. If a path
 // contains a glob, it will be evaluated as per fs.Glob. If a path specifies a directory, its
 // contents will be walked as per fs.WalkDir.
-func (c *Client) uploadBuildContext(ctx context.Context, rw io.ReadWriteSeeker, fsys fs.FS, paths []string) (digest string, err error) {
+func (c *Client) uploadBuildContext(ctx context.Context, f *os.File, paths []string, uo uploadBuildContextOptions) (digest string, err error) {
 	// Write a compressed archive and accumulate its digest.
 	h := sha256.New()
-	if err := writeArchive(io.MultiWriter(rw, h), fsys, paths); err != nil {
+	opts := []archiverOption{
+		optArchiveReproducible(uo.reproducible),
+		optArchivePreserveSymlinks(uo.preserveSymlinks),
+	}
+	if err := writeArchive(io.MultiWriter(f, h), uo.fsys, paths, uo.compression, opts...); err != nil {
 		return "", fmt.Errorf("failed to write archive: %w", err)
 	}
 
 	// Obtain size of build context.
-	size, err := rw.Seek(0, io.SeekCurrent)
+	size, err := f.Seek(0, io.SeekCurrent)
 	if err != nil {
 		return "", fmt.Errorf("failed to seek: %w", err)
 	}
@@ -134,7 +338,7 @@ func (c *Client) uploadBuildContext(ctx context.Context, rw io.ReadWriteSeeker,
 	digest = fmt.Sprintf("sha256.%x", h.Sum(nil))
 
 	// Get the build context upload location.
-	loc, err := c.getBuildContextUploadLocation(ctx, size, digest)
+	ul, err := c.getBuildContextUploadLocation(ctx, size, digest, uo.compression, uo.partSize)
 	if err != nil {
 		if errors.Is(err, errContextAlreadyPresent) {
 			return digest, nil
@@ -142,21 +346,239 @@ func (c *Client) uploadBuildContext(ctx context.Context, rw io.ReadWriteSeeker,
 		return "", fmt.Errorf("failed to get build context upload location: %w", err)
 	}
 
-	// Seek to the beginning of the build context file.
-	if _, err := rw.Seek(0, io.SeekStart); err != nil {
-		return "", fmt.Errorf("failed to seek: %w", err)
+	// Upload build context, in parts if the Build Service supports it, retrying transient failures.
+	if ul.multipart != nil {
+		err = c.putBuildContextMultipart(ctx, ul.multipart, f, size, digest, uo)
+	} else {
+		err = c.putBuildContextRetry(ctx, ul.url, f, size, digest, uo)
 	}
-
-	// Upload build context.
-	if err := c.putBuildContext(ctx, loc, rw, size); err != nil {
+	if err != nil {
 		return "", fmt.Errorf("failed to upload build context: %w", err)
 	}
 
 	return digest, nil
 }
 
+// getStreamedBuildContextUpload obtains an upload ID and location for a build context that is
+// streamed to the Build Service. Since the size and digest of the build context are not known in
+// advance, they are supplied once the upload is complete, using finalizeBuildContextUpload.
+func (c *Client) getStreamedBuildContextUpload(ctx context.Context, comp Compression) (string, *url.URL, error) {
+	ref := &url.URL{
+		Path: "v1/build-context",
+	}
+
+	body := struct {
+		Streamed    bool        `json:"streamed"`
+		Compression Compression `json:"compression,omitempty"`
+	}{
+		Streamed: true,
+	}
+	if comp != CompressionGzip {
+		body.Compression = comp
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, ref, bytes.NewReader(b))
+	if err != nil {
+		return "", nil, fmt.Errorf("%w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := c.doWithRefresh(c.buildContextHTTPClient, req)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 { // non-2xx status code
+		return "", nil, fmt.Errorf("%w", c.errorFromResponse(res))
+	}
+
+	var upload struct {
+		ID string `json:"id"`
+	}
+	if err := jsonresp.ReadResponse(res.Body, &upload); err != nil {
+		return "", nil, fmt.Errorf("%w", err)
+	}
+
+	if upload.ID == "" || res.Header.Get("Location") == "" {
+		return "", nil, errMalformedUploadResponse
+	}
+
+	loc, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		return "", nil, fmt.Errorf("%w", err)
+	}
+	return upload.ID, loc, nil
+}
+
+// finalizeBuildContextUpload notifies the Build Service that the streamed upload with the specified
+// ID is complete, supplying the size and digest of the build context. The Build Service verifies
+// the uploaded build context against them.
+func (c *Client) finalizeBuildContextUpload(ctx context.Context, id string, size int64, digest string) error {
+	ref := &url.URL{
+		Path: "v1/build-context/uploads/" + id + "/_finalize",
+	}
+
+	b, err := json.Marshal(struct {
+		Size   int64  `json:"size"`
+		Digest string `json:"digest"`
+	}{size, digest})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPut, ref, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := c.doWithRefresh(c.buildContextHTTPClient, req)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 { // non-2xx status code
+		return fmt.Errorf("%w", c.errorFromResponse(res))
+	}
+	return nil
+}
+
+// countingWriter is an io.Writer that counts the bytes written to it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// countingReader is an io.Reader that adds the bytes read from it to n.
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+// newCountingReader returns an io.Reader that reads from r, adding the bytes read to n. If r
+// implements io.Closer, so does the returned reader, so that the HTTP client closes r as it would
+// otherwise.
+func newCountingReader(r io.Reader, n *atomic.Int64) io.Reader {
+	cr := &countingReader{r: r, n: n}
+	if c, ok := r.(io.Closer); ok {
+		return struct {
+			io.Reader
+			io.Closer
+		}{cr, c}
+	}
+	return cr
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n.Add(int64(n))
+	return n, err
+}
+
+// streamBuildContext generates an archive containing the files at the specified paths in uo.fsys,
+// and streams it to the Build Service as it is generated, without writing it to a temporary file.
+//
+// Paths must be specified in the rootless format specified by the io/fs package. If a path
+// contains a glob, it will be evaluated as per fs.Glob. If a path specifies a directory, its
+// contents will be walked as per fs.WalkDir.
+func (c *Client) streamBuildContext(ctx context.Context, paths []string, uo uploadBuildContextOptions) (digest string, err error) {
+	id, loc, err := c.getStreamedBuildContextUpload(ctx, uo.compression)
+	if err != nil {
+		return "", fmt.Errorf("failed to get build context upload location: %w", err)
+	}
+
+	pr, pw := io.Pipe()
+
+	// Write a compressed archive to the pipe, accumulating its size and digest.
+	h := sha256.New()
+	cw := &countingWriter{w: io.MultiWriter(pw, h)}
+	opts := []archiverOption{
+		optArchiveReproducible(uo.reproducible),
+		optArchivePreserveSymlinks(uo.preserveSymlinks),
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		err := writeArchive(cw, uo.fsys, paths, uo.compression, opts...)
+		pw.CloseWithError(err)
+		errc <- err
+	}()
+
+	// Upload the archive as it is written. The size is not known, so chunked encoding is used.
+	_, putErr := c.putBuildContext(ctx, loc, pr, -1, uo)
+
+	// If the upload ended early, unblock the archive writer.
+	pr.Close()
+
+	archiveErr := <-errc
+	if archiveErr != nil && !errors.Is(archiveErr, io.ErrClosedPipe) {
+		return "", fmt.Errorf("failed to write archive: %w", archiveErr)
+	}
+	if putErr != nil {
+		return "", fmt.Errorf("failed to upload build context: %w", putErr)
+	}
+	if archiveErr != nil {
+		return "", fmt.Errorf("failed to upload build context: %w", archiveErr)
+	}
+
+	digest = fmt.Sprintf("sha256.%x", h.Sum(nil))
+
+	if err := c.finalizeBuildContextUpload(ctx, id, cw.n, digest); err != nil {
+		return "", fmt.Errorf("failed to finalize build context upload: %w", err)
+	}
+
+	return digest, nil
+}
+
+// rootFS is the file system rooted at "/", with support for reading symbolic links.
+type rootFS struct {
+	fs.FS
+}
+
+func newRootFS() rootFS {
+	return rootFS{os.DirFS("/")}
+}
+
+// ReadLink returns the destination of the named symbolic link.
+func (rootFS) ReadLink(name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return os.Readlink("/" + name)
+}
+
+// Lstat returns a FileInfo describing the named file, without following a final symbolic link.
+func (rootFS) Lstat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrInvalid}
+	}
+	return os.Lstat("/" + name)
+}
+
 type uploadBuildContextOptions struct {
-	fsys fs.FS
+	fsys             fs.FS
+	reproducible     bool
+	compression      Compression
+	preserveSymlinks bool
+	streaming        bool
+	attempts         int
+	backoff          time.Duration
+	partSize         int64
+	concurrency      int
+	sent             *atomic.Int64
 }
 
 type UploadBuildContextOption func(*uploadBuildContextOptions) error
@@ -170,18 +592,144 @@ func optUploadBuildContextFS(fsys fs.FS) UploadBuildContextOption {
 	}
 }
 
+// OptUploadReproducible specifies whether the build context archive should be reproducible. When
+// set, archive entries are sorted, modification times are set to a fixed epoch, and ownership
+// information is omitted, such that the digest of the build context depends only on the paths,
+// modes and contents of the files it contains. This allows the Build Service to deduplicate
+// uploads of unchanged build contexts across hosts and checkouts.
+func OptUploadReproducible(b bool) UploadBuildContextOption {
+	return func(uo *uploadBuildContextOptions) error {
+		uo.reproducible = b
+		return nil
+	}
+}
+
+// OptUploadCompression sets the compression algorithm applied to the build context archive. The
+// default is CompressionGzip. Other algorithms require support in the Build Service.
+func OptUploadCompression(c Compression) UploadBuildContextOption {
+	return func(uo *uploadBuildContextOptions) error {
+		if c != CompressionGzip && c != CompressionZstd {
+			return fmt.Errorf("%w: %v", errUnsupportedCompression, c)
+		}
+		uo.compression = c
+		return nil
+	}
+}
+
+// OptUploadPreserveSymlinks specifies whether symbolic links are preserved in the build context
+// archive. By default, symbolic links are followed, and the files they refer to are archived in
+// their place. When set, a symbolic link that resolves to a path within the directory being
+// archived is written as a link. Links that escape the directory are followed.
+func OptUploadPreserveSymlinks(b bool) UploadBuildContextOption {
+	return func(uo *uploadBuildContextOptions) error {
+		uo.preserveSymlinks = b
+		return nil
+	}
+}
+
+// OptUploadStreaming specifies whether the build context archive is streamed to the Build Service
+// as it is generated. By default, the archive is first written to a temporary file, so that its
+// size and digest can be supplied when requesting an upload location. When set, the archive is
+// uploaded as it is generated using chunked transfer encoding, and its size and digest are supplied
+// once the upload is complete. This avoids writing the archive to disk, and reading it twice.
+//
+// Streamed uploads require support in the Build Service. Since the digest is not known in advance,
+// a build context already present in the Build Service is uploaded again.
+func OptUploadStreaming(b bool) UploadBuildContextOption {
+	return func(uo *uploadBuildContextOptions) error {
+		uo.streaming = b
+		return nil
+	}
+}
+
+var errInvalidAttempts = errors.New("invalid number of attempts")
+
+// OptUploadAttempts sets the number of attempts made to upload the build context archive before
+// failing, where failures may be transient. The default is 3. Streamed uploads (see
+// OptUploadStreaming) cannot be retried, since the archive is not retained.
+func OptUploadAttempts(n int) UploadBuildContextOption {
+	return func(uo *uploadBuildContextOptions) error {
+		if n < 1 {
+			return fmt.Errorf("%w: %v", errInvalidAttempts, n)
+		}
+		uo.attempts = n
+		return nil
+	}
+}
+
+// optUploadBackoff sets the delay before the second attempt to upload the build context archive.
+func optUploadBackoff(d time.Duration) UploadBuildContextOption {
+	return func(uo *uploadBuildContextOptions) error {
+		uo.backoff = d
+		return nil
+	}
+}
+
+var (
+	errInvalidPartSize    = errors.New("invalid part size")
+	errInvalidConcurrency = errors.New("invalid concurrency")
+)
+
+// OptUploadParts sets the size of the parts in which the build context archive is uploaded, and
+// the number of parts uploaded at once. Archives larger than partSize are uploaded in parts where
+// the Build Service supports it, so that a failure affects only the part being uploaded, and an
+// upload that fails partway through can be resumed. The Build Service may choose a different part
+// size. The defaults are 64 MiB and 4, respectively. A partSize of zero disables multipart uploads.
+// Streamed uploads (see OptUploadStreaming) are not uploaded in parts.
+func OptUploadParts(partSize int64, concurrency int) UploadBuildContextOption {
+	return func(uo *uploadBuildContextOptions) error {
+		if partSize < 0 {
+			return fmt.Errorf("%w: %v", errInvalidPartSize, partSize)
+		}
+		if concurrency < 1 {
+			return fmt.Errorf("%w: %v", errInvalidConcurrency, concurrency)
+		}
+		uo.partSize = partSize
+		uo.concurrency = concurrency
+		return nil
+	}
+}
+
+// OptUploadCounter adds the number of bytes of the build context archive sent to the Build
+// Service, or a server to which it is uploaded, to n as the upload proceeds. Bytes sent by
+// attempts that fail are included, so the count may exceed the size of the archive. A build
+// context already present in the Build Service is not uploaded, so nothing is added.
+func OptUploadCounter(n *atomic.Int64) UploadBuildContextOption {
+	return func(uo *uploadBuildContextOptions) error {
+		uo.sent = n
+		return nil
+	}
+}
+
 var errNoPathsSpecified = errors.New("no paths specified for build context")
 
 // UploadBuildContext generates an archive containing the files at the specified paths, and uploads
 // it to the Build Service. When the build context is no longer required, DeleteBuildContext should
 // be called to notify the Build Service.
 //
+// Transient failures to upload the archive are retried, without regenerating it. See
+// OptUploadAttempts. Large archives are uploaded in parts, where the Build Service supports it.
+// See OptUploadParts.
+//
 // Paths must be specified in the rootless format specified by the io/fs package. If a path
 // contains a glob, it will be evaluated as per fs.Glob. If a path specifies a directory, its
 // contents will be walked as per fs.WalkDir.
 func (c *Client) UploadBuildContext(ctx context.Context, paths []string, opts ...UploadBuildContextOption) (digest string, err error) {
+	ctx, span := c.startSpan(ctx, "UploadBuildContext")
+	defer func() {
+		if digest != "" {
+			span.SetAttribute(AttributeContextDigest, digest)
+		}
+		span.End(err)
+	}()
+
 	uo := uploadBuildContextOptions{
-		fsys: os.DirFS("/"),
+		fsys:        newRootFS(),
+		compression: CompressionGzip,
+		attempts:    defaultUploadAttempts,
+		backoff:     defaultUploadBackoff,
+		partSize:    defaultUploadPartSize,
+		concurrency: defaultUploadConcurrency,
 	}
 
 	for _, opt := range opts {
@@ -194,13 +742,54 @@ func (c *Client) UploadBuildContext(ctx context.Context, paths []string, opts ..
 		return "", errNoPathsSpecified
 	}
 
+	if uo.streaming {
+		return c.streamBuildContext(ctx, paths, uo)
+	}
+
 	f, err := os.CreateTemp("", "scs-build-context-*")
 	if err != nil {
 		return "", fmt.Errorf("%w", err)
 	}
 	defer os.Remove(f.Name())
 
-	return c.uploadBuildContext(ctx, f, uo.fsys, paths)
+	return c.uploadBuildContext(ctx, f, paths, uo)
+}
+
+// DigestBuildContext generates an archive containing the files at the specified paths, as per
+// UploadBuildContext, and returns its digest and size without uploading it. Given the same options,
+// the digest is that UploadBuildContext would return, provided the archive is reproducible.
+// OptUploadStreaming has no effect.
+//
+// Paths must be specified in the rootless format specified by the io/fs package. If a path
+// contains a glob, it will be evaluated as per fs.Glob. If a path specifies a directory, its
+// contents will be walked as per fs.WalkDir.
+func DigestBuildContext(paths []string, opts ...UploadBuildContextOption) (digest string, size int64, err error) {
+	uo := uploadBuildContextOptions{
+		fsys:        newRootFS(),
+		compression: CompressionGzip,
+	}
+
+	for _, opt := range opts {
+		if err := opt(&uo); err != nil {
+			return "", 0, fmt.Errorf("%w", err)
+		}
+	}
+
+	if len(paths) == 0 {
+		return "", 0, errNoPathsSpecified
+	}
+
+	h := sha256.New()
+	cw := &countingWriter{w: h}
+	aopts := []archiverOption{
+		optArchiveReproducible(uo.reproducible),
+		optArchivePreserveSymlinks(uo.preserveSymlinks),
+	}
+	if err := writeArchive(cw, uo.fsys, paths, uo.compression, aopts...); err != nil {
+		return "", 0, fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	return fmt.Sprintf("sha256.%x", h.Sum(nil)), cw.n, nil
 }
 
 type deleteBuildContextOptions struct{}
@@ -226,15 +815,134 @@ func (c *Client) DeleteBuildContext(ctx context.Context, digest string, opts ...
 		return fmt.Errorf("%w", err)
 	}
 
-	res, err := c.buildContextHTTPClient.Do(req)
+	res, err := c.doWithRefresh(c.buildContextHTTPClient, req)
 	if err != nil {
 		return fmt.Errorf("%w", err)
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode/100 != 2 { // non-2xx status code
-		return fmt.Errorf("%w", errorFromResponse(res))
+		return fmt.Errorf("%w", c.errorFromResponse(res))
 	}
 
 	return nil
 }
+
+// BuildContextInfo describes a build context stored by the Build Service.
+type BuildContextInfo struct {
+	Digest   string    `json:"digest"`     // Digest of the build context.
+	Size     int64     `json:"size"`       // Size of the build context archive, in bytes.
+	Created  time.Time `json:"createdAt"`  // Time at which the build context was uploaded.
+	LastUsed time.Time `json:"lastUsedAt"` // Time at which the build context was last used, if ever.
+}
+
+type listBuildContextsOptions struct {
+	pageSize       int
+	lastUsedBefore time.Time
+}
+
+type ListBuildContextsOption func(*listBuildContextsOptions) error
+
+var errInvalidPageSize = errors.New("invalid page size")
+
+// OptListBuildContextsPageSize sets the number of build contexts requested from the Build Service
+// in each page of results. By default, the Build Service selects the page size.
+func OptListBuildContextsPageSize(n int) ListBuildContextsOption {
+	return func(lo *listBuildContextsOptions) error {
+		if n <= 0 {
+			return fmt.Errorf("%w: %v", errInvalidPageSize, n)
+		}
+		lo.pageSize = n
+		return nil
+	}
+}
+
+// OptListBuildContextsLastUsedBefore restricts results to build contexts that were last used (or,
+// if never used, created) before t.
+func OptListBuildContextsLastUsedBefore(t time.Time) ListBuildContextsOption {
+	return func(lo *listBuildContextsOptions) error {
+		lo.lastUsedBefore = t
+		return nil
+	}
+}
+
+// ListBuildContexts returns the build contexts owned by the caller. Results are retrieved from the
+// Build Service one page at a time, until all pages have been retrieved.
+//
+// If the Build Service does not support listing build contexts, an error wrapping ErrNotSupported
+// is returned.
+func (c *Client) ListBuildContexts(ctx context.Context, opts ...ListBuildContextsOption) ([]BuildContextInfo, error) {
+	lo := listBuildContextsOptions{}
+
+	for _, opt := range opts {
+		if err := opt(&lo); err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+	}
+
+	var infos []BuildContextInfo
+
+	for pageToken := ""; ; {
+		page, next, err := c.listBuildContextsPage(ctx, lo, pageToken)
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+
+		infos = append(infos, page...)
+
+		if next == "" {
+			return infos, nil
+		}
+		pageToken = next
+	}
+}
+
+// listBuildContextsPage returns the page of build contexts identified by pageToken, along with the
+// token identifying the next page. If there are no further pages, the returned token is empty.
+func (c *Client) listBuildContextsPage(ctx context.Context, lo listBuildContextsOptions, pageToken string) ([]BuildContextInfo, string, error) {
+	q := url.Values{}
+	if lo.pageSize > 0 {
+		q.Set("pageSize", strconv.Itoa(lo.pageSize))
+	}
+	if !lo.lastUsedBefore.IsZero() {
+		q.Set("lastUsedBefore", lo.lastUsedBefore.UTC().Format(time.RFC3339))
+	}
+	if pageToken != "" {
+		q.Set("pageToken", pageToken)
+	}
+
+	ref := &url.URL{
+		Path:     "v1/build-context",
+		RawQuery: q.Encode(),
+	}
+
+	req, err := c.newRequest(ctx, http.MethodGet, ref, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	res, err := c.doWithRefresh(c.buildContextHTTPClient, req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+
+	// Servers that predate listing of build contexts do not route GET requests to this endpoint.
+	if res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusMethodNotAllowed {
+		return nil, "", fmt.Errorf("%w: listing build contexts: %w", ErrNotSupported, c.errorFromResponse(res))
+	}
+
+	if res.StatusCode/100 != 2 { // non-2xx status code
+		return nil, "", c.errorFromResponse(res)
+	}
+
+	var page struct {
+		Contexts      []BuildContextInfo `json:"contexts"`
+		NextPageToken string             `json:"nextPageToken"`
+	}
+	if err := jsonresp.ReadResponse(res.Body, &page); err != nil {
+		return nil, "", err
+	}
+
+	return page.Contexts, page.NextPageToken, nil
+}
@@ -6,6 +6,9 @@
 package client
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/sha256"
 	"encoding/json"
@@ -13,20 +16,29 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"testing/fstest"
+	"time"
 
+	"github.com/klauspost/compress/zstd"
 	jsonresp "github.com/sylabs/json-resp"
 )
 
 type mockUploadBuildContext struct {
-	t      *testing.T
-	code1  int // for "/v1/build-context"
-	code2  int // for "/upload-here"
-	size   int64
-	digest string
+	t           *testing.T
+	code1       int // for "/v1/build-context"
+	code2       int // for "/upload-here"
+	size        int64
+	digest      string
+	compression string
+	body        []byte // Archive received at "/upload-here".
 }
 
 func (m *mockUploadBuildContext) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -48,16 +60,18 @@ func (m *mockUploadBuildContext) ServeHTTP(w http.ResponseWriter, r *http.Reques
 		}
 
 		var body struct {
-			Size   int64  `json:"size"`
-			Digest string `json:"digest"`
+			Size        int64  `json:"size"`
+			Digest      string `json:"digest"`
+			Compression string `json:"compression"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 			m.t.Fatalf("failed to decode request: %v", err)
 		}
 
-		// Record size and digest, so we can check them when the archive is uploaded.
+		// Record size, digest and compression, so we can check them when the archive is uploaded.
 		m.size = body.Size
 		m.digest = body.Digest
+		m.compression = body.Compression
 
 		// Return upload URL to caller.
 		w.Header().Set("Location", "/upload-here")
@@ -74,7 +88,12 @@ func (m *mockUploadBuildContext) ServeHTTP(w http.ResponseWriter, r *http.Reques
 			return
 		}
 
-		if got, want := r.Header.Get("Content-Type"), "application/octet-stream"; got != want {
+		wantContentType := "application/octet-stream"
+		if m.compression == "zstd" {
+			wantContentType = "application/zstd"
+		}
+
+		if got, want := r.Header.Get("Content-Type"), wantContentType; got != want {
 			m.t.Errorf("got content type %v, want %v", got, want)
 		}
 
@@ -83,11 +102,13 @@ func (m *mockUploadBuildContext) ServeHTTP(w http.ResponseWriter, r *http.Reques
 		}
 
 		h := sha256.New()
+		b := bytes.Buffer{}
 
-		n, err := io.Copy(h, r.Body)
+		n, err := io.Copy(io.MultiWriter(h, &b), r.Body)
 		if err != nil {
 			m.t.Fatal(err)
 		}
+		m.body = b.Bytes()
 
 		if got, want := n, m.size; got != want {
 			m.t.Errorf("got size %v, want %v", got, want)
@@ -207,73 +228,1370 @@ func TestClient_UploadBuildContext(t *testing.T) {
 	}
 }
 
-type mockDeleteBuildContext struct {
-	t      *testing.T
-	code   int
-	digest string
+func TestClient_UploadBuildContextHTTPHeader(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a": &fstest.MapFile{Data: []byte("a"), Mode: 0o644, ModTime: testTime},
+	}
+
+	tests := []struct {
+		name          string
+		opts          []Option
+		wantPresigned []string
+	}{
+		{
+			name: "Default",
+			opts: []Option{OptHTTPHeader("X-Org-ID", "org")},
+		},
+		{
+			name:          "Presigned",
+			opts:          []Option{OptHTTPHeader("X-Org-ID", "org"), OptPresignedHTTPHeaders(true)},
+			wantPresigned: []string{"org"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := make(map[string][]string)
+
+			m := &mockUploadBuildContext{t: t, code2: http.StatusCreated}
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received[r.URL.Path] = r.Header.Values("X-Org-ID")
+
+				// Credentials are never sent to the pre-signed location.
+				if r.URL.Path == "/upload-here" && r.Header.Get("Authorization") != "" {
+					t.Error("authorization sent to pre-signed location")
+				}
+
+				m.ServeHTTP(w, r)
+			}))
+			t.Cleanup(s.Close)
+
+			c, err := NewClient(append([]Option{OptBaseURL(s.URL), OptBearerToken("token")}, tt.opts...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := c.UploadBuildContext(context.Background(), []string{"a"}, optUploadBuildContextFS(fsys)); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := received["/v1/build-context"], []string{"org"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("got build context headers %v, want %v", got, want)
+			}
+
+			if got, want := received["/upload-here"], tt.wantPresigned; !reflect.DeepEqual(got, want) {
+				t.Errorf("got pre-signed headers %v, want %v", got, want)
+			}
+		})
+	}
 }
 
-func (m *mockDeleteBuildContext) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if m.code != 0 {
-		if err := jsonresp.WriteError(w, "", m.code); err != nil {
-			m.t.Fatalf("failed to write error: %v", err)
+// slowReader is an io.Reader that delays each read, and reads at most 16 bytes at a time.
+type slowReader struct {
+	r     io.Reader
+	delay time.Duration
+}
+
+func (r slowReader) Read(p []byte) (int, error) {
+	time.Sleep(r.delay)
+	if len(p) > 16 {
+		p = p[:16]
+	}
+	return r.r.Read(p)
+}
+
+func TestClient_UploadBuildContextSlow(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a": &fstest.MapFile{
+			Data:    bytes.Repeat([]byte("a"), 64<<10),
+			Mode:    0o644,
+			ModTime: testTime,
+		},
+	}
+
+	m := &mockUploadBuildContext{t: t}
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Simulate a slow link by delaying reads of the uploaded build context.
+		if r.URL.Path == "/upload-here" {
+			r.Body = io.NopCloser(slowReader{r.Body, 10 * time.Millisecond})
 		}
-		return
+		m.ServeHTTP(w, r)
+	}))
+	t.Cleanup(s.Close)
+
+	// The upload takes considerably longer than the HTTP timeout, but must not be cut off, since
+	// build context transfers are not subject to it.
+	c, err := NewClient(OptBaseURL(s.URL), OptHTTPTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	if got, want := r.Method, http.MethodDelete; got != want {
-		m.t.Errorf("got method %v, want %v", got, want)
+	start := time.Now()
+
+	if _, err := c.UploadBuildContext(context.Background(), []string{"a"}, optUploadBuildContextFS(fsys)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
 	}
 
-	if got, want := r.URL.Path, fmt.Sprintf("/v1/build-context/%v", m.digest); got != want {
-		m.t.Errorf("got path %v, want %v", got, want)
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Fatalf("upload completed in %v, expected slow upload", d)
 	}
+}
 
-	w.WriteHeader(http.StatusOK)
+func TestClient_UploadBuildContextReproducible(t *testing.T) {
+	newFS := func(modTime time.Time) fs.FS {
+		return fstest.MapFS{
+			"a": &fstest.MapFile{
+				Mode:    0o755 | fs.ModeDir,
+				ModTime: modTime,
+			},
+			"a/b": &fstest.MapFile{
+				Data:    []byte("hello"),
+				Mode:    0o644,
+				ModTime: modTime,
+			},
+			"c": &fstest.MapFile{
+				Data:    []byte("goodbye"),
+				Mode:    0o644,
+				ModTime: modTime,
+			},
+		}
+	}
+
+	tests := []struct {
+		name         string
+		reproducible bool
+		wantSame     bool
+	}{
+		{"Reproducible", true, true},
+		{"NotReproducible", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := httptest.NewServer(&mockUploadBuildContext{
+				t:     t,
+				code2: http.StatusCreated,
+			})
+			t.Cleanup(s.Close)
+
+			c, err := NewClient(OptBaseURL(s.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			digest1, err := c.UploadBuildContext(context.Background(), []string{"a", "c"},
+				optUploadBuildContextFS(newFS(testTime)),
+				OptUploadReproducible(tt.reproducible),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			digest2, err := c.UploadBuildContext(context.Background(), []string{"c", "a/b"},
+				optUploadBuildContextFS(newFS(testTime.Add(time.Hour))),
+				OptUploadReproducible(tt.reproducible),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := digest1 == digest2, tt.wantSame; got != want {
+				t.Errorf("got digests %v and %v, want same %v", digest1, digest2, want)
+			}
+		})
+	}
 }
 
-func TestClient_DeleteBuildContext(t *testing.T) {
-	t.Parallel()
+func TestClient_UploadBuildContextCompression(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a": &fstest.MapFile{
+			Mode:    0o755 | fs.ModeDir,
+			ModTime: testTime,
+		},
+		"a/b": &fstest.MapFile{
+			Data:    []byte("hello"),
+			Mode:    0o644,
+			ModTime: testTime,
+		},
+	}
 
 	tests := []struct {
-		name    string
-		code    int
-		digest  string
-		wantErr error
+		name            string
+		opts            []UploadBuildContextOption
+		wantCompression string
+		newReader       func(io.Reader) (io.Reader, error)
+		wantErr         error
 	}{
 		{
-			name:   "OK",
-			digest: "sha256.f2ca1bb6c7e907d06dafe4687e579fce76b37e4e93b7605022da52e6ccc26fd2",
+			name: "Default",
+			newReader: func(r io.Reader) (io.Reader, error) {
+				return gzip.NewReader(r)
+			},
 		},
 		{
-			name:    "HTTPError",
-			code:    http.StatusBadRequest,
-			digest:  "sha256.f2ca1bb6c7e907d06dafe4687e579fce76b37e4e93b7605022da52e6ccc26fd2",
-			wantErr: &httpError{Code: http.StatusBadRequest},
+			name: "Gzip",
+			opts: []UploadBuildContextOption{OptUploadCompression(CompressionGzip)},
+			newReader: func(r io.Reader) (io.Reader, error) {
+				return gzip.NewReader(r)
+			},
+		},
+		{
+			name:            "Zstd",
+			opts:            []UploadBuildContextOption{OptUploadCompression(CompressionZstd)},
+			wantCompression: "zstd",
+			newReader: func(r io.Reader) (io.Reader, error) {
+				return zstd.NewReader(r)
+			},
+		},
+		{
+			name:    "Unsupported",
+			opts:    []UploadBuildContextOption{OptUploadCompression("bzip2")},
+			wantErr: errUnsupportedCompression,
 		},
 	}
 	for _, tt := range tests {
-		tt := tt
-
 		t.Run(tt.name, func(t *testing.T) {
-			t.Parallel()
+			m := &mockUploadBuildContext{t: t}
 
-			s := httptest.NewServer(&mockDeleteBuildContext{
-				t:      t,
-				code:   tt.code,
-				digest: tt.digest,
-			})
-			defer s.Close()
+			s := httptest.NewServer(m)
+			t.Cleanup(s.Close)
 
 			c, err := NewClient(OptBaseURL(s.URL))
 			if err != nil {
 				t.Fatal(err)
 			}
 
-			err = c.DeleteBuildContext(context.Background(), tt.digest)
+			opts := append([]UploadBuildContextOption{optUploadBuildContextFS(fsys)}, tt.opts...)
+
+			_, err = c.UploadBuildContext(context.Background(), []string{"a"}, opts...)
 
 			if got, want := err, tt.wantErr; !errors.Is(got, want) {
-				t.Errorf("got error %v, want %v", got, want)
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if got, want := m.compression, tt.wantCompression; got != want {
+				t.Errorf("got compression %q, want %q", got, want)
+			}
+
+			// Decode the uploaded archive, and check its contents.
+			r, err := tt.newReader(bytes.NewReader(m.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			contents := make(map[string]string)
+
+			tr := tar.NewReader(r)
+			for {
+				h, err := tr.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				b, err := io.ReadAll(tr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				contents[h.Name] = string(b)
+			}
+
+			if got, want := contents, map[string]string{"a/": "", "a/b": "hello"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("got contents %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+type mockStreamBuildContext struct {
+	t              *testing.T
+	rejectFinalize bool   // If set, the finalize request is rejected.
+	body           []byte // Archive received at "/upload-here".
+	finalized      bool   // Set when a finalize request is accepted.
+	size           int64  // Size supplied in finalize request.
+	digest         string // Digest supplied in finalize request.
+}
+
+const mockUploadID = "upload-id"
+
+func (m *mockStreamBuildContext) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The general flow is that the client POSTs to /v1/build-context to get an upload URL and ID,
+	// PUTs the archive to the upload URL, and then finalizes the upload with the archive digest.
+	switch r.URL.Path {
+	case "/v1/build-context":
+		var body struct {
+			Streamed bool   `json:"streamed"`
+			Size     int64  `json:"size"`
+			Digest   string `json:"digest"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			m.t.Errorf("failed to decode request: %v", err)
+			return
+		}
+
+		if !body.Streamed || body.Size != 0 || body.Digest != "" {
+			m.t.Errorf("unexpected request %+v", body)
+		}
+
+		w.Header().Set("Location", "/upload-here")
+
+		if err := jsonresp.WriteResponse(w, struct {
+			ID string `json:"id"`
+		}{mockUploadID}, http.StatusAccepted); err != nil {
+			m.t.Error(err)
+		}
+
+	case "/upload-here":
+		if got, want := r.ContentLength, int64(-1); got != want {
+			m.t.Errorf("got content length %v, want %v", got, want)
+		}
+
+		if got, want := r.TransferEncoding, []string{"chunked"}; !reflect.DeepEqual(got, want) {
+			m.t.Errorf("got transfer encoding %v, want %v", got, want)
+		}
+
+		// The client abandons the upload if it fails to generate the archive, in which case the
+		// body is truncated.
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		m.body = b
+
+		w.WriteHeader(http.StatusCreated)
+
+	case "/v1/build-context/uploads/" + mockUploadID + "/_finalize":
+		if got, want := r.Method, http.MethodPut; got != want {
+			m.t.Errorf("got method %v, want %v", got, want)
+		}
+
+		var body struct {
+			Size   int64  `json:"size"`
+			Digest string `json:"digest"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			m.t.Errorf("failed to decode request: %v", err)
+			return
+		}
+		m.size = body.Size
+		m.digest = body.Digest
+
+		if m.rejectFinalize {
+			if err := jsonresp.WriteError(w, "digest mismatch", http.StatusUnprocessableEntity); err != nil {
+				m.t.Error(err)
+			}
+			return
+		}
+
+		m.finalized = true
+
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		m.t.Errorf("unexpected path: %v", r.URL.Path)
+	}
+}
+
+// resetConnection is an outcome of mockFlakyUpload, in which the connection is closed partway
+// through the upload.
+const resetConnection = -1
+
+// mockFlakyUpload implements the build context upload flow, failing uploads as scripted. Each
+// upload location it issues is distinct.
+type mockFlakyUpload struct {
+	t        *testing.T
+	outcomes []int // Outcome of successive uploads: a status code, or resetConnection. Those beyond succeed.
+
+	posts    int    // Number of upload location requests.
+	puts     int    // Number of uploads.
+	size     int64  // Size of build context, as requested.
+	digest   string // Digest of build context, as requested.
+	stored   bool   // A build context was received in full, so upload is no longer required.
+	location string // Most recently issued upload location.
+}
+
+func (m *mockFlakyUpload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/v1/build-context" {
+		m.posts++
+
+		if m.stored {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		var body struct {
+			Size   int64  `json:"size"`
+			Digest string `json:"digest"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			m.t.Fatalf("failed to decode request: %v", err)
+		}
+		m.size, m.digest = body.Size, body.Digest
+
+		m.location = fmt.Sprintf("/upload-%v", m.posts)
+		w.Header().Set("Location", m.location)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	if got, want := r.URL.Path, m.location; got != want {
+		m.t.Errorf("got upload to %v, want %v", got, want)
+	}
+
+	m.puts++
+
+	outcome := 0
+	if m.puts <= len(m.outcomes) {
+		outcome = m.outcomes[m.puts-1]
+	}
+
+	switch outcome {
+	case resetConnection:
+		if _, err := io.CopyN(io.Discard, r.Body, m.size/2); err != nil {
+			m.t.Fatal(err)
+		}
+
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			m.t.Fatal(err)
+		}
+		conn.Close()
+
+	case 0, http.StatusConflict:
+		// The upload is received in full, though a conflict may be reported.
+		h := sha256.New()
+		n, err := io.Copy(h, r.Body)
+		if err != nil {
+			m.t.Fatal(err)
+		}
+
+		if got, want := n, m.size; got != want {
+			m.t.Errorf("got size %v, want %v", got, want)
+		}
+		if got, want := fmt.Sprintf("sha256.%x", h.Sum(nil)), m.digest; got != want {
+			m.t.Errorf("got digest %v, want %v", got, want)
+		}
+		m.stored = true
+
+		if outcome == 0 {
+			outcome = http.StatusCreated
+		}
+		w.WriteHeader(outcome)
+
+	default:
+		w.WriteHeader(outcome)
+	}
+}
+
+func TestClient_UploadBuildContextRetry(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a": &fstest.MapFile{
+			Data:    bytes.Repeat([]byte("hello"), 64<<10),
+			Mode:    0o644,
+			ModTime: testTime,
+		},
+	}
+
+	tests := []struct {
+		name      string
+		outcomes  []int
+		opts      []UploadBuildContextOption
+		wantPosts int
+		wantPuts  int
+		wantErr   error
+	}{
+		{
+			name:      "Success",
+			wantPosts: 1,
+			wantPuts:  1,
+		},
+		{
+			name:      "ConnectionReset",
+			outcomes:  []int{resetConnection},
+			wantPosts: 1,
+			wantPuts:  2,
+		},
+		{
+			name:      "ServerError",
+			outcomes:  []int{http.StatusServiceUnavailable, http.StatusBadGateway},
+			wantPosts: 1,
+			wantPuts:  3,
+		},
+		{
+			name:      "LocationExpired",
+			outcomes:  []int{http.StatusForbidden},
+			wantPosts: 2,
+			wantPuts:  2,
+		},
+		{
+			name:      "ConflictAfterUpload",
+			outcomes:  []int{http.StatusConflict},
+			wantPosts: 2,
+			wantPuts:  1,
+		},
+		{
+			name:      "Exhausted",
+			outcomes:  []int{resetConnection, http.StatusServiceUnavailable},
+			opts:      []UploadBuildContextOption{OptUploadAttempts(2)},
+			wantPosts: 1,
+			wantPuts:  2,
+			wantErr:   &httpError{Code: http.StatusServiceUnavailable},
+		},
+		{
+			name:      "NotRetryable",
+			outcomes:  []int{http.StatusBadRequest},
+			wantPosts: 1,
+			wantPuts:  1,
+			wantErr:   &httpError{Code: http.StatusBadRequest},
+		},
+		{
+			name:    "InvalidAttempts",
+			opts:    []UploadBuildContextOption{OptUploadAttempts(0)},
+			wantErr: errInvalidAttempts,
+		},
+	}
+
+	wantDigest, _, err := DigestBuildContext([]string{"a"}, optUploadBuildContextFS(fsys))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mockFlakyUpload{t: t, outcomes: tt.outcomes}
+
+			s := httptest.NewServer(m)
+			t.Cleanup(s.Close)
+
+			c, err := NewClient(OptBaseURL(s.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			opts := append([]UploadBuildContextOption{optUploadBuildContextFS(fsys), optUploadBackoff(time.Millisecond)}, tt.opts...)
+
+			digest, err := c.UploadBuildContext(context.Background(), []string{"a"}, opts...)
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if err == nil {
+				if got, want := digest, wantDigest; got != want {
+					t.Errorf("got digest %v, want %v", got, want)
+				}
+				if got, want := m.digest, wantDigest; got != want {
+					t.Errorf("got requested digest %v, want %v", got, want)
+				}
+			}
+
+			if got, want := m.posts, tt.wantPosts; got != want {
+				t.Errorf("got %v upload location requests, want %v", got, want)
+			}
+			if got, want := m.puts, tt.wantPuts; got != want {
+				t.Errorf("got %v uploads, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestClient_UploadBuildContextCounter(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a": &fstest.MapFile{
+			Data:    bytes.Repeat([]byte("hello"), 64<<10),
+			Mode:    0o644,
+			ModTime: testTime,
+		},
+	}
+
+	_, size, err := DigestBuildContext([]string{"a"}, optUploadBuildContextFS(fsys))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		outcomes []int
+		stored   bool
+		want     int64
+	}{
+		{"Success", nil, false, size},
+		{"ConflictAfterUpload", []int{http.StatusConflict}, false, size},
+		{"AlreadyPresent", nil, true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mockFlakyUpload{t: t, outcomes: tt.outcomes, stored: tt.stored}
+
+			s := httptest.NewServer(m)
+			t.Cleanup(s.Close)
+
+			c, err := NewClient(OptBaseURL(s.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var n atomic.Int64
+
+			if _, err := c.UploadBuildContext(context.Background(), []string{"a"},
+				optUploadBuildContextFS(fsys),
+				optUploadBackoff(time.Millisecond),
+				OptUploadCounter(&n),
+			); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := n.Load(), tt.want; got != want {
+				t.Errorf("got %v bytes sent, want %v", got, want)
+			}
+		})
+	}
+}
+
+// mockMultipartUpload implements the build context upload flow, advertising a multipart upload
+// when one is requested.
+type mockMultipartUpload struct {
+	t         *testing.T
+	noAdvert  bool          // Do not advertise multipart uploads, so the archive is uploaded in full.
+	partSize  int64         // Part size advertised, regardless of that requested.
+	failures  map[int][]int // Status codes returned by successive uploads of each part.
+	expiredAt int           // Upload location request after which part locations are rejected as expired.
+
+	mu       sync.Mutex
+	posts    int            // Number of upload location requests.
+	puts     map[int]int    // Number of uploads of each part, or of the full archive (part 0).
+	size     int64          // Size of build context, as requested.
+	digest   string         // Digest of build context, as requested.
+	reqPart  int64          // Part size, as requested.
+	parts    map[int][]byte // Parts received.
+	complete bool           // Multipart upload was completed.
+	stored   bool           // Build context was received in full.
+}
+
+// numParts returns the number of parts in the upload.
+func (m *mockMultipartUpload) numParts() int {
+	return int((m.size + m.partSize - 1) / m.partSize)
+}
+
+func (m *mockMultipartUpload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/v1/build-context":
+		m.posts++
+
+		if m.stored {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		var body struct {
+			Size     int64  `json:"size"`
+			Digest   string `json:"digest"`
+			PartSize int64  `json:"partSize"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			m.t.Fatalf("failed to decode request: %v", err)
+		}
+
+		if m.digest != "" && m.digest != body.Digest {
+			m.t.Fatalf("got digest %v, want %v", body.Digest, m.digest)
+		}
+		m.size, m.digest, m.reqPart = body.Size, body.Digest, body.PartSize
+
+		if m.noAdvert || body.PartSize == 0 {
+			w.Header().Set("Location", "/upload-here")
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		// Advertise a multipart upload, reporting parts received by earlier uploads as complete.
+		mu := multipartUpload{ID: "mp1", PartSize: m.partSize}
+		for n := 1; n <= m.numParts(); n++ {
+			p := multipartPart{
+				Number:   n,
+				Location: fmt.Sprintf("/part-%v-%v", m.posts, n),
+			}
+			if _, ok := m.parts[n]; ok {
+				p.Complete, p.ETag = true, fmt.Sprintf(`"etag-%v"`, n)
+			}
+			mu.Parts = append(mu.Parts, p)
+		}
+
+		if err := jsonresp.WriteResponse(w, mu, http.StatusAccepted); err != nil {
+			m.t.Fatal(err)
+		}
+
+	case r.URL.Path == "/upload-here":
+		m.puts[0]++
+
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			m.t.Fatal(err)
+		}
+		m.parts = map[int][]byte{1: b}
+		m.stored = true
+
+		w.WriteHeader(http.StatusCreated)
+
+	case strings.HasPrefix(r.URL.Path, "/part-"):
+		var post, n int
+		if _, err := fmt.Sscanf(r.URL.Path, "/part-%d-%d", &post, &n); err != nil {
+			m.t.Fatal(err)
+		}
+
+		m.puts[n]++
+
+		if m.expiredAt != 0 && post <= m.expiredAt {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if fs := m.failures[n]; len(fs) > 0 {
+			m.failures[n] = fs[1:]
+			w.WriteHeader(fs[0])
+			return
+		}
+
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			m.t.Fatal(err)
+		}
+
+		if got, want := int64(len(b)), min(m.partSize, m.size-int64(n-1)*m.partSize); got != want {
+			m.t.Errorf("part %v: got size %v, want %v", n, got, want)
+		}
+		m.parts[n] = b
+
+		w.Header().Set("ETag", fmt.Sprintf(`"etag-%v"`, n))
+		w.WriteHeader(http.StatusOK)
+
+	case r.URL.Path == "/v1/build-context/uploads/mp1/_complete":
+		var body struct {
+			Parts []struct {
+				Number int    `json:"number"`
+				ETag   string `json:"etag"`
+			} `json:"parts"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			m.t.Fatalf("failed to decode request: %v", err)
+		}
+
+		if got, want := len(body.Parts), m.numParts(); got != want {
+			m.t.Fatalf("got %v parts, want %v", got, want)
+		}
+
+		// Assemble the parts, and verify the result against the size and digest requested.
+		h := sha256.New()
+		var size int64
+		for i, p := range body.Parts {
+			if got, want := p.ETag, fmt.Sprintf(`"etag-%v"`, i+1); got != want {
+				m.t.Errorf("part %v: got etag %v, want %v", i+1, got, want)
+			}
+
+			n, _ := h.Write(m.parts[p.Number])
+			size += int64(n)
+		}
+
+		if got, want := size, m.size; got != want {
+			m.t.Errorf("got size %v, want %v", got, want)
+		}
+		if got, want := fmt.Sprintf("sha256.%x", h.Sum(nil)), m.digest; got != want {
+			m.t.Errorf("got digest %v, want %v", got, want)
+		}
+		m.complete, m.stored = true, true
+
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		m.t.Errorf("unexpected path: %v", r.URL.Path)
+	}
+}
+
+func TestClient_UploadBuildContextMultipart(t *testing.T) {
+	// Random data does not compress, so the archive spans several parts.
+	data := make([]byte, 256<<10)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	fsys := fstest.MapFS{
+		"a": &fstest.MapFile{Data: data, Mode: 0o644, ModTime: testTime},
+	}
+
+	const partSize = 64 << 10
+
+	tests := []struct {
+		name         string
+		noAdvert     bool
+		failures     map[int][]int
+		expiredAt    int
+		opts         []UploadBuildContextOption
+		wantPosts    int
+		wantPuts     map[int]int
+		wantErr      error
+		wantPartSize int64
+	}{
+		{
+			name:         "Multipart",
+			wantPosts:    1,
+			wantPuts:     map[int]int{1: 1, 2: 1, 3: 1, 4: 1, 5: 1},
+			wantPartSize: partSize,
+		},
+		{
+			name:         "NotAdvertised",
+			noAdvert:     true,
+			wantPosts:    1,
+			wantPuts:     map[int]int{0: 1},
+			wantPartSize: partSize,
+		},
+		{
+			name:      "Disabled",
+			opts:      []UploadBuildContextOption{OptUploadParts(0, 1)},
+			wantPosts: 1,
+			wantPuts:  map[int]int{0: 1},
+		},
+		{
+			name:         "PartRetried",
+			failures:     map[int][]int{2: {http.StatusServiceUnavailable, http.StatusBadGateway}},
+			wantPosts:    1,
+			wantPuts:     map[int]int{1: 1, 2: 3, 3: 1, 4: 1, 5: 1},
+			wantPartSize: partSize,
+		},
+		{
+			name:         "LocationsExpired",
+			expiredAt:    1,
+			opts:         []UploadBuildContextOption{OptUploadParts(partSize, 1)},
+			wantPosts:    2,
+			wantPuts:     map[int]int{1: 2, 2: 1, 3: 1, 4: 1, 5: 1},
+			wantPartSize: partSize,
+		},
+		{
+			name:         "PartFailed",
+			failures:     map[int][]int{3: {http.StatusServiceUnavailable, http.StatusServiceUnavailable}},
+			opts:         []UploadBuildContextOption{OptUploadParts(partSize, 1), OptUploadAttempts(2)},
+			wantPosts:    1,
+			wantPuts:     map[int]int{1: 1, 2: 1, 3: 2},
+			wantErr:      &httpError{Code: http.StatusServiceUnavailable},
+			wantPartSize: partSize,
+		},
+		{
+			name:    "InvalidPartSize",
+			opts:    []UploadBuildContextOption{OptUploadParts(-1, 1)},
+			wantErr: errInvalidPartSize,
+		},
+		{
+			name:    "InvalidConcurrency",
+			opts:    []UploadBuildContextOption{OptUploadParts(partSize, 0)},
+			wantErr: errInvalidConcurrency,
+		},
+	}
+
+	wantDigest, _, err := DigestBuildContext([]string{"a"}, optUploadBuildContextFS(fsys))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mockMultipartUpload{
+				t:         t,
+				noAdvert:  tt.noAdvert,
+				partSize:  partSize,
+				failures:  tt.failures,
+				expiredAt: tt.expiredAt,
+				puts:      make(map[int]int),
+				parts:     make(map[int][]byte),
+			}
+
+			s := httptest.NewServer(m)
+			t.Cleanup(s.Close)
+
+			c, err := NewClient(OptBaseURL(s.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			opts := []UploadBuildContextOption{
+				optUploadBuildContextFS(fsys),
+				optUploadBackoff(time.Millisecond),
+				OptUploadParts(partSize, 2),
+			}
+			opts = append(opts, tt.opts...)
+
+			digest, err := c.UploadBuildContext(context.Background(), []string{"a"}, opts...)
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if err == nil {
+				if got, want := digest, wantDigest; got != want {
+					t.Errorf("got digest %v, want %v", got, want)
+				}
+				if !m.stored {
+					t.Errorf("build context not stored")
+				}
+			}
+
+			if got, want := m.reqPart, tt.wantPartSize; got != want {
+				t.Errorf("got requested part size %v, want %v", got, want)
+			}
+			if got, want := m.posts, tt.wantPosts; got != want {
+				t.Errorf("got %v upload location requests, want %v", got, want)
+			}
+			if tt.wantPuts != nil {
+				if got, want := m.puts, tt.wantPuts; !reflect.DeepEqual(got, want) {
+					t.Errorf("got uploads %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestClient_UploadBuildContextMultipartResume(t *testing.T) {
+	data := make([]byte, 256<<10)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	fsys := fstest.MapFS{
+		"a": &fstest.MapFile{Data: data, Mode: 0o644, ModTime: testTime},
+	}
+
+	const partSize = 64 << 10
+
+	m := &mockMultipartUpload{
+		t:        t,
+		partSize: partSize,
+		failures: map[int][]int{3: {http.StatusServiceUnavailable}},
+		puts:     make(map[int]int),
+		parts:    make(map[int][]byte),
+	}
+
+	s := httptest.NewServer(m)
+	t.Cleanup(s.Close)
+
+	c, err := NewClient(OptBaseURL(s.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := []UploadBuildContextOption{
+		optUploadBuildContextFS(fsys),
+		OptUploadReproducible(true),
+		OptUploadParts(partSize, 1),
+		OptUploadAttempts(1),
+	}
+
+	// The first upload fails at the third part, having uploaded the first two.
+	if _, err := c.UploadBuildContext(context.Background(), []string{"a"}, opts...); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	if got, want := m.puts, map[int]int{1: 1, 2: 1, 3: 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got uploads %v, want %v", got, want)
+	}
+
+	// The second upload resumes at the third part.
+	digest, err := c.UploadBuildContext(context.Background(), []string{"a"}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := digest, m.digest; got != want {
+		t.Errorf("got digest %v, want %v", got, want)
+	}
+	if got, want := m.puts, map[int]int{1: 1, 2: 1, 3: 2, 4: 1, 5: 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got uploads %v, want %v", got, want)
+	}
+	if !m.complete {
+		t.Errorf("multipart upload not completed")
+	}
+}
+
+func TestClient_UploadBuildContextStreaming(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a": &fstest.MapFile{
+			Mode:    0o755 | fs.ModeDir,
+			ModTime: testTime,
+		},
+		"a/b": &fstest.MapFile{
+			Data:    bytes.Repeat([]byte("hello"), 64<<10),
+			Mode:    0o644,
+			ModTime: testTime,
+		},
+	}
+
+	// The digest of the streamed build context must match that of the same build context uploaded
+	// via a temporary file.
+	s := httptest.NewServer(&mockUploadBuildContext{t: t, code2: http.StatusCreated})
+	t.Cleanup(s.Close)
+
+	c, err := NewClient(OptBaseURL(s.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantDigest, err := c.UploadBuildContext(context.Background(), []string{"a"},
+		optUploadBuildContextFS(fsys),
+		OptUploadReproducible(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		paths          []string
+		rejectFinalize bool
+		wantErr        error
+		wantFinalized  bool
+	}{
+		{
+			name:          "Streamed",
+			paths:         []string{"a"},
+			wantFinalized: true,
+		},
+		{
+			name:           "FinalizeRejected",
+			paths:          []string{"a"},
+			rejectFinalize: true,
+			wantErr:        &httpError{Code: http.StatusUnprocessableEntity},
+		},
+		{
+			name:    "NotExistPath",
+			paths:   []string{"b"},
+			wantErr: fs.ErrNotExist,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mockStreamBuildContext{t: t, rejectFinalize: tt.rejectFinalize}
+
+			s := httptest.NewServer(m)
+			t.Cleanup(s.Close)
+
+			c, err := NewClient(OptBaseURL(s.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			digest, err := c.UploadBuildContext(context.Background(), tt.paths,
+				optUploadBuildContextFS(fsys),
+				OptUploadReproducible(true),
+				OptUploadStreaming(true),
+			)
+
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if got, want := m.finalized, tt.wantFinalized; got != want {
+				t.Errorf("got finalized %v, want %v", got, want)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if got, want := digest, wantDigest; got != want {
+				t.Errorf("got digest %v, want %v", got, want)
+			}
+
+			if got, want := m.digest, fmt.Sprintf("sha256.%x", sha256.Sum256(m.body)); got != want {
+				t.Errorf("got finalized digest %v, want %v", got, want)
+			}
+
+			if got, want := m.size, int64(len(m.body)); got != want {
+				t.Errorf("got finalized size %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+type mockDeleteBuildContext struct {
+	t      *testing.T
+	code   int
+	digest string
+}
+
+func (m *mockDeleteBuildContext) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if m.code != 0 {
+		if err := jsonresp.WriteError(w, "", m.code); err != nil {
+			m.t.Fatalf("failed to write error: %v", err)
+		}
+		return
+	}
+
+	if got, want := r.Method, http.MethodDelete; got != want {
+		m.t.Errorf("got method %v, want %v", got, want)
+	}
+
+	if got, want := r.URL.Path, fmt.Sprintf("/v1/build-context/%v", m.digest); got != want {
+		m.t.Errorf("got path %v, want %v", got, want)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestClient_DeleteBuildContext(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		code    int
+		digest  string
+		wantErr error
+	}{
+		{
+			name:   "OK",
+			digest: "sha256.f2ca1bb6c7e907d06dafe4687e579fce76b37e4e93b7605022da52e6ccc26fd2",
+		},
+		{
+			name:    "HTTPError",
+			code:    http.StatusBadRequest,
+			digest:  "sha256.f2ca1bb6c7e907d06dafe4687e579fce76b37e4e93b7605022da52e6ccc26fd2",
+			wantErr: &httpError{Code: http.StatusBadRequest},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := httptest.NewServer(&mockDeleteBuildContext{
+				t:      t,
+				code:   tt.code,
+				digest: tt.digest,
+			})
+			defer s.Close()
+
+			c, err := NewClient(OptBaseURL(s.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = c.DeleteBuildContext(context.Background(), tt.digest)
+
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Errorf("got error %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+type mockListBuildContexts struct {
+	t        *testing.T
+	code     int                // If set, status code returned in place of results.
+	contexts []BuildContextInfo // Build contexts to return.
+	pageSize int                // Page size used if not specified in request.
+	queries  []string           // Raw queries received.
+}
+
+func (m *mockListBuildContexts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.queries = append(m.queries, r.URL.RawQuery)
+
+	if got, want := r.URL.Path, "/v1/build-context"; got != want {
+		m.t.Errorf("got path %v, want %v", got, want)
+	}
+
+	if m.code != 0 {
+		if err := jsonresp.WriteError(w, "", m.code); err != nil {
+			m.t.Fatal(err)
+		}
+		return
+	}
+
+	pageSize := m.pageSize
+	if v := r.URL.Query().Get("pageSize"); v != "" {
+		if _, err := fmt.Sscan(v, &pageSize); err != nil {
+			m.t.Fatal(err)
+		}
+	}
+
+	var start int
+	if v := r.URL.Query().Get("pageToken"); v != "" {
+		if _, err := fmt.Sscan(v, &start); err != nil {
+			m.t.Fatal(err)
+		}
+	}
+
+	end := min(start+pageSize, len(m.contexts))
+
+	var next string
+	if end < len(m.contexts) {
+		next = fmt.Sprint(end)
+	}
+
+	if err := jsonresp.WriteResponse(w, struct {
+		Contexts      []BuildContextInfo `json:"contexts"`
+		NextPageToken string             `json:"nextPageToken"`
+	}{m.contexts[start:end], next}, http.StatusOK); err != nil {
+		m.t.Fatal(err)
+	}
+}
+
+func TestClient_ListBuildContexts(t *testing.T) {
+	contexts := []BuildContextInfo{
+		{Digest: "sha256.a", Size: 1, Created: testTime, LastUsed: testTime.Add(time.Hour)},
+		{Digest: "sha256.b", Size: 2, Created: testTime},
+		{Digest: "sha256.c", Size: 3, Created: testTime, LastUsed: testTime.Add(2 * time.Hour)},
+	}
+
+	tests := []struct {
+		name        string
+		code        int
+		opts        []ListBuildContextsOption
+		want        []BuildContextInfo
+		wantQueries []string
+		wantErr     error
+	}{
+		{
+			name:        "ServerPageSize",
+			want:        contexts,
+			wantQueries: []string{"", "pageToken=2"},
+		},
+		{
+			name:        "PageSize",
+			opts:        []ListBuildContextsOption{OptListBuildContextsPageSize(1)},
+			want:        contexts,
+			wantQueries: []string{"pageSize=1", "pageSize=1&pageToken=1", "pageSize=1&pageToken=2"},
+		},
+		{
+			name:        "LastUsedBefore",
+			opts:        []ListBuildContextsOption{OptListBuildContextsLastUsedBefore(testTime)},
+			want:        contexts,
+			wantQueries: []string{"lastUsedBefore=2017-09-06T00%3A25%3A53Z", "lastUsedBefore=2017-09-06T00%3A25%3A53Z&pageToken=2"},
+		},
+		{
+			name:    "InvalidPageSize",
+			opts:    []ListBuildContextsOption{OptListBuildContextsPageSize(0)},
+			wantErr: errInvalidPageSize,
+		},
+		{
+			name:        "NotFound",
+			code:        http.StatusNotFound,
+			wantQueries: []string{""},
+			wantErr:     ErrNotSupported,
+		},
+		{
+			name:        "MethodNotAllowed",
+			code:        http.StatusMethodNotAllowed,
+			wantQueries: []string{""},
+			wantErr:     ErrNotSupported,
+		},
+		{
+			name:        "InternalServerError",
+			code:        http.StatusInternalServerError,
+			wantQueries: []string{""},
+			wantErr:     &httpError{Code: http.StatusInternalServerError},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mockListBuildContexts{t: t, code: tt.code, contexts: contexts, pageSize: 2}
+
+			s := httptest.NewServer(m)
+			t.Cleanup(s.Close)
+
+			c, err := NewClient(OptBaseURL(s.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := c.ListBuildContexts(context.Background(), tt.opts...)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(m.queries, tt.wantQueries) {
+				t.Errorf("got queries %q, want %q", m.queries, tt.wantQueries)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v build contexts, want %v", len(got), len(tt.want))
+			}
+
+			for i := range got {
+				if !got[i].Created.Equal(tt.want[i].Created) || !got[i].LastUsed.Equal(tt.want[i].LastUsed) ||
+					got[i].Digest != tt.want[i].Digest || got[i].Size != tt.want[i].Size {
+					t.Errorf("got build context %+v, want %+v", got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDigestBuildContext(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a": &fstest.MapFile{
+			Mode:    0o755 | fs.ModeDir,
+			ModTime: testTime,
+		},
+		"a/b": &fstest.MapFile{
+			Data:    []byte("hello"),
+			Mode:    0o644,
+			ModTime: testTime,
+		},
+	}
+
+	tests := []struct {
+		name    string
+		paths   []string
+		opts    []UploadBuildContextOption
+		wantErr error
+	}{
+		{"Gzip", []string{"a"}, nil, nil},
+		{"Zstd", []string{"a"}, []UploadBuildContextOption{OptUploadCompression(CompressionZstd)}, nil},
+		{"NoPaths", nil, nil, errNoPathsSpecified},
+		{"Unsupported", []string{"a"}, []UploadBuildContextOption{OptUploadCompression("bzip2")}, errUnsupportedCompression},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]UploadBuildContextOption{
+				optUploadBuildContextFS(fsys),
+				OptUploadReproducible(true),
+			}, tt.opts...)
+
+			digest, size, err := DigestBuildContext(tt.paths, opts...)
+
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if err != nil {
+				return
+			}
+
+			// The digest and size are those supplied when the same build context is uploaded.
+			m := &mockUploadBuildContext{t: t}
+
+			s := httptest.NewServer(m)
+			t.Cleanup(s.Close)
+
+			c, err := NewClient(OptBaseURL(s.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			uploaded, err := c.UploadBuildContext(context.Background(), tt.paths, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := digest, uploaded; got != want {
+				t.Errorf("got digest %v, want %v", got, want)
+			}
+			if got, want := size, m.size; got != want {
+				t.Errorf("got size %v, want %v", got, want)
 			}
 		})
 	}
@@ -6,13 +6,21 @@
 package client
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"reflect"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
+
+	jsonresp "github.com/sylabs/json-resp"
 )
 
 func TestSubmit(t *testing.T) {
@@ -29,7 +37,8 @@ func TestSubmit(t *testing.T) {
 		ctx          context.Context //nolint:containedctx
 	}{
 		{"SuccessAttached", nil, "", http.StatusCreated, context.Background()},
-		{"SuccessLibraryRef", nil, "library://user/collection/image", http.StatusCreated, context.Background()},
+		{"SuccessLibraryRef", nil, "library://user/collection/image:latest", http.StatusCreated, context.Background()},
+		{"InvalidLibraryRef", errInvalidLibraryRef, "library://user/collection/image", http.StatusCreated, context.Background()},
 		{"NotFoundAttached", &httpError{Code: http.StatusNotFound}, "", http.StatusNotFound, context.Background()},
 		{"ContextExpiredAttached", context.DeadlineExceeded, "", http.StatusCreated, ctx},
 	}
@@ -50,7 +59,7 @@ func TestSubmit(t *testing.T) {
 			m.buildResponseCode = tt.responseCode
 
 			// Call the handler
-			bi, err := c.Submit(tt.ctx, strings.NewReader(""),
+			bi, err := c.Submit(tt.ctx, strings.NewReader(testDefinition),
 				OptBuildLibraryRef(tt.libraryRef),
 			)
 
@@ -73,6 +82,259 @@ func TestSubmit(t *testing.T) {
 	}
 }
 
+func TestOptBuildLibraryRef(t *testing.T) {
+	tests := []struct {
+		name     string
+		imageRef string
+		wantRef  string
+		wantErr  error
+	}{
+		{"Empty", "", "", nil},
+		{"Hostless", "library:entity/collection/container:tag", "library:entity/collection/container:tag", nil},
+		{"HostlessMultipleTags", "library:entity/collection/container:tag1,tag2", "library:entity/collection/container:tag1,tag2", nil},
+		{"Ambiguous", "library://entity/collection/container:tag", "library:entity/collection/container:tag", nil},
+		{"Hosted", "library://host.example.com/entity/collection/container:tag", "", errInvalidLibraryRef},
+		{"NoTag", "library:entity/collection/container", "", errInvalidLibraryRef},
+		{"Pathless", "library:container:tag", "", errInvalidLibraryRef},
+		{"NoEntity", "library:collection/container:tag", "", errInvalidLibraryRef},
+		{"EmptyPathComponent", "library:entity//container:tag", "", errInvalidLibraryRef},
+		{"LocalPath", "/tmp/image.sif", "", errInvalidLibraryRef},
+		{"Docker", "docker://alpine:3", "", errInvalidLibraryRef},
+		{"Garbage", "library:%%%", "", errInvalidLibraryRef},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bo buildOptions
+
+			err := OptBuildLibraryRef(tt.imageRef)(&bo)
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if got, want := bo.libraryRef, tt.wantRef; got != want {
+				t.Errorf("got ref %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestBuildInfo(t *testing.T) {
+	submitTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	startTime := submitTime.Add(time.Minute)
+	completeTime := startTime.Add(time.Hour)
+
+	tests := []struct {
+		name             string
+		body             string
+		wantState        BuildState
+		wantPosition     int
+		wantPositionOK   bool
+		wantSubmitTime   time.Time
+		wantStartTime    time.Time
+		wantCompleteTime time.Time
+		wantExitCode     int
+		wantExitCodeOK   bool
+		wantFailed       bool
+		wantDefChecksum  string
+		wantDefRaw       []byte
+	}{
+		{
+			name: "Legacy",
+			body: `{"id":"id","isComplete":true,"imageSize":1}`,
+		},
+		{
+			name:       "LegacyFailed",
+			body:       `{"id":"id","isComplete":true}`,
+			wantFailed: true,
+		},
+		{
+			name:           "Queued",
+			body:           `{"id":"id","state":"queued","queuePosition":3,"submitTime":"2024-01-02T03:04:05Z"}`,
+			wantState:      BuildStateQueued,
+			wantPosition:   3,
+			wantPositionOK: true,
+			wantSubmitTime: submitTime,
+		},
+		{
+			name:           "QueuedNext",
+			body:           `{"id":"id","state":"queued","queuePosition":0}`,
+			wantState:      BuildStateQueued,
+			wantPositionOK: true,
+		},
+		{
+			name:           "Building",
+			body:           `{"id":"id","state":"building","submitTime":"2024-01-02T03:04:05Z","startTime":"2024-01-02T03:05:05Z"}`,
+			wantState:      BuildStateBuilding,
+			wantSubmitTime: submitTime,
+			wantStartTime:  startTime,
+		},
+		{
+			name:             "Failed",
+			body:             `{"id":"id","isComplete":true,"state":"failed","submitTime":"2024-01-02T03:04:05Z","startTime":"2024-01-02T03:05:05Z","completeTime":"2024-01-02T04:05:05Z","exitCode":255}`,
+			wantState:        BuildStateFailed,
+			wantSubmitTime:   submitTime,
+			wantStartTime:    startTime,
+			wantCompleteTime: completeTime,
+			wantExitCode:     255,
+			wantExitCodeOK:   true,
+			wantFailed:       true,
+		},
+		{
+			name:           "Succeeded",
+			body:           `{"id":"id","isComplete":true,"imageSize":1,"state":"succeeded","exitCode":0}`,
+			wantState:      BuildStateSucceeded,
+			wantExitCodeOK: true,
+		},
+		{
+			name:            "DefinitionChecksum",
+			body:            `{"id":"id","definitionChecksum":"sha256.9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"}`,
+			wantDefChecksum: "sha256.9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		},
+		{
+			name:       "DefinitionRaw",
+			body:       `{"id":"id","definitionRaw":"Ym9vdHN0cmFwOiBkb2NrZXIK"}`,
+			wantDefRaw: []byte("bootstrap: docker\n"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bi BuildInfo
+			if err := json.Unmarshal([]byte(tt.body), &bi.raw); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := bi.State(), tt.wantState; got != want {
+				t.Errorf("got state %q, want %q", got, want)
+			}
+
+			pos, ok := bi.QueuePosition()
+			if pos != tt.wantPosition || ok != tt.wantPositionOK {
+				t.Errorf("got queue position (%v, %v), want (%v, %v)", pos, ok, tt.wantPosition, tt.wantPositionOK)
+			}
+
+			if got, want := bi.SubmitTime(), tt.wantSubmitTime; !got.Equal(want) {
+				t.Errorf("got submit time %v, want %v", got, want)
+			}
+			if got, want := bi.StartTime(), tt.wantStartTime; !got.Equal(want) {
+				t.Errorf("got start time %v, want %v", got, want)
+			}
+			if got, want := bi.CompleteTime(), tt.wantCompleteTime; !got.Equal(want) {
+				t.Errorf("got complete time %v, want %v", got, want)
+			}
+
+			code, ok := bi.ExitCode()
+			if code != tt.wantExitCode || ok != tt.wantExitCodeOK {
+				t.Errorf("got exit code (%v, %v), want (%v, %v)", code, ok, tt.wantExitCode, tt.wantExitCodeOK)
+			}
+
+			if got, want := bi.DefinitionChecksum(), tt.wantDefChecksum; got != want {
+				t.Errorf("got definition checksum %q, want %q", got, want)
+			}
+			if got, want := bi.DefinitionRaw(), tt.wantDefRaw; !bytes.Equal(got, want) {
+				t.Errorf("got definition %q, want %q", got, want)
+			}
+
+			if bi.IsComplete() {
+				if got, want := bi.Failed(), tt.wantFailed; got != want {
+					t.Errorf("got failed %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSubmitBuilderRequirements(t *testing.T) {
+	tests := []struct {
+		name             string
+		opts             []BuildOption
+		wantRequirements map[string]string
+	}{
+		{
+			name:             "Default",
+			wantRequirements: map[string]string{"arch": runtime.GOARCH},
+		},
+		{
+			name:             "Architecture",
+			opts:             []BuildOption{OptBuildArchitecture("arm64")},
+			wantRequirements: map[string]string{"arch": "arm64"},
+		},
+		{
+			name:             "NoArchitecture",
+			opts:             []BuildOption{OptBuildArchitecture("")},
+			wantRequirements: nil,
+		},
+		{
+			name: "Requirements",
+			opts: []BuildOption{
+				OptBuildArchitecture("arm64"),
+				OptBuildRequirement("gpu", "nvidia"),
+				OptBuildRequirement("memory", "large"),
+			},
+			wantRequirements: map[string]string{"arch": "arm64", "gpu": "nvidia", "memory": "large"},
+		},
+		{
+			name: "RequirementOverridesArchitecture",
+			opts: []BuildOption{
+				OptBuildArchitecture("arm64"),
+				OptBuildRequirement("arch", "ppc64le"),
+			},
+			wantRequirements: map[string]string{"arch": "ppc64le"},
+		},
+		{
+			name: "ArchitectureOverridesRequirement",
+			opts: []BuildOption{
+				OptBuildRequirement("arch", "ppc64le"),
+				OptBuildArchitecture("arm64"),
+			},
+			wantRequirements: map[string]string{"arch": "arm64"},
+		},
+		{
+			name: "LastWriterWins",
+			opts: []BuildOption{
+				OptBuildRequirement("gpu", "nvidia"),
+				OptBuildRequirement("gpu", "amd"),
+			},
+			wantRequirements: map[string]string{"arch": runtime.GOARCH, "gpu": "amd"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]string
+
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var br struct {
+					BuilderRequirements map[string]string `json:"builderRequirements"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&br); err != nil {
+					t.Errorf("failed to parse request: %v", err)
+				}
+				got = br.BuilderRequirements
+
+				if err := jsonresp.WriteResponse(w, rawBuildInfo{ID: "id"}, http.StatusCreated); err != nil {
+					t.Error(err)
+				}
+			}))
+			defer s.Close()
+
+			c, err := NewClient(OptBaseURL(s.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := c.Submit(context.Background(), strings.NewReader(testDefinition), tt.opts...); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if want := tt.wantRequirements; !reflect.DeepEqual(got, want) {
+				t.Errorf("got requirements %v, want %v", got, want)
+			}
+		})
+	}
+}
+
 func TestCancel(t *testing.T) {
 	// Start a mock server
 	m := mockService{t: t}
@@ -91,3 +353,110 @@ func TestCancel(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestSubmitEmptyDefinition(t *testing.T) {
+	// A file whose offset is at the end, as if consumed by an earlier attempt.
+	consumed, err := os.CreateTemp(t.TempDir(), "*.def")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumed.Close()
+
+	if _, err := consumed.WriteString(testDefinition); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		definition io.Reader
+	}{
+		{"Empty", strings.NewReader("")},
+		{"Whitespace", strings.NewReader(" \n\t\r\n")},
+		{"ConsumedFile", consumed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int
+
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				requests++
+				w.WriteHeader(http.StatusCreated)
+			}))
+			defer s.Close()
+
+			c, err := NewClient(OptBaseURL(s.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := c.Submit(context.Background(), tt.definition); !errors.Is(err, ErrEmptyDefinition) {
+				t.Fatalf("got error %v, want %v", err, ErrEmptyDefinition)
+			}
+
+			if requests != 0 {
+				t.Errorf("got %v requests, want none", requests)
+			}
+		})
+	}
+}
+
+func TestSubmitRetry(t *testing.T) {
+	var definitions []string
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var br struct {
+			DefinitionRaw []byte `json:"definitionRaw"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&br); err != nil {
+			t.Errorf("failed to parse request: %v", err)
+		}
+		definitions = append(definitions, string(br.DefinitionRaw))
+
+		// Reject the first attempt, as if the token had expired.
+		if r.Header.Get("Authorization") != "BEARER fresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if err := jsonresp.WriteResponse(w, rawBuildInfo{ID: "id"}, http.StatusCreated); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer s.Close()
+
+	c, err := NewClient(
+		OptBaseURL(s.URL),
+		OptBearerTokenFunc(func(_ context.Context, refresh bool) (string, error) {
+			if refresh {
+				return "fresh", nil
+			}
+			return "stale", nil
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.CreateTemp(t.TempDir(), "*.def")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(testDefinition); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Submit(context.Background(), f); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The retry sends the definition read by the first attempt.
+	if want := []string{testDefinition, testDefinition}; !reflect.DeepEqual(definitions, want) {
+		t.Errorf("got definitions %q, want %q", definitions, want)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrBuildFailed is returned by BuildToWriter when the build completes without producing an image.
+var ErrBuildFailed = errors.New("build failed")
+
+var errLibraryRefNotSupported = errors.New("library ref not supported by BuildToWriter")
+
+// BuildToWriter submits an ephemeral build of definition to the Build Service, waits for it to
+// complete, and streams the resulting image to w. The context controls the lifetime of the
+// operation. No Library client is required, since the image is retrieved from the Build Service.
+//
+// Options are as for Submit, except that OptBuildLibraryRef is not supported, since the image is
+// not published. To stream build output while the build runs, consider using OptBuildOutput.
+//
+// If the build fails, an error wrapping ErrBuildFailed is returned along with the final status of
+// the build. The image is verified as described for GetArtifact, and w should be discarded if an
+// error is returned.
+func (c *Client) BuildToWriter(ctx context.Context, definition io.Reader, w io.Writer, opts ...BuildOption) (*BuildInfo, error) {
+	bo := buildOptions{requirements: map[string]string{}}
+	for _, opt := range opts {
+		if err := opt(&bo); err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+	}
+
+	if bo.libraryRef != "" {
+		return nil, fmt.Errorf("%w: %v", errLibraryRefNotSupported, bo.libraryRef)
+	}
+
+	bi, err := c.Submit(ctx, definition, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit build: %w", err)
+	}
+
+	if bo.output != nil {
+		if err := c.GetOutput(ctx, bi.ID(), bo.output); err != nil {
+			return nil, fmt.Errorf("failed to stream build output: %w", err)
+		}
+	}
+
+	if bi, err = c.WaitForCompletion(ctx, bi.ID()); err != nil {
+		return nil, fmt.Errorf("failed to get build status: %w", err)
+	}
+
+	if bi.Failed() {
+		if code, ok := bi.ExitCode(); ok {
+			return bi, fmt.Errorf("%w (exit code %v)", ErrBuildFailed, code)
+		}
+		return bi, fmt.Errorf("%w", ErrBuildFailed)
+	}
+
+	if err := c.GetArtifact(ctx, bi.ID(), w); err != nil {
+		return bi, fmt.Errorf("failed to get image: %w", err)
+	}
+
+	return bi, nil
+}
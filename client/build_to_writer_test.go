@@ -0,0 +1,137 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestClient_BuildToWriter(t *testing.T) {
+	defer func(d time.Duration) { waitPollInterval = d }(waitPollInterval)
+	waitPollInterval = time.Millisecond
+
+	tests := []struct {
+		name              string
+		opts              []BuildOption
+		failBuild         bool
+		pendingStatus     int
+		imageResponseCode int
+		wantOutput        string
+		wantImage         string
+		wantBuildInfo     bool
+		wantErr           error
+	}{
+		{
+			name:              "Success",
+			imageResponseCode: http.StatusOK,
+			wantImage:         imageContents,
+			wantBuildInfo:     true,
+		},
+		{
+			name:              "Pending",
+			pendingStatus:     3,
+			imageResponseCode: http.StatusOK,
+			wantImage:         imageContents,
+			wantBuildInfo:     true,
+		},
+		{
+			name:              "Output",
+			imageResponseCode: http.StatusOK,
+			wantOutput:        stdoutContents,
+			wantImage:         imageContents,
+			wantBuildInfo:     true,
+		},
+		{
+			name:              "BuildFailed",
+			failBuild:         true,
+			imageResponseCode: http.StatusOK,
+			wantBuildInfo:     true,
+			wantErr:           ErrBuildFailed,
+		},
+		{
+			name:              "ImageNotFound",
+			imageResponseCode: http.StatusNotFound,
+			wantBuildInfo:     true,
+			wantErr:           ErrNotFound,
+		},
+		{
+			name:              "LibraryRef",
+			opts:              []BuildOption{OptBuildLibraryRef("library://user/collection/image:latest")},
+			imageResponseCode: http.StatusOK,
+			wantErr:           errLibraryRefNotSupported,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mockService{
+				t:                  t,
+				buildResponseCode:  http.StatusCreated,
+				wsResponseCode:     http.StatusOK,
+				wsCloseCode:        websocket.CloseNormalClosure,
+				statusResponseCode: http.StatusOK,
+				imageResponseCode:  tt.imageResponseCode,
+				failBuild:          tt.failBuild,
+				pendingStatus:      tt.pendingStatus,
+			}
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("/", m.ServeHTTP)
+			mux.HandleFunc(wsPath, m.ServeWebsocket)
+			s := httptest.NewServer(mux)
+			t.Cleanup(s.Close)
+
+			m.httpAddr = s.Listener.Addr().String()
+
+			c, err := NewClient(OptBaseURL(s.URL), OptBearerToken(authToken))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var output bytes.Buffer
+			opts := tt.opts
+			if tt.wantOutput != "" {
+				opts = append(opts, OptBuildOutput(&output))
+			}
+
+			var image bytes.Buffer
+			bi, err := c.BuildToWriter(context.Background(), strings.NewReader("bootstrap: docker\nfrom: alpine:3\n"), &image, opts...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if got, want := bi != nil, tt.wantBuildInfo; got != want {
+				t.Errorf("got build info %v, want %v", got, want)
+			}
+
+			if bi != nil && !bi.IsComplete() {
+				t.Errorf("build info not complete")
+			}
+
+			if got, want := output.String(), tt.wantOutput; got != want {
+				t.Errorf("got output %q, want %q", got, want)
+			}
+
+			if got, want := image.String(), tt.wantImage; got != want {
+				t.Errorf("got image %q, want %q", got, want)
+			}
+
+			// Polling continues until the build is complete.
+			if got := m.pendingStatus; got != 0 {
+				t.Errorf("got %v pending status responses, want 0", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+
+	jsonresp "github.com/sylabs/json-resp"
+)
+
+// Feature is an optional feature of the Build Service.
+type Feature string
+
+const (
+	FeatureBuildContext Feature = "buildContext" // Build contexts. See UploadBuildContext.
+	FeatureZstdContext  Feature = "zstdContext"  // Build contexts compressed using CompressionZstd.
+	FeatureOCIPush      Feature = "ociPush"      // Push of built images to OCI registries.
+)
+
+// Capabilities describes the capabilities of the Build Service.
+type Capabilities struct {
+	// Reported is false if the Build Service predates reporting of capabilities, in which case the
+	// remaining fields are unset.
+	Reported bool `json:"-"`
+
+	// Architectures for which the Build Service has builders.
+	Architectures []string `json:"architectures"`
+
+	// Features supported by the Build Service. Nil if not reported, as by Build Service versions
+	// that report architectures only.
+	Features []Feature `json:"features"`
+}
+
+// Supports returns whether the Build Service supports f. If the Build Service does not report its
+// features, known is false, and support must be determined by other means, such as its version.
+func (c *Capabilities) Supports(f Feature) (supported, known bool) {
+	if c.Features == nil {
+		return false, false
+	}
+	return slices.Contains(c.Features, f), true
+}
+
+// GetCapabilities returns the capabilities of the Build Service. The context controls the lifetime
+// of the request.
+//
+// If the Build Service predates reporting of capabilities, Capabilities with Reported set to false
+// are returned, rather than an error.
+func (c *Client) GetCapabilities(ctx context.Context) (*Capabilities, error) {
+	ref := &url.URL{
+		Path: "v1/capabilities",
+	}
+
+	req, err := c.newRequest(ctx, http.MethodGet, ref, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	res, err := c.doWithRefresh(c.httpClient, req)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	defer res.Body.Close()
+
+	// Servers that predate the capabilities endpoint do not route requests to it.
+	if res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusMethodNotAllowed {
+		return &Capabilities{}, nil
+	}
+
+	if res.StatusCode/100 != 2 { // non-2xx status code
+		return nil, fmt.Errorf("%w", c.errorFromResponse(res))
+	}
+
+	caps := Capabilities{Reported: true}
+	if err := jsonresp.ReadResponse(res.Body, &caps); err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return &caps, nil
+}
+
+// GetBuilderArchitectures returns the architectures for which the Build Service has builders. The
+// context controls the lifetime of the request.
+//
+// If the Build Service does not report its capabilities, an error wrapping ErrNotSupported is
+// returned.
+func (c *Client) GetBuilderArchitectures(ctx context.Context) ([]string, error) {
+	caps, err := c.GetCapabilities(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !caps.Reported {
+		return nil, fmt.Errorf("%w: querying builder architectures", ErrNotSupported)
+	}
+
+	return caps.Architectures, nil
+}
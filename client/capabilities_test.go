@@ -0,0 +1,195 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	jsonresp "github.com/sylabs/json-resp"
+)
+
+type mockCapabilities struct {
+	t        *testing.T
+	code     int
+	archs    []string
+	features []Feature // If nil, features are not reported.
+}
+
+func (m *mockCapabilities) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if got, want := r.Method, http.MethodGet; got != want {
+		m.t.Errorf("got method %v, want %v", got, want)
+	}
+
+	if got, want := r.URL.Path, "/v1/capabilities"; got != want {
+		m.t.Errorf("got path %v, want %v", got, want)
+	}
+
+	if m.code/100 != 2 { // non-2xx status code
+		w.WriteHeader(m.code)
+		return
+	}
+
+	caps := struct {
+		Architectures []string   `json:"architectures"`
+		Features      *[]Feature `json:"features,omitempty"`
+	}{
+		Architectures: m.archs,
+	}
+	if m.features != nil {
+		caps.Features = &m.features
+	}
+	if err := jsonresp.WriteResponse(w, caps, m.code); err != nil {
+		m.t.Fatalf("failed to write response: %v", err)
+	}
+}
+
+func TestClient_GetBuilderArchitectures(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    int
+		archs   []string
+		wantErr error
+	}{
+		{
+			name:  "OK",
+			code:  http.StatusOK,
+			archs: []string{"amd64", "arm64"},
+		},
+		{
+			name:    "NotFound",
+			code:    http.StatusNotFound,
+			wantErr: ErrNotSupported,
+		},
+		{
+			name:    "MethodNotAllowed",
+			code:    http.StatusMethodNotAllowed,
+			wantErr: ErrNotSupported,
+		},
+		{
+			name:    "HTTPError",
+			code:    http.StatusInternalServerError,
+			wantErr: &httpError{Code: http.StatusInternalServerError},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := httptest.NewServer(&mockCapabilities{t: t, code: tt.code, archs: tt.archs})
+			defer s.Close()
+
+			c, err := NewClient(OptBaseURL(s.URL))
+			if err != nil {
+				t.Fatalf("failed to create client: %v", err)
+			}
+
+			archs, err := c.GetBuilderArchitectures(context.Background())
+
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if got, want := archs, tt.archs; !reflect.DeepEqual(got, want) {
+				t.Errorf("got archs %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestClient_GetCapabilities(t *testing.T) {
+	tests := []struct {
+		name          string
+		code          int
+		archs         []string
+		features      []Feature
+		wantReported  bool
+		wantSupported map[Feature]bool // Support for each feature, if known.
+		wantErr       error
+	}{
+		{
+			name:         "Modern",
+			code:         http.StatusOK,
+			archs:        []string{"amd64", "arm64"},
+			features:     []Feature{FeatureBuildContext, FeatureZstdContext},
+			wantReported: true,
+			wantSupported: map[Feature]bool{
+				FeatureBuildContext: true,
+				FeatureZstdContext:  true,
+				FeatureOCIPush:      false,
+			},
+		},
+		{
+			name:         "NoFeatures",
+			code:         http.StatusOK,
+			archs:        []string{"amd64"},
+			features:     []Feature{},
+			wantReported: true,
+			wantSupported: map[Feature]bool{
+				FeatureBuildContext: false,
+				FeatureZstdContext:  false,
+				FeatureOCIPush:      false,
+			},
+		},
+		{
+			name:         "ArchitecturesOnly",
+			code:         http.StatusOK,
+			archs:        []string{"amd64"},
+			wantReported: true,
+		},
+		{
+			name: "Legacy",
+			code: http.StatusNotFound,
+		},
+		{
+			name:    "HTTPError",
+			code:    http.StatusInternalServerError,
+			wantErr: &httpError{Code: http.StatusInternalServerError},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := httptest.NewServer(&mockCapabilities{t: t, code: tt.code, archs: tt.archs, features: tt.features})
+			defer s.Close()
+
+			c, err := NewClient(OptBaseURL(s.URL))
+			if err != nil {
+				t.Fatalf("failed to create client: %v", err)
+			}
+
+			caps, err := c.GetCapabilities(context.Background())
+
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if got, want := caps.Reported, tt.wantReported; got != want {
+				t.Errorf("got reported %v, want %v", got, want)
+			}
+
+			if got, want := caps.Architectures, tt.archs; !reflect.DeepEqual(got, want) {
+				t.Errorf("got archs %v, want %v", got, want)
+			}
+
+			for _, f := range []Feature{FeatureBuildContext, FeatureZstdContext, FeatureOCIPush} {
+				wantSupported, wantKnown := tt.wantSupported[f]
+
+				supported, known := caps.Supports(f)
+				if supported != wantSupported || known != wantKnown {
+					t.Errorf("got %v support (%v, %v), want (%v, %v)", f, supported, known, wantSupported, wantKnown)
+				}
+			}
+		})
+	}
+}
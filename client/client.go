@@ -10,6 +10,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
@@ -34,6 +35,11 @@ func normalizeURL(rawURL string) (*url.URL, error) {
 	// the final path component of BaseURL when constructing request URL from a relative path.
 	if !strings.HasSuffix(u.Path, "/") {
 		u.Path += "/"
+
+		// Preserve any escaping in the original path, such as an encoded separator.
+		if u.RawPath != "" {
+			u.RawPath += "/"
+		}
 	}
 
 	return u, nil
@@ -41,10 +47,25 @@ func normalizeURL(rawURL string) (*url.URL, error) {
 
 // clientOptions describes the options for a Client.
 type clientOptions struct {
-	baseURL     string
-	bearerToken string
-	userAgent   string
-	transport   http.RoundTripper
+	baseURL                 string
+	bearerToken             string
+	bearerTokenFunc         BearerTokenFunc
+	userAgent               string
+	headers                 http.Header
+	presignedHeaders        bool
+	transport               http.RoundTripper
+	httpClient              *http.Client
+	buildContextHTTPClient  *http.Client
+	recorder                *HTTPRecorder
+	timeouts                TimeoutConfig
+	httpTimeout             time.Duration
+	buildContextHTTPTimeout time.Duration
+	rateLimitRetries        int
+	rateLimitMaxWait        time.Duration
+	rateLimitFunc           RateLimitFunc
+	verboseErrors           bool
+	traceContextFunc        TraceContextFunc
+	tracer                  Tracer
 }
 
 // Option are used to populate co.
@@ -66,6 +87,21 @@ func OptBearerToken(token string) Option {
 	}
 }
 
+// BearerTokenFunc returns a bearer token to include in the "Authorization" header of a request. If
+// refresh is true, a previously returned token was rejected, and a fresh token should be obtained
+// rather than returning a cached value.
+type BearerTokenFunc func(ctx context.Context, refresh bool) (string, error)
+
+// OptBearerTokenFunc sets f as the source of the bearer token to include in the "Authorization"
+// header of each request, in place of a static token set using OptBearerToken. This allows
+// long-running operations to outlive the lifetime of a single token.
+func OptBearerTokenFunc(f BearerTokenFunc) Option {
+	return func(co *clientOptions) error {
+		co.bearerTokenFunc = f
+		return nil
+	}
+}
+
 // OptUserAgent sets the HTTP user agent to include in the "User-Agent" header of each request.
 func OptUserAgent(agent string) Option {
 	return func(co *clientOptions) error {
@@ -74,7 +110,34 @@ func OptUserAgent(agent string) Option {
 	}
 }
 
-// OptHTTPTransport sets the transport for HTTP requests to use.
+// OptHTTPHeader adds a header with the specified key and value to each request, such as may be
+// required by a gateway in front of the Build Service. It may be specified more than once, to add
+// multiple headers or values. Headers set by the client, such as "Authorization" and
+// "User-Agent", take precedence.
+//
+// Headers are not sent on requests to pre-signed URLs used to upload build contexts, since these
+// are typically served by a third party, unless OptPresignedHTTPHeaders is set.
+func OptHTTPHeader(key, value string) Option {
+	return func(co *clientOptions) error {
+		if co.headers == nil {
+			co.headers = make(http.Header)
+		}
+		co.headers.Add(key, value)
+		return nil
+	}
+}
+
+// OptPresignedHTTPHeaders sets whether headers added using OptHTTPHeader are also sent on requests
+// to pre-signed URLs used to upload build contexts.
+func OptPresignedHTTPHeaders(b bool) Option {
+	return func(co *clientOptions) error {
+		co.presignedHeaders = b
+		return nil
+	}
+}
+
+// OptHTTPTransport sets the transport for HTTP requests to use. The transport is used as supplied,
+// so OptTimeouts does not apply to it.
 func OptHTTPTransport(tr http.RoundTripper) Option {
 	return func(co *clientOptions) error {
 		co.transport = tr
@@ -82,26 +145,203 @@ func OptHTTPTransport(tr http.RoundTripper) Option {
 	}
 }
 
+// OptHTTPClient sets the client for HTTP requests to use, in place of one constructed by NewClient.
+// This allows the caller to configure aspects of the client other than the transport, such as the
+// redirect policy or cookie jar, or to supply an instrumented client.
+//
+// The client is used as supplied, so takes precedence over OptHTTPTransport, and OptTimeouts,
+// OptHTTPTimeout and OptBuildContextHTTPTimeout do not apply to requests made using it, nor to the
+// websocket used to stream build output. Unless
+// OptBuildContextHTTPClient is also set, the client is also used to transfer build contexts. The
+// proxy and TLS configuration of the client's transport, if it is an *http.Transport, are applied
+// to the websocket used to stream build output.
+func OptHTTPClient(hc *http.Client) Option {
+	return func(co *clientOptions) error {
+		co.httpClient = hc
+		return nil
+	}
+}
+
+// OptBuildContextHTTPClient sets the client for HTTP requests used to transfer build contexts, in
+// place of that set using OptHTTPClient, or constructed by NewClient. The client is used as
+// supplied, so OptBuildContextHTTPTimeout does not apply to requests made using it.
+func OptBuildContextHTTPClient(hc *http.Client) Option {
+	return func(co *clientOptions) error {
+		co.buildContextHTTPClient = hc
+		return nil
+	}
+}
+
+// OptHTTPRecorder sets r to record HTTP requests and responses, including the websocket handshake
+// and messages used to stream build output.
+func OptHTTPRecorder(r *HTTPRecorder) Option {
+	return func(co *clientOptions) error {
+		co.recorder = r
+		return nil
+	}
+}
+
+// TimeoutConfig describes the timeouts applied to network operations. With the exception of Dial,
+// a timeout of zero means no timeout. If Dial is zero, the dialer configured in the transport is
+// used unmodified.
+type TimeoutConfig struct {
+	Dial           time.Duration // Time limit to establish a network connection.
+	TLSHandshake   time.Duration // Time limit to perform a TLS handshake.
+	ResponseHeader time.Duration // Time limit to receive response headers, once a request is written.
+	IdleConn       time.Duration // Time an idle connection remains open before being closed.
+}
+
+// defaultTimeouts limit the time taken to establish connections and receive response headers,
+// without limiting the time taken to transfer request or response bodies.
+var defaultTimeouts = TimeoutConfig{
+	Dial:           30 * time.Second,
+	TLSHandshake:   10 * time.Second,
+	ResponseHeader: 30 * time.Second,
+	IdleConn:       90 * time.Second,
+}
+
+// OptTimeouts sets the timeouts applied to network operations.
+//
+// Timeouts are applied to the transport created by NewClient, and to the websocket used to stream
+// build output. They do not apply where the caller supplies a transport or client, using
+// OptHTTPTransport or OptHTTPClient, which is used as configured.
+func OptTimeouts(tc TimeoutConfig) Option {
+	return func(co *clientOptions) error {
+		co.timeouts = tc
+		return nil
+	}
+}
+
+// OptHTTPTimeout sets an overall time limit for HTTP requests made by the client, with the
+// exception of those used to transfer build contexts. The time limit includes reading the response
+// body. A timeout of zero means no timeout.
+func OptHTTPTimeout(d time.Duration) Option {
+	return func(co *clientOptions) error {
+		co.httpTimeout = d
+		return nil
+	}
+}
+
+// OptBuildContextHTTPTimeout sets an overall time limit for HTTP requests used to transfer build
+// contexts. The time limit includes reading the response body. A timeout of zero means no timeout.
+func OptBuildContextHTTPTimeout(d time.Duration) Option {
+	return func(co *clientOptions) error {
+		co.buildContextHTTPTimeout = d
+		return nil
+	}
+}
+
+var errInvalidRetries = errors.New("invalid number of retries")
+
+// OptRateLimitRetries sets the number of times a request rejected as rate limited (429 Too Many
+// Requests) is retried, after waiting for the delay requested by the server. The default is 3. A
+// value of zero disables retries.
+func OptRateLimitRetries(n int) Option {
+	return func(co *clientOptions) error {
+		if n < 0 {
+			return fmt.Errorf("%w: %v", errInvalidRetries, n)
+		}
+		co.rateLimitRetries = n
+		return nil
+	}
+}
+
+// OptRateLimitMaxWait sets the longest period waited before retrying a request rejected as rate
+// limited, regardless of the delay requested by the server. The default is 60 seconds.
+func OptRateLimitMaxWait(d time.Duration) Option {
+	return func(co *clientOptions) error {
+		co.rateLimitMaxWait = d
+		return nil
+	}
+}
+
+// RateLimitFunc is called when a request is rejected as rate limited, before waiting for the
+// specified period to retry it.
+type RateLimitFunc func(wait time.Duration)
+
+// OptRateLimitFunc sets f to be called when a request is rejected as rate limited, and is to be
+// retried, so that the caller can report the delay.
+func OptRateLimitFunc(f RateLimitFunc) Option {
+	return func(co *clientOptions) error {
+		co.rateLimitFunc = f
+		return nil
+	}
+}
+
+// OptVerboseErrors sets whether errors resulting from responses from the Build Service, or from a
+// server to which a build context is uploaded, include the request method and path, the request
+// ID assigned by the server, and the start of a response body that does not contain a JSON error,
+// in their messages. Regardless, the request ID is available via RequestID.
+func OptVerboseErrors(b bool) Option {
+	return func(co *clientOptions) error {
+		co.verboseErrors = b
+		return nil
+	}
+}
+
 // Client describes the client details.
 type Client struct {
-	baseURL                *url.URL     // Parsed base URL.
-	bearerToken            string       // Bearer token to include in "Authorization" header.
-	userAgent              string       // Value to include in "User-Agent" header.
-	httpClient             *http.Client // Client to use for HTTP requests.
-	buildContextHTTPClient *http.Client // Client to use for build context HTTP requests.
+	baseURL                *url.URL          // Parsed base URL.
+	bearerToken            string            // Bearer token to include in "Authorization" header.
+	bearerTokenFunc        BearerTokenFunc   // If set, used in place of bearerToken.
+	userAgent              string            // Value to include in "User-Agent" header.
+	headers                http.Header       // Additional headers to include in each request.
+	presignedHeaders       bool              // Include headers in requests to pre-signed URLs.
+	timeouts               *TimeoutConfig    // If set, timeouts applied to network operations.
+	transport              http.RoundTripper // Transport for HTTP requests, without recording.
+	recorder               *HTTPRecorder     // If set, records HTTP requests and responses.
+	httpClient             *http.Client      // Client to use for HTTP requests.
+	buildContextHTTPClient *http.Client      // Client to use for build context HTTP requests.
+	rateLimitRetries       int               // Number of retries of rate limited requests.
+	rateLimitMaxWait       time.Duration     // Longest wait before retrying a rate limited request.
+	rateLimitFunc          RateLimitFunc     // If set, called before waiting to retry.
+	verboseErrors          bool              // Include request details in errors from responses.
+	traceContextFunc       TraceContextFunc  // If set, injects trace context into requests.
+	tracer                 Tracer            // If set, starts spans for client operations.
 }
 
-const defaultBaseURL = "https://build.sylabs.io/"
+const (
+	defaultBaseURL = "https://build.sylabs.io/"
+
+	// defaultRateLimitRetries is the number of times a rate limited request is retried.
+	defaultRateLimitRetries = 3
+
+	// defaultRateLimitMaxWait is the longest wait before retrying a rate limited request.
+	defaultRateLimitMaxWait = 60 * time.Second
+
+	// defaultRateLimitWait is the wait before retrying a rate limited request, where the server does
+	// not request a delay. It doubles with each retry.
+	defaultRateLimitWait = time.Second
+)
 
 // NewClient returns a Client configured according to opts.
 //
 // By default, the Sylabs Build Service is used. To override this behaviour, use OptBaseURL.
 //
-// By default, requests are not authenticated. To override this behaviour, use OptBearerToken.
+// By default, requests are not authenticated. To override this behaviour, use OptBearerToken or
+// OptBearerTokenFunc.
+//
+// By default, establishing a connection times out after 30 seconds, and a response that does not
+// begin within 30 seconds of the request being written times out. The transfer of request and
+// response bodies is not time limited, since build contexts, images and build output may be large.
+// To override this behaviour, use OptTimeouts, OptHTTPTimeout and OptBuildContextHTTPTimeout.
+//
+// By default, HTTP clients are constructed from a transport created by NewClient. To supply a
+// transport, which is used as configured, use OptHTTPTransport. To supply fully configured clients
+// instead, use OptHTTPClient and OptBuildContextHTTPClient.
+//
+// By default, trace context is not propagated, and spans are not reported. To override this
+// behaviour, use OptPropagateTraceContext and OptTracer.
+//
+// By default, requests rejected as rate limited are retried up to 3 times, waiting for the delay
+// requested by the server, up to 60 seconds. To override this behaviour, use OptRateLimitRetries
+// and OptRateLimitMaxWait.
 func NewClient(opts ...Option) (*Client, error) {
 	co := clientOptions{
-		baseURL:   defaultBaseURL,
-		transport: http.DefaultTransport,
+		baseURL:          defaultBaseURL,
+		timeouts:         defaultTimeouts,
+		rateLimitRetries: defaultRateLimitRetries,
+		rateLimitMaxWait: defaultRateLimitMaxWait,
 	}
 
 	// Apply options.
@@ -111,14 +351,55 @@ func NewClient(opts ...Option) (*Client, error) {
 		}
 	}
 
+	// Timeouts are only applied to a transport created by the client, so that one supplied by the
+	// caller is used as configured.
+	var timeouts *TimeoutConfig
+
+	tr := co.transport
+	if co.httpClient != nil {
+		tr = clientTransport(co.httpClient)
+	} else if tr == nil {
+		tr = applyTimeouts(http.DefaultTransport, co.timeouts)
+		timeouts = &co.timeouts
+	}
+
 	c := Client{
-		bearerToken: co.bearerToken,
-		userAgent:   co.userAgent,
-		httpClient: &http.Client{
-			Transport: co.transport,
-			Timeout:   30 * time.Second, // use default from singularity
-		},
-		buildContextHTTPClient: &http.Client{Transport: co.transport},
+		bearerToken:      co.bearerToken,
+		bearerTokenFunc:  co.bearerTokenFunc,
+		userAgent:        co.userAgent,
+		headers:          co.headers,
+		presignedHeaders: co.presignedHeaders,
+		timeouts:         timeouts,
+		transport:        tr,
+		recorder:         co.recorder,
+		rateLimitRetries: co.rateLimitRetries,
+		rateLimitMaxWait: co.rateLimitMaxWait,
+		rateLimitFunc:    co.rateLimitFunc,
+		verboseErrors:    co.verboseErrors,
+		traceContextFunc: co.traceContextFunc,
+		tracer:           co.tracer,
+	}
+
+	if co.httpClient != nil {
+		c.httpClient = withRecorder(co.httpClient, co.recorder)
+		c.buildContextHTTPClient = c.httpClient
+	} else {
+		if co.recorder != nil {
+			tr = co.recorder.RoundTripper(tr)
+		}
+
+		c.httpClient = &http.Client{
+			Transport: tr,
+			Timeout:   co.httpTimeout,
+		}
+		c.buildContextHTTPClient = &http.Client{
+			Transport: tr,
+			Timeout:   co.buildContextHTTPTimeout,
+		}
+	}
+
+	if co.buildContextHTTPClient != nil {
+		c.buildContextHTTPClient = withRecorder(co.buildContextHTTPClient, co.recorder)
 	}
 
 	// Normalize base URL.
@@ -131,6 +412,53 @@ func NewClient(opts ...Option) (*Client, error) {
 	return &c, nil
 }
 
+// BaseURL returns the base URL of the Build Service.
+func (c *Client) BaseURL() string {
+	return c.baseURL.String()
+}
+
+// clientTransport returns the transport used by hc.
+func clientTransport(hc *http.Client) http.RoundTripper {
+	if hc.Transport == nil {
+		return http.DefaultTransport
+	}
+	return hc.Transport
+}
+
+// withRecorder returns hc if r is nil. Otherwise, it returns a copy of hc that records HTTP
+// requests and responses using r.
+func withRecorder(hc *http.Client, r *HTTPRecorder) *http.Client {
+	if r == nil {
+		return hc
+	}
+
+	rc := *hc
+	rc.Transport = r.RoundTripper(clientTransport(hc))
+	return &rc
+}
+
+// applyTimeouts returns a RoundTripper that applies tc to rt. If rt is not an *http.Transport, it is
+// returned unmodified.
+func applyTimeouts(rt http.RoundTripper, tc TimeoutConfig) http.RoundTripper {
+	tr, ok := rt.(*http.Transport)
+	if !ok {
+		return rt
+	}
+
+	tr = tr.Clone()
+	if tc.Dial > 0 {
+		tr.DialContext = (&net.Dialer{
+			Timeout:   tc.Dial,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+	}
+	tr.TLSHandshakeTimeout = tc.TLSHandshake
+	tr.ResponseHeaderTimeout = tc.ResponseHeader
+	tr.IdleConnTimeout = tc.IdleConn
+
+	return tr
+}
+
 // newRequest returns a new Request given a method, ref, and optional body.
 //
 // The context controls the entire lifetime of a request and its response: obtaining a connection,
@@ -143,17 +471,127 @@ func (c *Client) newRequest(ctx context.Context, method string, ref *url.URL, bo
 		return nil, err
 	}
 
-	c.setRequestHeaders(r.Header)
+	if err := c.setRequestHeaders(ctx, r.Header, false); err != nil {
+		return nil, err
+	}
 
 	return r, nil
 }
 
-// setRequestHeaders sets HTTP headers according to c.
-func (c *Client) setRequestHeaders(h http.Header) {
-	if v := c.bearerToken; v != "" {
-		h.Set("Authorization", fmt.Sprintf("BEARER %s", v))
+// setRequestHeaders sets HTTP headers according to c. If refresh is true, a fresh bearer token is
+// obtained from c.bearerTokenFunc, if set.
+func (c *Client) setRequestHeaders(ctx context.Context, h http.Header, refresh bool) error {
+	for k, vs := range c.headers {
+		h[k] = append([]string(nil), vs...)
+	}
+
+	token := c.bearerToken
+	if c.bearerTokenFunc != nil {
+		var err error
+		if token, err = c.bearerTokenFunc(ctx, refresh); err != nil {
+			return fmt.Errorf("failed to get bearer token: %w", err)
+		}
+	}
+
+	if token != "" {
+		h.Set("Authorization", fmt.Sprintf("BEARER %s", token))
 	}
 	if v := c.userAgent; v != "" {
 		h.Set("User-Agent", v)
 	}
+	if c.traceContextFunc != nil {
+		c.traceContextFunc(ctx, h)
+	}
+	return nil
+}
+
+// ErrTokenRejected is returned when a request is rejected as unauthorized, even after obtaining a
+// fresh bearer token.
+var ErrTokenRejected = errors.New("bearer token rejected after refresh")
+
+// rateLimitWait returns the period to wait before retrying a request rejected as rate limited with
+// res, for the specified retry (counting from zero). The delay requested by the server, if any, is
+// used, up to c.rateLimitMaxWait.
+func (c *Client) rateLimitWait(res *http.Response, retry int) time.Duration {
+	d, ok := parseRetryAfter(res.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		d = defaultRateLimitWait << retry
+	}
+	return min(d, c.rateLimitMaxWait)
+}
+
+// doWithRefresh sends req using hc. If the request is rejected as unauthorized and the client was
+// configured with OptBearerTokenFunc, a fresh token is obtained and the request is retried once. If
+// the retry is also rejected, an error wrapping ErrTokenRejected is returned.
+//
+// If the request is rejected as rate limited, it is retried up to c.rateLimitRetries times, after
+// waiting as per rateLimitWait. Once retries are exhausted, the rate limited response is returned.
+func (c *Client) doWithRefresh(hc *http.Client, req *http.Request) (*http.Response, error) {
+	for retry := 0; ; retry++ {
+		res, err := c.doWithRefreshOnce(hc, req)
+		if err != nil || res.StatusCode != http.StatusTooManyRequests || retry >= c.rateLimitRetries {
+			return res, err
+		}
+
+		// A request with a body can only be retried if the body can be re-read.
+		if req.Body != nil && req.GetBody == nil {
+			return res, nil
+		}
+
+		wait := c.rateLimitWait(res, retry)
+		res.Body.Close()
+
+		if c.rateLimitFunc != nil {
+			c.rateLimitFunc(wait)
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+
+		next := req.Clone(req.Context())
+		if req.GetBody != nil {
+			if next.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		req = next
+	}
+}
+
+// doWithRefreshOnce sends req using hc, refreshing the bearer token as described by doWithRefresh.
+func (c *Client) doWithRefreshOnce(hc *http.Client, req *http.Request) (*http.Response, error) {
+	res, err := hc.Do(req)
+	if err != nil || res.StatusCode != http.StatusUnauthorized || c.bearerTokenFunc == nil {
+		return res, err
+	}
+
+	// A request with a body can only be retried if the body can be re-read.
+	if req.Body != nil && req.GetBody == nil {
+		return res, nil
+	}
+	res.Body.Close()
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.setRequestHeaders(req.Context(), retry.Header, true); err != nil {
+		return nil, err
+	}
+
+	if res, err = hc.Do(retry); err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusUnauthorized {
+		defer res.Body.Close()
+		return nil, fmt.Errorf("%w: %w", ErrTokenRejected, c.errorFromResponse(res))
+	}
+	return res, nil
 }
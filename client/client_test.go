@@ -7,16 +7,32 @@ package client
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
+// roundTripperFunc implements http.RoundTripper using a function.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
 func TestNewClient(t *testing.T) {
 	httpTransport, _ := http.DefaultTransport.(*http.Transport)
 	httpTransport = httpTransport.Clone()
+	httpTransport.MaxConnsPerHost = 42
+
+	roundTripper := roundTripperFunc(http.DefaultTransport.RoundTrip)
 
 	tests := []struct {
 		name              string
@@ -40,6 +56,9 @@ func TestNewClient(t *testing.T) {
 		{"HTTPSBaseURLWithPathSlash", []Option{
 			OptBaseURL("https://build.staging.sylabs.io/path/"),
 		}, false, "https://build.staging.sylabs.io/path/", "", "", http.DefaultTransport},
+		{"HTTPSBaseURLWithEscapedPath", []Option{
+			OptBaseURL("https://build.staging.sylabs.io/a%2Fb"),
+		}, false, "https://build.staging.sylabs.io/a%2Fb/", "", "", http.DefaultTransport},
 		{"UnsupportedBaseURL", []Option{
 			OptBaseURL("bad:"),
 		}, true, "", "", "", nil},
@@ -55,6 +74,16 @@ func TestNewClient(t *testing.T) {
 		{"HTTPTransport", []Option{
 			OptHTTPTransport(httpTransport),
 		}, false, defaultBaseURL, "", "", httpTransport},
+		{"RoundTripper", []Option{
+			OptHTTPTransport(roundTripper),
+		}, false, defaultBaseURL, "", "", roundTripper},
+		{"HTTPClient", []Option{
+			OptHTTPClient(&http.Client{Transport: roundTripper}),
+		}, false, defaultBaseURL, "", "", roundTripper},
+		{"HTTPClientPrecedence", []Option{
+			OptHTTPClient(&http.Client{Transport: roundTripper}),
+			OptHTTPTransport(httpTransport),
+		}, false, defaultBaseURL, "", "", roundTripper},
 	}
 
 	for _, tt := range tests {
@@ -77,14 +106,294 @@ func TestNewClient(t *testing.T) {
 					t.Errorf("got user agent %v, want %v", got, want)
 				}
 
-				if got, want := c.httpClient.Transport, tt.wantHTTPTransport; got != want {
-					t.Errorf("got HTTP client %v, want %v", got, want)
+				// An *http.Transport is cloned in order to apply timeouts, so compare a distinguishing
+				// field. Other implementations are used as-is.
+				if want, ok := tt.wantHTTPTransport.(*http.Transport); ok {
+					got, ok := c.httpClient.Transport.(*http.Transport)
+					if !ok {
+						t.Fatalf("got HTTP transport %T, want %T", c.httpClient.Transport, want)
+					}
+
+					if got.MaxConnsPerHost != want.MaxConnsPerHost {
+						t.Errorf("got HTTP transport %v, want %v", got, want)
+					}
+				} else if got, want := fmt.Sprint(c.httpClient.Transport), fmt.Sprint(tt.wantHTTPTransport); got != want {
+					t.Errorf("got HTTP transport %v, want %v", got, want)
 				}
 			}
 		})
 	}
 }
 
+func TestNewClientTimeout(t *testing.T) {
+	tests := []struct {
+		name                        string
+		opts                        []Option
+		wantHTTPTimeout             time.Duration
+		wantBuildContextHTTPTimeout time.Duration
+	}{
+		{"Default", nil, 0, 0},
+		{"HTTPTimeout", []Option{
+			OptHTTPTimeout(time.Hour),
+		}, time.Hour, 0},
+		{"HTTPTimeoutDisabled", []Option{
+			OptHTTPTimeout(0),
+		}, 0, 0},
+		{"BuildContextHTTPTimeout", []Option{
+			OptBuildContextHTTPTimeout(time.Hour),
+		}, 0, time.Hour},
+		{"Independent", []Option{
+			OptHTTPTimeout(time.Minute),
+			OptBuildContextHTTPTimeout(time.Hour),
+		}, time.Minute, time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewClient(tt.opts...)
+			if err != nil {
+				t.Fatalf("failed to create client: %v", err)
+			}
+
+			if got, want := c.httpClient.Timeout, tt.wantHTTPTimeout; got != want {
+				t.Errorf("got HTTP timeout %v, want %v", got, want)
+			}
+
+			if got, want := c.buildContextHTTPClient.Timeout, tt.wantBuildContextHTTPTimeout; got != want {
+				t.Errorf("got build context HTTP timeout %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestNewClientHTTPClient(t *testing.T) {
+	hc := &http.Client{Timeout: time.Minute}
+	bchc := &http.Client{Timeout: time.Hour}
+
+	r, err := NewHTTPRecorder(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name                       string
+		opts                       []Option
+		wantHTTPClient             *http.Client
+		wantBuildContextHTTPClient *http.Client
+		wantRecorded               bool
+	}{
+		{"HTTPClient", []Option{
+			OptHTTPClient(hc),
+		}, hc, hc, false},
+		{"BuildContextHTTPClient", []Option{
+			OptBuildContextHTTPClient(bchc),
+		}, nil, bchc, false},
+		{"Split", []Option{
+			OptHTTPClient(hc),
+			OptBuildContextHTTPClient(bchc),
+		}, hc, bchc, false},
+		{"TimeoutsNotApplied", []Option{
+			OptHTTPClient(hc),
+			OptHTTPTimeout(time.Second),
+			OptBuildContextHTTPTimeout(time.Second),
+		}, hc, hc, false},
+		{"Recorder", []Option{
+			OptHTTPClient(hc),
+			OptBuildContextHTTPClient(bchc),
+			OptHTTPRecorder(r),
+		}, hc, bchc, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewClient(tt.opts...)
+			if err != nil {
+				t.Fatalf("failed to create client: %v", err)
+			}
+
+			check := func(name string, got, want *http.Client) {
+				if want == nil {
+					return
+				}
+
+				if !tt.wantRecorded {
+					if got != want {
+						t.Errorf("got %v client %p, want %p", name, got, want)
+					}
+					return
+				}
+
+				// The supplied client is copied in order to record requests, and must not be modified.
+				if got == want {
+					t.Errorf("%v client not copied", name)
+				}
+				if want.Transport != nil {
+					t.Errorf("supplied %v client modified", name)
+				}
+				if got, want := got.Timeout, want.Timeout; got != want {
+					t.Errorf("got %v timeout %v, want %v", name, got, want)
+				}
+				if _, ok := got.Transport.(*recordingTransport); !ok {
+					t.Errorf("got %v transport %T, want recording transport", name, got.Transport)
+				}
+			}
+
+			check("HTTP", c.httpClient, tt.wantHTTPClient)
+			check("build context HTTP", c.buildContextHTTPClient, tt.wantBuildContextHTTPClient)
+		})
+	}
+}
+
+func TestOptHTTPClientWebsocketTransport(t *testing.T) {
+	tr := &http.Transport{TLSClientConfig: &tls.Config{ServerName: "build.example.com"}}
+
+	c, err := NewClient(OptHTTPClient(&http.Client{Transport: tr}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The websocket dialer derives its proxy and TLS configuration from the supplied transport.
+	if c.transport != tr {
+		t.Errorf("got transport %v, want %v", c.transport, tr)
+	}
+}
+
+func TestOptTimeouts(t *testing.T) {
+	tc := TimeoutConfig{
+		TLSHandshake:   time.Second,
+		ResponseHeader: 2 * time.Second,
+		IdleConn:       3 * time.Second,
+	}
+
+	c, err := NewClient(OptTimeouts(tc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, hc := range []*http.Client{c.httpClient, c.buildContextHTTPClient} {
+		tr, ok := hc.Transport.(*http.Transport)
+		if !ok {
+			t.Fatalf("unexpected transport type %T", hc.Transport)
+		}
+
+		if got, want := tr.TLSHandshakeTimeout, tc.TLSHandshake; got != want {
+			t.Errorf("got TLS handshake timeout %v, want %v", got, want)
+		}
+		if got, want := tr.ResponseHeaderTimeout, tc.ResponseHeader; got != want {
+			t.Errorf("got response header timeout %v, want %v", got, want)
+		}
+		if got, want := tr.IdleConnTimeout, tc.IdleConn; got != want {
+			t.Errorf("got idle connection timeout %v, want %v", got, want)
+		}
+	}
+}
+
+func TestOptTimeoutsSuppliedTransport(t *testing.T) {
+	tc := TimeoutConfig{ResponseHeader: time.Second}
+
+	tr := &http.Transport{ResponseHeaderTimeout: time.Minute}
+
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"HTTPTransport", []Option{OptHTTPTransport(tr), OptTimeouts(tc)}},
+		{"HTTPClient", []Option{OptHTTPClient(&http.Client{Transport: tr}), OptTimeouts(tc)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewClient(tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The supplied transport is used as configured, including by the websocket dialer.
+			if c.httpClient.Transport != tr {
+				t.Errorf("got transport %v, want %v", c.httpClient.Transport, tr)
+			}
+			if got, want := tr.ResponseHeaderTimeout, time.Minute; got != want {
+				t.Errorf("got response header timeout %v, want %v", got, want)
+			}
+			if c.timeouts != nil {
+				t.Errorf("got timeouts %+v, want none", *c.timeouts)
+			}
+		})
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	const delay = 200 * time.Millisecond
+
+	// Server that delays response headers.
+	delayHeaders := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(delay)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(delayHeaders.Close)
+
+	// Server that sends response headers promptly, but delays the body.
+	delayBody := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+		w.(http.Flusher).Flush()
+		time.Sleep(delay)
+	}))
+	t.Cleanup(delayBody.Close)
+
+	// Listener that accepts connections, but never completes a TLS handshake.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { c.Close() })
+		}
+	}()
+
+	tests := []struct {
+		name     string
+		url      string
+		timeouts TimeoutConfig
+		wantErr  bool
+	}{
+		{"DelayHeadersNoTimeout", delayHeaders.URL, TimeoutConfig{}, false},
+		{"DelayHeadersTimeout", delayHeaders.URL, TimeoutConfig{ResponseHeader: delay / 4}, true},
+		{"DelayBodyNoTimeout", delayBody.URL, TimeoutConfig{}, false},
+		{"DelayBodyHeaderTimeout", delayBody.URL, TimeoutConfig{ResponseHeader: delay / 4}, false},
+		{"TLSHandshakeTimeout", "https://" + ln.Addr().String(), TimeoutConfig{TLSHandshake: delay / 4}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewClient(OptBaseURL(tt.url), OptTimeouts(tt.timeouts))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 4*delay)
+			defer cancel()
+
+			err = c.Cancel(ctx, "id")
+
+			if got, want := err != nil, tt.wantErr; got != want {
+				t.Fatalf("got error %v, want error %v", err, want)
+			}
+
+			// Ensure failures are due to the timeout under test, rather than the context.
+			if err != nil && errors.Is(err, context.DeadlineExceeded) && ctx.Err() != nil {
+				t.Errorf("request timed out by context: %v", err)
+			}
+		})
+	}
+}
+
 func TestNewRequest(t *testing.T) {
 	tests := []struct {
 		name            string
@@ -186,3 +495,309 @@ func TestNewRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestNewRequestHTTPHeader(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Option
+		wantHeader http.Header
+	}{
+		{"None", nil, http.Header{}},
+		{"Single", []Option{
+			OptHTTPHeader("X-Org-ID", "org"),
+		}, http.Header{"X-Org-Id": {"org"}}},
+		{"Repeated", []Option{
+			OptHTTPHeader("X-Org-ID", "org"),
+			OptHTTPHeader("x-org-id", "other"),
+			OptHTTPHeader("X-Trace", "trace"),
+		}, http.Header{"X-Org-Id": {"org", "other"}, "X-Trace": {"trace"}}},
+		{"ClientHeadersPrecedence", []Option{
+			OptHTTPHeader("Authorization", "custom"),
+			OptHTTPHeader("User-Agent", "custom"),
+			OptBearerToken("blah"),
+			OptUserAgent("Secret Agent Man"),
+		}, http.Header{"Authorization": {"BEARER blah"}, "User-Agent": {"Secret Agent Man"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewClient(tt.opts...)
+			if err != nil {
+				t.Fatalf("failed to create client: %v", err)
+			}
+
+			r, err := c.newRequest(context.Background(), http.MethodGet, &url.URL{Path: "/path"}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := r.Header, tt.wantHeader; !reflect.DeepEqual(got, want) {
+				t.Errorf("got header %v, want %v", got, want)
+			}
+
+			// Requests must not share the header values of the client.
+			r.Header.Add("X-Org-ID", "modified")
+			if got := c.headers.Values("X-Org-ID"); len(got) > 0 && got[len(got)-1] == "modified" {
+				t.Errorf("client headers modified: %v", got)
+			}
+		})
+	}
+}
+
+func TestClient_BearerTokenRefresh(t *testing.T) {
+	errTokenFunc := errors.New("token func error")
+
+	// tokenFunc returns a stale token, unless a refresh is requested.
+	tokenFunc := func(_ context.Context, refresh bool) (string, error) {
+		if refresh {
+			return "fresh", nil
+		}
+		return "stale", nil
+	}
+
+	tests := []struct {
+		name         string
+		opt          Option
+		acceptToken  string
+		wantErr      error
+		wantRequests int
+	}{
+		{
+			name:         "StaticToken",
+			opt:          OptBearerToken("stale"),
+			acceptToken:  "fresh",
+			wantErr:      &httpError{Code: http.StatusUnauthorized},
+			wantRequests: 1,
+		},
+		{
+			name:         "TokenAccepted",
+			opt:          OptBearerTokenFunc(tokenFunc),
+			acceptToken:  "stale",
+			wantRequests: 1,
+		},
+		{
+			name:         "TokenRefreshed",
+			opt:          OptBearerTokenFunc(tokenFunc),
+			acceptToken:  "fresh",
+			wantRequests: 2,
+		},
+		{
+			name:         "TokenRejected",
+			opt:          OptBearerTokenFunc(tokenFunc),
+			acceptToken:  "other",
+			wantErr:      ErrTokenRejected,
+			wantRequests: 2,
+		},
+		{
+			name: "TokenFuncError",
+			opt: OptBearerTokenFunc(func(context.Context, bool) (string, error) {
+				return "", errTokenFunc
+			}),
+			wantErr:      errTokenFunc,
+			wantRequests: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int
+
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+
+				if got, want := r.Header.Get("Authorization"), "BEARER "+tt.acceptToken; got != want {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			t.Cleanup(s.Close)
+
+			c, err := NewClient(OptBaseURL(s.URL), tt.opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = c.DeleteBuildContext(context.Background(), "sha256.digest")
+
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Errorf("got error %v, want %v", got, want)
+			}
+
+			if got, want := requests, tt.wantRequests; got != want {
+				t.Errorf("got %v requests, want %v", got, want)
+			}
+		})
+	}
+}
+
+// rateLimited is an http.Handler that rejects requests as rate limited, until the number of
+// requests to each path in limits is exhausted, and delegates remaining requests to h.
+type rateLimited struct {
+	h          http.Handler
+	limits     map[string]int
+	retryAfter string
+	requests   int // Number of requests rejected.
+}
+
+func (rl *rateLimited) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rl.limits[r.URL.Path] > 0 {
+		rl.limits[r.URL.Path]--
+		rl.requests++
+
+		w.Header().Set("Retry-After", rl.retryAfter)
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+	rl.h.ServeHTTP(w, r)
+}
+
+func TestClient_RateLimit(t *testing.T) {
+	fsys := fstest.MapFS{"a": &fstest.MapFile{Data: []byte("a"), ModTime: testTime}}
+
+	id := newObjectID()
+
+	tests := []struct {
+		name         string
+		h            func(*testing.T) http.Handler
+		limits       map[string]int
+		opts         []Option
+		fn           func(context.Context, *Client) error
+		wantErr      error
+		wantRejected int
+	}{
+		{
+			name:   "Submit",
+			h:      func(t *testing.T) http.Handler { return &mockService{t: t, buildResponseCode: http.StatusCreated} },
+			limits: map[string]int{buildPath: 2},
+			fn: func(ctx context.Context, c *Client) error {
+				_, err := c.Submit(ctx, strings.NewReader(testDefinition))
+				return err
+			},
+			wantRejected: 2,
+		},
+		{
+			name:   "GetStatus",
+			h:      func(t *testing.T) http.Handler { return &mockService{t: t, statusResponseCode: http.StatusOK} },
+			limits: map[string]int{buildPath + "/" + id: 3},
+			fn: func(ctx context.Context, c *Client) error {
+				_, err := c.GetStatus(ctx, id)
+				return err
+			},
+			wantRejected: 3,
+		},
+		{
+			name:   "UploadBuildContext",
+			h:      func(t *testing.T) http.Handler { return &mockUploadBuildContext{t: t} },
+			limits: map[string]int{"/v1/build-context": 1, "/upload-here": 1},
+			fn: func(ctx context.Context, c *Client) error {
+				_, err := c.UploadBuildContext(ctx, []string{"a"}, optUploadBuildContextFS(fsys))
+				return err
+			},
+			wantRejected: 2,
+		},
+		{
+			name:   "Exhausted",
+			h:      func(t *testing.T) http.Handler { return &mockService{t: t, statusResponseCode: http.StatusOK} },
+			limits: map[string]int{buildPath + "/" + id: 3},
+			opts:   []Option{OptRateLimitRetries(1)},
+			fn: func(ctx context.Context, c *Client) error {
+				_, err := c.GetStatus(ctx, id)
+				return err
+			},
+			wantErr:      &httpError{Code: http.StatusTooManyRequests},
+			wantRejected: 2,
+		},
+		{
+			name:   "Disabled",
+			h:      func(t *testing.T) http.Handler { return &mockService{t: t, statusResponseCode: http.StatusOK} },
+			limits: map[string]int{buildPath + "/" + id: 1},
+			opts:   []Option{OptRateLimitRetries(0)},
+			fn: func(ctx context.Context, c *Client) error {
+				_, err := c.GetStatus(ctx, id)
+				return err
+			},
+			wantErr:      &httpError{Code: http.StatusTooManyRequests},
+			wantRejected: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := &rateLimited{h: tt.h(t), limits: tt.limits, retryAfter: "30"}
+
+			s := httptest.NewServer(rl)
+			t.Cleanup(s.Close)
+
+			var waits []time.Duration
+
+			// The requested delay is bounded by the maximum wait.
+			opts := []Option{
+				OptBaseURL(s.URL),
+				OptRateLimitMaxWait(time.Millisecond),
+				OptRateLimitFunc(func(d time.Duration) { waits = append(waits, d) }),
+			}
+
+			c, err := NewClient(append(opts, tt.opts...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = tt.fn(context.Background(), c)
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if err != nil {
+				if d, ok := RetryAfter(err); !ok || d != 30*time.Second {
+					t.Errorf("got retry delay %v (%v), want %v", d, ok, 30*time.Second)
+				}
+			}
+
+			if got, want := rl.requests, tt.wantRejected; got != want {
+				t.Errorf("got %v requests rejected, want %v", got, want)
+			}
+
+			// Each retry is reported.
+			wantWaits := tt.wantRejected
+			if err != nil {
+				wantWaits--
+			}
+			if got, want := len(waits), wantWaits; got != want {
+				t.Errorf("got %v waits reported, want %v", got, want)
+			}
+			for _, d := range waits {
+				if got, want := d, time.Millisecond; got != want {
+					t.Errorf("got wait %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestClient_RateLimitContext(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	t.Cleanup(s.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := NewClient(OptBaseURL(s.URL), OptRateLimitFunc(func(time.Duration) { cancel() }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Waiting to retry is abandoned when the context is cancelled.
+	if _, err := c.GetStatus(ctx, newObjectID()); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestOptRateLimitRetries(t *testing.T) {
+	if _, err := NewClient(OptRateLimitRetries(-1)); !errors.Is(err, errInvalidRetries) {
+		t.Errorf("got error %v, want %v", err, errInvalidRetries)
+	}
+}
@@ -6,28 +6,137 @@
 package client
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	jsonresp "github.com/sylabs/json-resp"
 )
 
+// ErrNotSupported is returned when an operation is not supported by the Build Service.
+var ErrNotSupported = errors.New("not supported by Build Service")
+
+// ErrNotFound matches errors returned when the Build Service responds with 404 Not Found.
+var ErrNotFound error = &httpError{Code: http.StatusNotFound}
+
+// ErrUnauthorized matches errors returned when the Build Service responds with 401 Unauthorized,
+// indicating the bearer token is missing, invalid or expired. Obtaining a fresh token may resolve
+// the error.
+var ErrUnauthorized error = &httpError{Code: http.StatusUnauthorized}
+
+// ErrForbidden matches errors returned when the Build Service responds with 403 Forbidden,
+// indicating the bearer token is valid, but does not permit the operation. Obtaining a fresh token
+// will not resolve the error.
+var ErrForbidden error = &httpError{Code: http.StatusForbidden}
+
+// Limits on the portion of an error response body read, and retained where the body does not
+// contain a JSON error.
+const (
+	maxErrorBodyRead   = 64 << 10
+	maxErrorBodyRetain = 512
+)
+
 // httpError represents an error returned from an HTTP server.
 type httpError struct {
-	Code int
-	err  error
+	Code       int
+	err        error
+	retryAfter *time.Duration // Delay requested by the server before retrying, if any.
+	requestID  string         // ID assigned to the request by the server, if any.
+	method     string         // Method of the request, if known.
+	path       string         // Path of the request URL, if known. The query is omitted.
+	body       string         // Start of the response body, where it does not contain a JSON error.
+	verbose    bool           // Include request details and body in Error.
+}
+
+// RetryAfter returns the delay the server requested before the request is retried, using the
+// "Retry-After" header. If the server did not request a delay, ok is false.
+func (e *httpError) RetryAfter() (d time.Duration, ok bool) {
+	if e.retryAfter == nil {
+		return 0, false
+	}
+	return *e.retryAfter, true
+}
+
+// RetryAfter returns the delay requested before retrying the request that resulted in err, where
+// err results from a response from the Build Service, or a server to which a build context is
+// uploaded, that includes a "Retry-After" header. Otherwise, ok is false.
+func RetryAfter(err error) (d time.Duration, ok bool) {
+	var he *httpError
+	if errors.As(err, &he) {
+		return he.RetryAfter()
+	}
+	return 0, false
+}
+
+// RequestID returns the ID assigned by the server to the request that resulted in err, where err
+// results from a response from the Build Service, or a server to which a build context is uploaded,
+// that includes an "X-Request-ID" or "X-Correlation-ID" header. Otherwise, ok is false.
+//
+// The ID identifies the request in server logs, so should be included in reports of failures.
+func RequestID(err error) (id string, ok bool) {
+	var he *httpError
+	if errors.As(err, &he) && he.requestID != "" {
+		return he.requestID, true
+	}
+	return "", false
+}
+
+// parseRetryAfter parses the value of a "Retry-After" header, which specifies either a number of
+// seconds or an HTTP date, relative to now. A date in the past is a delay of zero.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	return max(t.Sub(now), 0), true
 }
 
 // Unwrap returns the error wrapped by e.
 func (e *httpError) Unwrap() error { return e.err }
 
-// Error returns a human-readable representation of e.
+// Error returns a human-readable representation of e. If e is verbose, the request, request ID
+// and response body are included, where known.
 func (e *httpError) Error() string {
+	s := fmt.Sprintf("%v %v", e.Code, http.StatusText(e.Code))
 	if e.err != nil {
-		return fmt.Sprintf("%v %v: %v", e.Code, http.StatusText(e.Code), e.err.Error())
+		s += ": " + e.err.Error()
+	}
+
+	if !e.verbose {
+		return s
+	}
+
+	if e.err == nil && e.body != "" {
+		s += fmt.Sprintf(": %q", e.body)
+	}
+
+	var details []string
+	if e.method != "" || e.path != "" {
+		details = append(details, strings.TrimSpace(e.method+" "+e.path))
+	}
+	if e.requestID != "" {
+		details = append(details, "request ID "+e.requestID)
 	}
-	return fmt.Sprintf("%v %v", e.Code, http.StatusText(e.Code))
+	if len(details) > 0 {
+		s += " (" + strings.Join(details, ", ") + ")"
+	}
+	return s
 }
 
 // Is compares e against target. If target is a HTTPError with the same code as e, true is returned.
@@ -36,14 +145,40 @@ func (e *httpError) Is(target error) bool {
 	return ok && (t.Code == e.Code)
 }
 
-// errorFromResponse returns an HTTPError containing the status code and detailed error message (if
-// available) from res.
-func errorFromResponse(res *http.Response) error {
-	httpErr := httpError{Code: res.StatusCode}
+// errorFromResponse returns an HTTPError containing the status code, detailed error message,
+// requested retry delay, request ID, request and response body (if available) from res. If
+// c.verboseErrors is set, the error includes these details in its message.
+func (c *Client) errorFromResponse(res *http.Response) error {
+	httpErr := httpError{
+		Code:    res.StatusCode,
+		verbose: c.verboseErrors,
+	}
+
+	if d, ok := parseRetryAfter(res.Header.Get("Retry-After"), time.Now()); ok {
+		httpErr.retryAfter = &d
+	}
+
+	httpErr.requestID = res.Header.Get("X-Request-ID")
+	if httpErr.requestID == "" {
+		httpErr.requestID = res.Header.Get("X-Correlation-ID")
+	}
+
+	if req := res.Request; req != nil {
+		httpErr.method = req.Method
+		if req.URL != nil {
+			httpErr.path = req.URL.Path
+		}
+	}
+
+	if res.Body != nil {
+		b, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBodyRead))
 
-	var jerr *jsonresp.Error
-	if err := jsonresp.ReadError(res.Body); errors.As(err, &jerr) {
-		httpErr.err = errors.New(jerr.Message)
+		var jerr *jsonresp.Error
+		if err := jsonresp.ReadError(bytes.NewReader(b)); errors.As(err, &jerr) {
+			httpErr.err = errors.New(jerr.Message)
+		} else {
+			httpErr.body = strings.TrimSpace(string(b[:min(len(b), maxErrorBodyRetain)]))
+		}
 	}
 
 	return &httpErr
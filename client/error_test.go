@@ -6,9 +6,18 @@
 package client
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
+
+	jsonresp "github.com/sylabs/json-resp"
 )
 
 func TestHTTPError(t *testing.T) {
@@ -50,3 +59,283 @@ func TestHTTPError(t *testing.T) {
 		})
 	}
 }
+
+func TestClient_AuthErrors(t *testing.T) {
+	fsys := fstest.MapFS{"a": &fstest.MapFile{Data: []byte("a")}}
+
+	endpoints := []struct {
+		name string
+		fn   func(context.Context, *Client) error
+	}{
+		{"Submit", func(ctx context.Context, c *Client) error {
+			_, err := c.Submit(ctx, strings.NewReader("bootstrap: docker\nfrom: alpine\n"))
+			return err
+		}},
+		{"GetStatus", func(ctx context.Context, c *Client) error {
+			_, err := c.GetStatus(ctx, "id")
+			return err
+		}},
+		{"GetOutput", func(ctx context.Context, c *Client) error {
+			return c.GetOutput(ctx, "id", io.Discard)
+		}},
+		{"Cancel", func(ctx context.Context, c *Client) error {
+			return c.Cancel(ctx, "id")
+		}},
+		{"GetArtifact", func(ctx context.Context, c *Client) error {
+			return c.GetArtifact(ctx, "id", io.Discard)
+		}},
+		{"GetVersion", func(ctx context.Context, c *Client) error {
+			_, err := c.GetVersion(ctx)
+			return err
+		}},
+		{"GetBuilderArchitectures", func(ctx context.Context, c *Client) error {
+			_, err := c.GetBuilderArchitectures(ctx)
+			return err
+		}},
+		{"UploadBuildContext", func(ctx context.Context, c *Client) error {
+			_, err := c.UploadBuildContext(ctx, []string{"a"}, optUploadBuildContextFS(fsys))
+			return err
+		}},
+		{"UploadBuildContextStreaming", func(ctx context.Context, c *Client) error {
+			_, err := c.UploadBuildContext(ctx, []string{"a"}, optUploadBuildContextFS(fsys), OptUploadStreaming(true))
+			return err
+		}},
+		{"DeleteBuildContext", func(ctx context.Context, c *Client) error {
+			return c.DeleteBuildContext(ctx, "sha256.digest")
+		}},
+		{"ListBuildContexts", func(ctx context.Context, c *Client) error {
+			_, err := c.ListBuildContexts(ctx)
+			return err
+		}},
+	}
+
+	codes := []struct {
+		name             string
+		code             int
+		wantUnauthorized bool
+		wantForbidden    bool
+	}{
+		{"Unauthorized", http.StatusUnauthorized, true, false},
+		{"Forbidden", http.StatusForbidden, false, true},
+	}
+
+	for _, tc := range codes {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			if err := jsonresp.WriteError(w, "rejected", tc.code); err != nil {
+				t.Error(err)
+			}
+		}))
+		t.Cleanup(s.Close)
+
+		c, err := NewClient(OptBaseURL(s.URL), OptBearerToken(authToken))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, ep := range endpoints {
+			t.Run(ep.name+tc.name, func(t *testing.T) {
+				err := ep.fn(context.Background(), c)
+
+				if got, want := errors.Is(err, ErrUnauthorized), tc.wantUnauthorized; got != want {
+					t.Errorf("got unauthorized %v, want %v (error %v)", got, want, err)
+				}
+				if got, want := errors.Is(err, ErrForbidden), tc.wantForbidden; got != want {
+					t.Errorf("got forbidden %v, want %v (error %v)", got, want, err)
+				}
+			})
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"Missing", "", 0, false},
+		{"Seconds", "30", 30 * time.Second, true},
+		{"Zero", "0", 0, true},
+		{"NegativeSeconds", "-1", 0, false},
+		{"Date", now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second, true},
+		{"DatePast", now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+		{"Invalid", "soon", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseRetryAfter(tt.value, now)
+			if got, want := ok, tt.wantOK; got != want {
+				t.Fatalf("got ok %v, want %v", got, want)
+			}
+			if got, want := got, tt.want; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestErrorFromResponseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter string
+		want       time.Duration
+		wantOK     bool
+	}{
+		{"Missing", "", 0, false},
+		{"Seconds", "30", 30 * time.Second, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			if tt.retryAfter != "" {
+				rec.Header().Set("Retry-After", tt.retryAfter)
+			}
+			if err := jsonresp.WriteError(rec, "slow down", http.StatusTooManyRequests); err != nil {
+				t.Fatal(err)
+			}
+
+			err := fmt.Errorf("wrapped: %w", new(Client).errorFromResponse(rec.Result()))
+
+			if !errors.Is(err, &httpError{Code: http.StatusTooManyRequests}) {
+				t.Errorf("got error %v, want %v", err, http.StatusTooManyRequests)
+			}
+
+			got, ok := RetryAfter(err)
+			if got, want := ok, tt.wantOK; got != want {
+				t.Fatalf("got ok %v, want %v", got, want)
+			}
+			if got, want := got, tt.want; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+
+	if _, ok := RetryAfter(errors.New("other")); ok {
+		t.Errorf("got retry delay for error not from response")
+	}
+}
+
+func TestErrorFromResponse(t *testing.T) {
+	html := "<html><body><h1>502 Bad Gateway</h1></body></html>"
+
+	tests := []struct {
+		name          string
+		contentType   string
+		body          string
+		header        http.Header
+		verbose       bool
+		wantRequestID string
+		wantBody      string
+		wantMessage   string
+	}{
+		{
+			name:          "JSON",
+			contentType:   "application/json",
+			body:          `{"error":{"code":502,"message":"upstream unavailable"}}`,
+			header:        http.Header{"X-Request-Id": {"abc123"}},
+			wantRequestID: "abc123",
+			wantMessage:   "502 Bad Gateway: upstream unavailable",
+		},
+		{
+			name:          "JSONVerbose",
+			contentType:   "application/json",
+			body:          `{"error":{"code":502,"message":"upstream unavailable"}}`,
+			header:        http.Header{"X-Request-Id": {"abc123"}},
+			verbose:       true,
+			wantRequestID: "abc123",
+			wantMessage:   "502 Bad Gateway: upstream unavailable (POST /v1/build, request ID abc123)",
+		},
+		{
+			name:        "HTML",
+			contentType: "text/html",
+			body:        html + "\n",
+			wantBody:    html,
+			wantMessage: "502 Bad Gateway",
+		},
+		{
+			name:          "HTMLVerbose",
+			contentType:   "text/html",
+			body:          html + "\n",
+			header:        http.Header{"X-Correlation-Id": {"def456"}},
+			verbose:       true,
+			wantRequestID: "def456",
+			wantBody:      html,
+			wantMessage:   "502 Bad Gateway: \"" + html + "\" (POST /v1/build, request ID def456)",
+		},
+		{
+			name:        "HTMLTruncated",
+			contentType: "text/html",
+			body:        strings.Repeat("x", 2*maxErrorBodyRetain),
+			wantBody:    strings.Repeat("x", maxErrorBodyRetain),
+			wantMessage: "502 Bad Gateway",
+		},
+		{
+			name:        "Empty",
+			wantMessage: "502 Bad Gateway",
+		},
+		{
+			name:        "EmptyVerbose",
+			verbose:     true,
+			wantMessage: "502 Bad Gateway (POST /v1/build)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				for k, v := range tt.header {
+					w.Header()[k] = v
+				}
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				w.WriteHeader(http.StatusBadGateway)
+				io.WriteString(w, tt.body) //nolint:errcheck
+			}))
+			defer s.Close()
+
+			res, err := http.Post(s.URL+"/v1/build?token=secret", "application/json", strings.NewReader("{}"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			err = fmt.Errorf("wrapped: %w", (&Client{verboseErrors: tt.verbose}).errorFromResponse(res))
+
+			if !errors.Is(err, &httpError{Code: http.StatusBadGateway}) {
+				t.Errorf("got error %v, want %v", err, http.StatusBadGateway)
+			}
+
+			var he *httpError
+			if !errors.As(err, &he) {
+				t.Fatalf("got error %T, want %T", err, he)
+			}
+
+			if got, want := he.method, http.MethodPost; got != want {
+				t.Errorf("got method %v, want %v", got, want)
+			}
+			if got, want := he.path, "/v1/build"; got != want {
+				t.Errorf("got path %v, want %v", got, want)
+			}
+			if got, want := he.body, tt.wantBody; got != want {
+				t.Errorf("got body %q, want %q", got, want)
+			}
+			if got, want := he.Error(), tt.wantMessage; got != want {
+				t.Errorf("got message %q, want %q", got, want)
+			}
+
+			id, ok := RequestID(err)
+			if got, want := ok, tt.wantRequestID != ""; got != want {
+				t.Fatalf("got ok %v, want %v", got, want)
+			}
+			if got, want := id, tt.wantRequestID; got != want {
+				t.Errorf("got request ID %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,381 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+//go:build integration
+
+package client
+
+// The integration tests exercise a real Build Service, such as Singularity Container Services or
+// a (containerized) Singularity Enterprise instance, in order to detect protocol drift that mock
+// servers cannot. They are run using:
+//
+//	go test -tags integration -run Integration -v ./client/...
+//
+// and are configured by the following environment variables:
+//
+//	SYLABS_TEST_URL          Frontend URL, from which the Build Service URL is discovered.
+//	SYLABS_TEST_BUILD_URL    Build Service URL, used in place of discovery.
+//	SYLABS_TEST_AUTH_TOKEN   Access token.
+//	SYLABS_TEST_CA_CERT      PEM file containing additional CA certificates to trust.
+//	SYLABS_TEST_SKIP_VERIFY  If "true", skip TLS certificate verification.
+//	SYLABS_TEST_ARCH         Build architecture (default runtime.GOARCH).
+//	SYLABS_TEST_RUN_ID       Identifies the objects created by a run (default generated).
+//	SYLABS_TEST_TIMEOUT      Overall time limit (default 30m).
+//
+// If neither URL is set, the tests are skipped. Builds are ephemeral, so are removed by the Build
+// Service. Build contexts are deleted, and builds cancelled, as each test completes.
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sylabs/scs-build-client/internal/pkg/endpoints"
+)
+
+const (
+	defaultIntegrationTimeout = 30 * time.Minute
+
+	// integrationPollInterval is the interval at which build status is polled.
+	integrationPollInterval = 5 * time.Second
+)
+
+// integrationConfig describes the Build Service targeted by the integration tests.
+type integrationConfig struct {
+	buildURL string
+	arch     string
+	runID    string
+	timeout  time.Duration
+	c        *Client
+}
+
+// newRunID returns an identifier for a test run, which is included in the objects it creates, so
+// that they may be attributed to it.
+func newRunID(t *testing.T) string {
+	t.Helper()
+
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("scs-build-it-%v-%v", time.Now().UTC().Format("20060102T150405"), hex.EncodeToString(b))
+}
+
+// newIntegrationTransport returns a transport configured according to the environment.
+func newIntegrationTransport(t *testing.T) *http.Transport {
+	t.Helper()
+
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if name := os.Getenv("SYLABS_TEST_CA_CERT"); name != "" {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			t.Fatalf("no certificates found in %v", name)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if skip, _ := strconv.ParseBool(os.Getenv("SYLABS_TEST_SKIP_VERIFY")); skip {
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	tr.TLSClientConfig = tlsConfig
+	return tr
+}
+
+// newIntegrationConfig returns the configuration of the integration tests, skipping t if no Build
+// Service is configured.
+func newIntegrationConfig(t *testing.T) *integrationConfig {
+	t.Helper()
+
+	feURL := os.Getenv("SYLABS_TEST_URL")
+	buildURL := os.Getenv("SYLABS_TEST_BUILD_URL")
+	if feURL == "" && buildURL == "" {
+		t.Skip("integration tests not configured (set SYLABS_TEST_URL or SYLABS_TEST_BUILD_URL)")
+	}
+
+	tr := newIntegrationTransport(t)
+
+	if buildURL == "" {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		feCfg, err := endpoints.GetFrontendConfig(ctx, &http.Client{Transport: tr}, feURL)
+		if err != nil {
+			t.Fatalf("failed to discover Build Service: %v", err)
+		}
+		buildURL = feCfg.BuildAPI.URI
+	}
+
+	ic := &integrationConfig{
+		buildURL: buildURL,
+		arch:     os.Getenv("SYLABS_TEST_ARCH"),
+		runID:    os.Getenv("SYLABS_TEST_RUN_ID"),
+		timeout:  defaultIntegrationTimeout,
+	}
+
+	if ic.arch == "" {
+		ic.arch = runtime.GOARCH
+	}
+
+	if ic.runID == "" {
+		ic.runID = newRunID(t)
+	}
+
+	if s := os.Getenv("SYLABS_TEST_TIMEOUT"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			t.Fatalf("invalid SYLABS_TEST_TIMEOUT: %v", err)
+		}
+		ic.timeout = d
+	}
+
+	c, err := NewClient(
+		OptBaseURL(buildURL),
+		OptBearerToken(os.Getenv("SYLABS_TEST_AUTH_TOKEN")),
+		OptUserAgent("scs-build-client-integration/"+ic.runID),
+		OptHTTPTransport(tr),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ic.c = c
+
+	t.Logf("Build Service %v, architecture %v, run %v", buildURL, ic.arch, ic.runID)
+
+	return ic
+}
+
+// cleanupContext returns a context for cleanup, which is not cancelled along with the test.
+func cleanupContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 2*time.Minute)
+}
+
+// uploadContext creates a tree of files identifying the run, and uploads it as a build context,
+// which is deleted when t completes. It returns the directory containing the tree, and the digest
+// of the build context.
+func (ic *integrationConfig) uploadContext(ctx context.Context, t *testing.T) (string, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"run-id":       ic.runID + "\n",
+		"data/a.txt":   "a\n",
+		"data/b/b.txt": "b\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	paths := []string{filepath.Join(dir, "run-id"), filepath.Join(dir, "data")}
+
+	digest, err := ic.c.UploadBuildContext(ctx, paths, OptUploadReproducible(true))
+	if err != nil {
+		t.Fatalf("failed to upload build context: %v", err)
+	}
+
+	t.Cleanup(func() {
+		ctx, cancel := cleanupContext()
+		defer cancel()
+
+		if err := ic.c.DeleteBuildContext(ctx, digest); err != nil && !errors.Is(err, ErrNotFound) {
+			t.Errorf("failed to delete build context %v: %v", digest, err)
+		}
+	})
+
+	return dir, digest
+}
+
+// submit submits a build of def, which is cancelled when t completes, if still running.
+func (ic *integrationConfig) submit(ctx context.Context, t *testing.T, def string, opts ...BuildOption) *BuildInfo {
+	t.Helper()
+
+	opts = append([]BuildOption{OptBuildArchitecture(ic.arch)}, opts...)
+
+	bi, err := ic.c.Submit(ctx, strings.NewReader(def), opts...)
+	if err != nil {
+		t.Fatalf("failed to submit build: %v", err)
+	}
+	t.Logf("Submitted build %v", bi.ID())
+
+	t.Cleanup(func() {
+		ctx, cancel := cleanupContext()
+		defer cancel()
+
+		if bi, err := ic.c.GetStatus(ctx, bi.ID()); err == nil && !bi.IsComplete() {
+			_ = ic.c.Cancel(ctx, bi.ID())
+		}
+	})
+
+	return bi
+}
+
+// awaitComplete polls the status of the build with the specified ID until it is complete.
+func (ic *integrationConfig) awaitComplete(ctx context.Context, t *testing.T, id string) *BuildInfo {
+	t.Helper()
+
+	for {
+		bi, err := ic.c.GetStatus(ctx, id)
+		if err != nil {
+			t.Fatalf("failed to get build status: %v", err)
+		}
+		if bi.IsComplete() {
+			return bi
+		}
+
+		select {
+		case <-ctx.Done():
+			t.Fatalf("build %v not complete: %v", id, ctx.Err())
+		case <-time.After(integrationPollInterval):
+		}
+	}
+}
+
+func TestIntegration(t *testing.T) {
+	ic := newIntegrationConfig(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), ic.timeout)
+	defer cancel()
+
+	t.Run("Version", func(t *testing.T) {
+		v, err := ic.c.GetVersion(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v == "" {
+			t.Error("empty version")
+		}
+		t.Logf("Build Service version %v", v)
+	})
+
+	t.Run("BuildContext", func(t *testing.T) {
+		dir, digest := ic.uploadContext(ctx, t)
+
+		// Archives are reproducible, so an unchanged tree has the same digest.
+		again, err := ic.c.UploadBuildContext(ctx, []string{filepath.Join(dir, "run-id"), filepath.Join(dir, "data")}, OptUploadReproducible(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again != digest {
+			t.Errorf("got digest %v on re-upload, want %v", again, digest)
+		}
+
+		if err := ic.c.DeleteBuildContext(ctx, digest); err != nil {
+			t.Fatal(err)
+		}
+
+		bcs, err := ic.c.ListBuildContexts(ctx)
+		if errors.Is(err, ErrNotSupported) {
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, bc := range bcs {
+			if bc.Digest == digest {
+				t.Errorf("build context %v listed after deletion", digest)
+			}
+		}
+	})
+
+	t.Run("Build", func(t *testing.T) {
+		dir, digest := ic.uploadContext(ctx, t)
+
+		def := fmt.Sprintf(`bootstrap: docker
+from: alpine:3
+
+%%files
+    %[1]v/run-id /run-id
+    %[1]v/data /data
+
+%%labels
+    org.sylabs.scs-build-client.integration-run %[2]v
+
+%%post
+    echo "run $(cat /run-id)"
+    cat /data/a.txt /data/b/b.txt
+`, dir, ic.runID)
+
+		bi := ic.submit(ctx, t, def, OptBuildContext(digest), OptBuildWorkingDirectory(dir))
+
+		var out bytes.Buffer
+		if err := ic.c.GetOutput(ctx, bi.ID(), &out); err != nil {
+			t.Fatalf("failed to stream output: %v", err)
+		}
+
+		// Output shows the files in the build context were available to the build.
+		if want := "run " + ic.runID; !strings.Contains(out.String(), want) {
+			t.Errorf("output does not contain %q:\n%v", want, out.String())
+		}
+
+		bi = ic.awaitComplete(ctx, t, bi.ID())
+		if bi.ImageSize() <= 0 {
+			t.Fatalf("build failed:\n%v", out.String())
+		}
+
+		// GetArtifact verifies the image against the size and checksum reported.
+		var img bytes.Buffer
+		if err := ic.c.GetArtifact(ctx, bi.ID(), &img); err != nil {
+			t.Fatalf("failed to download image: %v", err)
+		}
+		if got, want := int64(img.Len()), bi.ImageSize(); got != want {
+			t.Errorf("got %v bytes, want %v", got, want)
+		}
+		if bi.ImageChecksum() == "" {
+			t.Error("no image checksum reported")
+		}
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		bi := ic.submit(ctx, t, fmt.Sprintf(`bootstrap: docker
+from: alpine:3
+
+%%labels
+    org.sylabs.scs-build-client.integration-run %v
+
+%%post
+    sleep 3600
+`, ic.runID))
+
+		if err := ic.c.Cancel(ctx, bi.ID()); err != nil {
+			t.Fatalf("failed to cancel build: %v", err)
+		}
+
+		bi = ic.awaitComplete(ctx, t, bi.ID())
+		if bi.ImageSize() > 0 {
+			t.Errorf("cancelled build produced image of %v bytes", bi.ImageSize())
+		}
+	})
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	jsonresp "github.com/sylabs/json-resp"
+)
+
+type listBuildsOptions struct {
+	limit     int
+	before    string
+	completed bool
+}
+
+type ListBuildsOption func(*listBuildsOptions) error
+
+var errInvalidLimit = errors.New("invalid limit")
+
+// OptListLimit sets the maximum number of builds returned. By default, the Build Service selects
+// the limit.
+func OptListLimit(n int) ListBuildsOption {
+	return func(lo *listBuildsOptions) error {
+		if n <= 0 {
+			return fmt.Errorf("%w: %v", errInvalidLimit, n)
+		}
+		lo.limit = n
+		return nil
+	}
+}
+
+// OptListBefore restricts results to builds submitted before the build with the specified ID. To
+// retrieve successive pages of results, specify the ID of the last build in the previous page.
+func OptListBefore(buildID string) ListBuildsOption {
+	return func(lo *listBuildsOptions) error {
+		lo.before = buildID
+		return nil
+	}
+}
+
+// OptListCompleted restricts results to builds that are complete.
+func OptListCompleted(b bool) ListBuildsOption {
+	return func(lo *listBuildsOptions) error {
+		lo.completed = b
+		return nil
+	}
+}
+
+// GetBuilds returns builds submitted by the caller, most recently submitted first. The context
+// controls the lifetime of the request.
+//
+// If the Build Service does not support listing builds, an error wrapping ErrNotSupported is
+// returned.
+func (c *Client) GetBuilds(ctx context.Context, opts ...ListBuildsOption) ([]BuildInfo, error) {
+	lo := listBuildsOptions{}
+
+	for _, opt := range opts {
+		if err := opt(&lo); err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+	}
+
+	q := url.Values{}
+	if lo.limit > 0 {
+		q.Set("limit", strconv.Itoa(lo.limit))
+	}
+	if lo.before != "" {
+		q.Set("before", lo.before)
+	}
+	if lo.completed {
+		q.Set("completed", "true")
+	}
+
+	ref := &url.URL{
+		Path:     "v1/build",
+		RawQuery: q.Encode(),
+	}
+
+	req, err := c.newRequest(ctx, http.MethodGet, ref, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	res, err := c.doWithRefresh(c.httpClient, req)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	defer res.Body.Close()
+
+	// Servers that predate listing of builds do not route GET requests to this endpoint.
+	if res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusMethodNotAllowed {
+		return nil, fmt.Errorf("%w: listing builds: %w", ErrNotSupported, c.errorFromResponse(res))
+	}
+
+	if res.StatusCode/100 != 2 { // non-2xx status code
+		return nil, fmt.Errorf("%w", c.errorFromResponse(res))
+	}
+
+	var rbis []rawBuildInfo
+	if err := jsonresp.ReadResponse(res.Body, &rbis); err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	bis := make([]BuildInfo, 0, len(rbis))
+	for _, rbi := range rbis {
+		bis = append(bis, BuildInfo{rbi})
+	}
+	return bis, nil
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetBuilds(t *testing.T) {
+	submitTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	builds := []rawBuildInfo{
+		{ID: "5", State: BuildStateBuilding, Arch: "amd64", SubmitTime: &submitTime},
+		{ID: "4", State: BuildStateSucceeded, Arch: "arm64", IsComplete: true},
+		{ID: "3", State: BuildStateQueued},
+		{ID: "2", State: BuildStateFailed, Arch: "amd64", IsComplete: true},
+		{ID: "1", State: BuildStateSucceeded, Arch: "amd64", IsComplete: true},
+	}
+
+	tests := []struct {
+		name         string
+		responseCode int
+		opts         []ListBuildsOption
+		wantIDs      []string
+		wantErr      error
+	}{
+		{
+			name:         "All",
+			responseCode: http.StatusOK,
+			wantIDs:      []string{"5", "4", "3", "2", "1"},
+		},
+		{
+			name:         "Limit",
+			responseCode: http.StatusOK,
+			opts:         []ListBuildsOption{OptListLimit(2)},
+			wantIDs:      []string{"5", "4"},
+		},
+		{
+			name:         "Before",
+			responseCode: http.StatusOK,
+			opts:         []ListBuildsOption{OptListLimit(2), OptListBefore("4")},
+			wantIDs:      []string{"3", "2"},
+		},
+		{
+			name:         "Completed",
+			responseCode: http.StatusOK,
+			opts:         []ListBuildsOption{OptListCompleted(true)},
+			wantIDs:      []string{"4", "2", "1"},
+		},
+		{
+			name:         "None",
+			responseCode: http.StatusOK,
+			opts:         []ListBuildsOption{OptListBefore("1")},
+			wantIDs:      []string{},
+		},
+		{
+			name:         "InvalidLimit",
+			responseCode: http.StatusOK,
+			opts:         []ListBuildsOption{OptListLimit(0)},
+			wantErr:      errInvalidLimit,
+		},
+		{
+			name:         "NotSupported",
+			responseCode: http.StatusMethodNotAllowed,
+			wantErr:      ErrNotSupported,
+		},
+		{
+			name:         "HTTPError",
+			responseCode: http.StatusInternalServerError,
+			wantErr:      &httpError{Code: http.StatusInternalServerError},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := mockService{
+				t:                t,
+				listResponseCode: tt.responseCode,
+				builds:           builds,
+			}
+			s := httptest.NewServer(&m)
+			defer s.Close()
+
+			c, err := NewClient(OptBaseURL(s.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			bis, err := c.GetBuilds(context.Background(), tt.opts...)
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if err != nil {
+				return
+			}
+
+			ids := []string{}
+			for _, bi := range bis {
+				ids = append(ids, bi.ID())
+			}
+			if got, want := ids, tt.wantIDs; !reflect.DeepEqual(got, want) {
+				t.Errorf("got IDs %v, want %v", got, want)
+			}
+
+			if len(bis) > 0 && bis[0].ID() == "5" {
+				if got, want := bis[0].Arch(), "amd64"; got != want {
+					t.Errorf("got arch %v, want %v", got, want)
+				}
+				if got, want := bis[0].SubmitTime(), submitTime; !got.Equal(want) {
+					t.Errorf("got submit time %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
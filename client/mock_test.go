@@ -7,11 +7,14 @@ package client
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -28,7 +31,11 @@ type mockService struct {
 	statusResponseCode int
 	imageResponseCode  int
 	cancelResponseCode int
+	listResponseCode   int
+	builds             []rawBuildInfo // Builds listed, most recently submitted first.
 	httpAddr           string
+	failBuild          bool // If set, builds are reported to have failed.
+	pendingStatus      int  // Number of status requests to report as incomplete.
 }
 
 var upgrader = websocket.Upgrader{}
@@ -45,6 +52,7 @@ const (
 	wsPath            = "/v1/build-ws/"
 	imagePath         = "/v1/image"
 	buildCancelSuffix = "/_cancel"
+	testDefinition    = "bootstrap: docker\nfrom: alpine:3\n"
 )
 
 func newResponse(m *mockService, id string, libraryRef string) rawBuildInfo {
@@ -56,13 +64,47 @@ func newResponse(m *mockService, id string, libraryRef string) rawBuildInfo {
 		libraryRef = "library://user/collection/image"
 	}
 
-	return rawBuildInfo{
-		ID:         id,
-		LibraryURL: libraryURL.String(),
-		LibraryRef: libraryRef,
-		IsComplete: true,
-		ImageSize:  1,
+	submitTime := time.Now().Add(-time.Minute)
+	startTime := submitTime.Add(10 * time.Second)
+	completeTime := startTime.Add(30 * time.Second)
+
+	if m.pendingStatus > 0 {
+		m.pendingStatus--
+
+		return rawBuildInfo{
+			ID:         id,
+			LibraryURL: libraryURL.String(),
+			LibraryRef: libraryRef,
+			State:      BuildStateBuilding,
+			SubmitTime: &submitTime,
+			StartTime:  &startTime,
+		}
+	}
+
+	rbi := rawBuildInfo{
+		ID:            id,
+		LibraryURL:    libraryURL.String(),
+		LibraryRef:    libraryRef,
+		IsComplete:    true,
+		ImageSize:     int64(len(imageContents)),
+		ImageChecksum: fmt.Sprintf("sha256.%x", sha256.Sum256([]byte(imageContents))),
+		State:         BuildStateSucceeded,
+		SubmitTime:    &submitTime,
+		StartTime:     &startTime,
+		CompleteTime:  &completeTime,
+		ExitCode:      new(int),
+	}
+
+	if m.failBuild {
+		exitCode := 1
+
+		rbi.ImageSize = 0
+		rbi.ImageChecksum = ""
+		rbi.State = BuildStateFailed
+		rbi.ExitCode = &exitCode
 	}
+
+	return rbi
 }
 
 func (m *mockService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -85,6 +127,17 @@ func (m *mockService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				m.t.Fatal(err)
 			}
 		}
+	} else if r.Method == http.MethodGet && r.URL.Path == buildPath {
+		// Mock list builds endpoint
+		if m.listResponseCode == http.StatusOK {
+			if err := jsonresp.WriteResponse(w, m.listBuilds(r.URL.Query()), m.listResponseCode); err != nil {
+				m.t.Fatal(err)
+			}
+		} else {
+			if err := jsonresp.WriteError(w, "", m.listResponseCode); err != nil {
+				m.t.Fatal(err)
+			}
+		}
 	} else if r.Method == http.MethodGet && strings.HasPrefix(r.RequestURI, buildPath) {
 		// Mock status endpoint
 		id := r.RequestURI[strings.LastIndexByte(r.RequestURI, '/')+1:]
@@ -125,6 +178,34 @@ func (m *mockService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// listBuilds returns the builds in m.builds selected by the query parameters q.
+func (m *mockService) listBuilds(q url.Values) []rawBuildInfo {
+	builds := m.builds
+
+	if before := q.Get("before"); before != "" {
+		for i, rbi := range builds {
+			if rbi.ID == before {
+				builds = builds[i+1:]
+				break
+			}
+		}
+	}
+
+	selected := []rawBuildInfo{}
+	for _, rbi := range builds {
+		if q.Get("completed") == "true" && !rbi.IsComplete {
+			continue
+		}
+		selected = append(selected, rbi)
+	}
+
+	if limit, err := strconv.Atoi(q.Get("limit")); err == nil && limit < len(selected) {
+		selected = selected[:limit]
+	}
+
+	return selected
+}
+
 func (m *mockService) ServeWebsocket(w http.ResponseWriter, r *http.Request) {
 	if m.wsResponseCode != http.StatusOK {
 		w.WriteHeader(m.wsResponseCode)
@@ -150,14 +231,6 @@ func TestBuild(t *testing.T) {
 	expiredCtx, cancel := context.WithDeadline(context.Background(), time.Now())
 	defer cancel()
 
-	// Create a temporary file for testing
-	f, err := os.CreateTemp("/tmp", "TestBuild")
-	if err != nil {
-		t.Fatalf("failed to create temp file: %v", err)
-	}
-	f.Close()
-	defer os.Remove(f.Name())
-
 	// Start a mock server
 	m := mockService{t: t}
 	mux := http.NewServeMux()
@@ -185,14 +258,14 @@ func TestBuild(t *testing.T) {
 		imageResponseCode   int
 		ctx                 context.Context //nolint:containedctx
 	}{
-		{"Success", true, true, true, f.Name(), "", http.StatusCreated, http.StatusOK, websocket.CloseNormalClosure, http.StatusOK, http.StatusOK, context.Background()},
-		{"SuccessLibraryRef", true, true, true, "library://user/collection/image", "", http.StatusCreated, http.StatusOK, websocket.CloseNormalClosure, http.StatusOK, http.StatusOK, context.Background()},
-		{"SuccessLibraryRefURL", true, true, true, "library://user/collection/image", m.httpAddr, http.StatusCreated, http.StatusOK, websocket.CloseNormalClosure, http.StatusOK, http.StatusOK, context.Background()},
-		{"AddBuildFailure", false, false, false, f.Name(), "", http.StatusUnauthorized, http.StatusOK, websocket.CloseNormalClosure, http.StatusOK, http.StatusOK, context.Background()},
-		{"WebsocketFailure", true, false, true, f.Name(), "", http.StatusCreated, http.StatusUnauthorized, websocket.CloseNormalClosure, http.StatusOK, http.StatusOK, context.Background()},
-		{"WebsocketAbnormalClosure", true, false, true, f.Name(), "", http.StatusCreated, http.StatusOK, websocket.CloseAbnormalClosure, http.StatusOK, http.StatusOK, context.Background()},
-		{"GetStatusFailure", true, true, false, f.Name(), "", http.StatusCreated, http.StatusOK, websocket.CloseNormalClosure, http.StatusUnauthorized, http.StatusOK, context.Background()},
-		{"ContextExpired", false, false, false, f.Name(), "", http.StatusCreated, http.StatusOK, websocket.CloseNormalClosure, http.StatusOK, http.StatusOK, expiredCtx},
+		{"Success", true, true, true, "", "", http.StatusCreated, http.StatusOK, websocket.CloseNormalClosure, http.StatusOK, http.StatusOK, context.Background()},
+		{"SuccessLibraryRef", true, true, true, "library://user/collection/image:latest", "", http.StatusCreated, http.StatusOK, websocket.CloseNormalClosure, http.StatusOK, http.StatusOK, context.Background()},
+		{"SuccessLibraryRefURL", true, true, true, "library://user/collection/image:latest", m.httpAddr, http.StatusCreated, http.StatusOK, websocket.CloseNormalClosure, http.StatusOK, http.StatusOK, context.Background()},
+		{"AddBuildFailure", false, false, false, "", "", http.StatusUnauthorized, http.StatusOK, websocket.CloseNormalClosure, http.StatusOK, http.StatusOK, context.Background()},
+		{"WebsocketFailure", true, false, true, "", "", http.StatusCreated, http.StatusUnauthorized, websocket.CloseNormalClosure, http.StatusOK, http.StatusOK, context.Background()},
+		{"WebsocketAbnormalClosure", true, false, true, "", "", http.StatusCreated, http.StatusOK, websocket.CloseAbnormalClosure, http.StatusOK, http.StatusOK, context.Background()},
+		{"GetStatusFailure", true, true, false, "", "", http.StatusCreated, http.StatusOK, websocket.CloseNormalClosure, http.StatusUnauthorized, http.StatusOK, context.Background()},
+		{"ContextExpired", false, false, false, "", "", http.StatusCreated, http.StatusOK, websocket.CloseNormalClosure, http.StatusOK, http.StatusOK, expiredCtx},
 	}
 
 	// Loop over test cases
@@ -214,7 +287,7 @@ func TestBuild(t *testing.T) {
 			m.imageResponseCode = tt.imageResponseCode
 
 			// Do it!
-			bd, err := c.Submit(tt.ctx, strings.NewReader(""),
+			bd, err := c.Submit(tt.ctx, strings.NewReader(testDefinition),
 				OptBuildLibraryRef(tt.imagePath),
 			)
 			if !tt.expectSubmitSuccess {
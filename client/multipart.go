@@ -0,0 +1,233 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultUploadPartSize is the size of the parts in which a build context archive is uploaded,
+	// where the Build Service supports multipart uploads. Archives no larger than this are
+	// uploaded using a single request. See OptUploadParts.
+	defaultUploadPartSize = 64 << 20
+
+	// defaultUploadConcurrency is the number of parts of a build context archive uploaded at once.
+	// See OptUploadParts.
+	defaultUploadConcurrency = 4
+)
+
+// multipartPart is a part of a multipart build context upload.
+type multipartPart struct {
+	Number   int    `json:"number"`
+	Location string `json:"location"`
+
+	// Complete is set by the Build Service if the part was received by an earlier upload of the
+	// same build context, in which case the part is not uploaded again.
+	Complete bool   `json:"complete,omitempty"`
+	ETag     string `json:"etag,omitempty"`
+}
+
+// multipartUpload is a multipart build context upload, as advertised by the Build Service in
+// response to a request for an upload location.
+type multipartUpload struct {
+	ID       string          `json:"id"`
+	PartSize int64           `json:"partSize"`
+	Parts    []multipartPart `json:"parts"`
+}
+
+// validate returns an error if mu does not describe the parts of an archive of the specified
+// size, numbered in order from 1.
+func (mu *multipartUpload) validate(size int64) error {
+	if mu.ID == "" || mu.PartSize <= 0 {
+		return errMalformedUploadResponse
+	}
+
+	if n := (size + mu.PartSize - 1) / mu.PartSize; int64(len(mu.Parts)) != n {
+		return fmt.Errorf("%w: got %v parts, want %v", errMalformedUploadResponse, len(mu.Parts), n)
+	}
+
+	for i, p := range mu.Parts {
+		if p.Number != i+1 || (!p.Complete && p.Location == "") {
+			return fmt.Errorf("%w: invalid part %v", errMalformedUploadResponse, p.Number)
+		}
+	}
+	return nil
+}
+
+// putPart uploads part p of mu, read from ra, retrying transient failures as per
+// putBuildContextRetry. On success, p is marked complete.
+func (c *Client) putPart(ctx context.Context, mu *multipartUpload, p *multipartPart, ra io.ReaderAt, size int64, uo uploadBuildContextOptions) error {
+	loc, err := url.Parse(p.Location)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	off := int64(p.Number-1) * mu.PartSize
+	n := min(mu.PartSize, size-off)
+
+	backoff := uo.backoff
+
+	for attempt := 1; ; attempt++ {
+		etag, err := c.putBuildContext(ctx, loc, io.NewSectionReader(ra, off, n), n, uo)
+		if err == nil {
+			p.Complete, p.ETag = true, etag
+			return nil
+		}
+
+		if ctx.Err() != nil || !retryableUploadError(err) || attempt >= uo.attempts {
+			return fmt.Errorf("part %v: %w", p.Number, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("part %v: %w", p.Number, err)
+		case <-time.After(c.uploadRetryWait(err, backoff)):
+		}
+		backoff *= 2
+	}
+}
+
+// putParts uploads the parts of mu that are not complete, read from ra, with up to uo.concurrency
+// parts uploaded at once. If a part cannot be uploaded, uploads of the remaining parts are
+// abandoned, and the first error encountered is returned.
+func (c *Client) putParts(ctx context.Context, mu *multipartUpload, ra io.ReaderAt, size int64, uo uploadBuildContextOptions) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	parts := make(chan *multipartPart)
+
+	var (
+		wg       sync.WaitGroup
+		errMu    sync.Mutex
+		firstErr error
+	)
+
+	for range uo.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for p := range parts {
+				if err := c.putPart(ctx, mu, p, ra, size, uo); err != nil {
+					errMu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					errMu.Unlock()
+
+					cancel()
+				}
+			}
+		}()
+	}
+
+	for i := range mu.Parts {
+		if p := &mu.Parts[i]; !p.Complete && ctx.Err() == nil {
+			select {
+			case parts <- p:
+			case <-ctx.Done():
+			}
+		}
+	}
+	close(parts)
+
+	wg.Wait()
+
+	return firstErr
+}
+
+// completeMultipartUpload notifies the Build Service that all parts of mu have been uploaded, so
+// that it can assemble them and verify the resulting build context.
+func (c *Client) completeMultipartUpload(ctx context.Context, mu *multipartUpload) error {
+	ref := &url.URL{
+		Path: "v1/build-context/uploads/" + mu.ID + "/_complete",
+	}
+
+	type part struct {
+		Number int    `json:"number"`
+		ETag   string `json:"etag,omitempty"`
+	}
+
+	body := struct {
+		Parts []part `json:"parts"`
+	}{}
+	for _, p := range mu.Parts {
+		body.Parts = append(body.Parts, part{p.Number, p.ETag})
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPut, ref, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := c.doWithRefresh(c.buildContextHTTPClient, req)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 { // non-2xx status code
+		return fmt.Errorf("%w", c.errorFromResponse(res))
+	}
+	return nil
+}
+
+var errMultipartNotResumed = errors.New("multipart upload not resumed")
+
+// putBuildContextMultipart uploads the archive read from ra, of the specified size and digest, in
+// the parts described by mu, and asks the Build Service to assemble them. Parts that the Build
+// Service reports complete, having been received by an earlier upload of the same build context,
+// are not uploaded again, so an upload that failed partway through can be resumed.
+//
+// Pre-signed part locations expire, so if a part location is rejected as unauthorized or
+// forbidden, fresh locations are obtained, and the upload is resumed. Up to uo.attempts attempts
+// are made.
+func (c *Client) putBuildContextMultipart(ctx context.Context, mu *multipartUpload, ra io.ReaderAt, size int64, digest string, uo uploadBuildContextOptions) error {
+	for attempt := 1; ; attempt++ {
+		err := c.putParts(ctx, mu, ra, size, uo)
+		if err == nil {
+			break
+		}
+
+		expired := errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrForbidden)
+		if ctx.Err() != nil || !expired || attempt >= uo.attempts {
+			return err
+		}
+
+		ul, lerr := c.getBuildContextUploadLocation(ctx, size, digest, uo.compression, uo.partSize)
+		if errors.Is(lerr, errContextAlreadyPresent) {
+			return nil
+		}
+		if lerr != nil {
+			return fmt.Errorf("%w (failed to get new upload location: %w)", err, lerr)
+		}
+		if ul.multipart == nil || ul.multipart.ID != mu.ID {
+			return fmt.Errorf("%w (%w)", err, errMultipartNotResumed)
+		}
+		mu = ul.multipart
+	}
+
+	if err := c.completeMultipartUpload(ctx, mu); err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	return nil
+}
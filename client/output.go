@@ -6,73 +6,288 @@
 package client
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// OutputEvent is a message of build output.
+type OutputEvent struct {
+	Message     []byte    // Message payload. If lines are split, the terminating newline is removed.
+	Received    time.Time // Time at which the message was received by the client.
+	MessageType int       // Websocket message type (websocket.TextMessage or websocket.BinaryMessage).
+}
+
+const (
+	// defaultOutputPingInterval is the interval at which pings are sent to the server while
+	// streaming output, so that intermediaries do not consider the connection idle.
+	defaultOutputPingInterval = 30 * time.Second
+
+	// controlWriteTimeout is the time limit to write a control message, such as a ping or pong.
+	controlWriteTimeout = 10 * time.Second
+)
+
+type outputOptions struct {
+	splitLines   bool
+	readTimeout  time.Duration
+	pingInterval time.Duration
+	keepBuild    bool
+}
+
+type OutputOption func(*outputOptions) error
+
+// OptOutputSplitLines instructs GetOutputEvents to emit an event per line of output, rather than per
+// message received. A line split across messages is emitted once complete, with the receive time of
+// the message in which it began.
+func OptOutputSplitLines(b bool) OutputOption {
+	return func(oo *outputOptions) error {
+		oo.splitLines = b
+		return nil
+	}
+}
+
+// OptOutputReadTimeout sets the period within which a message, ping or pong must be received from
+// the server, after which streaming fails. Pings sent by the client (see OptOutputPingInterval)
+// elicit pongs from the server, so a build that produces no output does not time out, provided
+// the server answers pings. A timeout of zero, the default, means no timeout.
+func OptOutputReadTimeout(d time.Duration) OutputOption {
+	return func(oo *outputOptions) error {
+		oo.readTimeout = d
+		return nil
+	}
+}
+
+// OptOutputCancelBuild sets whether the build is cancelled if the context is done while streaming
+// output. Where it is not, only the output stream is closed. Defaults to true.
+func OptOutputCancelBuild(b bool) OutputOption {
+	return func(oo *outputOptions) error {
+		oo.keepBuild = !b
+		return nil
+	}
+}
+
+// OptOutputPingInterval sets the interval at which pings are sent to the server while streaming
+// output. An interval of zero disables pings. Defaults to 30s.
+func OptOutputPingInterval(d time.Duration) OutputOption {
+	return func(oo *outputOptions) error {
+		oo.pingInterval = d
+		return nil
+	}
+}
+
+// lineSplitter splits output events into lines, which are passed to fn.
+type lineSplitter struct {
+	fn      func(OutputEvent) error
+	partial *OutputEvent // Incomplete line, if any.
+}
+
+// emit passes each complete line in e to ls.fn, retaining any incomplete line.
+func (ls *lineSplitter) emit(e OutputEvent) error {
+	for b := e.Message; len(b) > 0; {
+		if ls.partial == nil {
+			ls.partial = &OutputEvent{Received: e.Received, MessageType: e.MessageType}
+		}
+
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			ls.partial.Message = append(ls.partial.Message, b...)
+			return nil
+		}
+
+		ls.partial.Message = append(ls.partial.Message, b[:i]...)
+		b = b[i+1:]
+
+		if err := ls.flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush passes the incomplete line to ls.fn, if any.
+func (ls *lineSplitter) flush() error {
+	if ls.partial == nil {
+		return nil
+	}
+
+	e := *ls.partial
+	ls.partial = nil
+	return ls.fn(e)
+}
+
 // GetOutput streams build output for the provided buildID to w. The context controls the lifetime
 // of the request.
-func (c *Client) GetOutput(ctx context.Context, buildID string, w io.Writer) error {
+func (c *Client) GetOutput(ctx context.Context, buildID string, w io.Writer) (err error) {
+	ctx, span := c.startSpan(ctx, "GetOutput")
+	span.SetAttribute(AttributeBuildID, buildID)
+	defer func() { span.End(err) }()
+
+	return c.getOutputEvents(ctx, buildID, func(e OutputEvent) error {
+		if e.MessageType != websocket.TextMessage {
+			return nil
+		}
+
+		if _, err := io.Copy(w, bytes.NewReader(e.Message)); err != nil {
+			return fmt.Errorf("failed to copy output: %w", err)
+		}
+		return nil
+	})
+}
+
+// websocketURL returns the URL of the websocket that streams output for the provided buildID. The
+// URL is resolved against the base URL in the same way as other requests, so that any path prefix
+// in the base URL is preserved, and the scheme is then mapped to its websocket equivalent.
+func (c *Client) websocketURL(buildID string) *url.URL {
 	u := c.baseURL.ResolveReference(&url.URL{
 		Path: "v1/build-ws/" + buildID,
 	})
 
-	wsScheme := "ws"
-	if c.baseURL.Scheme == "https" {
-		wsScheme = "wss"
+	if u.Scheme == "https" {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
 	}
-	u.Scheme = wsScheme
+
+	return u
+}
+
+// GetOutputEvents streams build output for the provided buildID, calling fn for each message
+// received. If fn returns an error, streaming stops and the error is returned. The context
+// controls the lifetime of the request.
+//
+// By default, fn is called once per message. To call fn once per line of output, consider using
+// OptOutputSplitLines.
+func (c *Client) GetOutputEvents(ctx context.Context, buildID string, fn func(OutputEvent) error, opts ...OutputOption) (err error) {
+	ctx, span := c.startSpan(ctx, "GetOutputEvents")
+	span.SetAttribute(AttributeBuildID, buildID)
+	defer func() { span.End(err) }()
+
+	return c.getOutputEvents(ctx, buildID, fn, opts...)
+}
+
+// getOutputEvents streams build output for the provided buildID, as per GetOutputEvents.
+func (c *Client) getOutputEvents(ctx context.Context, buildID string, fn func(OutputEvent) error, opts ...OutputOption) error {
+	oo := outputOptions{
+		pingInterval: defaultOutputPingInterval,
+	}
+
+	for _, opt := range opts {
+		if err := opt(&oo); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+	}
+
+	emit, flush := fn, func() error { return nil }
+	if oo.splitLines {
+		ls := &lineSplitter{fn: fn}
+		emit, flush = ls.emit, ls.flush
+	}
+
+	u := c.websocketURL(buildID)
 
 	h := http.Header{}
-	c.setRequestHeaders(h)
+	if err := c.setRequestHeaders(ctx, h, false); err != nil {
+		return fmt.Errorf("%w", err)
+	}
 
 	// Clone default websocket dialer
 	dialer := *websocket.DefaultDialer
 
+	// Use the same proxy as HTTP requests.
+	if tr, ok := c.transport.(*http.Transport); ok {
+		dialer.Proxy = tr.Proxy
+	}
+
 	// Clone TLS configuration for websocket protocol such as to not interfere with http protocol TLS configuration
 	// (ref: https://github.com/gorilla/websocket/issues/601)
-	if tr, ok := c.httpClient.Transport.(*http.Transport); ok && tr.TLSClientConfig != nil {
+	if tr, ok := c.transport.(*http.Transport); ok && tr.TLSClientConfig != nil {
 		tlsConfig := &tls.Config{
-			InsecureSkipVerify: tr.TLSClientConfig.InsecureSkipVerify,
-			RootCAs:            tr.TLSClientConfig.RootCAs,
+			InsecureSkipVerify:   tr.TLSClientConfig.InsecureSkipVerify,
+			RootCAs:              tr.TLSClientConfig.RootCAs,
+			Certificates:         tr.TLSClientConfig.Certificates,
+			GetClientCertificate: tr.TLSClientConfig.GetClientCertificate,
+			MinVersion:           tr.TLSClientConfig.MinVersion,
+			MaxVersion:           tr.TLSClientConfig.MaxVersion,
+			CipherSuites:         tr.TLSClientConfig.CipherSuites,
+			VerifyConnection:     tr.TLSClientConfig.VerifyConnection,
 		}
 		dialer.TLSClientConfig = tlsConfig.Clone()
 	}
 
+	// Apply timeouts, unless the transport was supplied by the caller. The handshake timeout spans
+	// establishing the connection, the TLS handshake, and receiving the response to the upgrade
+	// request.
+	if tc := c.timeouts; tc != nil {
+		if d := tc.Dial; d > 0 {
+			dialer.NetDialContext = (&net.Dialer{Timeout: d}).DialContext
+		}
+		if d := tc.ResponseHeader; d > 0 {
+			dialer.HandshakeTimeout = tc.Dial + tc.TLSHandshake + d
+		} else {
+			dialer.HandshakeTimeout = 0
+		}
+	}
+
 	ws, resp, err := dialer.DialContext(ctx, u.String(), h)
+
+	var rec *recording
+	if c.recorder != nil {
+		rec = c.recorder.newWebsocketRecording(u, h, resp, err)
+		defer func() { rec.finish(nil) }()
+	}
+
 	if err != nil {
-		return fmt.Errorf("failed to dial: %w", err)
+		if resp != nil {
+			// Include the status, so that the handshake being rejected (for example, as unauthorized)
+			// can be distinguished.
+			defer resp.Body.Close()
+			return fmt.Errorf("failed to dial %v: %w (%w)", u.Redacted(), err, c.errorFromResponse(resp))
+		}
+		return fmt.Errorf("failed to dial %v: %w", u.Redacted(), err)
 	}
 	defer resp.Body.Close()
 	defer ws.Close()
 
+	// Messages are read in a separate goroutine, and queued until passed to emit, so that control
+	// messages such as pings continue to be answered while emit is blocked.
+	stop := make(chan struct{})
+	defer close(stop)
+
+	q := newOutputQueue()
+
+	go func() {
+		q.close(readOutput(ws, rec, oo.readTimeout, q.push))
+	}()
+
+	if oo.pingInterval > 0 {
+		go sendPings(ws, oo.pingInterval, stop)
+	}
+
 	errChan := make(chan error)
 
 	go func() {
 		defer close(errChan)
 		errChan <- func() error {
 			for {
-				// Read from websocket
-				mt, r, err := ws.NextReader()
-				if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
-					return nil
-				} else if err != nil {
-					return fmt.Errorf("failed to read output: %w", err)
-				}
-
-				if mt != websocket.TextMessage {
-					continue
+				e, ok, err := q.next()
+				if err != nil {
+					return err
+				} else if !ok {
+					return flush()
 				}
 
-				if _, err := io.Copy(w, r); err != nil {
-					return fmt.Errorf("failed to copy output: %w", err)
+				if err := emit(e); err != nil {
+					return err
 				}
 			}
 		}()
@@ -80,10 +295,12 @@ func (c *Client) GetOutput(ctx context.Context, buildID string, w io.Writer) err
 
 	select {
 	case <-ctx.Done():
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		if !oo.keepBuild {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
 
-		_ = c.Cancel(ctx, buildID) //nolint:contextcheck
+			_ = c.Cancel(ctx, buildID) //nolint:contextcheck
+		}
 
 		ws.Close()
 
@@ -93,3 +310,139 @@ func (c *Client) GetOutput(ctx context.Context, buildID string, w io.Writer) err
 		return err
 	}
 }
+
+// outputQueue is a queue of output events without a bound on its length, so that messages
+// continue to be read however slowly they are consumed.
+type outputQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	events []OutputEvent
+	closed bool
+	err    error
+}
+
+// newOutputQueue returns an empty outputQueue.
+func newOutputQueue() *outputQueue {
+	q := &outputQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push appends e to the queue.
+func (q *outputQueue) push(e OutputEvent) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.events = append(q.events, e)
+	q.cond.Signal()
+}
+
+// close marks the end of the queue. Once queued events are consumed, next returns err.
+func (q *outputQueue) close(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.err = err
+	q.cond.Broadcast()
+}
+
+// next removes and returns the event at the head of the queue, blocking until one is available. If
+// the queue is closed and empty, ok is false, and err is the error passed to close.
+func (q *outputQueue) next() (e OutputEvent, ok bool, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.events) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+
+	if len(q.events) == 0 {
+		return OutputEvent{}, false, q.err
+	}
+
+	e = q.events[0]
+	q.events[0] = OutputEvent{}
+	q.events = q.events[1:]
+	return e, true, nil
+}
+
+// readOutput reads messages from ws, and passes them to push, until the websocket is closed.
+// Messages fragmented across continuation frames are reassembled. Pings are answered as they are
+// read.
+//
+// push must not block, so that control messages are answered regardless of how quickly output is
+// consumed. See outputQueue.
+//
+// If readTimeout is positive, reading fails unless a message, ping or pong is received within
+// readTimeout.
+func readOutput(ws *websocket.Conn, rec *recording, readTimeout time.Duration, push func(OutputEvent)) error {
+	extendDeadline := func() {
+		if readTimeout > 0 {
+			_ = ws.SetReadDeadline(time.Now().Add(readTimeout))
+		}
+	}
+
+	ws.SetPingHandler(func(data string) error {
+		extendDeadline()
+
+		err := ws.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(controlWriteTimeout))
+		if errors.Is(err, websocket.ErrCloseSent) {
+			return nil
+		}
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			return nil
+		}
+		return err
+	})
+
+	ws.SetPongHandler(func(string) error {
+		extendDeadline()
+		return nil
+	})
+
+	for {
+		extendDeadline()
+
+		mt, r, err := ws.NextReader()
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read output: %w", err)
+		}
+
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("failed to read output: %w", err)
+		}
+
+		if rec != nil {
+			rec.addFrame(mt, b)
+		}
+
+		push(OutputEvent{
+			Message:     b,
+			Received:    time.Now(),
+			MessageType: mt,
+		})
+	}
+}
+
+// sendPings sends a ping to ws at the specified interval, until stop is closed or a ping cannot be
+// sent.
+func sendPings(ws *websocket.Conn, interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			if err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(controlWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -6,10 +6,18 @@
 package client
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -126,3 +134,683 @@ func TestOutput(t *testing.T) {
 		})
 	}
 }
+
+func TestClient_websocketURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		baseURL string
+		want    string
+	}{
+		{"HTTP", "http://build.example.com", "ws://build.example.com/v1/build-ws/id"},
+		{"HTTPS", "https://build.example.com", "wss://build.example.com/v1/build-ws/id"},
+		{"Port", "https://build.example.com:8443", "wss://build.example.com:8443/v1/build-ws/id"},
+		{"Prefix", "https://gw.example.com/builder", "wss://gw.example.com/builder/v1/build-ws/id"},
+		{"PrefixSlash", "https://gw.example.com/builder/", "wss://gw.example.com/builder/v1/build-ws/id"},
+		{"NestedPrefix", "http://gw.example.com/a/b/", "ws://gw.example.com/a/b/v1/build-ws/id"},
+		{"EscapedPrefix", "https://gw.example.com/a%2Fb", "wss://gw.example.com/a%2Fb/v1/build-ws/id"},
+		{"Query", "https://gw.example.com/builder/?x=y", "wss://gw.example.com/builder/v1/build-ws/id"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewClient(OptBaseURL(tt.baseURL))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := c.websocketURL("id").String(), tt.want; got != want {
+				t.Errorf("got URL %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestOutputPrefixedBaseURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		mountPath string
+		basePath  string
+		wantErr   bool
+	}{
+		{"Prefix", "/builder", "/builder", false},
+		{"PrefixSlash", "/builder", "/builder/", false},
+		{"NestedPrefix", "/a/b", "/a/b/", false},
+		{"MissingPrefix", "/builder", "", true},
+	}
+
+	for _, useTLS := range []bool{true, false} {
+		name := "WithoutTLS"
+		if useTLS {
+			name = "WithTLS"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					m := mockService{t: t, wsResponseCode: http.StatusOK, wsCloseCode: websocket.CloseNormalClosure}
+
+					// Mount the websocket endpoint under the prefix only.
+					mux := http.NewServeMux()
+					mux.HandleFunc(tt.mountPath+wsPath, m.ServeWebsocket)
+
+					var opts []Option
+
+					var s *httptest.Server
+					if useTLS {
+						s = httptest.NewTLSServer(mux)
+						opts = append(opts, OptHTTPTransport(s.Client().Transport))
+					} else {
+						s = httptest.NewServer(mux)
+					}
+					t.Cleanup(s.Close)
+
+					c, err := NewClient(append(opts, OptBaseURL(s.URL+tt.basePath))...)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					var b bytes.Buffer
+
+					err = c.GetOutput(context.Background(), "id", &b)
+
+					if tt.wantErr {
+						if err == nil {
+							t.Fatal("unexpected success")
+						}
+
+						// The error must identify the URL dialed, and the response received.
+						if got, want := err.Error(), c.websocketURL("id").String(); !strings.Contains(got, want) {
+							t.Errorf("got error %q, want URL %v", got, want)
+						}
+						if got, want := err.Error(), "404 Not Found"; !strings.Contains(got, want) {
+							t.Errorf("got error %q, want status %v", got, want)
+						}
+						return
+					}
+
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if got, want := b.String(), stdoutContents; got != want {
+						t.Errorf("got output %q, want %q", got, want)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestOutputBearerTokenFunc(t *testing.T) {
+	errTokenFunc := errors.New("token func error")
+
+	// The handler runs in the server's goroutines, so access to received is synchronized.
+	var (
+		mu       sync.Mutex
+		received []string
+	)
+
+	reset := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		received = nil
+	}
+
+	requests := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), received...)
+	}
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received = append(received, r.Header.Get("Authorization"))
+		mu.Unlock()
+
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("failed to upgrade websocket: %v", err)
+			return
+		}
+		defer ws.Close()
+
+		if err := ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")); err != nil {
+			t.Errorf("failed to close websocket: %v", err)
+		}
+	}))
+	t.Cleanup(s.Close)
+
+	t.Run("Fresh", func(t *testing.T) {
+		reset()
+
+		// Return a new token on each call.
+		var calls int
+		c, err := NewClient(OptBaseURL(s.URL), OptBearerTokenFunc(func(context.Context, bool) (string, error) {
+			calls++
+			return fmt.Sprintf("token%d", calls), nil
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 2; i++ {
+			if err := c.GetOutput(context.Background(), "id", io.Discard); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if got, want := requests(), []string{"BEARER token1", "BEARER token2"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		reset()
+
+		c, err := NewClient(OptBaseURL(s.URL), OptBearerTokenFunc(func(context.Context, bool) (string, error) {
+			return "", errTokenFunc
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.GetOutput(context.Background(), "id", io.Discard); !errors.Is(err, errTokenFunc) {
+			t.Errorf("got error %v, want %v", err, errTokenFunc)
+		}
+
+		if got := len(requests()); got != 0 {
+			t.Errorf("got %v requests, want 0", got)
+		}
+	})
+}
+
+func TestGetOutputEvents(t *testing.T) {
+	errStop := errors.New("stop")
+
+	type message struct {
+		mt int
+		b  string
+	}
+
+	messages := []message{
+		{websocket.TextMessage, "a\nb"},
+		{websocket.TextMessage, "c\n"},
+		{websocket.BinaryMessage, "\x00\n"},
+		{websocket.TextMessage, "d"},
+	}
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("failed to upgrade websocket: %v", err)
+			return
+		}
+		defer ws.Close()
+
+		for _, m := range messages {
+			if err := ws.WriteMessage(m.mt, []byte(m.b)); err != nil {
+				t.Errorf("failed to write message: %v", err)
+				return
+			}
+		}
+
+		if err := ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")); err != nil {
+			t.Errorf("failed to close websocket: %v", err)
+		}
+	}))
+	t.Cleanup(s.Close)
+
+	c, err := NewClient(OptBaseURL(s.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    []OutputOption
+		stopAt  int
+		want    []message
+		wantErr error
+	}{
+		{
+			name: "Messages",
+			want: messages,
+		},
+		{
+			name: "SplitLines",
+			opts: []OutputOption{OptOutputSplitLines(true)},
+			want: []message{
+				{websocket.TextMessage, "a"},
+				{websocket.TextMessage, "bc"},
+				{websocket.BinaryMessage, "\x00"},
+				{websocket.TextMessage, "d"},
+			},
+		},
+		{
+			name:    "Stopped",
+			stopAt:  2,
+			want:    messages[:2],
+			wantErr: errStop,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+
+			var got []message
+
+			err := c.GetOutputEvents(context.Background(), "id", func(e OutputEvent) error {
+				if e.Received.Before(start) {
+					t.Errorf("got receive time %v before start %v", e.Received, start)
+				}
+
+				got = append(got, message{e.MessageType, string(e.Message)})
+
+				if len(got) == tt.stopAt {
+					return errStop
+				}
+				return nil
+			}, tt.opts...)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got events %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutputHTTPHeader(t *testing.T) {
+	var received []string
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Values("X-Org-ID")
+
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("failed to upgrade websocket: %v", err)
+			return
+		}
+		defer ws.Close()
+
+		if err := ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")); err != nil {
+			t.Errorf("failed to close websocket: %v", err)
+		}
+	}))
+	t.Cleanup(s.Close)
+
+	c, err := NewClient(OptBaseURL(s.URL), OptHTTPHeader("X-Org-ID", "org"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.GetOutput(context.Background(), "id", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := received, []string{"org"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// writeFrame writes a websocket frame with the specified FIN bit, opcode and payload to conn, as
+// a server would. The payload must be shorter than 126 bytes.
+func writeFrame(conn net.Conn, fin bool, opcode byte, payload string) error {
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	_, err := conn.Write(append([]byte{b0, byte(len(payload))}, payload...))
+	return err
+}
+
+// readControl reads from ws until an error occurs, so that control messages sent by the client
+// are processed.
+func readControl(ws *websocket.Conn) {
+	for {
+		if _, _, err := ws.NextReader(); err != nil {
+			return
+		}
+	}
+}
+
+func TestGetOutputEventsFragmented(t *testing.T) {
+	const (
+		opText         = 1
+		opContinuation = 0
+		opPing         = 9
+	)
+
+	pongs := make(chan string, 1)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("failed to upgrade websocket: %v", err)
+			return
+		}
+		defer ws.Close()
+
+		ws.SetPongHandler(func(data string) error {
+			pongs <- data
+			return nil
+		})
+		go readControl(ws)
+
+		// A text message fragmented across continuation frames, with a ping interleaved, as sent
+		// by some proxies.
+		frames := []struct {
+			fin     bool
+			opcode  byte
+			payload string
+		}{
+			{false, opText, "frag"},
+			{false, opContinuation, "mented "},
+			{true, opPing, "ping"},
+			{true, opContinuation, "message\n"},
+			{true, opText, "whole\n"},
+		}
+		for _, f := range frames {
+			if err := writeFrame(ws.UnderlyingConn(), f.fin, f.opcode, f.payload); err != nil {
+				t.Errorf("failed to write frame: %v", err)
+				return
+			}
+		}
+
+		select {
+		case data := <-pongs:
+			if data != "ping" {
+				t.Errorf("got pong %q, want %q", data, "ping")
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("ping not answered")
+		}
+
+		if err := ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")); err != nil {
+			t.Errorf("failed to close websocket: %v", err)
+		}
+	}))
+	t.Cleanup(s.Close)
+
+	c, err := NewClient(OptBaseURL(s.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	if err := c.GetOutputEvents(context.Background(), "id", func(e OutputEvent) error {
+		if e.MessageType != websocket.TextMessage {
+			t.Errorf("got message type %v, want %v", e.MessageType, websocket.TextMessage)
+		}
+		got = append(got, string(e.Message))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"fragmented message\n", "whole\n"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got messages %q, want %q", got, want)
+	}
+}
+
+func TestGetOutputEventsPingWhileBlocked(t *testing.T) {
+	ponged := make(chan struct{})
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("failed to upgrade websocket: %v", err)
+			return
+		}
+		defer ws.Close()
+
+		ws.SetPongHandler(func(string) error {
+			close(ponged)
+			return nil
+		})
+		go readControl(ws)
+
+		if err := ws.WriteMessage(websocket.TextMessage, []byte("first\n")); err != nil {
+			t.Errorf("failed to write message: %v", err)
+			return
+		}
+
+		if err := ws.WriteControl(websocket.PingMessage, []byte("ping"), time.Now().Add(time.Second)); err != nil {
+			t.Errorf("failed to write ping: %v", err)
+			return
+		}
+
+		select {
+		case <-ponged:
+		case <-time.After(5 * time.Second):
+			t.Error("ping not answered")
+		}
+
+		if err := ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")); err != nil {
+			t.Errorf("failed to close websocket: %v", err)
+		}
+	}))
+	t.Cleanup(s.Close)
+
+	c, err := NewClient(OptBaseURL(s.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Block on the first message until the ping is answered, as a slow writer would.
+	var got []string
+	if err := c.GetOutputEvents(context.Background(), "id", func(e OutputEvent) error {
+		if len(got) == 0 {
+			select {
+			case <-ponged:
+			case <-time.After(5 * time.Second):
+				t.Error("ping not answered while blocked")
+			}
+		}
+		got = append(got, string(e.Message))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"first\n"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got messages %q, want %q", got, want)
+	}
+}
+
+func TestGetOutputEventsSlowConsumer(t *testing.T) {
+	const messages = 5000
+
+	ponged := make(chan struct{})
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("failed to upgrade websocket: %v", err)
+			return
+		}
+		defer ws.Close()
+
+		ws.SetPongHandler(func(string) error {
+			close(ponged)
+			return nil
+		})
+		go readControl(ws)
+
+		for i := 0; i < messages; i++ {
+			if err := ws.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("%v\n", i))); err != nil {
+				t.Errorf("failed to write message: %v", err)
+				return
+			}
+		}
+
+		// The ping is answered, although no messages have been consumed.
+		if err := ws.WriteControl(websocket.PingMessage, []byte("ping"), time.Now().Add(time.Second)); err != nil {
+			t.Errorf("failed to write ping: %v", err)
+			return
+		}
+
+		select {
+		case <-ponged:
+		case <-time.After(5 * time.Second):
+			t.Error("ping not answered")
+		}
+
+		if err := ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")); err != nil {
+			t.Errorf("failed to close websocket: %v", err)
+		}
+	}))
+	t.Cleanup(s.Close)
+
+	c, err := NewClient(OptBaseURL(s.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Block on the first message until the ping is answered, and consume the rest slowly, as a slow
+	// writer would.
+	var got []string
+	if err := c.GetOutputEvents(context.Background(), "id", func(e OutputEvent) error {
+		if len(got) == 0 {
+			select {
+			case <-ponged:
+			case <-time.After(5 * time.Second):
+				t.Error("ping not answered while blocked")
+			}
+		} else if len(got)%1000 == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		got = append(got, string(e.Message))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// No output is lost, or reordered.
+	want := make([]string, 0, messages)
+	for i := 0; i < messages; i++ {
+		want = append(want, fmt.Sprintf("%v\n", i))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v messages, want %v in order", len(got), len(want))
+	}
+}
+
+func TestGetOutputEventsReadTimeout(t *testing.T) {
+	tests := []struct {
+		name         string
+		pingInterval time.Duration
+		wantTimeout  bool
+	}{
+		{
+			name:        "Silent",
+			wantTimeout: true,
+		},
+		{
+			name:         "KeptAlive",
+			pingInterval: 20 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ws, err := upgrader.Upgrade(w, r, nil)
+				if err != nil {
+					t.Errorf("failed to upgrade websocket: %v", err)
+					return
+				}
+				defer ws.Close()
+
+				// Pings from the client are answered while reading. The build produces no output
+				// for several read timeouts before completing.
+				go readControl(ws)
+
+				time.Sleep(500 * time.Millisecond)
+
+				_ = ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			}))
+			t.Cleanup(s.Close)
+
+			c, err := NewClient(OptBaseURL(s.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = c.GetOutputEvents(context.Background(), "id", func(OutputEvent) error { return nil },
+				OptOutputReadTimeout(100*time.Millisecond),
+				OptOutputPingInterval(tt.pingInterval),
+			)
+
+			var ne net.Error
+			if got := errors.As(err, &ne) && ne.Timeout(); got != tt.wantTimeout {
+				t.Fatalf("got error %v, want timeout %v", err, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+func TestGetOutputEventsCancelBuild(t *testing.T) {
+	tests := []struct {
+		name        string
+		cancelBuild bool
+		wantCancels int64
+	}{
+		{
+			name:        "Cancel",
+			cancelBuild: true,
+			wantCancels: 1,
+		},
+		{
+			name: "Keep",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cancels atomic.Int64
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("PUT /v1/build/id/_cancel", func(w http.ResponseWriter, r *http.Request) {
+				cancels.Add(1)
+				w.WriteHeader(http.StatusNoContent)
+			})
+			mux.HandleFunc(wsPath, func(w http.ResponseWriter, r *http.Request) {
+				ws, err := upgrader.Upgrade(w, r, nil)
+				if err != nil {
+					t.Errorf("failed to upgrade websocket: %v", err)
+					return
+				}
+				defer ws.Close()
+
+				// Produce no output until the client closes the websocket.
+				readControl(ws)
+			})
+
+			s := httptest.NewServer(mux)
+			t.Cleanup(s.Close)
+
+			c, err := NewClient(OptBaseURL(s.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			if err := c.GetOutputEvents(ctx, "id", func(OutputEvent) error { return nil },
+				OptOutputCancelBuild(tt.cancelBuild),
+			); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := cancels.Load(), tt.wantCancels; got != want {
+				t.Errorf("got %v cancels, want %v", got, want)
+			}
+		})
+	}
+}
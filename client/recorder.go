@@ -0,0 +1,350 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultRecordBodyLimit is the default number of bytes of each body recorded by an HTTPRecorder.
+const DefaultRecordBodyLimit = 64 << 10
+
+// redacted replaces sensitive values in recordings.
+const redacted = "REDACTED"
+
+// redactedHeaders lists headers whose values are redacted in recordings.
+var redactedHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+}
+
+// redactedQueryParams lists substrings of URL query parameter names whose values are redacted in
+// recordings, such as those of pre-signed URLs.
+var redactedQueryParams = []string{
+	"signature",
+	"credential",
+	"token",
+}
+
+// HTTPRecorder records HTTP requests and responses as numbered JSON files in a directory, to
+// capture the exact exchanges with a server when reporting an issue. Websocket handshakes are
+// recorded along with a transcript of the messages received.
+//
+// Credentials in headers and URLs are redacted. Bodies are recorded up to a size limit as they are
+// read by the client and server, so recording does not alter the exchange.
+type HTTPRecorder struct {
+	dir       string
+	bodyLimit int
+	seq       atomic.Int64
+}
+
+// NewHTTPRecorder returns an HTTPRecorder that records to dir, which is created if necessary.
+func NewHTTPRecorder(dir string) (*HTTPRecorder, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	return &HTTPRecorder{dir: dir, bodyLimit: DefaultRecordBodyLimit}, nil
+}
+
+// SetBodyLimit sets the number of bytes of each body recorded to n. It must be called before the
+// recorder is used.
+func (r *HTTPRecorder) SetBodyLimit(n int) {
+	r.bodyLimit = n
+}
+
+// recordedBody is a request or response body, as recorded.
+type recordedBody struct {
+	Encoding  string `json:"encoding,omitempty"` // "base64" if not valid UTF-8.
+	Data      string `json:"data"`
+	Size      int64  `json:"size"` // Total size, including bytes not recorded.
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// recordedMessage is a request or response, as recorded.
+type recordedMessage struct {
+	Method string        `json:"method,omitempty"`
+	URL    string        `json:"url,omitempty"`
+	Status string        `json:"status,omitempty"`
+	Header http.Header   `json:"header"`
+	Body   *recordedBody `json:"body,omitempty"`
+}
+
+// recordedFrame is a websocket message, as recorded.
+type recordedFrame struct {
+	Received time.Time    `json:"received"`
+	Type     int          `json:"type"`
+	Body     recordedBody `json:"body"`
+}
+
+// recordedExchange is the content of a recording.
+type recordedExchange struct {
+	Seq      int64            `json:"seq"`
+	Started  time.Time        `json:"started"`
+	Request  recordedMessage  `json:"request"`
+	Response *recordedMessage `json:"response,omitempty"`
+	Frames   []recordedFrame  `json:"frames,omitempty"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// redactHeader returns a copy of h with sensitive values redacted.
+func redactHeader(h http.Header) http.Header {
+	h = h.Clone()
+	if h == nil {
+		h = http.Header{}
+	}
+	for _, k := range redactedHeaders {
+		if vs, ok := h[k]; ok {
+			for i := range vs {
+				vs[i] = redacted
+			}
+		}
+	}
+	return h
+}
+
+// redactURL returns u as a string, with any password and sensitive query parameters redacted.
+func redactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+
+	c := *u
+	if c.RawQuery != "" {
+		q := c.Query()
+		for k, vs := range q {
+			for _, s := range redactedQueryParams {
+				if strings.Contains(strings.ToLower(k), s) {
+					for i := range vs {
+						vs[i] = redacted
+					}
+				}
+			}
+		}
+		c.RawQuery = q.Encode()
+	}
+	return c.Redacted()
+}
+
+// limitedBuffer retains up to limit bytes written to it, while counting all bytes written.
+type limitedBuffer struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	limit int
+	n     int64
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.n += int64(len(p))
+	if rem := b.limit - b.buf.Len(); rem > 0 {
+		if len(p) > rem {
+			b.buf.Write(p[:rem])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// body returns the recorded body.
+func (b *limitedBuffer) body() recordedBody {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return newRecordedBody(b.buf.Bytes(), b.n)
+}
+
+// newRecordedBody returns a recordedBody containing p, of total size n.
+func newRecordedBody(p []byte, n int64) recordedBody {
+	rb := recordedBody{Size: n, Truncated: int64(len(p)) < n}
+	if utf8.Valid(p) {
+		rb.Data = string(p)
+	} else {
+		rb.Encoding = "base64"
+		rb.Data = base64.StdEncoding.EncodeToString(p)
+	}
+	return rb
+}
+
+// recording is an exchange in progress.
+type recording struct {
+	r    *HTTPRecorder
+	once sync.Once
+
+	mu      sync.Mutex
+	ex      recordedExchange
+	reqBody *limitedBuffer
+	resBody *limitedBuffer
+}
+
+// newRecording starts a recording of a request with the specified method, URL and header.
+func (r *HTTPRecorder) newRecording(method string, u *url.URL, h http.Header) *recording {
+	return &recording{
+		r: r,
+		ex: recordedExchange{
+			Seq:     r.seq.Add(1),
+			Started: time.Now(),
+			Request: recordedMessage{
+				Method: method,
+				URL:    redactURL(u),
+				Header: redactHeader(h),
+			},
+		},
+	}
+}
+
+// setResponse records res, which may be nil.
+func (rec *recording) setResponse(res *http.Response) {
+	if res == nil {
+		return
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.ex.Response = &recordedMessage{
+		Status: res.Status,
+		Header: redactHeader(res.Header),
+	}
+}
+
+// addFrame records a websocket message of type mt.
+func (rec *recording) addFrame(mt int, p []byte) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	n := len(p)
+	if n > rec.r.bodyLimit {
+		p = p[:rec.r.bodyLimit]
+	}
+	rec.ex.Frames = append(rec.ex.Frames, recordedFrame{
+		Received: time.Now(),
+		Type:     mt,
+		Body:     newRecordedBody(p, int64(n)),
+	})
+}
+
+// finish writes the recording, noting err if not nil. Only the first call has any effect.
+func (rec *recording) finish(err error) {
+	rec.once.Do(func() {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+
+		if err != nil {
+			rec.ex.Error = err.Error()
+		}
+		if rec.reqBody != nil {
+			b := rec.reqBody.body()
+			rec.ex.Request.Body = &b
+		}
+		if rec.resBody != nil && rec.ex.Response != nil {
+			b := rec.resBody.body()
+			rec.ex.Response.Body = &b
+		}
+
+		// Recording is best effort, and must not affect the exchange.
+		_ = rec.r.write(&rec.ex)
+	})
+}
+
+// write writes ex to a file named according to its sequence number.
+func (r *HTTPRecorder) write(ex *recordedExchange) error {
+	b, err := json.MarshalIndent(ex, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(r.dir, fmt.Sprintf("%06d.json", ex.Seq))
+	return os.WriteFile(name, b, 0o600)
+}
+
+// teeReadCloser copies what is read from rc to w, and calls done, if set, when rc is closed.
+type teeReadCloser struct {
+	rc   io.ReadCloser
+	w    io.Writer
+	done func(error)
+}
+
+func (t *teeReadCloser) Read(p []byte) (int, error) {
+	n, err := t.rc.Read(p)
+	if n > 0 {
+		_, _ = t.w.Write(p[:n])
+	}
+	return n, err
+}
+
+func (t *teeReadCloser) Close() error {
+	err := t.rc.Close()
+	if t.done != nil {
+		t.done(nil)
+	}
+	return err
+}
+
+// recordingTransport is an http.RoundTripper that records exchanges.
+type recordingTransport struct {
+	rt http.RoundTripper
+	r  *HTTPRecorder
+}
+
+// RoundTripper returns an http.RoundTripper that records exchanges made using rt.
+func (r *HTTPRecorder) RoundTripper(rt http.RoundTripper) http.RoundTripper {
+	return &recordingTransport{rt: rt, r: r}
+}
+
+// RoundTrip records the exchange of req. The recording is written once the response body is
+// closed, or when the exchange fails.
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := t.r.newRecording(req.Method, req.URL, req.Header)
+
+	if req.Body != nil && req.Body != http.NoBody {
+		rec.reqBody = &limitedBuffer{limit: t.r.bodyLimit}
+
+		// A RoundTripper must not modify the request, so the body is replaced on a copy.
+		req = req.Clone(req.Context())
+		req.Body = &teeReadCloser{rc: req.Body, w: rec.reqBody}
+	}
+
+	res, err := t.rt.RoundTrip(req)
+	if err != nil {
+		rec.finish(err)
+		return res, err
+	}
+
+	rec.setResponse(res)
+	rec.resBody = &limitedBuffer{limit: t.r.bodyLimit}
+	res.Body = &teeReadCloser{rc: res.Body, w: rec.resBody, done: rec.finish}
+
+	return res, nil
+}
+
+// newWebsocketRecording starts a recording of a websocket handshake to u, with request header h,
+// that received response res and error err. Messages received on the websocket are recorded using
+// addFrame, and the recording is written by finish.
+func (r *HTTPRecorder) newWebsocketRecording(u *url.URL, h http.Header, res *http.Response, err error) *recording {
+	rec := r.newRecording(http.MethodGet, u, h)
+	rec.setResponse(res)
+	if err != nil {
+		rec.ex.Error = err.Error()
+	}
+	return rec
+}
@@ -0,0 +1,241 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// readRecordings returns the exchanges recorded in dir, in order.
+func readRecordings(t *testing.T, dir string) []recordedExchange {
+	t.Helper()
+
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exs := make([]recordedExchange, 0, len(names))
+	for _, name := range names {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ex recordedExchange
+		if err := json.Unmarshal(b, &ex); err != nil {
+			t.Fatal(err)
+		}
+		exs = append(exs, ex)
+	}
+	return exs
+}
+
+func TestHTTPRecorder(t *testing.T) {
+	const reqBody = "request body"
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+
+		// The request must reach the server unaltered.
+		assert.Equal(t, reqBody, string(b))
+		assert.Equal(t, "BEARER secret", r.Header.Get("Authorization"))
+		assert.Equal(t, "secret", r.URL.Query().Get("X-Amz-Signature"))
+
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(w, "response "+string(b))
+	}))
+	t.Cleanup(s.Close)
+
+	tests := []struct {
+		name          string
+		bodyLimit     int
+		wantReqBody   string
+		wantResBody   string
+		wantTruncated bool
+	}{
+		{
+			name:        "Complete",
+			bodyLimit:   DefaultRecordBodyLimit,
+			wantReqBody: reqBody,
+			wantResBody: "response " + reqBody,
+		},
+		{
+			name:          "Truncated",
+			bodyLimit:     4,
+			wantReqBody:   "requ",
+			wantResBody:   "resp",
+			wantTruncated: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "trace")
+
+			r, err := NewHTTPRecorder(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.SetBodyLimit(tt.bodyLimit)
+
+			hc := &http.Client{Transport: r.RoundTripper(http.DefaultTransport)}
+
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, s.URL+"/path?X-Amz-Signature=secret&keep=value", strings.NewReader(reqBody))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "BEARER secret")
+
+			res, err := hc.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The response must reach the caller unaltered.
+			b, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			assert.Equal(t, "response "+reqBody, string(b))
+			assert.Equal(t, http.StatusCreated, res.StatusCode)
+
+			exs := readRecordings(t, dir)
+			if !assert.Len(t, exs, 1) {
+				return
+			}
+			ex := exs[0]
+
+			assert.Equal(t, int64(1), ex.Seq)
+			assert.Equal(t, http.MethodPost, ex.Request.Method)
+			assert.Contains(t, ex.Request.URL, "keep=value")
+			assert.NotContains(t, ex.Request.URL, "secret")
+			assert.Equal(t, []string{redacted}, ex.Request.Header["Authorization"])
+
+			if assert.NotNil(t, ex.Request.Body) {
+				assert.Equal(t, tt.wantReqBody, ex.Request.Body.Data)
+				assert.Equal(t, int64(len(reqBody)), ex.Request.Body.Size)
+				assert.Equal(t, tt.wantTruncated, ex.Request.Body.Truncated)
+			}
+
+			if assert.NotNil(t, ex.Response) {
+				assert.Equal(t, res.Status, ex.Response.Status)
+				assert.Equal(t, []string{redacted}, ex.Response.Header["Set-Cookie"])
+				assert.Equal(t, []string{"text/plain"}, ex.Response.Header["Content-Type"])
+
+				if assert.NotNil(t, ex.Response.Body) {
+					assert.Equal(t, tt.wantResBody, ex.Response.Body.Data)
+					assert.Equal(t, int64(len(b)), ex.Response.Body.Size)
+					assert.Equal(t, tt.wantTruncated, ex.Response.Body.Truncated)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPRecorderWebsocket(t *testing.T) {
+	messages := []struct {
+		mt int
+		b  string
+	}{
+		{websocket.TextMessage, "output\n"},
+		{websocket.BinaryMessage, "\xff\x00"},
+	}
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("failed to upgrade websocket: %v", err)
+			return
+		}
+		defer ws.Close()
+
+		for _, m := range messages {
+			if err := ws.WriteMessage(m.mt, []byte(m.b)); err != nil {
+				t.Errorf("failed to write message: %v", err)
+				return
+			}
+		}
+
+		if err := ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")); err != nil {
+			t.Errorf("failed to close websocket: %v", err)
+		}
+	}))
+	t.Cleanup(s.Close)
+
+	dir := t.TempDir()
+
+	r, err := NewHTTPRecorder(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewClient(OptBaseURL(s.URL), OptBearerToken(authToken), OptHTTPRecorder(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []OutputEvent
+	if err := c.GetOutputEvents(context.Background(), "id", func(oe OutputEvent) error {
+		got = append(got, oe)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Recording must not alter the messages received.
+	if assert.Len(t, got, len(messages)) {
+		for i, m := range messages {
+			assert.Equal(t, m.mt, got[i].MessageType)
+			assert.Equal(t, m.b, string(got[i].Message))
+		}
+	}
+
+	exs := readRecordings(t, dir)
+	if !assert.Len(t, exs, 1) {
+		return
+	}
+	ex := exs[0]
+
+	assert.Equal(t, "ws"+strings.TrimPrefix(s.URL, "http")+"/v1/build-ws/id", ex.Request.URL)
+	assert.Equal(t, []string{redacted}, ex.Request.Header["Authorization"])
+
+	if assert.NotNil(t, ex.Response) {
+		assert.Equal(t, "101 Switching Protocols", ex.Response.Status)
+	}
+
+	if assert.Len(t, ex.Frames, len(messages)) {
+		assert.Equal(t, websocket.TextMessage, ex.Frames[0].Type)
+		assert.Equal(t, "output\n", ex.Frames[0].Body.Data)
+		assert.Equal(t, websocket.BinaryMessage, ex.Frames[1].Type)
+		assert.Equal(t, "base64", ex.Frames[1].Body.Encoding)
+		assert.Equal(t, "/wA=", ex.Frames[1].Body.Data)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "000001.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotContains(t, string(b), authToken)
+}
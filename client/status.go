@@ -10,13 +10,18 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"time"
 
 	jsonresp "github.com/sylabs/json-resp"
 )
 
 // GetStatus gets the status of a build from the Build Service by build ID. The context controls
 // the lifetime of the request.
-func (c *Client) GetStatus(ctx context.Context, buildID string) (*BuildInfo, error) {
+func (c *Client) GetStatus(ctx context.Context, buildID string) (_ *BuildInfo, err error) {
+	ctx, span := c.startSpan(ctx, "GetStatus")
+	span.SetAttribute(AttributeBuildID, buildID)
+	defer func() { span.End(err) }()
+
 	ref := &url.URL{
 		Path: "v1/build/" + buildID,
 	}
@@ -26,14 +31,14 @@ func (c *Client) GetStatus(ctx context.Context, buildID string) (*BuildInfo, err
 		return nil, fmt.Errorf("%w", err)
 	}
 
-	res, err := c.httpClient.Do(req)
+	res, err := c.doWithRefresh(c.httpClient, req)
 	if err != nil {
 		return nil, fmt.Errorf("%w", err)
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode/100 != 2 { // non-2xx status code
-		return nil, fmt.Errorf("%w", errorFromResponse(res))
+		return nil, fmt.Errorf("%w", c.errorFromResponse(res))
 	}
 
 	var rbi rawBuildInfo
@@ -43,3 +48,32 @@ func (c *Client) GetStatus(ctx context.Context, buildID string) (*BuildInfo, err
 
 	return &BuildInfo{rbi}, nil
 }
+
+// waitPollInterval is the interval at which WaitForCompletion polls build status.
+var waitPollInterval = 5 * time.Second
+
+// WaitForCompletion polls the status of the build with the specified ID until it is complete, and
+// returns the final status. The context controls the lifetime of the wait.
+//
+// An error is returned only if status cannot be retrieved. To determine the outcome of the build,
+// consider using BuildInfo.Failed.
+func (c *Client) WaitForCompletion(ctx context.Context, buildID string) (*BuildInfo, error) {
+	ticker := time.NewTicker(waitPollInterval)
+	defer ticker.Stop()
+
+	for {
+		bi, err := c.GetStatus(ctx, buildID)
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+		if bi.IsComplete() {
+			return bi, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
@@ -66,6 +66,15 @@ func TestStatus(t *testing.T) {
 				if bi.LibraryURL() == "" {
 					t.Errorf("empty Library URL")
 				}
+				if got, want := bi.State(), BuildStateSucceeded; got != want {
+					t.Errorf("got state %v, want %v", got, want)
+				}
+				if code, ok := bi.ExitCode(); !ok || code != 0 {
+					t.Errorf("got exit code (%v, %v), want (0, true)", code, ok)
+				}
+				if bi.StartTime().Before(bi.SubmitTime()) || bi.CompleteTime().Before(bi.StartTime()) {
+					t.Errorf("times out of order: submitted %v, started %v, completed %v", bi.SubmitTime(), bi.StartTime(), bi.CompleteTime())
+				}
 			}
 		})
 	}
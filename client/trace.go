@@ -0,0 +1,74 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// Span attributes recorded by the client.
+const (
+	AttributeBuildID       = "build.id"
+	AttributeContextDigest = "build.context.digest"
+)
+
+// TraceContextFunc injects the trace context carried by ctx into the headers of an outgoing
+// request. To propagate an OpenTelemetry trace context using W3C "traceparent" and "tracestate"
+// headers, use:
+//
+//	func(ctx context.Context, h http.Header) {
+//		propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(h))
+//	}
+type TraceContextFunc func(ctx context.Context, h http.Header)
+
+// OptPropagateTraceContext sets f to be called to inject the trace context of each request into its
+// headers, including the request that establishes the stream of build output.
+func OptPropagateTraceContext(f TraceContextFunc) Option {
+	return func(co *clientOptions) error {
+		co.traceContextFunc = f
+		return nil
+	}
+}
+
+// Span is an operation started by a Tracer.
+type Span interface {
+	// SetAttribute records an attribute of the operation, such as AttributeBuildID.
+	SetAttribute(key, value string)
+
+	// End completes the operation. If err is non-nil, the operation failed.
+	End(err error)
+}
+
+// Tracer starts spans describing operations performed by the client, such as Submit. It can be
+// implemented using an OpenTelemetry tracer, without the client depending on OpenTelemetry.
+type Tracer interface {
+	// Start starts a span with the specified name, returning a context carrying it.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// OptTracer sets t to start a span for each call to Submit, GetStatus, GetOutput,
+// GetOutputEvents and UploadBuildContext.
+func OptTracer(t Tracer) Option {
+	return func(co *clientOptions) error {
+		co.tracer = t
+		return nil
+	}
+}
+
+// noopSpan is a Span that records nothing.
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, string) {}
+func (noopSpan) End(error)                   {}
+
+// startSpan starts a span with the specified name using c.tracer, if set.
+func (c *Client) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if c.tracer == nil {
+		return ctx, noopSpan{}
+	}
+	return c.tracer.Start(ctx, name)
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+type traceIDKey struct{}
+
+// injectTraceID injects the trace ID carried by ctx, if any, into h.
+func injectTraceID(ctx context.Context, h http.Header) {
+	if id, ok := ctx.Value(traceIDKey{}).(string); ok {
+		h.Set("Traceparent", id)
+	}
+}
+
+func TestOptPropagateTraceContext(t *testing.T) {
+	const traceID = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+
+	var (
+		mu       sync.Mutex
+		received = make(map[string]string)
+	)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received[r.URL.Path] = r.Header.Get("Traceparent")
+		mu.Unlock()
+
+		if !websocket.IsWebSocketUpgrade(r) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("failed to upgrade websocket: %v", err)
+			return
+		}
+		defer ws.Close()
+
+		if err := ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")); err != nil {
+			t.Errorf("failed to close websocket: %v", err)
+		}
+	}))
+	t.Cleanup(s.Close)
+
+	c, err := NewClient(OptBaseURL(s.URL), OptPropagateTraceContext(injectTraceID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.WithValue(context.Background(), traceIDKey{}, traceID)
+
+	if err := c.Cancel(ctx, "id"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.GetOutput(ctx, "id", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"/v1/build/id/_cancel": traceID,
+		"/v1/build-ws/id":      traceID,
+	}
+	if !reflect.DeepEqual(received, want) {
+		t.Errorf("got headers %v, want %v", received, want)
+	}
+}
+
+type testSpan struct {
+	name  string
+	attrs map[string]string
+	ended bool
+	err   error
+}
+
+func (s *testSpan) SetAttribute(key, value string) { s.attrs[key] = value }
+func (s *testSpan) End(err error)                  { s.ended, s.err = true, err }
+
+// testTracer is a Tracer that records the spans it starts.
+type testTracer struct {
+	spans []*testSpan
+}
+
+func (tt *testTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	s := &testSpan{name: name, attrs: make(map[string]string)}
+	tt.spans = append(tt.spans, s)
+	return ctx, s
+}
+
+func TestOptTracer(t *testing.T) {
+	m := mockService{t: t, buildResponseCode: http.StatusCreated, statusResponseCode: http.StatusOK}
+	mux := http.NewServeMux()
+	mux.Handle("/", &m)
+	mux.HandleFunc(wsPath, m.ServeWebsocket)
+
+	s := httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	m.httpAddr = s.Listener.Addr().String()
+	m.wsResponseCode = http.StatusOK
+	m.wsCloseCode = websocket.CloseNormalClosure
+
+	var tr testTracer
+
+	c, err := NewClient(OptBaseURL(s.URL), OptTracer(&tr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	bi, err := c.Submit(ctx, strings.NewReader(testDefinition), OptBuildContext("sha256.digest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetStatus(ctx, bi.ID()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.GetOutput(ctx, bi.ID(), io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.UploadBuildContext(ctx, nil); !errors.Is(err, errNoPathsSpecified) {
+		t.Fatalf("got error %v, want %v", err, errNoPathsSpecified)
+	}
+
+	want := []*testSpan{
+		{
+			name: "Submit",
+			attrs: map[string]string{
+				AttributeBuildID:       bi.ID(),
+				AttributeContextDigest: "sha256.digest",
+			},
+			ended: true,
+		},
+		{
+			name:  "GetStatus",
+			attrs: map[string]string{AttributeBuildID: bi.ID()},
+			ended: true,
+		},
+		{
+			name:  "GetOutput",
+			attrs: map[string]string{AttributeBuildID: bi.ID()},
+			ended: true,
+		},
+		{
+			name:  "UploadBuildContext",
+			attrs: map[string]string{},
+			ended: true,
+			err:   errNoPathsSpecified,
+		},
+	}
+	if !reflect.DeepEqual(tr.spans, want) {
+		for _, s := range tr.spans {
+			t.Logf("got span %+v", *s)
+		}
+		t.Errorf("got %v spans, want %v", len(tr.spans), len(want))
+	}
+}
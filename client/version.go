@@ -14,33 +14,53 @@ import (
 	jsonresp "github.com/sylabs/json-resp"
 )
 
+// Notice is a message, such as a maintenance banner, published by the build service for its users.
+type Notice struct {
+	Severity string `json:"severity,omitempty"` // For example, "info", "warning" or "critical".
+	Message  string `json:"message"`
+}
+
+// VersionInfo describes the build service.
+type VersionInfo struct {
+	Version string   `json:"version"`
+	Notices []Notice `json:"notices,omitempty"` // Published by newer build services only.
+}
+
 // GetVersion gets version information from the build service. The context controls the lifetime of
 // the request.
 func (c *Client) GetVersion(ctx context.Context) (string, error) {
+	vi, err := c.GetVersionInfo(ctx)
+	if err != nil {
+		return "", err
+	}
+	return vi.Version, nil
+}
+
+// GetVersionInfo gets version information, including any notices, from the build service. The
+// context controls the lifetime of the request.
+func (c *Client) GetVersionInfo(ctx context.Context) (*VersionInfo, error) {
 	ref := &url.URL{
 		Path: "version",
 	}
 
 	req, err := c.newRequest(ctx, http.MethodGet, ref, nil)
 	if err != nil {
-		return "", fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("%w", err)
 	}
 
 	res, err := c.httpClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("%w", err)
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode/100 != 2 { // non-2xx status code
-		return "", fmt.Errorf("%w", errorFromResponse(res))
+		return nil, fmt.Errorf("%w", c.errorFromResponse(res))
 	}
 
-	vi := struct {
-		Version string `json:"version"`
-	}{}
+	var vi VersionInfo
 	if err := jsonresp.ReadResponse(res.Body, &vi); err != nil {
-		return "", fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("%w", err)
 	}
-	return vi.Version, nil
+	return &vi, nil
 }
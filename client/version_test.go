@@ -10,6 +10,7 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
 
 	jsonresp "github.com/sylabs/json-resp"
@@ -20,6 +21,7 @@ type mockVersion struct {
 	code    int
 	message string
 	version string
+	notices []Notice
 }
 
 func (m *mockVersion) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -38,10 +40,9 @@ func (m *mockVersion) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		m.t.Errorf("got path %v, want %v", got, want)
 	}
 
-	vi := struct {
-		Version string `json:"version"`
-	}{
+	vi := VersionInfo{
 		Version: m.version,
+		Notices: m.notices,
 	}
 	if err := jsonresp.WriteResponse(w, vi, m.code); err != nil {
 		m.t.Fatalf("failed to write response: %v", err)
@@ -125,3 +126,57 @@ func TestClient_GetVersion(t *testing.T) {
 		})
 	}
 }
+
+func TestClient_GetVersionInfo(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		notices []Notice
+	}{
+		{
+			name: "NoNotices",
+		},
+		{
+			name: "Notices",
+			notices: []Notice{
+				{Severity: "info", Message: "new builders available"},
+				{Severity: "warning", Message: "builders draining for upgrade at 18:00 UTC"},
+				{Severity: "critical", Message: "builds are suspended"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := httptest.NewServer(&mockVersion{
+				t:       t,
+				code:    http.StatusOK,
+				version: "1.2.3",
+				notices: tt.notices,
+			})
+			defer s.Close()
+
+			c, err := NewClient(OptBaseURL(s.URL))
+			if err != nil {
+				t.Fatalf("failed to create client: %v", err)
+			}
+
+			vi, err := c.GetVersionInfo(context.Background())
+			if err != nil {
+				t.Fatalf("failed to get version info: %v", err)
+			}
+
+			if got, want := vi.Version, "1.2.3"; got != want {
+				t.Errorf("got version %v, want %v", got, want)
+			}
+
+			if got, want := vi.Notices, tt.notices; !reflect.DeepEqual(got, want) {
+				t.Errorf("got notices %v, want %v", got, want)
+			}
+		})
+	}
+}
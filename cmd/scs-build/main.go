@@ -8,11 +8,13 @@ package main
 import (
 	"fmt"
 	"os"
+
+	"github.com/sylabs/scs-build-client/internal/app/buildclient"
 )
 
 func main() {
 	if err := execute(); err != nil {
 		fmt.Fprintln(os.Stderr, "Error:", err)
-		os.Exit(1)
+		os.Exit(buildclient.ExitCode(err))
 	}
 }
@@ -17,8 +17,32 @@ import (
 )
 
 var rootCmd = &cobra.Command{
-	Use:           "scs-build",
-	Short:         "Singularity Container Services Build Client",
+	Use:   "scs-build",
+	Short: "Singularity Container Services Build Client",
+	Long: fmt.Sprintf(`Singularity Container Services Build Client
+
+Exit status:
+  %v  Success
+  %v  Failure not in another category
+  %v  Invalid flags or arguments
+  %v  Access token missing, invalid or expired
+  %v  Invalid build definition or build context
+  %v  Remote build failed or timed out
+  %v  Image download or verification failed
+  %v  Image signing or signature verification failed
+  %v  Access token valid, but not permitted to perform operation
+  %v  Interrupted, such as by Ctrl-C`,
+		0,
+		buildclient.ExitFailure,
+		buildclient.ExitUsage,
+		buildclient.ExitAuth,
+		buildclient.ExitValidation,
+		buildclient.ExitBuild,
+		buildclient.ExitDownload,
+		buildclient.ExitSigning,
+		buildclient.ExitForbidden,
+		buildclient.ExitInterrupted,
+	),
 	SilenceErrors: true,
 	SilenceUsage:  true,
 }
@@ -67,6 +91,24 @@ func execute() error {
 	// Add build subcommand
 	buildclient.AddBuildCommand(rootCmd)
 
+	// Add list subcommand
+	buildclient.AddListCommand(rootCmd)
+
+	// Add gc and context subcommands
+	buildclient.AddContextCommands(rootCmd)
+
+	// Add cache subcommand
+	buildclient.AddCacheCommands(rootCmd)
+
+	// Add info subcommand
+	buildclient.AddInfoCommand(rootCmd)
+
+	// Add debug subcommand
+	buildclient.AddDebugCommand(rootCmd)
+
+	// Exit with ExitUsage when flags or arguments are invalid.
+	buildclient.MarkUsageErrors(rootCmd)
+
 	useragent.Init(version)
 
 	return rootCmd.Execute()
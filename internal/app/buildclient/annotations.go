@@ -0,0 +1,178 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// CIAnnotations is the CI system for which annotations summarizing a run are written.
+type CIAnnotations string
+
+const (
+	CIAnnotationsNone   CIAnnotations = ""
+	CIAnnotationsGitHub CIAnnotations = "github"
+	CIAnnotationsAuto   CIAnnotations = "auto" // GitHub, if running in GitHub Actions, and otherwise none.
+)
+
+var errInvalidCIAnnotations = errors.New("invalid CI annotations")
+
+// parseCIAnnotations parses the CI system for which annotations are written.
+func parseCIAnnotations(value string) (CIAnnotations, error) {
+	switch ci := CIAnnotations(value); ci {
+	case CIAnnotationsNone, CIAnnotationsGitHub, CIAnnotationsAuto:
+		return ci, nil
+	default:
+		return "", fmt.Errorf("%w %q: expected %v or %v", errInvalidCIAnnotations, value, CIAnnotationsGitHub, CIAnnotationsAuto)
+	}
+}
+
+// resolve returns the CI system for which annotations are written, detecting it if ci is
+// CIAnnotationsAuto.
+func (ci CIAnnotations) resolve() CIAnnotations {
+	if ci != CIAnnotationsAuto {
+		return ci
+	}
+
+	if os.Getenv("GITHUB_ACTIONS") == "true" {
+		return CIAnnotationsGitHub
+	}
+	return CIAnnotationsNone
+}
+
+// annotationLevel is the severity of an annotation.
+type annotationLevel string
+
+const (
+	annotationNotice annotationLevel = "notice"
+	annotationError  annotationLevel = "error"
+)
+
+// annotation summarizes the outcome of a run, or of the build for one architecture.
+type annotation struct {
+	level   annotationLevel
+	title   string
+	message string
+}
+
+// annotationRenderer writes annotations in the format understood by a CI system.
+type annotationRenderer interface {
+	render(w io.Writer, a annotation) error
+}
+
+// annotationRenderers are the renderers for each supported CI system.
+var annotationRenderers = map[CIAnnotations]annotationRenderer{
+	CIAnnotationsGitHub: githubRenderer{},
+}
+
+// githubRenderer writes workflow commands understood by GitHub Actions.
+type githubRenderer struct{}
+
+// githubData escapes s for use as the message of a workflow command.
+var githubData = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+
+// githubProperty escapes s for use as a property of a workflow command.
+var githubProperty = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+
+func (githubRenderer) render(w io.Writer, a annotation) error {
+	_, err := fmt.Fprintf(w, "::%v title=%v::%v\n", a.level, githubProperty.Replace(a.title), githubData.Replace(a.message))
+	return err
+}
+
+// failurePhase returns the phase of a run in which err occurred, according to its category. See
+// ExitCode.
+func failurePhase(err error) string {
+	switch ExitCode(err) {
+	case ExitUsage:
+		return "usage"
+	case ExitAuth, ExitForbidden:
+		return "authorization"
+	case ExitValidation:
+		return "validation"
+	case ExitBuild:
+		return "build"
+	case ExitDownload:
+		return "download"
+	case ExitSigning:
+		return "signing"
+	default:
+		return "run"
+	}
+}
+
+// firstLine returns the first line of s.
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+// annotations returns annotations summarizing the outcome of the build for each architecture
+// recorded in md. If no architecture is recorded, and err is set, the run failed before any build,
+// so a single annotation describing err is returned.
+func annotations(md *Metadata, err error) []annotation {
+	var as []annotation
+
+	if md != nil {
+		for _, am := range md.Archs {
+			if !am.Succeeded {
+				as = append(as, annotation{
+					level:   annotationError,
+					title:   fmt.Sprintf("Build for %v failed (%v)", am.Arch, am.FailurePhase),
+					message: firstLine(am.Error),
+				})
+				continue
+			}
+
+			dst := am.FileName
+			if dst == "" {
+				dst = libraryURI(am.LibraryRef)
+			}
+
+			as = append(as, annotation{
+				level:   annotationNotice,
+				title:   fmt.Sprintf("Built %v", am.Arch),
+				message: fmt.Sprintf("%v (%v)", dst, formatBytes(am.ImageSize)),
+			})
+		}
+	}
+
+	if len(as) == 0 && err != nil {
+		as = append(as, annotation{
+			level:   annotationError,
+			title:   fmt.Sprintf("Build failed (%v)", failurePhase(err)),
+			message: firstLine(err.Error()),
+		})
+	}
+
+	return as
+}
+
+// writeAnnotations writes annotations summarizing the run, which completed with err, to
+// app.stdout, in the format understood by app.ciAnnotations. Nothing is written unless CI
+// annotations are enabled. Where the run builds a batch, titles are prefixed with the name of the
+// definition built.
+func (app *App) writeAnnotations(err error) {
+	r, ok := annotationRenderers[app.ciAnnotations]
+	if !ok {
+		return
+	}
+
+	for _, a := range annotations(app.metadata, err) {
+		// Distinguish the definitions of a batch.
+		if app.batch != nil {
+			a.title = app.batch.name + ": " + a.title
+		}
+
+		if err := r.render(app.stdout, a); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing CI annotations: %v\n", err)
+			return
+		}
+	}
+}
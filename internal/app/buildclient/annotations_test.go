@@ -0,0 +1,180 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sebdah/goldie/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCIAnnotations(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    CIAnnotations
+		wantErr error
+	}{
+		{"None", "", CIAnnotationsNone, nil},
+		{"GitHub", "github", CIAnnotationsGitHub, nil},
+		{"Auto", "auto", CIAnnotationsAuto, nil},
+		{"Unknown", "jenkins", "", errInvalidCIAnnotations},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCIAnnotations(tt.value)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCIAnnotations_Resolve(t *testing.T) {
+	tests := []struct {
+		name          string
+		ci            CIAnnotations
+		githubActions string
+		want          CIAnnotations
+	}{
+		{"None", CIAnnotationsNone, "true", CIAnnotationsNone},
+		{"GitHub", CIAnnotationsGitHub, "", CIAnnotationsGitHub},
+		{"AutoGitHubActions", CIAnnotationsAuto, "true", CIAnnotationsGitHub},
+		{"AutoUndetected", CIAnnotationsAuto, "", CIAnnotationsNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GITHUB_ACTIONS", tt.githubActions)
+
+			assert.Equal(t, tt.want, tt.ci.resolve())
+		})
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	md := &Metadata{
+		Archs: []ArchMetadata{
+			{
+				Arch:      "amd64",
+				Succeeded: true,
+				FileName:  "image.sif-amd64",
+				ImageSize: 3 << 20,
+			},
+			{
+				Arch:       "arm64",
+				Succeeded:  true,
+				LibraryRef: "library:user/collection/image:tag",
+				ImageSize:  2048,
+			},
+			{
+				Arch:         "ppc64le",
+				Error:        "build failed: exit status 1: 50%, or more, of: the build\nsecond line",
+				FailurePhase: "build",
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		ci       CIAnnotations
+		metadata *Metadata
+		err      error
+	}{
+		{"GitHub", CIAnnotationsGitHub, md, fmt.Errorf("%w: arm64", errRetrieveArtifact)},
+		{"GitHubRunFailed", CIAnnotationsGitHub, &Metadata{}, fmt.Errorf("%w: data.txt\nsecond line", errMissingFiles)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := annotationRenderers[tt.ci]
+
+			var b bytes.Buffer
+			for _, a := range annotations(tt.metadata, tt.err) {
+				if err := r.render(&b, a); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			g := goldie.New(t, goldie.WithTestNameForDir(true))
+			g.Assert(t, tt.name, b.Bytes())
+		})
+	}
+}
+
+func TestApp_RunCIAnnotations(t *testing.T) {
+	tests := []struct {
+		name      string
+		ci        CIAnnotations
+		failBuild bool
+		want      string
+	}{
+		{
+			name: "Succeeded",
+			ci:   CIAnnotationsGitHub,
+			want: "::notice title=Built amd64::$DST (" + formatBytes(int64(len(mockImage))) + ")\n",
+		},
+		{
+			name:      "Failed",
+			ci:        CIAnnotationsGitHub,
+			failBuild: true,
+			want:      "::error title=Build for amd64 failed (build)::",
+		},
+		{
+			name: "None",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.failBuild = tt.failBuild
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			dst := filepath.Join(dir, "image.sif")
+
+			app, err := New(context.Background(), &Config{
+				URL:           m.frontend.URL,
+				BuildSpec:     defFile,
+				LibraryRef:    dst,
+				ArchsToBuild:  []string{"amd64"},
+				CIAnnotations: tt.ci,
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			var stdout bytes.Buffer
+			app.stdout = &stdout
+
+			if err := app.Run(context.Background()); (err != nil) != tt.failBuild {
+				t.Fatalf("got error %v, want error %v", err, tt.failBuild)
+			}
+
+			if tt.want == "" {
+				assert.NotContains(t, stdout.String(), "::")
+				return
+			}
+			assert.Contains(t, stdout.String(), strings.ReplaceAll(tt.want, "$DST", dst))
+		})
+	}
+}
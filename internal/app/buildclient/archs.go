@@ -0,0 +1,76 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	build "github.com/sylabs/scs-build-client/client"
+)
+
+// archAll is the architecture value that selects all architectures supported by the Build Service.
+const archAll = "all"
+
+var errInvalidArch = errors.New("invalid architecture")
+
+// resolveArchs expands archAll in app.archsToBuild to the architectures for which the Build
+// Service has builders. Other architectures are checked against the same list, so that unknown
+// values fail before any build is submitted. If the Build Service does not report its
+// architectures, they are accepted as is, but archAll cannot be expanded.
+func (app *App) resolveArchs(ctx context.Context) error {
+	if len(app.archsToBuild) == 0 {
+		return nil
+	}
+
+	all := slices.Contains(app.archsToBuild, archAll)
+	if all && len(app.archsToBuild) > 1 {
+		return fmt.Errorf("%w: %q may not be combined with other architectures", errInvalidArch, archAll)
+	}
+
+	caps, err := app.getCapabilities(ctx)
+	if err != nil || !caps.Reported {
+		if !all {
+			// Unknown architectures are instead reported by the Build Service on submission.
+			return nil
+		}
+		if err == nil {
+			return fmt.Errorf("--%v %v requires a newer Build Service: %w", keyArch, archAll, build.ErrNotSupported)
+		}
+		return fmt.Errorf("error getting builder architectures: %w", app.wrapBuildErr(err))
+	}
+	supported := caps.Architectures
+
+	if all {
+		if len(supported) == 0 {
+			return fmt.Errorf("%w: Build Service reports no builder architectures", errInvalidArch)
+		}
+		app.archsToBuild = supported
+		return nil
+	}
+
+	for _, arch := range app.archsToBuild {
+		if !slices.Contains(supported, arch) {
+			return fmt.Errorf("%w %q: expected %v or %v", errInvalidArch, arch, strings.Join(supported, ", "), archAll)
+		}
+	}
+	return nil
+}
+
+// getCapabilities returns the capabilities of the Build Service, retrieving them on first use.
+func (app *App) getCapabilities(ctx context.Context) (*build.Capabilities, error) {
+	if app.capabilities == nil {
+		caps, err := app.buildClient.GetCapabilities(ctx)
+		if err != nil {
+			return nil, err
+		}
+		app.capabilities = caps
+	}
+	return app.capabilities, nil
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	build "github.com/sylabs/scs-build-client/client"
+)
+
+func TestApp_RunArchs(t *testing.T) {
+	tests := []struct {
+		name         string
+		builderArchs []string // Architectures reported by the Build Service, if supported.
+		archs        []string
+		wantErr      error
+		wantFiles    []string
+	}{
+		{
+			name:         "All",
+			builderArchs: []string{"amd64", "arm64"},
+			archs:        []string{"all"},
+			wantFiles:    []string{"image-amd64.sif", "image-arm64.sif"},
+		},
+		{
+			name:         "AllSingle",
+			builderArchs: []string{"amd64"},
+			archs:        []string{"all"},
+			wantFiles:    []string{"image.sif"},
+		},
+		{
+			name:    "AllNotSupported",
+			archs:   []string{"all"},
+			wantErr: build.ErrNotSupported,
+		},
+		{
+			name:         "AllNoBuilders",
+			builderArchs: []string{},
+			archs:        []string{"all"},
+			wantErr:      errInvalidArch,
+		},
+		{
+			name:         "AllCombined",
+			builderArchs: []string{"amd64", "arm64"},
+			archs:        []string{"all", "amd64"},
+			wantErr:      errInvalidArch,
+		},
+		{
+			name:         "Explicit",
+			builderArchs: []string{"amd64", "arm64", "ppc64le"},
+			archs:        []string{"amd64", "ppc64le"},
+			wantFiles:    []string{"image-amd64.sif", "image-ppc64le.sif"},
+		},
+		{
+			name:         "ExplicitUnknown",
+			builderArchs: []string{"amd64", "arm64"},
+			archs:        []string{"amd64", "ppc64le"},
+			wantErr:      errInvalidArch,
+		},
+		{
+			name:      "ExplicitNotSupported",
+			archs:     []string{"amd64", "ppc64le"},
+			wantFiles: []string{"image-amd64.sif", "image-ppc64le.sif"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.builderArchs = tt.builderArchs
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			app, err := New(context.Background(), &Config{
+				URL:          m.frontend.URL,
+				BuildSpec:    defFile,
+				LibraryRef:   filepath.Join(dir, "image.sif"),
+				ArchsToBuild: tt.archs,
+			})
+
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if err != nil {
+				if got := m.submits.Load(); got != 0 {
+					t.Errorf("got %v submits, want 0", got)
+				}
+				return
+			}
+
+			if err := app.Run(context.Background()); err != nil {
+				t.Fatalf("run error: %v", err)
+			}
+
+			if got, want := m.submits.Load(), int64(len(tt.wantFiles)); got != want {
+				t.Errorf("got %v submits, want %v", got, want)
+			}
+
+			for _, name := range tt.wantFiles {
+				if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
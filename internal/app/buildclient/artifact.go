@@ -8,76 +8,650 @@ package buildclient
 import (
 	"bytes"
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/gorilla/websocket"
+	jsonresp "github.com/sylabs/json-resp"
 	build "github.com/sylabs/scs-build-client/client"
+	"github.com/sylabs/scs-build-client/internal/pkg/tlsdebug"
+	library "github.com/sylabs/scs-library-client/client"
 )
 
+// BuildFailureError is returned when a build does not succeed. It includes the final portion of the
+// build output, to aid diagnosis.
+type BuildFailureError struct {
+	Arch       string // Architecture of the failed build.
+	BuildID    string // ID of the failed build.
+	OutputTail string // Final portion of the build output.
+	Err        error  // Underlying error.
+}
+
+func (e *BuildFailureError) Error() string { return e.Err.Error() }
+
+func (e *BuildFailureError) Unwrap() error { return e.Err }
+
+// TimeoutError is returned when a build is not submitted, or does not complete, within the
+// configured timeout.
+type TimeoutError struct {
+	Op      string        // Operation that timed out ("submit" or "build").
+	BuildID string        // ID of the build, if submitted.
+	Timeout time.Duration // Timeout that expired.
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%v timed out after %v", e.Op, formatDuration(e.Timeout))
+}
+
+// Is returns true if target is context.DeadlineExceeded, so that a TimeoutError can be treated as
+// any other deadline.
+func (e *TimeoutError) Is(target error) bool { return target == context.DeadlineExceeded }
+
+// withTimeout returns a copy of ctx that is cancelled once timeout expires, with a TimeoutError
+// describing op as the cause. If timeout is not positive, the copy is not subject to a timeout.
+func withTimeout(ctx context.Context, op string, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, timeout, &TimeoutError{Op: op, Timeout: timeout})
+}
+
+// timeoutError returns the TimeoutError that caused ctx to be cancelled, with buildID set, or nil
+// if ctx was not cancelled due to a timeout.
+func timeoutError(ctx context.Context, buildID string) *TimeoutError {
+	var te *TimeoutError
+	if !errors.As(context.Cause(ctx), &te) {
+		return nil
+	}
+	return &TimeoutError{Op: te.Op, BuildID: buildID, Timeout: te.Timeout}
+}
+
+// buildCancelTimeout is the timeout of the request to cancel a build that has timed out, or whose
+// run was interrupted.
+const buildCancelTimeout = 5 * time.Second
+
 // buildArtifact sends a build request for the specified arch, optionally publishing it to
 // libraryRef. Output is streamed to standard output. If the build cannot be submitted, or does not
 // succeed, an error is returned.
+//
+// If app.buildTimeout is set and the build does not complete in time, the build is cancelled, and
+// a TimeoutError is returned. Similarly, a TimeoutError is returned if app.submitTimeout is set and
+// the build cannot be submitted in time.
 func (app *App) buildArtifact(ctx context.Context, arch string, def []byte, buildContext string, libraryRef string) (*build.BuildInfo, error) {
-	opts := []build.BuildOption{build.OptBuildArchitecture(arch), build.OptBuildContext(buildContext)}
+	opts := []build.BuildOption{build.OptBuildContext(buildContext)}
+	for k, v := range app.requirements {
+		opts = append(opts, build.OptBuildRequirement(k, v))
+	}
+	opts = append(opts, build.OptBuildArchitecture(arch))
 	if libraryRef != "" {
 		opts = append(opts, build.OptBuildLibraryRef(libraryRef))
 	}
+	if app.contextDir != "" {
+		// Relative sources are resolved against the build context, rather than the working directory.
+		opts = append(opts, build.OptBuildWorkingDirectory(app.contextDir))
+	}
+
+	// Retain the final portion of the build output, in case the build fails.
+	tail := newRingBuffer(app.outputTailSize)
+
+	w := io.MultiWriter(&pipeWriter{w: app.stdout}, tail)
+
+	if app.logFile != "" {
+		lw, err := app.openLogFile(arch)
+		if err != nil {
+			return nil, err
+		}
+		defer lw.Close()
+
+		w = io.MultiWriter(w, lw)
+	}
 
-	bi, err := app.buildClient.Submit(ctx, bytes.NewReader(def), opts...)
+	bctx, cancel := withTimeout(ctx, "build", app.buildTimeout)
+	defer cancel()
+
+	sctx, cancelSubmit := withTimeout(bctx, "submit", app.submitTimeout)
+	defer cancelSubmit()
+
+	bi, err := app.buildClient.Submit(sctx, bytes.NewReader(def), opts...)
 	if err != nil {
-		return nil, fmt.Errorf("error submitting remote build: %w", err)
+		if te := timeoutError(sctx, ""); te != nil {
+			return nil, te
+		}
+		if app.contextDigest != "" && errors.Is(err, build.ErrNotFound) {
+			return nil, unknownContextError(app.contextDigest)
+		}
+		app.checkFrontendConfig(err)
+		return nil, fmt.Errorf("error submitting remote build: %w", app.wrapBuildErr(err))
+	}
+
+	id := bi.ID()
+
+	st := &stateTracker{report: app.reportTransition}
+	st.update(bi)
+
+	var lw *outputLimitWriter
+	if app.maxOutputBytes > 0 {
+		lw = &outputLimitWriter{w: w, limit: app.maxOutputBytes}
+		if app.cancelOnOutputLimit {
+			lw.onExceed = func() {
+				fmt.Fprintf(os.Stderr, "Cancelling build %v\n", id)
+				app.cancelBuild(bctx, id)
+			}
+		}
+		w = lw
+	}
+
+	bi, err = app.awaitBuild(bctx, id, w, st)
+	if lw != nil && lw.exceeded {
+		app.outputTruncated[arch] = true
 	}
-	if err := app.buildClient.GetOutput(ctx, bi.ID(), os.Stdout); err != nil {
-		return nil, fmt.Errorf("error streaming remote build output: %w", err)
+	if err != nil {
+		if te := timeoutError(bctx, id); te != nil {
+			app.cancelBuild(ctx, id)
+			return nil, te
+		}
+		if ctx.Err() != nil {
+			// The run was interrupted, so the build is of no further use.
+			app.cancelBuild(ctx, id)
+		}
+		return nil, err
 	}
-	if bi, err = app.buildClient.GetStatus(ctx, bi.ID()); err != nil {
-		return nil, fmt.Errorf("error getting remote build status: %w", err)
+
+	if bi.Failed() {
+		err := errors.New(buildFailureReason(bi))
+		if app.outputTruncated[arch] && app.cancelOnOutputLimit {
+			err = fmt.Errorf("%w: build cancelled after %v bytes of output", errOutputLimit, app.maxOutputBytes)
+		}
+
+		return nil, &BuildFailureError{
+			Arch:       arch,
+			BuildID:    bi.ID(),
+			OutputTail: string(tail.Bytes()),
+			Err:        err,
+		}
 	}
 
-	// The returned info doesn't indicate an exit code, but a zero-sized image tells us something
-	// went wrong.
-	if bi.ImageSize() <= 0 {
-		return nil, errors.New("failed to build image")
+	if err := app.verifyBuiltDefinition(arch, bi, def); err != nil {
+		return nil, err
 	}
 
 	return bi, nil
 }
 
-func (app *App) retrieveArtifact(ctx context.Context, bi *build.BuildInfo, filename, arch string) error {
-	fp, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o770)
+// pipeWriter is an io.Writer that detects a broken pipe, such as when output is piped to a command
+// that exits before reading it all. Since this does not affect the build, a notice is reported,
+// and subsequent output is discarded. Writes continue to succeed, so that output is still streamed
+// to any other writers, such as the log file.
+type pipeWriter struct {
+	w        io.Writer
+	detached bool
+}
+
+func (pw *pipeWriter) Write(p []byte) (int, error) {
+	if pw.detached {
+		return len(p), nil
+	}
+
+	if _, err := pw.w.Write(p); errors.Is(err, syscall.EPIPE) {
+		fmt.Fprintf(os.Stderr, "Build output detached (broken pipe), awaiting completion\n")
+		pw.detached = true
+	} else if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+var errOutputLimit = errors.New("build output limit exceeded")
+
+// outputLimitWriter is an io.Writer that writes up to limit bytes of build output to w. Once the
+// limit is exceeded, a notice is reported, onExceed is called if set, and subsequent output is
+// discarded. Writes continue to succeed, so that the output stream is drained and the build is
+// unaffected.
+type outputLimitWriter struct {
+	w        io.Writer
+	limit    int64
+	n        int64 // Bytes written to w.
+	exceeded bool
+	onExceed func()
+}
+
+func (lw *outputLimitWriter) Write(p []byte) (int, error) {
+	if lw.exceeded {
+		return len(p), nil
+	}
+
+	if r := lw.limit - lw.n; int64(len(p)) > r {
+		n, err := lw.w.Write(p[:r])
+		lw.n += int64(n)
+		if err != nil {
+			return n, err
+		}
+
+		fmt.Fprintf(os.Stderr, "\nBuild output exceeded %v bytes, further output discarded\n", lw.limit)
+		lw.exceeded = true
+		if lw.onExceed != nil {
+			lw.onExceed()
+		}
+		return len(p), nil
+	}
+
+	n, err := lw.w.Write(p)
+	lw.n += int64(n)
+	return n, err
+}
+
+// cancelBuild requests cancellation of the build with the specified ID. The build may already be
+// complete, so failure is ignored.
+func (app *App) cancelBuild(ctx context.Context, id string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), buildCancelTimeout)
+	defer cancel()
+
+	_ = app.buildClient.Cancel(ctx, id)
+}
+
+// logFileWriter is an io.Writer that writes build output to a log file. Since the log file is a
+// copy of the output, a write error does not fail the build. Instead, a warning is reported, and
+// subsequent output is discarded.
+type logFileWriter struct {
+	f      *os.File
+	failed bool
+}
+
+// openLogFile opens the log file for the build for arch. When building for multiple architectures,
+// the architecture is inserted before the file extension. See archFileName. If app.logAppend is
+// set, output is appended to an existing file, otherwise the file is truncated.
+func (app *App) openLogFile(arch string) (*logFileWriter, error) {
+	name := app.logFile
+	if len(app.archsToBuild) > 1 {
+		// The default template requires no tag, so cannot fail.
+		name, _ = archFileName(defaultOutputTemplate, name, arch, "")
+	}
+
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if app.logAppend {
+		flag = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+
+	f, err := os.OpenFile(name, flag, 0o644)
 	if err != nil {
-		return fmt.Errorf("error opening file %s for writing: %w", filename, err)
+		return nil, fmt.Errorf("error opening log file: %w", err)
 	}
+	return &logFileWriter{f: f}, nil
+}
+
+func (lw *logFileWriter) Write(p []byte) (int, error) {
+	if lw.failed {
+		return len(p), nil
+	}
+
+	if _, err := lw.f.Write(p); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: error writing log file, further output will not be logged: %v\n", err)
+		lw.failed = true
+	}
+	return len(p), nil
+}
+
+// Close closes the log file, reporting a warning on failure.
+func (lw *logFileWriter) Close() {
+	if err := lw.f.Close(); err != nil && !lw.failed {
+		fmt.Fprintf(os.Stderr, "Warning: error closing log file: %v\n", err)
+	}
+}
+
+// statusPollInterval is the interval at which build status is polled while output is streamed.
+var statusPollInterval = 10 * time.Second
+
+// activityWriter is an io.Writer that signals each write on a channel. Once detached, writes are
+// discarded.
+type activityWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	activity chan struct{}
+}
+
+func (aw *activityWriter) Write(p []byte) (int, error) {
+	aw.mu.Lock()
+	defer aw.mu.Unlock()
+
+	if aw.w == nil {
+		return len(p), nil
+	}
+
+	select {
+	case aw.activity <- struct{}{}:
+	default:
+	}
+	return aw.w.Write(p)
+}
+
+// detach discards subsequent writes.
+func (aw *activityWriter) detach() {
+	aw.mu.Lock()
+	defer aw.mu.Unlock()
+
+	aw.w = nil
+}
+
+// awaitBuild streams the output of the build with the specified ID to w until the build completes,
+// and returns its final status. Status is reported to st as it is polled.
+//
+// Some Build Service versions report completion before the final output has been streamed. The
+// build is therefore considered finished once the output stream is closed by the server. If the
+// status reports completion first, output continues to be drained until the stream is closed, or
+// no output is received for app.outputGracePeriod. In the latter case, the stream is closed, and
+// any further output is discarded.
+func (app *App) awaitBuild(ctx context.Context, id string, w io.Writer, st *stateTracker) (*build.BuildInfo, error) {
+	aw := &activityWriter{w: w, activity: make(chan struct{}, 1)}
+	defer aw.detach()
+
+	// Once awaitBuild returns, the output stream is closed, and its goroutine awaited, so that
+	// neither is leaked.
+	outCtx, cancel := context.WithCancel(ctx)
+	finished := make(chan struct{})
 	defer func() {
-		_ = fp.Close()
+		cancel()
+		<-finished
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		defer close(finished)
+		done <- app.getOutput(outCtx, id, aw)
 	}()
 
-	h := sha256.New()
+	ticker := time.NewTicker(statusPollInterval)
+	defer ticker.Stop()
 
-	w := io.MultiWriter(fp, h)
+	for completed := false; !completed; {
+		select {
+		case err := <-done:
+			if err != nil {
+				return nil, fmt.Errorf("error streaming remote build output: %w", tlsdebug.Wrap(err, roleBuildOutput, app.buildURL))
+			}
+			completed = true
 
-	path, tag := splitLibraryRef(bi.LibraryRef())
+		case <-ticker.C:
+			// Errors are not fatal here, since status is retrieved again once output is drained.
+			bi, err := app.buildClient.GetStatus(ctx, id)
+			if err != nil {
+				continue
+			}
+			st.update(bi)
+
+			if bi.IsComplete() {
+				if err := drainOutput(ctx, done, aw.activity, app.outputGracePeriod); err != nil {
+					return nil, fmt.Errorf("error streaming remote build output: %w", err)
+				}
+				completed = true
+			}
 
-	if err := app.libraryClient.DownloadImage(ctx, w, arch, path, tag, nil); err != nil {
-		return fmt.Errorf("error downloading image %v: %w", bi.LibraryRef(), err)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	bi, err := app.buildClient.GetStatus(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting remote build status: %w", app.wrapBuildErr(err))
 	}
+	st.update(bi)
+	return bi, nil
+}
 
-	// Verify image checksum
-	if values := strings.Split(bi.ImageChecksum(), "."); len(values) == 2 {
-		if strings.ToLower(values[0]) == "sha256" {
-			imageChecksum := hex.EncodeToString(h.Sum(nil))
-			if values[1] != imageChecksum {
-				fmt.Fprintf(os.Stderr, "Error: image checksum mismatch (expecting %v, got %v)\n", values[1], imageChecksum)
-			} else {
-				fmt.Fprintf(os.Stderr, "Image checksum verified successfully.\n")
+// getOutput streams the output of the build with the specified ID to w. If app.logTimestamps is
+// set, each line is prefixed with the time it was received, in RFC 3339 format. The build is not
+// cancelled when ctx is done, since the stream may be closed once the build is complete. See
+// cancelBuild.
+func (app *App) getOutput(ctx context.Context, id string, w io.Writer) error {
+	opts := []build.OutputOption{build.OptOutputCancelBuild(false)}
+
+	if !app.logTimestamps {
+		return app.buildClient.GetOutputEvents(ctx, id, func(e build.OutputEvent) error {
+			if e.MessageType != websocket.TextMessage {
+				return nil
 			}
+
+			_, err := w.Write(e.Message)
+			return err
+		}, opts...)
+	}
+
+	return app.buildClient.GetOutputEvents(ctx, id, func(e build.OutputEvent) error {
+		if e.MessageType != websocket.TextMessage {
+			return nil
+		}
+
+		_, err := fmt.Fprintf(w, "%v %s\n", e.Received.Format(time.RFC3339), e.Message)
+		return err
+	}, append(opts, build.OptOutputSplitLines(true))...)
+}
+
+// drainOutput waits for the output stream to be closed, as signalled by done, or for no output
+// activity to be received for the grace period.
+func drainOutput(ctx context.Context, done <-chan error, activity <-chan struct{}, grace time.Duration) error {
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-activity:
+		case <-time.After(grace):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// downloadPartSize is the size of each part of a concurrent download.
+var downloadPartSize int64 = 8 << 20
+
+var errSizeMismatch = errors.New("size mismatch")
+
+// retrieveArtifact downloads the image described by bi to filename. See writeArtifact. If an
+// artifact cache is configured, the image is retrieved from the cache if present, and otherwise
+// added to the cache once downloaded. If filename is stdoutDestination, the image is streamed to
+// standard output instead. See streamArtifact.
+func (app *App) retrieveArtifact(ctx context.Context, bi *build.BuildInfo, filename, arch string) error {
+	if filename == stdoutDestination {
+		_, err := app.streamArtifact(ctx, bi, arch)
+		return err
+	}
+
+	key, cached := app.artifactCacheKey(bi)
+	if cached && app.retrieveCachedArtifact(ctx, key, filename, arch) {
+		return nil
+	}
+
+	if err := app.writeArtifact(filename, func(fp *os.File) (int64, error) {
+		return app.downloadArtifact(ctx, fp, bi, arch)
+	}); err != nil {
+		return err
+	}
+
+	if cached {
+		app.cacheArtifact(ctx, key, filename)
+	}
+	return nil
+}
+
+// downloadArtifact downloads the image described by bi to fp, and returns the number of bytes
+// downloaded. If the checksum of the image does not match that reported by the Build Service, an
+// error is returned.
+func (app *App) downloadArtifact(ctx context.Context, fp *os.File, bi *build.BuildInfo, arch string) (int64, error) {
+	path, tag := splitLibraryRef(bi.LibraryRef())
+
+	if app.downloadConcurrency > 1 {
+		err := app.downloadImageConcurrent(ctx, fp, bi, arch, path, tag)
+		if err == nil {
+			// Parts are written at their offsets, and the size verified.
+			return bi.ImageSize(), nil
+		}
+
+		// The server may not support ranged requests, so revert to a single stream.
+		fmt.Fprintf(os.Stderr, "Concurrent download failed (%v), reverting to single stream\n", err)
+
+		if err := fp.Truncate(0); err != nil {
+			return 0, fmt.Errorf("error truncating file %s: %w", fp.Name(), err)
+		}
+		if _, err := fp.Seek(0, io.SeekStart); err != nil {
+			return 0, fmt.Errorf("error seeking file %s: %w", fp.Name(), err)
+		}
+	}
+
+	var w io.Writer = fp
+
+	h := app.downloadHash.newHash()
+	if h != nil {
+		w = io.MultiWriter(fp, h)
+	}
+
+	tctx, m, done := app.libraryTransfer(ctx, "download", bi.ImageSize())
+	w = &stallWriter{w: w, m: m}
+
+	// The size of the response is used only to report progress, since it is unknown when the image
+	// is streamed using chunked encoding.
+	copyImage := func(size int64, r io.Reader, w io.Writer) error {
+		m.setTotal(size)
+
+		_, err := io.Copy(w, r)
+		return err
+	}
+
+	// A rejected request fails before any of the image is written, so the download can be retried.
+	err := done(app.withLibraryAuth(tctx, func() error {
+		return app.libraryClient.DownloadImage(tctx, w, arch, path, tag, copyImage)
+	}))
+	app.bytesDownloaded[arch] += m.snapshot().Transferred
+	if isLibraryUnavailable(err) {
+		fmt.Fprintf(os.Stderr, "Library download failed (%v), downloading image from Build Service\n", err)
+
+		if err := app.getArtifact(ctx, fp, bi, arch); err != nil {
+			return 0, err
 		}
+		return fp.Seek(0, io.SeekCurrent)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error downloading image %v: %w", bi.LibraryRef(), app.wrapLibraryErr(err))
+	}
+
+	if h != nil {
+		// Verify image checksum. A corrupt image is not renamed into place. See writeArtifact.
+		sum := h.Sum(nil)
+		if err := verifyChecksum(bi.ImageChecksum(), app.downloadHash, sum); err != nil {
+			return 0, fmt.Errorf("image %v: %w", bi.LibraryRef(), err)
+		}
+		app.downloadChecksums[arch] = app.downloadHash.formatChecksum(sum)
+	}
+
+	// The image is written sequentially, so the offset is the number of bytes downloaded.
+	return fp.Seek(0, io.SeekCurrent)
+}
+
+// isLibraryUnavailable returns true if err indicates that an image could not be downloaded from
+// the library because it was not found, or access to it was forbidden. The library client does not
+// export the errors it returns in these cases, so they are matched by message where necessary.
+func isLibraryUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, &jsonresp.Error{Code: http.StatusNotFound}) ||
+		errors.Is(err, &jsonresp.Error{Code: http.StatusForbidden}) {
+		return true
+	}
+
+	msg := err.Error()
+	return strings.Contains(msg, "requested image was not found") ||
+		strings.Contains(msg, fmt.Sprintf("unexpected http status code: %d", http.StatusForbidden))
+}
+
+// getArtifact downloads the image described by bi, built for arch, from the Build Service to fp,
+// replacing any partial contents. The image is verified by the Build Service client.
+func (app *App) getArtifact(ctx context.Context, fp *os.File, bi *build.BuildInfo, arch string) error {
+	if err := fp.Truncate(0); err != nil {
+		return fmt.Errorf("error truncating file %s: %w", fp.Name(), err)
+	}
+	if _, err := fp.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("error seeking file %s: %w", fp.Name(), err)
+	}
+
+	cw := &countingWriter{w: fp}
+	defer func() { app.bytesDownloaded[arch] += cw.n }()
+
+	if err := app.buildClient.GetArtifact(ctx, bi.ID(), cw); err != nil {
+		return fmt.Errorf("error downloading image %v from Build Service: %w", bi.ID(), app.wrapBuildErr(err))
+	}
+
+	reportArtifactVerified(bi)
+	return nil
+}
+
+// reportArtifactVerified reports whether the image described by bi, once downloaded from the Build
+// Service, was verified. The Build Service client verifies only sha256 checksums, where reported.
+func reportArtifactVerified(bi *build.BuildInfo) {
+	if alg, _, ok := splitChecksum(bi.ImageChecksum()); ok && alg == DownloadHashSHA256 {
+		fmt.Fprintf(os.Stderr, "Image checksum verified successfully.\n")
+	}
+}
+
+// downloadImageConcurrent downloads the image described by bi to fp, using multiple ranged
+// requests in parallel. The image is verified against the size and checksum in bi, so that a
+// server that does not honor ranged requests is detected. If app.downloadHash is off, only the
+// size is verified.
+//
+// A library that streams the image without reporting its length is read in a single stream by the
+// library client, so the download proceeds sequentially.
+func (app *App) downloadImageConcurrent(ctx context.Context, fp *os.File, bi *build.BuildInfo, arch, path, tag string) error {
+	size := bi.ImageSize()
+
+	spec := &library.Downloader{
+		Concurrency: app.downloadConcurrency,
+		PartSize:    downloadPartSize,
+	}
+
+	tctx, m, done := app.libraryTransfer(ctx, "download", size)
+
+	// Where the server reports the size of the image, pre-allocate the file, since parts are written
+	// at their offsets as they arrive. Pre-allocation is an optimization, so failure is ignored.
+	pb := &stallProgressBar{m: m, preallocate: func(n int64) { _ = fp.Truncate(n) }}
+
+	err := done(app.withLibraryAuth(tctx, func() error {
+		return app.libraryClient.ConcurrentDownloadImage(tctx, fp, arch, path, tag, spec, pb)
+	}))
+	app.bytesDownloaded[arch] += m.snapshot().Transferred
+	if err != nil {
+		return fmt.Errorf("error downloading image %v: %w", bi.LibraryRef(), app.wrapLibraryErr(err))
+	}
+
+	fi, err := fp.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() != size {
+		return fmt.Errorf("%w (expecting %v, got %v)", errSizeMismatch, size, fi.Size())
+	}
+
+	// Parts complete out of order, so the checksum is computed in a sequential pass.
+	h := app.downloadHash.newHash()
+	if h == nil {
+		return nil
+	}
+	if _, err := io.Copy(h, io.NewSectionReader(fp, 0, size)); err != nil {
+		return err
+	}
+
+	sum := h.Sum(nil)
+	if err := verifyChecksum(bi.ImageChecksum(), app.downloadHash, sum); err != nil {
+		return err
 	}
+	app.downloadChecksums[arch] = app.downloadHash.formatChecksum(sum)
 
 	return nil
 }
@@ -0,0 +1,869 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestApp_RunDownloadConcurrency(t *testing.T) {
+	// Use small parts, so that the mock image is downloaded in several parts.
+	defer func(n int64) { downloadPartSize = n }(downloadPartSize)
+	downloadPartSize = 4
+
+	tests := []struct {
+		name              string
+		rangedDownloads   bool
+		ignoreRange       bool
+		concurrency       uint
+		wantRangeRequests bool
+	}{
+		{
+			name:        "SingleStream",
+			concurrency: 1,
+		},
+		{
+			name:            "SingleStreamRangeSupported",
+			rangedDownloads: true,
+			concurrency:     1,
+		},
+		{
+			name:              "Concurrent",
+			rangedDownloads:   true,
+			concurrency:       4,
+			wantRangeRequests: true,
+		},
+		{
+			name:        "ConcurrentRangeUnsupported",
+			concurrency: 4,
+		},
+		{
+			name:              "ConcurrentRangeIgnored",
+			rangedDownloads:   true,
+			ignoreRange:       true,
+			concurrency:       4,
+			wantRangeRequests: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.rangedDownloads = tt.rangedDownloads
+			m.ignoreRange = tt.ignoreRange
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			imageFile := filepath.Join(dir, "image.sif")
+
+			app, err := New(context.Background(), &Config{
+				URL:                 m.frontend.URL,
+				BuildSpec:           defFile,
+				LibraryRef:          imageFile,
+				ArchsToBuild:        []string{"amd64"},
+				DownloadConcurrency: tt.concurrency,
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			if err := app.Run(context.Background()); err != nil {
+				t.Fatalf("run error: %v", err)
+			}
+
+			b, err := os.ReadFile(imageFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(b, mockImage) {
+				t.Errorf("got image %q, want %q", b, mockImage)
+			}
+
+			if got, want := m.rangeRequests.Load() > 1, tt.wantRangeRequests; got != want {
+				t.Errorf("got %v range requests", m.rangeRequests.Load())
+			}
+		})
+	}
+}
+
+func TestApp_RunChunkedDownload(t *testing.T) {
+	// Use small parts, so that the mock image would be downloaded in several parts.
+	defer func(n int64) { downloadPartSize = n }(downloadPartSize)
+	downloadPartSize = 4
+
+	tests := []struct {
+		name            string
+		rangedDownloads bool
+		concurrency     uint
+	}{
+		{
+			name:        "SingleStream",
+			concurrency: 1,
+		},
+		{
+			name:        "Concurrent",
+			concurrency: 4,
+		},
+		{
+			name:            "ConcurrentRangeIgnored",
+			rangedDownloads: true,
+			concurrency:     4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.chunked = true
+			m.rangedDownloads = tt.rangedDownloads
+			m.ignoreRange = tt.rangedDownloads
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			imageFile := filepath.Join(dir, "image.sif")
+
+			app, err := New(context.Background(), &Config{
+				URL:                 m.frontend.URL,
+				BuildSpec:           defFile,
+				LibraryRef:          imageFile,
+				ArchsToBuild:        []string{"amd64"},
+				DownloadConcurrency: tt.concurrency,
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			var events []transferProgress
+			app.progress = func(p transferProgress) { events = append(events, p) }
+
+			if err := app.Run(context.Background()); err != nil {
+				t.Fatalf("run error: %v", err)
+			}
+
+			b, err := os.ReadFile(imageFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(b, mockImage) {
+				t.Errorf("got image %q, want %q", b, mockImage)
+			}
+
+			if got, want := app.downloadChecksums["amd64"], imageChecksum(); got != want {
+				t.Errorf("got checksum %v, want %v", got, want)
+			}
+
+			// The download completes with its size reported as unknown.
+			if len(events) == 0 {
+				t.Fatal("no progress reported")
+			}
+			want := transferProgress{Op: "download", Transferred: int64(len(mockImage)), Total: -1, Done: true}
+			if got := events[len(events)-1]; got != want {
+				t.Errorf("got progress %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestApp_RunLibraryUnavailable(t *testing.T) {
+	tests := []struct {
+		name             string
+		libraryStatus    int
+		concurrency      uint
+		wantArtifactGets int64
+		wantErr          bool
+	}{
+		{
+			name:             "NotFound",
+			libraryStatus:    http.StatusNotFound,
+			concurrency:      1,
+			wantArtifactGets: 1,
+		},
+		{
+			name:             "Forbidden",
+			libraryStatus:    http.StatusForbidden,
+			concurrency:      1,
+			wantArtifactGets: 1,
+		},
+		{
+			name:             "ForbiddenConcurrent",
+			libraryStatus:    http.StatusForbidden,
+			concurrency:      4,
+			wantArtifactGets: 1,
+		},
+		{
+			name:          "InternalServerError",
+			libraryStatus: http.StatusInternalServerError,
+			concurrency:   1,
+			wantErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.libraryStatus = tt.libraryStatus
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			imageFile := filepath.Join(dir, "image.sif")
+
+			app, err := New(context.Background(), &Config{
+				URL:                 m.frontend.URL,
+				BuildSpec:           defFile,
+				LibraryRef:          imageFile,
+				ArchsToBuild:        []string{"amd64"},
+				DownloadConcurrency: tt.concurrency,
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			err = app.Run(context.Background())
+
+			if got, want := m.artifactGets.Load(), tt.wantArtifactGets; got != want {
+				t.Errorf("got %v Build Service downloads, want %v", got, want)
+			}
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("unexpected success")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("run error: %v", err)
+			}
+
+			b, err := os.ReadFile(imageFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(b, mockImage) {
+				t.Errorf("got image %q, want %q", b, mockImage)
+			}
+		})
+	}
+}
+
+func TestApp_RunLateOutput(t *testing.T) {
+	// Poll status frequently, so that completion is reported before output is streamed in full.
+	defer func(d time.Duration) { statusPollInterval = d }(statusPollInterval)
+	statusPollInterval = 10 * time.Millisecond
+
+	tests := []struct {
+		name            string
+		lateOutputDelay time.Duration
+		gracePeriod     time.Duration
+		wantLateOutput  bool
+	}{
+		{
+			name:            "WithinGracePeriod",
+			lateOutputDelay: 200 * time.Millisecond,
+			gracePeriod:     2 * time.Second,
+			wantLateOutput:  true,
+		},
+		{
+			name:            "GracePeriodElapsed",
+			lateOutputDelay: 2 * time.Second,
+			gracePeriod:     200 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.failBuild = true // Report output tail in error.
+			m.output = []string{"early output\n"}
+			m.lateOutput = []string{"late output\n"}
+			m.lateOutputDelay = tt.lateOutputDelay
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			app, err := New(context.Background(), &Config{
+				URL:               m.frontend.URL,
+				BuildSpec:         defFile,
+				LibraryRef:        filepath.Join(dir, "image.sif"),
+				ArchsToBuild:      []string{"amd64"},
+				OutputGracePeriod: tt.gracePeriod,
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			start := time.Now()
+
+			var bfe *BuildFailureError
+			if err := app.Run(context.Background()); !errors.As(err, &bfe) {
+				t.Fatalf("got error %v, want BuildFailureError", err)
+			}
+
+			if got, want := strings.Contains(bfe.OutputTail, "late output"), tt.wantLateOutput; got != want {
+				t.Errorf("got output %q, want late output %v", bfe.OutputTail, want)
+			}
+
+			if !strings.Contains(bfe.OutputTail, "early output") {
+				t.Errorf("got output %q, want early output", bfe.OutputTail)
+			}
+
+			if elapsed := time.Since(start); elapsed >= tt.lateOutputDelay && !tt.wantLateOutput {
+				t.Errorf("run took %v, want less than %v", elapsed, tt.lateOutputDelay)
+			}
+
+			// Closing the output stream once the grace period elapses does not cancel the build.
+			if got := m.cancels.Load(); got != 0 {
+				t.Errorf("got %v cancels, want 0", got)
+			}
+		})
+	}
+}
+
+func TestApp_RunTimeout(t *testing.T) {
+	tests := []struct {
+		name          string
+		hangBuild     bool
+		submitDelay   time.Duration
+		buildTimeout  time.Duration
+		submitTimeout time.Duration
+		wantErr       *TimeoutError
+		wantCancel    bool
+	}{
+		{
+			name:         "WithinTimeout",
+			buildTimeout: 10 * time.Second,
+		},
+		{
+			name:         "BuildTimeout",
+			hangBuild:    true,
+			buildTimeout: 200 * time.Millisecond,
+			wantErr:      &TimeoutError{Op: "build", BuildID: mockBuildID, Timeout: 200 * time.Millisecond},
+			wantCancel:   true,
+		},
+		{
+			name:          "SubmitTimeout",
+			submitDelay:   10 * time.Second,
+			buildTimeout:  time.Minute,
+			submitTimeout: 100 * time.Millisecond,
+			wantErr:       &TimeoutError{Op: "submit", Timeout: 100 * time.Millisecond},
+		},
+		{
+			name:         "SubmitWithinBuildTimeout",
+			submitDelay:  10 * time.Second,
+			buildTimeout: 100 * time.Millisecond,
+			wantErr:      &TimeoutError{Op: "build", Timeout: 100 * time.Millisecond},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.hangBuild = tt.hangBuild
+			m.submitDelay = tt.submitDelay
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			app, err := New(context.Background(), &Config{
+				URL:           m.frontend.URL,
+				BuildSpec:     defFile,
+				LibraryRef:    filepath.Join(dir, "image.sif"),
+				ArchsToBuild:  []string{"amd64"},
+				BuildTimeout:  tt.buildTimeout,
+				SubmitTimeout: tt.submitTimeout,
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			err = app.Run(context.Background())
+
+			if got, want := m.cancels.Load() > 0, tt.wantCancel; got != want {
+				t.Errorf("got %v cancellations, want cancellation %v", m.cancels.Load(), want)
+			}
+
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("run error: %v", err)
+				}
+				return
+			}
+
+			var te *TimeoutError
+			if !errors.As(err, &te) {
+				t.Fatalf("got error %v, want TimeoutError", err)
+			}
+
+			if got, want := *te, *tt.wantErr; got != want {
+				t.Errorf("got error %+v, want %+v", got, want)
+			}
+
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("got error %v, want deadline exceeded", err)
+			}
+		})
+	}
+}
+
+// brokenPipeWriter is an io.Writer that accepts n bytes, and then fails as if the reader of a pipe
+// had gone away.
+type brokenPipeWriter struct {
+	n       int
+	written bytes.Buffer
+}
+
+func (w *brokenPipeWriter) Write(p []byte) (int, error) {
+	if r := w.n - w.written.Len(); len(p) > r {
+		w.written.Write(p[:r])
+		return r, syscall.EPIPE
+	}
+	return w.written.Write(p)
+}
+
+func TestApp_RunBrokenPipe(t *testing.T) {
+	// Poll status frequently, since output is no longer streamed once the pipe is broken.
+	defer func(d time.Duration) { statusPollInterval = d }(statusPollInterval)
+	statusPollInterval = 10 * time.Millisecond
+
+	tests := []struct {
+		name          string
+		n             int
+		logTimestamps bool
+	}{
+		{"Immediate", 0, false},
+		{"MidStream", 10, false},
+		{"MidStreamTimestamps", 30, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.output = []string{"first line of output\n", "second line of output\n", "third line of output\n"}
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			imageFile := filepath.Join(dir, "image.sif")
+			logFile := filepath.Join(dir, "build.log")
+
+			app, err := New(context.Background(), &Config{
+				URL:           m.frontend.URL,
+				BuildSpec:     defFile,
+				LibraryRef:    imageFile,
+				ArchsToBuild:  []string{"amd64"},
+				LogTimestamps: tt.logTimestamps,
+				LogFile:       logFile,
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			w := &brokenPipeWriter{n: tt.n}
+			app.stdout = w
+
+			if err := app.Run(context.Background()); err != nil {
+				t.Fatalf("run error: %v", err)
+			}
+
+			if got, want := w.written.Len(), tt.n; got != want {
+				t.Errorf("got %v bytes of output, want %v", got, want)
+			}
+
+			b, err := os.ReadFile(imageFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(b, mockImage) {
+				t.Errorf("got image %q, want %q", b, mockImage)
+			}
+
+			// Output continues to be written to the log file once standard output is detached.
+			if b, err := os.ReadFile(logFile); err != nil {
+				t.Error(err)
+			} else if !strings.Contains(string(b), "third line of output") {
+				t.Errorf("got log %q, want all output", b)
+			}
+
+			if got := m.cancels.Load(); got != 0 {
+				t.Errorf("got %v cancellations, want none", got)
+			}
+		})
+	}
+}
+
+func TestApp_RunLogTimestamps(t *testing.T) {
+	tests := []struct {
+		name          string
+		logTimestamps bool
+		wantTail      *regexp.Regexp
+	}{
+		{
+			name:     "Raw",
+			wantTail: regexp.MustCompile(`\Afirst line\nsecond line\n\z`),
+		},
+		{
+			name:          "Timestamps",
+			logTimestamps: true,
+			wantTail:      regexp.MustCompile(`\A\S+ first line\n\S+ second line\n\z`),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.failBuild = true // Report output tail in error.
+			m.output = []string{"first ", "line\nsecond line\n"}
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			app, err := New(context.Background(), &Config{
+				URL:           m.frontend.URL,
+				BuildSpec:     defFile,
+				LibraryRef:    filepath.Join(dir, "image.sif"),
+				ArchsToBuild:  []string{"amd64"},
+				LogTimestamps: tt.logTimestamps,
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			var bfe *BuildFailureError
+			if err := app.Run(context.Background()); !errors.As(err, &bfe) {
+				t.Fatalf("got error %v, want BuildFailureError", err)
+			}
+
+			if !tt.wantTail.MatchString(bfe.OutputTail) {
+				t.Fatalf("got output %q, want match %v", bfe.OutputTail, tt.wantTail)
+			}
+
+			if !tt.logTimestamps {
+				return
+			}
+
+			for _, line := range strings.Split(strings.TrimSuffix(bfe.OutputTail, "\n"), "\n") {
+				ts, _, _ := strings.Cut(line, " ")
+				if _, err := time.Parse(time.RFC3339, ts); err != nil {
+					t.Errorf("line %q: %v", line, err)
+				}
+			}
+		})
+	}
+}
+
+func TestApp_RunLogFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		archs     []string
+		logAppend bool
+		wantFiles map[string]string
+	}{
+		{
+			name:      "Truncate",
+			archs:     []string{"amd64"},
+			wantFiles: map[string]string{"build.log": "build output\n"},
+		},
+		{
+			name:      "Append",
+			archs:     []string{"amd64"},
+			logAppend: true,
+			wantFiles: map[string]string{"build.log": "previous output\nbuild output\n"},
+		},
+		{
+			name:  "MultipleArchs",
+			archs: []string{"amd64", "arm64"},
+			wantFiles: map[string]string{
+				"build-amd64.log": "build output\n",
+				"build-arm64.log": "build output\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.output = []string{"build ", "output\n"}
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			logFile := filepath.Join(dir, "build.log")
+			if err := os.WriteFile(logFile, []byte("previous output\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			app, err := New(context.Background(), &Config{
+				URL:          m.frontend.URL,
+				BuildSpec:    defFile,
+				LibraryRef:   filepath.Join(dir, "image.sif"),
+				ArchsToBuild: tt.archs,
+				LogFile:      logFile,
+				LogAppend:    tt.logAppend,
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			if err := app.Run(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			for name, want := range tt.wantFiles {
+				b, err := os.ReadFile(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got := string(b); got != want {
+					t.Errorf("%v: got %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLogFileWriter(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "build.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lw := &logFileWriter{f: f}
+
+	if n, err := lw.Write([]byte("first\n")); err != nil || n != 6 {
+		t.Fatalf("got (%v, %v), want (6, nil)", n, err)
+	}
+
+	// Write errors are not reported to the caller, so that the build continues.
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if n, err := lw.Write([]byte("second\n")); err != nil || n != 7 {
+			t.Fatalf("got (%v, %v), want (7, nil)", n, err)
+		}
+
+		if !lw.failed {
+			t.Fatal("write failure not recorded")
+		}
+	}
+
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := string(b), "first\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestOutputLimitWriter(t *testing.T) {
+	tests := []struct {
+		name         string
+		limit        int64
+		writes       []string
+		wantOutput   string
+		wantExceeded bool
+	}{
+		{"WithinLimit", 10, []string{"01234", "56789"}, "0123456789", false},
+		{"SplitWrite", 8, []string{"01234", "56789"}, "01234567", true},
+		{"Discarded", 5, []string{"01234", "56789", "abc"}, "01234", true},
+		{"FirstWrite", 2, []string{"01234"}, "01", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			var calls int
+
+			lw := &outputLimitWriter{w: &buf, limit: tt.limit, onExceed: func() { calls++ }}
+
+			for _, s := range tt.writes {
+				// Writes succeed regardless of the limit, so that output continues to be drained.
+				if n, err := lw.Write([]byte(s)); err != nil || n != len(s) {
+					t.Fatalf("got (%v, %v), want (%v, nil)", n, err, len(s))
+				}
+			}
+
+			if got, want := buf.String(), tt.wantOutput; got != want {
+				t.Errorf("got output %q, want %q", got, want)
+			}
+
+			if got, want := lw.exceeded, tt.wantExceeded; got != want {
+				t.Errorf("got exceeded %v, want %v", got, want)
+			}
+
+			// onExceed is called once, when the limit is first exceeded.
+			if got, want := calls == 1, tt.wantExceeded; got != want {
+				t.Errorf("got %v calls, want called %v", calls, want)
+			}
+		})
+	}
+
+	t.Run("WriteError", func(t *testing.T) {
+		w := &brokenPipeWriter{n: 3}
+		lw := &outputLimitWriter{w: w, limit: 5}
+
+		if _, err := lw.Write([]byte("0123456789")); !errors.Is(err, syscall.EPIPE) {
+			t.Errorf("got error %v, want %v", err, syscall.EPIPE)
+		}
+	})
+}
+
+func TestApp_RunOutputLimit(t *testing.T) {
+	tests := []struct {
+		name                string
+		maxOutputBytes      int64
+		cancelOnOutputLimit bool
+		wantOutput          string
+		wantTruncated       bool
+		wantErr             error
+	}{
+		{
+			name:       "NoLimit",
+			wantOutput: "first line\nsecond line\nthird line\n",
+		},
+		{
+			name:           "WithinLimit",
+			maxOutputBytes: 1 << 10,
+			wantOutput:     "first line\nsecond line\nthird line\n",
+		},
+		{
+			name:           "Discard",
+			maxOutputBytes: 16,
+			wantOutput:     "first line\nsecon",
+			wantTruncated:  true,
+		},
+		{
+			name:                "Cancel",
+			maxOutputBytes:      16,
+			cancelOnOutputLimit: true,
+			wantOutput:          "first line\nsecon",
+			wantTruncated:       true,
+			wantErr:             errOutputLimit,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.output = []string{"first line\n", "second line\n", "third line\n"}
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			imageFile := filepath.Join(dir, "image.sif")
+			resumeFile := filepath.Join(dir, "metadata.json")
+
+			app, err := New(context.Background(), &Config{
+				URL:                 m.frontend.URL,
+				BuildSpec:           defFile,
+				LibraryRef:          imageFile,
+				ArchsToBuild:        []string{"amd64"},
+				ResumeFile:          resumeFile,
+				MaxOutputBytes:      tt.maxOutputBytes,
+				CancelOnOutputLimit: tt.cancelOnOutputLimit,
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			var out bytes.Buffer
+			app.stdout = &out
+
+			err = app.Run(context.Background())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if got, want := out.String(), "Building for amd64...\n"+tt.wantOutput; got != want {
+				t.Errorf("got output %q, want %q", got, want)
+			}
+
+			if got, want := m.cancels.Load() > 0, tt.cancelOnOutputLimit; got != want {
+				t.Errorf("got %v cancellations, want cancellation %v", m.cancels.Load(), want)
+			}
+
+			// The image is retrieved, unless the build was cancelled.
+			if err == nil {
+				b, err := os.ReadFile(imageFile)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !bytes.Equal(b, mockImage) {
+					t.Errorf("got image %q, want %q", b, mockImage)
+				}
+			}
+
+			md, err := readMetadata(resumeFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if am := md.arch("amd64"); am == nil {
+				t.Error("outcome not recorded")
+			} else if got, want := am.OutputTruncated, tt.wantTruncated; got != want {
+				t.Errorf("got truncated %v, want %v", got, want)
+			}
+		})
+	}
+}
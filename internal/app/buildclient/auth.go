@@ -0,0 +1,116 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	jsonresp "github.com/sylabs/json-resp"
+	build "github.com/sylabs/scs-build-client/client"
+	library "github.com/sylabs/scs-library-client/client"
+)
+
+// isLibraryUnauthorized returns true if err indicates a library request was rejected as
+// unauthorized.
+func isLibraryUnauthorized(err error) bool {
+	return errors.Is(err, library.ErrUnauthorized) ||
+		errors.Is(err, &jsonresp.Error{Code: http.StatusUnauthorized})
+}
+
+// setLibraryToken obtains a token from app.authTokenFunc, and configures the library client to use
+// it. If refresh is true, a fresh token is requested.
+func (app *App) setLibraryToken(ctx context.Context, refresh bool) error {
+	token, err := app.authTokenFunc(ctx, refresh)
+	if err != nil {
+		return fmt.Errorf("error getting auth token: %w", err)
+	}
+	app.libraryClient.AuthToken = token
+	return nil
+}
+
+// withLibraryAuth runs op, which performs one or more library requests. If app.authTokenFunc is
+// set, a current token is obtained before op is run. If op is rejected as unauthorized, a fresh
+// token is obtained and op is retried once, so that operations that follow a long build do not fail
+// due to token expiry.
+func (app *App) withLibraryAuth(ctx context.Context, op func() error) error {
+	if app.authTokenFunc == nil {
+		return op()
+	}
+
+	if err := app.setLibraryToken(ctx, false); err != nil {
+		return err
+	}
+
+	if err := op(); !isLibraryUnauthorized(err) {
+		return err
+	}
+
+	if err := app.setLibraryToken(ctx, true); err != nil {
+		return err
+	}
+
+	err := op()
+	if isLibraryUnauthorized(err) {
+		return fmt.Errorf("%w: %w", build.ErrTokenRejected, err)
+	}
+	return err
+}
+
+// envAuthToken is the environment variable from which the access token is read.
+const envAuthToken = "SYLABS_AUTH_TOKEN"
+
+var errAuthTokenRequired = errors.New("access token required")
+
+var errPermissionDenied = errors.New("access token does not permit operation")
+
+// isUnauthorized returns true if err indicates a Build or Library Service request was rejected as
+// unauthorized, because the access token is missing, invalid or expired.
+func isUnauthorized(err error) bool {
+	return errors.Is(err, build.ErrUnauthorized) || isLibraryUnauthorized(err)
+}
+
+// isForbidden returns true if err indicates a Build or Library Service request was rejected as
+// forbidden, because the access token, while valid, does not permit the operation.
+func isForbidden(err error) bool {
+	return errors.Is(err, build.ErrForbidden) || errors.Is(err, &jsonresp.Error{Code: http.StatusForbidden})
+}
+
+// isAuthRejected returns true if err indicates a Build or Library Service request was rejected as
+// unauthorized or forbidden.
+func isAuthRejected(err error) bool {
+	return isUnauthorized(err) || isForbidden(err)
+}
+
+// translateAuthErr returns err annotated with guidance, if err indicates a request was rejected.
+// If no token was configured, guidance on supplying one is given. If a token was configured and
+// the request was forbidden, err is annotated to make clear a fresh token will not help. Otherwise,
+// err is returned unchanged.
+func (app *App) translateAuthErr(err error) error {
+	if err == nil || !isAuthRejected(err) {
+		return err
+	}
+
+	if !app.noAuthToken {
+		if !isForbidden(err) {
+			return err
+		}
+
+		return fmt.Errorf("%w: %w\n\n"+
+			"The access token was accepted, but its owner is not permitted to perform the operation.\n"+
+			"Check the owner has access to the entity or collection concerned, or use another token",
+			errPermissionDenied, err)
+	}
+
+	return fmt.Errorf("%w: %w\n\n"+
+		"No access token is configured. Create one at %v/tokens, and supply it using the --%v flag\n"+
+		"or the %v environment variable, or store it in the Singularity remote config\n"+
+		"(~/.singularity/remote.yaml) using 'singularity remote login'",
+		errAuthTokenRequired, err, strings.TrimSuffix(app.frontendURL, "/"), keyAccessToken, envAuthToken)
+}
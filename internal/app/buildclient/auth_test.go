@@ -0,0 +1,276 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	jsonresp "github.com/sylabs/json-resp"
+	build "github.com/sylabs/scs-build-client/client"
+	library "github.com/sylabs/scs-library-client/client"
+)
+
+func TestApp_RunTokenRefresh(t *testing.T) {
+	// cachingTokenFunc returns a token function that returns a cached token, which is only replaced
+	// when a refresh is requested.
+	cachingTokenFunc := func() build.BearerTokenFunc {
+		var mu sync.Mutex
+		token := "stale"
+		return func(_ context.Context, refresh bool) (string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if refresh {
+				token = "fresh"
+			}
+			return token, nil
+		}
+	}
+
+	// naiveTokenFunc returns a stale token unless a refresh is requested.
+	naiveTokenFunc := func() build.BearerTokenFunc {
+		return func(_ context.Context, refresh bool) (string, error) {
+			if refresh {
+				return "fresh", nil
+			}
+			return "stale", nil
+		}
+	}
+
+	tests := []struct {
+		name         string
+		tokenFunc    build.BearerTokenFunc
+		rotateToken  string
+		wantErr      error
+		wantErrStep  string
+		wantRejected []string
+	}{
+		{
+			name:        "NoExpiry",
+			tokenFunc:   cachingTokenFunc(),
+			rotateToken: "stale",
+		},
+		{
+			name:        "CachingTokenFunc",
+			tokenFunc:   cachingTokenFunc(),
+			rotateToken: "fresh",
+			wantRejected: []string{
+				"/v1/build/" + mockBuildID,
+			},
+		},
+		{
+			name:        "NaiveTokenFunc",
+			tokenFunc:   naiveTokenFunc(),
+			rotateToken: "fresh",
+			wantRejected: []string{
+				"/v1/build/" + mockBuildID,
+				"/v1/imagefile/" + mockLibraryRef,
+				"/v1/build-context/",
+			},
+		},
+		{
+			name:        "TokenRejected",
+			tokenFunc:   naiveTokenFunc(),
+			rotateToken: "revoked",
+			wantErr:     build.ErrTokenRejected,
+			wantErrStep: "error getting remote build status",
+			wantRejected: []string{
+				"/v1/build/" + mockBuildID,
+				"/v1/build/" + mockBuildID,
+				"/v1/build-context/",
+				"/v1/build-context/",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.acceptToken = "stale"
+			m.rotateToken = tt.rotateToken
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			m.files = []string{defFile}
+
+			dst := filepath.Join(dir, "image.sif")
+
+			app, err := New(context.Background(), &Config{
+				URL:           m.frontend.URL,
+				AuthTokenFunc: tt.tokenFunc,
+				BuildSpec:     defFile,
+				LibraryRef:    dst,
+				ArchsToBuild:  []string{"amd64"},
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			err = app.Run(context.Background())
+
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if err != nil && !strings.Contains(err.Error(), tt.wantErrStep) {
+				t.Errorf("error %q does not name step %q", err, tt.wantErrStep)
+			}
+
+			// Context deletion is identified by path prefix, since the digest is not known.
+			var rejected []string
+			for _, path := range m.rejected {
+				if strings.HasPrefix(path, "/v1/build-context/") {
+					path = "/v1/build-context/"
+				}
+				rejected = append(rejected, path)
+			}
+
+			if got, want := rejected, tt.wantRejected; !reflect.DeepEqual(got, want) {
+				t.Errorf("got rejected requests %v, want %v", got, want)
+			}
+
+			if err == nil {
+				if err := verifyFileChecksum(dst, imageChecksum()); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
+
+func TestApp_RunAuthTokenRequired(t *testing.T) {
+	tests := []struct {
+		name         string
+		authToken    string
+		wantGuidance bool
+	}{
+		{name: "NoToken", wantGuidance: true},
+		{name: "TokenRejected", authToken: "invalid"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.acceptToken = "valid"
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			app, err := New(context.Background(), &Config{
+				URL:          m.frontend.URL,
+				AuthToken:    tt.authToken,
+				BuildSpec:    defFile,
+				LibraryRef:   filepath.Join(dir, "image.sif"),
+				ArchsToBuild: []string{"amd64"},
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			err = app.Run(context.Background())
+			if err == nil {
+				t.Fatal("unexpected success")
+			}
+
+			if got, want := errors.Is(err, errAuthTokenRequired), tt.wantGuidance; got != want {
+				t.Errorf("got guidance %v, want %v (error %q)", got, want, err)
+			}
+
+			// The rejection itself is reported either way.
+			if !errors.Is(err, &jsonresp.Error{Code: http.StatusUnauthorized}) {
+				t.Errorf("got error %v, want unauthorized", err)
+			}
+
+			for _, s := range []string{"--" + keyAccessToken, envAuthToken, "remote.yaml", m.frontend.URL + "/tokens"} {
+				if got, want := strings.Contains(err.Error(), s), tt.wantGuidance; got != want {
+					t.Errorf("error mentions %q: got %v, want %v", s, got, want)
+				}
+			}
+
+			if got := m.submits.Load(); got != 0 {
+				t.Errorf("got %v submits, want 0", got)
+			}
+		})
+	}
+}
+
+func TestIsAuthRejected(t *testing.T) {
+	tests := []struct {
+		name             string
+		err              error
+		wantUnauthorized bool
+		wantForbidden    bool
+	}{
+		{"Nil", nil, false, false},
+		{"Other", errors.New("other"), false, false},
+		{"BuildUnauthorized", fmt.Errorf("submit: %w", build.ErrUnauthorized), true, false},
+		{"BuildForbidden", fmt.Errorf("submit: %w", build.ErrForbidden), false, true},
+		{"LibraryUnauthorized", fmt.Errorf("push: %w", library.ErrUnauthorized), true, false},
+		{"JSONUnauthorized", &jsonresp.Error{Code: http.StatusUnauthorized}, true, false},
+		{"JSONForbidden", &jsonresp.Error{Code: http.StatusForbidden}, false, true},
+		{"JSONNotFound", &jsonresp.Error{Code: http.StatusNotFound}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantUnauthorized, isUnauthorized(tt.err))
+			assert.Equal(t, tt.wantForbidden, isForbidden(tt.err))
+			assert.Equal(t, tt.wantUnauthorized || tt.wantForbidden, isAuthRejected(tt.err))
+		})
+	}
+}
+
+func TestApp_TranslateAuthErr(t *testing.T) {
+	tests := []struct {
+		name        string
+		noAuthToken bool
+		err         error
+		wantErr     error
+	}{
+		{"NoTokenUnauthorized", true, build.ErrUnauthorized, errAuthTokenRequired},
+		{"NoTokenForbidden", true, build.ErrForbidden, errAuthTokenRequired},
+		{"TokenUnauthorized", false, build.ErrUnauthorized, nil},
+		{"TokenForbidden", false, build.ErrForbidden, errPermissionDenied},
+		{"LibraryForbidden", false, &jsonresp.Error{Code: http.StatusForbidden}, errPermissionDenied},
+		{"Other", false, errors.New("other"), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &App{noAuthToken: tt.noAuthToken, frontendURL: "https://cloud.example.com/"}
+
+			err := app.translateAuthErr(tt.err)
+
+			// The original error is preserved, so that its category is unchanged.
+			if !errors.Is(err, tt.err) {
+				t.Errorf("got error %v, want %v", err, tt.err)
+			}
+
+			if tt.wantErr == nil {
+				assert.Equal(t, tt.err, err)
+			} else if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
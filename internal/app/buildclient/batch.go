@@ -0,0 +1,214 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// definitionNamePlaceholder is replaced by the name of each definition in the destination, and
+// other outputs, of a batch. See definitionName.
+const definitionNamePlaceholder = "{name}"
+
+var (
+	errBatchBuildSpec   = errors.New("build spec may not be specified along with definition files")
+	errBatchDestination = errors.New(definitionNamePlaceholder + " required when building more than one definition")
+)
+
+// batch describes a run building several definitions in turn. Outputs are templates, in which
+// definitionNamePlaceholder is replaced by the name of each definition.
+type batch struct {
+	defs           []string
+	dst            string
+	tags           []string
+	resumeFile     string
+	provenanceFile string
+	logFile        string
+	metadataFile   string
+	name           string            // Name of the definition being built.
+	contexts       map[string]string // Uploaded build contexts, by digest of their sources. See contextDigest.
+}
+
+// definitionName returns the name of the definition at path, which is its file name without
+// extension.
+func definitionName(path string) string {
+	name := filepath.Base(path)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// expandDefinitionName returns s with definitionNamePlaceholder replaced by name.
+func expandDefinitionName(s, name string) string {
+	return strings.ReplaceAll(s, definitionNamePlaceholder, name)
+}
+
+// setBatch configures app to build each of cfg.DefFiles in turn. Where more than one definition is
+// built, each of the destination and other outputs configured must contain
+// definitionNamePlaceholder, so that the outputs of one definition do not overwrite another.
+func (app *App) setBatch(cfg *Config) error {
+	if cfg.BuildSpec != "" {
+		return errBatchBuildSpec
+	}
+
+	if len(cfg.DefFiles) > 1 {
+		for _, s := range []string{cfg.LibraryRef, cfg.ResumeFile, cfg.ProvenanceFile, cfg.LogFile, cfg.MetadataFile} {
+			// Standard output is shared by every definition, so needs no placeholder.
+			if s != "" && s != stdoutDestination && !strings.Contains(s, definitionNamePlaceholder) {
+				return fmt.Errorf("%w: %v", errBatchDestination, s)
+			}
+		}
+	}
+
+	app.batch = &batch{
+		defs:           cfg.DefFiles,
+		dst:            cfg.LibraryRef,
+		tags:           cfg.Tags,
+		resumeFile:     cfg.ResumeFile,
+		provenanceFile: cfg.ProvenanceFile,
+		logFile:        cfg.LogFile,
+		metadataFile:   cfg.MetadataFile,
+		contexts:       make(map[string]string),
+	}
+
+	return nil
+}
+
+// setDefinition configures app to build the definition at path, as part of a batch.
+func (app *App) setDefinition(path string) error {
+	b := app.batch
+
+	b.name = definitionName(path)
+
+	if _, err := app.setDestination(expandDefinitionName(b.dst, b.name), b.tags); err != nil {
+		return err
+	}
+
+	app.buildSpec = path
+	app.resumeFile = expandDefinitionName(b.resumeFile, b.name)
+	app.provenanceFile = expandDefinitionName(b.provenanceFile, b.name)
+	app.logFile = expandDefinitionName(b.logFile, b.name)
+	app.metadataFile = expandDefinitionName(b.metadataFile, b.name)
+
+	app.metadata = nil
+	app.downloadChecksums = make(map[string]string)
+	app.signedChecksums = make(map[string]string)
+	app.builtDefinitions = make(map[string]string)
+	app.outputTruncated = make(map[string]bool)
+	app.resetTransfers()
+
+	return nil
+}
+
+// runBatch builds each definition in app.batch in turn, as described by Run. A failure to build one
+// definition does not prevent the remainder being built. Build contexts are retained until every
+// definition is built, so that definitions with the same '%files' sources share a build context.
+func (app *App) runBatch(ctx context.Context) error {
+	defer app.deleteBatchContexts(ctx)
+
+	errs := make(map[string]error)
+
+	for i, path := range app.batch.defs {
+		if err := ctx.Err(); err != nil {
+			errs[path] = err
+			continue
+		}
+
+		if app.stopped.Load() {
+			fmt.Fprintf(app.stdout, "Skipping definition %v: stop requested\n", path)
+			continue
+		}
+
+		fmt.Fprintf(app.stdout, "Building definition %v (%v of %v)...\n", path, i+1, len(app.batch.defs))
+
+		err := app.setDefinition(path)
+		if err == nil {
+			err = app.translateAuthErr(app.run(ctx))
+		}
+		app.writeAnnotations(err)
+
+		if err != nil {
+			errs[path] = err
+		}
+	}
+
+	return reportDefinitionErrs(app.batch.defs, errs)
+}
+
+// batchContext returns the digest of a build context containing sources uploaded earlier in the
+// batch, if any.
+func (app *App) batchContext(sources []FileTransport) (string, bool) {
+	digest, err := contextDigest(sources)
+	if err != nil {
+		return "", false
+	}
+	buildContext, ok := app.batch.contexts[digest]
+	return buildContext, ok
+}
+
+// keepBatchContext records that buildContext, containing sources, was uploaded, so that it may be
+// used by later definitions in the batch.
+func (app *App) keepBatchContext(sources []FileTransport, buildContext string) {
+	if digest, err := contextDigest(sources); err == nil {
+		app.batch.contexts[digest] = buildContext
+	}
+}
+
+// deleteBatchContexts deletes the build contexts uploaded during the batch, unless they are to be
+// kept.
+func (app *App) deleteBatchContexts(ctx context.Context) {
+	if app.keepContext {
+		return
+	}
+
+	for _, buildContext := range app.batch.contexts {
+		app.deleteBuildContext(ctx, buildContext)
+	}
+}
+
+// reportDefinitionErrs outputs the errors of each failed definition to console, in the order of
+// defs. If more than one definition failed, an error wrapping each is returned.
+func reportDefinitionErrs(defs []string, errs map[string]error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	if len(errs) == 1 {
+		for _, err := range errs {
+			return err
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "\nBuild error(s):\n")
+
+	all := make([]error, 0, len(errs))
+	for _, path := range defs {
+		if err, ok := errs[path]; ok {
+			fmt.Fprintf(os.Stderr, "  - %v: %v\n", path, err)
+			all = append(all, err)
+		}
+	}
+
+	fmt.Fprintln(os.Stderr)
+
+	return &multiDefinitionError{errs: all, total: len(defs)}
+}
+
+// multiDefinitionError is returned when builds of more than one definition fail. The errors of each
+// are wrapped, so that they may be matched by errors.Is and errors.As.
+type multiDefinitionError struct {
+	errs  []error
+	total int
+}
+
+func (e *multiDefinitionError) Error() string {
+	return fmt.Sprintf("failed to build %v of %v definitions", len(e.errs), e.total)
+}
+
+func (e *multiDefinitionError) Unwrap() []error { return e.errs }
@@ -0,0 +1,164 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefinitionName(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"alpine.def", "alpine"},
+		{"defs/alpine-3.19.def", "alpine-3.19"},
+		{"/defs/alpine", "alpine"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			assert.Equal(t, tt.want, definitionName(tt.path))
+		})
+	}
+}
+
+func TestNew_Batch(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantDst string
+		wantErr error
+	}{
+		{
+			name:    "Single",
+			cfg:     Config{DefFiles: []string{"alpine.def"}, LibraryRef: "image.sif"},
+			wantDst: "image.sif",
+		},
+		{
+			name:    "SingleName",
+			cfg:     Config{DefFiles: []string{"defs/alpine.def"}, LibraryRef: "{name}.sif"},
+			wantDst: "alpine.sif",
+		},
+		{
+			name: "Multiple",
+			cfg:  Config{DefFiles: []string{"alpine.def", "debian.def"}, LibraryRef: "{name}.sif", LogFile: "{name}.log"},
+		},
+		{
+			name: "MultipleEphemeral",
+			cfg:  Config{DefFiles: []string{"alpine.def", "debian.def"}},
+		},
+		{
+			name:    "MultipleNoName",
+			cfg:     Config{DefFiles: []string{"alpine.def", "debian.def"}, LibraryRef: "image.sif"},
+			wantErr: errBatchDestination,
+		},
+		{
+			name:    "MultipleLogFileNoName",
+			cfg:     Config{DefFiles: []string{"alpine.def", "debian.def"}, LibraryRef: "{name}.sif", LogFile: "build.log"},
+			wantErr: errBatchDestination,
+		},
+		{
+			name:    "BuildSpec",
+			cfg:     Config{DefFiles: []string{"alpine.def"}, BuildSpec: "debian.def"},
+			wantErr: errBatchBuildSpec,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+
+			cfg := tt.cfg
+			cfg.URL = m.frontend.URL
+
+			app, err := New(context.Background(), &cfg)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				assert.Equal(t, ExitUsage, ExitCode(err))
+				return
+			}
+
+			if tt.wantDst != "" {
+				assert.Equal(t, tt.wantDst, app.dstFileName)
+			}
+		})
+	}
+}
+
+func TestApp_RunBatch(t *testing.T) {
+	m := newMockServers(t)
+	m.parseDefs = true
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "data.txt"), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	defs := map[string]string{
+		"alpine.def": "bootstrap: docker\nfrom: alpine:3\n\n%files\n  data.txt /data.txt\n",
+		"broken.def": "bootstrap: docker\nfrom: alpine:3\n\n%files\n  missing.txt /missing.txt\n",
+		"debian.def": "bootstrap: docker\nfrom: debian:12\n\n%files\n  data.txt /data.txt\n",
+	}
+
+	var paths []string
+	for _, name := range []string{"alpine.def", "broken.def", "debian.def"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(defs[name]), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	paths = append(paths, filepath.Join(dir, "missing.def"))
+
+	out := t.TempDir()
+
+	app, err := New(context.Background(), &Config{
+		URL:          m.frontend.URL,
+		DefFiles:     paths,
+		LibraryRef:   filepath.Join(out, "{name}.sif"),
+		ArchsToBuild: []string{"amd64"},
+		Context:      dir,
+	})
+	if err != nil {
+		t.Fatalf("initialization error: %v", err)
+	}
+
+	err = app.Run(context.Background())
+
+	// Failed definitions are reported together, and do not prevent the remainder being built.
+	var mde *multiDefinitionError
+	if !errors.As(err, &mde) {
+		t.Fatalf("got error %v, want multiDefinitionError", err)
+	}
+	assert.Len(t, mde.errs, 2)
+	assert.ErrorIs(t, err, errMissingFiles)
+	assert.Equal(t, ExitValidation, ExitCode(err))
+
+	for _, name := range []string{"alpine.sif", "debian.sif"} {
+		assert.FileExists(t, filepath.Join(out, name))
+	}
+	assert.NoFileExists(t, filepath.Join(out, "broken.sif"))
+
+	assert.Equal(t, [][]byte{[]byte(defs["alpine.def"]), []byte(defs["debian.def"])}, m.submittedDefs)
+
+	// Definitions with the same '%files' sources share a build context, which is deleted once the
+	// batch completes.
+	assert.Len(t, m.archives, 1)
+	if assert.Len(t, m.submittedCtxs, 2) {
+		assert.Equal(t, m.submittedCtxs[0], m.submittedCtxs[1])
+		assert.Equal(t, []string{m.submittedCtxs[0]}, m.deleted)
+	}
+}
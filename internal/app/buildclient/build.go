@@ -10,45 +10,129 @@ import (
 	"crypto"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/sigstore/sigstore/pkg/cryptoutils"
 	"github.com/sigstore/sigstore/pkg/signature"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	build "github.com/sylabs/scs-build-client/client"
+	"github.com/sylabs/scs-build-client/internal/pkg/endpoints"
+	"github.com/sylabs/scs-build-client/internal/pkg/keyless"
+	"github.com/sylabs/scs-build-client/internal/pkg/provenance"
+	"github.com/sylabs/scs-build-client/internal/pkg/statedir"
 	"github.com/sylabs/scs-build-client/internal/pkg/useragent"
 	"github.com/sylabs/sif/v2/pkg/integrity"
 )
 
 const (
-	keyAccessToken       = "auth-token"
-	keySkipTLSVerify     = "skip-verify"
-	keyArch              = "arch"
-	keyFrontendURL       = "url"
-	keyForceOverwrite    = "force"
-	keySign              = "sign"
-	keySigningKeyIndex   = "keyidx"
-	keyFingerprint       = "fingerprint"
-	keyKeyring           = "keyring"
-	keyPassphrase        = "passphrase"
-	keyPrivateSigningKey = "key"
+	keyAccessToken         = "auth-token"
+	keyAccessTokenFile     = "auth-token-file"
+	keySkipTLSVerify       = "skip-verify"
+	keyArch                = "arch"
+	keyFrontendURL         = "url"
+	keyBuildURL            = "build-url"
+	keyLibraryURL          = "library-url"
+	keyForceOverwrite      = "force"
+	keySign                = "sign"
+	keySigningKeyIndex     = "keyidx"
+	keyFingerprint         = "fingerprint"
+	keyKeyring             = "keyring"
+	keyPassphrase          = "passphrase"
+	keyPassphraseFD        = "passphrase-fd"
+	keyPassphraseFile      = "passphrase-file"
+	keyPrivateSigningKey   = "key"
+	keyResume              = "resume"
+	keyForceResume         = "force-resume"
+	keyRequirement         = "requirement"
+	keyBuildArg            = "build-arg"
+	keyDefPreprocess       = "def-preprocess"
+	keyDefFile             = "def-file"
+	keyAllowMissingArgs    = "build-arg-allow-missing"
+	keyHeader              = "header"
+	keyIgnoreCompat        = "ignore-compat"
+	keyContextCompression  = "context-compression"
+	keyOutputTailSize      = "output-tail-size"
+	keyAllowEmptyGlobs     = "allow-empty-globs"
+	keyLock                = "lock"
+	keyStateDir            = "state-dir"
+	keyDownloadConcurrency = "download-concurrency"
+	keyExpandEnvFiles      = "expand-env-files"
+	keyOutputGracePeriod   = "output-grace-period"
+	keyProvenance          = "provenance"
+	keyMetadataFile        = "metadata-file"
+	keyStrictProvenance    = "strict-provenance"
+	keyNoCache             = "no-cache"
+	keyFrontendCacheTTL    = "frontend-cache-ttl"
+	keyCACert              = "ca-cert"
+	keyClientCert          = "client-cert"
+	keyClientKey           = "client-key"
+	keyTLSMinVersion       = "tls-min-version"
+	keyTLSCipherProfile    = "tls-cipher-profile"
+	keyProxy               = "proxy"
+	keyNoRemoteConfig      = "no-remote-config"
+	keyLibraryTimeout      = "library-timeout"
+	keyLibraryStallTimeout = "library-stall-timeout"
+	keyLogTimestamps       = "log-timestamps"
+	keyLogFile             = "log-file"
+	keyLogAppend           = "log-append"
+	keyMaxOutputBytes      = "max-output-bytes"
+	keyCancelOnOutputLimit = "cancel-on-output-limit"
+	keyBuildTimeout        = "timeout"
+	keySubmitTimeout       = "submit-timeout"
+	keyTag                 = "tag"
+	keyDownloadHash        = "download-hash"
+	keySkipVerifySignature = "skip-verify-signature"
+	keySignKeyless         = "sign-keyless"
+	keyFulcioURL           = "fulcio-url"
+	keyRekorURL            = "rekor-url"
+	keyHTTPTrace           = "http-trace"
+	keyContext             = "context"
+	keyBuildDir            = "build-dir"
+	keyGitToken            = "git-token"
+	keyNoFsync             = "no-fsync"
+	keyOutputMode          = "output-mode"
+	keyOutputTemplate      = "output-template"
+	keyArtifactCache       = "artifact-cache"
+	keyArtifactCacheSize   = "artifact-cache-size"
+	keyKeepContext         = "keep-context"
+	keyContextDigest       = "context-digest"
+	keyCIAnnotations       = "ci-annotations"
+	keySupportBundle       = "support-bundle"
+	keySupportBundleAlways = "support-bundle-always"
+	keySupportBundleDef    = "support-bundle-definition"
+	keyDefaultLibraryRef   = "default-library-ref"
+	keyEphemeral           = "ephemeral"
+	keyRateLimitRetries    = "rate-limit-retries"
+	keyNonInteractive      = "non-interactive"
+	keyConfirmCancel       = "confirm-cancel"
+	keyVerbose             = "verbose"
+	keyOutput              = "output"
 )
 
 var buildCmd = &cobra.Command{
 	Use:   "build [flags] <build spec> <image path>",
 	Short: "Perform remote build on Singularity Container Services (https://cloud.sylabs.io) or Singularity Enterprise",
-	Args:  cobra.MinimumNArgs(1),
+	Args:  checkBuildArgs,
 	RunE:  executeBuildCmd,
 	Example: `
   Build and push artifact to cloud library:
 
       scs-build build alpine.def library:user/project/image:tag
 
+  Build and push artifact to cloud library with multiple tags:
+
+      scs-build build alpine.def library:user/project/image:1.2.3,latest
+
   Build and push artifact to Singularity Enterprise:
 
       scs-build build alpine.def library://cloud.enterprise.local/user/project/image:tag
@@ -57,6 +141,10 @@ var buildCmd = &cobra.Command{
 
       scs-build build docker://alpine alpine_latest.sif
 
+  Build local artifacts for every architecture supported by the Build Service:
+
+      scs-build build --arch all docker://alpine alpine_latest.sif
+
   Build local artifact on Singularity Enterprise:
 
       scs-build build --url https://cloud.enterprise.local --skip-verify docker://alpine alpine_latest.sif
@@ -65,39 +153,166 @@ var buildCmd = &cobra.Command{
 
       scs-build build alpine.def
 
+  Rebuild image using the definition embedded in an existing SIF:
+
+      scs-build build existing.sif library:user/project/rebuilt:tag
+
+  Build using a definition and '%files' sources in the app directory of a git repository tag:
+
+      scs-build build --context git+https://git.example.com/repo.git#v1.0.0:app app.def library:user/project/image:tag
+
+  Build each definition in the defs directory, naming images after their definition files:
+
+      scs-build build -f 'defs/*.def' library:user/project/{name}:latest
+
+  Build local artifact, writing it to standard output:
+
+      scs-build build -o - alpine.def | singularity sif list /dev/stdin
+
+  Build using definition read from standard input:
+
+      envsubst < alpine.def | scs-build build - library:user/project/image:tag
+
+  Push to the default destination, set in the environment, unless an image path is specified:
+
+      export SYLABS_DEFAULT_LIBRARY_REF='library:user/ci/{def_basename}:{git_sha|latest}'
+      scs-build build alpine.def
+
   Note: ephemeral artifacts are short-lived and are usually deleted within 24 hours.
 
-  Using --sign will enable automatic PGP signing. Use '--sign --key FILE' to sign with private key.`,
+  The default destination (--default-library-ref) may contain {def_basename}, {arch}, {date} and
+  {git_sha}, replaced by the definition name, the architecture (if only one is built), the date
+  (YYYYMMDD, UTC) and the abbreviated SHA of the commit checked out in the definition's directory.
+  Use {variable|fallback} to substitute fallback where a value is not available. Use --ephemeral
+  to build an ephemeral artifact regardless.
+
+  Using --sign will enable automatic PGP signing. Use '--sign --key FILE' to sign with private key.
+  Use '--sign-keyless' to sign in CI using its OIDC identity, rather than a long-lived key.
+  The passphrase of an encrypted PGP key is read from --passphrase-fd, --passphrase-file or the
+  SYLABS_PGP_PASSPHRASE environment variable, if set, and otherwise prompted for on the terminal.
+
+  Using --expand-env-files will expand environment variables such as $VAR, ${VAR} and
+  ${VAR:-default} in '%files' sources. As this allows a definition to select local files for
+  upload based on the environment, only use it with trusted definitions.`,
 }
 
-var errSigningNotSupported = errors.New("build and sign ephemeral image is not supported")
+var (
+	errSigningNotSupported = errors.New("build and sign ephemeral image is not supported")
+	errSigningOpts         = errors.New("error parsing signing opts")
+	errOutputSpecified     = errors.New("image path specified as both argument and --output")
+)
+
+// addConnectionFlags adds the flags used to connect to Singularity Container Services or
+// Singularity Enterprise to cmd.
+func addConnectionFlags(cmd *cobra.Command) {
+	cmd.Flags().String(keyAccessToken, "", "Access token")
+	cmd.Flags().String(keyAccessTokenFile, "", "File containing access token, which is re-read when it changes (for example, when a secret is rotated)")
+	cmd.MarkFlagsMutuallyExclusive(keyAccessToken, keyAccessTokenFile)
+	cmd.Flags().Bool(keyNoRemoteConfig, false, "Do not read access token from Singularity remote config (~/.singularity/remote.yaml)")
+	cmd.Flags().Bool(keySkipTLSVerify, false, "Skip SSL/TLS certificate verification")
+	cmd.Flags().String(keyCACert, "", "PEM file containing CA certificates to trust, in addition to system certificates")
+	cmd.Flags().String(keyClientCert, "", "PEM file containing client certificate, for servers that require one")
+	cmd.Flags().String(keyClientKey, "", "PEM file containing client certificate private key")
+	cmd.Flags().String(keyTLSMinVersion, "", "Minimum TLS version (1.2 or 1.3); servers negotiating an earlier version are rejected")
+	cmd.Flags().String(keyTLSCipherProfile, "", "Restrict TLS 1.2 connections to the cipher suites of a profile ('strict' permits forward secret AEAD suites only)")
+	cmd.Flags().String(keyProxy, "", "Proxy URL (overrides HTTP_PROXY, HTTPS_PROXY and NO_PROXY)")
+	cmd.Flags().String(keyFrontendURL, "", "Singularity Container Services or Singularity Enterprise URL")
+	cmd.Flags().String(keyBuildURL, "", "Build Service URL, used in place of discovery from the frontend (requires --library-url)")
+	cmd.Flags().String(keyLibraryURL, "", "Library Service URL, used in place of discovery from the frontend (requires --build-url)")
+	cmd.MarkFlagsRequiredTogether(keyBuildURL, keyLibraryURL)
+	cmd.Flags().Bool(keyNoCache, false, "Contact the server even if its configuration is cached, or it recently failed (host not found, or certificate error)")
+	cmd.Flags().Duration(keyFrontendCacheTTL, endpoints.DefaultConfigTTL, "Period for which server configuration is cached")
+}
 
 func AddBuildCommand(rootCmd *cobra.Command) {
-	buildCmd.Flags().String(keyAccessToken, "", "Access token")
-	buildCmd.Flags().Bool(keySkipTLSVerify, false, "Skip SSL/TLS certificate verification")
-	buildCmd.Flags().StringSlice(keyArch, []string{runtime.GOARCH}, "Requested build architecture")
-	buildCmd.Flags().String(keyFrontendURL, "", "Singularity Container Services or Singularity Enterprise URL")
-	buildCmd.Flags().Bool(keyForceOverwrite, false, "Overwrite image file if it exists")
+	addConnectionFlags(buildCmd)
+	buildCmd.Flags().StringSlice(keyArch, []string{runtime.GOARCH}, "Requested build architecture ('all' builds for every architecture supported by the Build Service)")
+	buildCmd.Flags().Bool(keyForceOverwrite, false, "Overwrite image file if it exists, without prompting")
+	buildCmd.Flags().Bool(keyNonInteractive, false, "Never prompt for confirmation (for example, to overwrite an existing image file), assuming the safe choice")
+	buildCmd.Flags().Bool(keyConfirmCancel, false, "Prompt for confirmation before cancelling running builds on the first interrupt (Ctrl-C)")
+	buildCmd.Flags().StringP(keyOutput, "o", "", "Image path, in place of <image path> ('-' writes the image to standard output)")
+	buildCmd.Flags().String(keyDefaultLibraryRef, "", "Destination template used when no image path is specified (see below)")
+	buildCmd.Flags().Bool(keyEphemeral, false, "Build an ephemeral artifact, even if a default destination is set")
+	buildCmd.Flags().StringArray(keyTag, nil, "Additional tag to apply to image pushed to library (may be repeated)")
 	buildCmd.Flags().Bool(keySign, false, "Automatically sign image after build")
 	buildCmd.Flags().IntP(keySigningKeyIndex, "k", -1, "PGP private key to use")
 	buildCmd.Flags().String(keyFingerprint, "", "Fingerprint for PGP key to sign with")
 	buildCmd.Flags().String(keyKeyring, "", "Full path to PGP keyring")
-	buildCmd.Flags().String(keyPassphrase, "", "Passphrase for PGP key")
+	buildCmd.Flags().String(keyPassphrase, "", "Passphrase for PGP key (visible to other users; prefer --passphrase-fd, --passphrase-file or "+envPGPPassphrase+")")
+	buildCmd.Flags().Int(keyPassphraseFD, -1, "Read passphrase for PGP key from file descriptor")
+	buildCmd.Flags().String(keyPassphraseFile, "", "Read passphrase for PGP key from file")
 	buildCmd.Flags().String(keyPrivateSigningKey, "", "Private key for signing")
-
-	buildCmd.MarkFlagsMutuallyExclusive(keySigningKeyIndex, keyFingerprint, keyPrivateSigningKey)
-	buildCmd.MarkFlagsMutuallyExclusive(keyKeyring, keyPrivateSigningKey)
-	buildCmd.MarkFlagsMutuallyExclusive(keyPassphrase, keyPrivateSigningKey)
-	buildCmd.MarkFlagsMutuallyExclusive(keyFingerprint, keyPrivateSigningKey)
+	buildCmd.Flags().Bool(keySignKeyless, false, "Sign using an ephemeral key certified for the ambient OIDC identity, recording signatures in a transparency log")
+	buildCmd.Flags().String(keyFulcioURL, keyless.DefaultFulcioURL, "Fulcio URL, used to certify keys when signing keyless")
+	buildCmd.Flags().String(keyRekorURL, keyless.DefaultRekorURL, "Rekor URL, used to record signatures when signing keyless")
+	buildCmd.Flags().Bool(keySkipVerifySignature, false, "Do not verify signatures before upload (emergency use only)")
+	buildCmd.Flags().String(keyResume, "", "Record outcome in metadata file, skipping architectures it records as built")
+	buildCmd.Flags().Bool(keyForceResume, false, "Resume even if build definition changed since recorded run")
+	buildCmd.Flags().StringArray(keyRequirement, nil, "Builder requirement in key=value format (may be repeated)")
+	buildCmd.Flags().StringArrayP(keyDefFile, "f", nil, "Definition file, or glob pattern matching definition files, to build in place of <build spec>, replacing {name} in <image path> with the file name without extension (may be repeated)")
+	buildCmd.Flags().String(keyDefPreprocess, "", "Shell command through which the definition is piped (standard input to standard output) before use")
+	buildCmd.Flags().StringArray(keyBuildArg, nil, "Value substituted for {{ KEY }} placeholders in the definition, and for ${KEY} where KEY is set, in KEY=VALUE format (may be repeated)")
+	buildCmd.Flags().Bool(keyAllowMissingArgs, false, "Leave placeholders naming build arguments that are not set as is, rather than failing")
+	buildCmd.Flags().StringArray(keyHeader, nil, "Header included in Build Service requests, in 'Key: Value' format (may be repeated)")
+	buildCmd.Flags().Bool(keyIgnoreCompat, false, "Warn rather than fail if the server does not support the features in use")
+	buildCmd.Flags().String(keyContextCompression, string(build.CompressionGzip), "Build context compression (gzip or zstd)")
+	buildCmd.Flags().Int(keyOutputTailSize, defaultOutputTailSize>>10, "Amount of build output (in KiB) retained for failure reports")
+	buildCmd.Flags().String(keyContext, "", "Directory or git repository (git+<url>[#<ref>[:<subdir>]]) against which relative '%files' sources, and for a repository the definition, are resolved")
+	buildCmd.Flags().String(keyBuildDir, "", "Directory against which relative '%files' sources are resolved, and in which the remote build runs (default working directory)")
+	buildCmd.MarkFlagsMutuallyExclusive(keyContext, keyBuildDir)
+	buildCmd.Flags().String(keyGitToken, "", "Token used to fetch a git repository build context (default standard git credentials)")
+	buildCmd.Flags().Bool(keyKeepContext, false, "Keep the uploaded build context, and print its digest for use with --context-digest")
+	buildCmd.Flags().String(keyContextDigest, "", "Use the previously uploaded build context with the specified digest (sha256.<hex>), rather than uploading one")
+	buildCmd.Flags().Bool(keyAllowEmptyGlobs, false, "Allow globs in '%files' section(s) that match no files")
+	buildCmd.Flags().Bool(keyLock, false, "Lock outputs shared with concurrent runs")
+	buildCmd.Flags().String(keyStateDir, "", "State directory used to lock outputs and cache server configuration and failures (default $XDG_STATE_HOME/scs-build)")
+	buildCmd.Flags().Uint(keyDownloadConcurrency, 1, "Number of concurrent ranged requests used to download images")
+	buildCmd.Flags().Bool(keyDryRun, false, "Validate the definition and build context, and report what would be built, without building")
+	buildCmd.Flags().String(keyOutputMode, "0644", "Permissions of image files written, in octal, applied regardless of umask (ignored on Windows)")
+	buildCmd.Flags().String(keyOutputTemplate, defaultOutputTemplate, "Name of the image file written for each architecture, where more than one is built, from {name}, {ext}, {arch} and {tag} (use '{name}{ext}-{arch}' for names formerly used)")
+	buildCmd.Flags().Bool(keyNoFsync, false, "Do not sync downloaded images to stable storage before reporting success (faster, but images may be lost or empty after a crash)")
+	buildCmd.Flags().String(keyArtifactCache, "", "Directory in which downloaded images are cached by checksum, and from which identical images are retrieved")
+	buildCmd.Flags().Int64(keyArtifactCacheSize, defaultArtifactCacheSize, "Maximum size in bytes of the artifact cache, beyond which least recently used images are evicted")
+	buildCmd.Flags().String(keyDownloadHash, string(DownloadHashSHA256), "Algorithm used to hash downloaded images (sha256, blake3 or off, which disables verification)")
+	buildCmd.Flags().Bool(keyExpandEnvFiles, false, "Expand environment variables in '%files' sources (trusted definitions only)")
+	buildCmd.Flags().String(keyProvenance, "", "Write SLSA provenance to file (signed, if signing is enabled)")
+	buildCmd.Flags().String(keyMetadataFile, "", "Write build ID, digests, library ref and times of each image built to file as JSON, once verified (one file per architecture, if building for multiple architectures; '-' for standard output)")
+	buildCmd.Flags().Bool(keyStrictProvenance, false, "Fail builds if the Build Service reports building a definition other than that submitted (default warn)")
+	buildCmd.Flags().Duration(keyOutputGracePeriod, defaultOutputGracePeriod, "Period to await further build output once the build is reported complete")
+	buildCmd.Flags().Bool(keyLogTimestamps, false, "Prefix each line of build output with the time it was received (RFC 3339)")
+	buildCmd.Flags().String(keyLogFile, "", "Also write build output to file (one file per architecture, if building for multiple architectures)")
+	buildCmd.Flags().Bool(keyLogAppend, false, "Append to log file, rather than truncating it")
+	buildCmd.Flags().Int64(keyMaxOutputBytes, 0, "Discard build output beyond this many bytes (default no limit)")
+	buildCmd.Flags().Bool(keyCancelOnOutputLimit, false, "Cancel builds whose output exceeds --"+keyMaxOutputBytes)
+	buildCmd.Flags().Duration(keyLibraryTimeout, 0, "Timeout of each library download or upload (default sized according to image, assuming at least 1 MiB/s)")
+	buildCmd.Flags().Duration(keyLibraryStallTimeout, defaultLibraryStallTimeout, "Fail library downloads and uploads that make no progress for this period")
+	buildCmd.Flags().Duration(keyBuildTimeout, 0, "Cancel each build that does not complete within this period (default no limit)")
+	buildCmd.Flags().Int(keyRateLimitRetries, 3, "Number of times Build Service requests rejected as rate limited are retried, after the delay requested by the server (up to 1m)")
+	buildCmd.Flags().Duration(keySubmitTimeout, 0, "Timeout of each build submission (default no limit, other than --timeout)")
+	buildCmd.Flags().String(keyCIAnnotations, "", "Write annotations summarizing the outcome for each architecture to standard output once the run completes, for CI system (github, or auto to detect GitHub Actions)")
+	buildCmd.Flags().Bool(keyVerbose, false, "Report additional diagnostics, such as the number of requests made to parse definitions, and the request and request ID of failed Build Service requests")
+	buildCmd.Flags().String(keyHTTPTrace, "", "Record HTTP requests and responses as JSON files in directory, to report server issues (credentials are redacted)")
+	buildCmd.Flags().String(keySupportBundle, "", "On failure, write a support bundle (.tar.gz) containing redacted settings, build context sources, build output, HTTP trace and run metadata to file, to report issues")
+	buildCmd.Flags().Bool(keySupportBundleAlways, false, "Write the support bundle even if the run succeeds")
+	buildCmd.Flags().Bool(keySupportBundleDef, false, "Include the definition in the support bundle (it may contain sensitive information)")
+
+	// Conflicting signing flags are rejected by validateArgs, which explains the combination to use.
+	setOnce(buildCmd, keySigningKeyIndex, keyFingerprint, keyPrivateSigningKey, keyKeyring)
+
+	addLegacyFlags(buildCmd)
+	addImageSpecFlag(buildCmd)
 
 	rootCmd.AddCommand(buildCmd)
 }
 
 func getConfig(cmd *cobra.Command) (*viper.Viper, error) {
 	v := viper.New()
-	v.SetEnvPrefix("sylabs")
+	v.SetEnvPrefix(envPrefix)
 	v.AutomaticEnv()
 	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	if err := applyLegacyNames(cmd, v, cmd.ErrOrStderr()); err != nil {
+		return nil, err
+	}
 	return v, v.BindPFlags(cmd.Flags())
 }
 
@@ -108,52 +323,221 @@ func executeBuildCmd(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("error getting config: %w", err)
 	}
 
-	if v.GetString(keyPassphrase) != "" && !(cmd.Flag(keySigningKeyIndex).Changed || cmd.Flag(keyFingerprint).Changed) {
-		return fmt.Errorf("--passphrase only effective when PGP signing enabled")
+	if err := validateArgs(cmd, v); err != nil {
+		return err
 	}
 
 	signing := v.GetString(keyPassphrase) != "" ||
 		v.GetInt(keySigningKeyIndex) != -1 ||
 		v.GetString(keyFingerprint) != "" ||
-		v.GetBool(keySign)
+		v.GetBool(keySign) ||
+		v.GetBool(keySignKeyless)
 
-	var signerOpts []integrity.SignerOpt
+	so := &signingOpts{}
 	if signing {
 		fmt.Printf("Build artifacts will be automatically signed\n")
 
-		signerOpts, err = parseSigningOpts(v)
+		so, err = parseSigningOpts(v)
 		if err != nil {
-			return fmt.Errorf("error parsing signing opts: %w", err)
+			return fmt.Errorf("%w: %w", errSigningOpts, err)
+		}
+
+		if v.GetBool(keySkipVerifySignature) {
+			fmt.Fprintf(os.Stderr, "Warning: signatures will not be verified before upload\n")
+			so.verifierOpts = nil
 		}
 	}
 
-	var libraryRef string
-	if len(args) > 1 {
-		libraryRef = args[1]
+	var buildSpec, libraryRef string
+	var defFiles []string
+
+	output := v.GetString(keyOutput)
+
+	if args, err = imageSpecArgs(cmd, args, output, cmd.ErrOrStderr()); err != nil {
+		return err
+	}
+
+	if patterns := v.GetStringSlice(keyDefFile); len(patterns) > 0 {
+		// Remaining definitions, such as those expanded by the shell from a pattern, precede the
+		// destination, unless it is specified using --output.
+		if output != "" {
+			patterns = append(patterns, args...)
+		} else if len(args) > 0 {
+			patterns = append(patterns, args[:len(args)-1]...)
+			libraryRef = args[len(args)-1]
+		}
+
+		if defFiles, err = parseDefFiles(patterns); err != nil {
+			return err
+		}
 	} else {
-		if len(args) == 1 && signing {
-			return errSigningNotSupported
+		if len(args) > 1 {
+			if output != "" {
+				return errOutputSpecified
+			}
+			libraryRef = args[1]
+		}
+
+		if buildSpec, err = parseBuildSpec(args[0]); err != nil {
+			return err
 		}
 	}
 
-	buildSpec, err := parseBuildSpec(args[0])
+	if output != "" {
+		libraryRef = output
+	}
+
+	// Without an image path, push to the default destination, if any. The rendered destination is
+	// validated along with an image path specified as an argument.
+	explicit := libraryRef != ""
+
+	tmpl := v.GetString(keyDefaultLibraryRef)
+	libraryRef, err = resolveDestination(libraryRef, tmpl, v.GetBool(keyEphemeral), func() destinationVars {
+		return defaultDestinationVars(cmd.Context(), tmpl, buildSpec, defFiles, v.GetStringSlice(keyArch), time.Now())
+	})
+	if err != nil {
+		return err
+	}
+	if !explicit && libraryRef != "" {
+		fmt.Printf("Using default destination %v\n", libraryRef)
+	}
+
+	if libraryRef == "" && signing {
+		return errSigningNotSupported
+	}
+
+	requirements, err := parseRequirements(v.GetStringSlice(keyRequirement))
+	if err != nil {
+		return err
+	}
+
+	buildArgs, err := parseBuildArgs(v.GetStringSlice(keyBuildArg))
+	if err != nil {
+		return err
+	}
+
+	headers, err := parseHeaders(v.GetStringSlice(keyHeader))
 	if err != nil {
 		return err
 	}
 
+	compression, err := parseContextCompression(v.GetString(keyContextCompression))
+	if err != nil {
+		return err
+	}
+
+	outputMode, err := parseOutputMode(v.GetString(keyOutputMode))
+	if err != nil {
+		return err
+	}
+
+	downloadHash, err := parseDownloadHash(v.GetString(keyDownloadHash))
+	if err != nil {
+		return err
+	}
+	if downloadHash == DownloadHashOff {
+		fmt.Fprintf(os.Stderr, "WARNING: --%v=%v: downloaded images will NOT be verified, and may be corrupt\n", keyDownloadHash, downloadHash)
+	}
+
+	ciAnnotations, err := parseCIAnnotations(v.GetString(keyCIAnnotations))
+	if err != nil {
+		return err
+	}
+
+	var stateDir string
+	if v.GetBool(keyLock) {
+		if stateDir, err = parseStateDir(v.GetString(keyStateDir)); err != nil {
+			return err
+		}
+	}
+
+	// Passphrases are redacted from the support bundle wherever they appear.
+	var bundleSecrets []string
+	if v.GetString(keySupportBundle) != "" {
+		bundleSecrets = passphraseSecrets(v)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	app, err := New(ctx, &Config{
-		URL:           v.GetString(keyFrontendURL),
-		AuthToken:     v.GetString(keyAccessToken),
-		BuildSpec:     buildSpec,
-		LibraryRef:    libraryRef,
-		SkipTLSVerify: v.GetBool(keySkipTLSVerify),
-		Force:         v.GetBool(keyForceOverwrite),
-		UserAgent:     useragent.Value(),
-		ArchsToBuild:  v.GetStringSlice(keyArch),
-		SignerOpts:    signerOpts,
+	app, err := newApp(ctx, &Config{
+		URL:                 v.GetString(keyFrontendURL),
+		BuildURL:            v.GetString(keyBuildURL),
+		LibraryURL:          v.GetString(keyLibraryURL),
+		AuthToken:           v.GetString(keyAccessToken),
+		AuthTokenFile:       v.GetString(keyAccessTokenFile),
+		BuildSpec:           buildSpec,
+		LibraryRef:          libraryRef,
+		Tags:                v.GetStringSlice(keyTag),
+		SkipTLSVerify:       v.GetBool(keySkipTLSVerify),
+		CACertFile:          v.GetString(keyCACert),
+		ClientCertFile:      v.GetString(keyClientCert),
+		ClientKeyFile:       v.GetString(keyClientKey),
+		TLSMinVersion:       v.GetString(keyTLSMinVersion),
+		TLSCipherProfile:    v.GetString(keyTLSCipherProfile),
+		Proxy:               v.GetString(keyProxy),
+		RemoteConfigFile:    parseRemoteConfigFile(v),
+		Force:               v.GetBool(keyForceOverwrite),
+		NonInteractive:      v.GetBool(keyNonInteractive),
+		ConfirmCancel:       v.GetBool(keyConfirmCancel),
+		Verbose:             v.GetBool(keyVerbose),
+		UserAgent:           useragent.Value(),
+		ArchsToBuild:        v.GetStringSlice(keyArch),
+		SignerOpts:          so.signerOpts,
+		VerifierOpts:        so.verifierOpts,
+		SignatureLog:        so.signatureLog,
+		ResumeFile:          v.GetString(keyResume),
+		ForceResume:         v.GetBool(keyForceResume),
+		IgnoreCompat:        v.GetBool(keyIgnoreCompat),
+		ContextCompression:  compression,
+		OutputTailSize:      v.GetInt(keyOutputTailSize) << 10,
+		AllowEmptyGlobs:     v.GetBool(keyAllowEmptyGlobs),
+		StateDir:            stateDir,
+		DownloadConcurrency: v.GetUint(keyDownloadConcurrency),
+		DownloadHash:        downloadHash,
+		NoFsync:             v.GetBool(keyNoFsync),
+		OutputMode:          outputMode,
+		OutputTemplate:      v.GetString(keyOutputTemplate),
+		ArtifactCache:       v.GetString(keyArtifactCache),
+		ArtifactCacheSize:   v.GetInt64(keyArtifactCacheSize),
+		KeepContext:         v.GetBool(keyKeepContext),
+		ContextDigest:       v.GetString(keyContextDigest),
+		DryRun:              v.GetBool(keyDryRun),
+		CIAnnotations:       ciAnnotations,
+		ExpandEnvFiles:      v.GetBool(keyExpandEnvFiles),
+		OutputGracePeriod:   v.GetDuration(keyOutputGracePeriod),
+		LogTimestamps:       v.GetBool(keyLogTimestamps),
+		LogFile:             v.GetString(keyLogFile),
+		LogAppend:           v.GetBool(keyLogAppend),
+		MaxOutputBytes:      v.GetInt64(keyMaxOutputBytes),
+		CancelOnOutputLimit: v.GetBool(keyCancelOnOutputLimit),
+		LibraryTimeout:      v.GetDuration(keyLibraryTimeout),
+		LibraryStallTimeout: v.GetDuration(keyLibraryStallTimeout),
+		BuildTimeout:        v.GetDuration(keyBuildTimeout),
+		SubmitTimeout:       v.GetDuration(keySubmitTimeout),
+		HTTPTraceDir:        v.GetString(keyHTTPTrace),
+		Context:             v.GetString(keyContext),
+		WorkingDir:          v.GetString(keyBuildDir),
+		GitToken:            v.GetString(keyGitToken),
+		ProvenanceFile:      v.GetString(keyProvenance),
+		ProvenanceSigner:    so.provenanceSigner,
+		MetadataFile:        v.GetString(keyMetadataFile),
+		StrictProvenance:    v.GetBool(keyStrictProvenance),
+		CacheDir:            parseCacheDir(v),
+		NoCache:             v.GetBool(keyNoCache),
+		FrontendCacheTTL:    v.GetDuration(keyFrontendCacheTTL),
+		Requirements:        requirements,
+		DefFiles:            defFiles,
+		DefPreprocess:       v.GetString(keyDefPreprocess),
+		BuildArgs:           buildArgs,
+		AllowMissingArgs:    v.GetBool(keyAllowMissingArgs),
+		HTTPHeaders:         headers,
+		SupportBundle:       v.GetString(keySupportBundle),
+		SupportBundleAlways: v.GetBool(keySupportBundleAlways),
+		BundleDefinition:    v.GetBool(keySupportBundleDef),
+		BundleSettings:      v.AllSettings(),
+		BundleSecrets:       bundleSecrets,
+		RateLimitRetries:    v.GetInt(keyRateLimitRetries),
 	})
 	if err != nil {
 		return fmt.Errorf("application init error: %w", err)
@@ -163,14 +547,18 @@ func executeBuildCmd(cmd *cobra.Command, args []string) error {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 
-	go func() {
-		fmt.Fprintf(os.Stderr, "Shutting down due to signal: %v\n", <-c)
-		cancel()
-	}()
+	// Report a broken pipe on standard output as an error, rather than terminating the process, so
+	// that a build is not abandoned if its output is piped to a command that exits early.
+	signal.Ignore(syscall.SIGPIPE)
+
+	go app.awaitInterrupt(c, cancel, os.Exit)
 
 	return app.Run(ctx)
 }
 
+// newApp returns the App run by the build command. Tests replace it to inspect the Config.
+var newApp = New
+
 var errInvalidBuildSpec = errors.New("invalid build spec")
 
 // parseBuildSpec validates buildspec argument.
@@ -191,18 +579,225 @@ func parseBuildSpec(buildSpec string) (string, error) {
 	return buildSpec, nil
 }
 
-func parseSigningOpts(v *viper.Viper) ([]integrity.SignerOpt, error) {
+// checkBuildArgs checks the arguments of the build command, which are a build spec and optional
+// destination or, if definition files are specified, optional further definitions followed by a
+// destination.
+func checkBuildArgs(cmd *cobra.Command, args []string) error {
+	if cmd.Flags().Changed(keyDefFile) {
+		return nil
+	}
+	return cobra.MinimumNArgs(1)(cmd, args)
+}
+
+var errNoDefFiles = errors.New("no definition files match pattern")
+
+// parseDefFiles returns the definition files matching patterns, in order, without duplicates.
+func parseDefFiles(patterns []string) ([]string, error) {
+	var defs []string
+
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v: %w", errNoDefFiles, pattern, err)
+		}
+
+		// A missing file named without glob characters is reported when it is read.
+		if matches == nil {
+			if strings.ContainsAny(pattern, "*?[") {
+				return nil, fmt.Errorf("%w: %v", errNoDefFiles, pattern)
+			}
+			matches = []string{pattern}
+		}
+
+		for _, path := range matches {
+			if !seen[path] {
+				seen[path] = true
+				defs = append(defs, path)
+			}
+		}
+	}
+
+	return defs, nil
+}
+
+var errInvalidRequirement = errors.New("invalid builder requirement")
+
+// parseRequirements parses builder requirements specified in key=value format.
+func parseRequirements(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil //nolint:nilnil
+	}
+
+	requirements := make(map[string]string)
+
+	for _, kv := range values {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("%w %q: expected key=value", errInvalidRequirement, kv)
+		}
+
+		if k == keyArch {
+			return nil, fmt.Errorf("%w %q: use --%v to select architecture", errInvalidRequirement, kv, keyArch)
+		}
+
+		requirements[k] = v
+	}
+
+	return requirements, nil
+}
+
+var errInvalidHeader = errors.New("invalid header")
+
+// parseHeaders parses HTTP headers specified in "Key: Value" format. A key may be repeated, to
+// specify multiple values.
+func parseHeaders(values []string) (http.Header, error) {
+	if len(values) == 0 {
+		return nil, nil //nolint:nilnil
+	}
+
+	h := make(http.Header)
+
+	for _, kv := range values {
+		k, v, ok := strings.Cut(kv, ":")
+		if !ok || k == "" || strings.ContainsAny(k, " \t") {
+			return nil, fmt.Errorf("%w %q: expected 'Key: Value'", errInvalidHeader, kv)
+		}
+
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, fmt.Errorf("%w %q: value must not contain line breaks", errInvalidHeader, kv)
+		}
+
+		h.Add(k, strings.TrimSpace(v))
+	}
+
+	return h, nil
+}
+
+var errInvalidContextCompression = errors.New("invalid build context compression")
+
+// parseContextCompression parses the build context compression algorithm.
+func parseContextCompression(value string) (build.Compression, error) {
+	switch c := build.Compression(value); c {
+	case build.CompressionGzip, build.CompressionZstd:
+		return c, nil
+	default:
+		return "", fmt.Errorf("%w %q: expected %v or %v", errInvalidContextCompression, value, build.CompressionGzip, build.CompressionZstd)
+	}
+}
+
+// parseRemoteConfigFile returns the Singularity remote config file from which the access token is
+// read, if none is specified by flag or environment. If reading the remote config is disabled, or
+// its location cannot be determined, an empty string is returned.
+func parseRemoteConfigFile(v *viper.Viper) string {
+	if v.GetBool(keyNoRemoteConfig) {
+		return ""
+	}
+
+	path, err := DefaultRemoteConfigFile()
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+// parseCacheDir returns the directory in which frontend configuration and discovery failures are
+// cached. The cache is best-effort, so is disabled (an empty string is returned) if there is no
+// state directory.
+func parseCacheDir(v *viper.Viper) string {
+	dir, err := parseStateDir(v.GetString(keyStateDir))
+	if err != nil {
+		return ""
+	}
+	return dir
+}
+
+// connectionConfig returns a Config containing the settings used to connect to Singularity
+// Container Services or Singularity Enterprise, as specified by the flags added by
+// addConnectionFlags.
+func connectionConfig(v *viper.Viper) *Config {
+	return &Config{
+		URL:              v.GetString(keyFrontendURL),
+		BuildURL:         v.GetString(keyBuildURL),
+		LibraryURL:       v.GetString(keyLibraryURL),
+		AuthToken:        v.GetString(keyAccessToken),
+		AuthTokenFile:    v.GetString(keyAccessTokenFile),
+		SkipTLSVerify:    v.GetBool(keySkipTLSVerify),
+		CACertFile:       v.GetString(keyCACert),
+		ClientCertFile:   v.GetString(keyClientCert),
+		ClientKeyFile:    v.GetString(keyClientKey),
+		TLSMinVersion:    v.GetString(keyTLSMinVersion),
+		TLSCipherProfile: v.GetString(keyTLSCipherProfile),
+		Proxy:            v.GetString(keyProxy),
+		RemoteConfigFile: parseRemoteConfigFile(v),
+		CacheDir:         parseCacheDir(v),
+		NoCache:          v.GetBool(keyNoCache),
+		FrontendCacheTTL: v.GetDuration(keyFrontendCacheTTL),
+		UserAgent:        useragent.Value(),
+	}
+}
+
+// parseStateDir returns the state directory, or the default state directory if none is specified.
+func parseStateDir(value string) (string, error) {
+	if value != "" {
+		return value, nil
+	}
+	return statedir.Default()
+}
+
+// signingOpts describes how images and provenance are signed, and how signatures are verified and
+// published.
+type signingOpts struct {
+	signerOpts       []integrity.SignerOpt
+	verifierOpts     []integrity.VerifierOpt
+	provenanceSigner provenance.Signer
+	signatureLog     SignatureLog
+}
+
+// parseSigningOpts returns options to sign artifacts, along with a signer for provenance, using
+// the same key. Keys are loaded, and decrypted if necessary, before returning, so that any
+// passphrase is prompted for once, and misconfiguration is reported before a build is submitted.
+// An ephemeral key for keyless signing is instead certified once an image is signed, so that no
+// identity token is requested for a build that fails.
+func parseSigningOpts(v *viper.Viper) (*signingOpts, error) {
 	// Parse flags to determine signing configuration
-	opts := []integrity.SignerOpt{}
+	if v.GetBool(keySignKeyless) {
+		// Use ephemeral key certified for ambient OIDC identity, contacting Fulcio and Rekor with the
+		// same proxy and TLS configuration as other requests.
+		tr, err := newTransport(connectionConfig(v))
+		if err != nil {
+			return nil, err
+		}
+
+		ks, err := keyless.NewSigner(keyless.Config{
+			FulcioURL:  v.GetString(keyFulcioURL),
+			RekorURL:   v.GetString(keyRekorURL),
+			HTTPClient: &http.Client{Transport: tr},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error initializing keyless signer: %w", err)
+		}
+
+		return &signingOpts{
+			signerOpts:       []integrity.SignerOpt{integrity.OptSignWithSigner(ks)},
+			verifierOpts:     []integrity.VerifierOpt{integrity.OptVerifyWithVerifier(ks)},
+			provenanceSigner: provenance.NewKeySigner(ks),
+			signatureLog:     ks,
+		}, nil
+	}
 
 	if privateSigningKey := v.GetString(keyPrivateSigningKey); privateSigningKey != "" {
 		// Use private key for signing
-		ss, err := signature.LoadSignerFromPEMFile(privateSigningKey, crypto.SHA256, cryptoutils.GetPasswordFromStdIn)
+		sv, err := signature.LoadSignerVerifierFromPEMFile(privateSigningKey, crypto.SHA256, cryptoutils.GetPasswordFromStdIn)
 		if err != nil {
 			return nil, fmt.Errorf("error initializing private key signer: %w", err)
 		}
 
-		return append(opts, integrity.OptSignWithSigner(ss)), nil
+		return &signingOpts{
+			signerOpts:       []integrity.SignerOpt{integrity.OptSignWithSigner(sv)},
+			verifierOpts:     []integrity.VerifierOpt{integrity.OptVerifyWithVerifier(sv)},
+			provenanceSigner: provenance.NewKeySigner(sv),
+		}, nil
 	}
 
 	// Fallback to PGP signing
@@ -211,10 +806,14 @@ func parseSigningOpts(v *viper.Viper) ([]integrity.SignerOpt, error) {
 		return nil, err
 	}
 
-	pgpSignerOpts, err := getPGPSignerOpts(s...)
+	e, err := getPGPEntity(s...)
 	if err != nil {
 		return nil, err
 	}
 
-	return append(opts, pgpSignerOpts...), nil
+	return &signingOpts{
+		signerOpts:       []integrity.SignerOpt{integrity.OptSignWithEntity(e)},
+		verifierOpts:     []integrity.VerifierOpt{integrity.OptVerifyWithKeyRing(openpgp.EntityList{e})},
+		provenanceSigner: provenance.NewPGPSigner(e),
+	}, nil
 }
@@ -6,9 +6,44 @@
 package buildclient
 
 import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	build "github.com/sylabs/scs-build-client/client"
 )
 
+// buildTestRoot returns the root command of buildCmd. Since the flags of buildCmd can be added only
+// once, buildCmd is added to a new root command only if it has not already been added.
+func buildTestRoot() *cobra.Command {
+	if !buildCmd.HasParent() {
+		AddBuildCommand(&cobra.Command{Use: "scs-build", SilenceUsage: true, SilenceErrors: true})
+	}
+	return buildCmd.Root()
+}
+
+// resetBuildFlags restores the flags of buildCmd to their defaults, since the command is reused. A
+// slice flag with a non-empty default, once set, appends to its default when set again.
+func resetBuildFlags() {
+	buildCmd.Flags().VisitAll(func(f *pflag.Flag) {
+		f.Changed = false
+
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			var def []string
+			if s := strings.Trim(f.DefValue, "[]"); s != "" {
+				def = strings.Split(s, ",")
+			}
+			_ = sv.Replace(def)
+			return
+		}
+		_ = f.Value.Set(f.DefValue)
+	})
+}
+
 func TestValidateBuildSpec(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -20,6 +55,7 @@ func TestValidateBuildSpec(t *testing.T) {
 		{"MalformedAgainButValidFilename", "docker:alpine:3", false},
 		{"File", "alpine_3.def", false},
 		{"FileScheme", "file://alpine_3.def", false},
+		{"Stdin", "-", false},
 	}
 
 	for _, tt := range tests {
@@ -33,3 +69,98 @@ func TestValidateBuildSpec(t *testing.T) {
 		})
 	}
 }
+
+func TestParseRequirements(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    map[string]string
+		wantErr error
+	}{
+		{"None", nil, nil, nil},
+		{"Single", []string{"gpu=nvidia"}, map[string]string{"gpu": "nvidia"}, nil},
+		{"Multiple", []string{"gpu=nvidia", "memory=large"}, map[string]string{"gpu": "nvidia", "memory": "large"}, nil},
+		{"LastWriterWins", []string{"gpu=nvidia", "gpu=amd"}, map[string]string{"gpu": "amd"}, nil},
+		{"EmptyValue", []string{"label="}, map[string]string{"label": ""}, nil},
+		{"ValueWithEquals", []string{"label=a=b"}, map[string]string{"label": "a=b"}, nil},
+		{"MissingSeparator", []string{"gpu"}, nil, errInvalidRequirement},
+		{"MissingKey", []string{"=nvidia"}, nil, errInvalidRequirement},
+		{"Arch", []string{"arch=arm64"}, nil, errInvalidRequirement},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRequirements(tt.values)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    http.Header
+		wantErr error
+	}{
+		{"None", nil, nil, nil},
+		{"Single", []string{"X-Org-ID: org"}, http.Header{"X-Org-Id": {"org"}}, nil},
+		{"NoSpace", []string{"X-Org-ID:org"}, http.Header{"X-Org-Id": {"org"}}, nil},
+		{"Repeated", []string{"X-Org-ID: a", "x-org-id: b"}, http.Header{"X-Org-Id": {"a", "b"}}, nil},
+		{"EmptyValue", []string{"X-Empty:"}, http.Header{"X-Empty": {""}}, nil},
+		{"ValueWithColon", []string{"X-URL: https://example.com"}, http.Header{"X-Url": {"https://example.com"}}, nil},
+		{"MissingSeparator", []string{"X-Org-ID"}, nil, errInvalidHeader},
+		{"MissingKey", []string{": org"}, nil, errInvalidHeader},
+		{"KeyWithSpace", []string{"X Org: org"}, nil, errInvalidHeader},
+		{"LineBreak", []string{"X-Org-ID: org\r\nX-Injected: value"}, nil, errInvalidHeader},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseHeaders(tt.values)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseContextCompression(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    build.Compression
+		wantErr error
+	}{
+		{"Gzip", "gzip", build.CompressionGzip, nil},
+		{"Zstd", "zstd", build.CompressionZstd, nil},
+		{"Empty", "", "", errInvalidContextCompression},
+		{"Unsupported", "bzip2", "", errInvalidContextCompression},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseContextCompression(tt.value)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	errInvalidBuildArg    = errors.New("invalid build argument")
+	errUnresolvedBuildArg = errors.New("unresolved build argument")
+)
+
+// buildArgName matches the name of a build argument.
+var buildArgName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// buildArgPlaceholder matches a build argument placeholder, in "{{ KEY }}" or "${KEY}" form.
+var buildArgPlaceholder = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}|\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// parseBuildArgs parses build arguments specified in KEY=VALUE format.
+func parseBuildArgs(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil //nolint:nilnil
+	}
+
+	args := make(map[string]string)
+
+	for _, kv := range values {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || !buildArgName.MatchString(k) {
+			return nil, fmt.Errorf("%w %q: expected KEY=VALUE, where KEY consists of letters, digits and underscores", errInvalidBuildArg, kv)
+		}
+
+		args[k] = v
+	}
+
+	return args, nil
+}
+
+// substituteBuildArgs replaces placeholders in def, in "{{ KEY }}" or "${KEY}" form, with the value
+// of the corresponding build argument in args. Values are inserted literally, so are neither
+// quoted, nor themselves substituted.
+//
+// Since "${VAR}" is also used to refer to shell variables in scripts, a placeholder in that form is
+// substituted only if it names a build argument in args, and is otherwise left as is. If a "{{ KEY }}"
+// placeholder names a build argument not in args, an error wrapping errUnresolvedBuildArg is
+// returned, unless allowMissing is set, in which case the placeholder is left as is.
+func substituteBuildArgs(def []byte, args map[string]string, allowMissing bool) ([]byte, error) {
+	missing := make(map[string]bool)
+
+	b := buildArgPlaceholder.ReplaceAllFunc(def, func(match []byte) []byte {
+		sub := buildArgPlaceholder.FindSubmatch(match)
+
+		name, braces := string(sub[1]), true
+		if name == "" {
+			name, braces = string(sub[2]), false
+		}
+
+		if v, ok := args[name]; ok {
+			return []byte(v)
+		}
+
+		if braces {
+			missing[name] = true
+		}
+		return match
+	})
+
+	if len(missing) > 0 && !allowMissing {
+		names := make([]string, 0, len(missing))
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		return nil, fmt.Errorf("%w(s) in definition: %v (set using --%v, or use --%v)",
+			errUnresolvedBuildArg, strings.Join(names, ", "), keyBuildArg, keyAllowMissingArgs)
+	}
+
+	return b, nil
+}
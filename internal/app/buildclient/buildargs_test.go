@@ -0,0 +1,205 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBuildArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    map[string]string
+		wantErr error
+	}{
+		{"None", nil, nil, nil},
+		{"Single", []string{"TAG=3.19"}, map[string]string{"TAG": "3.19"}, nil},
+		{"Multiple", []string{"TAG=3.19", "pkg_version=1.2"}, map[string]string{"TAG": "3.19", "pkg_version": "1.2"}, nil},
+		{"LastWriterWins", []string{"TAG=3.18", "TAG=3.19"}, map[string]string{"TAG": "3.19"}, nil},
+		{"EmptyValue", []string{"TAG="}, map[string]string{"TAG": ""}, nil},
+		{"ValueWithEquals", []string{"OPTS=a=b"}, map[string]string{"OPTS": "a=b"}, nil},
+		{"MissingSeparator", []string{"TAG"}, nil, errInvalidBuildArg},
+		{"MissingKey", []string{"=3.19"}, nil, errInvalidBuildArg},
+		{"InvalidKey", []string{"BASE-TAG=3.19"}, nil, errInvalidBuildArg},
+		{"LeadingDigit", []string{"1TAG=3.19"}, nil, errInvalidBuildArg},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseBuildArgs(tt.values)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubstituteBuildArgs(t *testing.T) {
+	args := map[string]string{
+		"TAG":   "3.19",
+		"VALUE": "a\nb; $(id) `id` ${TAG} {{ TAG }} \\ $1",
+	}
+
+	tests := []struct {
+		name         string
+		def          string
+		allowMissing bool
+		want         string
+		wantErr      error
+		wantMsg      string // If set, the error names only the placeholders expected, followed by this.
+	}{
+		{
+			name: "Braces",
+			def:  "bootstrap: docker\nfrom: alpine:{{ TAG }}\n",
+			want: "bootstrap: docker\nfrom: alpine:3.19\n",
+		},
+		{
+			name: "BracesNoSpace",
+			def:  "from: alpine:{{TAG}}\n",
+			want: "from: alpine:3.19\n",
+		},
+		{
+			name: "Dollar",
+			def:  "from: alpine:${TAG}\n",
+			want: "from: alpine:3.19\n",
+		},
+		{
+			name: "Repeated",
+			def:  "from: alpine:${TAG}\n%labels\n  tag {{ TAG }}\n",
+			want: "from: alpine:3.19\n%labels\n  tag 3.19\n",
+		},
+		{
+			name: "Literal",
+			def:  "%post\n  echo '{{ VALUE }}'\n",
+			want: "%post\n  echo '" + args["VALUE"] + "'\n",
+		},
+		{
+			name: "NotPlaceholder",
+			def:  "%post\n  echo $TAG {{ }} ${} {TAG}\n",
+			want: "%post\n  echo $TAG {{ }} ${} {TAG}\n",
+		},
+		{
+			name: "DollarUndeclared",
+			def:  "from: alpine:${TAG}\n%post\n  echo ${HOME}\n",
+			want: "from: alpine:3.19\n%post\n  echo ${HOME}\n",
+		},
+		{
+			name:    "Missing",
+			def:     "from: alpine:{{ TAG }}\n%post\n  echo ${HOME} {{ VERSION }}\n",
+			wantErr: errUnresolvedBuildArg,
+			wantMsg: "VERSION (set",
+		},
+		{
+			name:         "AllowMissing",
+			def:          "from: alpine:{{ TAG }}\n%post\n  echo ${HOME} {{ VERSION }}\n",
+			allowMissing: true,
+			want:         "from: alpine:3.19\n%post\n  echo ${HOME} {{ VERSION }}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := substituteBuildArgs([]byte(tt.def), args, tt.allowMissing)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantMsg != "" {
+				assert.Contains(t, err.Error(), "definition: "+tt.wantMsg)
+			}
+
+			if err == nil {
+				assert.Equal(t, tt.want, string(got))
+			}
+		})
+	}
+}
+
+func TestApp_RunBuildArgs(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "data-1.2.txt"), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	defFile := filepath.Join(dir, "alpine.def")
+	def := "bootstrap: docker\nfrom: alpine:{{ TAG }}\n\n%files\n  data-${VERSION}.txt /data.txt\n"
+	if err := os.WriteFile(defFile, []byte(def), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		args    map[string]string
+		want    string
+		wantErr error
+	}{
+		{
+			name: "OK",
+			args: map[string]string{"TAG": "3.19", "VERSION": "1.2"},
+			want: "bootstrap: docker\nfrom: alpine:3.19\n\n%files\n  data-1.2.txt /data.txt\n",
+		},
+		{
+			name:    "Missing",
+			args:    map[string]string{"VERSION": "1.2"},
+			wantErr: errUnresolvedBuildArg,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.parseDefs = true
+
+			app, err := New(context.Background(), &Config{
+				URL:          m.frontend.URL,
+				BuildSpec:    defFile,
+				LibraryRef:   filepath.Join(t.TempDir(), "image.sif"),
+				ArchsToBuild: []string{"amd64"},
+				Context:      dir,
+				BuildArgs:    tt.args,
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			err = app.Run(context.Background())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr != nil {
+				assert.Equal(t, ExitValidation, ExitCode(err))
+				assert.Empty(t, m.convertedDefs)
+				assert.Equal(t, int64(0), m.submits.Load())
+				return
+			}
+
+			// The substituted definition is used to determine the build context, and is submitted.
+			assert.Equal(t, [][]byte{[]byte(tt.want)}, m.convertedDefs)
+			assert.Equal(t, [][]byte{[]byte(tt.want)}, m.submittedDefs)
+
+			if assert.Len(t, m.archives, 1) {
+				want := strings.TrimPrefix(filepath.ToSlash(filepath.Join(dir, "data-1.2.txt")), "/")
+				assert.Contains(t, archiveEntries(t, m.archives[0]), want)
+			}
+		})
+	}
+}
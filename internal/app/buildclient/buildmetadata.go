@@ -0,0 +1,92 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	build "github.com/sylabs/scs-build-client/client"
+)
+
+// BuildMetadata describes the image built for a single architecture. It is written to the build
+// metadata file, if configured, once the image is verified. The JSON field names are stable, so
+// that other tools may consume it.
+type BuildMetadata struct {
+	BuildID          string     `json:"buildID"`
+	Arch             string     `json:"arch"`
+	DefinitionDigest string     `json:"definitionDigest"`
+	ContextDigest    string     `json:"contextDigest,omitempty"`
+	ImageChecksum    string     `json:"imageChecksum,omitempty"` // Of the image delivered, once signed.
+	LibraryRef       string     `json:"libraryRef,omitempty"`
+	FileName         string     `json:"fileName,omitempty"`
+	SubmitTime       *time.Time `json:"submitTime,omitempty"`
+	StartTime        *time.Time `json:"startTime,omitempty"`
+	CompleteTime     *time.Time `json:"completeTime,omitempty"`
+}
+
+// timeOrNil returns a pointer to t, or nil if t is the zero time.
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// buildMetadata returns the metadata of the image built for arch, as described by bi, and written
+// to libraryRef and fileName, either of which may be empty. Where libraryRef is empty, the image
+// was pushed to the library location reported by the Build Service.
+func (app *App) buildMetadata(arch string, bi *build.BuildInfo, libraryRef, fileName string) BuildMetadata {
+	if libraryRef == "" {
+		libraryRef = bi.LibraryRef()
+	}
+	if fileName == stdoutDestination {
+		fileName = ""
+	}
+
+	bm := BuildMetadata{
+		BuildID:       bi.ID(),
+		Arch:          arch,
+		ImageChecksum: app.deliveredChecksum(arch, bi),
+		LibraryRef:    libraryRef,
+		FileName:      fileName,
+		SubmitTime:    timeOrNil(bi.SubmitTime()),
+		StartTime:     timeOrNil(bi.StartTime()),
+		CompleteTime:  timeOrNil(bi.CompleteTime()),
+	}
+	if app.metadata != nil {
+		bm.DefinitionDigest = app.metadata.DefinitionDigest
+		bm.ContextDigest = app.metadata.ContextDigest
+	}
+	return bm
+}
+
+// writeBuildMetadata writes bm to app.metadataFile, named as the image files of each architecture
+// are by default if building for multiple architectures. See archFileName. If app.metadataFile is
+// stdoutDestination, bm is instead written to app.metadataOut, on a single line.
+func (app *App) writeBuildMetadata(bm BuildMetadata) error {
+	if app.metadataFile == stdoutDestination {
+		return json.NewEncoder(app.metadataOut).Encode(bm)
+	}
+
+	b, err := json.MarshalIndent(bm, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	name := app.metadataFile
+	if len(app.archsToBuild) > 1 {
+		// The default template requires no tag, so cannot fail.
+		name, _ = archFileName(defaultOutputTemplate, name, bm.Arch, "")
+	}
+	if err := os.WriteFile(name, append(b, '\n'), 0o644); err != nil { //nolint:gosec
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote build metadata to %v\n", name)
+	return nil
+}
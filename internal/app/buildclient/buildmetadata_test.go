@@ -0,0 +1,224 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	build "github.com/sylabs/scs-build-client/client"
+	"github.com/sylabs/sif/v2/pkg/integrity"
+)
+
+func TestBuildMetadata_JSON(t *testing.T) {
+	submitted := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	started := submitted.Add(time.Minute)
+	completed := started.Add(time.Minute)
+
+	bm := BuildMetadata{
+		BuildID:          "6387923149ab6b512d0326f3",
+		Arch:             "amd64",
+		DefinitionDigest: "sha256.9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		ContextDigest:    "sha256.fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9",
+		ImageChecksum:    "sha256.2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+		LibraryRef:       "library:user/collection/image:tag",
+		FileName:         "image.sif",
+		SubmitTime:       &submitted,
+		StartTime:        &started,
+		CompleteTime:     &completed,
+	}
+
+	b, err := json.Marshal(bm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Field names are relied upon by consumers, so must not change.
+	assert.JSONEq(t, `{
+		"buildID": "6387923149ab6b512d0326f3",
+		"arch": "amd64",
+		"definitionDigest": "sha256.9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		"contextDigest": "sha256.fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9",
+		"imageChecksum": "sha256.2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+		"libraryRef": "library:user/collection/image:tag",
+		"fileName": "image.sif",
+		"submitTime": "2024-01-02T03:04:05Z",
+		"startTime": "2024-01-02T03:05:05Z",
+		"completeTime": "2024-01-02T03:06:05Z"
+	}`, string(b))
+
+	var got BuildMetadata
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, bm, got)
+}
+
+func TestApp_RunMetadataFile(t *testing.T) {
+	// Serve a SIF image, so that it can be signed.
+	sif, err := os.ReadFile(filepath.Join("testdata", "deffile.sif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(b []byte) { mockImage = b }(mockImage)
+	mockImage = sif
+
+	tests := []struct {
+		name          string
+		archs         []string
+		stdout        bool
+		signed        bool
+		imageChecksum string
+		wantFiles     map[string]string // Metadata files written, and the architecture each describes.
+	}{
+		{
+			name:      "File",
+			archs:     []string{"amd64"},
+			wantFiles: map[string]string{"metadata.json": "amd64"},
+		},
+		{
+			name:      "MultipleArchs",
+			archs:     []string{"amd64", "arm64"},
+			wantFiles: map[string]string{"metadata-amd64.json": "amd64", "metadata-arm64.json": "arm64"},
+		},
+		{
+			name:   "Stdout",
+			archs:  []string{"amd64", "arm64"},
+			stdout: true,
+		},
+		{
+			name:      "Signed",
+			archs:     []string{"amd64"},
+			signed:    true,
+			wantFiles: map[string]string{"metadata.json": "amd64"},
+		},
+		{
+			name:          "ChecksumMismatch",
+			archs:         []string{"amd64"},
+			imageChecksum: "sha256.0000000000000000000000000000000000000000000000000000000000000000",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.imageChecksum = tt.imageChecksum
+
+			dir := t.TempDir()
+
+			def := []byte("bootstrap: docker\nfrom: alpine:3\n")
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, def, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			metadataFile := filepath.Join(dir, "metadata.json")
+			if tt.stdout {
+				metadataFile = stdoutDestination
+			}
+
+			cfg := &Config{
+				URL:          m.frontend.URL,
+				BuildSpec:    defFile,
+				LibraryRef:   filepath.Join(dir, "image.sif"),
+				ArchsToBuild: tt.archs,
+				MetadataFile: metadataFile,
+			}
+			if tt.signed {
+				cfg.SignerOpts = []integrity.SignerOpt{integrity.OptSignWithEntity(newTestEntity(t))}
+			}
+
+			app, err := New(context.Background(), cfg)
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			var out bytes.Buffer
+			app.metadataOut = &out
+
+			if tt.stdout {
+				// Human-readable output is not written to standard output.
+				assert.Equal(t, os.Stderr, app.stdout)
+				app.stdout = &bytes.Buffer{}
+			}
+
+			err = app.Run(context.Background())
+			if got, want := err != nil, tt.imageChecksum != ""; got != want {
+				t.Fatalf("got error %v, want error %v", err, want)
+			}
+
+			var docs []BuildMetadata
+
+			if tt.stdout {
+				for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+					var bm BuildMetadata
+					if err := json.Unmarshal([]byte(line), &bm); err != nil {
+						t.Fatal(err)
+					}
+					docs = append(docs, bm)
+				}
+				assert.Len(t, docs, len(tt.archs))
+			} else {
+				assert.Empty(t, out.String())
+
+				for name, arch := range tt.wantFiles {
+					b, err := os.ReadFile(filepath.Join(dir, name))
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					var bm BuildMetadata
+					if err := json.Unmarshal(b, &bm); err != nil {
+						t.Fatal(err)
+					}
+					assert.Equal(t, arch, bm.Arch)
+					docs = append(docs, bm)
+				}
+			}
+
+			// An image that fails verification is not described.
+			if tt.imageChecksum != "" {
+				assert.NoFileExists(t, metadataFile)
+			}
+
+			for _, bm := range docs {
+				assert.Equal(t, mockBuildID, bm.BuildID)
+				assert.Equal(t, definitionDigest(def), bm.DefinitionDigest)
+				// The checksum describes the image delivered, which differs from that built if signed.
+				want := imageChecksum()
+				if tt.signed {
+					if want, err = fileChecksum(bm.FileName); err != nil {
+						t.Fatal(err)
+					}
+					assert.NotEqual(t, imageChecksum(), want)
+				}
+				assert.Equal(t, want, bm.ImageChecksum)
+				assert.Equal(t, app.dstFileNameForArch(bm.Arch), bm.FileName)
+				if assert.NotNil(t, bm.SubmitTime) {
+					assert.True(t, mockSubmitTime.Equal(*bm.SubmitTime))
+				}
+				if assert.NotNil(t, bm.StartTime) {
+					assert.True(t, mockStartTime.Equal(*bm.StartTime))
+				}
+			}
+		})
+	}
+}
+
+func TestApp_BuildMetadataStdout(t *testing.T) {
+	app := &App{}
+
+	// An image written to standard output has no file name.
+	bm := app.buildMetadata("amd64", &build.BuildInfo{}, "", stdoutDestination)
+	assert.Empty(t, bm.FileName)
+}
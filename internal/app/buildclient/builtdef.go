@@ -0,0 +1,58 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	build "github.com/sylabs/scs-build-client/client"
+)
+
+var errDefinitionMismatch = errors.New("definition built differs from definition submitted")
+
+// builtDefinitionDigest returns the digest of the definition built, as reported in bi by the Build
+// Service, or an empty string if not reported, as by older Build Service versions.
+func builtDefinitionDigest(bi *build.BuildInfo) string {
+	if checksum := bi.DefinitionChecksum(); checksum != "" {
+		return checksum
+	}
+	if def := bi.DefinitionRaw(); def != nil {
+		return definitionDigest(def)
+	}
+	return ""
+}
+
+// verifyBuiltDefinition compares the definition built for arch, as reported in bi, with def, the
+// definition submitted, and records the digest reported, so that it is included in the run
+// metadata and provenance. If the definitions differ, a warning is reported or, if
+// app.strictProvenance is set, an error returned.
+//
+// If the Build Service does not report the definition built, nothing is recorded, leaving only the
+// digest of the definition submitted.
+func (app *App) verifyBuiltDefinition(arch string, bi *build.BuildInfo, def []byte) error {
+	got := builtDefinitionDigest(bi)
+	if got == "" {
+		return nil
+	}
+	app.builtDefinitions[arch] = got
+
+	want := definitionDigest(def)
+
+	// The case of neither the algorithm nor the hex digest is significant.
+	if strings.EqualFold(got, want) {
+		return nil
+	}
+
+	err := fmt.Errorf("%w (submitted %v, built %v)", errDefinitionMismatch, want, got)
+	if app.strictProvenance {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %v: %v\n", arch, err)
+	return nil
+}
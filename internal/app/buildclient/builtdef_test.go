@@ -0,0 +1,139 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/sylabs/scs-build-client/internal/pkg/provenance"
+)
+
+func TestApp_RunBuiltDefinition(t *testing.T) {
+	def := []byte("bootstrap: docker\nfrom: alpine:3\n")
+	digest := definitionDigest(def)
+	otherDigest := definitionDigest([]byte("bootstrap: docker\nfrom: alpine:latest\n"))
+
+	tests := []struct {
+		name               string
+		definitionChecksum string
+		echoDefinition     bool
+		strict             bool
+		wantErr            error
+		wantBuilt          string
+	}{
+		{
+			name: "Absent",
+		},
+		{
+			name:   "AbsentStrict",
+			strict: true,
+		},
+		{
+			name:               "ChecksumMatch",
+			definitionChecksum: digest,
+			strict:             true,
+			wantBuilt:          digest,
+		},
+		{
+			name:           "RawMatch",
+			echoDefinition: true,
+			strict:         true,
+			wantBuilt:      digest,
+		},
+		{
+			name:               "Mismatch",
+			definitionChecksum: otherDigest,
+			wantBuilt:          otherDigest,
+		},
+		{
+			name:               "MismatchStrict",
+			definitionChecksum: otherDigest,
+			strict:             true,
+			wantErr:            errDefinitionMismatch,
+			wantBuilt:          otherDigest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.definitionChecksum = tt.definitionChecksum
+			m.echoDefinition = tt.echoDefinition
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, def, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			resumeFile := filepath.Join(dir, "metadata.json")
+			provenanceFile := filepath.Join(dir, "provenance.json")
+
+			app, err := New(context.Background(), &Config{
+				URL:              m.frontend.URL,
+				BuildSpec:        defFile,
+				LibraryRef:       filepath.Join(dir, "image.sif"),
+				ArchsToBuild:     []string{"amd64"},
+				ResumeFile:       resumeFile,
+				ProvenanceFile:   provenanceFile,
+				StrictProvenance: tt.strict,
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			if err := app.Run(context.Background()); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			// Both the definition submitted and that built, if reported, are recorded.
+			md, err := readMetadata(resumeFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, digest, md.DefinitionDigest)
+
+			if am := md.arch("amd64"); assert.NotNil(t, am) {
+				assert.Equal(t, tt.wantBuilt, am.DefinitionDigest)
+			}
+
+			if tt.wantErr != nil {
+				return
+			}
+
+			b, err := os.ReadFile(provenanceFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var s provenance.Statement
+			if err := json.Unmarshal(b, &s); err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, "sha256."+s.Predicate.BuildDefinition.ExternalParameters.Definition.Digest["sha256"], digest)
+
+			var built []string
+			for _, rd := range s.Predicate.RunDetails.Byproducts {
+				if rd.Name == "definition-built-amd64" {
+					built = append(built, "sha256."+rd.Digest["sha256"])
+				}
+			}
+			if tt.wantBuilt == "" {
+				assert.Empty(t, built)
+			} else {
+				assert.Equal(t, []string{tt.wantBuilt}, built)
+			}
+		})
+	}
+}
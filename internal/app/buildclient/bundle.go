@@ -0,0 +1,476 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// bundleRedacted replaces sensitive values in support bundles.
+const bundleRedacted = "REDACTED"
+
+// bundleInfoTimeout bounds the time taken to retrieve Build Service information for a support
+// bundle, which is written once the run is complete, and possibly after it was cancelled.
+const bundleInfoTimeout = 10 * time.Second
+
+// sensitiveSettings lists settings whose values are redacted from support bundles.
+var sensitiveSettings = []string{
+	keyAccessToken,
+	keyPassphrase,
+	keyGitToken,
+}
+
+// supportBundle describes the support bundle written once a run completes, and records the runs
+// it describes. See writeSupportBundle.
+type supportBundle struct {
+	path     string
+	always   bool           // Write the bundle even if the run succeeds.
+	withDef  bool           // Include definitions, which are otherwise omitted.
+	settings map[string]any // Effective settings, as specified.
+	secrets  []string       // Values redacted wherever they appear.
+	traceDir string         // If set, directory containing the HTTP trace.
+	runs     []*bundleRun
+}
+
+// bundleRun records a run of a single definition.
+type bundleRun struct {
+	name     string // Name of the definition, in batch mode.
+	defPath  string
+	def      []byte // Definition as read, before preprocessing or substitution of build arguments.
+	sources  []FileTransport
+	metadata *Metadata
+}
+
+// bundleFile describes a file included in a support bundle.
+type bundleFile struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// bundleOmission describes an item omitted from a support bundle.
+type bundleOmission struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// bundleManifest describes the content of a support bundle, and what was redacted from it.
+type bundleManifest struct {
+	Created   time.Time        `json:"created"`
+	UserAgent string           `json:"userAgent,omitempty"`
+	Error     string           `json:"error,omitempty"` // Error with which the run failed, if any.
+	Files     []bundleFile     `json:"files"`
+	Omitted   []bundleOmission `json:"omitted,omitempty"`
+	Redacted  []string         `json:"redacted,omitempty"` // Descriptions of redacted values.
+}
+
+// setSupportBundle sets the support bundle written once the run completes, as specified in cfg.
+func (app *App) setSupportBundle(cfg *Config) {
+	if cfg.SupportBundle == "" {
+		return
+	}
+
+	app.bundle = &supportBundle{
+		path:     cfg.SupportBundle,
+		always:   cfg.SupportBundleAlways,
+		withDef:  cfg.BundleDefinition,
+		settings: cfg.BundleSettings,
+		secrets:  slices.Clone(cfg.BundleSecrets),
+		traceDir: cfg.HTTPTraceDir,
+	}
+	app.bundle.addSecrets(cfg.AuthToken, cfg.GitToken)
+
+	// Header and build argument values may carry credentials, so are redacted wherever they appear,
+	// such as in the HTTP trace, rather than from settings alone. See redactSettings.
+	for name, vs := range cfg.HTTPHeaders {
+		for _, v := range vs {
+			app.bundle.addSecrets(v, name+": "+v)
+		}
+	}
+	for _, v := range cfg.BuildArgs {
+		app.bundle.addSecrets(v)
+	}
+}
+
+// addSecrets records values to be redacted wherever they appear in the bundle.
+func (b *supportBundle) addSecrets(secrets ...string) {
+	if b == nil {
+		return
+	}
+
+	for _, s := range secrets {
+		if s != "" && !slices.Contains(b.secrets, s) {
+			b.secrets = append(b.secrets, s)
+		}
+	}
+}
+
+// addRun records a run of the definition at defPath, with content def, as read.
+func (b *supportBundle) addRun(name, defPath string, def []byte) {
+	if b == nil {
+		return
+	}
+	b.runs = append(b.runs, &bundleRun{name: name, defPath: defPath, def: def})
+}
+
+// setMetadata records the metadata of the current run.
+func (b *supportBundle) setMetadata(md *Metadata) {
+	if b == nil || len(b.runs) == 0 {
+		return
+	}
+	b.runs[len(b.runs)-1].metadata = md
+}
+
+// setSources records the build context sources of the current run.
+func (b *supportBundle) setSources(sources []FileTransport) {
+	if b == nil || len(b.runs) == 0 {
+		return
+	}
+	b.runs[len(b.runs)-1].sources = sources
+}
+
+// passphraseSecrets returns the passphrases specified in v, or the environment, for redaction
+// from the support bundle. A passphrase read from a file descriptor cannot be read again, so is
+// not returned.
+func passphraseSecrets(v *viper.Viper) []string {
+	var secrets []string
+
+	if path := v.GetString(keyPassphraseFile); path != "" {
+		if f, err := os.Open(path); err == nil {
+			if p, err := readPassphrase(f); err == nil {
+				secrets = append(secrets, string(p))
+			}
+			f.Close()
+		}
+	}
+
+	secrets = append(secrets, v.GetString(keyPassphrase), os.Getenv(envPGPPassphrase))
+
+	return secrets
+}
+
+// redactSettingValue returns v, with any user information in a URL, which may include a token or
+// password, redacted.
+func redactSettingValue(v string) string {
+	if u, err := url.Parse(v); err == nil && u.User != nil {
+		u.User = url.User(bundleRedacted)
+		return u.String()
+	}
+	return v
+}
+
+// redactSettings returns a copy of settings with sensitive values redacted, along with the names
+// of the settings redacted. Header values and build argument values are redacted, since they may
+// carry credentials, as is user information in URLs.
+func redactSettings(settings map[string]any) (map[string]any, []string) {
+	redacted := make(map[string]any, len(settings))
+	var names []string
+
+	for k, v := range settings {
+		switch {
+		case slices.Contains(sensitiveSettings, k):
+			if v != nil && fmt.Sprint(v) != "" {
+				v = bundleRedacted
+				names = append(names, k)
+			}
+
+		case k == keyHeader || k == keyBuildArg:
+			sep := "="
+			if k == keyHeader {
+				sep = ": "
+			}
+
+			if vs, ok := v.([]string); ok && len(vs) > 0 {
+				rvs := make([]string, 0, len(vs))
+				for _, s := range vs {
+					name, _, _ := strings.Cut(s, strings.TrimSpace(sep))
+					rvs = append(rvs, name+sep+bundleRedacted)
+				}
+				v = rvs
+				names = append(names, k)
+			}
+
+		default:
+			if s, ok := v.(string); ok {
+				if rs := redactSettingValue(s); rs != s {
+					v = rs
+					names = append(names, k)
+				}
+			}
+		}
+
+		redacted[k] = v
+	}
+
+	slices.Sort(names)
+	return redacted, names
+}
+
+// redactSecrets returns b, with each of secrets replaced wherever it appears, and whether any
+// replacement was made. Secrets are also replaced where they appear escaped in JSON, such as in the
+// HTTP trace.
+func redactSecrets(b []byte, secrets []string) ([]byte, bool) {
+	found := false
+	for _, s := range secrets {
+		if s == "" {
+			continue
+		}
+
+		forms := [][]byte{[]byte(s)}
+		if j, err := json.Marshal(s); err == nil && string(j[1:len(j)-1]) != s {
+			forms = append(forms, j[1:len(j)-1])
+		}
+
+		for _, f := range forms {
+			if bytes.Contains(b, f) {
+				b = bytes.ReplaceAll(b, f, []byte(bundleRedacted))
+				found = true
+			}
+		}
+	}
+	return b, found
+}
+
+// bundleWriter assembles the content of a support bundle.
+type bundleWriter struct {
+	secrets  []string
+	manifest bundleManifest
+	files    map[string][]byte
+}
+
+// add adds a file with the specified name, description and content to the bundle, redacting
+// secrets.
+func (w *bundleWriter) add(name, description string, b []byte) {
+	b, found := redactSecrets(b, w.secrets)
+	if found {
+		w.manifest.Redacted = append(w.manifest.Redacted, name+": secret values")
+	}
+
+	w.manifest.Files = append(w.manifest.Files, bundleFile{name, description})
+	w.files[name] = b
+}
+
+// addJSON adds a file containing the JSON encoding of v to the bundle, as described by add.
+func (w *bundleWriter) addJSON(name, description string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	w.add(name, description, append(b, '\n'))
+	return nil
+}
+
+// omit records that the named item was omitted from the bundle, and why.
+func (w *bundleWriter) omit(name, reason string) {
+	w.manifest.Omitted = append(w.manifest.Omitted, bundleOmission{name, reason})
+}
+
+// writeTo writes the bundle to the named file, as a gzip-compressed tar archive, with the
+// manifest first.
+func (w *bundleWriter) writeTo(name string) (err error) {
+	manifest, err := json.MarshalIndent(w.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	manifest, _ = redactSecrets(append(manifest, '\n'), w.secrets)
+
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(name)
+		}
+	}()
+
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+
+	write := func(name string, b []byte) error {
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    0o600,
+			Size:    int64(len(b)),
+			ModTime: w.manifest.Created,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(b)
+		return err
+	}
+
+	if err := write("manifest.json", manifest); err != nil {
+		return err
+	}
+	for _, bf := range w.manifest.Files {
+		if err := write(bf.Name, w.files[bf.Name]); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// addRun adds the files describing r to the bundle.
+func (w *bundleWriter) addRun(r *bundleRun, withDef bool) error {
+	prefix := ""
+	if r.name != "" {
+		prefix = r.name + "/"
+	}
+
+	if !withDef {
+		w.omit(prefix+"definition.def", "definitions are only included if requested (--"+keySupportBundleDef+")")
+	} else {
+		w.add(prefix+"definition.def", fmt.Sprintf("definition %v, as read", r.defPath), r.def)
+	}
+
+	contextManifest := struct {
+		Digest  string          `json:"digest,omitempty"`
+		Sources []FileTransport `json:"sources"`
+	}{Sources: r.sources}
+	if contextManifest.Sources == nil {
+		contextManifest.Sources = []FileTransport{}
+	}
+	if r.metadata != nil {
+		contextManifest.Digest = r.metadata.ContextDigest
+	}
+	if err := w.addJSON(prefix+"context.json", "build context sources, from the '%files' section(s) of the definition", contextManifest); err != nil {
+		return err
+	}
+
+	if r.metadata == nil {
+		w.omit(prefix+"metadata.json", "run failed before metadata was recorded")
+		return nil
+	}
+
+	if err := w.addJSON(prefix+"metadata.json", "run metadata, as recorded by --"+keyResume, r.metadata); err != nil {
+		return err
+	}
+
+	for _, am := range r.metadata.Archs {
+		if am.OutputTail != "" {
+			w.add(prefix+path.Join("output", am.Arch+".log"), "final build output for "+am.Arch, []byte(am.OutputTail))
+		}
+	}
+	return nil
+}
+
+// addHTTPTrace adds the files recorded by --http-trace in dir to the bundle.
+func (w *bundleWriter) addHTTPTrace(dir string) error {
+	if dir == "" {
+		w.omit("http-trace", "HTTP trace not enabled (--"+keyHTTPTrace+")")
+		return nil
+	}
+
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		w.add(path.Join("http-trace", filepath.Base(name)), "recorded HTTP exchange", b)
+	}
+	return nil
+}
+
+// writeSupportBundle writes a support bundle describing the run, which failed with runErr, if the
+// run failed or a bundle is always written. The bundle is a gzip-compressed tar archive containing
+// the effective settings, the build context sources, run metadata, final build output of each
+// architecture, HTTP trace, Build Service information and, if requested, definitions, along with
+// a manifest describing what was included and redacted.
+//
+// Tokens, passphrases, header and build argument values, and other sensitive settings are redacted
+// from every file in the bundle, including the HTTP trace. A failure to write the bundle is
+// reported as a warning, so as not to obscure runErr.
+func (app *App) writeSupportBundle(ctx context.Context, runErr error) {
+	if app.bundle == nil || (runErr == nil && !app.bundle.always) {
+		return
+	}
+
+	if err := app.assembleSupportBundle(ctx, runErr); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: unable to write support bundle: %v\n", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Support bundle written to %v\n", app.bundle.path)
+}
+
+// assembleSupportBundle writes the support bundle, as described by writeSupportBundle.
+func (app *App) assembleSupportBundle(ctx context.Context, runErr error) error {
+	b := app.bundle
+
+	// The token may have been refreshed since the run started.
+	if app.authTokenFunc != nil {
+		if token, err := app.authTokenFunc(ctx, false); err == nil {
+			b.addSecrets(token)
+		}
+	}
+
+	w := &bundleWriter{
+		secrets: b.secrets,
+		manifest: bundleManifest{
+			Created:   time.Now().UTC(),
+			UserAgent: app.userAgent,
+		},
+		files: make(map[string][]byte),
+	}
+	if runErr != nil {
+		w.manifest.Error = runErr.Error()
+	}
+
+	settings, redacted := redactSettings(b.settings)
+	for _, name := range redacted {
+		w.manifest.Redacted = append(w.manifest.Redacted, "settings.json: "+name)
+	}
+	if err := w.addJSON("settings.json", "effective settings", settings); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), bundleInfoTimeout)
+	defer cancel()
+
+	var info bytes.Buffer
+	if err := app.WriteInfo(ctx, &info); err != nil {
+		w.omit("info.txt", err.Error())
+	} else {
+		w.add("info.txt", "frontend and Build Service information", info.Bytes())
+	}
+
+	for _, r := range b.runs {
+		if err := w.addRun(r, b.withDef); err != nil {
+			return err
+		}
+	}
+
+	if err := w.addHTTPTrace(b.traceDir); err != nil {
+		return err
+	}
+
+	return w.writeTo(b.path)
+}
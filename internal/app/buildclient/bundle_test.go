@@ -0,0 +1,271 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Secrets that must never appear in a support bundle.
+const (
+	bundleToken      = "s3cr3t-auth-token"
+	bundlePassphrase = "s3cr3t-passphrase"
+	bundleGitToken   = "s3cr3t-git-token"
+	bundlePassword   = "s3cr3t-proxy-password"
+	bundleHeader     = "s3cr3t-api-key"
+	bundleBuildArg   = "s3cr3t-build-arg"
+)
+
+var bundleSecretValues = []string{
+	bundleToken,
+	bundlePassphrase,
+	bundleGitToken,
+	bundlePassword,
+	bundleHeader,
+	bundleBuildArg,
+}
+
+// bundleSettings returns effective settings containing each of bundleSecretValues.
+func bundleSettings() map[string]any {
+	return map[string]any{
+		keyAccessToken:   bundleToken,
+		keyPassphrase:    bundlePassphrase,
+		keyGitToken:      bundleGitToken,
+		keyProxy:         "http://user:" + bundlePassword + "@proxy.example.com:3128",
+		keyContext:       "git+https://" + bundleGitToken + "@git.example.com/repo.git#v1",
+		keyHeader:        []string{"X-API-Key: " + bundleHeader},
+		keyBuildArg:      []string{"SECRET=" + bundleBuildArg},
+		keyArch:          []string{"amd64"},
+		keyFrontendURL:   "https://cloud.example.com",
+		keyPassphraseFD:  -1,
+		keySupportBundle: "bundle.tar.gz",
+	}
+}
+
+// assertNoSecrets fails the test if any of bundleSecretValues appear in b.
+func assertNoSecrets(t *testing.T, name string, b []byte) {
+	t.Helper()
+
+	for _, s := range bundleSecretValues {
+		if strings.Contains(string(b), s) {
+			t.Errorf("%v contains secret %q", name, s)
+		}
+	}
+}
+
+func TestRedactSettings(t *testing.T) {
+	settings, names := redactSettings(bundleSettings())
+
+	b, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNoSecrets(t, "settings", b)
+
+	assert.Equal(t, []string{keyAccessToken, keyBuildArg, keyContext, keyGitToken, keyHeader, keyPassphrase, keyProxy}, names)
+
+	assert.Equal(t, bundleRedacted, settings[keyAccessToken])
+	assert.Equal(t, []string{"X-API-Key: " + bundleRedacted}, settings[keyHeader])
+	assert.Equal(t, []string{"SECRET=" + bundleRedacted}, settings[keyBuildArg])
+	assert.Equal(t, "http://"+bundleRedacted+"@proxy.example.com:3128", settings[keyProxy])
+	assert.Equal(t, "git+https://"+bundleRedacted+"@git.example.com/repo.git#v1", settings[keyContext])
+
+	// Settings without sensitive values are unchanged.
+	assert.Equal(t, []string{"amd64"}, settings[keyArch])
+	assert.Equal(t, "https://cloud.example.com", settings[keyFrontendURL])
+	assert.Equal(t, -1, settings[keyPassphraseFD])
+}
+
+func TestRedactSecrets(t *testing.T) {
+	b, found := redactSecrets([]byte("token "+bundleToken+" and "+bundleToken), []string{"", bundleToken})
+	assert.True(t, found)
+	assert.Equal(t, "token REDACTED and REDACTED", string(b))
+
+	b, found = redactSecrets([]byte("nothing to see"), []string{bundleToken})
+	assert.False(t, found)
+	assert.Equal(t, "nothing to see", string(b))
+
+	// Secrets are redacted from JSON, in which they may be escaped.
+	b, found = redactSecrets([]byte(`{"header":"key\u0026\"value\""}`), []string{`key&"value"`})
+	assert.True(t, found)
+	assert.Equal(t, `{"header":"REDACTED"}`, string(b))
+}
+
+// readBundle returns the manifest and files of the support bundle at path.
+func readBundle(t *testing.T, path string) (*bundleManifest, map[string][]byte) {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string][]byte)
+
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = b
+	}
+
+	var manifest bundleManifest
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		t.Fatal(err)
+	}
+	return &manifest, files
+}
+
+func TestApp_RunSupportBundle(t *testing.T) {
+	tests := []struct {
+		name       string
+		failBuild  bool
+		always     bool
+		withDef    bool
+		wantBundle bool
+	}{
+		{
+			name:       "Failure",
+			failBuild:  true,
+			wantBundle: true,
+		},
+		{
+			name:       "FailureDefinition",
+			failBuild:  true,
+			withDef:    true,
+			wantBundle: true,
+		},
+		{
+			name: "Success",
+		},
+		{
+			name:       "SuccessAlways",
+			always:     true,
+			wantBundle: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.acceptToken = bundleToken
+			m.failBuild = tt.failBuild
+			m.output = []string{
+				"Fetching with token " + bundleToken + "\n",
+				"Unlocking key with " + bundlePassphrase + "\n",
+			}
+
+			dir := t.TempDir()
+
+			def := "bootstrap: docker\nfrom: alpine:3\n\n%post\n  echo " + bundlePassphrase + " {{ SECRET }}\n"
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte(def), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			bundleFile := filepath.Join(dir, "bundle.tar.gz")
+
+			app, err := New(context.Background(), &Config{
+				URL:                 m.frontend.URL,
+				AuthToken:           bundleToken,
+				BuildSpec:           defFile,
+				LibraryRef:          filepath.Join(dir, "image.sif"),
+				ArchsToBuild:        []string{"amd64"},
+				HTTPTraceDir:        filepath.Join(dir, "trace"),
+				SupportBundle:       bundleFile,
+				SupportBundleAlways: tt.always,
+				BundleDefinition:    tt.withDef,
+				BundleSettings:      bundleSettings(),
+				BundleSecrets:       []string{bundlePassphrase},
+				HTTPHeaders:         http.Header{"X-Api-Key": {bundleHeader}},
+				BuildArgs:           map[string]string{"SECRET": bundleBuildArg},
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			runErr := app.Run(context.Background())
+			if got, want := runErr != nil, tt.failBuild; got != want {
+				t.Fatalf("got error %v, want error %v", runErr, want)
+			}
+
+			if !tt.wantBundle {
+				assert.NoFileExists(t, bundleFile)
+				return
+			}
+
+			manifest, files := readBundle(t, bundleFile)
+
+			// Every file in the bundle is listed in the manifest, and no file contains a secret.
+			var names []string
+			for _, f := range manifest.Files {
+				names = append(names, f.Name)
+			}
+			assert.Len(t, files, len(names)+1)
+
+			for name, b := range files {
+				assertNoSecrets(t, name, b)
+			}
+
+			for _, name := range []string{"settings.json", "info.txt", "context.json", "metadata.json"} {
+				assert.Contains(t, names, name)
+			}
+			assert.Contains(t, manifest.Redacted, "settings.json: "+keyAccessToken)
+
+			if tt.withDef {
+				assert.Contains(t, names, "definition.def")
+				assert.Contains(t, string(files["definition.def"]), "echo "+bundleRedacted)
+				assert.Contains(t, manifest.Redacted, "definition.def: secret values")
+			} else {
+				assert.NotContains(t, names, "definition.def")
+				assert.Contains(t, manifest.Omitted, bundleOmission{"definition.def", "definitions are only included if requested (--" + keySupportBundleDef + ")"})
+			}
+
+			if tt.failBuild {
+				assert.NotEmpty(t, manifest.Error)
+				if assert.Contains(t, names, "output/amd64.log") {
+					assert.Contains(t, string(files["output/amd64.log"]), "Fetching with token "+bundleRedacted)
+				}
+			}
+
+			var traces int
+			for _, name := range names {
+				if strings.HasPrefix(name, "http-trace/") {
+					traces++
+				}
+			}
+			assert.Positive(t, traces)
+		})
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	build "github.com/sylabs/scs-build-client/client"
+	"github.com/sylabs/scs-build-client/internal/pkg/artifactcache"
+)
+
+// defaultArtifactCacheSize is the default maximum size of the artifact cache.
+const defaultArtifactCacheSize = 10 << 30
+
+var errNoArtifactCache = errors.New("no artifact cache specified")
+
+// artifactCacheKey returns the key under which the image described by bi is cached, and true if
+// the image is to be cached. Images are cached only if an artifact cache is configured, and the
+// Build Service reports a checksum against which they can be verified.
+func (app *App) artifactCacheKey(bi *build.BuildInfo) (string, bool) {
+	if app.artifactCache == nil {
+		return "", false
+	}
+
+	alg, _, ok := splitChecksum(bi.ImageChecksum())
+	if !ok || (alg != DownloadHashSHA256 && alg != DownloadHashBLAKE3) {
+		return "", false
+	}
+	return strings.ToLower(bi.ImageChecksum()), true
+}
+
+// retrieveCachedArtifact retrieves the image cached with the specified key to filename, returning
+// true if it was retrieved. The cache is advisory, so if the image cannot be retrieved, the reason
+// is reported, and false returned, so that the image is downloaded instead.
+//
+// Unless the image is to be signed, which modifies it in place, the cached image is hard linked to
+// filename where possible, rather than copied.
+func (app *App) retrieveCachedArtifact(ctx context.Context, key, filename, arch string) bool {
+	f, err := app.artifactCache.Get(ctx, key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: unable to retrieve image from artifact cache: %v\n", err)
+		return false
+	}
+	defer f.Close()
+
+	// The entry is linked only if it has the mode of artifacts written, since the mode is shared.
+	if app.signerOpts == nil && app.linkableEntry(f) && app.linkArtifact(f.Name(), filename) == nil {
+		fmt.Fprintf(os.Stderr, "Image %v retrieved from artifact cache.\n", key)
+		app.downloadChecksums[arch] = key
+		return true
+	}
+
+	if err := app.writeArtifact(filename, func(fp *os.File) (int64, error) {
+		return io.Copy(fp, f)
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: unable to retrieve image from artifact cache: %v\n", err)
+		return false
+	}
+
+	fmt.Fprintf(os.Stderr, "Image %v retrieved from artifact cache.\n", key)
+	app.downloadChecksums[arch] = key
+	return true
+}
+
+// linkableEntry reports whether the cache entry f has the mode of artifacts written, and so can be
+// linked in place of writing an artifact. On Windows, where the mode is ignored, it always does.
+func (app *App) linkableEntry(f *os.File) bool {
+	if runtime.GOOS == "windows" {
+		return true
+	}
+
+	fi, err := f.Stat()
+	return err == nil && fi.Mode().Perm() == app.outputMode
+}
+
+// linkArtifact hard links the file src to dst. The link is created alongside dst, and renamed into
+// place, so that dst is never observed partially written. See writeArtifact.
+func (app *App) linkArtifact(src, dst string) error {
+	tmp, err := artifactTempName(dst)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Link(src, tmp); err != nil {
+		return err
+	}
+
+	if err := app.artifactFS.Rename(tmp, dst); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	if !app.noFsync {
+		if err := app.artifactFS.SyncDir(filepath.Dir(dst)); err != nil {
+			return fmt.Errorf("error syncing directory %v: %w", filepath.Dir(dst), err)
+		}
+	}
+	return nil
+}
+
+// cacheArtifact adds the image at filename to the artifact cache with the specified key. The cache
+// is advisory, so failure to add the image is reported, rather than failing the run.
+func (app *App) cacheArtifact(ctx context.Context, key, filename string) {
+	if err := app.artifactCache.Put(ctx, key, filename); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: unable to add image to artifact cache: %v\n", err)
+	}
+}
+
+// PruneArtifactCache evicts least recently used images from the artifact cache in dir, until its
+// size is at most maxSize bytes.
+func PruneArtifactCache(dir string, maxSize int64, w io.Writer) error {
+	if dir == "" {
+		return fmt.Errorf("%w: specify --%v", errNoArtifactCache, keyArtifactCache)
+	}
+
+	c, err := artifactcache.Open(dir, maxSize, verifyReaderChecksum)
+	if err != nil {
+		return err
+	}
+
+	evicted, freed, err := c.Prune(maxSize)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Evicted %v image(s), freeing %v\n", evicted, formatBytes(freed))
+	return nil
+}
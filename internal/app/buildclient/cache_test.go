@@ -0,0 +1,129 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApp_RunArtifactCache(t *testing.T) {
+	m := newMockServers(t)
+
+	dir := t.TempDir()
+	cacheDir := filepath.Join(dir, "cache")
+	entry := filepath.Join(cacheDir, "entries", imageChecksum())
+
+	defFile := filepath.Join(dir, "alpine.def")
+	if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(t *testing.T) string {
+		t.Helper()
+
+		dst := filepath.Join(t.TempDir(), "image.sif")
+
+		app, err := New(context.Background(), &Config{
+			URL:               m.frontend.URL,
+			BuildSpec:         defFile,
+			LibraryRef:        dst,
+			ArchsToBuild:      []string{"amd64"},
+			ArtifactCache:     cacheDir,
+			ArtifactCacheSize: defaultArtifactCacheSize,
+		})
+		if err != nil {
+			t.Fatalf("initialization error: %v", err)
+		}
+
+		if err := app.Run(context.Background()); err != nil {
+			t.Fatalf("run error: %v", err)
+		}
+
+		if err := verifyFileChecksum(dst, imageChecksum()); err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, imageChecksum(), app.downloadChecksums["amd64"])
+
+		return dst
+	}
+
+	// On a miss, the image is downloaded, and added to the cache.
+	run(t)
+	assert.Equal(t, int64(1), m.libraryGets.Load())
+
+	if err := verifyFileChecksum(entry, imageChecksum()); err != nil {
+		t.Fatal(err)
+	}
+
+	// On a hit, the image is linked from the cache, without being downloaded.
+	dst := run(t)
+	assert.Equal(t, int64(1), m.libraryGets.Load())
+
+	efi, err := os.Stat(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dfi, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, os.SameFile(efi, dfi))
+
+	// A corrupt entry is discarded, and the image downloaded, and added to the cache, again.
+	if err := os.Remove(entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(entry, bytes.ToUpper(mockImage), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	run(t)
+	assert.Equal(t, int64(2), m.libraryGets.Load())
+
+	if err := verifyFileChecksum(entry, imageChecksum()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPruneArtifactCache(t *testing.T) {
+	if err := PruneArtifactCache("", 0, &bytes.Buffer{}); !errors.Is(err, errNoArtifactCache) {
+		t.Errorf("got error %v, want %v", err, errNoArtifactCache)
+	}
+
+	dir := t.TempDir()
+
+	src := filepath.Join(t.TempDir(), "image.sif")
+	if err := os.WriteFile(src, mockImage, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	app, err := New(context.Background(), &Config{
+		URL:               newMockServers(t).frontend.URL,
+		ArtifactCache:     dir,
+		ArtifactCacheSize: defaultArtifactCacheSize,
+	})
+	if err != nil {
+		t.Fatalf("initialization error: %v", err)
+	}
+	app.cacheArtifact(context.Background(), imageChecksum(), src)
+
+	var b bytes.Buffer
+	if err := PruneArtifactCache(dir, 0, &b); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Evicted 1 image(s), freeing "+formatBytes(int64(len(mockImage)))+"\n", b.String())
+
+	if _, err := os.Stat(filepath.Join(dir, "entries", imageChecksum())); !os.IsNotExist(err) {
+		t.Errorf("image not evicted: %v", err)
+	}
+}
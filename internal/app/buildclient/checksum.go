@@ -0,0 +1,149 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	build "github.com/sylabs/scs-build-client/client"
+	"github.com/zeebo/blake3"
+)
+
+// DownloadHash is the algorithm used to hash images as they are downloaded.
+type DownloadHash string
+
+const (
+	DownloadHashSHA256 DownloadHash = "sha256"
+	DownloadHashBLAKE3 DownloadHash = "blake3"
+	DownloadHashOff    DownloadHash = "off" // Images are not hashed, and therefore not verified.
+)
+
+// newHash returns a hash computing dh, or nil if hashing is off.
+func (dh DownloadHash) newHash() hash.Hash {
+	switch dh {
+	case DownloadHashBLAKE3:
+		return blake3.New()
+	case DownloadHashOff:
+		return nil
+	default:
+		return sha256.New()
+	}
+}
+
+// formatChecksum returns sum, computed using dh, in the "<algorithm>.<hex>" format reported by
+// the Build Service.
+func (dh DownloadHash) formatChecksum(sum []byte) string {
+	return string(dh) + "." + hex.EncodeToString(sum)
+}
+
+// splitChecksum splits checksum in "<algorithm>.<hex>" format into its algorithm and value.
+func splitChecksum(checksum string) (DownloadHash, string, bool) {
+	alg, sum, ok := strings.Cut(checksum, ".")
+	if !ok || strings.Contains(sum, ".") {
+		return "", "", false
+	}
+	return DownloadHash(strings.ToLower(alg)), sum, true
+}
+
+var errInvalidDownloadHash = errors.New("invalid download hash")
+
+// parseDownloadHash parses the algorithm used to hash downloaded images.
+func parseDownloadHash(value string) (DownloadHash, error) {
+	switch dh := DownloadHash(value); dh {
+	case DownloadHashSHA256, DownloadHashBLAKE3, DownloadHashOff:
+		return dh, nil
+	default:
+		return "", fmt.Errorf("%w %q: expected %v, %v or %v", errInvalidDownloadHash, value, DownloadHashSHA256, DownloadHashBLAKE3, DownloadHashOff)
+	}
+}
+
+// verifyChecksum compares sum, computed using dh, with the image checksum reported by the Build
+// Service, if any. If the reported checksum was computed using a different algorithm, the image
+// cannot be verified, and a notice is reported.
+func verifyChecksum(checksum string, dh DownloadHash, sum []byte) error {
+	alg, want, ok := splitChecksum(checksum)
+	if !ok {
+		return nil
+	}
+
+	if alg != dh {
+		fmt.Fprintf(os.Stderr, "Image checksum not verified (Build Service reports %v checksum, image hashed using %v).\n", alg, dh)
+		return nil
+	}
+
+	if got := hex.EncodeToString(sum); got != want {
+		return fmt.Errorf("%w (expecting %v, got %v)", errChecksumMismatch, want, got)
+	}
+	fmt.Fprintf(os.Stderr, "Image checksum verified successfully.\n")
+	return nil
+}
+
+// verifyFileChecksum verifies the contents of the named file against checksum, which is expected
+// to be in the "<algorithm>.<hex>" format reported by the Build Service.
+func verifyFileChecksum(name, checksum string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return verifyReaderChecksum(checksum, f)
+}
+
+// verifyReaderChecksum verifies the contents read from r against checksum, which is expected to be
+// in the "<algorithm>.<hex>" format reported by the Build Service. It satisfies
+// artifactcache.VerifyFunc.
+func verifyReaderChecksum(checksum string, r io.Reader) error {
+	alg, want, ok := splitChecksum(checksum)
+	if !ok || (alg != DownloadHashSHA256 && alg != DownloadHashBLAKE3) {
+		return fmt.Errorf("unsupported checksum %q", checksum)
+	}
+
+	h := alg.newHash()
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("%w (expecting %v, got %v)", errChecksumMismatch, want, got)
+	}
+	return nil
+}
+
+// fileChecksum returns the SHA-256 checksum of the contents of the named file, in the
+// "<algorithm>.<hex>" format reported by the Build Service.
+func fileChecksum(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := DownloadHashSHA256.newHash()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return DownloadHashSHA256.formatChecksum(h.Sum(nil)), nil
+}
+
+// deliveredChecksum returns the checksum of the image built for arch, as delivered. Where the
+// image was signed, this differs from the checksum reported by the Build Service.
+func (app *App) deliveredChecksum(arch string, bi *build.BuildInfo) string {
+	if sum, ok := app.signedChecksums[arch]; ok {
+		return sum
+	}
+	if sum, ok := app.downloadChecksums[arch]; ok {
+		return sum
+	}
+	return bi.ImageChecksum()
+}
@@ -0,0 +1,254 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zeebo/blake3"
+)
+
+// blake3Checksum returns the BLAKE3 checksum of b, in "<algorithm>.<hex>" format.
+func blake3Checksum(b []byte) string {
+	return fmt.Sprintf("blake3.%x", blake3.Sum256(b))
+}
+
+func TestParseDownloadHash(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    DownloadHash
+		wantErr error
+	}{
+		{"SHA256", "sha256", DownloadHashSHA256, nil},
+		{"BLAKE3", "blake3", DownloadHashBLAKE3, nil},
+		{"Off", "off", DownloadHashOff, nil},
+		{"Empty", "", "", errInvalidDownloadHash},
+		{"Unsupported", "md5", "", errInvalidDownloadHash},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDownloadHash(tt.value)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	tests := []struct {
+		name     string
+		checksum string // Checksum reported by the Build Service.
+		hash     DownloadHash
+		contents []byte
+		wantErr  error
+	}{
+		{"NoChecksum", "", DownloadHashSHA256, mockImage, nil},
+		{"SHA256", imageChecksum(), DownloadHashSHA256, mockImage, nil},
+		{"SHA256Mismatch", imageChecksum(), DownloadHashSHA256, []byte("corrupt"), errChecksumMismatch},
+		{"BLAKE3", blake3Checksum(mockImage), DownloadHashBLAKE3, mockImage, nil},
+		{"BLAKE3Mismatch", blake3Checksum(mockImage), DownloadHashBLAKE3, []byte("corrupt"), errChecksumMismatch},
+		{"DifferentAlgorithm", imageChecksum(), DownloadHashBLAKE3, []byte("corrupt"), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := tt.hash.newHash()
+			h.Write(tt.contents)
+
+			if got, want := verifyChecksum(tt.checksum, tt.hash, h.Sum(nil)), tt.wantErr; !errors.Is(got, want) {
+				t.Errorf("got error %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestVerifyFileChecksum(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "image.sif")
+	if err := os.WriteFile(name, mockImage, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		checksum string
+		wantErr  bool
+	}{
+		{"SHA256", imageChecksum(), false},
+		{"BLAKE3", blake3Checksum(mockImage), false},
+		{"Mismatch", blake3Checksum([]byte("corrupt")), true},
+		{"Off", "off.", true},
+		{"Malformed", "sha256", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyFileChecksum(name, tt.checksum); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApp_RunDownloadHash(t *testing.T) {
+	tests := []struct {
+		name                 string
+		hash                 DownloadHash
+		imageChecksum        string // Checksum reported by the Build Service.
+		concurrency          uint
+		wantDownloadChecksum string
+	}{
+		{
+			name:                 "Default",
+			wantDownloadChecksum: imageChecksum(),
+		},
+		{
+			name:                 "SHA256",
+			hash:                 DownloadHashSHA256,
+			wantDownloadChecksum: imageChecksum(),
+		},
+		{
+			name:                 "BLAKE3",
+			hash:                 DownloadHashBLAKE3,
+			imageChecksum:        blake3Checksum(mockImage),
+			wantDownloadChecksum: blake3Checksum(mockImage),
+		},
+		{
+			name:                 "BLAKE3Unverified",
+			hash:                 DownloadHashBLAKE3,
+			wantDownloadChecksum: blake3Checksum(mockImage),
+		},
+		{
+			name:                 "BLAKE3Concurrent",
+			hash:                 DownloadHashBLAKE3,
+			imageChecksum:        blake3Checksum(mockImage),
+			concurrency:          4,
+			wantDownloadChecksum: blake3Checksum(mockImage),
+		},
+		{
+			name: "Off",
+			hash: DownloadHashOff,
+		},
+		{
+			name:        "OffConcurrent",
+			hash:        DownloadHashOff,
+			concurrency: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.rangedDownloads = true
+			m.imageChecksum = tt.imageChecksum
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			imageFile := filepath.Join(dir, "image.sif")
+			resumeFile := filepath.Join(dir, "metadata.json")
+
+			app, err := New(context.Background(), &Config{
+				URL:                 m.frontend.URL,
+				BuildSpec:           defFile,
+				LibraryRef:          imageFile,
+				ArchsToBuild:        []string{"amd64"},
+				ResumeFile:          resumeFile,
+				DownloadConcurrency: tt.concurrency,
+				DownloadHash:        tt.hash,
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			if err := app.Run(context.Background()); err != nil {
+				t.Fatalf("run error: %v", err)
+			}
+
+			b, err := os.ReadFile(imageFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(b, mockImage) {
+				t.Errorf("got image %q, want %q", b, mockImage)
+			}
+
+			md, err := readMetadata(resumeFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			am := md.arch("amd64")
+			if am == nil {
+				t.Fatal("arch not recorded")
+			}
+
+			if got, want := am.DownloadChecksum, tt.wantDownloadChecksum; got != want {
+				t.Errorf("got download checksum %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func BenchmarkDownloadHash(b *testing.B) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4<<20) // 64 MiB
+
+	for _, dh := range []DownloadHash{DownloadHashSHA256, DownloadHashBLAKE3, DownloadHashOff} {
+		b.Run(string(dh), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+
+			for i := 0; i < b.N; i++ {
+				var w io.Writer = io.Discard
+				if h := dh.newHash(); h != nil {
+					w = io.MultiWriter(io.Discard, h)
+				}
+
+				if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestFileChecksum(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "image.sif")
+	if err := os.WriteFile(name, mockImage, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := fileChecksum(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := imageChecksum(); got != want {
+		t.Errorf("got checksum %q, want %q", got, want)
+	}
+
+	if _, err := fileChecksum(filepath.Join(t.TempDir(), "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got error %v, want %v", err, os.ErrNotExist)
+	}
+}
@@ -6,90 +6,440 @@
 package buildclient
 
 import (
-	"bytes"
 	"context"
-	"crypto/tls"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	build "github.com/sylabs/scs-build-client/client"
+	"github.com/sylabs/scs-build-client/internal/pkg/artifactcache"
 	"github.com/sylabs/scs-build-client/internal/pkg/endpoints"
+	"github.com/sylabs/scs-build-client/internal/pkg/provenance"
+	"github.com/sylabs/scs-build-client/internal/pkg/statedir"
+	"github.com/sylabs/scs-build-client/internal/pkg/tlsdebug"
 	library "github.com/sylabs/scs-library-client/client"
 	"github.com/sylabs/sif/v2/pkg/integrity"
 )
 
-const defaultFrontendURL = "https://cloud.sylabs.io"
+const (
+	defaultFrontendURL = "https://cloud.sylabs.io"
+
+	// defaultOutputTailSize is the amount of build output retained for failure reports.
+	defaultOutputTailSize = 64 << 10
+
+	// outputTailLines is the number of lines of build output included in failure reports.
+	outputTailLines = 50
+
+	// defaultOutputGracePeriod is the period for which build output is drained once the build is
+	// reported complete, in the absence of further output.
+	defaultOutputGracePeriod = 5 * time.Second
+)
 
 // Config contains set up for application
+//
+// If BuildClient and LibraryClient are set, they are used in place of clients constructed from
+// URL, AuthToken, TLS settings, Proxy and UserAgent, and frontend discovery is skipped.
+// BuildClient and LibraryClient must be set together, and URL, AuthToken, AuthTokenFunc, Proxy,
+// HTTPHeaders and TLS settings (SkipTLSVerify, CACertFile, ClientCertFile and ClientKeyFile) must
+// not be set.
+//
+// If AuthTokenFunc is set, it is used in place of AuthToken to obtain a token before each request,
+// so that a run may outlive the lifetime of a single token. Similarly, if AuthTokenFile is set, the
+// token is read from it, and re-read when it is replaced. At most one of AuthToken, AuthTokenFunc
+// and AuthTokenFile may be set.
 type Config struct {
-	URL           string
-	AuthToken     string
-	BuildSpec     string
-	SkipTLSVerify bool
-	LibraryRef    string
-	Force         bool
-	UserAgent     string
-	ArchsToBuild  []string
-	SignerOpts    []integrity.SignerOpt
+	URL                 string
+	AuthToken           string
+	AuthTokenFunc       build.BearerTokenFunc
+	AuthTokenFile       string // If set, file from which the token is read, and re-read when it changes.
+	BuildSpec           string
+	DefFiles            []string // If set, definitions built in turn, in place of BuildSpec. See runBatch.
+	SkipTLSVerify       bool
+	LibraryRef          string
+	Tags                []string // Tags applied to LibraryRef, in addition to any it contains.
+	Force               bool
+	UserAgent           string
+	ArchsToBuild        []string // Architectures to build, or "all" for those supported by the Build Service.
+	SignerOpts          []integrity.SignerOpt
+	VerifierOpts        []integrity.VerifierOpt // If set, signed images are verified using these options before upload.
+	SignatureLog        SignatureLog            // If set, signatures are recorded in this transparency log once made.
+	ResumeFile          string
+	ForceResume         bool
+	Requirements        map[string]string
+	DefPreprocess       string            // If set, shell command through which the definition is piped before use. See preprocessDefinition.
+	BuildArgs           map[string]string // If set, values substituted for placeholders in the definition. See substituteBuildArgs.
+	AllowMissingArgs    bool              // Leave placeholders naming build arguments not in BuildArgs as is, rather than failing.
+	IgnoreCompat        bool
+	ContextCompression  build.Compression
+	OutputTailSize      int
+	AllowEmptyGlobs     bool
+	StateDir            string // If set, outputs shared with concurrent runs are locked.
+	DownloadConcurrency uint
+	ExpandEnvFiles      bool // Expand environment variables in '%files' sources. See expandSources.
+	OutputGracePeriod   time.Duration
+	LogTimestamps       bool              // Prefix each line of build output with the time it was received.
+	LogFile             string            // If set, build output is also written to this file. See openLogFile.
+	LogAppend           bool              // Append to LogFile, rather than truncating it.
+	MaxOutputBytes      int64             // If positive, build output beyond this many bytes is discarded.
+	CancelOnOutputLimit bool              // Cancel builds whose output exceeds MaxOutputBytes.
+	ProvenanceFile      string            // If set, SLSA provenance is written to this file.
+	ProvenanceSigner    provenance.Signer // If set, provenance is signed, and the signature written alongside it.
+	MetadataFile        string            // If set, build metadata is written to this file ("-" for standard output). See BuildMetadata.
+	StrictProvenance    bool              // Fail builds of a definition other than that submitted, as reported by the Build Service.
+	CACertFile          string            // If set, certificates in this PEM file are trusted, in addition to system certificates.
+	ClientCertFile      string            // If set, along with ClientKeyFile, the certificate is presented to servers.
+	ClientKeyFile       string            // Private key corresponding to ClientCertFile.
+	TLSMinVersion       string            // If set ("1.2" or "1.3"), connections negotiating an earlier TLS version are rejected.
+	TLSCipherProfile    string            // If set ("strict"), TLS 1.2 connections are restricted to the cipher suites of this profile.
+	RemoteConfigFile    string            // If set, and no auth token is set, the token for the frontend is read from this Singularity remote config.
+	Proxy               string            // If set, proxy URL used in place of HTTP_PROXY, HTTPS_PROXY and NO_PROXY.
+	HTTPHeaders         http.Header       // Additional headers included in Build Service requests.
+	BuildURL            string            // If set, along with LibraryURL, Build Service URL used in place of frontend discovery.
+	LibraryURL          string            // If set, along with BuildURL, Library Service URL used in place of frontend discovery.
+	CacheDir            string            // If set, frontend configuration and hard failures of frontend discovery are cached in this directory.
+	NoCache             bool              // Attempt frontend discovery regardless of cached configuration or failures.
+	FrontendCacheTTL    time.Duration     // Period for which frontend configuration is cached. Defaults to 24h.
+	LibraryTimeout      time.Duration     // If set, timeout of each library operation. Otherwise, sized according to the image.
+	LibraryStallTimeout time.Duration     // Library transfers making no progress for this period fail. Defaults to 2m.
+	BuildTimeout        time.Duration     // If set, builds that do not complete within this period are cancelled.
+	SubmitTimeout       time.Duration     // If set, timeout of each build submission.
+	DownloadHash        DownloadHash      // Algorithm used to hash downloaded images. Defaults to sha256.
+	NoFsync             bool              // Do not sync downloaded images to stable storage. See writeArtifact.
+	OutputMode          os.FileMode       // Permissions of image files written, regardless of umask. If zero, 0644 is used.
+	OutputTemplate      string            // Names image files where more than one architecture is built. See archFileName.
+	ArtifactCache       string            // If set, directory in which downloaded images are cached, keyed by checksum.
+	ArtifactCacheSize   int64             // Maximum size in bytes of ArtifactCache, beyond which least recently used images are evicted.
+	DryRun              bool              // Report what would be built, without uploading the build context or building.
+	CIAnnotations       CIAnnotations     // CI system for which annotations summarizing the run are written to standard output, if any.
+	HTTPTraceDir        string            // If set, HTTP requests and responses are recorded in this directory.
+	Context             string            // If set, directory or git repository ("git+<url>[#<ref>[:<subdir>]]") relative '%files' sources are resolved against.
+	WorkingDir          string            // If set, directory relative '%files' sources are resolved against, and the remote build runs in. Defaults to the working directory.
+	GitToken            string            // If set, token used to fetch a git repository Context.
+	KeepContext         bool              // Do not delete the uploaded build context once the run completes.
+	ContextDigest       string            // If set, digest of a previously uploaded build context to use, rather than uploading one.
+	SupportBundle       string            // If set, a support bundle is written to this file if the run fails. See writeSupportBundle.
+	SupportBundleAlways bool              // Write SupportBundle regardless of the outcome of the run.
+	BundleDefinition    bool              // Include definitions in SupportBundle.
+	BundleSettings      map[string]any    // Effective settings recorded in SupportBundle, once redacted. See redactSettings.
+	BundleSecrets       []string          // Values redacted wherever they appear in SupportBundle, in addition to tokens.
+	RateLimitRetries    int               // Number of times Build Service requests rejected as rate limited are retried.
+	MetricsFunc         MetricsFunc       // If set, receives the bytes transferred by each run once it completes, in place of the summary written to standard error.
+	NonInteractive      bool              // Never prompt for confirmation, assuming the safe choice. Prompts are also skipped unless standard input and error are terminals.
+	ConfirmCancel       bool              // Prompt for confirmation before cancelling running builds on the first interrupt. See awaitInterrupt.
+	Verbose             bool              // Report additional diagnostics, such as the number of definitions parsed by the Build Service, and details of failed requests.
+	BuildClient         *build.Client
+	LibraryClient       *library.Client
+
+	// TraceContextFunc, if set, injects the trace context of each request made by the run into its
+	// headers, including frontend discovery and Library Service requests.
+	TraceContextFunc build.TraceContextFunc
+
+	// Tracer, if set, starts spans for Build Service operations.
+	Tracer build.Tracer
 }
 
 // App represents the application instance
 type App struct {
-	buildClient   *build.Client
-	libraryClient *library.Client
-	buildSpec     string
-	libraryRef    *library.Ref
-	dstFileName   string
-	force         bool
-	buildURL      string
-	skipTLSVerify bool
-	archsToBuild  []string
-	signerOpts    []integrity.SignerOpt
+	buildClient         *build.Client
+	libraryClient       *library.Client
+	authTokenFunc       build.BearerTokenFunc
+	authTokenFile       string
+	buildSpec           string
+	batch               *batch // If set, definitions built in turn. See runBatch.
+	libraryRef          *library.Ref
+	dstFileName         string
+	force               bool
+	prompter            *prompter // Asks the user to confirm destructive operations.
+	confirmCancel       bool
+	buildURL            string
+	httpHeaders         http.Header // Additional headers included in Build Service requests.
+	httpClient          *http.Client
+	archsToBuild        []string
+	signerOpts          []integrity.SignerOpt
+	verifierOpts        []integrity.VerifierOpt
+	signatureLog        SignatureLog
+	resumeFile          string
+	forceResume         bool
+	requirements        map[string]string
+	defPreprocess       string
+	buildArgs           map[string]string
+	parsedDefs          map[[sha256.Size]byte]definition // Definitions parsed by the Build Service, by digest. See parseDefinition.
+	defParses           int                              // Number of definitions sent to the Build Service to parse.
+	defParseHits        int                              // Number of definitions parsed using parsedDefs.
+	verbose             bool
+	tlsMinVersion       string // If set, minimum TLS version negotiated. See translateTLSErr.
+	allowMissingArgs    bool
+	ignoreCompat        bool
+	contextCompression  build.Compression
+	outputTailSize      int
+	allowEmptyGlobs     bool
+	stateDir            *statedir.Dir
+	downloadConcurrency uint
+	expandEnvFiles      bool
+	outputGracePeriod   time.Duration
+	logTimestamps       bool
+	logFile             string
+	logAppend           bool
+	libraryTimeout      time.Duration
+	libraryStallTimeout time.Duration
+	progress            func(transferProgress) // If set, receives progress of library transfers. See reportProgress.
+	transitions         func(buildTransition)  // If set, receives build state transitions. See reportTransition.
+	tokenChanges        func()                 // If set, called when the token in authTokenFile changes. See reportTokenChange.
+	buildTimeout        time.Duration
+	submitTimeout       time.Duration
+	downloadHash        DownloadHash
+	downloadChecksums   map[string]string // Checksums of downloaded images, by architecture.
+	signedChecksums     map[string]string // Checksums of images once signed, by architecture.
+	contextBytes        atomic.Int64      // Bytes of build context uploaded by the run.
+	bytesUploaded       map[string]int64  // Bytes of images uploaded, by architecture.
+	bytesDownloaded     map[string]int64  // Bytes of images downloaded, by architecture.
+	metricsFunc         MetricsFunc       // If set, receives the bytes transferred by each run. See reportTransfers.
+	noFsync             bool
+	outputMode          os.FileMode
+	outputTemplate      string
+	outputTag           string               // Substituted for {tag} in outputTemplate.
+	artifactCache       *artifactcache.Cache // If set, downloaded images are cached here. See retrieveArtifact.
+	dryRun              bool
+	ciAnnotations       CIAnnotations
+	keepContext         bool
+	contextDigest       string
+	artifactFS          artifactFS
+	maxOutputBytes      int64
+	cancelOnOutputLimit bool
+	outputTruncated     map[string]bool // Architectures for which build output exceeded maxOutputBytes.
+	gitContext          *gitContext     // If set, the build context is fetched from this git repository.
+	gitFetcher          gitFetcher
+	contextDir          string // If set, relative '%files' sources are resolved against this directory.
+	contextRoot         string // Directory gitContext is fetched to, once fetched.
+	contextCommit       string // Commit SHA of gitContext, once fetched.
+	provenanceFile      string
+	provenanceSigner    provenance.Signer
+	metadataFile        string
+	strictProvenance    bool
+	builtDefinitions    map[string]string // Digests of definitions built, as reported by the Build Service, by architecture.
+	frontendURL         string
+	noAuthToken         bool                   // If true, no access token was configured, so requests are anonymous.
+	frontendConfigCache *endpoints.ConfigCache // If set, frontend configuration was read from this cache.
+	frontendNotices     []Notice
+	notices             []Notice // Notices of the frontend and Build Service, once reported. See reportNotices.
+	userAgent           string
+	capabilities        *build.Capabilities // Capabilities of the Build Service, once retrieved. See getCapabilities.
+	metadata            *Metadata
+	bundle              *supportBundle // If set, written once the run completes. See writeSupportBundle.
+	stdin               io.Reader
+	stdout              io.Writer // Human-readable output. Standard error where the image or build metadata is written to standard output.
+	artifactOut         io.Writer // Destination of an image written to standard output. See streamArtifact.
+	metadataOut         io.Writer // Destination of build metadata written to standard output. See writeBuildMetadata.
+
+	// If set, remaining architectures and definitions are skipped. See Stop.
+	stopped atomic.Bool
 }
 
-var errNoBuildContextFiles = errors.New("no files referenced in build definition")
+var (
+	errNoBuildContextFiles   = errors.New("no files referenced in build definition")
+	errTagsWithoutLibraryRef = errors.New("tags may only be specified when pushing to a library ref")
+)
+
+// uniqueTags returns tags with duplicates removed, preserving order.
+func uniqueTags(tags []string) []string {
+	var unique []string
+
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		if !seen[tag] {
+			seen[tag] = true
+			unique = append(unique, tag)
+		}
+	}
+
+	return unique
+}
 
 // New creates new application instance
-func New(ctx context.Context, cfg *Config) (*App, error) {
+func New(ctx context.Context, cfg *Config) (_ *App, err error) {
+	defer func() { err = tlsVersionError(err, cfg.TLSMinVersion) }()
+
 	app := &App{
-		buildSpec:     cfg.BuildSpec,
-		force:         cfg.Force,
-		skipTLSVerify: cfg.SkipTLSVerify,
-		archsToBuild:  cfg.ArchsToBuild,
-		signerOpts:    cfg.SignerOpts,
+		buildSpec:           cfg.BuildSpec,
+		force:               cfg.Force,
+		prompter:            newTerminalPrompter(cfg.NonInteractive),
+		confirmCancel:       cfg.ConfirmCancel,
+		verbose:             cfg.Verbose,
+		tlsMinVersion:       cfg.TLSMinVersion,
+		archsToBuild:        cfg.ArchsToBuild,
+		signerOpts:          cfg.SignerOpts,
+		verifierOpts:        cfg.VerifierOpts,
+		signatureLog:        cfg.SignatureLog,
+		resumeFile:          cfg.ResumeFile,
+		forceResume:         cfg.ForceResume,
+		requirements:        cfg.Requirements,
+		defPreprocess:       cfg.DefPreprocess,
+		buildArgs:           cfg.BuildArgs,
+		allowMissingArgs:    cfg.AllowMissingArgs,
+		ignoreCompat:        cfg.IgnoreCompat,
+		authTokenFunc:       cfg.AuthTokenFunc,
+		authTokenFile:       cfg.AuthTokenFile,
+		contextCompression:  cfg.ContextCompression,
+		outputTailSize:      cfg.OutputTailSize,
+		allowEmptyGlobs:     cfg.AllowEmptyGlobs,
+		downloadConcurrency: cfg.DownloadConcurrency,
+		expandEnvFiles:      cfg.ExpandEnvFiles,
+		outputGracePeriod:   cfg.OutputGracePeriod,
+		logTimestamps:       cfg.LogTimestamps,
+		logFile:             cfg.LogFile,
+		logAppend:           cfg.LogAppend,
+		libraryTimeout:      cfg.LibraryTimeout,
+		libraryStallTimeout: cfg.LibraryStallTimeout,
+		buildTimeout:        cfg.BuildTimeout,
+		submitTimeout:       cfg.SubmitTimeout,
+		downloadHash:        cfg.DownloadHash,
+		downloadChecksums:   make(map[string]string),
+		signedChecksums:     make(map[string]string),
+		bytesUploaded:       make(map[string]int64),
+		bytesDownloaded:     make(map[string]int64),
+		metricsFunc:         cfg.MetricsFunc,
+		noFsync:             cfg.NoFsync,
+		outputMode:          cfg.OutputMode,
+		outputTemplate:      cfg.OutputTemplate,
+		outputTag:           imageTag(cfg.BuildSpec),
+		dryRun:              cfg.DryRun,
+		ciAnnotations:       cfg.CIAnnotations.resolve(),
+		keepContext:         cfg.KeepContext,
+		contextDigest:       cfg.ContextDigest,
+		artifactFS:          osArtifactFS{},
+		maxOutputBytes:      cfg.MaxOutputBytes,
+		cancelOnOutputLimit: cfg.CancelOnOutputLimit,
+		outputTruncated:     make(map[string]bool),
+		provenanceFile:      cfg.ProvenanceFile,
+		provenanceSigner:    cfg.ProvenanceSigner,
+		metadataFile:        cfg.MetadataFile,
+		strictProvenance:    cfg.StrictProvenance,
+		builtDefinitions:    make(map[string]string),
+		userAgent:           cfg.UserAgent,
+		httpHeaders:         cfg.HTTPHeaders,
+		stdin:               os.Stdin,
+		stdout:              os.Stdout,
+		artifactOut:         os.Stdout,
+		metadataOut:         os.Stdout,
 	}
 
-	var libraryRefHost string
+	if app.outputMode == 0 {
+		app.outputMode = defaultOutputMode
+	}
 
-	// Parse/validate image spec (local file or library ref)
-	if strings.HasPrefix(cfg.LibraryRef, library.Scheme+":") {
-		ref, err := library.ParseAmbiguous(cfg.LibraryRef)
+	if app.outputTailSize <= 0 {
+		app.outputTailSize = defaultOutputTailSize
+	}
+
+	if app.outputGracePeriod <= 0 {
+		app.outputGracePeriod = defaultOutputGracePeriod
+	}
+
+	if app.libraryStallTimeout <= 0 {
+		app.libraryStallTimeout = defaultLibraryStallTimeout
+	}
+
+	if app.downloadHash == "" {
+		app.downloadHash = DownloadHashSHA256
+	}
+
+	app.setSupportBundle(cfg)
+
+	if cfg.StateDir != "" {
+		d, err := statedir.Open(cfg.StateDir)
 		if err != nil {
-			return nil, fmt.Errorf("malformed library ref: %w", err)
+			return nil, err
 		}
+		app.stateDir = d
+	}
 
-		if ref.Host != "" {
-			// Ref contains a host. Note this to determine the front end URL, but don't include it
-			// in the LibraryRef, since the Build Service expects a hostless format.
-			libraryRefHost = ref.Host
-			ref.Host = ""
+	if cfg.ArtifactCache != "" {
+		c, err := artifactcache.Open(cfg.ArtifactCache, cfg.ArtifactCacheSize, verifyReaderChecksum)
+		if err != nil {
+			return nil, err
 		}
+		app.artifactCache = c
+	}
 
-		app.libraryRef = ref
-	} else if cfg.LibraryRef != "" {
-		// Parse as URL
-		ref, err := url.Parse(cfg.LibraryRef)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing %v as URL: %w", cfg.LibraryRef, err)
+	dst := cfg.LibraryRef
+	if len(cfg.DefFiles) > 0 {
+		if err := app.setBatch(cfg); err != nil {
+			return nil, err
 		}
-		if ref.Scheme != "file" && ref.Scheme != "" {
-			return nil, fmt.Errorf("unsupported library ref scheme %v", ref.Scheme)
+		dst = expandDefinitionName(dst, definitionName(cfg.DefFiles[0]))
+	}
+
+	libraryRefHost, err := app.setDestination(dst, cfg.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep standard output free of anything but the image, or build metadata.
+	if app.dstFileName == stdoutDestination || app.metadataFile == stdoutDestination {
+		app.stdout = os.Stderr
+	}
+
+	if err := app.checkStdoutDestination(); err != nil {
+		return nil, err
+	}
+
+	if err := checkOutputTemplate(app.outputTemplate, app.outputTag); err != nil {
+		return nil, err
+	}
+
+	if err := app.setContext(cfg); err != nil {
+		return nil, err
+	}
+
+	if app.contextDigest != "" {
+		if err := checkContextDigest(app.contextDigest); err != nil {
+			return nil, err
 		}
-		app.dstFileName = ref.Path
+	}
+
+	// Check signing configuration before anything is built.
+	if err := app.checkSigning(); err != nil {
+		return nil, err
+	}
+
+	// Use clients supplied by caller, if provided.
+	if cfg.BuildClient != nil || cfg.LibraryClient != nil {
+		if err := checkInjectedClients(cfg); err != nil {
+			return nil, err
+		}
+
+		app.buildClient = cfg.BuildClient
+		app.libraryClient = cfg.LibraryClient
+		app.buildURL = cfg.BuildClient.BaseURL()
+		app.frontendURL = app.buildURL
+		app.httpClient = cfg.LibraryClient.HTTPClient
+		if app.httpClient == nil {
+			app.httpClient = http.DefaultClient
+		}
+
+		if err := app.resolveArchs(ctx); err != nil {
+			return nil, err
+		}
+
+		return app, nil
+	}
+
+	if cfg.AuthTokenFile != "" {
+		if cfg.AuthToken != "" || cfg.AuthTokenFunc != nil {
+			return nil, fmt.Errorf("%w: auth token must not be set along with auth token file", errConflictingClientConfig)
+		}
+
+		tf := &tokenFile{path: cfg.AuthTokenFile, onChange: app.reportTokenChange}
+		app.authTokenFunc = tf.Token
 	}
 
 	// Determine frontend URL either from library ref, if provided or url, if provided, or default.
@@ -98,40 +448,188 @@ func New(ctx context.Context, cfg *Config) (*App, error) {
 		return nil, err
 	}
 
+	tr, err := newTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	app.httpClient = &http.Client{Transport: tr}
+
+	var recorder *build.HTTPRecorder
+	if cfg.HTTPTraceDir != "" {
+		if recorder, err = build.NewHTTPRecorder(cfg.HTTPTraceDir); err != nil {
+			return nil, fmt.Errorf("error initializing HTTP trace: %w", err)
+		}
+		app.httpClient.Transport = recorder.RoundTripper(tr)
+	}
+	if cfg.TraceContextFunc != nil {
+		app.httpClient.Transport = traceTransport{next: app.httpClient.Transport, f: cfg.TraceContextFunc}
+	}
+
 	// Initialize build & library clients
-	feCfg, err := endpoints.GetFrontendConfig(ctx, cfg.SkipTLSVerify, feURL)
+	feCfg, err := app.resolveServiceURLs(ctx, cfg, feURL)
 	if err != nil {
 		return nil, err
 	}
 	app.buildURL = feCfg.BuildAPI.URI
+	app.frontendURL = feURL
+	app.frontendNotices = frontendNotices(feCfg)
 
-	tr, _ := http.DefaultTransport.(*http.Transport)
-	tr = tr.Clone()
-	tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: cfg.SkipTLSVerify}
+	authToken := cfg.AuthToken
+	if authToken == "" && app.authTokenFunc == nil && cfg.RemoteConfigFile != "" {
+		if authToken, err = remoteToken(cfg.RemoteConfigFile, feURL); err != nil {
+			return nil, err
+		}
+	}
+
+	app.noAuthToken = authToken == "" && app.authTokenFunc == nil
+
+	tokenOpt := build.OptBearerToken(authToken)
+	if app.authTokenFunc != nil {
+		if authToken, err = app.authTokenFunc(ctx, false); err != nil {
+			return nil, fmt.Errorf("error getting auth token: %w", err)
+		}
+		tokenOpt = build.OptBearerTokenFunc(app.authTokenFunc)
+	}
+
+	app.bundle.addSecrets(authToken)
 
-	app.buildClient, err = build.NewClient(
+	buildOpts := []build.Option{
 		build.OptBaseURL(feCfg.BuildAPI.URI),
-		build.OptBearerToken(cfg.AuthToken),
+		tokenOpt,
 		build.OptUserAgent(cfg.UserAgent),
-		build.OptHTTPTransport(tr),
-	)
+		build.OptHTTPTransport(buildTransport(tr)),
+		build.OptRateLimitRetries(max(cfg.RateLimitRetries, 0)),
+		build.OptRateLimitFunc(reportRateLimit),
+		build.OptVerboseErrors(cfg.Verbose),
+	}
+	if recorder != nil {
+		buildOpts = append(buildOpts, build.OptHTTPRecorder(recorder))
+	}
+	if cfg.TraceContextFunc != nil {
+		buildOpts = append(buildOpts, build.OptPropagateTraceContext(cfg.TraceContextFunc))
+	}
+	if cfg.Tracer != nil {
+		buildOpts = append(buildOpts, build.OptTracer(cfg.Tracer))
+	}
+	for k, vs := range cfg.HTTPHeaders {
+		for _, v := range vs {
+			buildOpts = append(buildOpts, build.OptHTTPHeader(k, v))
+		}
+	}
+
+	app.buildClient, err = build.NewClient(buildOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("error initializing build client: %w", err)
 	}
 
 	app.libraryClient, err = library.NewClient(&library.Config{
 		BaseURL:    feCfg.LibraryAPI.URI,
-		AuthToken:  cfg.AuthToken,
-		HTTPClient: &http.Client{Transport: tr},
+		AuthToken:  authToken,
+		HTTPClient: app.httpClient,
 		UserAgent:  cfg.UserAgent,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("error initializing library client: %w", err)
 	}
 
+	if err := app.resolveArchs(ctx); err != nil {
+		return nil, app.translateAuthErr(err)
+	}
+
 	return app, nil
 }
 
+// setDestination sets the destination of the image, which is either a library ref, to which tags
+// are applied in addition to any it contains, or a local file. If the library ref contains a host,
+// it is returned.
+func (app *App) setDestination(dst string, tags []string) (string, error) {
+	app.libraryRef, app.dstFileName = nil, ""
+
+	var libraryRefHost string
+
+	// Parse/validate image spec (local file or library ref)
+	if strings.HasPrefix(dst, library.Scheme+":") {
+		ref, err := library.ParseAmbiguous(dst)
+		if err != nil {
+			return "", fmt.Errorf("malformed library ref: %w", err)
+		}
+
+		if ref.Host != "" {
+			// Ref contains a host. Note this to determine the front end URL, but don't include it
+			// in the LibraryRef, since the Build Service expects a hostless format.
+			libraryRefHost = ref.Host
+			ref.Host = ""
+		}
+
+		ref.Tags = uniqueTags(append(ref.Tags, tags...))
+		if len(ref.Tags) == 0 {
+			// The Build Service requires a tag, so apply the Library default.
+			ref.Tags = []string{"latest"}
+		}
+
+		app.libraryRef = ref
+	} else if dst != "" {
+		// Parse as URL
+		ref, err := url.Parse(dst)
+		if err != nil {
+			return "", fmt.Errorf("error parsing %v as URL: %w", dst, err)
+		}
+		if ref.Scheme != "file" && ref.Scheme != "" {
+			return "", fmt.Errorf("unsupported library ref scheme %v", ref.Scheme)
+		}
+		app.dstFileName = ref.Path
+	}
+
+	if len(tags) > 0 && app.libraryRef == nil {
+		return "", errTagsWithoutLibraryRef
+	}
+
+	return libraryRefHost, nil
+}
+
+var (
+	errRetrieveArtifact      = errors.New("error retrieving build artifact")
+	errSigning               = errors.New("error signing image")
+	errSignatureVerification = errors.New("signature verification failed after signing")
+)
+
+var errConflictingClientConfig = errors.New("conflicting client configuration")
+
+// checkInjectedClients validates the configuration when clients are supplied by the caller.
+func checkInjectedClients(cfg *Config) error {
+	if cfg.BuildClient == nil || cfg.LibraryClient == nil {
+		return fmt.Errorf("%w: build client and library client must be supplied together", errConflictingClientConfig)
+	}
+
+	if cfg.URL != "" {
+		return fmt.Errorf("%w: URL must not be set when clients are supplied", errConflictingClientConfig)
+	}
+
+	if cfg.AuthToken != "" || cfg.AuthTokenFunc != nil || cfg.AuthTokenFile != "" {
+		return fmt.Errorf("%w: auth token must not be set when clients are supplied", errConflictingClientConfig)
+	}
+
+	if cfg.Proxy != "" {
+		return fmt.Errorf("%w: proxy must be configured in supplied clients", errConflictingClientConfig)
+	}
+
+	if len(cfg.HTTPHeaders) > 0 {
+		return fmt.Errorf("%w: headers must be configured in supplied clients", errConflictingClientConfig)
+	}
+
+	if cfg.SkipTLSVerify || cfg.CACertFile != "" || cfg.ClientCertFile != "" || cfg.ClientKeyFile != "" ||
+		cfg.TLSMinVersion != "" || cfg.TLSCipherProfile != "" {
+		return fmt.Errorf("%w: TLS must be configured in supplied clients", errConflictingClientConfig)
+	}
+
+	if cfg.TraceContextFunc != nil || cfg.Tracer != nil {
+		return fmt.Errorf("%w: tracing must be configured in supplied clients", errConflictingClientConfig)
+	}
+
+	return nil
+}
+
 // getFrontendURL determines the front end value based on urlOverride and/or libraryRefHost.
 func getFrontendURL(urlOverride, libraryRefHost string) (string, error) {
 	if urlOverride != "" {
@@ -158,192 +656,709 @@ func getFrontendURL(urlOverride, libraryRefHost string) (string, error) {
 	return defaultFrontendURL, nil
 }
 
-// uploadBuildContext parses definition file specified by 'rawDef' and uploads build context
-// containing files referenced in '%files' section(s) to build server.
+var errIncompleteServiceURLs = errors.New("build and library URLs must be specified together")
+
+// resolveServiceURLs returns the Build and Library Service URLs. If cfg.BuildURL and cfg.LibraryURL
+// are set, they are used without contacting the frontend, for installations that do not serve the
+// frontend configuration. Otherwise, they are discovered from the frontend at feURL.
+func (app *App) resolveServiceURLs(ctx context.Context, cfg *Config, feURL string) (*endpoints.FrontendConfig, error) {
+	if cfg.BuildURL == "" && cfg.LibraryURL == "" {
+		feCfg, err := app.getFrontendConfig(ctx, cfg, feURL)
+		if err != nil {
+			return nil, tlsdebug.Wrap(err, roleFrontend, feURL)
+		}
+		return feCfg, nil
+	}
+
+	if cfg.BuildURL == "" || cfg.LibraryURL == "" {
+		return nil, fmt.Errorf("%w: specify both, or neither to discover them from %v", errIncompleteServiceURLs, feURL)
+	}
+
+	return &endpoints.FrontendConfig{
+		LibraryAPI: endpoints.URI{URI: cfg.LibraryURL},
+		BuildAPI:   endpoints.URI{URI: cfg.BuildURL},
+	}, nil
+}
+
+// failureCacheName is the name of the file in Config.CacheDir in which failures of frontend
+// discovery are cached.
+const failureCacheName = "frontend-failures.json"
+
+// getFrontendConfig retrieves the frontend configuration from feURL using app.httpClient.
+//
+// If cfg.CacheDir is set, the configuration is cached there, and unless cfg.NoCache is set, cached
+// configuration is returned without contacting feURL. Likewise, a recent hard failure to retrieve
+// the configuration is returned without contacting feURL.
+func (app *App) getFrontendConfig(ctx context.Context, cfg *Config, feURL string) (*endpoints.FrontendConfig, error) {
+	if cfg.CacheDir == "" {
+		return endpoints.GetFrontendConfig(ctx, app.httpClient, feURL)
+	}
+
+	ttl := cfg.FrontendCacheTTL
+	if ttl == 0 {
+		ttl = endpoints.DefaultConfigTTL
+	}
+
+	cc := endpoints.NewConfigCache(cfg.CacheDir, ttl)
+	fc := endpoints.NewFailureCache(filepath.Join(cfg.CacheDir, failureCacheName), endpoints.DefaultFailureTTL)
+	tlsConfig := tlsConfigDigest(cfg)
+
+	if !cfg.NoCache {
+		if feCfg, ok := cc.Get(feURL, cfg.SkipTLSVerify); ok {
+			app.frontendConfigCache = cc
+			return feCfg, nil
+		}
+
+		if err := fc.Check(feURL, tlsConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	feCfg, err := endpoints.GetFrontendConfig(ctx, app.httpClient, feURL)
+
+	if rerr := fc.Record(feURL, tlsConfig, err); rerr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to update frontend failure cache: %v\n", rerr)
+	}
+
+	if err == nil {
+		if perr := cc.Put(feURL, cfg.SkipTLSVerify, feCfg); perr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to update frontend configuration cache: %v\n", perr)
+		}
+	}
+
+	return feCfg, err
+}
+
+// checkFrontendConfig discards the cached frontend configuration, if err indicates the Build
+// Service was not found at the configured URL. See discardFrontendConfig.
+func (app *App) checkFrontendConfig(err error) {
+	if errors.Is(err, build.ErrNotFound) {
+		app.discardFrontendConfig()
+	}
+}
+
+// discardFrontendConfig discards the cached frontend configuration, if it was used, so that the
+// next run rediscovers the Build and Library Service URLs. It is called when an endpoint that
+// every Build Service supports is not found, which suggests the cached configuration is out of
+// date.
+func (app *App) discardFrontendConfig() {
+	if app.frontendConfigCache == nil {
+		return
+	}
+
+	if err := app.frontendConfigCache.Invalidate(app.frontendURL); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to update frontend configuration cache: %v\n", err)
+		return
+	}
+	app.frontendConfigCache = nil
+
+	fmt.Fprintf(os.Stderr, "Discarded cached configuration of %v, which may be out of date. Please retry.\n", app.frontendURL)
+}
+
+// contextArchiveOpts returns the options used to generate the build context archive. The archive
+// is reproducible, so that unchanged build contexts need not be re-uploaded.
+func (app *App) contextArchiveOpts() []build.UploadBuildContextOption {
+	opts := []build.UploadBuildContextOption{build.OptUploadReproducible(true)}
+	if app.contextCompression != "" {
+		opts = append(opts, build.OptUploadCompression(app.contextCompression))
+	}
+	return opts
+}
+
+// uploadBuildContext uploads a build context containing the specified sources to build server.
+// Before doing so, it verifies that all sources are present, so that missing files are reported
+// in terms of the definition.
 //
 // Returns sha256 digest of uploaded build context if build context was uploaded successfully,
 // otherwise returns errNoBuildContextFiles indicating no build context was uploaded/required.
-func (app *App) uploadBuildContext(ctx context.Context, rawDef []byte) (string, error) {
-	// Get list of files from def file '%files' section(s)
-	files, err := app.getFiles(ctx, bytes.NewReader(rawDef))
+func (app *App) uploadBuildContext(ctx context.Context, sources []FileTransport) (string, error) {
+	files, err := checkSources(os.DirFS("/"), sources, app.allowEmptyGlobs)
 	if err != nil {
-		return "", fmt.Errorf("error getting build context files: %w", err)
+		return "", err
 	}
 	if files == nil {
 		return "", errNoBuildContextFiles
 	}
 
-	// Upload build context containing files referenced in def file to build server
-	digest, err := app.buildClient.UploadBuildContext(ctx, files)
+	// Prevent a concurrent run with the same build context deleting it while it is uploaded.
+	unlockContext, err := app.lockContext(ctx, files)
 	if err != nil {
 		return "", err
 	}
+	defer unlockContext()
+
+	// Upload build context containing files referenced in def file to build server.
+	opts := app.contextArchiveOpts()
+
+	// Where supported, stream the archive directly to the server, rather than assembling it in a
+	// temporary file to compute its digest before uploading.
+	if app.serverSupports(ctx, capStreamedContextUpload) {
+		opts = append(opts, build.OptUploadStreaming(true))
+	}
+
+	opts = append(opts, build.OptUploadCounter(&app.contextBytes))
+
+	digest, err := app.buildClient.UploadBuildContext(ctx, files, opts...)
+	if err != nil {
+		app.checkFrontendConfig(err)
+		return "", app.wrapBuildErr(err)
+	}
 	return digest, nil
 }
 
-func appendFileSuffix(name, suffix string, appendSuffix bool) string {
-	if !appendSuffix {
-		return name
+var errDefinitionChanged = errors.New("build definition changed since previous run")
+
+// resume reads the metadata recorded by a previous run from app.resumeFile, and returns the
+// architectures that must be (re)built. Architectures whose recorded build succeeded are skipped,
+// provided the destination is unchanged and, for local files, the file still matches the recorded
+// image checksum.
+//
+// If app.resumeFile does not exist, all architectures are built, and the outcome is recorded in
+// app.resumeFile once the run completes.
+//
+// The build context recorded by the previous run will usually have been deleted when that run
+// completed, so it is uploaded again. If it is still present on the Build Service, only its digest
+// is exchanged.
+func (app *App) resume() ([]string, error) {
+	md, err := readMetadata(app.resumeFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return app.archsToBuild, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading metadata: %w", err)
+	}
+
+	if md.DefinitionDigest != app.metadata.DefinitionDigest {
+		if !app.forceResume {
+			return nil, fmt.Errorf("%w (recorded %v, got %v)", errDefinitionChanged, md.DefinitionDigest, app.metadata.DefinitionDigest)
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %v, resuming anyway\n", errDefinitionChanged)
+	}
+
+	var archs []string
+
+	for _, arch := range app.archsToBuild {
+		am := md.arch(arch)
+		if am == nil || !am.Succeeded {
+			archs = append(archs, arch)
+			continue
+		}
+
+		if reason := app.resumeCheck(am); reason != "" {
+			fmt.Fprintf(app.stdout, "Rebuilding for %v: %v\n", arch, reason)
+			archs = append(archs, arch)
+			continue
+		}
+
+		fmt.Fprintf(app.stdout, "Skipping %v: previous build succeeded\n", arch)
+		app.metadata.setArch(*am)
+	}
+
+	return archs, nil
+}
+
+// resumeCheck determines whether the successful build recorded in am can be reused. If it cannot,
+// the reason is returned.
+func (app *App) resumeCheck(am *ArchMetadata) string {
+	if dst := app.dstFileNameForArch(am.Arch); am.FileName != dst {
+		return "destination changed"
 	}
-	return fmt.Sprintf("%v-%v", name, suffix)
+
+	if app.libraryRef != nil && am.LibraryRef != app.libraryRef.String() {
+		return "library ref changed"
+	}
+
+	if am.FileName == "" {
+		return ""
+	}
+
+	// Metadata recorded before signed images were distinguished holds only the checksum reported
+	// by the Build Service.
+	checksum := am.FileChecksum
+	if checksum == "" {
+		checksum = am.ImageChecksum
+	}
+
+	if err := verifyFileChecksum(am.FileName, checksum); err != nil {
+		return fmt.Sprintf("unable to verify %v: %v", am.FileName, err)
+	}
+
+	return ""
 }
 
 // Run is the main application entrypoint
+//
+// If ctx is cancelled, running builds are cancelled, any image being downloaded is discarded, and
+// build contexts uploaded by the run are deleted. The error returned then wraps errInterrupted, so
+// that ExitCode returns ExitInterrupted. To end the run gracefully instead, use Stop.
 func (app *App) Run(ctx context.Context) error {
-	if !app.force && app.dstFileName != "" {
+	var err error
+	if app.batch != nil {
+		err = interruptedError(ctx, app.translateTLSErr(app.runBatch(ctx)))
+	} else {
+		err = interruptedError(ctx, app.translateTLSErr(app.translateAuthErr(app.run(ctx))))
+		app.writeAnnotations(err)
+	}
+
+	app.writeSupportBundle(ctx, err)
+
+	if app.verbose {
+		fmt.Fprintf(os.Stderr, "Definitions parsed by Build Service: %v (%v reused from cache)\n", app.defParses, app.defParseHits)
+	}
+	return err
+}
+
+// run performs the build, as described by Run.
+func (app *App) run(ctx context.Context) error {
+	startedOn := time.Now()
+
+	app.reportNotices(ctx)
+
+	// Fetch the build context first, since it may contain the definition.
+	cleanupContext, err := app.fetchContext(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching build context: %w", err)
+	}
+	defer cleanupContext()
+
+	// The definition is read once, since it may be read from a stream, and is used both to
+	// determine the build context and to submit the build.
+	defPath := app.definitionPath(app.buildSpec)
+
+	buildDef, err := getBuildDef(defPath, app.stdin)
+	if err != nil {
+		return fmt.Errorf("unable to get build definition: %w", err)
+	}
+	if app.bundle != nil {
+		name := ""
+		if app.batch != nil {
+			name = app.batch.name
+		}
+		app.bundle.addRun(name, defPath, buildDef)
+	}
+
+	// The preprocessor is run on the definition as written, before build arguments are
+	// substituted, and the digests of both its input and output recorded.
+	var sourceDigest string
+	if app.defPreprocess != "" {
+		sourceDigest = definitionDigest(buildDef)
+
+		if buildDef, err = app.preprocessDefinition(ctx, buildDef, defPath); err != nil {
+			return err
+		}
+	}
+
+	// Build arguments are substituted before the definition is used for anything else, so that
+	// they may be used in '%files' sources, and are reflected in the definition digest.
+	if app.buildArgs != nil {
+		if buildDef, err = substituteBuildArgs(buildDef, app.buildArgs, app.allowMissingArgs); err != nil {
+			return err
+		}
+	}
+
+	app.metadata = &Metadata{
+		DefinitionDigest:       definitionDigest(buildDef),
+		SourceDefinitionDigest: sourceDigest,
+		ContextCommit:          app.contextCommit,
+		Notices:                app.notices,
+	}
+	if app.gitContext != nil {
+		app.metadata.ContextSource = app.gitContext.String()
+	}
+	app.bundle.setMetadata(app.metadata)
+
+	archs := app.archsToBuild
+
+	// Prevent concurrent runs clobbering the metadata file and destination. A dry run writes
+	// neither.
+	if !app.dryRun {
+		unlock, err := app.lockOutputs(ctx)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	if app.resumeFile != "" {
+		if archs, err = app.resume(); err != nil {
+			return err
+		}
+		if len(archs) == 0 {
+			fmt.Fprintf(app.stdout, "All architectures recorded in %v built successfully, nothing to do\n", app.resumeFile)
+			return nil
+		}
+	}
+
+	if err := app.checkDestinationCollisions(); err != nil {
+		return err
+	}
+
+	// A dry run writes no destination, so neither an existing destination nor its overwrite prompt
+	// is of concern.
+	if !app.force && !app.dryRun && app.dstFileName != "" && app.dstFileName != stdoutDestination {
 		// Check for existence of dst files
-		for _, arch := range app.archsToBuild {
-			fn := appendFileSuffix(app.dstFileName, arch, len(app.archsToBuild) > 1)
+		for _, arch := range archs {
+			fn := app.dstFileNameForArch(arch)
 
 			if _, err := os.Stat(fn); !os.IsNotExist(err) {
+				if app.prompter.confirm(ctx, fmt.Sprintf("Destination file %q already exists. Overwrite?", fn)) {
+					continue
+				}
 				return fmt.Errorf("destination file %q already exists", fn)
 			}
 		}
 	}
 
-	var err error
-	buildDef, err := getBuildDef(app.buildSpec)
+	// Get list of files from def file '%files' section(s)
+	d, sources, err := app.getSources(ctx, buildDef)
 	if err != nil {
-		return fmt.Errorf("unable to get build definition: %w", err)
+		return fmt.Errorf("error getting build context files: %w", err)
 	}
+	app.bundle.setSources(sources)
 
-	// Upload build context, as necessary
-	buildContext, err := app.uploadBuildContext(ctx, buildDef)
-	if err != nil && !errors.Is(err, errNoBuildContextFiles) {
-		return fmt.Errorf("error uploading build context: %w", err)
+	// Check the Build Service supports the capabilities this invocation relies on.
+	zstdContext := sources != nil && app.contextDigest == "" && app.contextCompression == build.CompressionZstd
+	caps := requiredCapabilities(sources != nil || app.contextDigest != "", len(app.requirements) > 0, zstdContext)
+	if err := app.checkServerCompatibility(ctx, caps); err != nil {
+		return err
 	}
 
-	defer func() {
+	if app.dryRun {
+		return app.reportDryRun(sources, archs)
+	}
+
+	// A previously uploaded build context is used as is, and is neither uploaded nor deleted.
+	buildContext := app.contextDigest
+
+	if buildContext == "" && app.batch != nil {
+		buildContext, _ = app.batchContext(sources)
+	}
+
+	// Where the Build Service does not support build contexts, the build proceeds without one. This
+	// is only reached if incompatibilities are ignored. See checkServerCompatibility.
+	if buildContext == "" && sources != nil && app.featureUnsupported(ctx, build.FeatureBuildContext) {
+		fmt.Fprintf(os.Stderr, "Warning: Build Service does not support build contexts, building without one\n")
+		sources = nil
+	}
+
+	if buildContext == "" {
+		// Upload build context, as necessary
+		buildContext, err = app.uploadBuildContext(ctx, sources)
+		if err != nil && !errors.Is(err, errNoBuildContextFiles) {
+			return fmt.Errorf("error uploading build context: %w", err)
+		}
+
 		if buildContext != "" {
-			_ = app.buildClient.DeleteBuildContext(ctx, buildContext)
+			if app.keepContext {
+				fmt.Fprintf(app.stdout, "Build context %v kept for reuse\n", buildContext)
+			}
+
+			if app.batch != nil {
+				// Deleted once every definition in the batch is built. See runBatch.
+				app.keepBatchContext(sources, buildContext)
+			} else if !app.keepContext {
+				defer app.deleteBuildContext(ctx, buildContext)
+			}
 		}
-	}()
+	}
+	app.metadata.ContextDigest = buildContext
 
-	if len(app.archsToBuild) > 1 {
-		fmt.Printf("Performing builds for following architectures: %v\n", strings.Join(app.archsToBuild, " "))
+	if len(archs) > 1 {
+		fmt.Fprintf(app.stdout, "Performing builds for following architectures: %v\n", strings.Join(archs, " "))
 	}
 
-	return app.build(ctx, buildDef, buildContext, app.archsToBuild)
+	err = app.build(ctx, buildDef, buildContext, archs)
+
+	app.reportTransfers()
+
+	if err == nil && app.provenanceFile != "" {
+		if err := app.writeProvenance(ctx, d, startedOn); err != nil {
+			return fmt.Errorf("error writing provenance: %w", err)
+		}
+	}
+
+	if app.resumeFile != "" {
+		if werr := writeMetadata(app.resumeFile, app.metadata); werr != nil {
+			fmt.Fprintf(os.Stderr, "Error writing metadata file %v: %v\n", app.resumeFile, werr)
+		}
+	}
+
+	return err
 }
 
 func (app *App) build(ctx context.Context, Def []byte, Context string, Archs []string) error {
 	errs := make(map[string]error)
 
-	signed := app.signerOpts != nil
+	// Plan how each image reaches its destinations before any build is submitted.
+	plans := make(map[string]transferPlan, len(Archs))
+	for _, arch := range Archs {
+		plan, err := app.planArch(arch)
+		if err != nil {
+			return err
+		}
+		plans[arch] = plan
+
+		if app.verbose {
+			fmt.Fprintf(os.Stderr, "Transfer plan for %v: %v\n", arch, plan)
+		}
+	}
 
 	for _, arch := range Archs {
-		fmt.Printf("Building for %v...\n", arch)
+		if err := ctx.Err(); err != nil {
+			errs[arch] = err
+			continue
+		}
+
+		if app.stopped.Load() {
+			fmt.Fprintf(app.stdout, "Skipping %v: stop requested\n", arch)
+			app.recordSkipped(arch)
+			continue
+		}
 
-		dstFileName := appendFileSuffix(app.dstFileName, arch, len(Archs) > 1)
+		fmt.Fprintf(app.stdout, "Building for %v...\n", arch)
+
+		plan := plans[arch]
 
 		var libraryRef string
 		if app.libraryRef != nil {
 			libraryRef = app.libraryRef.String()
 		}
 
-		bi, err := app.buildArch(ctx, arch, Def, Context, libraryRef, dstFileName)
+		bi, err := app.buildArch(ctx, arch, Def, Context, plan)
+		app.recordArch(arch, bi, libraryRef, plan.dstFile, err)
 		if err != nil {
 			errs[arch] = err
 			continue
 		}
 
-		if !signed && dstFileName == "" {
-			// Library ref specified; image pushed to library automatically
+		// The image has been verified, where downloaded, so its metadata may be relied upon.
+		if app.metadataFile != "" {
+			if err := app.writeBuildMetadata(app.buildMetadata(arch, bi, libraryRef, plan.dstFile)); err != nil {
+				errs[arch] = fmt.Errorf("error writing build metadata: %w", err)
+				continue
+			}
+		}
+
+		if plan.dstFile == "" {
+			// Without a library ref, image pushed to a temporary library location
 			if app.libraryRef == nil {
-				fmt.Printf("Build artifact %v is available for 24 hours or less\n", bi.LibraryRef())
+				fmt.Fprintf(app.stdout, "Build artifact %v is available for 24 hours or less\n", bi.LibraryRef())
+				fmt.Fprintf(app.stdout, "Retrieve it with: %v\n", pullCommand(bi.LibraryURL(), bi.LibraryRef()))
 			}
 			continue
 		}
 
-		if signed && dstFileName == "" {
-			// Do not display image stats
+		if plan.dstFile == stdoutDestination {
+			fmt.Fprintf(app.stdout, "Wrote %d bytes to standard output\n", bi.ImageSize())
 			continue
 		}
 
 		// Display file stats for locally downloaded image
-		fi, err := os.Lstat(dstFileName)
+		fi, err := os.Lstat(plan.dstFile)
 		if err != nil {
-			return fmt.Errorf("error opening file %v for reading: %w", dstFileName, err)
+			return fmt.Errorf("error opening file %v for reading: %w", plan.dstFile, err)
 		}
-		fmt.Fprintf(os.Stderr, "Wrote %v (%d bytes)\n", dstFileName, fi.Size())
+		fmt.Fprintf(os.Stderr, "Wrote %v (%d bytes)\n", plan.dstFile, fi.Size())
 	}
 
 	return app.reportErrs(errs)
 }
 
-func (app *App) directLibraryUpload(filename string) bool {
-	return app.libraryRef != nil || filename == ""
-}
+// dstFileNameForArch returns the local destination file name for arch, or an empty string if the
+// artifact is not to be written locally.
+func (app *App) dstFileNameForArch(arch string) string {
+	if app.dstFileName == "" || len(app.archsToBuild) <= 1 {
+		return app.dstFileName
+	}
 
-func (app *App) buildArch(ctx context.Context, arch string, def []byte, buildContext string, libraryRef string, dstFileName string) (*build.BuildInfo, error) {
-	signed := app.signerOpts != nil
+	// The template is checked by New, so cannot fail here.
+	name, _ := archFileName(app.outputTemplate, app.dstFileName, arch, app.outputTag)
+	return name
+}
 
-	var tmpFileName string
-	var tmpLibraryRef string
+// recordArch records the outcome of the build for arch in the run metadata.
+func (app *App) recordArch(arch string, bi *build.BuildInfo, libraryRef, dstFileName string, err error) {
+	if app.metadata == nil {
+		return
+	}
 
-	if !signed {
-		if libraryRef != "" && dstFileName == "" {
-			tmpLibraryRef = libraryRef
-		} else if libraryRef == "" && dstFileName != "" {
-			tmpFileName = dstFileName
+	am := ArchMetadata{
+		Arch:       arch,
+		Succeeded:  err == nil,
+		LibraryRef: libraryRef,
+		FileName:   dstFileName,
+	}
+	if bi != nil {
+		am.BuildID = bi.ID()
+		am.ImageChecksum = bi.ImageChecksum()
+		am.ImageSize = bi.ImageSize()
+		am.DownloadChecksum = app.downloadChecksums[arch]
+		am.FileChecksum = app.deliveredChecksum(arch, bi)
+		if am.LibraryRef == "" {
+			am.LibraryRef = bi.LibraryRef()
+			am.LibraryURL = bi.LibraryURL()
 		}
 	}
+	if err != nil {
+		am.Error = err.Error()
+		am.FailurePhase = failurePhase(err)
+	}
+	am.DefinitionDigest = app.builtDefinitions[arch]
+	am.OutputTruncated = app.outputTruncated[arch]
+	am.BytesUploaded = app.bytesUploaded[arch]
+	am.BytesDownloaded = app.bytesDownloaded[arch]
+
+	var bfe *BuildFailureError
+	if errors.As(err, &bfe) {
+		am.OutputTail = bfe.OutputTail
+	}
 
+	app.metadata.setArch(am)
+}
+
+// recordSkipped records in the run metadata that arch was skipped, since a stop was requested. As
+// the build did not succeed, it is performed when the run is resumed.
+func (app *App) recordSkipped(arch string) {
+	if app.metadata == nil {
+		return
+	}
+
+	app.metadata.setArch(ArchMetadata{Arch: arch, Skipped: true})
+}
+
+// libraryURI returns ref, which may omit the library scheme, as a library URI.
+func libraryURI(ref string) string {
+	if strings.HasPrefix(ref, library.Scheme+":") {
+		return ref
+	}
+	return library.Scheme + "://" + strings.TrimPrefix(ref, "/")
+}
+
+// pullCommand returns a command that retrieves the artifact at ref from the library at libraryURL.
+// Refs returned by the Build Service omit the library host, so libraryURL is specified explicitly.
+func pullCommand(libraryURL, ref string) string {
+	ref = libraryURI(ref)
+
+	if libraryURL == "" {
+		return fmt.Sprintf("singularity pull %v", ref)
+	}
+	return fmt.Sprintf("singularity pull --library %v %v", libraryURL, ref)
+}
+
+// buildArch builds the image for arch, and delivers it to its destinations as described by plan.
+func (app *App) buildArch(ctx context.Context, arch string, def []byte, buildContext string, plan transferPlan) (*build.BuildInfo, error) {
 	// Submit build request
-	bi, err := app.buildArtifact(ctx, arch, def, buildContext, tmpLibraryRef)
+	bi, err := app.buildArtifact(ctx, arch, def, buildContext, plan.serverRef)
 	if err != nil {
 		return nil, err
 	}
 
 	// Build completed successfully
-	if !signed {
-		if tmpFileName == "" {
-			// Build image uploaded directly to library
-			return bi, nil
-		}
+	if !plan.download {
+		// Build image uploaded directly to library
+		return bi, nil
+	}
 
-		// Build image will be written directly to 'tmpFileName'
-	} else {
-		if dstFileName != "" || libraryRef != "" {
-			// Create (local) temporary file for images being pushed directly to library
-			f, err := os.CreateTemp("", "scs-build-")
-			if err != nil {
-				return nil, err
-			}
-			f.Close()
-			tmpFileName = f.Name()
+	fileName := plan.dstFile
+
+	if plan.tempFile {
+		// Create (local) temporary file for images signed or pushed to library by the client. Where
+		// the image is also written locally, the file is created alongside the destination, so that
+		// it can be renamed into place.
+		f, err := app.createImageTemp(plan.dstFile)
+		if err != nil {
+			return nil, err
 		}
+		f.Close()
+		fileName = f.Name()
+
+		// Once renamed into place, there is nothing to remove.
+		defer os.Remove(fileName)
 	}
 
 	// Download file locally
-	if err := app.retrieveArtifact(ctx, bi, tmpFileName, arch); err != nil {
-		return nil, fmt.Errorf("error retrieving build artifact: %w", err)
+	if err := app.retrieveArtifact(ctx, bi, fileName, arch); err != nil {
+		return nil, fmt.Errorf("%w: %w", errRetrieveArtifact, err)
 	}
 
-	if signed {
+	if plan.sign {
 		// Sign local file
-		if err := app.sign(ctx, tmpFileName); err != nil {
-			return nil, err
+		if err := app.sign(ctx, fileName); err != nil {
+			return nil, fmt.Errorf("%w: %w", errSigning, err)
 		}
 
-		if app.directLibraryUpload(dstFileName) {
-			// Upload temporary (local) image file to library
-			if err := app.uploadImage(ctx, tmpFileName, arch); err != nil {
-				return nil, err
-			}
-		} else {
-			// Rename temporary local file to specified destination
-			if err := os.Rename(tmpFileName, dstFileName); err != nil {
-				return nil, fmt.Errorf("file rename error: %w", err)
+		// Signing changes the image, so the checksum reported by the Build Service no longer
+		// describes it.
+		sum, err := fileChecksum(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errSigning, err)
+		}
+		app.signedChecksums[arch] = sum
+
+		// Confirm the signature before the image is published, rather than at pull time.
+		if app.verifierOpts != nil {
+			if err := verify(fileName, app.verifierOpts...); err != nil {
+				return nil, fmt.Errorf("%w: %w", errSignatureVerification, err)
 			}
 		}
+
+		if err := app.publishSignatures(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if plan.uploadRef != "" {
+		// Upload temporary (local) image file to library
+		if err := app.uploadImage(ctx, fileName, arch); err != nil {
+			return nil, err
+		}
+	}
+
+	if plan.tempFile && plan.dstFile != "" {
+		// Rename temporary local file to specified destination. The image is modified in place when
+		// signed, so is synced again.
+		if err := app.commitImage(fileName, plan.dstFile); err != nil {
+			return nil, err
+		}
 	}
 
 	return bi, nil
 }
 
+// createImageTemp creates a temporary file to which an image is downloaded, prior to being signed
+// or pushed to the library. If dst is set, the file is created alongside dst with mode
+// app.outputMode, so that it can be renamed into place by commitImage.
+func (app *App) createImageTemp(dst string) (*os.File, error) {
+	if dst == "" {
+		return os.CreateTemp("", "scs-build-")
+	}
+
+	f, err := createArtifactTemp(dst, app.outputMode)
+	if err != nil {
+		return nil, fmt.Errorf("error creating file for %v: %w", dst, err)
+	}
+	return f, nil
+}
+
+// commitImage renames the image at fileName, created by createImageTemp, into place at dst. See
+// commitArtifact.
+func (app *App) commitImage(fileName, dst string) error {
+	f, err := os.OpenFile(fileName, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	return app.commitArtifact(f, dst, fi.Size())
+}
+
 func (app *App) sign(_ context.Context, fileName string) error {
-	fmt.Printf("Signing...\n")
+	fmt.Fprintf(app.stdout, "Signing...\n")
 
 	return sign(fileName, app.signerOpts...)
 }
@@ -357,12 +1372,33 @@ func (app *App) uploadImage(ctx context.Context, tmpFileName, arch string) error
 		_ = fp.Close()
 	}()
 
-	if _, err := app.libraryClient.UploadImage(ctx, fp, app.libraryRef.Path, arch, app.libraryRef.Tags, "", nil); err != nil {
-		return fmt.Errorf("error uploading image %v to %v: %w", tmpFileName, app.libraryRef.String(), err)
+	fi, err := fp.Stat()
+	if err != nil {
+		return fmt.Errorf("uploading file: %w", err)
 	}
 
-	// Remove temporary file
-	_ = os.Remove(tmpFileName)
+	// The image is read once to compute its checksums, and again as it is uploaded.
+	tctx, m, done := app.libraryTransfer(ctx, "upload", 2*fi.Size())
+	m.setTotal(2 * fi.Size())
+	r := newStallReadSeeker(fp, m)
+
+	if err := done(app.withLibraryAuth(tctx, func() error {
+		// Rewind, in case a previous attempt was rejected part way through.
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		// Of the bytes read by the attempt, those beyond the checksum pass are uploaded.
+		before := m.snapshot().Transferred
+		defer func() {
+			app.bytesUploaded[arch] += max(m.snapshot().Transferred-before-fi.Size(), 0)
+		}()
+
+		_, err := app.libraryClient.UploadImage(tctx, r, app.libraryRef.Path, arch, app.libraryRef.Tags, "", nil)
+		return err
+	})); err != nil {
+		return fmt.Errorf("error uploading image %v to %v: %w", tmpFileName, app.libraryRef.String(), app.wrapLibraryErr(err))
+	}
 
 	return nil
 }
@@ -378,6 +1414,7 @@ func (app *App) reportErrs(errs map[string]error) error {
 	if len(errs) == 1 {
 		// Return first (and only) error
 		for _, err := range errs {
+			reportOutputTail(err)
 			return err
 		}
 	}
@@ -386,9 +1423,37 @@ func (app *App) reportErrs(errs map[string]error) error {
 
 	for arch, err := range errs {
 		fmt.Fprintf(os.Stderr, "  - %v: %v\n", arch, err)
+		reportOutputTail(err)
 	}
 
 	fmt.Fprintln(os.Stderr)
 
-	return errors.New("failed to build images")
+	all := make([]error, 0, len(errs))
+	for _, err := range errs {
+		all = append(all, err)
+	}
+	return &multiArchError{errs: all}
+}
+
+// multiArchError is returned when builds for more than one architecture fail. The errors of each
+// are wrapped, so that they may be matched by errors.Is and errors.As.
+type multiArchError struct {
+	errs []error
+}
+
+func (e *multiArchError) Error() string { return "failed to build images" }
+
+func (e *multiArchError) Unwrap() []error { return e.errs }
+
+// reportOutputTail outputs the final lines of build output to console, if err is a
+// BuildFailureError.
+func reportOutputTail(err error) {
+	var bfe *BuildFailureError
+	if !errors.As(err, &bfe) || bfe.OutputTail == "" {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "\n--- last %v lines of build output ---\n", outputTailLines)
+	fmt.Fprintln(os.Stderr, lastLines(bfe.OutputTail, outputTailLines))
+	fmt.Fprintln(os.Stderr, "---")
 }
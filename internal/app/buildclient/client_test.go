@@ -8,16 +8,24 @@ package buildclient
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
+	"sync/atomic"
 	"testing"
 
 	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 	jsonresp "github.com/sylabs/json-resp"
+	build "github.com/sylabs/scs-build-client/client"
 	"github.com/sylabs/scs-build-client/internal/pkg/endpoints"
+	library "github.com/sylabs/scs-library-client/client"
 )
 
 const (
@@ -172,6 +180,318 @@ func TestGetFrontendURL(t *testing.T) {
 	}
 }
 
+func TestNewWithClients(t *testing.T) {
+	bc, err := build.NewClient(build.OptBaseURL(testBuildURI))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lc, err := library.NewClient(&library.Config{BaseURL: testLibraryURI})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr error
+	}{
+		{"BothClients", Config{BuildClient: bc, LibraryClient: lc}, nil},
+		{"BothClientsLibraryRefWithHost", Config{BuildClient: bc, LibraryClient: lc, LibraryRef: "library://host/entity/collection/container:tag"}, nil},
+		{"SIFBuildSpecLibraryRef", Config{BuildClient: bc, LibraryClient: lc, BuildSpec: "existing.sif", LibraryRef: "library://entity/collection/rebuilt:tag"}, nil},
+		{"BuildClientOnly", Config{BuildClient: bc}, errConflictingClientConfig},
+		{"LibraryClientOnly", Config{LibraryClient: lc}, errConflictingClientConfig},
+		{"URL", Config{BuildClient: bc, LibraryClient: lc, URL: "https://cloud.sylabs.io"}, errConflictingClientConfig},
+		{"AuthToken", Config{BuildClient: bc, LibraryClient: lc, AuthToken: "token"}, errConflictingClientConfig},
+		{"SkipTLSVerify", Config{BuildClient: bc, LibraryClient: lc, SkipTLSVerify: true}, errConflictingClientConfig},
+		{"CACertFile", Config{BuildClient: bc, LibraryClient: lc, CACertFile: "ca.pem"}, errConflictingClientConfig},
+		{"ClientCertFile", Config{BuildClient: bc, LibraryClient: lc, ClientCertFile: "client.pem", ClientKeyFile: "client.key"}, errConflictingClientConfig},
+		{"HTTPHeaders", Config{BuildClient: bc, LibraryClient: lc, HTTPHeaders: http.Header{"X-Org-Id": {"org"}}}, errConflictingClientConfig},
+		{"TraceContextFunc", Config{BuildClient: bc, LibraryClient: lc, TraceContextFunc: func(context.Context, http.Header) {}}, errConflictingClientConfig},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, err := New(context.Background(), &tt.cfg)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if err == nil {
+				assert.Same(t, bc, app.buildClient)
+				assert.Same(t, lc, app.libraryClient)
+				assert.Equal(t, testBuildURI+"/", app.buildURL)
+			}
+		})
+	}
+}
+
+func TestNewFailureCache(t *testing.T) {
+	var requests atomic.Int64
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+
+		if err := json.NewEncoder(w).Encode(&endpoints.FrontendConfig{
+			LibraryAPI: endpoints.URI{URI: testLibraryURI},
+			BuildAPI:   endpoints.URI{URI: testBuildURI},
+		}); err != nil {
+			t.Errorf("json encoding error: %v", err)
+		}
+	}))
+	defer ts.Close()
+
+	cacheDir := t.TempDir()
+
+	// The server certificate is not trusted, so discovery fails, and the failure is cached.
+	_, err := New(context.Background(), &Config{URL: ts.URL, CacheDir: cacheDir})
+
+	var cfe *endpoints.CachedFailureError
+	if err == nil || errors.As(err, &cfe) {
+		t.Fatalf("got error %v, want certificate error", err)
+	}
+
+	// Repeat fails with the cached error.
+	_, err = New(context.Background(), &Config{URL: ts.URL, CacheDir: cacheDir})
+	if !errors.As(err, &cfe) {
+		t.Fatalf("got error %v, want cached failure", err)
+	}
+	if got, want := cfe.Seen, 2; got != want {
+		t.Errorf("got %v occurrences, want %v", got, want)
+	}
+
+	// Cached failure is overridden.
+	_, err = New(context.Background(), &Config{URL: ts.URL, CacheDir: cacheDir, NoCache: true})
+	if err == nil || errors.As(err, &cfe) {
+		t.Fatalf("got error %v, want certificate error", err)
+	}
+
+	// Cached certificate errors do not apply when certificate verification is skipped. Success
+	// clears the cached failure.
+	if _, err := New(context.Background(), &Config{URL: ts.URL, CacheDir: cacheDir, SkipTLSVerify: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = New(context.Background(), &Config{URL: ts.URL, CacheDir: cacheDir})
+	if err == nil || errors.As(err, &cfe) {
+		t.Fatalf("got error %v, want certificate error", err)
+	}
+
+	if got, want := requests.Load(), int64(1); got != want {
+		t.Errorf("got %v requests, want %v", got, want)
+	}
+}
+
+func TestApp_RunServiceURLs(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         bool // If set, the frontend URL is specified.
+		buildURL    bool
+		libraryURL  bool
+		wantErr     error
+		wantConfigs int64
+	}{
+		{name: "Discovered", url: true, wantConfigs: 1},
+		{name: "Specified", url: true, buildURL: true, libraryURL: true},
+		{name: "SpecifiedDefaultFrontend", buildURL: true, libraryURL: true},
+		{name: "BuildURLOnly", url: true, buildURL: true, wantErr: errIncompleteServiceURLs},
+		{name: "LibraryURLOnly", url: true, libraryURL: true, wantErr: errIncompleteServiceURLs},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+
+			dir := t.TempDir()
+			def := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(def, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := &Config{
+				BuildSpec:    def,
+				LibraryRef:   filepath.Join(dir, "image.sif"),
+				ArchsToBuild: []string{"amd64"},
+				CacheDir:     t.TempDir(),
+			}
+			if tt.url {
+				cfg.URL = m.frontend.URL
+			}
+			if tt.buildURL {
+				cfg.BuildURL = m.build.URL
+			}
+			if tt.libraryURL {
+				cfg.LibraryURL = m.library.URL
+			}
+
+			app, err := New(context.Background(), cfg)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if err == nil {
+				if err := app.Run(context.Background()); err != nil {
+					t.Fatalf("run error: %v", err)
+				}
+
+				if got, want := m.submits.Load(), int64(1); got != want {
+					t.Errorf("got %v submits, want %v", got, want)
+				}
+			}
+
+			// When the service URLs are specified, the frontend is never contacted.
+			if got, want := m.configs.Load(), tt.wantConfigs; got != want {
+				t.Errorf("got %v configuration requests, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApp_RunHTTPHeaders(t *testing.T) {
+	m := newMockServers(t)
+
+	dir := t.TempDir()
+	def := filepath.Join(dir, "alpine.def")
+	if err := os.WriteFile(def, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m.files = []string{def}
+
+	app, err := New(context.Background(), &Config{
+		URL:          m.frontend.URL,
+		BuildSpec:    def,
+		LibraryRef:   filepath.Join(dir, "image.sif"),
+		ArchsToBuild: []string{"amd64"},
+		HTTPHeaders:  http.Header{"X-Org-Id": {"org"}},
+	})
+	if err != nil {
+		t.Fatalf("initialization error: %v", err)
+	}
+
+	if err := app.Run(context.Background()); err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+
+	// Headers are included in Build Service requests, including those made by the app itself, but
+	// not on the pre-signed build context upload.
+	for _, path := range []string{"/v1/convert-def-file", "/v1/build-context", "/v1/build", "/v1/build/" + mockBuildID} {
+		assert.Equal(t, []string{"org"}, m.orgIDs[path], path)
+	}
+	assert.NotContains(t, m.orgIDs, "/upload-here")
+}
+
+func TestApp_RunTraceContext(t *testing.T) {
+	const traceparent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+
+	m := newMockServers(t)
+
+	dir := t.TempDir()
+	def := filepath.Join(dir, "alpine.def")
+	if err := os.WriteFile(def, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m.files = []string{def}
+
+	app, err := New(context.Background(), &Config{
+		URL:          m.frontend.URL,
+		BuildSpec:    def,
+		LibraryRef:   filepath.Join(dir, "image.sif"),
+		ArchsToBuild: []string{"amd64"},
+		TraceContextFunc: func(_ context.Context, h http.Header) {
+			h.Set("Traceparent", traceparent)
+		},
+	})
+	if err != nil {
+		t.Fatalf("initialization error: %v", err)
+	}
+
+	if err := app.Run(context.Background()); err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+
+	// Trace context is included in frontend discovery and Build Service requests, including those
+	// made by the app itself.
+	for _, path := range []string{"/assets/config/config.prod.json", "/v1/convert-def-file", "/v1/build-context", "/v1/build", "/v1/build/" + mockBuildID} {
+		assert.Equal(t, traceparent, m.traceparents[path], path)
+	}
+}
+
+func TestApp_RunFrontendConfigCache(t *testing.T) {
+	m := newMockServers(t)
+
+	cacheDir := t.TempDir()
+
+	dir := t.TempDir()
+	def := filepath.Join(dir, "alpine.def")
+	if err := os.WriteFile(def, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(t *testing.T, noCache bool) error {
+		t.Helper()
+
+		app, err := New(context.Background(), &Config{
+			URL:          m.frontend.URL,
+			BuildSpec:    def,
+			LibraryRef:   filepath.Join(t.TempDir(), "image.sif"),
+			ArchsToBuild: []string{"amd64"},
+			CacheDir:     cacheDir,
+			NoCache:      noCache,
+		})
+		if err != nil {
+			t.Fatalf("initialization error: %v", err)
+		}
+		return app.Run(context.Background())
+	}
+
+	// Configuration is cached once discovered.
+	for i := 0; i < 2; i++ {
+		if err := run(t, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := m.configs.Load(), int64(1); got != want {
+		t.Errorf("got %v configuration requests, want %v", got, want)
+	}
+
+	// Cached configuration is bypassed.
+	if err := run(t, true); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.configs.Load(), int64(2); got != want {
+		t.Errorf("got %v configuration requests, want %v", got, want)
+	}
+
+	// Cached configuration that refers to a Build Service that is no longer present is discarded.
+	gone := httptest.NewServer(http.NotFoundHandler())
+	defer gone.Close()
+
+	cc := endpoints.NewConfigCache(cacheDir, endpoints.DefaultConfigTTL)
+	if err := cc.Put(m.frontend.URL, false, &endpoints.FrontendConfig{
+		LibraryAPI: endpoints.URI{URI: m.library.URL},
+		BuildAPI:   endpoints.URI{URI: gone.URL},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run(t, false); err == nil {
+		t.Fatal("unexpected success")
+	}
+	if _, ok := cc.Get(m.frontend.URL, false); ok {
+		t.Error("out of date configuration not discarded")
+	}
+
+	if err := run(t, false); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.configs.Load(), int64(3); got != want {
+		t.Errorf("got %v configuration requests, want %v", got, want)
+	}
+	if got, want := m.submits.Load(), int64(4); got != want {
+		t.Errorf("got %v submits, want %v", got, want)
+	}
+}
+
 var upgrader = websocket.Upgrader{} // use default options
 
 // Test_build is a rudimentary unit test for (*App).build() method
@@ -238,28 +558,25 @@ func Test_build(t *testing.T) {
 	buildSrv := httptest.NewServer(buildSrvMux)
 	defer buildSrv.Close()
 
-	frontendSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-
-		feConfig := endpoints.FrontendConfig{
-			LibraryAPI: endpoints.URI{URI: "http://cloud-library-server"},
-			BuildAPI:   endpoints.URI{URI: buildSrv.URL},
-		}
+	// Supply clients directly, so no frontend is required.
+	bc, err := build.NewClient(build.OptBaseURL(buildSrv.URL))
+	if err != nil {
+		t.Fatalf("build client initialization error: %v", err)
+	}
 
-		if err := json.NewEncoder(w).Encode(&feConfig); err != nil {
-			t.Fatalf("response encoding error: %v", err)
-		}
-	}))
-	defer frontendSrv.Close()
+	lc, err := library.NewClient(&library.Config{BaseURL: "http://cloud-library-server"})
+	if err != nil {
+		t.Fatalf("library client initialization error: %v", err)
+	}
 
 	app, err := New(context.Background(), &Config{
-		URL:          frontendSrv.URL,
-		ArchsToBuild: []string{runtime.GOARCH},
+		ArchsToBuild:  []string{runtime.GOARCH},
+		BuildClient:   bc,
+		LibraryClient: lc,
 	})
 	if err != nil {
 		t.Fatalf("initialization error: %v", err)
 	}
-	_ = app
 
 	const buildDef = "bootstrap: docker\nfrom: alpine:3\n"
 
@@ -267,3 +584,404 @@ func Test_build(t *testing.T) {
 		t.Fatalf("build error: %v", err)
 	}
 }
+
+func TestApp_RunStdin(t *testing.T) {
+	const buildDef = "bootstrap: docker\nfrom: alpine:3\n"
+
+	m := newMockServers(t)
+
+	app, err := New(context.Background(), &Config{
+		URL:          m.frontend.URL,
+		BuildSpec:    "-",
+		ArchsToBuild: []string{runtime.GOARCH},
+	})
+	if err != nil {
+		t.Fatalf("initialization error: %v", err)
+	}
+
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+
+	go func() {
+		defer pw.Close()
+
+		if _, err := io.WriteString(pw, buildDef); err != nil {
+			t.Errorf("failed to write definition: %v", err)
+		}
+	}()
+
+	app.stdin = pr
+
+	if err := app.Run(context.Background()); err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+
+	if got, want := len(m.convertedDefs), 1; got != want {
+		t.Fatalf("got %v definitions converted, want %v", got, want)
+	}
+	if got, want := string(m.convertedDefs[0]), buildDef; got != want {
+		t.Errorf("got converted definition %q, want %q", got, want)
+	}
+
+	if got, want := len(m.submittedDefs), 1; got != want {
+		t.Fatalf("got %v definitions submitted, want %v", got, want)
+	}
+	if got, want := string(m.submittedDefs[0]), buildDef; got != want {
+		t.Errorf("got submitted definition %q, want %q", got, want)
+	}
+}
+
+func TestApp_RunSIF(t *testing.T) {
+	tests := []struct {
+		name    string
+		sif     string
+		wantErr error
+		wantDef string
+	}{
+		{"Deffile", "deffile.sif", nil, sifDefinition},
+		{"NoDeffile", "no-deffile.sif", errNoSIFDefinition, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+
+			app, err := New(context.Background(), &Config{
+				URL:          m.frontend.URL,
+				BuildSpec:    filepath.Join("testdata", tt.sif),
+				LibraryRef:   filepath.Join(t.TempDir(), "rebuilt.sif"),
+				ArchsToBuild: []string{runtime.GOARCH},
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			if got, want := app.Run(context.Background()), tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if tt.wantErr != nil {
+				if got := m.submits.Load(); got != 0 {
+					t.Errorf("got %v submits, want 0", got)
+				}
+				return
+			}
+
+			if got, want := len(m.submittedDefs), 1; got != want {
+				t.Fatalf("got %v definitions submitted, want %v", got, want)
+			}
+			if got, want := string(m.submittedDefs[0]), tt.wantDef; got != want {
+				t.Errorf("got submitted definition %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestPullCommand(t *testing.T) {
+	tests := []struct {
+		name       string
+		libraryURL string
+		ref        string
+		want       string
+	}{
+		{"Hostless", "https://library.example", "entity/collection/container:tag", "singularity pull --library https://library.example library://entity/collection/container:tag"},
+		{"LeadingSlash", "https://library.example", "/entity/collection/container:tag", "singularity pull --library https://library.example library://entity/collection/container:tag"},
+		{"Scheme", "https://library.example", "library:entity/collection/container:tag", "singularity pull --library https://library.example library:entity/collection/container:tag"},
+		{"NoLibraryURL", "", "entity/collection/container:tag", "singularity pull library://entity/collection/container:tag"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, want := pullCommand(tt.libraryURL, tt.ref), tt.want; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestApp_RunEphemeral(t *testing.T) {
+	m := newMockServers(t)
+
+	dir := t.TempDir()
+
+	defFile := filepath.Join(dir, "alpine.def")
+	if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	resumeFile := filepath.Join(dir, "metadata.json")
+
+	app, err := New(context.Background(), &Config{
+		URL:          m.frontend.URL,
+		BuildSpec:    defFile,
+		ArchsToBuild: []string{"amd64"},
+		ResumeFile:   resumeFile,
+	})
+	if err != nil {
+		t.Fatalf("initialization error: %v", err)
+	}
+
+	if err := app.Run(context.Background()); err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+
+	md, err := readMetadata(resumeFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	am := md.arch("amd64")
+	if am == nil {
+		t.Fatal("arch not recorded in metadata")
+	}
+
+	if got, want := am.LibraryRef, mockLibraryRef; got != want {
+		t.Errorf("got library ref %v, want %v", got, want)
+	}
+
+	if got, want := am.LibraryURL, m.library.URL; got != want {
+		t.Errorf("got library URL %v, want %v", got, want)
+	}
+
+	want := fmt.Sprintf("singularity pull --library %v library://%v", m.library.URL, mockLibraryRef)
+	if got := pullCommand(am.LibraryURL, am.LibraryRef); got != want {
+		t.Errorf("got pull command %q, want %q", got, want)
+	}
+}
+
+func TestApp_RunTags(t *testing.T) {
+	tests := []struct {
+		name           string
+		libraryRef     string
+		tags           []string
+		wantErr        error
+		wantSubmitRef  string
+		wantPushedTags []string
+	}{
+		{
+			name:          "SingleTag",
+			libraryRef:    "library:entity/collection/container:1.2.3",
+			wantSubmitRef: "library:entity/collection/container:1.2.3",
+		},
+		{
+			name:           "MultipleTags",
+			libraryRef:     "library:entity/collection/container:1.2.3,latest",
+			wantPushedTags: []string{"1.2.3", "latest"},
+		},
+		{
+			name:           "TagFlag",
+			libraryRef:     "library:entity/collection/container:1.2.3",
+			tags:           []string{"latest"},
+			wantPushedTags: []string{"1.2.3", "latest"},
+		},
+		{
+			name:          "TagFlagOnly",
+			libraryRef:    "library:entity/collection/container",
+			tags:          []string{"latest"},
+			wantSubmitRef: "library:entity/collection/container:latest",
+		},
+		{
+			name:          "NoTag",
+			libraryRef:    "library:entity/collection/container",
+			wantSubmitRef: "library:entity/collection/container:latest",
+		},
+		{
+			name:          "DuplicateTags",
+			libraryRef:    "library:entity/collection/container:latest,latest",
+			tags:          []string{"latest"},
+			wantSubmitRef: "library:entity/collection/container:latest",
+		},
+		{
+			name:           "DuplicateTagsMultiple",
+			libraryRef:     "library:entity/collection/container:1.2.3,latest",
+			tags:           []string{"latest", "1.2.3", "stable"},
+			wantPushedTags: []string{"1.2.3", "latest", "stable"},
+		},
+		{
+			name:       "LocalFile",
+			libraryRef: "image.sif",
+			tags:       []string{"latest"},
+			wantErr:    errTagsWithoutLibraryRef,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			app, err := New(context.Background(), &Config{
+				URL:          m.frontend.URL,
+				BuildSpec:    defFile,
+				LibraryRef:   tt.libraryRef,
+				Tags:         tt.tags,
+				ArchsToBuild: []string{"amd64"},
+			})
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+			if err != nil {
+				return
+			}
+
+			if err := app.Run(context.Background()); err != nil {
+				t.Fatalf("run error: %v", err)
+			}
+
+			assert.Equal(t, []string{tt.wantSubmitRef}, m.submittedRefs, "submitted refs")
+			assert.Equal(t, tt.wantPushedTags, m.pushedTags, "pushed tags")
+
+			if got, want := m.pushes.Load() > 0, tt.wantPushedTags != nil; got != want {
+				t.Errorf("got %v pushes, want push %v", m.pushes.Load(), want)
+			}
+		})
+	}
+}
+
+func TestApp_RunHTTPTrace(t *testing.T) {
+	const token = "secret-token"
+
+	m := newMockServers(t)
+	m.acceptToken = token
+
+	dir := t.TempDir()
+	traceDir := filepath.Join(dir, "trace")
+
+	defFile := filepath.Join(dir, "alpine.def")
+	if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	imageFile := filepath.Join(dir, "image.sif")
+
+	app, err := New(context.Background(), &Config{
+		URL:          m.frontend.URL,
+		AuthToken:    token,
+		BuildSpec:    defFile,
+		LibraryRef:   imageFile,
+		ArchsToBuild: []string{"amd64"},
+		HTTPTraceDir: traceDir,
+	})
+	if err != nil {
+		t.Fatalf("initialization error: %v", err)
+	}
+
+	if err := app.Run(context.Background()); err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+
+	// Recording must not alter the image downloaded.
+	b, err := os.ReadFile(imageFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, mockImage, b)
+
+	type recordedBody struct {
+		Size int64 `json:"size"`
+	}
+	type recordedMessage struct {
+		Method string        `json:"method"`
+		URL    string        `json:"url"`
+		Header http.Header   `json:"header"`
+		Body   *recordedBody `json:"body"`
+	}
+	type recordedExchange struct {
+		Request  recordedMessage   `json:"request"`
+		Response *recordedMessage  `json:"response"`
+		Frames   []json.RawMessage `json:"frames"`
+	}
+
+	names, err := filepath.Glob(filepath.Join(traceDir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sawFrames, sawImage bool
+
+	for i, name := range names {
+		// Recordings are numbered in sequence.
+		assert.Equal(t, fmt.Sprintf("%06d.json", i+1), filepath.Base(name))
+
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.NotContains(t, string(b), token, "token not redacted in %v", name)
+
+		var ex recordedExchange
+		if err := json.Unmarshal(b, &ex); err != nil {
+			t.Fatal(err)
+		}
+
+		if ex.Request.Header.Get("Authorization") != "" {
+			assert.Equal(t, "REDACTED", ex.Request.Header.Get("Authorization"))
+		}
+
+		if len(ex.Frames) > 0 {
+			sawFrames = true
+		}
+
+		if ex.Response != nil && ex.Response.Body != nil && ex.Response.Body.Size == int64(len(mockImage)) {
+			sawImage = true
+		}
+	}
+
+	assert.NotEmpty(t, names)
+	assert.True(t, sawFrames, "websocket transcript not recorded")
+	assert.True(t, sawImage, "image download not recorded")
+}
+
+func TestApp_RunVerboseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		verbose bool
+	}{
+		{"Default", false},
+		{"Verbose", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.submitStatus = http.StatusBadRequest
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			app, err := New(context.Background(), &Config{
+				URL:          m.frontend.URL,
+				BuildSpec:    defFile,
+				LibraryRef:   filepath.Join(dir, "image.sif"),
+				ArchsToBuild: []string{"amd64"},
+				Verbose:      tt.verbose,
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			err = app.Run(context.Background())
+			if err == nil {
+				t.Fatal("unexpected success")
+			}
+
+			// The request ID is reported in the error only if verbose.
+			if got, want := strings.Contains(err.Error(), "request ID "+mockRequestID), tt.verbose; got != want {
+				t.Errorf("got error %q, want request ID included %v", err, want)
+			}
+		})
+	}
+}
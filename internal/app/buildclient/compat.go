@@ -0,0 +1,159 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/blang/semver/v4"
+	build "github.com/sylabs/scs-build-client/client"
+)
+
+// capability describes a Build Service capability that an invocation may depend on.
+type capability int
+
+const (
+	capBuildContext capability = iota
+	capWorkingDir
+	capBuilderRequirements
+	capStreamedContextUpload
+	capZstdContext
+)
+
+// capabilities maps each capability to a description, and the minimum Build Service version that
+// supports it. Servers that predate a capability silently ignore the corresponding request fields,
+// so the client must not rely on the server to reject such requests.
+var capabilities = map[capability]struct {
+	name       string
+	minVersion semver.Version
+}{
+	capBuildContext:          {"build context upload", semver.MustParse("0.7.0")},
+	capWorkingDir:            {"working directory", semver.MustParse("0.7.0")},
+	capBuilderRequirements:   {"builder requirements", semver.MustParse("0.4.0")},
+	capStreamedContextUpload: {"streamed build context upload", semver.MustParse("1.1.0")},
+	capZstdContext:           {"zstd build context compression", semver.MustParse("0.7.0")},
+}
+
+// requiredCapabilities returns the capabilities used by an invocation. The working directory is
+// only significant when a build context is supplied, since it is used to resolve relative paths in
+// the '%files' section(s) of the definition. zstdContext is set if a build context is to be uploaded
+// compressed using zstd.
+func requiredCapabilities(buildContext, requirements, zstdContext bool) []capability {
+	var caps []capability
+	if buildContext {
+		caps = append(caps, capBuildContext, capWorkingDir)
+	}
+	if zstdContext {
+		caps = append(caps, capZstdContext)
+	}
+	if requirements {
+		caps = append(caps, capBuilderRequirements)
+	}
+	return caps
+}
+
+// capabilityFeatures maps capabilities to the corresponding Build Service feature, along with a
+// suggestion for users of servers that report the feature as unsupported. Servers that report
+// their features may disable a capability regardless of version.
+var capabilityFeatures = map[capability]struct {
+	feature build.Feature
+	hint    string
+}{
+	capBuildContext: {build.FeatureBuildContext, "remove the '%files' section(s) from the definition, or use --ignore-compat to build without a build context"},
+	capZstdContext:  {build.FeatureZstdContext, "use --context-compression gzip, or --ignore-compat to upload the build context regardless"},
+}
+
+var errIncompatibleServer = errors.New("incompatible Build Service")
+
+// checkCompatibility verifies that the Build Service with the specified version supports caps.
+func checkCompatibility(serverVersion string, caps []capability) error {
+	v, err := semver.ParseTolerant(serverVersion)
+	if err != nil {
+		return fmt.Errorf("%w: unable to parse server version %q: %v", errIncompatibleServer, serverVersion, err)
+	}
+
+	var missing []string
+	for _, c := range caps {
+		if v.LT(capabilities[c].minVersion) {
+			missing = append(missing, fmt.Sprintf("%v (requires %v)", capabilities[c].name, capabilities[c].minVersion))
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: server version %v does not support %v", errIncompatibleServer, v, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// checkServerCompatibility retrieves the version of the Build Service, and verifies that it
+// supports caps. If app.ignoreCompat is set, incompatibilities are reported as warnings. The
+// version is only used for this check, so if it cannot be retrieved, a warning is reported, and
+// the version is not checked.
+func (app *App) checkServerCompatibility(ctx context.Context, caps []capability) error {
+	if len(caps) == 0 {
+		return nil
+	}
+
+	var err error
+	if v, verr := app.buildClient.GetVersion(ctx); verr != nil {
+		app.checkFrontendConfig(verr)
+		fmt.Fprintf(os.Stderr, "Warning: unable to get Build Service version, compatibility not checked: %v\n", app.wrapBuildErr(verr))
+	} else {
+		err = checkCompatibility(v, caps)
+	}
+
+	if err == nil {
+		err = app.checkFeatures(ctx, caps)
+	}
+
+	if err != nil && app.ignoreCompat {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		return nil
+	}
+	return err
+}
+
+// checkFeatures verifies that the Build Service does not report a feature corresponding to one of
+// caps as unsupported. Servers that do not report their features are assumed to support them.
+func (app *App) checkFeatures(ctx context.Context, caps []capability) error {
+	var missing, hints []string
+	for _, c := range caps {
+		if cf, ok := capabilityFeatures[c]; ok && app.featureUnsupported(ctx, cf.feature) {
+			missing = append(missing, capabilities[c].name)
+			hints = append(hints, cf.hint)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: server does not support %v (%v)", errIncompatibleServer, strings.Join(missing, ", "), strings.Join(hints, "; "))
+	}
+	return nil
+}
+
+// featureUnsupported returns true if the Build Service reports that it does not support f.
+func (app *App) featureUnsupported(ctx context.Context, f build.Feature) bool {
+	caps, err := app.getCapabilities(ctx)
+	if err != nil {
+		return false
+	}
+	supported, known := caps.Supports(f)
+	return known && !supported
+}
+
+// serverSupports returns true if the Build Service is known to support c. Unlike
+// checkServerCompatibility, it is intended for optional capabilities, so a server whose version
+// cannot be determined is assumed not to support c.
+func (app *App) serverSupports(ctx context.Context, c capability) bool {
+	v, err := app.buildClient.GetVersion(ctx)
+	if err != nil {
+		return false
+	}
+	return checkCompatibility(v, []capability{c}) == nil
+}
@@ -0,0 +1,340 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	build "github.com/sylabs/scs-build-client/client"
+)
+
+func TestCheckCompatibility(t *testing.T) {
+	tests := []struct {
+		name          string
+		serverVersion string
+		caps          []capability
+		wantErr       error
+		wantMissing   []string
+	}{
+		{
+			name:          "NoCapabilities",
+			serverVersion: "0.1.0",
+		},
+		{
+			name:          "Supported",
+			serverVersion: "0.7.0",
+			caps:          requiredCapabilities(true, true, false),
+		},
+		{
+			name:          "SupportedPrefix",
+			serverVersion: "v1.2.3",
+			caps:          requiredCapabilities(true, true, false),
+		},
+		{
+			name:          "BuildContextUnsupported",
+			serverVersion: "0.6.9",
+			caps:          requiredCapabilities(true, true, false),
+			wantErr:       errIncompatibleServer,
+			wantMissing:   []string{"build context upload", "working directory"},
+		},
+		{
+			name:          "RequirementsOnly",
+			serverVersion: "0.6.9",
+			caps:          requiredCapabilities(false, true, false),
+		},
+		{
+			name:          "RequirementsUnsupported",
+			serverVersion: "0.3.0",
+			caps:          requiredCapabilities(false, true, false),
+			wantErr:       errIncompatibleServer,
+			wantMissing:   []string{"builder requirements"},
+		},
+		{
+			name:          "BadVersion",
+			serverVersion: "unknown",
+			caps:          requiredCapabilities(true, false, false),
+			wantErr:       errIncompatibleServer,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkCompatibility(tt.serverVersion, tt.caps)
+
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			for _, name := range tt.wantMissing {
+				if !strings.Contains(err.Error(), name) {
+					t.Errorf("error %q does not name %q", err, name)
+				}
+			}
+		})
+	}
+}
+
+func TestApp_RunCompat(t *testing.T) {
+	tests := []struct {
+		name         string
+		version      string
+		noVersion    bool
+		contextFiles bool
+		ignoreCompat bool
+		wantErr      error
+		wantSubmits  int64
+	}{
+		{
+			name:         "Supported",
+			version:      "1.0.0",
+			contextFiles: true,
+			wantSubmits:  1,
+		},
+		{
+			name:        "UnsupportedUnused",
+			version:     "0.1.0",
+			wantSubmits: 1,
+		},
+		{
+			name:         "Unsupported",
+			version:      "0.1.0",
+			contextFiles: true,
+			wantErr:      errIncompatibleServer,
+		},
+		{
+			name:         "VersionUnavailable",
+			noVersion:    true,
+			contextFiles: true,
+			wantSubmits:  1,
+		},
+		{
+			name:         "UnsupportedIgnored",
+			version:      "0.1.0",
+			contextFiles: true,
+			ignoreCompat: true,
+			wantSubmits:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.version = tt.version
+			m.noVersion = tt.noVersion
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.contextFiles {
+				m.files = []string{defFile}
+			}
+
+			app, err := New(context.Background(), &Config{
+				URL:          m.frontend.URL,
+				BuildSpec:    defFile,
+				LibraryRef:   filepath.Join(dir, "image.sif"),
+				ArchsToBuild: []string{"amd64"},
+				IgnoreCompat: tt.ignoreCompat,
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			if got, want := app.Run(context.Background()), tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if got, want := m.submits.Load(), tt.wantSubmits; got != want {
+				t.Errorf("got %v submits, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApp_RunCompatFeatures(t *testing.T) {
+	tests := []struct {
+		name         string
+		builderArchs []string        // If nil, the Build Service predates reporting of capabilities.
+		features     []build.Feature // If nil, the Build Service does not report its features.
+		compression  build.Compression
+		ignoreCompat bool
+		wantErr      error
+		wantArchives int
+	}{
+		{
+			name:         "Supported",
+			builderArchs: []string{"amd64"},
+			features:     []build.Feature{build.FeatureBuildContext},
+			wantArchives: 1,
+		},
+		{
+			name:         "Unsupported",
+			builderArchs: []string{"amd64"},
+			features:     []build.Feature{},
+			wantErr:      errIncompatibleServer,
+		},
+		{
+			name:         "UnsupportedIgnored",
+			builderArchs: []string{"amd64"},
+			features:     []build.Feature{},
+			ignoreCompat: true,
+		},
+		{
+			name:         "ZstdSupported",
+			builderArchs: []string{"amd64"},
+			features:     []build.Feature{build.FeatureBuildContext, build.FeatureZstdContext},
+			compression:  build.CompressionZstd,
+			wantArchives: 1,
+		},
+		{
+			name:         "ZstdUnsupported",
+			builderArchs: []string{"amd64"},
+			features:     []build.Feature{build.FeatureBuildContext},
+			compression:  build.CompressionZstd,
+			wantErr:      errIncompatibleServer,
+		},
+		{
+			name:         "NotReported",
+			builderArchs: []string{"amd64"},
+			wantArchives: 1,
+		},
+		{
+			name:         "Legacy",
+			wantArchives: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.builderArchs = tt.builderArchs
+			m.features = tt.features
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			m.files = []string{defFile}
+
+			app, err := New(context.Background(), &Config{
+				URL:                m.frontend.URL,
+				BuildSpec:          defFile,
+				LibraryRef:         filepath.Join(dir, "image.sif"),
+				ArchsToBuild:       []string{"amd64"},
+				IgnoreCompat:       tt.ignoreCompat,
+				ContextCompression: tt.compression,
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			err = app.Run(context.Background())
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if err != nil {
+				if !strings.Contains(err.Error(), "--ignore-compat") {
+					t.Errorf("error %q does not suggest --ignore-compat", err)
+				}
+				if got := m.submits.Load(); got != 0 {
+					t.Errorf("got %v submits, want 0", got)
+				}
+			}
+
+			if got, want := len(m.archives), tt.wantArchives; got != want {
+				t.Errorf("got %v build contexts uploaded, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApp_RunStreamedContextUpload(t *testing.T) {
+	tests := []struct {
+		name           string
+		version        string
+		rejectFinalize bool
+		wantErr        bool
+		wantUploads    int64
+		wantFinalizes  int64
+		wantSubmits    int64
+	}{
+		{
+			name:        "Unsupported",
+			version:     "1.0.0",
+			wantUploads: 1,
+			wantSubmits: 1,
+		},
+		{
+			name:          "Supported",
+			version:       "1.1.0",
+			wantUploads:   1,
+			wantFinalizes: 1,
+			wantSubmits:   1,
+		},
+		{
+			name:           "FinalizeRejected",
+			version:        "1.1.0",
+			rejectFinalize: true,
+			wantErr:        true,
+			wantUploads:    1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.version = tt.version
+			m.rejectFinalize = tt.rejectFinalize
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			m.files = []string{defFile}
+
+			app, err := New(context.Background(), &Config{
+				URL:          m.frontend.URL,
+				BuildSpec:    defFile,
+				LibraryRef:   filepath.Join(dir, "image.sif"),
+				ArchsToBuild: []string{"amd64"},
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			if got, want := app.Run(context.Background()) != nil, tt.wantErr; got != want {
+				t.Fatalf("got error %v, want error %v", got, want)
+			}
+
+			if got, want := m.contextUploads.Load(), tt.wantUploads; got != want {
+				t.Errorf("got %v context uploads, want %v", got, want)
+			}
+
+			if got, want := m.contextFinalizes.Load(), tt.wantFinalizes; got != want {
+				t.Errorf("got %v context finalizes, want %v", got, want)
+			}
+
+			if got, want := m.submits.Load(), tt.wantSubmits; got != want {
+				t.Errorf("got %v submits, want %v", got, want)
+			}
+		})
+	}
+}
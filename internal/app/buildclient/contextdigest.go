@@ -0,0 +1,36 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	errInvalidContextDigest = errors.New("invalid build context digest")
+	errUnknownContext       = errors.New("build context not found")
+)
+
+// checkContextDigest checks that s is the digest of a build context, as returned when it is
+// uploaded.
+func checkContextDigest(s string) error {
+	hexDigest, ok := strings.CutPrefix(s, "sha256.")
+	if ok && len(hexDigest) == 64 && strings.ToLower(hexDigest) == hexDigest {
+		if _, err := hex.DecodeString(hexDigest); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w %q: expected sha256.<64 hexadecimal digits>", errInvalidContextDigest, s)
+}
+
+// unknownContextError returns an error reporting that the previously uploaded build context with
+// the specified digest is not known to the Build Service.
+func unknownContextError(digest string) error {
+	return fmt.Errorf("%w: %v: it may have been deleted or expired, so must be uploaded again", errUnknownContext, digest)
+}
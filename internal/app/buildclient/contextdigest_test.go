@@ -0,0 +1,121 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckContextDigest(t *testing.T) {
+	hexDigest := strings.Repeat("0123456789abcdef", 4)
+
+	tests := []struct {
+		name    string
+		s       string
+		wantErr error
+	}{
+		{"OK", "sha256." + hexDigest, nil},
+		{"NoAlgorithm", hexDigest, errInvalidContextDigest},
+		{"WrongAlgorithm", "sha512." + hexDigest, errInvalidContextDigest},
+		{"Colon", "sha256:" + hexDigest, errInvalidContextDigest},
+		{"Short", "sha256." + hexDigest[1:], errInvalidContextDigest},
+		{"Upper", "sha256." + strings.ToUpper(hexDigest), errInvalidContextDigest},
+		{"NotHex", "sha256." + strings.Repeat("g", 64), errInvalidContextDigest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, want := checkContextDigest(tt.s), tt.wantErr; !errors.Is(got, want) {
+				t.Errorf("got error %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApp_RunKeepContext(t *testing.T) {
+	m := newMockServers(t)
+	m.files = []string{"data.txt"}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "data.txt"), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	defFile := filepath.Join(dir, "alpine.def")
+	if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(t *testing.T, cfg Config) (*App, error) {
+		t.Helper()
+
+		cfg.URL = m.frontend.URL
+		cfg.BuildSpec = defFile
+		cfg.LibraryRef = filepath.Join(t.TempDir(), "image.sif")
+		cfg.ArchsToBuild = []string{"amd64"}
+		cfg.Context = dir
+
+		app, err := New(context.Background(), &cfg)
+		if err != nil {
+			t.Fatalf("initialization error: %v", err)
+		}
+		return app, app.Run(context.Background())
+	}
+
+	// The build context is uploaded, and kept once the run completes.
+	app, err := run(t, Config{KeepContext: true})
+	if err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+
+	digest := app.metadata.ContextDigest
+	if err := checkContextDigest(digest); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(1), m.contextUploads.Load())
+	assert.Equal(t, int64(0), m.contextDeletes.Load())
+
+	// The kept build context is reused, without being uploaded again, or deleted.
+	if _, err := run(t, Config{ContextDigest: digest}); err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+
+	assert.Equal(t, int64(1), m.contextUploads.Load())
+	assert.Equal(t, int64(0), m.contextDeletes.Load())
+	assert.Equal(t, []string{digest, digest}, m.submittedCtxs)
+
+	// Once deleted, the build context is reported as unknown.
+	if _, err := run(t, Config{}); err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	assert.Equal(t, int64(1), m.contextDeletes.Load())
+
+	if _, err := run(t, Config{ContextDigest: digest}); !errors.Is(err, errUnknownContext) {
+		t.Errorf("got error %v, want %v", err, errUnknownContext)
+	}
+}
+
+func TestNew_ContextDigest(t *testing.T) {
+	m := newMockServers(t)
+
+	_, err := New(context.Background(), &Config{
+		URL:           m.frontend.URL,
+		BuildSpec:     "alpine.def",
+		ArchsToBuild:  []string{"amd64"},
+		ContextDigest: "sha256:abc",
+	})
+	if !errors.Is(err, errInvalidContextDigest) {
+		t.Errorf("got error %v, want %v", err, errInvalidContextDigest)
+	}
+	assert.Equal(t, ExitUsage, ExitCode(err))
+}
@@ -0,0 +1,189 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	build "github.com/sylabs/scs-build-client/client"
+)
+
+// lastActive returns the time at which the build context described by bci was last used or, if it
+// has never been used, when it was uploaded.
+func lastActive(bci build.BuildContextInfo) time.Time {
+	if bci.LastUsed.IsZero() {
+		return bci.Created
+	}
+	return bci.LastUsed
+}
+
+// ListBuildContexts writes a table describing the build contexts owned by the caller to w.
+func (app *App) ListBuildContexts(ctx context.Context, w io.Writer) error {
+	bcis, err := app.buildClient.ListBuildContexts(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing build contexts: %w", err)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	defer tw.Flush()
+
+	fmt.Fprintf(tw, "DIGEST\tSIZE\tCREATED\tLAST USED\n")
+
+	for _, bci := range bcis {
+		lastUsed := "never"
+		if !bci.LastUsed.IsZero() {
+			lastUsed = bci.LastUsed.Format(time.RFC3339)
+		}
+
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", bci.Digest, formatBytes(bci.Size), bci.Created.Format(time.RFC3339), lastUsed)
+	}
+
+	return nil
+}
+
+var errDeletionNotConfirmed = errors.New("deletion not confirmed")
+
+// CollectBuildContexts deletes the build contexts owned by the caller that have not been used for
+// olderThan. If dryRun is set, the build contexts are reported, but not deleted.
+//
+// If more than confirmThreshold build contexts are to be deleted, and confirmThreshold is
+// positive, the user is asked to confirm the deletion. If the user declines, or cannot be asked,
+// no build contexts are deleted, and errDeletionNotConfirmed is returned.
+//
+// If the Build Service does not support listing build contexts, a warning is reported, and no
+// build contexts are deleted.
+func (app *App) CollectBuildContexts(ctx context.Context, olderThan time.Duration, dryRun bool, confirmThreshold int) error {
+	cutoff := time.Now().Add(-olderThan)
+
+	bcis, err := app.buildClient.ListBuildContexts(ctx, build.OptListBuildContextsLastUsedBefore(cutoff))
+	if errors.Is(err, build.ErrNotSupported) {
+		fmt.Fprintf(os.Stderr, "Warning: unable to collect build contexts: %v\n", err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error listing build contexts: %w", err)
+	}
+
+	var stale []build.BuildContextInfo
+	var size int64
+
+	for _, bci := range bcis {
+		// Servers may not apply the filter, so it is applied again here.
+		if lastActive(bci).Before(cutoff) {
+			stale = append(stale, bci)
+			size += bci.Size
+		}
+	}
+
+	if !dryRun && confirmThreshold > 0 && len(stale) > confirmThreshold {
+		q := fmt.Sprintf("Delete %v build contexts, totalling %v?", len(stale), formatBytes(size))
+		if !app.prompter.confirm(ctx, q) {
+			return fmt.Errorf("%w: %v build contexts would be deleted, more than %v (use --%v to raise the threshold)",
+				errDeletionNotConfirmed, len(stale), confirmThreshold, keyConfirmThreshold)
+		}
+	}
+
+	for _, bci := range stale {
+		if dryRun {
+			fmt.Printf("Would delete build context %v (%v)\n", bci.Digest, formatBytes(bci.Size))
+			continue
+		}
+
+		if err := app.buildClient.DeleteBuildContext(ctx, bci.Digest); err != nil {
+			return fmt.Errorf("error deleting build context %v: %w", bci.Digest, err)
+		}
+		fmt.Printf("Deleted build context %v (%v)\n", bci.Digest, formatBytes(bci.Size))
+	}
+
+	verb := "Deleted"
+	if dryRun {
+		verb = "Would delete"
+	}
+	fmt.Printf("%v %v build context(s), totalling %v\n", verb, len(stale), formatBytes(size))
+
+	return nil
+}
+
+// UploadBuildContext uploads a build context containing the files at the specified paths, which
+// are resolved against the working directory, and writes its digest and size to w. The build
+// context is kept until deleted, so that it may be supplied to builds by digest.
+func (app *App) UploadBuildContext(ctx context.Context, paths []string, w io.Writer) error {
+	files := make([]string, 0, len(paths))
+
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		if _, err := os.Stat(abs); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		// Paths are specified to the client in rootless format.
+		files = append(files, strings.TrimPrefix(filepath.ToSlash(abs), "/"))
+	}
+
+	// The size of the archive is not reported by the upload, so it is generated once beforehand.
+	// Since the archive is reproducible, the digest it has is that of the archive uploaded.
+	opts := app.contextArchiveOpts()
+
+	_, size, err := build.DigestBuildContext(files, opts...)
+	if err != nil {
+		return fmt.Errorf("error generating build context: %w", err)
+	}
+
+	digest, err := app.buildClient.UploadBuildContext(ctx, files, opts...)
+	if err != nil {
+		return fmt.Errorf("error uploading build context: %w", app.wrapBuildErr(err))
+	}
+
+	fmt.Fprintf(w, "Uploaded build context %v (%v)\n", digest, formatBytes(size))
+	return nil
+}
+
+var errDeleteBuildContexts = errors.New("unable to delete build context(s)")
+
+// DeleteBuildContexts deletes the build contexts with the specified digests, and writes the
+// outcome for each to w. Deletion of each is attempted, even if others cannot be deleted.
+func (app *App) DeleteBuildContexts(ctx context.Context, digests []string, w io.Writer) error {
+	// Check digests up front, so that a typo does not leave the operation half done.
+	for _, digest := range digests {
+		if err := checkContextDigest(digest); err != nil {
+			return err
+		}
+	}
+
+	var failed int
+
+	for _, digest := range digests {
+		switch err := app.buildClient.DeleteBuildContext(ctx, digest); {
+		case err == nil:
+			fmt.Fprintf(w, "Deleted build context %v\n", digest)
+
+		case errors.Is(err, build.ErrNotFound):
+			fmt.Fprintf(w, "Build context %v not found\n", digest)
+			failed++
+
+		default:
+			fmt.Fprintf(w, "Error deleting build context %v: %v\n", digest, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%w: %v of %v not deleted", errDeleteBuildContexts, failed, len(digests))
+	}
+	return nil
+}
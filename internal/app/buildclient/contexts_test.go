@@ -0,0 +1,277 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	build "github.com/sylabs/scs-build-client/client"
+)
+
+// mockBuildContexts returns build contexts last used at various times relative to now.
+func mockBuildContexts(now time.Time) []build.BuildContextInfo {
+	return []build.BuildContextInfo{
+		{Digest: "sha256.recent", Size: 1000, Created: now.Add(-72 * time.Hour), LastUsed: now.Add(-time.Hour)},
+		{Digest: "sha256.stale", Size: 2000, Created: now.Add(-72 * time.Hour), LastUsed: now.Add(-48 * time.Hour)},
+		{Digest: "sha256.unused", Size: 3000, Created: now.Add(-36 * time.Hour)},
+		{Digest: "sha256.new", Size: 4000, Created: now.Add(-time.Minute)},
+	}
+}
+
+func TestApp_ListBuildContexts(t *testing.T) {
+	tests := []struct {
+		name          string
+		noContextList bool
+		wantLines     int
+		wantErr       error
+	}{
+		{"Supported", false, 5, nil},
+		{"NotSupported", true, 0, build.ErrNotSupported},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.buildContexts = mockBuildContexts(time.Now())
+			m.noContextList = tt.noContextList
+
+			app, err := New(context.Background(), &Config{URL: m.frontend.URL})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			var b bytes.Buffer
+			if got, want := app.ListBuildContexts(context.Background(), &b), tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if tt.wantErr != nil {
+				return
+			}
+
+			lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+			if got, want := len(lines), tt.wantLines; got != want {
+				t.Fatalf("got %v lines, want %v:\n%v", got, want, b.String())
+			}
+
+			if !strings.Contains(lines[3], "sha256.unused") || !strings.Contains(lines[3], "never") {
+				t.Errorf("got line %q, want unused build context", lines[3])
+			}
+		})
+	}
+}
+
+func TestApp_CollectBuildContexts(t *testing.T) {
+	tests := []struct {
+		name             string
+		noContextList    bool
+		olderThan        time.Duration
+		dryRun           bool
+		confirmThreshold int
+		input            string
+		wantDeleted      []string
+		wantErr          error
+	}{
+		{
+			name:        "Default",
+			olderThan:   defaultGCOlderThan,
+			wantDeleted: []string{"sha256.stale", "sha256.unused"},
+		},
+		{
+			name:        "OlderThan",
+			olderThan:   40 * time.Hour,
+			wantDeleted: []string{"sha256.stale"},
+		},
+		{
+			name:        "All",
+			olderThan:   0,
+			wantDeleted: []string{"sha256.recent", "sha256.stale", "sha256.unused", "sha256.new"},
+		},
+		{
+			name:      "DryRun",
+			olderThan: defaultGCOlderThan,
+			dryRun:    true,
+		},
+		{
+			name:          "NotSupported",
+			noContextList: true,
+			olderThan:     defaultGCOlderThan,
+		},
+		{
+			name:             "BelowThreshold",
+			olderThan:        defaultGCOlderThan,
+			confirmThreshold: 2,
+			wantDeleted:      []string{"sha256.stale", "sha256.unused"},
+		},
+		{
+			name:             "Confirmed",
+			olderThan:        defaultGCOlderThan,
+			confirmThreshold: 1,
+			input:            "y\n",
+			wantDeleted:      []string{"sha256.stale", "sha256.unused"},
+		},
+		{
+			name:             "Declined",
+			olderThan:        defaultGCOlderThan,
+			confirmThreshold: 1,
+			input:            "n\n",
+			wantErr:          errDeletionNotConfirmed,
+		},
+		{
+			name:             "DryRunAboveThreshold",
+			olderThan:        defaultGCOlderThan,
+			dryRun:           true,
+			confirmThreshold: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.buildContexts = mockBuildContexts(time.Now())
+			m.noContextList = tt.noContextList
+
+			app, err := New(context.Background(), &Config{URL: m.frontend.URL})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			app.prompter = newPrompter(strings.NewReader(tt.input), io.Discard, true)
+
+			err = app.CollectBuildContexts(context.Background(), tt.olderThan, tt.dryRun, tt.confirmThreshold)
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if got, want := m.deleted, tt.wantDeleted; !reflect.DeepEqual(got, want) {
+				t.Errorf("got deleted %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApp_UploadBuildContext(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "b"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		paths       []string
+		wantErr     bool
+		wantUploads int64
+	}{
+		{"Files", []string{filepath.Join(dir, "a.txt"), filepath.Join(dir, "b")}, false, 1},
+		{"Missing", []string{filepath.Join(dir, "a.txt"), filepath.Join(dir, "missing")}, true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+
+			app, err := New(context.Background(), &Config{URL: m.frontend.URL})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			var b bytes.Buffer
+			err = app.UploadBuildContext(context.Background(), tt.paths, &b)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+
+			if got, want := m.contextUploads.Load(), tt.wantUploads; got != want {
+				t.Errorf("got %v uploads, want %v", got, want)
+			}
+
+			if err != nil {
+				return
+			}
+
+			// The digest reported is that the Build Service knows the build context by.
+			digest, _, ok := strings.Cut(strings.TrimPrefix(b.String(), "Uploaded build context "), " ")
+			if !ok || !m.uploaded[digest] {
+				t.Errorf("got output %q, want digest of uploaded build context", b.String())
+			}
+
+			// The build context is kept.
+			if got := m.contextDeletes.Load(); got != 0 {
+				t.Errorf("got %v deletes, want 0", got)
+			}
+		})
+	}
+}
+
+func TestApp_DeleteBuildContexts(t *testing.T) {
+	digest := func(c string) string { return "sha256." + strings.Repeat(c, 64) }
+
+	tests := []struct {
+		name        string
+		digests     []string
+		wantErr     error
+		wantDeleted []string
+		wantOutput  string
+	}{
+		{
+			name:        "Deleted",
+			digests:     []string{digest("a"), digest("b")},
+			wantDeleted: []string{digest("a"), digest("b")},
+			wantOutput: "Deleted build context " + digest("a") + "\n" +
+				"Deleted build context " + digest("b") + "\n",
+		},
+		{
+			name:        "NotFound",
+			digests:     []string{digest("f"), digest("b")},
+			wantErr:     errDeleteBuildContexts,
+			wantDeleted: []string{digest("b")},
+			wantOutput: "Build context " + digest("f") + " not found\n" +
+				"Deleted build context " + digest("b") + "\n",
+		},
+		{
+			name:    "InvalidDigest",
+			digests: []string{digest("a"), "sha256:b"},
+			wantErr: errInvalidContextDigest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.missingDigest = digest("f")
+
+			app, err := New(context.Background(), &Config{URL: m.frontend.URL})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			var b bytes.Buffer
+			if got, want := app.DeleteBuildContexts(context.Background(), tt.digests, &b), tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if got, want := m.deleted, tt.wantDeleted; !reflect.DeepEqual(got, want) {
+				t.Errorf("got deleted %v, want %v", got, want)
+			}
+
+			if got, want := b.String(), tt.wantOutput; got != want {
+				t.Errorf("got output %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/scs-build-client/internal/pkg/tlsdebug"
+)
+
+var debugCmd = &cobra.Command{
+	Use:   "debug",
+	Short: "Diagnose connection problems",
+}
+
+var debugTLSCmd = &cobra.Command{
+	Use:   "tls <url> [flags]",
+	Short: "Display the certificate chain presented by a server",
+	Long: `Display the certificate chain presented by a server, the outcome of verifying it, and
+whether the --ca-cert or --skip-verify flags would allow a connection.`,
+	Args: cobra.ExactArgs(1),
+	RunE: executeDebugTLSCmd,
+	Example: `
+  Inspect the certificate chain presented by a Build Service:
+
+      scs-build debug tls https://build.example.com
+
+  Inspect the certificate chain, trusting a private CA:
+
+      scs-build debug tls https://build.example.com --ca-cert ca.pem`,
+}
+
+// AddDebugCommand adds the debug command to rootCmd.
+func AddDebugCommand(rootCmd *cobra.Command) {
+	debugTLSCmd.Flags().String(keyCACert, "", "PEM file containing CA certificates to trust, in addition to system certificates")
+	debugTLSCmd.Flags().String(keyClientCert, "", "PEM file containing client certificate, for servers that require one")
+	debugTLSCmd.Flags().String(keyClientKey, "", "PEM file containing client certificate private key")
+
+	debugCmd.AddCommand(debugTLSCmd)
+
+	rootCmd.AddCommand(debugCmd)
+}
+
+func executeDebugTLSCmd(cmd *cobra.Command, args []string) error {
+	v, err := getConfig(cmd)
+	if err != nil {
+		return fmt.Errorf("error getting config: %w", err)
+	}
+
+	tlsConfig, err := newTLSConfig(&Config{
+		CACertFile:     v.GetString(keyCACert),
+		ClientCertFile: v.GetString(keyClientCert),
+		ClientKeyFile:  v.GetString(keyClientKey),
+	})
+	if err != nil {
+		return fmt.Errorf("error configuring TLS: %w", err)
+	}
+
+	ctx, cancel := newSignalContext()
+	defer cancel()
+
+	r, err := tlsdebug.Inspect(ctx, args[0], tlsConfig)
+	if err != nil {
+		return err
+	}
+
+	return r.Write(os.Stdout)
+}
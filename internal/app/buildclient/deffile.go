@@ -0,0 +1,142 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// maxSuggestionEntries bounds the number of directory entries examined when suggesting a
+// definition file in place of one that does not exist, so that large directories are not read in
+// full.
+const maxSuggestionEntries = 4096
+
+// readDefinitionFile reads the definition file with the specified name. If the file cannot be
+// read, the error names its absolute path. If the file does not exist, a file in the same
+// directory whose name differs only in case or extension is suggested, where one exists. If
+// permission is denied, the owner and mode of the file, or of the directory that cannot be
+// searched, are reported.
+func readDefinitionFile(name string) ([]byte, error) {
+	b, err := os.ReadFile(name)
+	if err == nil {
+		return b, nil
+	}
+
+	abs, aerr := filepath.Abs(name)
+	if aerr != nil {
+		abs = name
+	}
+
+	// The path is reported in absolute form, so only the underlying cause is retained.
+	cause := err
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		cause = pe.Err
+	}
+
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		if s := suggestDefinitionFile(name); s != "" {
+			return nil, fmt.Errorf("definition file %v: %w (did you mean %v?)", abs, cause, s)
+		}
+	case errors.Is(err, fs.ErrPermission):
+		if s := describePermissionDenied(abs); s != "" {
+			return nil, fmt.Errorf("definition file %v: %w (%v)", abs, cause, s)
+		}
+	}
+	return nil, fmt.Errorf("definition file %v: %w", abs, cause)
+}
+
+// suggestDefinitionFile returns the path of a file in the same directory as the file with the
+// specified name, which does not exist, whose name differs only in case or extension. A name
+// differing only in case is preferred, followed by one with the ".def" extension. If there is no
+// such file, an empty string is returned. At most maxSuggestionEntries entries are examined.
+func suggestDefinitionFile(name string) string {
+	dir, base := filepath.Dir(name), filepath.Base(name)
+	stem := strings.TrimSuffix(base, filepath.Ext(base))
+
+	f, err := os.Open(dir)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	// An error may accompany a partial read, in which case the entries read are considered.
+	entries, _ := f.ReadDir(maxSuggestionEntries)
+
+	var sameCase, sameStem []string
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		switch n := e.Name(); {
+		case strings.EqualFold(n, base):
+			sameCase = append(sameCase, n)
+		case strings.EqualFold(strings.TrimSuffix(n, filepath.Ext(n)), stem):
+			sameStem = append(sameStem, n)
+		}
+	}
+
+	// Directory entries are not returned in any particular order.
+	slices.Sort(sameCase)
+	slices.Sort(sameStem)
+
+	if len(sameCase) > 0 {
+		return filepath.Join(dir, sameCase[0])
+	}
+	if i := slices.IndexFunc(sameStem, func(n string) bool { return filepath.Ext(n) == ".def" }); i >= 0 {
+		return filepath.Join(dir, sameStem[i])
+	}
+	if len(sameStem) > 0 {
+		return filepath.Join(dir, sameStem[0])
+	}
+	return ""
+}
+
+// describePermissionDenied describes the cause of permission being denied to read the file at the
+// absolute path abs: the owner and mode of the first directory on the path that cannot be
+// searched, or otherwise of the file itself. If neither can be determined, an empty string is
+// returned.
+func describePermissionDenied(abs string) string {
+	// Stat requires search permission on each directory on the path, so the first path that
+	// cannot be stat'd lies in a directory that cannot be searched.
+	dirs := []string{abs}
+	for d := filepath.Dir(abs); d != dirs[len(dirs)-1]; d = filepath.Dir(d) {
+		dirs = append(dirs, d)
+	}
+	slices.Reverse(dirs)
+
+	for i := 1; i < len(dirs); i++ {
+		if _, err := os.Stat(dirs[i]); errors.Is(err, fs.ErrPermission) {
+			fi, err := os.Stat(dirs[i-1])
+			if err != nil {
+				return ""
+			}
+			return fmt.Sprintf("directory %v is %v", dirs[i-1], describeOwnerMode(fi))
+		}
+	}
+
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return ""
+	}
+	return "file is " + describeOwnerMode(fi)
+}
+
+// describeOwnerMode describes the owner and mode of the file described by fi.
+func describeOwnerMode(fi fs.FileInfo) string {
+	if owner := fileOwner(fi); owner != "" {
+		return fmt.Sprintf("owned by %v with mode %v", owner, fi.Mode())
+	}
+	return fmt.Sprintf("mode %v", fi.Mode())
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadDefinitionFile_NotFound(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   []string
+		read    string
+		suggest string
+	}{
+		{
+			name:    "CaseMismatch",
+			files:   []string{"alpine.def"},
+			read:    "Alpine.def",
+			suggest: "alpine.def",
+		},
+		{
+			name:    "WrongExtension",
+			files:   []string{"alpine.def"},
+			read:    "alpine.txt",
+			suggest: "alpine.def",
+		},
+		{
+			name:    "NoExtension",
+			files:   []string{"alpine.def"},
+			read:    "alpine",
+			suggest: "alpine.def",
+		},
+		{
+			name:    "CaseAndExtension",
+			files:   []string{"alpine.def"},
+			read:    "ALPINE.DEFN",
+			suggest: "alpine.def",
+		},
+		{
+			name:    "PreferCase",
+			files:   []string{"alpine.def", "alpine.txt", "Alpine.txt"},
+			read:    "ALPINE.txt",
+			suggest: "Alpine.txt",
+		},
+		{
+			name:    "PreferDefExtension",
+			files:   []string{"alpine.a", "alpine.def", "alpine.txt"},
+			read:    "alpine.yaml",
+			suggest: "alpine.def",
+		},
+		{
+			name:  "NoSimilarFile",
+			files: []string{"ubuntu.def", "alpine-3.def"},
+			read:  "alpine.def",
+		},
+		{
+			name:  "Directory",
+			files: []string{"alpine/"},
+			read:  "Alpine",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			for _, name := range tt.files {
+				if d, ok := strings.CutSuffix(name, "/"); ok {
+					if err := os.Mkdir(filepath.Join(dir, d), 0o755); err != nil {
+						t.Fatal(err)
+					}
+					continue
+				}
+				if err := os.WriteFile(filepath.Join(dir, name), []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			name := filepath.Join(dir, tt.read)
+
+			_, err := readDefinitionFile(name)
+			if !errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("got error %v, want %v", err, fs.ErrNotExist)
+			}
+
+			msg := err.Error()
+			assert.Contains(t, msg, "definition file "+name+": ")
+			assert.NotContains(t, msg, "open ", "path named once")
+
+			if tt.suggest == "" {
+				assert.NotContains(t, msg, "did you mean")
+			} else {
+				assert.Contains(t, msg, "(did you mean "+filepath.Join(dir, tt.suggest)+"?)")
+			}
+		})
+	}
+}
+
+func TestReadDefinitionFile_RelativePath(t *testing.T) {
+	_, err := readDefinitionFile(filepath.Join("testdata", "missing.def"))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("got error %v, want %v", err, fs.ErrNotExist)
+	}
+
+	abs, err2 := filepath.Abs(filepath.Join("testdata", "missing.def"))
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	assert.Contains(t, err.Error(), "definition file "+abs+": ")
+}
+
+func TestReadDefinitionFile_PermissionDenied(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes not supported")
+	}
+	if os.Geteuid() == 0 {
+		t.Skip("permission checks do not apply to root")
+	}
+
+	tests := []struct {
+		name     string
+		fileMode fs.FileMode
+		dirMode  fs.FileMode
+		wantDir  bool
+	}{
+		{"UnreadableFile", 0o200, 0o755, false},
+		{"UnsearchableDirectory", 0o644, 0o600, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "defs")
+			if err := os.Mkdir(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			name := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(name, []byte("bootstrap: docker\nfrom: alpine:3\n"), tt.fileMode); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := os.Chmod(dir, tt.dirMode); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { _ = os.Chmod(dir, 0o755) })
+
+			_, err := readDefinitionFile(name)
+			if !errors.Is(err, fs.ErrPermission) {
+				t.Fatalf("got error %v, want %v", err, fs.ErrPermission)
+			}
+
+			msg := err.Error()
+			assert.Contains(t, msg, "definition file "+name+": ")
+
+			if tt.wantDir {
+				assert.Contains(t, msg, "directory "+dir+" is owned by ")
+				assert.Contains(t, msg, "with mode "+(fs.ModeDir|tt.dirMode).String())
+			} else {
+				assert.Contains(t, msg, "file is owned by ")
+				assert.Contains(t, msg, "with mode "+tt.fileMode.String())
+			}
+		})
+	}
+}
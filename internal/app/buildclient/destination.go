@@ -0,0 +1,281 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Variables substituted in the default destination template. See renderDestination.
+const (
+	destVarDefBasename = "def_basename"
+	destVarArch        = "arch"
+	destVarDate        = "date"
+	destVarGitSHA      = "git_sha"
+)
+
+// Variables substituted in the output template. See archFileName.
+const (
+	outVarName = "name"
+	outVarExt  = "ext"
+	outVarArch = "arch"
+	outVarTag  = "tag"
+)
+
+// defaultOutputTemplate names the image file built for each architecture, where more than one is
+// built, by inserting the architecture before the extension, such as "alpine-arm64.sif". Image
+// files were formerly named by appending the architecture, such as "alpine.sif-arm64", which
+// "{name}{ext}-{arch}" reproduces.
+const defaultOutputTemplate = "{name}-{arch}{ext}"
+
+// destinationVarPattern matches a variable in a destination template, in "{name}" or
+// "{name|fallback}" format.
+var destinationVarPattern = regexp.MustCompile(`\{([a-z_]+)(?:\|([^{}]*))?\}`)
+
+var (
+	errDestinationVariable   = errors.New("destination template variable not available")
+	errEphemeralDestination  = errors.New("image path may not be specified along with --" + keyEphemeral)
+	errInvalidOutputTemplate = errors.New("invalid output template")
+	errDestinationCollision  = errors.New("destination file names collide")
+)
+
+// destinationVars holds the values of the variables substituted in the default destination
+// template. A value is empty where it is not available.
+type destinationVars struct {
+	defBasename string // Name of the definition, without directory or extension.
+	arch        string // Architecture built, where only one is built.
+	date        string // Date of the run (UTC), in YYYYMMDD format.
+	gitSHA      string // Abbreviated SHA of the commit checked out in the definition's directory.
+}
+
+// renderDestination returns tmpl with each variable, in "{name}" format, replaced by its value in
+// vars. A variable may specify a fallback, in "{name|fallback}" format, used where its value is
+// not available. Otherwise, a variable whose value is not available is an error. Placeholders that
+// do not name a variable, such as definitionNamePlaceholder, are left as is.
+func renderDestination(tmpl string, vars destinationVars) (string, error) {
+	return renderTemplate(tmpl, map[string]string{
+		destVarDefBasename: vars.defBasename,
+		destVarArch:        vars.arch,
+		destVarDate:        vars.date,
+		destVarGitSHA:      vars.gitSHA,
+	})
+}
+
+// renderTemplate returns tmpl with each variable replaced by its value in values, as described by
+// renderDestination.
+func renderTemplate(tmpl string, values map[string]string) (string, error) {
+	var err error
+
+	s := destinationVarPattern.ReplaceAllStringFunc(tmpl, func(m string) string {
+		sm := destinationVarPattern.FindStringSubmatch(m)
+
+		value, ok := values[sm[1]]
+		if !ok {
+			return m
+		}
+
+		if value == "" {
+			if !strings.Contains(m, "|") {
+				if err == nil {
+					err = fmt.Errorf("%w: {%v}", errDestinationVariable, sm[1])
+				}
+				return m
+			}
+			value = sm[2]
+		}
+		return value
+	})
+	if err != nil {
+		return "", err
+	}
+	return s, nil
+}
+
+// splitImagePath returns the image path p without its extension, and the extension, including the
+// leading dot. The extension is that of the file name only, so a dot in a directory name does not
+// begin one, and a dotfile without a further dot, such as ".image", has no extension.
+func splitImagePath(p string) (name, ext string) {
+	base := filepath.Base(p)
+
+	ext = filepath.Ext(base)
+	if ext == base {
+		ext = ""
+	}
+	return strings.TrimSuffix(p, ext), ext
+}
+
+// imageTag returns the tag of the image specified by buildSpec, where it is a docker:// or
+// library:// image ref, or an empty string otherwise.
+func imageTag(buildSpec string) string {
+	ref, ok := strings.CutPrefix(buildSpec, "docker://")
+	if !ok {
+		if ref, ok = strings.CutPrefix(buildSpec, "library://"); !ok {
+			return ""
+		}
+	}
+
+	ref, _, _ = strings.Cut(ref, "@")
+	_, tag, _ := strings.Cut(path.Base(ref), ":")
+	return tag
+}
+
+// archFileName returns the name of the image file built for arch, where more than one architecture
+// is built, by rendering tmpl for the image path dst. In tmpl, {name} is replaced by dst without
+// its extension, {ext} by the extension, {arch} by arch, and {tag} by tag, the tag of the image
+// built from, if any. If tmpl is empty, defaultOutputTemplate is used.
+func archFileName(tmpl, dst, arch, tag string) (string, error) {
+	if tmpl == "" {
+		tmpl = defaultOutputTemplate
+	}
+
+	// An image path need not have an extension, so {ext} may be empty.
+	tmpl = strings.ReplaceAll(tmpl, "{"+outVarExt+"}", "{"+outVarExt+"|}")
+
+	name, ext := splitImagePath(dst)
+
+	return renderTemplate(tmpl, map[string]string{
+		outVarName: name,
+		outVarExt:  ext,
+		outVarArch: arch,
+		outVarTag:  tag,
+	})
+}
+
+// checkOutputTemplate returns an error if tmpl names a variable other than those substituted by
+// archFileName, does not contain {arch}, so that the image files of each architecture would have
+// the same name, or requires tag where it is not available.
+func checkOutputTemplate(tmpl, tag string) error {
+	if tmpl == "" {
+		return nil
+	}
+
+	for _, sm := range destinationVarPattern.FindAllStringSubmatch(tmpl, -1) {
+		switch sm[1] {
+		case outVarName, outVarExt, outVarArch, outVarTag:
+		default:
+			return fmt.Errorf("%w %q: unknown variable {%v}", errInvalidOutputTemplate, tmpl, sm[1])
+		}
+	}
+
+	if !strings.Contains(tmpl, "{"+outVarArch+"}") {
+		return fmt.Errorf("%w %q: {%v} required", errInvalidOutputTemplate, tmpl, outVarArch)
+	}
+
+	if _, err := archFileName(tmpl, "image.sif", "amd64", tag); err != nil {
+		return fmt.Errorf("%w %q: %w", errInvalidOutputTemplate, tmpl, err)
+	}
+	return nil
+}
+
+// checkDestinationCollisions returns an error if the image files of two of app.archsToBuild would
+// have the same name. Names that differ only in case are considered the same, since they collide
+// on case-insensitive file systems.
+func (app *App) checkDestinationCollisions() error {
+	if app.dstFileName == "" {
+		return nil
+	}
+
+	seen := make(map[string]string)
+
+	for _, arch := range app.archsToBuild {
+		fn := app.dstFileNameForArch(arch)
+
+		key := strings.ToLower(filepath.Clean(fn))
+		if other, ok := seen[key]; ok {
+			return fmt.Errorf("%w: %v and %v both written to %v", errDestinationCollision, other, arch, fn)
+		}
+		seen[key] = arch
+	}
+	return nil
+}
+
+// resolveDestination returns the destination of the image. An image path specified as an argument
+// takes precedence over the default destination template tmpl, which is used only if ephemeral is
+// not set. Where neither applies, an empty string is returned, and the image is pushed to an
+// ephemeral location. The values of template variables are obtained from vars only where the
+// template is used.
+func resolveDestination(arg, tmpl string, ephemeral bool, vars func() destinationVars) (string, error) {
+	if arg != "" {
+		if ephemeral {
+			return "", errEphemeralDestination
+		}
+		return arg, nil
+	}
+
+	if ephemeral || tmpl == "" {
+		return "", nil
+	}
+
+	return renderDestination(tmpl, vars())
+}
+
+// buildSpecBasename returns the name of the definition or image specified by buildSpec, without
+// directory, extension, tag or digest. If buildSpec is read from standard input, an empty string
+// is returned.
+func buildSpecBasename(buildSpec string) string {
+	if buildSpec == "" || buildSpec == "-" {
+		return ""
+	}
+
+	if ref, ok := strings.CutPrefix(buildSpec, "docker://"); ok {
+		ref, _, _ = strings.Cut(ref, "@")
+		name := path.Base(ref)
+		name, _, _ = strings.Cut(name, ":")
+		return name
+	}
+
+	return definitionName(buildSpec)
+}
+
+// detectGitSHA returns the abbreviated SHA of the commit checked out in the git repository
+// containing dir. If dir is not within a git repository, or git is not available, an empty string
+// is returned.
+func detectGitSHA(ctx context.Context, dir string) string {
+	b, err := execGitFetcher{}.git(ctx, dir, "rev-parse", "--short", "HEAD")
+	if err != nil {
+		return ""
+	}
+	return string(bytes.TrimSpace(b))
+}
+
+// defaultDestinationVars returns the values of the variables substituted in the default destination
+// template, for a run building buildSpec, or each of defFiles, for archs. In a batch, the definition
+// basename is definitionNamePlaceholder, so that it is replaced by the name of each definition in
+// turn. Values that are costly to determine are only determined if tmpl uses them.
+func defaultDestinationVars(ctx context.Context, tmpl, buildSpec string, defFiles, archs []string, now time.Time) destinationVars {
+	vars := destinationVars{
+		defBasename: buildSpecBasename(buildSpec),
+		date:        now.UTC().Format("20060102"),
+	}
+
+	if len(defFiles) > 0 {
+		vars.defBasename = definitionNamePlaceholder
+	}
+
+	if len(archs) == 1 && archs[0] != archAll {
+		vars.arch = archs[0]
+	}
+
+	if strings.Contains(tmpl, "{"+destVarGitSHA) {
+		dir := "."
+		if len(defFiles) > 0 {
+			dir = filepath.Dir(defFiles[0])
+		} else if buildSpec != "-" && !strings.HasPrefix(buildSpec, "docker://") {
+			dir = filepath.Dir(buildSpec)
+		}
+		vars.gitSHA = detectGitSHA(ctx, dir)
+	}
+
+	return vars
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	build "github.com/sylabs/scs-build-client/client"
+)
+
+// dryRunReport describes what a run would submit to the Build Service.
+type dryRunReport struct {
+	Archs         []string
+	Destinations  []string // Destination of the image built for each of Archs.
+	ContextFiles  int      // Number of paths included in the build context.
+	ContextSize   int64    // Size of the build context archive.
+	ContextDigest string   // Digest of the build context archive, if any.
+	ContextReused bool     // The build context was previously uploaded.
+}
+
+func (r dryRunReport) String() string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "Architectures: %v\n", strings.Join(r.Archs, " "))
+	for i, arch := range r.Archs {
+		fmt.Fprintf(&sb, "Destination (%v): %v\n", arch, r.Destinations[i])
+	}
+
+	switch {
+	case r.ContextDigest == "":
+		sb.WriteString("Build context: none\n")
+	case r.ContextReused:
+		fmt.Fprintf(&sb, "Build context: %v (previously uploaded)\n", r.ContextDigest)
+	default:
+		fmt.Fprintf(&sb, "Build context: %v path(s), %v (%v)\n", r.ContextFiles, formatBytes(r.ContextSize), r.ContextDigest)
+	}
+
+	return sb.String()
+}
+
+// destinationForArch returns a description of where the image built for arch would be placed.
+func (app *App) destinationForArch(arch string) string {
+	if fn := app.dstFileNameForArch(arch); fn != "" {
+		return fn
+	}
+	if app.libraryRef != nil {
+		return app.libraryRef.String()
+	}
+	return "temporary library location"
+}
+
+// digestContext verifies the specified sources are present, and sets the size and digest of the
+// build context containing them in r, without uploading it.
+func (app *App) digestContext(sources []FileTransport, r *dryRunReport) error {
+	files, err := checkSources(os.DirFS("/"), sources, app.allowEmptyGlobs)
+	if err != nil {
+		return fmt.Errorf("error getting build context files: %w", err)
+	}
+
+	if files == nil {
+		return nil
+	}
+
+	r.ContextFiles = len(files)
+
+	if r.ContextDigest, r.ContextSize, err = build.DigestBuildContext(files, app.contextArchiveOpts()...); err != nil {
+		return fmt.Errorf("error generating build context: %w", err)
+	}
+	return nil
+}
+
+// reportDryRun verifies the sources in the build context are present, and reports what would be
+// submitted to build archs, without uploading the build context or submitting any build.
+func (app *App) reportDryRun(sources []FileTransport, archs []string) error {
+	r := dryRunReport{Archs: archs}
+
+	for _, arch := range archs {
+		r.Destinations = append(r.Destinations, app.destinationForArch(arch))
+	}
+
+	if app.contextDigest != "" {
+		r.ContextDigest, r.ContextReused = app.contextDigest, true
+	} else if err := app.digestContext(sources, &r); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(app.stdout, "Dry run, nothing submitted\n%v", r)
+	return nil
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDryRunReport_String(t *testing.T) {
+	tests := []struct {
+		name string
+		r    dryRunReport
+		want string
+	}{
+		{
+			name: "Context",
+			r: dryRunReport{
+				Archs:         []string{"amd64", "arm64"},
+				Destinations:  []string{"image-amd64.sif", "image-arm64.sif"},
+				ContextFiles:  2,
+				ContextSize:   2048,
+				ContextDigest: "sha256.abc",
+			},
+			want: "Architectures: amd64 arm64\n" +
+				"Destination (amd64): image-amd64.sif\n" +
+				"Destination (arm64): image-arm64.sif\n" +
+				"Build context: 2 path(s), " + formatBytes(2048) + " (sha256.abc)\n",
+		},
+		{
+			name: "ContextReused",
+			r: dryRunReport{
+				Archs:         []string{"amd64"},
+				Destinations:  []string{"image.sif"},
+				ContextDigest: "sha256.abc",
+				ContextReused: true,
+			},
+			want: "Architectures: amd64\n" +
+				"Destination (amd64): image.sif\n" +
+				"Build context: sha256.abc (previously uploaded)\n",
+		},
+		{
+			name: "NoContext",
+			r: dryRunReport{
+				Archs:        []string{"amd64"},
+				Destinations: []string{"library://user/collection/image:tag"},
+			},
+			want: "Architectures: amd64\n" +
+				"Destination (amd64): library://user/collection/image:tag\n" +
+				"Build context: none\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.r.String())
+		})
+	}
+}
+
+func TestApp_RunDryRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    []string
+		existing bool // If set, the destination exists.
+		wantErr  bool
+	}{
+		{name: "Context", files: []string{"data.txt"}},
+		{name: "ExistingDestination", existing: true},
+		{name: "NoContext"},
+		{name: "MissingFile", files: []string{"missing.txt"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.files = tt.files
+
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "data.txt"), []byte("data"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			imageFile := filepath.Join(dir, "image.sif")
+
+			if tt.existing {
+				for _, arch := range []string{"amd64", "arm64"} {
+					if err := os.WriteFile(filepath.Join(dir, "image-"+arch+".sif"), []byte("existing"), 0o644); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			app, err := New(context.Background(), &Config{
+				URL:          m.frontend.URL,
+				BuildSpec:    defFile,
+				LibraryRef:   imageFile,
+				ArchsToBuild: []string{"amd64", "arm64"},
+				Context:      dir,
+				DryRun:       true,
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			// The user is not asked to overwrite an existing destination.
+			var out bytes.Buffer
+			app.prompter = newPrompter(strings.NewReader(""), &out, true)
+
+			err = app.Run(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+
+			// Nothing is uploaded, submitted or pushed, regardless of the outcome.
+			assert.Equal(t, int64(0), m.contextUploads.Load())
+			assert.Equal(t, int64(0), m.contextFinalizes.Load())
+			assert.Equal(t, int64(0), m.submits.Load())
+			assert.Equal(t, int64(0), m.pushes.Load())
+
+			assert.Empty(t, out.String())
+
+			for _, arch := range []string{"amd64", "arm64"} {
+				b, err := os.ReadFile(filepath.Join(dir, "image-"+arch+".sif"))
+				if !tt.existing {
+					if !os.IsNotExist(err) {
+						t.Errorf("image for %v written: %v", arch, err)
+					}
+				} else if string(b) != "existing" {
+					t.Errorf("image for %v overwritten: %q, %v", arch, b, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+var errArtifactVerification = errors.New("artifact verification failed")
+
+// artifactFS provides the file system operations used to write artifacts durably.
+type artifactFS interface {
+	// Sync commits the contents of f to stable storage.
+	Sync(f *os.File) error
+
+	// SyncDir commits the entries of the directory with the specified name to stable storage.
+	SyncDir(name string) error
+
+	// Rename renames oldpath to newpath, replacing any existing file.
+	Rename(oldpath, newpath string) error
+
+	// Stat returns the FileInfo of the file with the specified name.
+	Stat(name string) (fs.FileInfo, error)
+}
+
+// osArtifactFS is an artifactFS that uses the operating system.
+type osArtifactFS struct{}
+
+func (osArtifactFS) Sync(f *os.File) error                 { return f.Sync() }
+func (osArtifactFS) Rename(oldpath, newpath string) error  { return os.Rename(oldpath, newpath) }
+func (osArtifactFS) Stat(name string) (fs.FileInfo, error) { return os.Stat(name) }
+
+func (osArtifactFS) SyncDir(name string) error {
+	d, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// artifactTempName returns a random name for a temporary file alongside the artifact at dst.
+func artifactTempName(dst string) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(filepath.Dir(dst), fmt.Sprintf(".%v.%v.tmp", filepath.Base(dst), hex.EncodeToString(b))), nil
+}
+
+// defaultOutputMode is the mode of artifacts written, unless otherwise configured.
+const defaultOutputMode os.FileMode = 0o644
+
+var errInvalidOutputMode = errors.New("invalid output mode")
+
+// parseOutputMode parses the mode of artifacts written, specified in octal, such as "0644".
+func parseOutputMode(value string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(strings.TrimPrefix(value, "0o"), 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%w %q: expected octal permissions, such as 0644", errInvalidOutputMode, value)
+	}
+
+	if mode > uint64(fs.ModePerm) {
+		return 0, fmt.Errorf("%w %q: only permission bits (0777) may be set", errInvalidOutputMode, value)
+	}
+
+	return os.FileMode(mode), nil
+}
+
+// chmodArtifact sets the mode of the named artifact to mode. Since the mode is set explicitly,
+// rather than when the file is created, it is not subject to the umask. On Windows, where the mode
+// governs only whether a file is read-only, it is ignored.
+func chmodArtifact(name string, mode os.FileMode) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	return os.Chmod(name, mode)
+}
+
+// createArtifactTemp creates a temporary file alongside the artifact at dst, so that it can be
+// renamed into place. Unlike os.CreateTemp, the file has the specified mode.
+func createArtifactTemp(dst string, mode os.FileMode) (*os.File, error) {
+	name, err := artifactTempName(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := chmodArtifact(name, mode); err != nil {
+		_ = f.Close()
+		_ = os.Remove(name)
+		return nil, err
+	}
+	return f, nil
+}
+
+// writeArtifact writes an artifact to dst. The artifact is written by write to a temporary file
+// alongside dst, which is given mode app.outputMode, and renamed into place once complete, so that
+// dst is never observed partially written. write returns the number of bytes of the artifact
+// written. See commitArtifact.
+func (app *App) writeArtifact(dst string, write func(*os.File) (int64, error)) error {
+	dir := filepath.Dir(dst)
+
+	if app.noFsync && isNetworkFS(dir) {
+		fmt.Fprintf(os.Stderr, "Warning: %v is on a network file system, where artifacts written without fsync may be lost on crash\n", dir)
+	}
+
+	f, err := createArtifactTemp(dst, app.outputMode)
+	if err != nil {
+		return fmt.Errorf("error creating file for %v: %w", dst, err)
+	}
+
+	// Once renamed into place, there is nothing to remove.
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+
+	n, err := write(f)
+	if err != nil {
+		return err
+	}
+
+	return app.commitArtifact(f, dst, n)
+}
+
+// commitArtifact closes f, a complete artifact of n bytes written to a temporary file alongside
+// dst, and renames it into place.
+//
+// Unless app.noFsync is set, f is synced before it is renamed, and its directory synced after, so
+// that the artifact survives a crash of the host once written. Without this, an artifact on a
+// network file system such as NFS may be left empty by a crash. Once renamed, the size of dst is
+// verified against n.
+func (app *App) commitArtifact(f *os.File, dst string, n int64) error {
+	if !app.noFsync {
+		if err := app.artifactFS.Sync(f); err != nil {
+			return fmt.Errorf("error syncing %v: %w", f.Name(), err)
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error closing %v: %w", f.Name(), err)
+	}
+
+	if err := app.artifactFS.Rename(f.Name(), dst); err != nil {
+		return fmt.Errorf("file rename error: %w", err)
+	}
+
+	if !app.noFsync {
+		dir := filepath.Dir(dst)
+		if err := app.artifactFS.SyncDir(dir); err != nil {
+			return fmt.Errorf("error syncing directory %v: %w", dir, err)
+		}
+	}
+
+	fi, err := app.artifactFS.Stat(dst)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errArtifactVerification, err)
+	}
+	if fi.Size() != n {
+		return fmt.Errorf("%w: %v is %v bytes, expecting %v", errArtifactVerification, dst, fi.Size(), n)
+	}
+
+	return nil
+}
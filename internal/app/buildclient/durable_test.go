@@ -0,0 +1,455 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/stretchr/testify/assert"
+	"github.com/sylabs/sif/v2/pkg/integrity"
+)
+
+// recordingFS is an artifactFS that records the operations performed, identifying the directory of
+// dst as "dir", and temporary files as "tmp".
+type recordingFS struct {
+	osArtifactFS
+	dst     string
+	ops     []string
+	syncErr error
+}
+
+func (r *recordingFS) name(name string) string {
+	switch name {
+	case r.dst:
+		return filepath.Base(name)
+	case filepath.Dir(r.dst):
+		return "dir"
+	}
+	return "tmp"
+}
+
+func (r *recordingFS) Sync(f *os.File) error {
+	r.ops = append(r.ops, "sync "+r.name(f.Name()))
+	if r.syncErr != nil {
+		return r.syncErr
+	}
+	return r.osArtifactFS.Sync(f)
+}
+
+func (r *recordingFS) SyncDir(name string) error {
+	r.ops = append(r.ops, "syncdir "+r.name(name))
+	return r.osArtifactFS.SyncDir(name)
+}
+
+func (r *recordingFS) Rename(oldpath, newpath string) error {
+	r.ops = append(r.ops, "rename "+r.name(oldpath)+" "+r.name(newpath))
+	return r.osArtifactFS.Rename(oldpath, newpath)
+}
+
+func (r *recordingFS) Stat(name string) (fs.FileInfo, error) {
+	r.ops = append(r.ops, "stat "+r.name(name))
+	return r.osArtifactFS.Stat(name)
+}
+
+func TestApp_WriteArtifact(t *testing.T) {
+	errWrite := errors.New("write error")
+	errSync := errors.New("sync error")
+
+	tests := []struct {
+		name     string
+		noFsync  bool
+		syncErr  error
+		writeErr error
+		extra    int64 // Added to the number of bytes reported written.
+		wantOps  []string
+		wantErr  error
+	}{
+		{
+			name:    "Fsync",
+			wantOps: []string{"sync tmp", "rename tmp image.sif", "syncdir dir", "stat image.sif"},
+		},
+		{
+			name:    "NoFsync",
+			noFsync: true,
+			wantOps: []string{"rename tmp image.sif", "stat image.sif"},
+		},
+		{
+			name:     "WriteError",
+			writeErr: errWrite,
+			wantErr:  errWrite,
+		},
+		{
+			name:    "SyncError",
+			syncErr: errSync,
+			wantOps: []string{"sync tmp"},
+			wantErr: errSync,
+		},
+		{
+			name:    "SizeMismatch",
+			extra:   1,
+			wantOps: []string{"sync tmp", "rename tmp image.sif", "syncdir dir", "stat image.sif"},
+			wantErr: errArtifactVerification,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "dir")
+			if err := os.Mkdir(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			dst := filepath.Join(dir, "image.sif")
+			rfs := &recordingFS{dst: dst, syncErr: tt.syncErr}
+
+			app := &App{noFsync: tt.noFsync, artifactFS: rfs, outputMode: defaultOutputMode}
+
+			err := app.writeArtifact(dst, func(f *os.File) (int64, error) {
+				n, err := f.Write(mockImage)
+				if err != nil {
+					return 0, err
+				}
+				return int64(n) + tt.extra, tt.writeErr
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			assert.Equal(t, tt.wantOps, rfs.ops)
+
+			// The temporary file does not remain.
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				if e.Name() != "image.sif" {
+					t.Errorf("unexpected file %v", e.Name())
+				}
+			}
+
+			// The artifact is written only once complete.
+			b, err := os.ReadFile(dst)
+			if tt.writeErr != nil || tt.syncErr != nil {
+				if !os.IsNotExist(err) {
+					t.Errorf("got error %v, want not exist", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, mockImage) {
+				t.Errorf("got image %q, want %q", b, mockImage)
+			}
+		})
+	}
+}
+
+func TestApp_RunFsync(t *testing.T) {
+	tests := []struct {
+		name    string
+		noFsync bool
+		wantOps []string
+	}{
+		{"Default", false, []string{"sync tmp", "rename tmp image.sif", "syncdir dir", "stat image.sif"}},
+		{"NoFsync", true, []string{"rename tmp image.sif", "stat image.sif"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			imageFile := filepath.Join(dir, "image.sif")
+
+			app, err := New(context.Background(), &Config{
+				URL:          m.frontend.URL,
+				BuildSpec:    defFile,
+				LibraryRef:   imageFile,
+				ArchsToBuild: []string{"amd64"},
+				NoFsync:      tt.noFsync,
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			rfs := &recordingFS{dst: imageFile}
+			app.artifactFS = rfs
+
+			if err := app.Run(context.Background()); err != nil {
+				t.Fatalf("run error: %v", err)
+			}
+
+			assert.Equal(t, tt.wantOps, rfs.ops)
+
+			b, err := os.ReadFile(imageFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, mockImage) {
+				t.Errorf("got image %q, want %q", b, mockImage)
+			}
+		})
+	}
+}
+
+func TestApp_RunFsyncSigned(t *testing.T) {
+	// Serve a SIF image, so that it can be signed.
+	b, err := os.ReadFile(filepath.Join("testdata", "deffile.sif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(b []byte) { mockImage = b }(mockImage)
+	mockImage = b
+
+	m := newMockServers(t)
+
+	dir := t.TempDir()
+
+	defFile := filepath.Join(dir, "alpine.def")
+	if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	imageDir := filepath.Join(dir, "images")
+	if err := os.Mkdir(imageDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	imageFile := filepath.Join(imageDir, "image.sif")
+
+	e := newTestEntity(t)
+
+	app, err := New(context.Background(), &Config{
+		URL:          m.frontend.URL,
+		BuildSpec:    defFile,
+		LibraryRef:   imageFile,
+		ArchsToBuild: []string{"amd64"},
+		SignerOpts:   []integrity.SignerOpt{integrity.OptSignWithEntity(e)},
+	})
+	if err != nil {
+		t.Fatalf("initialization error: %v", err)
+	}
+
+	rfs := &recordingFS{dst: imageFile}
+	app.artifactFS = rfs
+
+	if err := app.Run(context.Background()); err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+
+	// The image is downloaded to a temporary file alongside the destination, and synced again once
+	// signed.
+	assert.Equal(t, []string{
+		"sync tmp", "rename tmp tmp", "syncdir dir", "stat tmp",
+		"sync tmp", "rename tmp image.sif", "syncdir dir", "stat image.sif",
+	}, rfs.ops)
+
+	entries, err := os.ReadDir(imageDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name() != "image.sif" {
+			t.Errorf("unexpected file %v", e.Name())
+		}
+	}
+
+	if err := verify(imageFile, integrity.OptVerifyWithKeyRing(openpgp.EntityList{e})); err != nil {
+		t.Errorf("failed to verify signed image: %v", err)
+	}
+}
+
+func TestApp_RunDownloadFailure(t *testing.T) {
+	tests := []struct {
+		name          string
+		truncateImage bool
+		imageChecksum string
+		wantErr       error
+	}{
+		{
+			name:          "Interrupted",
+			truncateImage: true,
+			wantErr:       errRetrieveArtifact,
+		},
+		{
+			name:          "ChecksumMismatch",
+			imageChecksum: fmt.Sprintf("sha256.%x", sha256.Sum256([]byte("other image"))),
+			wantErr:       errChecksumMismatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.truncateImage = tt.truncateImage
+			m.imageChecksum = tt.imageChecksum
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			imageFile := filepath.Join(dir, "image.sif")
+			if err := os.WriteFile(imageFile, []byte("existing"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			app, err := New(context.Background(), &Config{
+				URL:          m.frontend.URL,
+				BuildSpec:    defFile,
+				LibraryRef:   imageFile,
+				ArchsToBuild: []string{"amd64"},
+				Force:        true,
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			if err := app.Run(context.Background()); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			// The existing image is untouched.
+			b, err := os.ReadFile(imageFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, "existing", string(b))
+
+			// The partially written image does not remain.
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				if e.Name() != "alpine.def" && e.Name() != "image.sif" {
+					t.Errorf("unexpected file %v", e.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestParseOutputMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		wantMode os.FileMode
+		wantErr  error
+	}{
+		{"Default", "0644", 0o644, nil},
+		{"NoLeadingZero", "600", 0o600, nil},
+		{"Prefixed", "0o640", 0o640, nil},
+		{"NotOctal", "0648", 0, errInvalidOutputMode},
+		{"Empty", "", 0, errInvalidOutputMode},
+		{"Setuid", "4755", 0, errInvalidOutputMode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode, err := parseOutputMode(tt.value)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if got, want := mode, tt.wantMode; got != want {
+				t.Errorf("got mode %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApp_RunOutputMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file mode ignored on Windows")
+	}
+
+	// Serve a SIF image, so that it can be signed.
+	b, err := os.ReadFile(filepath.Join("testdata", "deffile.sif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(b []byte) { mockImage = b }(mockImage)
+	mockImage = b
+
+	e := newTestEntity(t)
+
+	tests := []struct {
+		name       string
+		outputMode os.FileMode
+		signed     bool
+		wantMode   os.FileMode
+	}{
+		{"Default", 0, false, defaultOutputMode},
+		{"Override", 0o600, false, 0o600},
+		{"GroupWritable", 0o664, false, 0o664},
+		{"SignedDefault", 0, true, defaultOutputMode},
+		{"SignedOverride", 0o640, true, 0o640},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			imageFile := filepath.Join(dir, "image.sif")
+
+			cfg := &Config{
+				URL:          m.frontend.URL,
+				BuildSpec:    defFile,
+				LibraryRef:   imageFile,
+				ArchsToBuild: []string{"amd64"},
+				OutputMode:   tt.outputMode,
+			}
+			if tt.signed {
+				cfg.SignerOpts = []integrity.SignerOpt{integrity.OptSignWithEntity(e)}
+			}
+
+			app, err := New(context.Background(), cfg)
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			if err := app.Run(context.Background()); err != nil {
+				t.Fatalf("run error: %v", err)
+			}
+
+			fi, err := os.Stat(imageFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := fi.Mode().Perm(), tt.wantMode; got != want {
+				t.Errorf("got mode %v, want %v", got, want)
+			}
+		})
+	}
+}
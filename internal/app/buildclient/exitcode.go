@@ -0,0 +1,157 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+	build "github.com/sylabs/scs-build-client/client"
+)
+
+// Exit codes, by category of failure, so that automation can decide whether to retry.
+const (
+	ExitFailure     = 1 // Failure not in another category.
+	ExitUsage       = 2 // Invalid flags or arguments.
+	ExitAuth        = 3 // Access token missing, invalid or expired.
+	ExitValidation  = 4 // Invalid build definition or build context.
+	ExitBuild       = 5 // Remote build failed or timed out.
+	ExitDownload    = 6 // Image download or verification failed.
+	ExitSigning     = 7 // Image signing or signature verification failed.
+	ExitForbidden   = 8 // Access token valid, but does not permit the operation.
+	ExitInterrupted = 9 // Run interrupted, such as by Ctrl-C.
+)
+
+// usageError marks an error as resulting from invalid flags or arguments.
+type usageError struct {
+	err error
+}
+
+func (e *usageError) Error() string { return e.err.Error() }
+
+func (e *usageError) Unwrap() error { return e.err }
+
+// MarkUsageErrors marks errors returned by cmd and its subcommands on parsing invalid flags or
+// arguments, so that ExitCode returns ExitUsage for them.
+func MarkUsageErrors(cmd *cobra.Command) {
+	cmd.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return &usageError{err}
+	})
+
+	if args := cmd.Args; args != nil {
+		cmd.Args = func(cmd *cobra.Command, a []string) error {
+			if err := args(cmd, a); err != nil {
+				return &usageError{err}
+			}
+			return nil
+		}
+	}
+
+	for _, c := range cmd.Commands() {
+		MarkUsageErrors(c)
+	}
+}
+
+// usageErrors are errors that result from invalid flags or arguments.
+var usageErrors = []error{
+	errInvalidBuildSpec,
+	errInvalidRequirement,
+	errInvalidBuildArg,
+	errInvalidHeader,
+	errInvalidContextCompression,
+	errInvalidDownloadHash,
+	errInvalidCIAnnotations,
+	errInvalidArch,
+	errInvalidProxy,
+	errInvalidContext,
+	errInvalidContextDigest,
+	errTagsWithoutLibraryRef,
+	errSigningNotSupported,
+	errNoDefFiles,
+	errBatchBuildSpec,
+	errBatchDestination,
+	errConflictingClientConfig,
+	errIncompleteServiceURLs,
+	errNoArtifactCache,
+	errDestinationVariable,
+	errEphemeralDestination,
+	errInvalidOutputTemplate,
+	errDestinationCollision,
+}
+
+// validationErrors are errors that result from an invalid build definition or build context.
+var validationErrors = []error{
+	errDefinitionParse,
+	errUnterminatedQuote,
+	errMissingFiles,
+	errNoBuildContextFiles,
+	errUnresolvedBuildArg,
+	errPreprocess,
+	errNoSIFDefinition,
+	errDefinitionChanged,
+	errUnknownContext,
+	build.ErrEmptyDefinition,
+}
+
+// downloadErrors are errors that result from failure to download or verify an image.
+var downloadErrors = []error{
+	errRetrieveArtifact,
+	errChecksumMismatch,
+	errSizeMismatch,
+	errArtifactVerification,
+}
+
+// signingErrors are errors that result from failure to sign an image, or verify its signatures.
+var signingErrors = []error{
+	errSigning,
+	errSigningOpts,
+	errSigningCheck,
+	errSignatureVerification,
+	errNoPassphrase,
+}
+
+// isAny returns true if err matches any of targets.
+func isAny(err error, targets []error) bool {
+	for _, target := range targets {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExitCode returns the exit code corresponding to the category of err, or 0 if err is nil. Where
+// err matches more than one category, such as when builds for several architectures fail for
+// different reasons, the first in the order interrupted, usage, auth, forbidden, validation, signing, download
+// and build is chosen. Auth and forbidden precede the categories of operations, since any operation
+// may be rejected.
+func ExitCode(err error) int {
+	var ue *usageError
+	var bfe *BuildFailureError
+	var te *TimeoutError
+
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errInterrupted):
+		return ExitInterrupted
+	case errors.As(err, &ue), isAny(err, usageErrors):
+		return ExitUsage
+	case errors.Is(err, errAuthTokenRequired), isUnauthorized(err):
+		return ExitAuth
+	case isForbidden(err):
+		return ExitForbidden
+	case isAny(err, validationErrors):
+		return ExitValidation
+	case isAny(err, signingErrors):
+		return ExitSigning
+	case isAny(err, downloadErrors):
+		return ExitDownload
+	case errors.As(err, &bfe), errors.As(err, &te):
+		return ExitBuild
+	}
+	return ExitFailure
+}
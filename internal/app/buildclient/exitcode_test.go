@@ -0,0 +1,103 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	jsonresp "github.com/sylabs/json-resp"
+	build "github.com/sylabs/scs-build-client/client"
+	library "github.com/sylabs/scs-library-client/client"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"Nil", nil, 0},
+		{"Other", errors.New("other"), ExitFailure},
+		{"Usage", &usageError{errors.New("unknown flag: --bogus")}, ExitUsage},
+		{"InvalidRequirement", fmt.Errorf("%w: gpu", errInvalidRequirement), ExitUsage},
+		{"InvalidArch", fmt.Errorf("%w %q", errInvalidArch, "sparc"), ExitUsage},
+		{"AuthTokenRequired", fmt.Errorf("%w: %w", errAuthTokenRequired, build.ErrUnauthorized), ExitAuth},
+		{"BuildUnauthorized", fmt.Errorf("error submitting build: %w", build.ErrUnauthorized), ExitAuth},
+		{"BuildForbidden", build.ErrForbidden, ExitForbidden},
+		{"AuthTokenRequiredForbidden", fmt.Errorf("%w: %w", errAuthTokenRequired, build.ErrForbidden), ExitAuth},
+		{"PermissionDenied", fmt.Errorf("%w: %w", errPermissionDenied, build.ErrForbidden), ExitForbidden},
+		{"LibraryForbidden", fmt.Errorf("error pushing image: %w", &jsonresp.Error{Code: http.StatusForbidden}), ExitForbidden},
+		{"MultiArchAuth", &multiArchError{errs: []error{build.ErrForbidden, build.ErrUnauthorized}}, ExitAuth},
+		{"LibraryUnauthorized", fmt.Errorf("%w: %w", errRetrieveArtifact, library.ErrUnauthorized), ExitAuth},
+		{"DefinitionUnauthorized", fmt.Errorf("%w: %w", errDefinitionParse, &jsonresp.Error{Code: http.StatusUnauthorized}), ExitAuth},
+		{"DefinitionInvalid", fmt.Errorf("%w: %w", errDefinitionParse, &jsonresp.Error{Code: http.StatusBadRequest}), ExitValidation},
+		{"MissingFiles", fmt.Errorf("%w: a.txt", errMissingFiles), ExitValidation},
+		{"DefinitionChanged", errDefinitionChanged, ExitValidation},
+		{"DefinitionEmpty", fmt.Errorf("error submitting remote build: %w", build.ErrEmptyDefinition), ExitValidation},
+		{"BuildFailure", &BuildFailureError{Arch: "amd64", Err: errors.New("failed to build image (exit code 1)")}, ExitBuild},
+		{"OutputLimit", &BuildFailureError{Arch: "amd64", Err: errOutputLimit}, ExitBuild},
+		{"Timeout", &TimeoutError{Op: "build", Timeout: time.Minute}, ExitBuild},
+		{"ChecksumMismatch", fmt.Errorf("%w: %w", errRetrieveArtifact, errChecksumMismatch), ExitDownload},
+		{"SizeMismatch", fmt.Errorf("%w (expecting 2, got 1)", errSizeMismatch), ExitDownload},
+		{"ArtifactVerification", fmt.Errorf("%w: %w", errRetrieveArtifact, errArtifactVerification), ExitDownload},
+		{"RetrieveArtifact", fmt.Errorf("%w: %w", errRetrieveArtifact, io.ErrUnexpectedEOF), ExitDownload},
+		{"Signing", fmt.Errorf("%w: %w", errSigning, errors.New("key not found")), ExitSigning},
+		{"SigningOpts", fmt.Errorf("%w: %w", errSigningOpts, errNoPassphrase), ExitSigning},
+		{"SignatureVerification", fmt.Errorf("%w: %w", errSignatureVerification, errors.New("bad")), ExitSigning},
+		{"MultiArchSame", &multiArchError{errs: []error{&BuildFailureError{}, &BuildFailureError{}}}, ExitBuild},
+		{"MultiArchMixed", &multiArchError{errs: []error{&BuildFailureError{}, fmt.Errorf("%w: %w", errRetrieveArtifact, errChecksumMismatch)}}, ExitDownload},
+		{"Interrupted", fmt.Errorf("%w: %w", errInterrupted, fmt.Errorf("%w: %w", errRetrieveArtifact, context.Canceled)), ExitInterrupted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ExitCode(tt.err))
+		})
+	}
+}
+
+func TestMarkUsageErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"Success", []string{"sub", "arg"}, 0},
+		{"UnknownFlag", []string{"sub", "--bogus", "arg"}, ExitUsage},
+		{"InvalidFlagValue", []string{"sub", "--count", "x", "arg"}, ExitUsage},
+		{"MissingArg", []string{"sub"}, ExitUsage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := &cobra.Command{Use: "root", SilenceErrors: true, SilenceUsage: true}
+			sub := &cobra.Command{
+				Use:  "sub",
+				Args: cobra.ExactArgs(1),
+				RunE: func(*cobra.Command, []string) error { return nil },
+			}
+			sub.Flags().Int("count", 0, "")
+			root.AddCommand(sub)
+
+			MarkUsageErrors(root)
+
+			root.SetArgs(tt.args)
+			root.SetOut(&bytes.Buffer{})
+			root.SetErr(&bytes.Buffer{})
+
+			assert.Equal(t, tt.want, ExitCode(root.Execute()))
+		})
+	}
+}
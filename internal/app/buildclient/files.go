@@ -6,12 +6,16 @@
 package buildclient
 
 import (
+	"bytes"
 	"context"
-	"crypto/tls"
+	"crypto/sha256"
+	"errors"
 	"fmt"
-	"io"
+	"io/fs"
 	"net/http"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	jsonresp "github.com/sylabs/json-resp"
@@ -19,7 +23,20 @@ import (
 
 // definition defines subset of def file
 type definition struct {
-	BuildData buildData `json:"buildData"`
+	Header    map[string]string `json:"header"`
+	BuildData buildData         `json:"buildData"`
+}
+
+// bootstrapSource returns the source of the bootstrap image, if any, in URI form.
+func (d definition) bootstrapSource() string {
+	bootstrap, from := d.Header["bootstrap"], d.Header["from"]
+	if bootstrap == "" || from == "" {
+		return ""
+	}
+	if strings.Contains(from, "://") {
+		return from
+	}
+	return bootstrap + "://" + from
 }
 
 type buildData struct {
@@ -31,12 +48,14 @@ type files struct {
 	Files []FileTransport `json:"files"`
 }
 
+// Stage returns the name of the stage the files are copied from, or an empty string if the files
+// are copied from the host.
 func (f files) Stage() string {
 	// Trim comments from args.
 	cleanArgs := strings.SplitN(f.Args, "#", 2)[0]
 
-	// If "stage <name>", return "<name>".
-	if args := strings.Fields(cleanArgs); len(args) == 2 && args[0] != "stage" {
+	// If "from <name>", return "<name>".
+	if args := strings.Fields(cleanArgs); len(args) == 2 && args[0] == "from" {
 		return args[1]
 	}
 
@@ -46,11 +65,105 @@ func (f files) Stage() string {
 type FileTransport struct {
 	Src string `json:"source"`
 	Dst string `json:"destination"`
+
+	base string // If set, relative sources are resolved against this directory, in place of the working directory.
+}
+
+var errUnterminatedQuote = errors.New("unterminated quote")
+
+// splitQuoted splits s around whitespace, except where the whitespace is within single or double
+// quotes. Quotes are removed from the returned fields. Within double quotes, a backslash escapes
+// the following character.
+func splitQuoted(s string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	var inField bool
+	var quote byte
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		switch {
+		case quote == '"' && c == '\\' && i+1 < len(s):
+			i++
+			field.WriteByte(s[i])
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			field.WriteByte(c)
+		case c == '"' || c == '\'':
+			quote = c
+			inField = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteByte(c)
+			inField = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, errUnterminatedQuote
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
 }
 
-// SourcePath returns the source path in the format as specified by the io/fs package.
+// source returns the source, with any quotes removed. If the source is quoted, quoted is set,
+// indicating the source is to be treated literally rather than as a glob.
+//
+// A quoted source containing whitespace may have been split by the definition parser, leaving
+// part of the source in the destination. In this case, the source is reassembled.
+func (ft FileTransport) source() (src string, quoted bool, err error) {
+	if !strings.HasPrefix(ft.Src, `"`) && !strings.HasPrefix(ft.Src, "'") {
+		return ft.Src, false, nil
+	}
+
+	fields, err := splitQuoted(ft.Src)
+	if errors.Is(err, errUnterminatedQuote) && ft.Dst != "" {
+		fields, err = splitQuoted(ft.Src + " " + ft.Dst)
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("source %v: %w", ft.Src, err)
+	}
+	if len(fields) == 0 || fields[0] == "" {
+		return "", false, fmt.Errorf("source %v: empty path", ft.Src)
+	}
+	return fields[0], true, nil
+}
+
+// escapeGlob returns path with characters that are special to path.Match escaped, so that it
+// matches only itself.
+func escapeGlob(path string) string {
+	var b strings.Builder
+	for _, c := range path {
+		if strings.ContainsRune(`*?[]\`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// SourcePath returns the source path in the format as specified by the io/fs package. Quoted
+// sources are escaped, so that they are not treated as globs.
 func (ft FileTransport) SourcePath() (string, error) {
-	path, err := filepath.Abs(ft.Src)
+	src, quoted, err := ft.source()
+	if err != nil {
+		return "", err
+	}
+
+	if ft.base != "" && !filepath.IsAbs(src) {
+		src = filepath.Join(ft.base, src)
+	}
+
+	path, err := filepath.Abs(src)
 	if err != nil {
 		return "", err
 	}
@@ -64,7 +177,12 @@ func (ft FileTransport) SourcePath() (string, error) {
 	}
 
 	// Paths must not start with a slash.
-	return strings.TrimPrefix(path, "/"), nil
+	path = strings.TrimPrefix(path, "/")
+
+	if quoted {
+		path = escapeGlob(path)
+	}
+	return path, nil
 }
 
 // SourceFiles extracts source file names for parsed def file
@@ -77,30 +195,76 @@ func (d definition) SourceFiles() (result []string) {
 	return
 }
 
-// parseDefinition calls /v1/convert-def-file API to parse definition file (read from 'r'),
-// returns parsed definition
-func (app *App) parseDefinition(ctx context.Context, r io.Reader) (definition, error) {
-	tr := http.DefaultTransport.(*http.Transport).Clone()
-	tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: app.skipTLSVerify}
-	httpClient := &http.Client{Transport: tr}
+// hostFiles returns the files copied from the host in '%files' section(s). Files copied from
+// another build stage are not part of the build context, and are omitted.
+func (d definition) hostFiles() (result []FileTransport) {
+	for _, f := range d.BuildData.Files {
+		if f.Stage() != "" {
+			continue
+		}
+		result = append(result, f.Files...)
+	}
+	return
+}
+
+// parseDefinition returns the definition raw, as parsed by the Build Service. Parsed definitions
+// are cached by digest, so that each distinct definition is sent to the Build Service once, however
+// many times it is parsed. A definition that changes, for example once build arguments are
+// substituted, is parsed afresh.
+func (app *App) parseDefinition(ctx context.Context, raw []byte) (definition, error) {
+	key := sha256.Sum256(raw)
+
+	if d, ok := app.parsedDefs[key]; ok {
+		app.defParseHits++
+		return d, nil
+	}
+
+	d, err := app.convertDefinition(ctx, raw)
+	if err != nil {
+		return definition{}, err
+	}
+
+	if app.parsedDefs == nil {
+		app.parsedDefs = make(map[[sha256.Size]byte]definition)
+	}
+	app.parsedDefs[key] = d
+
+	return d, nil
+}
+
+// convertDefinition calls /v1/convert-def-file API to parse definition file raw, returns parsed
+// definition
+func (app *App) convertDefinition(ctx context.Context, raw []byte) (definition, error) {
+	app.defParses++
 
 	loc := fmt.Sprintf("%v/%v", strings.TrimSuffix(app.buildURL, "/"), "v1/convert-def-file")
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, loc, r)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, loc, bytes.NewReader(raw))
 	if err != nil {
 		return definition{}, err
 	}
 
+	for k, vs := range app.httpHeaders {
+		req.Header[k] = vs
+	}
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", app.libraryClient.AuthToken))
 
-	res, err := httpClient.Do(req)
+	res, err := app.httpClient.Do(req)
 	if err != nil {
-		return definition{}, err
+		return definition{}, app.wrapBuildErr(err)
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode == http.StatusNotFound {
+		app.discardFrontendConfig()
+	}
+
 	if res.StatusCode/100 != 2 { // non-2xx status code
-		return definition{}, fmt.Errorf("build server error (HTTP status %d)", res.StatusCode)
+		jerr := &jsonresp.Error{Code: res.StatusCode}
+		if err := jsonresp.ReadError(res.Body); errors.As(err, &jerr) && jerr.Code == 0 {
+			jerr.Code = res.StatusCode
+		}
+		return definition{}, fmt.Errorf("build server error: %w", jerr)
 	}
 
 	var d definition
@@ -110,30 +274,191 @@ func (app *App) parseDefinition(ctx context.Context, r io.Reader) (definition, e
 	return d, err
 }
 
-// ExtractFiles makes request to remote build server to parse specified def file and returns
-// files referenced in '%files' section(s)
-func (app *App) getFiles(ctx context.Context, r io.Reader) (files []string, err error) {
-	d, err := app.parseDefinition(ctx, r)
+// getSources makes request to remote build server to parse specified def file and returns the
+// parsed definition, along with the file transports in '%files' section(s)
+func (app *App) getSources(ctx context.Context, raw []byte) (d definition, sources []FileTransport, err error) {
+	d, err = app.parseDefinition(ctx, raw)
 	if err != nil {
-		err = fmt.Errorf("def file parse error: %w", err)
+		if isDefinitionRejected(err) {
+			err = fmt.Errorf("%w: %w", errDefinitionParse, err)
+		}
 		return
 	}
 
-	for _, f := range d.BuildData.Files {
-		if f.Stage() != "" {
-			// ignore files from stages
+	sources = d.hostFiles()
+
+	if app.expandEnvFiles {
+		if sources, err = expandSources(sources, os.LookupEnv); err != nil {
+			return d, nil, err
+		}
+	}
+
+	if err := app.checkContextSources(sources); err != nil {
+		return d, nil, err
+	}
+
+	for i := range sources {
+		sources[i].base = app.contextDir
+	}
+	return d, sources, nil
+}
+
+var (
+	errUndefinedVariable = errors.New("undefined variable")
+	errBadSubstitution   = errors.New("bad substitution")
+)
+
+// isNameChar returns true if c may appear in a variable name. The first character of a name must
+// not be a digit.
+func isNameChar(c byte, first bool) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || (!first && '0' <= c && c <= '9')
+}
+
+// isName returns true if s is a valid variable name.
+func isName(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isNameChar(s[i], i == 0) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// expandEnv replaces $VAR, ${VAR} and ${VAR:-default} in s with the value of VAR, as returned by
+// lookup. If VAR is not defined and no default is provided, errUndefinedVariable is returned. As
+// in the shell, the default is also used if VAR is defined but empty. A '$' that does not
+// introduce a variable is retained.
+func expandEnv(s string, lookup func(string) (string, bool)) (string, error) {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			b.WriteByte(s[i])
 			continue
 		}
 
-		for _, ft := range f.Files {
-			updFileName, err := ft.SourcePath()
+		var name, def string
+		var hasDefault bool
+
+		if s[i+1] == '{' {
+			end := strings.IndexByte(s[i+2:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("%w: %v", errBadSubstitution, s[i:])
+			}
+			expr := s[i+2 : i+2+end]
+			name, def, hasDefault = strings.Cut(expr, ":-")
+
+			if !isName(name) {
+				return "", fmt.Errorf("%w: ${%v}", errBadSubstitution, expr)
+			}
+			i += 2 + end
+		} else {
+			j := i + 1
+			for j < len(s) && isNameChar(s[j], j == i+1) {
+				j++
+			}
+			if j == i+1 {
+				b.WriteByte('$')
+				continue
+			}
+			name = s[i+1 : j]
+			i = j - 1
+		}
+
+		value, ok := lookup(name)
+		if hasDefault && value == "" {
+			value, ok = def, true
+		}
+		if !ok {
+			return "", fmt.Errorf("%w: %v", errUndefinedVariable, name)
+		}
+		b.WriteString(value)
+	}
+
+	return b.String(), nil
+}
+
+// expandSources expands variables in sources using lookup, as described by expandEnv. As in the
+// shell, single-quoted sources are not expanded.
+//
+// When lookup consults the local environment, the environment determines which local files are
+// uploaded in the build context. A definition from an untrusted source could use this to upload
+// sensitive files (for example, "$HOME/.ssh"), so expansion is only enabled on request.
+func expandSources(sources []FileTransport, lookup func(string) (string, bool)) ([]FileTransport, error) {
+	expanded := make([]FileTransport, 0, len(sources))
+
+	for _, ft := range sources {
+		if !strings.HasPrefix(ft.Src, "'") {
+			src, err := expandEnv(ft.Src, lookup)
 			if err != nil {
-				err = fmt.Errorf("error parsing def file: %w", err)
-				return []string{}, err
+				return nil, fmt.Errorf("error expanding source %v: %w", ft.Src, err)
 			}
+			ft.Src = src
+		}
+		expanded = append(expanded, ft)
+	}
+
+	return expanded, nil
+}
+
+var errDefinitionParse = errors.New("def file parse error")
+
+// isDefinitionRejected returns true if err indicates the Build Service rejected a definition, with
+// a 4xx status code. Transport errors, and 5xx status codes, indicate a failure of the Build Service
+// rather than the definition, so do not.
+func isDefinitionRejected(err error) bool {
+	var jerr *jsonresp.Error
+	return errors.As(err, &jerr) && jerr.Code/100 == 4
+}
+
+var errMissingFiles = errors.New("files referenced in definition not found")
+
+// checkSources verifies that each of sources exists in fsys, and returns their paths in the format
+// specified by the io/fs package. All missing sources are reported in a single error, which names
+// them as specified in the definition.
+//
+// A source containing a glob that matches no files is considered missing, unless allowEmptyGlobs is
+// set, in which case it is omitted from the returned paths.
+func checkSources(fsys fs.FS, sources []FileTransport, allowEmptyGlobs bool) ([]string, error) {
+	var paths, missing []string
 
-			files = append(files, updFileName)
+	for _, ft := range sources {
+		path, err := ft.SourcePath()
+		if err != nil {
+			return nil, fmt.Errorf("error parsing def file: %w", err)
 		}
+
+		matches, err := fs.Glob(fsys, path)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", ft.Src, err)
+		}
+
+		if len(matches) == 0 {
+			if allowEmptyGlobs && isGlob(path) {
+				continue
+			}
+			missing = append(missing, strconv.Quote(ft.Src))
+			continue
+		}
+
+		paths = append(paths, path)
 	}
-	return
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: %v", errMissingFiles, strings.Join(missing, ", "))
+	}
+	return paths, nil
+}
+
+// isGlob returns true if path contains any unescaped magic characters recognized by path.Match.
+func isGlob(path string) bool {
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case '\\':
+			i++
+		case '*', '?', '[':
+			return true
+		}
+	}
+	return false
 }
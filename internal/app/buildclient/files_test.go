@@ -7,6 +7,8 @@ package buildclient
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -14,6 +16,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -27,7 +30,12 @@ func Test_Stage(t *testing.T) {
 		want string
 	}{
 		{"basic", "test", ""},
-		{"escapeArgs", "\nfirst\nsecond", "second"},
+		{"escapeArgs", "\nfirst\nsecond", ""},
+		{"from", "from build", "build"},
+		{"fromWhitespace", "\nfrom\nbuild\n", "build"},
+		{"fromComment", "from build # copy artifacts", "build"},
+		{"commentedFrom", "# from build", ""},
+		{"fromNoStage", "from", ""},
 	}
 
 	for _, tt := range tests {
@@ -43,6 +51,62 @@ func Test_Stage(t *testing.T) {
 	}
 }
 
+func Test_hostFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{
+			name: "SingleStage",
+			data: `{"buildData":{"files":[{"args":"","files":[{"source":"a.txt","destination":"/a.txt"}]}]}}`,
+			want: []string{"a.txt"},
+		},
+		{
+			name: "FromStage",
+			data: `{"buildData":{"files":[` +
+				`{"args":"from build","files":[{"source":"/usr/bin/app","destination":"/usr/bin/app"}]},` +
+				`{"args":"","files":[{"source":"config.yaml","destination":"/etc/config.yaml"}]}` +
+				`]}}`,
+			want: []string{"config.yaml"},
+		},
+		{
+			name: "NamedStageHostFiles",
+			data: `{"buildData":{"files":[` +
+				`{"args":"","files":[{"source":"src","destination":"/src"}]},` +
+				`{"args":"from build","files":[{"source":"/src/app","destination":"/app"}]},` +
+				`{"args":"# runtime files","files":[{"source":"run.sh","destination":"/run.sh"}]}` +
+				`]}}`,
+			want: []string{"src", "run.sh"},
+		},
+		{
+			name: "AllFromStages",
+			data: `{"buildData":{"files":[` +
+				`{"args":"from build","files":[{"source":"/a","destination":"/a"}]},` +
+				`{"args":"from test","files":[{"source":"/b","destination":"/b"}]}` +
+				`]}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var d definition
+			if err := json.Unmarshal([]byte(tt.data), &d); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, ft := range d.hostFiles() {
+				got = append(got, ft.Src)
+			}
+
+			if want := tt.want; !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
 func Test_SourcePath(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -70,6 +134,82 @@ func Test_SourcePath(t *testing.T) {
 	}
 }
 
+func Test_splitQuoted(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []string
+		wantErr error
+	}{
+		{"Empty", "", nil, nil},
+		{"Unquoted", "a b", []string{"a", "b"}, nil},
+		{"DoubleQuoted", `"a b" c`, []string{"a b", "c"}, nil},
+		{"SingleQuoted", `'a b' c`, []string{"a b", "c"}, nil},
+		{"NestedQuotes", `"it's" 'say "hi"'`, []string{"it's", `say "hi"`}, nil},
+		{"EscapedQuote", `"a \"b\"" c`, []string{`a "b"`, "c"}, nil},
+		{"SingleQuotedBackslash", `'a\b'`, []string{`a\b`}, nil},
+		{"Adjacent", `a"b c"d`, []string{"ab cd"}, nil},
+		{"EmptyQuoted", `"" a`, []string{"", "a"}, nil},
+		{"ExtraWhitespace", " \ta \n b ", []string{"a", "b"}, nil},
+		{"MultiByte", `"dé jà" vu`, []string{"dé jà", "vu"}, nil},
+		{"Unterminated", `"a b`, nil, errUnterminatedQuote},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitQuoted(tt.s)
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if want := tt.want; !reflect.DeepEqual(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func Test_SourcePathQuoted(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd = strings.TrimPrefix(filepath.ToSlash(wd), "/")
+
+	tests := []struct {
+		name    string
+		ft      FileTransport
+		want    string
+		wantErr bool
+	}{
+		{"Directory", FileTransport{Src: "dir/", Dst: "/dir/"}, wd + "/dir", false},
+		{"Glob", FileTransport{Src: "/data/*.txt", Dst: "/data/"}, "data/*.txt", false},
+		{"DoubleQuoted", FileTransport{Src: `"./my data/input file.txt"`, Dst: "/data/"}, wd + "/my data/input file.txt", false},
+		{"SingleQuoted", FileTransport{Src: `'/my data/input file.txt'`, Dst: "/data/"}, "my data/input file.txt", false},
+		{"QuotedDirectory", FileTransport{Src: `"/my data/"`, Dst: "/data/"}, "my data", false},
+		{"QuotedGlob", FileTransport{Src: `"/data/*.txt"`, Dst: "/data/"}, `data/\*.txt`, false},
+		{"QuotedBrackets", FileTransport{Src: `'/data/file[1].txt'`, Dst: "/data/"}, `data/file\[1\].txt`, false},
+		{"QuotedRoot", FileTransport{Src: `"/"`, Dst: "/"}, ".", false},
+		{"Split", FileTransport{Src: `"./my`, Dst: `data/input file.txt" /data/`}, wd + "/my data/input file.txt", false},
+		{"SplitSingleQuoted", FileTransport{Src: `'/my`, Dst: `data/' /data/`}, "my data", false},
+		{"Unterminated", FileTransport{Src: `"/my data`}, "", true},
+		{"EmptyQuoted", FileTransport{Src: `""`, Dst: "/data/"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.ft.SourcePath()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+
+			if want := tt.want; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
 func TestExtractFiles(t *testing.T) {
 	// Create test build server
 	r := http.NewServeMux()
@@ -107,11 +247,20 @@ func TestExtractFiles(t *testing.T) {
 	}
 
 	// Extract files referenced by def file; rewrite all paths to be relative to current working directory
-	files, err := app.getFiles(context.Background(), nil)
+	_, sources, err := app.getSources(context.Background(), nil)
 	if err != nil {
 		t.Fatalf("%v", err)
 	}
 
+	var files []string
+	for _, ft := range sources {
+		name, err := ft.SourcePath()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		files = append(files, name)
+	}
+
 	if got, want := len(files), 4; got != want {
 		t.Fatalf("unexpected number of files: got %v, want %v", got, want)
 	}
@@ -133,3 +282,444 @@ func TestExtractFiles(t *testing.T) {
 		t.Fatalf("unexpected results: got %v, want %v", files, expectedFiles)
 	}
 }
+
+func TestCheckSources(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	present := FileTransport{Src: filepath.Join(dir, "a.txt")}
+	missing := FileTransport{Src: filepath.Join(dir, "missing.txt")}
+	glob := FileTransport{Src: filepath.Join(dir, "*.txt")}
+	emptyGlob := FileTransport{Src: filepath.Join(dir, "*.dat")}
+
+	tests := []struct {
+		name            string
+		sources         []FileTransport
+		allowEmptyGlobs bool
+		wantPaths       int
+		wantErr         error
+		wantMissing     []FileTransport
+	}{
+		{"NoSources", nil, false, 0, nil, nil},
+		{"Present", []FileTransport{present}, false, 1, nil, nil},
+		{"Missing", []FileTransport{missing}, false, 0, errMissingFiles, []FileTransport{missing}},
+		{"Mixed", []FileTransport{present, missing, glob}, false, 0, errMissingFiles, []FileTransport{missing}},
+		{"Glob", []FileTransport{glob}, false, 1, nil, nil},
+		{"EmptyGlob", []FileTransport{present, emptyGlob}, false, 0, errMissingFiles, []FileTransport{emptyGlob}},
+		{"EmptyGlobAllowed", []FileTransport{present, emptyGlob}, true, 1, nil, nil},
+		{"MixedEmptyGlobAllowed", []FileTransport{missing, emptyGlob}, true, 0, errMissingFiles, []FileTransport{missing}},
+		{"MultipleMissing", []FileTransport{missing, present, emptyGlob}, false, 0, errMissingFiles, []FileTransport{missing, emptyGlob}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths, err := checkSources(os.DirFS("/"), tt.sources, tt.allowEmptyGlobs)
+
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if got, want := len(paths), tt.wantPaths; got != want {
+				t.Errorf("got %v paths, want %v", got, want)
+			}
+
+			for _, ft := range tt.wantMissing {
+				if !strings.Contains(err.Error(), strconv.Quote(ft.Src)) {
+					t.Errorf("error %q does not name %q", err, ft.Src)
+				}
+			}
+
+			if err != nil && strings.Contains(err.Error(), strconv.Quote(present.Src)) {
+				t.Errorf("error %q names present file", err)
+			}
+		})
+	}
+}
+
+func TestCheckSourcesQuoted(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "my data")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"input file.txt", "file[1].txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		src       string
+		wantPaths int
+		wantErr   error
+	}{
+		{"Spaces", `"` + filepath.Join(dir, "input file.txt") + `"`, 1, nil},
+		{"Directory", `'` + dir + `/'`, 1, nil},
+		{"Brackets", `"` + filepath.Join(dir, "file[1].txt") + `"`, 1, nil},
+		{"LiteralGlob", `"` + filepath.Join(dir, "*.txt") + `"`, 0, errMissingFiles},
+		{"Missing", `"` + filepath.Join(dir, "other file.txt") + `"`, 0, errMissingFiles},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths, err := checkSources(os.DirFS("/"), []FileTransport{{Src: tt.src, Dst: "/data/"}}, true)
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if got, want := len(paths), tt.wantPaths; got != want {
+				t.Errorf("got %v paths, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApp_RunQuotedFiles(t *testing.T) {
+	m := newMockServers(t)
+
+	dir := filepath.Join(t.TempDir(), "my data")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	defFile := filepath.Join(dir, "alpine.def")
+	if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	inputFile := filepath.Join(dir, "input file[1].txt")
+	if err := os.WriteFile(inputFile, []byte("input"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m.files = []string{`"` + inputFile + `"`}
+
+	app, err := New(context.Background(), &Config{
+		URL:          m.frontend.URL,
+		BuildSpec:    defFile,
+		LibraryRef:   filepath.Join(dir, "image.sif"),
+		ArchsToBuild: []string{"amd64"},
+	})
+	if err != nil {
+		t.Fatalf("initialization error: %v", err)
+	}
+
+	if err := app.Run(context.Background()); err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+
+	if got, want := m.contextUploads.Load(), int64(1); got != want {
+		t.Errorf("got %v context uploads, want %v", got, want)
+	}
+}
+
+func Test_expandEnv(t *testing.T) {
+	env := map[string]string{
+		"DATASET_DIR": "/data/set",
+		"EMPTY":       "",
+		"A1":          "a",
+		"_UNDER":      "u",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	tests := []struct {
+		name    string
+		s       string
+		want    string
+		wantErr error
+	}{
+		{"NoVariables", "/data/train.csv", "/data/train.csv", nil},
+		{"Bare", "$DATASET_DIR/train.csv", "/data/set/train.csv", nil},
+		{"Braced", "${DATASET_DIR}/train.csv", "/data/set/train.csv", nil},
+		{"BracedSuffix", "${A1}b", "ab", nil},
+		{"BareSuffix", "$A1-b", "a-b", nil},
+		{"BareGreedy", "$A1b", "", errUndefinedVariable},
+		{"Multiple", "$A1/$_UNDER/${A1}", "a/u/a", nil},
+		{"Empty", "x${EMPTY}y", "xy", nil},
+		{"Undefined", "$UNDEFINED/train.csv", "", errUndefinedVariable},
+		{"UndefinedBraced", "${UNDEFINED}/train.csv", "", errUndefinedVariable},
+		{"Default", "${UNDEFINED:-/tmp}/train.csv", "/tmp/train.csv", nil},
+		{"DefaultEmpty", "${EMPTY:-/tmp}", "/tmp", nil},
+		{"DefaultUnused", "${DATASET_DIR:-/tmp}", "/data/set", nil},
+		{"DefaultIsEmpty", "${UNDEFINED:-}x", "x", nil},
+		{"DefaultNotExpanded", "${UNDEFINED:-$A1}", "$A1", nil},
+		{"TrailingDollar", "a$", "a$", nil},
+		{"DollarNonName", "a$/b$1", "a$/b$1", nil},
+		{"DollarDollar", "$$", "$$", nil},
+		{"Unterminated", "${DATASET_DIR", "", errBadSubstitution},
+		{"EmptyBraces", "${}", "", errBadSubstitution},
+		{"BadName", "${1A}", "", errBadSubstitution},
+		{"UnsupportedOperator", "${A1:=b}", "", errBadSubstitution},
+		{"MultiByte", "é/$A1/ü", "é/a/ü", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandEnv(tt.s, lookup)
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if want := tt.want; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func Test_expandSources(t *testing.T) {
+	lookup := func(name string) (string, bool) {
+		if name == "DIR" {
+			return "/my data", true
+		}
+		return "", false
+	}
+
+	sources := []FileTransport{
+		{Src: "$DIR/a.txt", Dst: "/a.txt"},
+		{Src: `"$DIR/b.txt"`, Dst: "/b.txt"},
+		{Src: `'$DIR/c.txt'`, Dst: "/c.txt"},
+	}
+
+	got, err := expandSources(sources, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []FileTransport{
+		{Src: "/my data/a.txt", Dst: "/a.txt"},
+		{Src: `"/my data/b.txt"`, Dst: "/b.txt"},
+		{Src: `'$DIR/c.txt'`, Dst: "/c.txt"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := expandSources([]FileTransport{{Src: "$UNDEFINED/a.txt"}}, lookup); !errors.Is(err, errUndefinedVariable) {
+		t.Errorf("got error %v, want %v", err, errUndefinedVariable)
+	}
+}
+
+func TestApp_RunExpandEnvFiles(t *testing.T) {
+	tests := []struct {
+		name           string
+		expandEnvFiles bool
+		wantErr        error
+	}{
+		{"Disabled", false, errMissingFiles},
+		{"Enabled", true, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+
+			dir := t.TempDir()
+			t.Setenv("DATASET_DIR", dir)
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "train.csv"), nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			m.files = []string{"$DATASET_DIR/train.csv"}
+
+			app, err := New(context.Background(), &Config{
+				URL:            m.frontend.URL,
+				BuildSpec:      defFile,
+				LibraryRef:     filepath.Join(dir, "image.sif"),
+				ArchsToBuild:   []string{"amd64"},
+				ExpandEnvFiles: tt.expandEnvFiles,
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			if got, want := app.Run(context.Background()), tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApp_RunMissingFiles(t *testing.T) {
+	m := newMockServers(t)
+
+	dir := t.TempDir()
+
+	defFile := filepath.Join(dir, "alpine.def")
+	if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	missingFile := filepath.Join(dir, "missing.txt")
+	m.files = []string{defFile, missingFile}
+
+	app, err := New(context.Background(), &Config{
+		URL:          m.frontend.URL,
+		BuildSpec:    defFile,
+		LibraryRef:   filepath.Join(dir, "image.sif"),
+		ArchsToBuild: []string{"amd64"},
+	})
+	if err != nil {
+		t.Fatalf("initialization error: %v", err)
+	}
+
+	err = app.Run(context.Background())
+	if !errors.Is(err, errMissingFiles) {
+		t.Fatalf("got error %v, want %v", err, errMissingFiles)
+	}
+
+	if !strings.Contains(err.Error(), missingFile) {
+		t.Errorf("error %q does not name %q", err, missingFile)
+	}
+
+	if got := m.submits.Load(); got != 0 {
+		t.Errorf("got %v submits, want 0", got)
+	}
+}
+
+func TestApp_GetSourcesError(t *testing.T) {
+	tests := []struct {
+		name           string
+		status         int
+		wantParseError bool
+		wantExitCode   int
+	}{
+		{"BadRequest", http.StatusBadRequest, true, ExitValidation},
+		{"Unauthorized", http.StatusUnauthorized, true, ExitAuth},
+		{"InternalServerError", http.StatusInternalServerError, false, ExitFailure},
+		{"BadGateway", http.StatusBadGateway, false, ExitFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.convertStatus = tt.status
+
+			app, err := New(context.Background(), &Config{URL: m.frontend.URL})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			_, _, err = app.getSources(context.Background(), []byte("bootstrap: docker\nfrom: alpine:3\n"))
+			if err == nil {
+				t.Fatal("unexpected success")
+			}
+
+			if got, want := errors.Is(err, errDefinitionParse), tt.wantParseError; got != want {
+				t.Errorf("got parse error %v, want %v", got, want)
+			}
+
+			if got, want := ExitCode(err), tt.wantExitCode; got != want {
+				t.Errorf("got exit code %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApp_ParseDefinitionCache(t *testing.T) {
+	m := newMockServers(t)
+
+	app, err := New(context.Background(), &Config{URL: m.frontend.URL})
+	if err != nil {
+		t.Fatalf("initialization error: %v", err)
+	}
+
+	alpine := []byte("bootstrap: docker\nfrom: alpine:3\n")
+	debian := []byte("bootstrap: docker\nfrom: debian:12\n")
+
+	// Each distinct definition is sent to the Build Service once.
+	for _, raw := range [][]byte{alpine, alpine, debian, alpine, debian} {
+		if _, err := app.parseDefinition(context.Background(), raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, want := m.convertedDefs, [][]byte{alpine, debian}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got definitions %q, want %q", got, want)
+	}
+	if got, want := app.defParses, 2; got != want {
+		t.Errorf("got %v parses, want %v", got, want)
+	}
+	if got, want := app.defParseHits, 3; got != want {
+		t.Errorf("got %v cache hits, want %v", got, want)
+	}
+}
+
+func TestApp_ParseDefinitionCacheError(t *testing.T) {
+	m := newMockServers(t)
+
+	app, err := New(context.Background(), &Config{URL: m.frontend.URL})
+	if err != nil {
+		t.Fatalf("initialization error: %v", err)
+	}
+
+	raw := []byte("bootstrap: docker\nfrom: alpine:3\n")
+
+	// A definition that could not be parsed is not cached.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := app.parseDefinition(ctx, raw); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	if _, err := app.parseDefinition(context.Background(), raw); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(m.convertedDefs), 1; got != want {
+		t.Errorf("got %v definitions, want %v", got, want)
+	}
+	if got, want := app.defParses, 2; got != want {
+		t.Errorf("got %v parses, want %v", got, want)
+	}
+}
+
+func TestApp_RunBatchParseDefinitionCache(t *testing.T) {
+	m := newMockServers(t)
+
+	dir := t.TempDir()
+
+	// Definitions with identical contents are parsed once.
+	var paths []string
+	for _, name := range []string{"a.def", "b.def"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	app, err := New(context.Background(), &Config{
+		URL:          m.frontend.URL,
+		DefFiles:     paths,
+		LibraryRef:   filepath.Join(t.TempDir(), "{name}.sif"),
+		ArchsToBuild: []string{"amd64"},
+	})
+	if err != nil {
+		t.Fatalf("initialization error: %v", err)
+	}
+
+	if err := app.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(m.convertedDefs), 1; got != want {
+		t.Errorf("got %v definitions parsed, want %v", got, want)
+	}
+	if got, want := m.submits.Load(), int64(2); got != want {
+		t.Errorf("got %v submits, want %v", got, want)
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	keyContexts         = "contexts"
+	keyOlderThan        = "older-than"
+	keyDryRun           = "dry-run"
+	keyConfirmThreshold = "confirm-threshold"
+
+	// defaultGCOlderThan is the default period for which remote state must be unused before it is
+	// collected.
+	defaultGCOlderThan = 24 * time.Hour
+
+	// defaultGCConfirmThreshold is the default number of build contexts that may be deleted without
+	// confirmation.
+	defaultGCConfirmThreshold = 20
+)
+
+var gcCmd = &cobra.Command{
+	Use:   "gc [flags]",
+	Short: "Remove remote state left behind by previous builds",
+	Args:  cobra.NoArgs,
+	RunE:  executeGCCmd,
+	Example: `
+  Delete build contexts that have not been used for a day:
+
+      scs-build gc --contexts
+
+  List build contexts that have not been used for a week, without deleting them:
+
+      scs-build gc --contexts --older-than 168h --dry-run`,
+}
+
+var contextCmd = &cobra.Command{
+	Use:   "context",
+	Short: "Manage build contexts",
+}
+
+var contextListCmd = &cobra.Command{
+	Use:   "list [flags]",
+	Short: "List build contexts owned by the caller",
+	Args:  cobra.NoArgs,
+	RunE:  executeContextListCmd,
+}
+
+var contextUploadCmd = &cobra.Command{
+	Use:   "upload [flags] <path>...",
+	Short: "Upload a build context containing the specified files and directories",
+	Long: `Upload a build context containing the specified files and directories, and print its digest.
+
+The build context is kept until deleted, and may be supplied to builds using
+'scs-build build --context-digest'.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: executeContextUploadCmd,
+}
+
+var contextDeleteCmd = &cobra.Command{
+	Use:   "delete [flags] <digest>...",
+	Short: "Delete the build contexts with the specified digests",
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  executeContextDeleteCmd,
+}
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the local artifact cache",
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune [flags]",
+	Short: "Evict least recently used images from the artifact cache",
+	Args:  cobra.NoArgs,
+	RunE:  executeCachePruneCmd,
+	Example: `
+  Evict images until the artifact cache is at most 1 GiB:
+
+      scs-build cache prune --artifact-cache ~/.cache/scs-build --artifact-cache-size 1073741824
+
+  Empty the artifact cache:
+
+      scs-build cache prune --artifact-cache ~/.cache/scs-build --artifact-cache-size 0`,
+}
+
+// AddContextCommands adds the commands used to manage build contexts to rootCmd.
+func AddContextCommands(rootCmd *cobra.Command) {
+	addConnectionFlags(gcCmd)
+	gcCmd.Flags().Bool(keyContexts, false, "Delete build contexts")
+	gcCmd.Flags().Duration(keyOlderThan, defaultGCOlderThan, "Only delete state that has not been used for this period")
+	gcCmd.Flags().Bool(keyDryRun, false, "Report what would be deleted, without deleting it")
+	gcCmd.Flags().Int(keyConfirmThreshold, defaultGCConfirmThreshold, "Prompt for confirmation before deleting more than this many build contexts (0 never prompts)")
+	gcCmd.Flags().Bool(keyNonInteractive, false, "Never prompt for confirmation, declining to delete more than --"+keyConfirmThreshold+" build contexts")
+
+	addConnectionFlags(contextListCmd)
+	addConnectionFlags(contextUploadCmd)
+	addConnectionFlags(contextDeleteCmd)
+	contextCmd.AddCommand(contextListCmd, contextUploadCmd, contextDeleteCmd)
+
+	addLegacyFlags(gcCmd)
+	addLegacyFlags(contextListCmd)
+	addLegacyFlags(contextUploadCmd)
+	addLegacyFlags(contextDeleteCmd)
+
+	rootCmd.AddCommand(gcCmd, contextCmd)
+}
+
+// AddCacheCommands adds the commands used to manage the artifact cache to rootCmd.
+func AddCacheCommands(rootCmd *cobra.Command) {
+	cachePruneCmd.Flags().String(keyArtifactCache, "", "Artifact cache directory")
+	cachePruneCmd.Flags().Int64(keyArtifactCacheSize, defaultArtifactCacheSize, "Maximum size in bytes of the artifact cache once pruned")
+	cacheCmd.AddCommand(cachePruneCmd)
+
+	rootCmd.AddCommand(cacheCmd)
+}
+
+var errNothingToCollect = errors.New("nothing to collect")
+
+func executeGCCmd(cmd *cobra.Command, _ []string) error {
+	v, err := getConfig(cmd)
+	if err != nil {
+		return fmt.Errorf("error getting config: %w", err)
+	}
+
+	if !v.GetBool(keyContexts) {
+		return fmt.Errorf("%w: specify --%v", errNothingToCollect, keyContexts)
+	}
+
+	ctx, cancel := newSignalContext()
+	defer cancel()
+
+	cfg := connectionConfig(v)
+	cfg.NonInteractive = v.GetBool(keyNonInteractive)
+
+	app, err := New(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("application init error: %w", err)
+	}
+
+	return app.translateTLSErr(app.CollectBuildContexts(ctx, v.GetDuration(keyOlderThan), v.GetBool(keyDryRun), v.GetInt(keyConfirmThreshold)))
+}
+
+func executeContextListCmd(cmd *cobra.Command, _ []string) error {
+	v, err := getConfig(cmd)
+	if err != nil {
+		return fmt.Errorf("error getting config: %w", err)
+	}
+
+	ctx, cancel := newSignalContext()
+	defer cancel()
+
+	app, err := New(ctx, connectionConfig(v))
+	if err != nil {
+		return fmt.Errorf("application init error: %w", err)
+	}
+
+	return app.translateTLSErr(app.ListBuildContexts(ctx, os.Stdout))
+}
+
+func executeContextUploadCmd(cmd *cobra.Command, args []string) error {
+	v, err := getConfig(cmd)
+	if err != nil {
+		return fmt.Errorf("error getting config: %w", err)
+	}
+
+	ctx, cancel := newSignalContext()
+	defer cancel()
+
+	app, err := New(ctx, connectionConfig(v))
+	if err != nil {
+		return fmt.Errorf("application init error: %w", err)
+	}
+
+	return app.translateTLSErr(app.UploadBuildContext(ctx, args, os.Stdout))
+}
+
+func executeContextDeleteCmd(cmd *cobra.Command, args []string) error {
+	v, err := getConfig(cmd)
+	if err != nil {
+		return fmt.Errorf("error getting config: %w", err)
+	}
+
+	ctx, cancel := newSignalContext()
+	defer cancel()
+
+	app, err := New(ctx, connectionConfig(v))
+	if err != nil {
+		return fmt.Errorf("application init error: %w", err)
+	}
+
+	return app.translateTLSErr(app.DeleteBuildContexts(ctx, args, os.Stdout))
+}
+
+func executeCachePruneCmd(cmd *cobra.Command, _ []string) error {
+	v, err := getConfig(cmd)
+	if err != nil {
+		return fmt.Errorf("error getting config: %w", err)
+	}
+
+	return PruneArtifactCache(v.GetString(keyArtifactCache), v.GetInt64(keyArtifactCacheSize), os.Stdout)
+}
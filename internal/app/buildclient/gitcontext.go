@@ -0,0 +1,315 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// gitContextPrefix identifies a build context fetched from a git repository.
+const gitContextPrefix = "git+"
+
+var errInvalidContext = errors.New("invalid build context")
+
+// gitContext describes a build context fetched from a git repository, specified in
+// "git+<url>[#<ref>[:<subdir>]]" format.
+type gitContext struct {
+	url    string // Repository URL.
+	ref    string // Branch, tag or commit. If empty, the default branch is used.
+	subdir string // Directory within the repository used as the context root, if any.
+}
+
+// parseGitContext parses s as a git build context. If s does not specify a git repository, ok is
+// false.
+func parseGitContext(s string) (gc *gitContext, ok bool, err error) {
+	rest, ok := strings.CutPrefix(s, gitContextPrefix)
+	if !ok {
+		return nil, false, nil
+	}
+
+	rawURL, fragment, _ := strings.Cut(rest, "#")
+	ref, subdir, _ := strings.Cut(fragment, ":")
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, true, fmt.Errorf("%w: %w", errInvalidContext, err)
+	}
+	if u.Scheme == "" || (u.Host == "" && u.Path == "") {
+		return nil, true, fmt.Errorf("%w: %v: repository URL required", errInvalidContext, s)
+	}
+
+	if subdir != "" {
+		if subdir = filepath.Clean(subdir); !filepath.IsLocal(subdir) {
+			return nil, true, fmt.Errorf("%w: %v: directory must be within repository", errInvalidContext, s)
+		}
+	}
+
+	return &gitContext{url: rawURL, ref: ref, subdir: subdir}, true, nil
+}
+
+// String returns the source of gc in "git+<url>[@<ref>]" format, with any password in the URL
+// redacted.
+func (gc *gitContext) String() string {
+	s := gc.url
+	if u, err := url.Parse(gc.url); err == nil {
+		s = u.Redacted()
+	}
+
+	s = gitContextPrefix + s
+	if gc.ref != "" {
+		s += "@" + gc.ref
+	}
+	return s
+}
+
+// gitFetcher fetches a revision of a git repository.
+type gitFetcher interface {
+	// Fetch fetches ref of the repository at rawURL into the empty directory dir, and returns the
+	// SHA of the commit checked out. If ref is empty, the default branch is fetched.
+	Fetch(ctx context.Context, rawURL, ref, dir string) (string, error)
+}
+
+// execGitFetcher is a gitFetcher that runs git, so that the standard git configuration,
+// environment and credential helpers apply. Only the commit checked out is fetched.
+type execGitFetcher struct {
+	token string // If set, presented to the server using HTTP basic authentication.
+}
+
+// git runs git with args in dir, and returns its standard output.
+func (f execGitFetcher) git(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+
+	// Fail rather than prompt for credentials. The token is passed in the environment, rather than
+	// as an argument, so that it is not visible to other users.
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if f.token != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + f.token))
+		cmd.Env = append(cmd.Env,
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.extraHeader",
+			"GIT_CONFIG_VALUE_0=Authorization: Basic "+auth,
+		)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	b, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %v: %w: %s", args[0], err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return b, nil
+}
+
+// Fetch fetches ref of the repository at rawURL into dir, and returns the SHA of the commit
+// checked out.
+func (f execGitFetcher) Fetch(ctx context.Context, rawURL, ref, dir string) (string, error) {
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	if _, err := f.git(ctx, dir, "init", "--quiet"); err != nil {
+		return "", err
+	}
+
+	if _, err := f.git(ctx, dir, "fetch", "--quiet", "--depth=1", "--", rawURL, ref); err != nil {
+		return "", err
+	}
+
+	if _, err := f.git(ctx, dir, "checkout", "--quiet", "FETCH_HEAD"); err != nil {
+		return "", err
+	}
+
+	b, err := f.git(ctx, dir, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSpace(b)), nil
+}
+
+// setContext sets the build context specified in cfg, if any, which is either a local directory
+// or a git repository. Alternatively, cfg.WorkingDir specifies the directory relative sources are
+// resolved against, and in which the remote build runs, without the directory being a git
+// repository.
+func (app *App) setContext(cfg *Config) error {
+	if cfg.WorkingDir != "" {
+		if cfg.Context != "" {
+			return fmt.Errorf("%w: working directory %v must not be set along with build context", errInvalidContext, cfg.WorkingDir)
+		}
+		return app.setContextDir(cfg.WorkingDir)
+	}
+
+	if cfg.Context == "" {
+		return nil
+	}
+
+	gc, ok, err := parseGitContext(cfg.Context)
+	if err != nil {
+		return err
+	}
+	if ok {
+		app.gitContext = gc
+		app.gitFetcher = execGitFetcher{token: cfg.GitToken}
+		return nil
+	}
+
+	return app.setContextDir(cfg.Context)
+}
+
+// setContextDir sets the local directory against which relative sources are resolved, and in
+// which the remote build runs.
+func (app *App) setContextDir(path string) error {
+	dir, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return fmt.Errorf("%w: %v: not a directory", errInvalidContext, path)
+	}
+
+	app.contextDir = dir
+	return nil
+}
+
+// fetchContext fetches app.gitContext, if set, to a temporary directory, and sets app.contextDir
+// and app.contextCommit accordingly. The returned function removes the temporary directory, and
+// must be called once the build context is no longer required.
+func (app *App) fetchContext(ctx context.Context) (func(), error) {
+	if app.gitContext == nil {
+		return func() {}, nil
+	}
+
+	dir, err := os.MkdirTemp("", "scs-build-context-")
+	if err != nil {
+		return nil, err
+	}
+	cleanup := func() { _ = os.RemoveAll(dir) }
+
+	fmt.Fprintf(app.stdout, "Fetching build context from %v\n", app.gitContext)
+
+	commit, err := app.gitFetcher.Fetch(ctx, app.gitContext.url, app.gitContext.ref, dir)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+
+	contextDir := filepath.Join(dir, app.gitContext.subdir)
+	if fi, err := os.Stat(contextDir); err != nil || !fi.IsDir() {
+		cleanup()
+		return nil, fmt.Errorf("%w: %v: directory %q not found in repository", errInvalidContext, app.gitContext, app.gitContext.subdir)
+	}
+
+	app.contextDir = contextDir
+	app.contextRoot = dir
+	app.contextCommit = commit
+
+	return cleanup, nil
+}
+
+// checkContextSources returns an error if any of sources is not within the git repository the
+// build context was fetched from, so that a definition fetched from a repository cannot copy
+// arbitrary host files into the image. Sources must be relative to the build context, must not
+// refer to a parent of the repository, and must not contain symbolic links that resolve outside the
+// repository, since links are followed when the build context is archived.
+func (app *App) checkContextSources(sources []FileTransport) error {
+	if app.gitContext == nil {
+		return nil
+	}
+
+	root, err := filepath.EvalSymlinks(app.contextRoot)
+	if err != nil {
+		return fmt.Errorf("failed to resolve build context: %w", err)
+	}
+
+	seen := make(map[string]bool)
+
+	for _, ft := range sources {
+		src, _, err := ft.source()
+		if err != nil {
+			return err
+		}
+
+		if filepath.IsAbs(src) {
+			return fmt.Errorf("%w: source %v must be relative to repository", errInvalidContext, src)
+		}
+
+		path := filepath.Join(app.contextDir, src)
+		if !withinDir(app.contextRoot, path) {
+			return fmt.Errorf("%w: source %v must be within repository", errInvalidContext, src)
+		}
+
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			return fmt.Errorf("%w: source %v: %w", errInvalidContext, src, err)
+		}
+
+		for _, m := range matches {
+			if err := checkLinksWithin(root, m, seen); err != nil {
+				return fmt.Errorf("%w: source %v must be within repository: %w", errInvalidContext, src, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// withinDir reports whether path is dir, or is below dir.
+func withinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// checkLinksWithin returns an error if path, or any file below it, resolves outside root once
+// symbolic links are followed. Resolved directories are recorded in seen, so that each is walked
+// once.
+func checkLinksWithin(root, path string, seen map[string]bool) error {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+
+	if !withinDir(root, resolved) {
+		return fmt.Errorf("%v resolves to %v", path, resolved)
+	}
+
+	if seen[resolved] {
+		return nil
+	}
+	seen[resolved] = true
+
+	return filepath.WalkDir(resolved, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != resolved && d.Type()&fs.ModeSymlink != 0 {
+			return checkLinksWithin(root, p, seen)
+		}
+		return nil
+	})
+}
+
+// definitionPath returns the path of the definition named by buildSpec. When the build context
+// is fetched from a git repository, a relative path is resolved against the build context.
+func (app *App) definitionPath(buildSpec string) string {
+	if app.gitContext == nil || buildSpec == "-" || filepath.IsAbs(buildSpec) {
+		return buildSpec
+	}
+	if _, ok := definitionFromURI(buildSpec); ok {
+		return buildSpec
+	}
+	return filepath.Join(app.contextDir, buildSpec)
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var infoCmd = &cobra.Command{
+	Use:   "info [flags]",
+	Short: "Display information about the Build Service, including any maintenance notices",
+	Args:  cobra.NoArgs,
+	RunE:  executeInfoCmd,
+	Example: `
+  Display the version of, and notices published by, Singularity Enterprise:
+
+      scs-build info --url https://cloud.enterprise.local`,
+}
+
+// AddInfoCommand adds the info command to rootCmd.
+func AddInfoCommand(rootCmd *cobra.Command) {
+	addConnectionFlags(infoCmd)
+	addLegacyFlags(infoCmd)
+
+	rootCmd.AddCommand(infoCmd)
+}
+
+func executeInfoCmd(cmd *cobra.Command, _ []string) error {
+	v, err := getConfig(cmd)
+	if err != nil {
+		return fmt.Errorf("error getting config: %w", err)
+	}
+
+	ctx, cancel := newSignalContext()
+	defer cancel()
+
+	app, err := New(ctx, connectionConfig(v))
+	if err != nil {
+		return fmt.Errorf("application init error: %w", err)
+	}
+
+	return app.translateTLSErr(app.WriteInfo(ctx, os.Stdout))
+}
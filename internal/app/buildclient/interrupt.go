@@ -0,0 +1,85 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var errInterrupted = errors.New("interrupted")
+
+// contextDeleteTimeout is the timeout of the request to delete a build context once it is no longer
+// required.
+const contextDeleteTimeout = 5 * time.Second
+
+// interruptedError returns err, marked as resulting from an interrupted run if ctx is done. If err
+// is nil, nil is returned, since the run completed regardless.
+func interruptedError(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+	return fmt.Errorf("%w: %w", errInterrupted, err)
+}
+
+// cancelOnSignal reports sig, which interrupted the run, and cancels the run using cancel, giving
+// it the opportunity to cancel running builds and clean up. Since that may take some time, such as
+// when the server is unreachable, a further signal received on sigs exits immediately using exit,
+// with ExitInterrupted, once standard error is flushed.
+func cancelOnSignal(sig os.Signal, sigs <-chan os.Signal, cancel context.CancelFunc, exit func(int)) {
+	fmt.Fprintf(os.Stderr, "Shutting down due to signal: %v (press Ctrl-C again to force quit)\n", sig)
+	cancel()
+
+	if sig, ok := <-sigs; ok {
+		fmt.Fprintf(os.Stderr, "Exiting immediately due to signal: %v\n", sig)
+		_ = os.Stderr.Sync()
+		exit(ExitInterrupted)
+	}
+}
+
+// newSignalContext returns a context that is cancelled when the process is interrupted. A further
+// interrupt exits immediately. See cancelOnSignal.
+func newSignalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		if sig, ok := <-sigs; ok {
+			cancelOnSignal(sig, sigs, cancel, os.Exit)
+		}
+	}()
+
+	return ctx, sync.OnceFunc(func() {
+		signal.Stop(sigs)
+		close(sigs)
+		cancel()
+	})
+}
+
+// deleteBuildContext deletes the build context with the specified digest. The context is deleted
+// even if ctx is done, such as when the run is interrupted, so that it does not linger on the
+// Build Service. Failure is ignored, since unused build contexts are eventually removed anyway.
+func (app *App) deleteBuildContext(ctx context.Context, digest string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), contextDeleteTimeout)
+	defer cancel()
+
+	// A concurrent run uploading the same build context may be relying on it being present.
+	unlockContext, err := app.lockContextDigest(ctx, digest)
+	if err != nil {
+		return
+	}
+	defer unlockContext()
+
+	_ = app.buildClient.DeleteBuildContext(ctx, digest)
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// interruptWriter is an io.Writer that cancels the run once marker is written.
+type interruptWriter struct {
+	marker string
+	once   sync.Once
+	cancel context.CancelFunc
+}
+
+func (w *interruptWriter) Write(p []byte) (int, error) {
+	if bytes.Contains(p, []byte(w.marker)) {
+		w.once.Do(w.cancel)
+	}
+	return len(p), nil
+}
+
+func TestApp_RunInterrupted(t *testing.T) {
+	tests := []struct {
+		name  string
+		batch bool
+	}{
+		{name: "Single"},
+		{name: "Batch", batch: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.hangBuild = true
+			m.output = []string{"Building...\n"}
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			m.files = []string{defFile}
+
+			cfg := &Config{
+				URL:          m.frontend.URL,
+				BuildSpec:    defFile,
+				LibraryRef:   filepath.Join(dir, "image.sif"),
+				ArchsToBuild: []string{"amd64", "arm64"},
+			}
+			if tt.batch {
+				cfg.BuildSpec = ""
+				cfg.DefFiles = []string{defFile, defFile}
+				cfg.LibraryRef = filepath.Join(dir, "{name}.sif")
+				cfg.ArchsToBuild = []string{"amd64"}
+			}
+
+			app, err := New(context.Background(), cfg)
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// Interrupt the run once the first build is running.
+			app.stdout = &interruptWriter{marker: "Building...", cancel: cancel}
+
+			err = app.Run(ctx)
+			if !errors.Is(err, errInterrupted) {
+				t.Fatalf("got error %v, want %v", err, errInterrupted)
+			}
+			assert.Equal(t, ExitInterrupted, ExitCode(err))
+
+			// The running build is cancelled, and no further builds submitted.
+			assert.Equal(t, int64(1), m.submits.Load())
+			assert.Positive(t, m.cancels.Load())
+
+			// The build context is deleted, despite the interrupt.
+			assert.Equal(t, int64(1), m.contextUploads.Load())
+			assert.Equal(t, int64(1), m.contextDeletes.Load())
+
+			// No image, or partial image, is left behind.
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if assert.Len(t, entries, 1) {
+				assert.Equal(t, "alpine.def", entries[0].Name())
+			}
+		})
+	}
+}
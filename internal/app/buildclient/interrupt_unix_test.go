@@ -0,0 +1,33 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+//go:build unix
+
+package buildclient
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNewSignalContext(t *testing.T) {
+	ctx, stop := newSignalContext()
+	defer stop()
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("context not cancelled")
+	}
+
+	// Once stopped, further signals are no longer awaited, so do not exit.
+	stop()
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// envPrefix is the prefix of environment variables corresponding to flags.
+const envPrefix = "sylabs"
+
+// legacyName records the flag and/or environment variable names under which the flag with the
+// specified key was previously known.
+type legacyName struct {
+	key  string // Current flag.
+	flag string // Previous flag name, if any.
+	env  string // Previous environment variable name, if any.
+}
+
+// legacyNames lists flag and environment variable names used by previous releases, which continue
+// to take effect with a deprecation warning. To rename a flag, add its previous name here.
+//
+// Flags that kept their names, such as --keyidx and its -k shorthand, need no entry, nor do the
+// environment variables derived from them. See envName.
+var legacyNames []legacyName
+
+// keyImageSpec is the flag by which previous releases accepted the image path, which is now
+// specified as an argument. See imageSpecArgs.
+const keyImageSpec = "image-spec"
+
+var errImageSpecSpecified = errors.New("image path specified as both argument and --image-spec")
+
+// envName returns the environment variable corresponding to the flag with the specified key.
+func envName(key string) string {
+	return strings.ToUpper(envPrefix + "_" + strings.ReplaceAll(key, "-", "_"))
+}
+
+// addLegacyFlags adds hidden aliases for the previous names of flags defined on cmd. An alias
+// shares the value of the flag it aliases.
+func addLegacyFlags(cmd *cobra.Command) {
+	for _, ln := range legacyNames {
+		f := cmd.Flags().Lookup(ln.key)
+		if f == nil || ln.flag == "" {
+			continue
+		}
+
+		cmd.Flags().AddFlag(&pflag.Flag{
+			Name:        ln.flag,
+			Usage:       f.Usage,
+			Value:       f.Value,
+			DefValue:    f.DefValue,
+			NoOptDefVal: f.NoOptDefVal,
+			Hidden:      true,
+		})
+	}
+}
+
+// addImageSpecFlag adds the hidden --image-spec flag to cmd.
+func addImageSpecFlag(cmd *cobra.Command) {
+	cmd.Flags().String(keyImageSpec, "", "Image path (deprecated, specify <image path> instead)")
+	_ = cmd.Flags().MarkHidden(keyImageSpec)
+}
+
+// imageSpecArgs returns args, with the image path set by --image-spec on cmd, if any, appended, so
+// that it is handled exactly as an image path specified as an argument. A deprecation warning is
+// written to w. If the image path is also specified as an argument, or by --output, an error is
+// returned.
+func imageSpecArgs(cmd *cobra.Command, args []string, output string, w io.Writer) ([]string, error) {
+	f := cmd.Flags().Lookup(keyImageSpec)
+	if f == nil || !f.Changed {
+		return args, nil
+	}
+
+	fmt.Fprintf(w, "Warning: --%v is deprecated, specify the image path as an argument instead\n", keyImageSpec)
+
+	if output != "" {
+		return nil, errOutputSpecified
+	}
+
+	// Definition files take any number of arguments, so only a build spec precludes another.
+	if !cmd.Flags().Changed(keyDefFile) && len(args) > 1 {
+		return nil, errImageSpecSpecified
+	}
+
+	return append(args, f.Value.String()), nil
+}
+
+// applyLegacyNames applies previous flag and environment variable names set for cmd to v, writing
+// a deprecation warning naming the replacement to w for each.
+func applyLegacyNames(cmd *cobra.Command, v *viper.Viper, w io.Writer) error {
+	for _, ln := range legacyNames {
+		f := cmd.Flags().Lookup(ln.key)
+		if f == nil {
+			continue
+		}
+
+		if ln.flag != "" {
+			// The alias shares the flag's value, so only the flag need be marked as set.
+			if alias := cmd.Flags().Lookup(ln.flag); alias != nil && alias.Changed {
+				fmt.Fprintf(w, "Warning: --%v is deprecated, use --%v instead\n", ln.flag, ln.key)
+				f.Changed = true
+			}
+		}
+
+		if ln.env != "" {
+			if _, ok := os.LookupEnv(ln.env); ok {
+				fmt.Fprintf(w, "Warning: %v is deprecated, use %v instead\n", ln.env, envName(ln.key))
+			}
+
+			// The current name takes precedence, if both are set.
+			if err := v.BindEnv(ln.key, envName(ln.key), ln.env); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
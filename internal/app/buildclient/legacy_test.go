@@ -0,0 +1,184 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+// newLegacyTestCommand returns a command defining the flags that have legacy names, along with those
+// that kept their names.
+func newLegacyTestCommand() *cobra.Command {
+	cmd := &cobra.Command{Use: "test"}
+	addConnectionFlags(cmd)
+	cmd.Flags().IntP(keySigningKeyIndex, "k", -1, "")
+	addLegacyFlags(cmd)
+	return cmd
+}
+
+func TestLegacyNames(t *testing.T) {
+	// A rename of --auth-token, and its environment variable, exercises the environment variable
+	// aliases that no current entry uses.
+	defer func(names []legacyName) { legacyNames = names }(legacyNames)
+	legacyNames = append(legacyNames, legacyName{key: keyAccessToken, flag: "old-auth-token", env: "SYLABS_OLD_AUTH_TOKEN"})
+
+	tests := []struct {
+		name        string
+		args        []string
+		env         map[string]string
+		key         string
+		want        any
+		wantWarning string
+	}{
+		{"KeyIndexFlag", []string{"--keyidx", "2"}, nil, keySigningKeyIndex, 2, ""},
+		{"KeyIndexShortFlag", []string{"-k", "2"}, nil, keySigningKeyIndex, 2, ""},
+		{"KeyIndexEnv", nil, map[string]string{"SYLABS_KEYIDX": "2"}, keySigningKeyIndex, 2, ""},
+		{"RenamedFlag", []string{"--old-auth-token", "token"}, nil, keyAccessToken, "token", "--old-auth-token"},
+		{"RenamedEnv", nil, map[string]string{"SYLABS_OLD_AUTH_TOKEN": "token"}, keyAccessToken, "token", "SYLABS_OLD_AUTH_TOKEN"},
+		{"CurrentEnvPrecedence", nil, map[string]string{"SYLABS_AUTH_TOKEN": "current", "SYLABS_OLD_AUTH_TOKEN": "legacy"}, keyAccessToken, "current", "SYLABS_OLD_AUTH_TOKEN"},
+		{"CurrentFlag", []string{"--auth-token", "token"}, nil, keyAccessToken, "token", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			cmd := newLegacyTestCommand()
+
+			var b bytes.Buffer
+			cmd.SetErr(&b)
+
+			if err := cmd.ParseFlags(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			v, err := getConfig(cmd)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			switch want := tt.want.(type) {
+			case string:
+				assert.Equal(t, want, v.GetString(tt.key))
+			case bool:
+				assert.Equal(t, want, v.GetBool(tt.key))
+			case int:
+				assert.Equal(t, want, v.GetInt(tt.key))
+			}
+
+			if tt.wantWarning == "" {
+				assert.Empty(t, b.String())
+				return
+			}
+
+			assert.Equal(t, 1, strings.Count(b.String(), "deprecated"), "warnings: %q", b.String())
+			assert.Contains(t, b.String(), tt.wantWarning+" is deprecated")
+		})
+	}
+}
+
+func TestBuildCommandImageSpec(t *testing.T) {
+	// Capture the Config of each run, rather than building.
+	errCaptured := errors.New("config captured")
+
+	var cfg *Config
+	defer func(f func(context.Context, *Config) (*App, error)) { newApp = f }(newApp)
+	newApp = func(_ context.Context, c *Config) (*App, error) {
+		cfg = c
+		return nil, errCaptured
+	}
+
+	rootCmd := buildTestRoot()
+
+	run := func(args []string) (*Config, string, error) {
+		defer resetBuildFlags()
+
+		var b bytes.Buffer
+		rootCmd.SetArgs(append([]string{"build"}, args...))
+		rootCmd.SetOut(io.Discard)
+		rootCmd.SetErr(&b)
+
+		cfg = nil
+		if err := rootCmd.Execute(); !errors.Is(err, errCaptured) {
+			return nil, b.String(), err
+		}
+		return cfg, b.String(), nil
+	}
+
+	tests := []struct {
+		name    string
+		legacy  []string
+		current []string
+		wantErr error
+	}{
+		{
+			name:    "LibraryRef",
+			legacy:  []string{"--image-spec", "library:user/project/image:tag", "alpine.def"},
+			current: []string{"alpine.def", "library:user/project/image:tag"},
+		},
+		{
+			name:    "ImagePath",
+			legacy:  []string{"alpine.def", "--image-spec", "image.sif", "--force"},
+			current: []string{"--force", "alpine.def", "image.sif"},
+		},
+		{
+			name:    "DefFiles",
+			legacy:  []string{"--def-file", "a.def", "--image-spec", "library:user/project/image", "b.def"},
+			current: []string{"--def-file", "a.def", "b.def", "library:user/project/image"},
+		},
+		{
+			name:    "ImagePathArgument",
+			legacy:  []string{"--image-spec", "a.sif", "alpine.def", "b.sif"},
+			wantErr: errImageSpecSpecified,
+		},
+		{
+			name:    "Output",
+			legacy:  []string{"--image-spec", "a.sif", "--output", "b.sif", "alpine.def"},
+			current: []string{"--output", "b.sif", "alpine.def", "a.sif"},
+			wantErr: errOutputSpecified,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, warnings, err := run(tt.legacy)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			assert.Contains(t, warnings, "--image-spec is deprecated")
+
+			if tt.current == nil {
+				return
+			}
+
+			// The legacy form is equivalent to the current one.
+			want, warnings, err := run(tt.current)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			assert.Empty(t, warnings)
+
+			if err != nil {
+				return
+			}
+
+			// The support bundle records flags as specified.
+			delete(got.BundleSettings, keyImageSpec)
+			delete(want.BundleSettings, keyImageSpec)
+			assert.Equal(t, want, got)
+		})
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	build "github.com/sylabs/scs-build-client/client"
+)
+
+const (
+	keyLimit     = "limit"
+	keyBefore    = "before"
+	keyCompleted = "completed"
+	keyJSON      = "json"
+)
+
+var listCmd = &cobra.Command{
+	Use:   "list [flags]",
+	Short: "List builds submitted by the caller",
+	Args:  cobra.NoArgs,
+	RunE:  executeListCmd,
+	Example: `
+  List the 20 most recently submitted builds:
+
+      scs-build list --limit 20
+
+  List the next 20 builds, submitted before the last build listed:
+
+      scs-build list --limit 20 --before <build ID>
+
+  List completed builds as JSON:
+
+      scs-build list --completed --json`,
+}
+
+// AddListCommand adds the list command to rootCmd.
+func AddListCommand(rootCmd *cobra.Command) {
+	addConnectionFlags(listCmd)
+	listCmd.Flags().Int(keyLimit, 0, "Maximum number of builds to list (defaults to that selected by the Build Service)")
+	listCmd.Flags().String(keyBefore, "", "Only list builds submitted before the build with this ID")
+	listCmd.Flags().Bool(keyCompleted, false, "Only list completed builds")
+	listCmd.Flags().Bool(keyJSON, false, "Write builds as JSON, rather than a table")
+	addLegacyFlags(listCmd)
+
+	rootCmd.AddCommand(listCmd)
+}
+
+func executeListCmd(cmd *cobra.Command, _ []string) error {
+	v, err := getConfig(cmd)
+	if err != nil {
+		return fmt.Errorf("error getting config: %w", err)
+	}
+
+	var opts []build.ListBuildsOption
+	if cmd.Flags().Changed(keyLimit) {
+		opts = append(opts, build.OptListLimit(v.GetInt(keyLimit)))
+	}
+	if before := v.GetString(keyBefore); before != "" {
+		opts = append(opts, build.OptListBefore(before))
+	}
+	if v.GetBool(keyCompleted) {
+		opts = append(opts, build.OptListCompleted(true))
+	}
+
+	ctx, cancel := newSignalContext()
+	defer cancel()
+
+	app, err := New(ctx, connectionConfig(v))
+	if err != nil {
+		return fmt.Errorf("application init error: %w", err)
+	}
+
+	return app.translateTLSErr(app.ListBuilds(ctx, os.Stdout, v.GetBool(keyJSON), opts...))
+}
+
+// buildSummary describes a build, as listed by ListBuilds.
+type buildSummary struct {
+	ID         string     `json:"id"`
+	State      string     `json:"state"`
+	Arch       string     `json:"arch,omitempty"`
+	SubmitTime *time.Time `json:"submitTime,omitempty"`
+}
+
+// buildState returns the state of the build described by bi. Older Build Service versions do not
+// report state, in which case it is derived from whether the build is complete.
+func buildState(bi *build.BuildInfo) string {
+	switch {
+	case bi.State() != build.BuildStateUnknown:
+		return string(bi.State())
+	case !bi.IsComplete():
+		return "incomplete"
+	case bi.Failed():
+		return string(build.BuildStateFailed)
+	default:
+		return string(build.BuildStateSucceeded)
+	}
+}
+
+// summarizeBuild returns a summary of the build described by bi.
+func summarizeBuild(bi *build.BuildInfo) buildSummary {
+	bs := buildSummary{
+		ID:    bi.ID(),
+		State: buildState(bi),
+		Arch:  bi.Arch(),
+	}
+	if t := bi.SubmitTime(); !t.IsZero() {
+		bs.SubmitTime = &t
+	}
+	return bs
+}
+
+// formatAge returns the time elapsed between t and now, or "unknown" if t is nil.
+func formatAge(t *time.Time, now time.Time) string {
+	if t == nil {
+		return "unknown"
+	}
+	return formatDuration(now.Sub(*t).Truncate(time.Second))
+}
+
+// ListBuilds writes a table describing builds submitted by the caller, most recently submitted
+// first, to w. If asJSON is set, the builds are written as a JSON array instead.
+func (app *App) ListBuilds(ctx context.Context, w io.Writer, asJSON bool, opts ...build.ListBuildsOption) error {
+	bis, err := app.buildClient.GetBuilds(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("error listing builds: %w", app.wrapBuildErr(err))
+	}
+
+	bss := make([]buildSummary, 0, len(bis))
+	for i := range bis {
+		bss = append(bss, summarizeBuild(&bis[i]))
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(bss)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	defer tw.Flush()
+
+	fmt.Fprintf(tw, "ID\tSTATE\tARCH\tAGE\n")
+
+	now := time.Now()
+	for _, bs := range bss {
+		arch := bs.Arch
+		if arch == "" {
+			arch = "unknown"
+		}
+
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", bs.ID, bs.State, arch, formatAge(bs.SubmitTime, now))
+	}
+
+	return nil
+}
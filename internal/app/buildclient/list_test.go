@@ -0,0 +1,120 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	build "github.com/sylabs/scs-build-client/client"
+)
+
+func TestApp_ListBuilds(t *testing.T) {
+	submitTime := time.Now().Add(-90 * time.Minute)
+
+	builds := []mockBuild{
+		{ID: "3", State: build.BuildStateBuilding, Arch: "amd64", SubmitTime: &submitTime},
+		{ID: "2", IsComplete: true, State: build.BuildStateFailed, Arch: "arm64"},
+		{ID: "1", IsComplete: true}, // Reported by older Build Service versions.
+	}
+
+	tests := []struct {
+		name        string
+		noBuildList bool
+		opts        []build.ListBuildsOption
+		wantLines   []string
+		wantErr     error
+	}{
+		{
+			name: "All",
+			wantLines: []string{
+				"ID  STATE     ARCH     AGE",
+				"3   building  amd64    1h30m",
+				"2   failed    arm64    unknown",
+				"1   failed    unknown  unknown",
+			},
+		},
+		{
+			name: "Completed",
+			opts: []build.ListBuildsOption{build.OptListCompleted(true)},
+			wantLines: []string{
+				"ID  STATE   ARCH     AGE",
+				"2   failed  arm64    unknown",
+				"1   failed  unknown  unknown",
+			},
+		},
+		{
+			name:        "NotSupported",
+			noBuildList: true,
+			wantErr:     build.ErrNotSupported,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.builds = builds
+			m.noBuildList = tt.noBuildList
+
+			app, err := New(context.Background(), &Config{URL: m.frontend.URL})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			var b bytes.Buffer
+			if got, want := app.ListBuilds(context.Background(), &b, false, tt.opts...), tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if tt.wantErr != nil {
+				return
+			}
+
+			lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+			for i := range lines {
+				lines[i] = strings.TrimRight(lines[i], " ")
+			}
+			assert.Equal(t, tt.wantLines, lines)
+		})
+	}
+}
+
+func TestApp_ListBuildsJSON(t *testing.T) {
+	submitTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	m := newMockServers(t)
+	m.builds = []mockBuild{
+		{ID: "2", State: build.BuildStateQueued, Arch: "amd64", SubmitTime: &submitTime},
+		{ID: "1", IsComplete: true, State: build.BuildStateSucceeded},
+	}
+
+	app, err := New(context.Background(), &Config{URL: m.frontend.URL})
+	if err != nil {
+		t.Fatalf("initialization error: %v", err)
+	}
+
+	var b bytes.Buffer
+	if err := app.ListBuilds(context.Background(), &b, true); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []buildSummary
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []buildSummary{
+		{ID: "2", State: "queued", Arch: "amd64", SubmitTime: &submitTime},
+		{ID: "1", State: "succeeded"},
+	}
+	assert.Equal(t, want, got)
+}
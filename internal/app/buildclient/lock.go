@@ -0,0 +1,113 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	build "github.com/sylabs/scs-build-client/client"
+	"github.com/sylabs/scs-build-client/internal/pkg/statedir"
+)
+
+// unlockAll returns a function that releases locks, in reverse order of acquisition.
+func unlockAll(locks []*statedir.Lock) func() {
+	return func() {
+		for i := len(locks) - 1; i >= 0; i-- {
+			if err := locks[i].Unlock(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error releasing lock: %v\n", err)
+			}
+		}
+	}
+}
+
+// lockOutputs acquires the locks on the metadata file and destination of the run, blocking while
+// they are held by a concurrent run. The returned function releases the locks. If no state
+// directory is configured, no locks are acquired.
+func (app *App) lockOutputs(ctx context.Context) (func(), error) {
+	var locks []*statedir.Lock
+
+	if app.stateDir == nil {
+		return unlockAll(locks), nil
+	}
+
+	// Locks are always acquired in the same order, so that concurrent runs cannot deadlock.
+	if app.resumeFile != "" {
+		l, err := app.stateDir.LockMetadata(ctx, app.resumeFile)
+		if err != nil {
+			return nil, err
+		}
+		locks = append(locks, l)
+	}
+
+	dst := app.dstFileName
+	if app.libraryRef != nil {
+		dst = app.libraryRef.String()
+	}
+
+	if dst != "" && dst != stdoutDestination {
+		l, err := app.stateDir.LockDestination(ctx, dst)
+		if err != nil {
+			unlockAll(locks)()
+			return nil, err
+		}
+		locks = append(locks, l)
+	}
+
+	return unlockAll(locks), nil
+}
+
+// contextDigest returns a digest identifying the build context containing sources, by their paths.
+// Contents are not considered, so it identifies build contexts within a single run only.
+func contextDigest(sources []FileTransport) (string, error) {
+	paths := make([]string, 0, len(sources))
+	for _, ft := range sources {
+		path, err := ft.SourcePath()
+		if err != nil {
+			return "", fmt.Errorf("error parsing def file: %w", err)
+		}
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+
+	sum := sha256.Sum256([]byte(strings.Join(paths, "\x00")))
+	return "sha256." + hex.EncodeToString(sum[:]), nil
+}
+
+// lockContext acquires the lock on the build context containing files, blocking while it is held
+// by a concurrent run. The lock is keyed on the digest of the build context, so that only runs
+// uploading, reusing or deleting a build context with identical contents are serialized. The
+// returned function releases the lock. If no state directory is configured, no lock is acquired.
+func (app *App) lockContext(ctx context.Context, files []string) (func(), error) {
+	if app.stateDir == nil {
+		return unlockAll(nil), nil
+	}
+
+	digest, _, err := build.DigestBuildContext(files, app.contextArchiveOpts()...)
+	if err != nil {
+		return nil, fmt.Errorf("error generating build context: %w", err)
+	}
+	return app.lockContextDigest(ctx, digest)
+}
+
+// lockContextDigest acquires the lock on the build context with the specified digest, as described
+// by lockContext.
+func (app *App) lockContextDigest(ctx context.Context, digest string) (func(), error) {
+	if app.stateDir == nil {
+		return unlockAll(nil), nil
+	}
+
+	l, err := app.stateDir.LockContext(ctx, digest)
+	if err != nil {
+		return nil, err
+	}
+	return unlockAll([]*statedir.Lock{l}), nil
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sylabs/scs-build-client/internal/pkg/statedir"
+)
+
+func TestApp_RunConcurrent(t *testing.T) {
+	m := newMockServers(t)
+
+	dir := t.TempDir()
+	stateDir := t.TempDir()
+
+	defFile := filepath.Join(dir, "alpine.def")
+	if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m.files = []string{defFile}
+
+	const runs = 4
+
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, runs)
+	)
+
+	for i := 0; i < runs; i++ {
+		app, err := New(context.Background(), &Config{
+			URL:          m.frontend.URL,
+			BuildSpec:    defFile,
+			LibraryRef:   filepath.Join(dir, "image.sif"),
+			ArchsToBuild: []string{"amd64"},
+			StateDir:     stateDir,
+		})
+		if err != nil {
+			t.Fatalf("initialization error: %v", err)
+		}
+
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			errs[i] = app.Run(context.Background())
+		}(i)
+	}
+
+	wg.Wait()
+
+	var succeeded int
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+		} else if !strings.Contains(err.Error(), "already exists") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	if got, want := succeeded, 1; got != want {
+		t.Errorf("got %v successful runs, want %v", got, want)
+	}
+
+	if got, want := m.submits.Load(), int64(1); got != want {
+		t.Errorf("got %v submits, want %v", got, want)
+	}
+
+	if got, want := m.contextUploads.Load(), m.contextDeletes.Load(); got != want {
+		t.Errorf("got %v context uploads, %v deletes", got, want)
+	}
+}
+
+func TestContextDigest(t *testing.T) {
+	a := FileTransport{Src: "/a"}
+	b := FileTransport{Src: "/b"}
+
+	ab, err := contextDigest([]FileTransport{a, b})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ba, err := contextDigest([]FileTransport{b, a})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ab != ba {
+		t.Errorf("digest depends on order: %v != %v", ab, ba)
+	}
+
+	only, err := contextDigest([]FileTransport{a})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if only == ab {
+		t.Errorf("digest does not depend on sources")
+	}
+}
+
+func TestApp_LockContext(t *testing.T) {
+	d, err := statedir.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &App{stateDir: d}
+
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := app.lockContext(context.Background(), []string{path[1:]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	tryLock := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+
+		unlock, err := app.lockContext(ctx, []string{path[1:]})
+		if err == nil {
+			unlock()
+		}
+		return err
+	}
+
+	// The same contents share a lock.
+	if err := tryLock(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The same path with different contents does not.
+	if err := os.WriteFile(path, []byte("b"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tryLock(); err != nil {
+		t.Fatal(err)
+	}
+}
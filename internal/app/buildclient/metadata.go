@@ -0,0 +1,101 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Metadata records the outcome of a run. It is used to resume a run that was interrupted, or in
+// which the build failed for one or more architectures.
+type Metadata struct {
+	DefinitionDigest       string         `json:"definitionDigest"`
+	SourceDefinitionDigest string         `json:"sourceDefinitionDigest,omitempty"` // Digest of the definition before preprocessing, if preprocessed.
+	ContextDigest          string         `json:"contextDigest,omitempty"`
+	ContextSource          string         `json:"contextSource,omitempty"` // Git repository the build context was fetched from, if any.
+	ContextCommit          string         `json:"contextCommit,omitempty"` // Commit SHA of ContextSource.
+	Notices                []Notice       `json:"notices,omitempty"`       // Published by the frontend and Build Service at the start of the run.
+	Archs                  []ArchMetadata `json:"archs"`
+
+	// Bytes transferred by the run, summed across architectures. See TransferMetrics.
+	BytesUploaded   int64 `json:"bytesUploaded,omitempty"`
+	BytesDownloaded int64 `json:"bytesDownloaded,omitempty"`
+}
+
+// ArchMetadata records the outcome of a build for a single architecture.
+type ArchMetadata struct {
+	Arch             string `json:"arch"`
+	Succeeded        bool   `json:"succeeded"`
+	BuildID          string `json:"buildID,omitempty"`
+	LibraryRef       string `json:"libraryRef,omitempty"`
+	LibraryURL       string `json:"libraryURL,omitempty"`
+	ImageChecksum    string `json:"imageChecksum,omitempty"`
+	FileChecksum     string `json:"fileChecksum,omitempty"` // Of the image delivered, which differs from ImageChecksum if signed.
+	ImageSize        int64  `json:"imageSize,omitempty"`
+	DownloadChecksum string `json:"downloadChecksum,omitempty"` // Computed locally, using --download-hash.
+	DefinitionDigest string `json:"definitionDigest,omitempty"` // Of the definition built, as reported by the Build Service, if supported.
+	FileName         string `json:"fileName,omitempty"`
+	Error            string `json:"error,omitempty"`
+	FailurePhase     string `json:"failurePhase,omitempty"` // Category of Error. See failurePhase.
+	OutputTail       string `json:"outputTail,omitempty"`
+	OutputTruncated  bool   `json:"outputTruncated,omitempty"` // Build output exceeded --max-output-bytes.
+	BytesUploaded    int64  `json:"bytesUploaded,omitempty"`   // Image uploaded, once signed.
+	BytesDownloaded  int64  `json:"bytesDownloaded,omitempty"` // Image downloaded.
+	Skipped          bool   `json:"skipped,omitempty"`         // Not built, since a stop was requested. See App.Stop.
+}
+
+// arch returns the recorded outcome for the named architecture, or nil if none is recorded.
+func (md *Metadata) arch(name string) *ArchMetadata {
+	for i := range md.Archs {
+		if md.Archs[i].Arch == name {
+			return &md.Archs[i]
+		}
+	}
+	return nil
+}
+
+// setArch records am, replacing any existing outcome for the same architecture.
+func (md *Metadata) setArch(am ArchMetadata) {
+	if e := md.arch(am.Arch); e != nil {
+		*e = am
+		return
+	}
+	md.Archs = append(md.Archs, am)
+}
+
+// readMetadata reads metadata from the named file.
+func readMetadata(name string) (*Metadata, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var md Metadata
+	if err := json.Unmarshal(b, &md); err != nil {
+		return nil, fmt.Errorf("error parsing metadata file %v: %w", name, err)
+	}
+	return &md, nil
+}
+
+// writeMetadata writes md to the named file.
+func writeMetadata(name string, md *Metadata) error {
+	b, err := json.MarshalIndent(md, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(b, '\n'), 0o644) //nolint:gosec
+}
+
+// definitionDigest returns the digest of the raw definition def.
+func definitionDigest(def []byte) string {
+	return fmt.Sprintf("sha256.%x", sha256.Sum256(def))
+}
+
+var errChecksumMismatch = errors.New("checksum mismatch")
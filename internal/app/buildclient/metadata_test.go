@@ -0,0 +1,315 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sylabs/sif/v2/pkg/integrity"
+)
+
+func TestApp_RunResume(t *testing.T) {
+	const def = "bootstrap: docker\nfrom: alpine:3\n"
+
+	archs := []string{"amd64", "arm64"}
+
+	tests := []struct {
+		name             string
+		definition       string
+		metadata         func(dir string) *Metadata
+		files            map[string][]byte // Files present in dir prior to run.
+		contextFiles     bool
+		force            bool
+		forceResume      bool
+		wantErr          error
+		wantSubmits      int64
+		wantUploads      int64
+		wantContextStale bool
+	}{
+		{
+			name:        "NoMetadataFile",
+			wantSubmits: 2,
+		},
+		{
+			name: "AllSucceeded",
+			metadata: func(dir string) *Metadata {
+				return &Metadata{
+					DefinitionDigest: definitionDigest([]byte(def)),
+					Archs: []ArchMetadata{
+						succeededArch(dir, "amd64"),
+						succeededArch(dir, "arm64"),
+					},
+				}
+			},
+			files: map[string][]byte{
+				"image-amd64.sif": mockImage,
+				"image-arm64.sif": mockImage,
+			},
+			wantSubmits: 0,
+		},
+		{
+			name: "DestinationDeleted",
+			metadata: func(dir string) *Metadata {
+				return &Metadata{
+					DefinitionDigest: definitionDigest([]byte(def)),
+					Archs: []ArchMetadata{
+						succeededArch(dir, "amd64"),
+						succeededArch(dir, "arm64"),
+					},
+				}
+			},
+			files: map[string][]byte{
+				"image-amd64.sif": mockImage,
+			},
+			wantSubmits: 1,
+		},
+		{
+			name: "DestinationModified",
+			metadata: func(dir string) *Metadata {
+				return &Metadata{
+					DefinitionDigest: definitionDigest([]byte(def)),
+					Archs: []ArchMetadata{
+						succeededArch(dir, "amd64"),
+						succeededArch(dir, "arm64"),
+					},
+				}
+			},
+			files: map[string][]byte{
+				"image-amd64.sif": mockImage,
+				"image-arm64.sif": []byte("truncated"),
+			},
+			force:       true,
+			wantSubmits: 1,
+		},
+		{
+			name: "FailedArch",
+			metadata: func(dir string) *Metadata {
+				return &Metadata{
+					DefinitionDigest: definitionDigest([]byte(def)),
+					Archs: []ArchMetadata{
+						succeededArch(dir, "amd64"),
+						{Arch: "arm64", Error: "failed to build image"},
+					},
+				}
+			},
+			files: map[string][]byte{
+				"image-amd64.sif": mockImage,
+			},
+			wantSubmits: 1,
+		},
+		{
+			name:       "DefinitionChanged",
+			definition: "bootstrap: docker\nfrom: alpine:edge\n",
+			metadata: func(dir string) *Metadata {
+				return &Metadata{
+					DefinitionDigest: definitionDigest([]byte(def)),
+					Archs: []ArchMetadata{
+						succeededArch(dir, "amd64"),
+					},
+				}
+			},
+			files: map[string][]byte{
+				"image-amd64.sif": mockImage,
+			},
+			wantErr:     errDefinitionChanged,
+			wantSubmits: 0,
+		},
+		{
+			name:       "DefinitionChangedForceResume",
+			definition: "bootstrap: docker\nfrom: alpine:edge\n",
+			metadata: func(dir string) *Metadata {
+				return &Metadata{
+					DefinitionDigest: definitionDigest([]byte(def)),
+					Archs: []ArchMetadata{
+						succeededArch(dir, "amd64"),
+					},
+				}
+			},
+			files: map[string][]byte{
+				"image-amd64.sif": mockImage,
+			},
+			forceResume: true,
+			wantSubmits: 1,
+		},
+		{
+			name: "ContextPurged",
+			metadata: func(dir string) *Metadata {
+				return &Metadata{
+					DefinitionDigest: definitionDigest([]byte(def)),
+					ContextDigest:    "sha256.0000000000000000000000000000000000000000000000000000000000000000",
+					Archs: []ArchMetadata{
+						succeededArch(dir, "amd64"),
+						{Arch: "arm64", Error: "failed to build image"},
+					},
+				}
+			},
+			files: map[string][]byte{
+				"image-amd64.sif": mockImage,
+			},
+			contextFiles:     true,
+			wantSubmits:      1,
+			wantUploads:      1,
+			wantContextStale: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+
+			dir := t.TempDir()
+
+			definition := def
+			if tt.definition != "" {
+				definition = tt.definition
+			}
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte(definition), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.contextFiles {
+				m.files = []string{defFile}
+			}
+
+			for name, b := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), b, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			resumeFile := filepath.Join(dir, "metadata.json")
+
+			var prev *Metadata
+			if tt.metadata != nil {
+				prev = tt.metadata(dir)
+				if err := writeMetadata(resumeFile, prev); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			app, err := New(context.Background(), &Config{
+				URL:          m.frontend.URL,
+				BuildSpec:    defFile,
+				LibraryRef:   filepath.Join(dir, "image.sif"),
+				Force:        tt.force,
+				ArchsToBuild: archs,
+				ResumeFile:   resumeFile,
+				ForceResume:  tt.forceResume,
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			err = app.Run(context.Background())
+
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if got, want := m.submits.Load(), tt.wantSubmits; got != want {
+				t.Errorf("got %v submits, want %v", got, want)
+			}
+
+			if got, want := m.contextUploads.Load(), tt.wantUploads; got != want {
+				t.Errorf("got %v context uploads, want %v", got, want)
+			}
+
+			if err != nil {
+				return
+			}
+
+			md, err := readMetadata(resumeFile)
+			if err != nil {
+				t.Fatalf("failed to read metadata: %v", err)
+			}
+
+			if got, want := len(md.Archs), len(archs); got != want {
+				t.Fatalf("got %v archs in metadata, want %v", got, want)
+			}
+
+			for _, arch := range archs {
+				am := md.arch(arch)
+				if am == nil || !am.Succeeded {
+					t.Errorf("arch %v not recorded as succeeded", arch)
+					continue
+				}
+
+				if err := verifyFileChecksum(am.FileName, am.ImageChecksum); err != nil {
+					t.Errorf("arch %v: %v", arch, err)
+				}
+			}
+
+			if tt.wantContextStale {
+				if md.ContextDigest == "" || md.ContextDigest == prev.ContextDigest {
+					t.Errorf("got context digest %v, want fresh digest", md.ContextDigest)
+				}
+			}
+		})
+	}
+}
+
+// succeededArch returns metadata describing a successful build for arch, written to dir.
+func succeededArch(dir, arch string) ArchMetadata {
+	return ArchMetadata{
+		Arch:          arch,
+		Succeeded:     true,
+		BuildID:       mockBuildID,
+		LibraryRef:    mockLibraryRef,
+		ImageChecksum: imageChecksum(),
+		FileName:      filepath.Join(dir, "image-"+arch+".sif"),
+	}
+}
+
+func TestApp_RunResumeSigned(t *testing.T) {
+	// Serve a SIF image, so that it can be signed.
+	b, err := os.ReadFile(filepath.Join("testdata", "deffile.sif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(b []byte) { mockImage = b }(mockImage)
+	mockImage = b
+
+	m := newMockServers(t)
+
+	dir := t.TempDir()
+
+	defFile := filepath.Join(dir, "alpine.def")
+	if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		URL:          m.frontend.URL,
+		BuildSpec:    defFile,
+		LibraryRef:   filepath.Join(dir, "image.sif"),
+		ArchsToBuild: []string{"amd64"},
+		ResumeFile:   filepath.Join(dir, "metadata.json"),
+		SignerOpts:   []integrity.SignerOpt{integrity.OptSignWithEntity(newTestEntity(t))},
+	}
+
+	// The signed image differs from that built, so is verified against the checksum of the image
+	// delivered when resumed, rather than rebuilt.
+	for i := 0; i < 2; i++ {
+		app, err := New(context.Background(), cfg)
+		if err != nil {
+			t.Fatalf("initialization error: %v", err)
+		}
+
+		if err := app.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, want := m.submits.Load(), int64(1); got != want {
+		t.Errorf("got %v submits, want %v", got, want)
+	}
+}
@@ -0,0 +1,823 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	jsonresp "github.com/sylabs/json-resp"
+	build "github.com/sylabs/scs-build-client/client"
+	"github.com/sylabs/scs-build-client/internal/pkg/endpoints"
+	library "github.com/sylabs/scs-library-client/client"
+)
+
+const (
+	mockBuildID    = "6387923149ab6b512d0326f3"
+	mockLibraryRef = "entity/collection/container:tag"
+	mockRequestID  = "5f0c8a3e-request"
+)
+
+var mockImage = []byte("mock image contents")
+
+// Times reported in build status.
+var (
+	mockSubmitTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	mockStartTime  = mockSubmitTime.Add(90 * time.Second)
+)
+
+// mockBuildInfo is the build status reported by the mock Build Service.
+type mockBuildInfo struct {
+	ID            string           `json:"id"`
+	IsComplete    bool             `json:"isComplete"`
+	ImageSize     int64            `json:"imageSize"`
+	ImageChecksum string           `json:"imageChecksum"`
+	LibraryRef    string           `json:"libraryRef"`
+	LibraryURL    string           `json:"libraryURL"`
+	State         build.BuildState `json:"state,omitempty"`
+	QueuePosition *int             `json:"queuePosition,omitempty"`
+	SubmitTime    *time.Time       `json:"submitTime,omitempty"`
+	StartTime     *time.Time       `json:"startTime,omitempty"`
+	ExitCode      *int             `json:"exitCode,omitempty"`
+
+	DefinitionChecksum string `json:"definitionChecksum,omitempty"`
+	DefinitionRaw      []byte `json:"definitionRaw,omitempty"`
+}
+
+// mockServers implements a frontend, Build Service and Library Service sufficient to exercise a
+// full run of the application.
+type mockServers struct {
+	t *testing.T
+
+	files     []string // Sources returned in '%files' section by convert-def-file.
+	parseDefs bool     // If set, sources are instead parsed from '%files' sections of the definition.
+	version   string   // Version reported by the Build Service.
+	noVersion bool     // If set, the version endpoint fails.
+	output    []string // Build output messages. If nil, a single sample message is sent.
+	failBuild bool     // If set, builds complete without producing an image. Cancelled builds do likewise.
+
+	convertStatus int // If non-zero, status code returned by convert-def-file.
+	submitStatus  int // If non-zero, status code returned on build submission, with request ID mockRequestID.
+
+	notices   []build.Notice     // Notices published by the Build Service version endpoint.
+	feNotices []endpoints.Notice // Notices published in the frontend configuration.
+
+	hangBuild   bool               // If set, builds never complete, and the output stream is held open.
+	legacyState bool               // If set, build state, times and exit code are not reported, as by older Build Services.
+	states      []build.BuildState // If set, states reported by successive status requests, the last of which is repeated.
+	submitDelay time.Duration      // Delay before builds are accepted.
+	onSubmit    func()             // If set, called when a build is submitted.
+
+	lateOutput      []string      // Build output messages sent after the build is reported complete.
+	lateOutputDelay time.Duration // Delay before lateOutput is sent.
+
+	rangedDownloads bool   // If set, image downloads are redirected to an endpoint serving ranges.
+	ignoreRange     bool   // If set, the ranged download endpoint ignores the Range header.
+	chunked         bool   // If set, images are streamed using chunked encoding, without a Content-Length.
+	libraryStatus   int    // If non-zero, status code returned by library image downloads.
+	imageChecksum   string // If set, image checksum reported by the Build Service, in place of that of mockImage.
+	truncateImage   bool   // If set, library image downloads are aborted once part of the image is written.
+
+	rejectFinalize bool // If set, streamed build context uploads fail to finalize.
+
+	definitionChecksum string // If set, checksum of the definition built, reported by the Build Service.
+	echoDefinition     bool   // If set, the Build Service reports the definition most recently submitted.
+
+	builderArchs []string        // If set, architectures reported by the capabilities endpoint, which is otherwise unsupported.
+	features     []build.Feature // If set, features reported by the capabilities endpoint.
+
+	builds      []mockBuild // Builds listed, most recently submitted first.
+	noBuildList bool        // If set, listing builds is not supported.
+
+	buildContexts []build.BuildContextInfo // Build contexts listed, two per page.
+	noContextList bool                     // If set, listing build contexts is not supported.
+	missingDigest string                   // If set, digest of a build context reported as not found when deleted.
+
+	mu            sync.Mutex
+	convertedDefs [][]byte            // Definitions received by convert-def-file.
+	submittedDefs [][]byte            // Definitions received in build requests.
+	submittedRefs []string            // Library refs received in build requests.
+	submittedDirs []string            // Working directories received in build requests.
+	submittedCtxs []string            // Build context digests received in build requests.
+	uploaded      map[string]bool     // Digests of build contexts uploaded, and not since deleted.
+	archives      [][]byte            // Build context archives uploaded.
+	acceptToken   string              // If set, bearer token required by all endpoints.
+	rotateToken   string              // If set, replaces acceptToken once build output has been streamed.
+	onRotate      func()              // If set, called when rotateToken replaces acceptToken.
+	rejected      []string            // Paths of requests rejected as unauthorized.
+	orgIDs        map[string][]string // Values of the X-Org-ID header received, by path.
+	traceparents  map[string]string   // Values of the Traceparent header received, by path.
+	deleted       []string            // Digests of build contexts deleted.
+	pushedTags    []string            // Tags set on images pushed to the library.
+
+	submits          atomic.Int64 // Number of builds submitted.
+	contextUploads   atomic.Int64 // Number of build contexts uploaded.
+	contextFinalizes atomic.Int64 // Number of streamed build context uploads finalized.
+	contextDeletes   atomic.Int64 // Number of build contexts deleted.
+	rangeRequests    atomic.Int64 // Number of image download requests with a Range header.
+	artifactGets     atomic.Int64 // Number of images downloaded from the Build Service.
+	libraryGets      atomic.Int64 // Number of images downloaded from the library.
+	cancels          atomic.Int64 // Number of build cancellation requests.
+	pushes           atomic.Int64 // Number of images pushed to the library.
+	pushedBytes      atomic.Int64 // Bytes of images pushed to the library.
+	configs          atomic.Int64 // Number of frontend configuration requests.
+	statusRequests   atomic.Int64 // Number of build status requests.
+
+	frontend *httptest.Server
+	build    *httptest.Server
+	library  *httptest.Server
+}
+
+// newMockServers starts a set of mock servers, which are closed when the test completes.
+func newMockServers(t *testing.T) *mockServers {
+	t.Helper()
+
+	return startMockServers(t, httptest.NewServer)
+}
+
+// newMockServersTLS starts a set of mock servers using TLS, which require clients to present a
+// certificate issued by one of clientCAs. The servers are closed when the test completes.
+func newMockServersTLS(t *testing.T, clientCAs *x509.CertPool) *mockServers {
+	t.Helper()
+
+	return startMockServers(t, func(h http.Handler) *httptest.Server {
+		s := httptest.NewUnstartedServer(h)
+		s.TLS = &tls.Config{
+			ClientAuth: tls.RequireAndVerifyClientCert,
+			ClientCAs:  clientCAs,
+		}
+		s.StartTLS()
+		return s
+	})
+}
+
+// startMockServers starts a set of mock servers using start, which are closed when the test
+// completes.
+func startMockServers(t *testing.T, start func(http.Handler) *httptest.Server) *mockServers {
+	t.Helper()
+
+	m := &mockServers{t: t, version: "1.0.0"}
+
+	m.build = start(m.authHandler(m.buildHandler()))
+	t.Cleanup(m.build.Close)
+
+	m.library = start(m.authHandler(m.libraryHandler()))
+	t.Cleanup(m.library.Close)
+
+	m.frontend = start(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.configs.Add(1)
+		m.recordTraceparent(r)
+
+		if err := json.NewEncoder(w).Encode(&endpoints.FrontendConfig{
+			LibraryAPI: endpoints.URI{URI: m.library.URL},
+			BuildAPI:   endpoints.URI{URI: m.build.URL},
+			Notices:    m.feNotices,
+		}); err != nil {
+			t.Errorf("response encoding error: %v", err)
+		}
+	}))
+	t.Cleanup(m.frontend.Close)
+
+	return m
+}
+
+// mockBuild describes a build listed by the Build Service.
+type mockBuild struct {
+	ID         string           `json:"id"`
+	IsComplete bool             `json:"isComplete"`
+	State      build.BuildState `json:"state,omitempty"`
+	Arch       string           `json:"arch,omitempty"`
+	SubmitTime *time.Time       `json:"submitTime,omitempty"`
+}
+
+// writeImage writes mockImage to w. If m.chunked is set, the image is written in several chunks,
+// each flushed in turn, so that the length of the response is not reported.
+func (m *mockServers) writeImage(w http.ResponseWriter) {
+	if m.truncateImage {
+		w.Header().Set("Content-Length", strconv.Itoa(len(mockImage)))
+		if _, err := w.Write(mockImage[:len(mockImage)/2]); err != nil {
+			m.t.Errorf("error writing image: %v", err)
+		}
+		w.(http.Flusher).Flush()
+
+		// Abort the response, closing the connection mid-stream.
+		panic(http.ErrAbortHandler)
+	}
+
+	if !m.chunked {
+		if _, err := w.Write(mockImage); err != nil {
+			m.t.Errorf("error writing image: %v", err)
+		}
+		return
+	}
+
+	for b := mockImage; len(b) > 0; {
+		n := min(len(b), 4)
+		if _, err := w.Write(b[:n]); err != nil {
+			m.t.Errorf("error writing image: %v", err)
+			return
+		}
+		w.(http.Flusher).Flush()
+		b = b[n:]
+	}
+}
+
+// imageChecksum returns the checksum of mockImage in the format reported by the Build Service.
+func imageChecksum() string {
+	return fmt.Sprintf("sha256.%x", sha256.Sum256(mockImage))
+}
+
+// recordTraceparent records the value of the Traceparent header of r, if set.
+func (m *mockServers) recordTraceparent(r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if v := r.Header.Get("Traceparent"); v != "" {
+		if m.traceparents == nil {
+			m.traceparents = make(map[string]string)
+		}
+		m.traceparents[r.URL.Path] = v
+	}
+}
+
+// authHandler wraps next, rejecting requests that do not carry the accepted bearer token, if set.
+// Version endpoints are exempt, as is the build context upload location, since it is typically a
+// pre-signed URL.
+func (m *mockServers) authHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		token := m.acceptToken
+		if v := r.Header.Values("X-Org-ID"); v != nil {
+			if m.orgIDs == nil {
+				m.orgIDs = make(map[string][]string)
+			}
+			m.orgIDs[r.URL.Path] = v
+		}
+		m.mu.Unlock()
+
+		m.recordTraceparent(r)
+
+		if token != "" && r.URL.Path != "/version" && r.URL.Path != "/upload-here" {
+			if f := strings.Fields(r.Header.Get("Authorization")); len(f) != 2 || f[1] != token {
+				m.mu.Lock()
+				m.rejected = append(m.rejected, r.URL.Path)
+				m.mu.Unlock()
+
+				if err := jsonresp.WriteError(w, "", http.StatusUnauthorized); err != nil {
+					m.t.Errorf("response encoding error: %v", err)
+				}
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// addUploaded records that the build context with the specified digest has been uploaded.
+func (m *mockServers) addUploaded(digest string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.uploaded == nil {
+		m.uploaded = make(map[string]bool)
+	}
+	m.uploaded[digest] = true
+}
+
+func (m *mockServers) buildHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /v1/build", func(w http.ResponseWriter, r *http.Request) {
+		m.submits.Add(1)
+		if m.onSubmit != nil {
+			m.onSubmit()
+		}
+
+		if m.submitStatus != 0 {
+			w.Header().Set("X-Request-ID", mockRequestID)
+			if err := jsonresp.WriteError(w, http.StatusText(m.submitStatus), m.submitStatus); err != nil {
+				m.t.Errorf("response encoding error: %v", err)
+			}
+			return
+		}
+
+		var br struct {
+			DefinitionRaw []byte `json:"definitionRaw"`
+			LibraryRef    string `json:"libraryRef"`
+			WorkingDir    string `json:"workingDir"`
+			ContextDigest string `json:"contextDigest"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&br); err != nil {
+			m.t.Errorf("failed to parse request: %v", err)
+		}
+
+		m.mu.Lock()
+		unknown := br.ContextDigest != "" && !m.uploaded[br.ContextDigest]
+		m.mu.Unlock()
+
+		if unknown {
+			if err := jsonresp.WriteError(w, "build context not found", http.StatusNotFound); err != nil {
+				m.t.Errorf("response encoding error: %v", err)
+			}
+			return
+		}
+
+		// The request body is drained, so that the server notices if the client gives up.
+		_, _ = io.Copy(io.Discard, r.Body)
+
+		select {
+		case <-time.After(m.submitDelay):
+		case <-r.Context().Done():
+			return
+		}
+
+		m.mu.Lock()
+		m.submittedDefs = append(m.submittedDefs, br.DefinitionRaw)
+		m.submittedRefs = append(m.submittedRefs, br.LibraryRef)
+		m.submittedDirs = append(m.submittedDirs, br.WorkingDir)
+		m.submittedCtxs = append(m.submittedCtxs, br.ContextDigest)
+		m.mu.Unlock()
+
+		if err := jsonresp.WriteResponse(w, struct {
+			ID string `json:"id"`
+		}{mockBuildID}, http.StatusCreated); err != nil {
+			m.t.Errorf("response encoding error: %v", err)
+		}
+	})
+
+	mux.HandleFunc("GET /v1/build", func(w http.ResponseWriter, r *http.Request) {
+		if m.noBuildList {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		builds := []mockBuild{}
+		for _, b := range m.builds {
+			if r.URL.Query().Get("completed") != "true" || b.IsComplete {
+				builds = append(builds, b)
+			}
+		}
+
+		if err := jsonresp.WriteResponse(w, builds, http.StatusOK); err != nil {
+			m.t.Errorf("response encoding error: %v", err)
+		}
+	})
+
+	mux.HandleFunc("GET /v1/capabilities", func(w http.ResponseWriter, _ *http.Request) {
+		if m.builderArchs == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		caps := build.Capabilities{Architectures: m.builderArchs, Features: m.features}
+		if err := jsonresp.WriteResponse(w, caps, http.StatusOK); err != nil {
+			m.t.Errorf("response encoding error: %v", err)
+		}
+	})
+
+	mux.HandleFunc("GET /v1/build/{id}", func(w http.ResponseWriter, r *http.Request) {
+		n := m.statusRequests.Add(1)
+
+		state := build.BuildStateSucceeded
+		if m.hangBuild {
+			state = build.BuildStateBuilding
+		}
+		if m.failBuild || m.cancels.Load() > 0 {
+			state = build.BuildStateFailed
+		}
+
+		// The queue position is the number of queued states that remain.
+		var pos *int
+		if len(m.states) > 0 {
+			i := min(int(n), len(m.states)) - 1
+			state = m.states[i]
+
+			if state == build.BuildStateQueued {
+				p := 0
+				for _, s := range m.states[i:] {
+					if s == build.BuildStateQueued {
+						p++
+					}
+				}
+				pos = &p
+			}
+		}
+
+		complete := state == build.BuildStateSucceeded || state == build.BuildStateFailed
+
+		size := int64(len(mockImage))
+		if state != build.BuildStateSucceeded {
+			size = 0
+		}
+
+		checksum := imageChecksum()
+		if m.imageChecksum != "" {
+			checksum = m.imageChecksum
+		}
+
+		res := mockBuildInfo{
+			ID:            r.PathValue("id"),
+			IsComplete:    complete,
+			ImageSize:     size,
+			ImageChecksum: checksum,
+			LibraryRef:    mockLibraryRef,
+			LibraryURL:    m.library.URL,
+		}
+
+		res.DefinitionChecksum = m.definitionChecksum
+		if m.echoDefinition {
+			m.mu.Lock()
+			res.DefinitionRaw = m.submittedDefs[len(m.submittedDefs)-1]
+			m.mu.Unlock()
+		}
+
+		if !m.legacyState {
+			res.State = state
+			res.QueuePosition = pos
+			res.SubmitTime = &mockSubmitTime
+			if state != build.BuildStateQueued {
+				res.StartTime = &mockStartTime
+			}
+			if complete {
+				code := 0
+				if state == build.BuildStateFailed {
+					code = 1
+				}
+				res.ExitCode = &code
+			}
+		}
+
+		if err := jsonresp.WriteResponse(w, res, http.StatusOK); err != nil {
+			m.t.Errorf("response encoding error: %v", err)
+		}
+	})
+
+	mux.HandleFunc("PUT /v1/build/{id}/_cancel", func(w http.ResponseWriter, r *http.Request) {
+		m.cancels.Add(1)
+
+		if got, want := r.PathValue("id"), mockBuildID; got != want {
+			m.t.Errorf("got ID %v, want %v", got, want)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("/v1/build-ws/{id}", func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			m.t.Errorf("ws upgrade error: %v", err)
+			return
+		}
+		defer c.Close()
+
+		output := m.output
+		if output == nil {
+			output = []string{"Sample remote build output\n"}
+		}
+
+		for _, msg := range output {
+			if err := c.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+				m.t.Errorf("error writing to websocket: %v", err)
+			}
+		}
+
+		// Hold the stream open until the client closes it.
+		if m.hangBuild {
+			for {
+				if _, _, err := c.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}
+
+		// Simulate expiry of the token during a long build.
+		m.mu.Lock()
+		if m.rotateToken != "" {
+			m.acceptToken = m.rotateToken
+
+			if m.onRotate != nil {
+				m.onRotate()
+			}
+		}
+		m.mu.Unlock()
+
+		if m.lateOutput != nil {
+			// The client may stop reading before late output is sent, so write errors are expected.
+			time.Sleep(m.lateOutputDelay)
+
+			for _, msg := range m.lateOutput {
+				if err := c.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+					return
+				}
+			}
+
+			_ = c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return
+		}
+
+		if err := c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")); err != nil {
+			m.t.Errorf("error closing ws: %v", err)
+		}
+	})
+
+	mux.HandleFunc("GET /version", func(w http.ResponseWriter, _ *http.Request) {
+		if m.noVersion {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if err := jsonresp.WriteResponse(w, build.VersionInfo{
+			Version: m.version,
+			Notices: m.notices,
+		}, http.StatusOK); err != nil {
+			m.t.Errorf("response encoding error: %v", err)
+		}
+	})
+
+	mux.HandleFunc("POST /v1/convert-def-file", func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			m.t.Errorf("failed to read request: %v", err)
+		}
+
+		m.mu.Lock()
+		m.convertedDefs = append(m.convertedDefs, b)
+		m.mu.Unlock()
+
+		if m.convertStatus != 0 {
+			if err := jsonresp.WriteError(w, "failed to parse definition", m.convertStatus); err != nil {
+				m.t.Errorf("response encoding error: %v", err)
+			}
+			return
+		}
+
+		var ft []FileTransport
+		for _, src := range m.files {
+			ft = append(ft, FileTransport{Src: src, Dst: "/"})
+		}
+
+		if m.parseDefs {
+			ft = nil
+
+			inFiles := false
+			for _, line := range strings.Split(string(b), "\n") {
+				if strings.HasPrefix(line, "%") {
+					inFiles = strings.TrimSpace(line) == "%files"
+					continue
+				}
+				if fields := strings.Fields(line); inFiles && len(fields) > 0 {
+					ft = append(ft, FileTransport{Src: fields[0], Dst: "/"})
+				}
+			}
+		}
+
+		// Parse header keywords, which precede the first section.
+		header := make(map[string]string)
+		for _, line := range strings.Split(string(b), "\n") {
+			if strings.HasPrefix(line, "%") {
+				break
+			}
+			if k, v, ok := strings.Cut(line, ":"); ok {
+				header[strings.ToLower(strings.TrimSpace(k))] = strings.TrimSpace(v)
+			}
+		}
+
+		d := definition{Header: header, BuildData: buildData{Files: []files{{Files: ft}}}}
+
+		if err := jsonresp.WriteResponse(w, &d, http.StatusOK); err != nil {
+			m.t.Errorf("response encoding error: %v", err)
+		}
+	})
+
+	mux.HandleFunc("POST /v1/build-context", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Streamed bool   `json:"streamed"`
+			Digest   string `json:"digest"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			m.t.Errorf("request decoding error: %v", err)
+		}
+
+		if !body.Streamed {
+			m.addUploaded(body.Digest)
+		}
+
+		w.Header().Set("Location", "/upload-here")
+
+		if !body.Streamed {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		if err := jsonresp.WriteResponse(w, struct {
+			ID string `json:"id"`
+		}{"upload-id"}, http.StatusAccepted); err != nil {
+			m.t.Errorf("response encoding error: %v", err)
+		}
+	})
+
+	mux.HandleFunc("PUT /v1/build-context/uploads/{id}/_finalize", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Digest string `json:"digest"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			m.t.Errorf("request decoding error: %v", err)
+		}
+
+		if m.rejectFinalize {
+			if err := jsonresp.WriteError(w, "digest mismatch", http.StatusUnprocessableEntity); err != nil {
+				m.t.Errorf("response encoding error: %v", err)
+			}
+			return
+		}
+
+		m.contextFinalizes.Add(1)
+		m.addUploaded(body.Digest)
+
+		w.WriteHeader(http.StatusOK)
+	})
+
+	mux.HandleFunc("PUT /upload-here", func(w http.ResponseWriter, r *http.Request) {
+		m.contextUploads.Add(1)
+
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			m.t.Errorf("error reading build context: %v", err)
+		}
+
+		m.mu.Lock()
+		m.archives = append(m.archives, b)
+		m.mu.Unlock()
+
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	mux.HandleFunc("GET /v1/build-context", func(w http.ResponseWriter, r *http.Request) {
+		if m.noContextList {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var start int
+		if v := r.URL.Query().Get("pageToken"); v != "" {
+			if _, err := fmt.Sscan(v, &start); err != nil {
+				m.t.Errorf("invalid page token: %v", err)
+			}
+		}
+
+		end := min(start+2, len(m.buildContexts))
+
+		var next string
+		if end < len(m.buildContexts) {
+			next = fmt.Sprint(end)
+		}
+
+		if err := jsonresp.WriteResponse(w, struct {
+			Contexts      []build.BuildContextInfo `json:"contexts"`
+			NextPageToken string                   `json:"nextPageToken"`
+		}{m.buildContexts[start:end], next}, http.StatusOK); err != nil {
+			m.t.Errorf("response encoding error: %v", err)
+		}
+	})
+
+	mux.HandleFunc("GET /v1/image/{id}", func(w http.ResponseWriter, r *http.Request) {
+		m.artifactGets.Add(1)
+
+		if got, want := r.PathValue("id"), mockBuildID; got != want {
+			m.t.Errorf("got ID %v, want %v", got, want)
+		}
+
+		if _, err := w.Write(mockImage); err != nil {
+			m.t.Errorf("error writing image: %v", err)
+		}
+	})
+
+	mux.HandleFunc("DELETE /v1/build-context/{digest}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("digest") == m.missingDigest {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		m.contextDeletes.Add(1)
+
+		m.mu.Lock()
+		m.deleted = append(m.deleted, r.PathValue("digest"))
+		delete(m.uploaded, r.PathValue("digest"))
+		m.mu.Unlock()
+
+		w.WriteHeader(http.StatusOK)
+	})
+
+	return mux
+}
+
+func (m *mockServers) libraryHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /v1/imagefile/{ref...}", func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.PathValue("ref"), mockLibraryRef; !strings.HasSuffix(got, want) {
+			m.t.Errorf("got ref %v, want %v", got, want)
+		}
+
+		m.libraryGets.Add(1)
+
+		if m.libraryStatus != 0 {
+			if err := jsonresp.WriteError(w, http.StatusText(m.libraryStatus), m.libraryStatus); err != nil {
+				m.t.Errorf("response encoding error: %v", err)
+			}
+			return
+		}
+
+		if m.rangedDownloads {
+			http.Redirect(w, r, m.library.URL+"/blob", http.StatusSeeOther)
+			return
+		}
+
+		m.writeImage(w)
+	})
+
+	mux.HandleFunc("GET /v1/images/{ref...}", func(w http.ResponseWriter, _ *http.Request) {
+		if err := jsonresp.WriteResponse(w, &library.Image{ID: "image", Size: int64(len(mockImage))}, http.StatusOK); err != nil {
+			m.t.Errorf("response encoding error: %v", err)
+		}
+	})
+
+	// Endpoints used to push images, using the legacy (non-OCI) library API.
+	mux.HandleFunc("GET /v1/entities/{ref}", func(w http.ResponseWriter, _ *http.Request) {
+		if err := jsonresp.WriteResponse(w, &library.Entity{ID: "entity"}, http.StatusOK); err != nil {
+			m.t.Errorf("response encoding error: %v", err)
+		}
+	})
+
+	mux.HandleFunc("GET /v1/collections/{ref...}", func(w http.ResponseWriter, _ *http.Request) {
+		if err := jsonresp.WriteResponse(w, &library.Collection{ID: "collection"}, http.StatusOK); err != nil {
+			m.t.Errorf("response encoding error: %v", err)
+		}
+	})
+
+	mux.HandleFunc("GET /v1/containers/{ref...}", func(w http.ResponseWriter, _ *http.Request) {
+		if err := jsonresp.WriteResponse(w, &library.Container{ID: "container"}, http.StatusOK); err != nil {
+			m.t.Errorf("response encoding error: %v", err)
+		}
+	})
+
+	mux.HandleFunc("POST /v1/imagefile/{id}", func(w http.ResponseWriter, r *http.Request) {
+		m.pushes.Add(1)
+
+		n, err := io.Copy(io.Discard, r.Body)
+		if err != nil {
+			m.t.Errorf("failed to read image: %v", err)
+		}
+		m.pushedBytes.Add(n)
+	})
+
+	mux.HandleFunc("GET /v1/tags/{id}", func(w http.ResponseWriter, _ *http.Request) {
+		if err := jsonresp.WriteResponse(w, library.TagMap{}, http.StatusOK); err != nil {
+			m.t.Errorf("response encoding error: %v", err)
+		}
+	})
+
+	mux.HandleFunc("POST /v1/tags/{id}", func(w http.ResponseWriter, r *http.Request) {
+		var it library.ImageTag
+		if err := json.NewDecoder(r.Body).Decode(&it); err != nil {
+			m.t.Errorf("failed to parse request: %v", err)
+		}
+
+		m.mu.Lock()
+		m.pushedTags = append(m.pushedTags, it.Tag)
+		m.mu.Unlock()
+	})
+
+	mux.HandleFunc("GET /blob", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			m.rangeRequests.Add(1)
+		}
+
+		if m.ignoreRange {
+			m.writeImage(w)
+			return
+		}
+
+		http.ServeContent(w, r, "image.sif", time.Time{}, bytes.NewReader(mockImage))
+	})
+
+	return mux
+}
@@ -0,0 +1,33 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import "syscall"
+
+// Magic numbers of network file systems, as reported by statfs(2).
+const (
+	nfsSuperMagic  = 0x6969
+	smbSuperMagic  = 0x517b
+	cifsSuperMagic = 0xff534d42
+	smb2SuperMagic = 0xfe534d42
+	cephSuperMagic = 0x00c36400
+	afsSuperMagic  = 0x5346414f
+)
+
+// isNetworkFS returns true if the directory with the specified name is on a network file system.
+// If the file system cannot be determined, false is returned.
+func isNetworkFS(name string) bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(name, &st); err != nil {
+		return false
+	}
+
+	switch uint32(st.Type) { //nolint:gosec
+	case nfsSuperMagic, smbSuperMagic, cifsSuperMagic, smb2SuperMagic, cephSuperMagic, afsSuperMagic:
+		return true
+	}
+	return false
+}
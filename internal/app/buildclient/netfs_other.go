@@ -0,0 +1,14 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+//go:build !linux
+
+package buildclient
+
+// isNetworkFS returns true if the directory with the specified name is on a network file system.
+// This is not supported on this platform, so false is returned.
+func isNetworkFS(string) bool {
+	return false
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	build "github.com/sylabs/scs-build-client/client"
+	"github.com/sylabs/scs-build-client/internal/pkg/endpoints"
+)
+
+// NoticeSeverity is the severity of a notice published by a server.
+type NoticeSeverity string
+
+const (
+	NoticeInfo     NoticeSeverity = "info"
+	NoticeWarning  NoticeSeverity = "warning"
+	NoticeCritical NoticeSeverity = "critical"
+)
+
+// parseNoticeSeverity returns the severity named by s. Severities that are missing or not
+// recognized are treated as NoticeInfo, so that notices from newer servers are still shown.
+func parseNoticeSeverity(s string) NoticeSeverity {
+	switch sev := NoticeSeverity(strings.ToLower(s)); sev {
+	case NoticeWarning, NoticeCritical:
+		return sev
+	default:
+		return NoticeInfo
+	}
+}
+
+// rank orders severities from least to most severe.
+func (s NoticeSeverity) rank() int {
+	return slices.Index([]NoticeSeverity{NoticeInfo, NoticeWarning, NoticeCritical}, s)
+}
+
+const (
+	noticeSourceFrontend = "frontend"
+	noticeSourceBuild    = "build service"
+)
+
+// Notice is a message, such as a maintenance banner, published by the frontend or Build Service.
+type Notice struct {
+	Source   string         `json:"source"`
+	Severity NoticeSeverity `json:"severity"`
+	Message  string         `json:"message"`
+}
+
+// String returns n in the form in which it is displayed.
+func (n Notice) String() string {
+	return fmt.Sprintf("[%v] %v: %v", strings.ToUpper(string(n.Severity)), n.Source, n.Message)
+}
+
+// frontendNotices returns the notices published in the frontend configuration feCfg. Where the
+// configuration is cached, so are its notices, so the Build Service is the timelier source.
+func frontendNotices(feCfg *endpoints.FrontendConfig) []Notice {
+	var ns []Notice
+	for _, n := range feCfg.Notices {
+		ns = append(ns, Notice{noticeSourceFrontend, parseNoticeSeverity(n.Severity), n.Message})
+	}
+	return ns
+}
+
+// buildNotices returns the notices published in the Build Service version information vi.
+func buildNotices(vi *build.VersionInfo) []Notice {
+	var ns []Notice
+	for _, n := range vi.Notices {
+		ns = append(ns, Notice{noticeSourceBuild, parseNoticeSeverity(n.Severity), n.Message})
+	}
+	return ns
+}
+
+// mergeNotices returns the notices in each of nss, most severe first, omitting empty messages and
+// messages published more than once.
+func mergeNotices(nss ...[]Notice) []Notice {
+	var merged []Notice
+
+	seen := make(map[string]bool)
+	for _, ns := range nss {
+		for _, n := range ns {
+			if n.Message == "" || seen[n.Message] {
+				continue
+			}
+			seen[n.Message] = true
+			merged = append(merged, n)
+		}
+	}
+
+	slices.SortStableFunc(merged, func(a, b Notice) int {
+		return b.Severity.rank() - a.Severity.rank()
+	})
+
+	return merged
+}
+
+// writeNotices writes ns to w, one per line.
+func writeNotices(w io.Writer, ns []Notice) {
+	for _, n := range ns {
+		fmt.Fprintln(w, n)
+	}
+}
+
+// reportNotices writes the notices published by the frontend and Build Service to standard error,
+// so that users learn of maintenance that may delay their builds. Notices are reported once per
+// App, regardless of the number of runs or architectures. A Build Service whose notices cannot be
+// retrieved is assumed to have none.
+func (app *App) reportNotices(ctx context.Context) {
+	if app.notices != nil {
+		return
+	}
+
+	var bns []Notice
+	if vi, err := app.buildClient.GetVersionInfo(ctx); err == nil {
+		bns = buildNotices(vi)
+	}
+
+	app.notices = mergeNotices(app.frontendNotices, bns)
+	if app.notices == nil {
+		app.notices = []Notice{}
+	}
+
+	if len(app.notices) > 0 {
+		fmt.Fprintln(os.Stderr)
+		writeNotices(os.Stderr, app.notices)
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+// WriteInfo writes the URLs and version of the Build Service, along with any notices published by
+// it or the frontend, to w.
+func (app *App) WriteInfo(ctx context.Context, w io.Writer) error {
+	vi, err := app.buildClient.GetVersionInfo(ctx)
+	if err != nil {
+		app.checkFrontendConfig(err)
+		return fmt.Errorf("unable to get server version: %w", app.wrapBuildErr(err))
+	}
+
+	fmt.Fprintf(w, "Frontend:      %v\n", app.frontendURL)
+	fmt.Fprintf(w, "Build Service: %v\n", app.buildURL)
+	fmt.Fprintf(w, "Version:       %v\n", vi.Version)
+
+	ns := mergeNotices(app.frontendNotices, buildNotices(vi))
+	if len(ns) == 0 {
+		fmt.Fprintf(w, "Notices:       none\n")
+		return nil
+	}
+
+	fmt.Fprintf(w, "Notices:\n")
+	for _, n := range ns {
+		fmt.Fprintf(w, "  %v\n", n)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	build "github.com/sylabs/scs-build-client/client"
+	"github.com/sylabs/scs-build-client/internal/pkg/endpoints"
+)
+
+func TestParseNoticeSeverity(t *testing.T) {
+	tests := []struct {
+		value string
+		want  NoticeSeverity
+	}{
+		{"info", NoticeInfo},
+		{"warning", NoticeWarning},
+		{"critical", NoticeCritical},
+		{"CRITICAL", NoticeCritical},
+		{"", NoticeInfo},
+		{"emergency", NoticeInfo},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseNoticeSeverity(tt.value))
+		})
+	}
+}
+
+func TestMergeNotices(t *testing.T) {
+	fe := []Notice{
+		{noticeSourceFrontend, NoticeInfo, "new builders available"},
+		{noticeSourceFrontend, NoticeWarning, "builders draining for upgrade at 18:00 UTC"},
+	}
+	bs := []Notice{
+		{noticeSourceBuild, NoticeWarning, "builders draining for upgrade at 18:00 UTC"},
+		{noticeSourceBuild, NoticeCritical, "builds are suspended"},
+		{noticeSourceBuild, NoticeInfo, ""},
+	}
+
+	want := []Notice{
+		{noticeSourceBuild, NoticeCritical, "builds are suspended"},
+		{noticeSourceFrontend, NoticeWarning, "builders draining for upgrade at 18:00 UTC"},
+		{noticeSourceFrontend, NoticeInfo, "new builders available"},
+	}
+
+	assert.Equal(t, want, mergeNotices(fe, bs))
+	assert.Nil(t, mergeNotices(nil, nil))
+}
+
+func TestApp_WriteInfo(t *testing.T) {
+	tests := []struct {
+		name      string
+		feNotices []endpoints.Notice
+		notices   []build.Notice
+		want      []string
+	}{
+		{
+			name: "None",
+			want: []string{"Version:       1.0.0\n", "Notices:       none\n"},
+		},
+		{
+			name:      "Info",
+			feNotices: []endpoints.Notice{{Severity: "info", Message: "new builders available"}},
+			want:      []string{"Notices:\n  [INFO] frontend: new builders available\n"},
+		},
+		{
+			name:    "Warning",
+			notices: []build.Notice{{Severity: "warning", Message: "builders draining for upgrade at 18:00 UTC"}},
+			want:    []string{"Notices:\n  [WARNING] build service: builders draining for upgrade at 18:00 UTC\n"},
+		},
+		{
+			name:      "Critical",
+			feNotices: []endpoints.Notice{{Severity: "info", Message: "new builders available"}},
+			notices:   []build.Notice{{Severity: "critical", Message: "builds are suspended"}},
+			want: []string{
+				"Notices:\n" +
+					"  [CRITICAL] build service: builds are suspended\n" +
+					"  [INFO] frontend: new builders available\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.feNotices = tt.feNotices
+			m.notices = tt.notices
+
+			app, err := New(context.Background(), &Config{URL: m.frontend.URL})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			var b bytes.Buffer
+			if err := app.WriteInfo(context.Background(), &b); err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Contains(t, b.String(), "Build Service: "+m.build.URL+"\n")
+			for _, want := range tt.want {
+				assert.Contains(t, b.String(), want)
+			}
+		})
+	}
+}
+
+func TestApp_RunNotices(t *testing.T) {
+	tests := []struct {
+		name      string
+		feNotices []endpoints.Notice
+		notices   []build.Notice
+		want      []Notice
+	}{
+		{
+			name: "None",
+		},
+		{
+			name:      "Notices",
+			feNotices: []endpoints.Notice{{Severity: "info", Message: "new builders available"}},
+			notices:   []build.Notice{{Severity: "warning", Message: "builders draining for upgrade at 18:00 UTC"}},
+			want: []Notice{
+				{noticeSourceBuild, NoticeWarning, "builders draining for upgrade at 18:00 UTC"},
+				{noticeSourceFrontend, NoticeInfo, "new builders available"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.feNotices = tt.feNotices
+			m.notices = tt.notices
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			resumeFile := filepath.Join(dir, "metadata.json")
+
+			app, err := New(context.Background(), &Config{
+				URL:          m.frontend.URL,
+				BuildSpec:    defFile,
+				LibraryRef:   filepath.Join(dir, "image.sif"),
+				ArchsToBuild: []string{"amd64", "arm64"},
+				ResumeFile:   resumeFile,
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			if err := app.Run(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			// Notices are recorded once per run, regardless of the number of architectures.
+			md, err := readMetadata(resumeFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tt.want, md.Notices)
+			assert.Len(t, md.Archs, 2)
+		})
+	}
+}
@@ -0,0 +1,16 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+//go:build !unix
+
+package buildclient
+
+import "io/fs"
+
+// fileOwner returns the name of the user owning the file described by fi. This is not supported
+// on this platform, so an empty string is returned.
+func fileOwner(fs.FileInfo) string {
+	return ""
+}
@@ -7,8 +7,10 @@ package buildclient
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -16,7 +18,6 @@ import (
 
 	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/spf13/viper"
-	"github.com/sylabs/sif/v2/pkg/integrity"
 	"golang.org/x/term"
 )
 
@@ -50,13 +51,70 @@ func parsePGPSignerOpts(v *viper.Viper) ([]pgpSignerOpt, error) {
 		so = append(so, signEntitySelector(keyringEntitySelectorFunc))
 	}
 
+	so = append(so, passphraseSource(v))
+
+	return so, nil
+}
+
+// envPGPPassphrase is the environment variable from which the passphrase of an encrypted PGP key
+// is read, if not otherwise specified.
+const envPGPPassphrase = "SYLABS_PGP_PASSPHRASE"
+
+var errNoPassphrase = errors.New("passphrase required, but standard input is not a terminal (use --passphrase-fd, --passphrase-file or " + envPGPPassphrase + ")")
+
+// passphraseSource returns an option specifying the source of the passphrase of an encrypted PGP
+// key. In order of preference, the passphrase is read from the file descriptor or file specified
+// in v, the passphrase specified in v, or the SYLABS_PGP_PASSPHRASE environment variable.
+// Otherwise, it is prompted for on the terminal.
+func passphraseSource(v *viper.Viper) pgpSignerOpt {
+	if v.IsSet(keyPassphraseFD) {
+		fd := v.GetInt(keyPassphraseFD)
+
+		return signKeyringPassphraseFunc(func() ([]byte, error) {
+			if fd < 0 {
+				return nil, fmt.Errorf("invalid passphrase file descriptor %v", fd)
+			}
+
+			f := os.NewFile(uintptr(fd), "passphrase-fd")
+			if f == nil {
+				return nil, fmt.Errorf("invalid passphrase file descriptor %v", fd)
+			}
+			defer f.Close()
+
+			return readPassphrase(f)
+		})
+	}
+
+	if path := v.GetString(keyPassphraseFile); path != "" {
+		return signKeyringPassphraseFunc(func() ([]byte, error) {
+			f, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+
+			return readPassphrase(f)
+		})
+	}
+
 	if passphrase := v.GetString(keyPassphrase); passphrase != "" {
-		so = append(so, signKeyringPassphrase(passphrase))
-	} else {
-		so = append(so, signKeyringPassphraseFunc(keyringPassphraseFunc))
+		return signKeyringPassphrase(passphrase)
 	}
 
-	return so, nil
+	if passphrase, ok := os.LookupEnv(envPGPPassphrase); ok {
+		return signKeyringPassphrase(passphrase)
+	}
+
+	return signKeyringPassphraseFunc(terminalPassphraseFunc(os.Stdin))
+}
+
+// readPassphrase reads a passphrase from the first line of r.
+func readPassphrase(r io.Reader) ([]byte, error) {
+	b, err := bufio.NewReader(r).ReadBytes('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return bytes.TrimRight(b, "\r\n"), nil
 }
 
 func keyringPath(keyring string) (string, error) {
@@ -75,18 +133,27 @@ func keyringPath(keyring string) (string, error) {
 	return "", errKeyringPath
 }
 
-func keyringPassphraseFunc() ([]byte, error) {
-	fmt.Print("Keyring passphrase: ")
-	bytePassword, err := term.ReadPassword(0)
+// terminalPassphraseFunc returns a function that prompts for a passphrase on the terminal f. If f
+// is not a terminal, the function returns errNoPassphrase.
+func terminalPassphraseFunc(f *os.File) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		fd := int(f.Fd())
+		if !term.IsTerminal(fd) {
+			return nil, errNoPassphrase
+		}
 
-	// Add missing newline after passphrase prompt
-	fmt.Println()
+		fmt.Print("Keyring passphrase: ")
+		bytePassword, err := term.ReadPassword(fd)
 
-	if err != nil {
-		return []byte(""), err
-	}
+		// Add missing newline after passphrase prompt
+		fmt.Println()
 
-	return bytePassword, nil
+		if err != nil {
+			return []byte(""), err
+		}
+
+		return bytePassword, nil
+	}
 }
 
 func keyringEntitySelectorFunc(e openpgp.EntityList) (*openpgp.Entity, error) {
@@ -184,8 +251,8 @@ func stripPublicKeys(e openpgp.EntityList) openpgp.EntityList {
 	return el
 }
 
-// getPGPSignerOpts returns a Signer that will Sign imgName.
-func getPGPSignerOpts(opts ...pgpSignerOpt) ([]integrity.SignerOpt, error) {
+// getPGPEntity returns the PGP entity selected by opts, with its private key decrypted.
+func getPGPEntity(opts ...pgpSignerOpt) (*openpgp.Entity, error) {
 	s := pgpSignerOpts{}
 
 	// Apply options.
@@ -229,5 +296,5 @@ func getPGPSignerOpts(opts ...pgpSignerOpt) ([]integrity.SignerOpt, error) {
 		}
 	}
 
-	return []integrity.SignerOpt{integrity.OptSignWithEntity(entity)}, nil
+	return entity, nil
 }
@@ -0,0 +1,112 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// passphraseFD returns a file descriptor from which passphrase can be read, as if passed to
+// --passphrase-fd by a parent process.
+func passphraseFD(t *testing.T, passphrase string) int {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+
+	if _, err := w.WriteString(passphrase); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return int(r.Fd())
+}
+
+func TestPassphraseSource(t *testing.T) {
+	tests := []struct {
+		name       string
+		fd         string // If set, written to a pipe passed as passphrase file descriptor.
+		file       string // If set, written to a file passed as passphrase file.
+		passphrase string
+		env        string // If set, value of SYLABS_PGP_PASSPHRASE.
+		want       string
+		wantErr    error
+	}{
+		{name: "FD", fd: "fd\n", want: "fd"},
+		{name: "FDNoNewline", fd: "fd", want: "fd"},
+		{name: "FDCRLF", fd: "fd\r\nignored\n", want: "fd"},
+		{name: "File", file: "file\n", want: "file"},
+		{name: "Passphrase", passphrase: "flag", want: "flag"},
+		{name: "Env", env: "env", want: "env"},
+		{name: "FDOverEnv", fd: "fd\n", env: "env", want: "fd"},
+		{name: "FileOverEnv", file: "file\n", env: "env", want: "file"},
+		{name: "PassphraseOverEnv", passphrase: "flag", env: "env", want: "flag"},
+		{name: "NotTerminal", wantErr: errNoPassphrase},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := viper.New()
+
+			if tt.fd != "" {
+				v.Set(keyPassphraseFD, passphraseFD(t, tt.fd))
+			}
+
+			if tt.file != "" {
+				path := filepath.Join(t.TempDir(), "passphrase")
+				if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				v.Set(keyPassphraseFile, path)
+			}
+
+			if tt.passphrase != "" {
+				v.Set(keyPassphrase, tt.passphrase)
+			}
+
+			if tt.env != "" {
+				t.Setenv(envPGPPassphrase, tt.env)
+			} else {
+				t.Setenv(envPGPPassphrase, "")
+				os.Unsetenv(envPGPPassphrase)
+			}
+
+			// Substitute standard input with a pipe, so that it is not a terminal.
+			stdin := os.Stdin
+			t.Cleanup(func() { os.Stdin = stdin })
+			r, w, err := os.Pipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { r.Close(); w.Close() })
+			os.Stdin = r
+
+			var opts pgpSignerOpts
+			if err := passphraseSource(v)(&opts); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := opts.passphraseFunc()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if err == nil {
+				assert.Equal(t, tt.want, string(got))
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var errInvalidPlan = errors.New("invalid transfer plan")
+
+// transferPlan describes how the image built for an architecture reaches its destinations: a
+// library ref, a local file (or standard output), both, or neither, in which case the Build
+// Service pushes the image to a temporary library location.
+type transferPlan struct {
+	serverRef string // If set, the Build Service pushes the image to this library ref.
+	download  bool   // If set, the client downloads the image.
+	tempFile  bool   // If set, the image is downloaded to a temporary file, rather than dstFile.
+	sign      bool   // If set, the client signs the downloaded image.
+	uploadRef string // If set, the client uploads the downloaded image to this library ref.
+	dstFile   string // If set, the downloaded image is written to this file, once signed.
+}
+
+// planTransfer returns the plan to deliver an image to libraryRef and dstFile, either of which may
+// be empty. If signed is set, the image is signed before it reaches either destination. If
+// clientTags is set, the image is tagged with more than one tag, which the Build Service does not
+// support, so must be uploaded by the client.
+//
+// The Build Service pushes the image to libraryRef where possible, to avoid moving the image
+// through the client. Otherwise, the client downloads the image to dstFile, or to a temporary file
+// where it is signed or uploaded, and then renamed to dstFile, if set.
+func planTransfer(libraryRef, dstFile string, signed, clientTags bool) transferPlan {
+	p := transferPlan{sign: signed, dstFile: dstFile}
+
+	if libraryRef != "" {
+		if signed || clientTags {
+			p.uploadRef = libraryRef
+		} else {
+			p.serverRef = libraryRef
+		}
+	}
+
+	p.download = p.uploadRef != "" || dstFile != "" || signed
+	p.tempFile = p.download && (p.uploadRef != "" || signed)
+
+	return p
+}
+
+// validate returns an error if p does not deliver the image to libraryRef and dstFile exactly once
+// each, or signs an image that is not retained.
+func (p transferPlan) validate(libraryRef, dstFile string) error {
+	switch {
+	case p.serverRef != "" && p.uploadRef != "":
+		return fmt.Errorf("%w: image pushed to the library by both the Build Service and the client", errInvalidPlan)
+	case libraryRef != "" && p.serverRef != libraryRef && p.uploadRef != libraryRef:
+		return fmt.Errorf("%w: image not pushed to %v", errInvalidPlan, libraryRef)
+	case libraryRef == "" && p.uploadRef != "":
+		return fmt.Errorf("%w: image uploaded to %v, which was not requested", errInvalidPlan, p.uploadRef)
+	case p.dstFile != dstFile:
+		return fmt.Errorf("%w: image written to %q, rather than %q", errInvalidPlan, p.dstFile, dstFile)
+	case dstFile != "" && !p.download:
+		return fmt.Errorf("%w: image not downloaded to %v", errInvalidPlan, dstFile)
+	case (p.sign || p.uploadRef != "") && !p.tempFile:
+		return fmt.Errorf("%w: image must be downloaded to a temporary file to be signed or uploaded", errInvalidPlan)
+	case p.sign && p.uploadRef == "" && dstFile == "":
+		return fmt.Errorf("%w: signed image has no destination", errInvalidPlan)
+	}
+	return nil
+}
+
+// steps returns a description of each step of p, in the order they are performed.
+func (p transferPlan) steps() []string {
+	var steps []string
+
+	if p.serverRef != "" {
+		steps = append(steps, fmt.Sprintf("server pushes to %v", p.serverRef))
+	}
+
+	switch {
+	case !p.download:
+		if p.serverRef == "" {
+			steps = append(steps, "server pushes to temporary library location")
+		}
+		return steps
+	case p.tempFile:
+		steps = append(steps, "client downloads to temporary file")
+	case p.dstFile == stdoutDestination:
+		steps = append(steps, "client downloads to standard output")
+	default:
+		steps = append(steps, fmt.Sprintf("client downloads to %v", p.dstFile))
+	}
+
+	if p.sign {
+		steps = append(steps, "client signs")
+	}
+
+	if p.uploadRef != "" {
+		steps = append(steps, fmt.Sprintf("client uploads to %v", p.uploadRef))
+	}
+
+	if p.tempFile && p.dstFile != "" {
+		steps = append(steps, fmt.Sprintf("client renames to %v", p.dstFile))
+	}
+
+	return steps
+}
+
+func (p transferPlan) String() string {
+	return strings.Join(p.steps(), ", ")
+}
+
+// planArch returns the plan to deliver the image built for arch to its destinations.
+func (app *App) planArch(arch string) (transferPlan, error) {
+	var libraryRef string
+	if app.libraryRef != nil {
+		libraryRef = app.libraryRef.String()
+	}
+
+	dstFile := app.dstFileNameForArch(arch)
+
+	p := planTransfer(libraryRef, dstFile, app.signerOpts != nil, app.libraryRef != nil && len(app.libraryRef.Tags) > 1)
+	if err := p.validate(libraryRef, dstFile); err != nil {
+		return transferPlan{}, err
+	}
+	return p, nil
+}
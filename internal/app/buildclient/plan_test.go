@@ -0,0 +1,166 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanTransfer(t *testing.T) {
+	const (
+		ref  = "library:entity/collection/container:tag"
+		file = "image.sif"
+	)
+
+	tests := []struct {
+		name       string
+		libraryRef string
+		dstFile    string
+		signed     bool
+		clientTags bool
+		wantSteps  []string
+		wantErr    error
+	}{
+		{
+			name:      "Ephemeral",
+			wantSteps: []string{"server pushes to temporary library location"},
+		},
+		{
+			name:    "EphemeralSigned",
+			signed:  true,
+			wantErr: errInvalidPlan,
+		},
+		{
+			name:       "Ref",
+			libraryRef: ref,
+			wantSteps:  []string{"server pushes to " + ref},
+		},
+		{
+			name:       "RefClientTags",
+			libraryRef: ref,
+			clientTags: true,
+			wantSteps:  []string{"client downloads to temporary file", "client uploads to " + ref},
+		},
+		{
+			name:       "RefSigned",
+			libraryRef: ref,
+			signed:     true,
+			wantSteps:  []string{"client downloads to temporary file", "client signs", "client uploads to " + ref},
+		},
+		{
+			name:      "File",
+			dstFile:   file,
+			wantSteps: []string{"client downloads to " + file},
+		},
+		{
+			name:      "FileSigned",
+			dstFile:   file,
+			signed:    true,
+			wantSteps: []string{"client downloads to temporary file", "client signs", "client renames to " + file},
+		},
+		{
+			name:      "Stdout",
+			dstFile:   stdoutDestination,
+			wantSteps: []string{"client downloads to standard output"},
+		},
+		{
+			name:       "RefAndFile",
+			libraryRef: ref,
+			dstFile:    file,
+			wantSteps:  []string{"server pushes to " + ref, "client downloads to " + file},
+		},
+		{
+			name:       "RefAndFileClientTags",
+			libraryRef: ref,
+			dstFile:    file,
+			clientTags: true,
+			wantSteps:  []string{"client downloads to temporary file", "client uploads to " + ref, "client renames to " + file},
+		},
+		{
+			name:       "RefAndFileSigned",
+			libraryRef: ref,
+			dstFile:    file,
+			signed:     true,
+			wantSteps: []string{
+				"client downloads to temporary file",
+				"client signs",
+				"client uploads to " + ref,
+				"client renames to " + file,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := planTransfer(tt.libraryRef, tt.dstFile, tt.signed, tt.clientTags)
+
+			err := p.validate(tt.libraryRef, tt.dstFile)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if err == nil {
+				assert.Equal(t, tt.wantSteps, p.steps())
+			}
+		})
+	}
+}
+
+func TestTransferPlan_Validate(t *testing.T) {
+	const (
+		ref  = "library:entity/collection/container:tag"
+		file = "image.sif"
+	)
+
+	tests := []struct {
+		name       string
+		plan       transferPlan
+		libraryRef string
+		dstFile    string
+	}{
+		{
+			name:       "PushedTwice",
+			plan:       transferPlan{serverRef: ref, download: true, tempFile: true, uploadRef: ref},
+			libraryRef: ref,
+		},
+		{
+			name:       "NotPushed",
+			plan:       transferPlan{download: true, dstFile: file},
+			libraryRef: ref,
+			dstFile:    file,
+		},
+		{
+			name: "UploadNotRequested",
+			plan: transferPlan{download: true, tempFile: true, uploadRef: ref},
+		},
+		{
+			name:    "NotDownloaded",
+			plan:    transferPlan{dstFile: file},
+			dstFile: file,
+		},
+		{
+			name:    "WrongFile",
+			plan:    transferPlan{download: true, dstFile: "other.sif"},
+			dstFile: file,
+		},
+		{
+			name:    "SignedInPlace",
+			plan:    transferPlan{download: true, sign: true, dstFile: file},
+			dstFile: file,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.plan.validate(tt.libraryRef, tt.dstFile); !errors.Is(err, errInvalidPlan) {
+				t.Errorf("got error %v, want %v", err, errInvalidPlan)
+			}
+		})
+	}
+}
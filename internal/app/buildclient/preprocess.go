@@ -0,0 +1,54 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+var errPreprocess = errors.New("definition preprocessor failed")
+
+// preprocessWaitDelay is the period for which the output of a preprocessor is awaited once it is
+// killed, since processes it started may hold its output open.
+var preprocessWaitDelay = time.Second
+
+// preprocessDefinition pipes def through the shell command app.defPreprocess, and returns its
+// output. The command is run in the directory containing the definition at defPath, if it is a
+// local file, so that paths it includes resolve as they would if it were run on the file directly.
+//
+// The command is killed if ctx is cancelled. If it exits with a non-zero status, the error returned
+// includes its standard error. Otherwise, its standard error is passed through.
+func (app *App) preprocessDefinition(ctx context.Context, def []byte, defPath string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", app.defPreprocess)
+	cmd.Stdin = bytes.NewReader(def)
+	cmd.WaitDelay = preprocessWaitDelay
+
+	if fi, err := os.Stat(defPath); err == nil && fi.Mode().IsRegular() {
+		cmd.Dir = filepath.Dir(defPath)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%w: %v: %w", errPreprocess, app.defPreprocess, ctx.Err())
+		}
+		return nil, fmt.Errorf("%w: %v: %w: %s", errPreprocess, app.defPreprocess, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	_, _ = os.Stderr.Write(stderr.Bytes())
+
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,190 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeScript writes an executable shell script with the specified body to dir, and returns its
+// path.
+func writeScript(t *testing.T, dir, name, body string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0o755); err != nil { //nolint:gosec
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestApp_PreprocessDefinition(t *testing.T) {
+	dir := t.TempDir()
+
+	defPath := filepath.Join(dir, "alpine.def.in")
+	if err := os.WriteFile(defPath, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "post.inc"), []byte("%post\n  echo included\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	scripts := t.TempDir()
+
+	tests := []struct {
+		name       string
+		command    string
+		timeout    time.Duration
+		want       string
+		wantErr    error
+		wantStderr string
+	}{
+		{
+			name:    "Substitute",
+			command: writeScript(t, scripts, "substitute", "sed 's/@BASE@/alpine:3/'\n"),
+			want:    "bootstrap: docker\nfrom: alpine:3\n",
+		},
+		{
+			name:    "Include",
+			command: writeScript(t, scripts, "include", "cat - post.inc\n"),
+			want:    "bootstrap: docker\nfrom: @BASE@\n%post\n  echo included\n",
+		},
+		{
+			name:    "Arguments",
+			command: writeScript(t, scripts, "arguments", "sed \"s/@BASE@/$1/\"\n") + " alpine:edge",
+			want:    "bootstrap: docker\nfrom: alpine:edge\n",
+		},
+		{
+			name:       "Failed",
+			command:    writeScript(t, scripts, "failed", "cat >/dev/null\necho 'post.inc: no such include' >&2\nexit 3\n"),
+			wantErr:    errPreprocess,
+			wantStderr: "post.inc: no such include",
+		},
+		{
+			name:    "Cancelled",
+			command: "exec sleep 60",
+			timeout: 100 * time.Millisecond,
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			app := &App{defPreprocess: tt.command}
+
+			start := time.Now()
+
+			got, err := app.preprocessDefinition(ctx, []byte("bootstrap: docker\nfrom: @BASE@\n"), defPath)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			// A cancelled preprocessor is killed, rather than awaited.
+			if d := time.Since(start); d > 10*time.Second {
+				t.Errorf("preprocessor took %v", d)
+			}
+
+			if err != nil {
+				assert.Contains(t, err.Error(), tt.wantStderr)
+				return
+			}
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestApp_RunDefPreprocess(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "data.txt"), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	def := "bootstrap: docker\nfrom: alpine:3\n\n%files\n  @FILE@ /data.txt\n"
+	want := strings.ReplaceAll(def, "@FILE@", "data.txt")
+
+	defFile := filepath.Join(dir, "alpine.def")
+	if err := os.WriteFile(defFile, []byte(def), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	scripts := t.TempDir()
+
+	tests := []struct {
+		name    string
+		command string
+		wantErr error
+	}{
+		{
+			name:    "OK",
+			command: writeScript(t, scripts, "ok", "sed 's/@FILE@/data.txt/'\n"),
+		},
+		{
+			name:    "Failed",
+			command: writeScript(t, scripts, "failed", "echo 'syntax error' >&2\nexit 1\n"),
+			wantErr: errPreprocess,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.parseDefs = true
+
+			app, err := New(context.Background(), &Config{
+				URL:           m.frontend.URL,
+				BuildSpec:     defFile,
+				LibraryRef:    filepath.Join(t.TempDir(), "image.sif"),
+				ArchsToBuild:  []string{"amd64"},
+				Context:       dir,
+				DefPreprocess: tt.command,
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			err = app.Run(context.Background())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				assert.Equal(t, ExitValidation, ExitCode(err))
+				assert.Contains(t, err.Error(), "syntax error")
+				assert.Empty(t, m.convertedDefs)
+				assert.Equal(t, int64(0), m.submits.Load())
+				return
+			}
+
+			// The preprocessed definition is used to determine the build context, and is submitted.
+			assert.Equal(t, [][]byte{[]byte(want)}, m.convertedDefs)
+			assert.Equal(t, [][]byte{[]byte(want)}, m.submittedDefs)
+
+			if assert.Len(t, m.archives, 1) {
+				name := strings.TrimPrefix(filepath.ToSlash(filepath.Join(dir, "data.txt")), "/")
+				assert.Contains(t, archiveEntries(t, m.archives[0]), name)
+			}
+
+			// The digests of the definition before and after preprocessing are recorded.
+			assert.Equal(t, definitionDigest([]byte(def)), app.metadata.SourceDefinitionDigest)
+			assert.Equal(t, definitionDigest([]byte(want)), app.metadata.DefinitionDigest)
+		})
+	}
+}
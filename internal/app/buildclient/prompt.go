@@ -0,0 +1,130 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// prompter asks the user to confirm destructive operations. Where the user cannot be asked, or
+// does not answer, the safe choice is assumed, which is not to proceed.
+type prompter struct {
+	in          io.Reader
+	out         io.Writer
+	interactive bool
+
+	once  sync.Once
+	lines chan string // Lines read from in. Closed once in is exhausted.
+}
+
+// newPrompter returns a prompter that reads answers from in, and writes questions to out. If
+// interactive is false, no questions are asked.
+func newPrompter(in io.Reader, out io.Writer, interactive bool) *prompter {
+	return &prompter{in: in, out: out, interactive: interactive}
+}
+
+// newTerminalPrompter returns a prompter that asks questions on standard error, and reads answers
+// from standard input, provided both are terminals and nonInteractive is false.
+func newTerminalPrompter(nonInteractive bool) *prompter {
+	interactive := !nonInteractive &&
+		term.IsTerminal(int(os.Stdin.Fd())) &&
+		term.IsTerminal(int(os.Stderr.Fd()))
+
+	return newPrompter(os.Stdin, os.Stderr, interactive)
+}
+
+// canPrompt reports whether p is able to ask questions.
+func (p *prompter) canPrompt() bool {
+	return p != nil && p.interactive
+}
+
+// readLines reads lines from p.in until it is exhausted. Lines are read by a single goroutine, so
+// that a prompt abandoned when its context is done does not consume the answer to the next.
+func (p *prompter) readLines() {
+	p.lines = make(chan string)
+
+	go func() {
+		defer close(p.lines)
+
+		s := bufio.NewScanner(p.in)
+		for s.Scan() {
+			p.lines <- s.Text()
+		}
+	}()
+}
+
+// confirm asks question, and reports whether the user answered yes. If p cannot prompt, the
+// input is exhausted, or ctx is done (for example, because the user pressed Ctrl-C) before the
+// user answers, false is returned.
+func (p *prompter) confirm(ctx context.Context, question string) bool {
+	if !p.canPrompt() {
+		return false
+	}
+
+	p.once.Do(p.readLines)
+
+	fmt.Fprintf(p.out, "%v [y/N]: ", question)
+
+	select {
+	case <-ctx.Done():
+		fmt.Fprintln(p.out)
+		return false
+	case line, ok := <-p.lines:
+		if !ok {
+			fmt.Fprintln(p.out)
+			return false
+		}
+
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			return true
+		}
+		return false
+	}
+}
+
+// awaitInterrupt waits for a signal to be received on sigs, and then cancels the run using cancel.
+// A further signal exits immediately using exit. See cancelOnSignal.
+//
+// If app.confirmCancel is set, and the user can be prompted, an interrupt first asks the user to
+// confirm that running builds should be cancelled. If the user declines, the run continues, and
+// awaitInterrupt waits for a further signal. A further signal received while the question is
+// being asked cancels the run without awaiting an answer.
+func (app *App) awaitInterrupt(sigs <-chan os.Signal, cancel context.CancelFunc, exit func(int)) {
+	for sig := range sigs {
+		if sig == os.Interrupt && app.confirmCancel && app.prompter.canPrompt() {
+			ctx, stop := context.WithCancel(context.Background())
+			answer := make(chan bool, 1)
+
+			go func() {
+				answer <- app.prompter.confirm(ctx, "Cancel running build?")
+			}()
+
+			select {
+			case ok := <-answer:
+				stop()
+				if !ok {
+					fmt.Fprintln(app.prompter.out, "Continuing build")
+					continue
+				}
+			case sig = <-sigs:
+				stop()
+				<-answer
+			}
+		}
+
+		cancelOnSignal(sig, sigs, cancel, exit)
+		return
+	}
+}
@@ -0,0 +1,316 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrompter_Confirm(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		interactive bool
+		want        bool
+	}{
+		{"Yes", "y\n", true, true},
+		{"YesWord", " Yes \n", true, true},
+		{"YesNoNewline", "y", true, true},
+		{"No", "n\n", true, false},
+		{"Default", "\n", true, false},
+		{"Other", "sure\n", true, false},
+		{"EOF", "", true, false},
+		{"NonInteractive", "y\n", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+
+			p := newPrompter(strings.NewReader(tt.input), &out, tt.interactive)
+
+			assert.Equal(t, tt.want, p.confirm(context.Background(), "Proceed?"))
+
+			if tt.interactive {
+				assert.True(t, strings.HasPrefix(out.String(), "Proceed? [y/N]: "), out.String())
+			} else {
+				assert.Empty(t, out.String())
+			}
+		})
+	}
+}
+
+func TestPrompter_ConfirmNil(t *testing.T) {
+	var p *prompter
+
+	assert.False(t, p.canPrompt())
+	assert.False(t, p.confirm(context.Background(), "Proceed?"))
+}
+
+func TestPrompter_ConfirmCancelled(t *testing.T) {
+	r, w := io.Pipe()
+	t.Cleanup(func() { r.Close() })
+
+	p := newPrompter(r, io.Discard, true)
+
+	// A prompt abandoned before it is answered declines.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.False(t, p.confirm(ctx, "Proceed?"))
+
+	// The answer typed afterwards is received by the next prompt.
+	go func() {
+		_, _ = io.WriteString(w, "y\n")
+	}()
+
+	assert.True(t, p.confirm(context.Background(), "Proceed?"))
+}
+
+// lineWriter is an io.Writer that sends each write to a channel.
+type lineWriter chan string
+
+func (lw lineWriter) Write(p []byte) (int, error) {
+	lw <- string(p)
+	return len(p), nil
+}
+
+// awaitWrite waits for a write containing s to lw.
+func (lw lineWriter) awaitWrite(t *testing.T, s string) {
+	t.Helper()
+
+	for {
+		select {
+		case w := <-lw:
+			if strings.Contains(w, s) {
+				return
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out waiting for %q", s)
+		}
+	}
+}
+
+func TestApp_AwaitInterrupt(t *testing.T) {
+	tests := []struct {
+		name          string
+		confirmCancel bool
+		interactive   bool
+		input         string
+		signals       []os.Signal
+		wantDeclined  int
+		wantCancelled bool
+	}{
+		{
+			name:          "NoConfirm",
+			interactive:   true,
+			signals:       []os.Signal{os.Interrupt},
+			wantCancelled: true,
+		},
+		{
+			name:          "Confirmed",
+			confirmCancel: true,
+			interactive:   true,
+			input:         "y\n",
+			signals:       []os.Signal{os.Interrupt},
+			wantCancelled: true,
+		},
+		{
+			name:          "Declined",
+			confirmCancel: true,
+			interactive:   true,
+			input:         "n\n",
+			signals:       []os.Signal{os.Interrupt},
+			wantDeclined:  1,
+		},
+		{
+			name:          "DeclinedThenConfirmed",
+			confirmCancel: true,
+			interactive:   true,
+			input:         "n\ny\n",
+			signals:       []os.Signal{os.Interrupt, os.Interrupt},
+			wantDeclined:  1,
+			wantCancelled: true,
+		},
+		{
+			name:          "NonInteractive",
+			confirmCancel: true,
+			signals:       []os.Signal{os.Interrupt},
+			wantCancelled: true,
+		},
+		{
+			name:          "Terminate",
+			confirmCancel: true,
+			interactive:   true,
+			input:         "n\n",
+			signals:       []os.Signal{syscall.SIGTERM},
+			wantCancelled: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := make(lineWriter, 16)
+
+			app := &App{
+				confirmCancel: tt.confirmCancel,
+				prompter:      newPrompter(strings.NewReader(tt.input), out, tt.interactive),
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			sigs := make(chan os.Signal)
+			done := make(chan struct{})
+
+			go func() {
+				defer close(done)
+				app.awaitInterrupt(sigs, cancel, func(code int) {
+					t.Errorf("unexpected exit with code %v", code)
+				})
+			}()
+
+			for i, sig := range tt.signals {
+				sigs <- sig
+
+				// Wait for the build to continue before interrupting it again.
+				if i < tt.wantDeclined {
+					out.awaitWrite(t, "Continuing build")
+				}
+			}
+
+			close(sigs)
+			<-done
+
+			assert.Equal(t, tt.wantCancelled, ctx.Err() != nil)
+		})
+	}
+}
+
+func TestApp_AwaitInterruptWhilePrompting(t *testing.T) {
+	r, _ := io.Pipe()
+	t.Cleanup(func() { r.Close() })
+
+	app := &App{
+		confirmCancel: true,
+		prompter:      newPrompter(r, io.Discard, true),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigs := make(chan os.Signal)
+	go app.awaitInterrupt(sigs, cancel, func(int) {})
+
+	// A second interrupt while the question is unanswered cancels the run.
+	sigs <- os.Interrupt
+	sigs <- os.Interrupt
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("run not cancelled")
+	}
+}
+
+func TestApp_AwaitInterruptForceExit(t *testing.T) {
+	app := &App{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigs := make(chan os.Signal)
+	exited := make(chan int, 1)
+	go app.awaitInterrupt(sigs, cancel, func(code int) { exited <- code })
+
+	// The first interrupt cancels the run, giving it the opportunity to clean up.
+	sigs <- os.Interrupt
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("run not cancelled")
+	}
+
+	// A second interrupt exits without waiting for the run to complete.
+	sigs <- os.Interrupt
+
+	select {
+	case code := <-exited:
+		assert.Equal(t, ExitInterrupted, code)
+	case <-time.After(10 * time.Second):
+		t.Fatal("no exit")
+	}
+}
+
+func TestApp_RunOverwritePrompt(t *testing.T) {
+	tests := []struct {
+		name        string
+		interactive bool
+		input       string
+		wantErr     bool
+	}{
+		{"Confirmed", true, "y\n", false},
+		{"Declined", true, "n\n", true},
+		{"NonInteractive", false, "y\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+
+			dir := t.TempDir()
+			def := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(def, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			m.files = []string{def}
+
+			dst := filepath.Join(dir, "image.sif")
+			if err := os.WriteFile(dst, []byte("existing"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			app, err := New(context.Background(), &Config{
+				URL:          m.frontend.URL,
+				BuildSpec:    def,
+				LibraryRef:   dst,
+				ArchsToBuild: []string{"amd64"},
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+			app.prompter = newPrompter(strings.NewReader(tt.input), io.Discard, tt.interactive)
+
+			err = app.Run(context.Background())
+			if got, want := err != nil, tt.wantErr; got != want {
+				t.Fatalf("got error %v, want error %v", err, want)
+			}
+
+			b, err := os.ReadFile(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.wantErr {
+				assert.Equal(t, "existing", string(b))
+				assert.Zero(t, m.submits.Load())
+			} else {
+				assert.Equal(t, mockImage, b)
+			}
+		})
+	}
+}
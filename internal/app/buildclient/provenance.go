@@ -0,0 +1,107 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sylabs/scs-build-client/internal/pkg/provenance"
+)
+
+// provenanceArtifacts returns the artifacts successfully built, as recorded in the run metadata.
+func (app *App) provenanceArtifacts() []provenance.Artifact {
+	var artifacts []provenance.Artifact
+
+	for _, am := range app.metadata.Archs {
+		// The subject is the image delivered, which differs from that built if signed.
+		digest := am.FileChecksum
+		if digest == "" {
+			digest = am.ImageChecksum
+		}
+
+		if !am.Succeeded || digest == "" {
+			continue
+		}
+
+		name := am.FileName
+		if name == "" {
+			name = libraryURI(am.LibraryRef)
+		}
+
+		artifacts = append(artifacts, provenance.Artifact{
+			Arch:             am.Arch,
+			Name:             name,
+			Digest:           digest,
+			BuildID:          am.BuildID,
+			DefinitionDigest: am.DefinitionDigest,
+		})
+	}
+
+	return artifacts
+}
+
+// writeProvenance writes SLSA provenance describing the artifacts built from definition d to
+// app.provenanceFile. If app.provenanceSigner is set, a detached signature is written to the same
+// path, with a ".sig" suffix.
+func (app *App) writeProvenance(ctx context.Context, d definition, startedOn time.Time) error {
+	version, err := app.buildClient.GetVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting Build Service version: %w", err)
+	}
+
+	definitionName := app.buildSpec
+	if definitionName != "-" {
+		definitionName = filepath.Base(definitionName)
+	}
+
+	s := provenance.NewStatement(provenance.Build{
+		FrontendURL:         app.frontendURL,
+		BuildServiceVersion: version,
+		ClientVersion:       app.userAgent,
+		DefinitionName:      definitionName,
+		DefinitionDigest:    app.metadata.DefinitionDigest,
+		Requirements:        app.requirements,
+		ContextDigest:       app.metadata.ContextDigest,
+		ContextSource:       app.metadata.ContextSource,
+		ContextCommit:       app.metadata.ContextCommit,
+		BootstrapSource:     d.bootstrapSource(),
+		Artifacts:           app.provenanceArtifacts(),
+		StartedOn:           startedOn,
+		FinishedOn:          time.Now(),
+	})
+
+	var b bytes.Buffer
+	if err := s.Write(&b); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(app.provenanceFile, b.Bytes(), 0o644); err != nil { //nolint:gosec
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote provenance to %v\n", app.provenanceFile)
+
+	if app.provenanceSigner == nil {
+		return nil
+	}
+
+	sig, err := app.provenanceSigner.Sign(b.Bytes())
+	if err != nil {
+		return err
+	}
+
+	sigFile := app.provenanceFile + ".sig"
+	if err := os.WriteFile(sigFile, sig, 0o644); err != nil { //nolint:gosec
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote provenance signature to %v\n", sigFile)
+
+	return app.publishSignatures(ctx)
+}
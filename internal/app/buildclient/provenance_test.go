@@ -0,0 +1,180 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sylabs/scs-build-client/internal/pkg/provenance"
+	"github.com/sylabs/sif/v2/pkg/integrity"
+)
+
+// prefixSigner is a provenance.Signer that returns the payload with a fixed prefix.
+type prefixSigner string
+
+func (s prefixSigner) Sign(payload []byte) ([]byte, error) {
+	return append([]byte(s), payload...), nil
+}
+
+func TestApp_RunProvenance(t *testing.T) {
+	tests := []struct {
+		name   string
+		signer provenance.Signer
+	}{
+		{"Unsigned", nil},
+		{"Signed", prefixSigner("signed:")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			provenanceFile := filepath.Join(dir, "provenance.json")
+
+			app, err := New(context.Background(), &Config{
+				URL:              m.frontend.URL,
+				BuildSpec:        defFile,
+				LibraryRef:       filepath.Join(dir, "image.sif"),
+				ArchsToBuild:     []string{"amd64"},
+				ProvenanceFile:   provenanceFile,
+				ProvenanceSigner: tt.signer,
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			if err := app.Run(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := os.ReadFile(provenanceFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var s provenance.Statement
+			if err := json.Unmarshal(b, &s); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := s.PredicateType, provenance.PredicateType; got != want {
+				t.Errorf("got predicate type %v, want %v", got, want)
+			}
+
+			if got, want := len(s.Subject), 1; got != want {
+				t.Fatalf("got %v subjects, want %v", got, want)
+			}
+
+			if got, want := "sha256."+s.Subject[0].Digest["sha256"], imageChecksum(); got != want {
+				t.Errorf("got subject digest %v, want %v", got, want)
+			}
+
+			var foundBootstrap bool
+			for _, rd := range s.Predicate.BuildDefinition.ResolvedDependencies {
+				if rd.URI == "docker://alpine:3" {
+					foundBootstrap = true
+				}
+			}
+			if !foundBootstrap {
+				t.Errorf("bootstrap source not found in dependencies")
+			}
+
+			sig, err := os.ReadFile(provenanceFile + ".sig")
+			if tt.signer == nil {
+				if !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("got error %v, want %v", err, os.ErrNotExist)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := string(sig), "signed:"+string(b); got != want {
+				t.Errorf("got signature %q, want %q", got, want)
+			}
+
+			if !strings.HasSuffix(s.Predicate.BuildDefinition.ExternalParameters.Definition.Name, "alpine.def") {
+				t.Errorf("definition name not recorded")
+			}
+		})
+	}
+}
+
+func TestApp_RunProvenanceSignedImage(t *testing.T) {
+	// Serve a SIF image, so that it can be signed.
+	b, err := os.ReadFile(filepath.Join("testdata", "deffile.sif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(b []byte) { mockImage = b }(mockImage)
+	mockImage = b
+
+	m := newMockServers(t)
+
+	dir := t.TempDir()
+
+	defFile := filepath.Join(dir, "alpine.def")
+	if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	provenanceFile := filepath.Join(dir, "provenance.json")
+	dst := filepath.Join(dir, "image.sif")
+
+	app, err := New(context.Background(), &Config{
+		URL:            m.frontend.URL,
+		BuildSpec:      defFile,
+		LibraryRef:     dst,
+		ArchsToBuild:   []string{"amd64"},
+		ProvenanceFile: provenanceFile,
+		SignerOpts:     []integrity.SignerOpt{integrity.OptSignWithEntity(newTestEntity(t))},
+	})
+	if err != nil {
+		t.Fatalf("initialization error: %v", err)
+	}
+
+	if err := app.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = os.ReadFile(provenanceFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var s provenance.Statement
+	if err := json.Unmarshal(b, &s); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(s.Subject), 1; got != want {
+		t.Fatalf("got %v subjects, want %v", got, want)
+	}
+
+	// The subject is the signed image delivered, rather than that built.
+	want, err := fileChecksum(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := "sha256." + s.Subject[0].Digest["sha256"]; got != want {
+		t.Errorf("got subject digest %v, want %v", got, want)
+	}
+}
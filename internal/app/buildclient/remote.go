@@ -0,0 +1,126 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultRemoteConfigFile returns the path of the Singularity remote configuration file of the
+// current user, $HOME/.singularity/remote.yaml.
+func DefaultRemoteConfigFile() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".singularity", "remote.yaml"), nil
+}
+
+// remoteConfig is the subset of the Singularity remote configuration used to locate credentials.
+type remoteConfig struct {
+	Active  string                     `yaml:"Active"`
+	Remotes map[string]*remoteEndpoint `yaml:"Remotes"`
+}
+
+// remoteEndpoint is a remote endpoint in the Singularity remote configuration.
+type remoteEndpoint struct {
+	URI   string `yaml:"URI"`
+	Token string `yaml:"Token"`
+}
+
+// hostPort returns the host and port of rawURL, in lower case. If rawURL contains no scheme, it is
+// assumed to be HTTPS. If rawURL contains no port, the default port for the scheme is returned.
+func hostPort(rawURL string) (string, error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+
+	return strings.ToLower(u.Hostname()) + ":" + port, nil
+}
+
+// tokenForURL returns the name of the endpoint matching the host of frontendURL, along with its
+// token. If more than one endpoint matches, the active endpoint is preferred, followed by the
+// first by name. Endpoints without a token are ignored. If no endpoint matches, ok is false.
+func (rc *remoteConfig) tokenForURL(frontendURL string) (name, token string, ok bool) {
+	want, err := hostPort(frontendURL)
+	if err != nil {
+		return "", "", false
+	}
+
+	var names []string
+	for name, ep := range rc.Remotes {
+		if ep == nil || ep.Token == "" {
+			continue
+		}
+
+		if got, err := hostPort(ep.URI); err == nil && got == want {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		return "", "", false
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		if (names[i] == rc.Active) != (names[j] == rc.Active) {
+			return names[i] == rc.Active
+		}
+		return names[i] < names[j]
+	})
+
+	return names[0], rc.Remotes[names[0]].Token, true
+}
+
+// remoteToken returns the token for frontendURL in the Singularity remote configuration file at
+// path. If the file does not exist, or contains no matching endpoint, an empty token is returned.
+// The file belongs to Singularity, so if it cannot be parsed, a warning is output and an empty
+// token returned, rather than failing the build.
+func remoteToken(path, frontendURL string) (string, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading remote config: %w", err)
+	}
+
+	var rc remoteConfig
+	if err := yaml.Unmarshal(b, &rc); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring remote config %v: %v\n", path, err)
+		return "", nil
+	}
+
+	name, token, ok := rc.tokenForURL(frontendURL)
+	if !ok {
+		return "", nil
+	}
+
+	fmt.Fprintf(os.Stderr, "Using auth token for remote endpoint %v from %v\n", name, path)
+
+	return token, nil
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestRemoteConfig_tokenForURL(t *testing.T) {
+	rc := remoteConfig{
+		Active: "Enterprise",
+		Remotes: map[string]*remoteEndpoint{
+			"SylabsCloud":    {URI: "cloud.sylabs.io", Token: "cloud-token"},
+			"Enterprise":     {URI: "enterprise.example.com", Token: "enterprise-token"},
+			"EnterpriseAlt":  {URI: "https://enterprise.example.com/", Token: "enterprise-alt-token"},
+			"EnterprisePort": {URI: "https://enterprise.example.com:8443", Token: "port-token"},
+			"Insecure":       {URI: "http://insecure.example.com", Token: "insecure-token"},
+			"Staging":        {URI: "staging.example.com", Token: "staging-token"},
+			"StagingZ":       {URI: "staging.example.com", Token: "staging-z-token"},
+			"NoToken":        {URI: "notoken.example.com"},
+			"Nil":            nil,
+		},
+	}
+
+	tests := []struct {
+		name        string
+		frontendURL string
+		wantName    string
+		wantToken   string
+		wantOK      bool
+	}{
+		{"DefaultCloud", defaultFrontendURL, "SylabsCloud", "cloud-token", true},
+		{"CloudTrailingSlash", "https://cloud.sylabs.io/", "SylabsCloud", "cloud-token", true},
+		{"CloudCase", "https://Cloud.Sylabs.IO", "SylabsCloud", "cloud-token", true},
+		{"CloudDefaultPort", "https://cloud.sylabs.io:443", "SylabsCloud", "cloud-token", true},
+		{"EnterpriseActive", "https://enterprise.example.com", "Enterprise", "enterprise-token", true},
+		{"EnterprisePort", "https://enterprise.example.com:8443", "EnterprisePort", "port-token", true},
+		{"InsecureHTTP", "http://insecure.example.com", "Insecure", "insecure-token", true},
+		{"InsecureHTTPS", "https://insecure.example.com", "", "", false},
+		{"FirstByName", "https://staging.example.com", "Staging", "staging-token", true},
+		{"NoToken", "https://notoken.example.com", "", "", false},
+		{"NoMatch", "https://other.example.com", "", "", false},
+		{"SubdomainNoMatch", "https://sub.cloud.sylabs.io", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, token, ok := rc.tokenForURL(tt.frontendURL)
+
+			if got, want := ok, tt.wantOK; got != want {
+				t.Fatalf("got ok %v, want %v", got, want)
+			}
+			if got, want := name, tt.wantName; got != want {
+				t.Errorf("got name %v, want %v", got, want)
+			}
+			if got, want := token, tt.wantToken; got != want {
+				t.Errorf("got token %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRemoteToken(t *testing.T) {
+	dir := t.TempDir()
+
+	writeConfig := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	valid := writeConfig("remote.yaml", `Active: SylabsCloud
+Remotes:
+  SylabsCloud:
+    URI: cloud.sylabs.io
+    Token: cloud-token
+    System: true
+    Exclusive: false
+`)
+	malformed := writeConfig("malformed.yaml", "Remotes: [")
+
+	tests := []struct {
+		name      string
+		path      string
+		wantToken string
+		wantErr   bool
+	}{
+		{"Match", valid, "cloud-token", false},
+		{"Missing", filepath.Join(dir, "missing.yaml"), "", false},
+		{"Malformed", malformed, "", false},
+		{"Unreadable", dir, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := remoteToken(tt.path, defaultFrontendURL)
+			if got, want := err != nil, tt.wantErr; got != want {
+				t.Fatalf("got error %v, want error %v", err, want)
+			}
+
+			if got, want := token, tt.wantToken; got != want {
+				t.Errorf("got token %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApp_RunRemoteConfigToken(t *testing.T) {
+	tests := []struct {
+		name      string
+		authToken string
+		remote    bool
+		wantToken string
+	}{
+		{"RemoteConfig", "", true, "remote-token"},
+		{"ExplicitToken", "explicit-token", true, "explicit-token"},
+		{"RemoteConfigDisabled", "", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+
+			// Accept only the expected token. When no token is expected, require the token in the
+			// remote config, which must not be sent.
+			m.acceptToken = tt.wantToken
+			if m.acceptToken == "" {
+				m.acceptToken = "remote-token"
+			}
+
+			dir := t.TempDir()
+
+			remoteConfigFile := filepath.Join(dir, "remote.yaml")
+			if err := os.WriteFile(remoteConfigFile, []byte(`Active: Enterprise
+Remotes:
+  Enterprise:
+    URI: `+m.frontend.URL+`
+    Token: remote-token
+`), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := &Config{
+				URL:          m.frontend.URL,
+				AuthToken:    tt.authToken,
+				BuildSpec:    "docker://alpine:3",
+				LibraryRef:   filepath.Join(dir, "image.sif"),
+				ArchsToBuild: []string{runtime.GOARCH},
+			}
+			if tt.remote {
+				cfg.RemoteConfigFile = remoteConfigFile
+			}
+
+			app, err := New(context.Background(), cfg)
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			err = app.Run(context.Background())
+			if tt.wantToken == "" {
+				if err == nil {
+					t.Fatal("unexpected success without token")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
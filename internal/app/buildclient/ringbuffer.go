@@ -0,0 +1,81 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// ringBuffer is an io.Writer that retains the most recent bytes written to it, up to a fixed size.
+type ringBuffer struct {
+	buf  []byte
+	next int  // Index at which the next byte is written.
+	full bool // Set once buf has wrapped.
+}
+
+// newRingBuffer returns a ringBuffer that retains up to size bytes.
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{buf: make([]byte, size)}
+}
+
+// Write writes p to the buffer, discarding the oldest bytes as necessary. It always succeeds.
+func (rb *ringBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+
+	if len(rb.buf) == 0 {
+		return n, nil
+	}
+
+	// Only the trailing bytes of p that fit in the buffer are retained.
+	if len(p) >= len(rb.buf) {
+		copy(rb.buf, p[len(p)-len(rb.buf):])
+		rb.next = 0
+		rb.full = true
+		return n, nil
+	}
+
+	c := copy(rb.buf[rb.next:], p)
+	if c < len(p) {
+		copy(rb.buf, p[c:])
+		rb.full = true
+	}
+	rb.next = (rb.next + len(p)) % len(rb.buf)
+	if rb.next == 0 {
+		rb.full = true
+	}
+
+	return n, nil
+}
+
+// Bytes returns the retained bytes, oldest first. If the buffer has wrapped, any partial UTF-8
+// sequence at the start of the retained bytes is dropped.
+func (rb *ringBuffer) Bytes() []byte {
+	if !rb.full {
+		return append([]byte(nil), rb.buf[:rb.next]...)
+	}
+
+	b := make([]byte, 0, len(rb.buf))
+	b = append(b, rb.buf[rb.next:]...)
+	b = append(b, rb.buf[:rb.next]...)
+
+	// Skip continuation bytes of a sequence whose leading byte was discarded.
+	for i := 0; i < len(b) && i < utf8.UTFMax; i++ {
+		if utf8.RuneStart(b[i]) {
+			return b[i:]
+		}
+	}
+	return b
+}
+
+// lastLines returns up to the final n lines of s.
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
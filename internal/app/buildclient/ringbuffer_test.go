@@ -0,0 +1,145 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRingBuffer(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		writes []string
+		want   string
+	}{
+		{"Empty", 8, nil, ""},
+		{"ZeroSize", 0, []string{"abc"}, ""},
+		{"Partial", 8, []string{"abc"}, "abc"},
+		{"Exact", 8, []string{"abcdefgh"}, "abcdefgh"},
+		{"ExactMultipleWrites", 8, []string{"abcd", "efgh"}, "abcdefgh"},
+		{"WrapAround", 8, []string{"abcdef", "ghij"}, "cdefghij"},
+		{"WrapAroundMany", 4, []string{"ab", "cd", "ef", "gh", "i"}, "fghi"},
+		{"LargeWrite", 4, []string{"ab", "cdefghij"}, "ghij"},
+		{"FilledThenPartial", 4, []string{"abcd", "e"}, "bcde"},
+		{"MultiByteIntact", 7, []string{"ab", "héllo"}, "bhéllo"},
+		{"MultiByteSplit", 4, []string{"aé", "bcd"}, "bcd"},     // Leading byte of 'é' discarded.
+		{"MultiByteSplit4", 6, []string{"a😀", "bcd"}, "bcd"},    // Leading bytes of '😀' discarded.
+		{"MultiByteBoundary", 5, []string{"aé", "bcd"}, "ébcd"}, // 'é' retained in full.
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rb := newRingBuffer(tt.size)
+
+			for _, w := range tt.writes {
+				n, err := rb.Write([]byte(w))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := n, len(w); got != want {
+					t.Errorf("got %v bytes written, want %v", got, want)
+				}
+			}
+
+			if got, want := string(rb.Bytes()), tt.want; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestLastLines(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		n    int
+		want string
+	}{
+		{"Empty", "", 2, ""},
+		{"Fewer", "a\nb\n", 3, "a\nb"},
+		{"Exact", "a\nb\n", 2, "a\nb"},
+		{"More", "a\nb\nc\n", 2, "b\nc"},
+		{"NoTrailingNewline", "a\nb\nc", 2, "b\nc"},
+		{"Zero", "a\nb\n", 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, want := lastLines(tt.s, tt.n), tt.want; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestApp_RunBuildFailure(t *testing.T) {
+	m := newMockServers(t)
+	m.failBuild = true
+
+	for i := 0; i < 1000; i++ {
+		m.output = append(m.output, fmt.Sprintf("line %v\n", i))
+	}
+
+	dir := t.TempDir()
+
+	defFile := filepath.Join(dir, "alpine.def")
+	if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	resumeFile := filepath.Join(dir, "metadata.json")
+
+	app, err := New(context.Background(), &Config{
+		URL:            m.frontend.URL,
+		BuildSpec:      defFile,
+		LibraryRef:     filepath.Join(dir, "image.sif"),
+		ArchsToBuild:   []string{"amd64"},
+		ResumeFile:     resumeFile,
+		OutputTailSize: 1024,
+	})
+	if err != nil {
+		t.Fatalf("initialization error: %v", err)
+	}
+
+	err = app.Run(context.Background())
+
+	var bfe *BuildFailureError
+	if !errors.As(err, &bfe) {
+		t.Fatalf("got error %v, want BuildFailureError", err)
+	}
+
+	if got, want := bfe.Arch, "amd64"; got != want {
+		t.Errorf("got arch %v, want %v", got, want)
+	}
+
+	if got, want := len(bfe.OutputTail), 1024; got != want {
+		t.Errorf("got %v bytes of output, want %v", got, want)
+	}
+
+	if !strings.HasSuffix(bfe.OutputTail, "line 998\nline 999\n") {
+		t.Errorf("output tail %q does not end with final lines", bfe.OutputTail)
+	}
+
+	if got, want := lastLines(bfe.OutputTail, 2), "line 998\nline 999"; got != want {
+		t.Errorf("got last lines %q, want %q", got, want)
+	}
+
+	md, err := readMetadata(resumeFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if am := md.arch("amd64"); am == nil || am.OutputTail != bfe.OutputTail {
+		t.Errorf("output tail not recorded in metadata")
+	}
+}
@@ -6,10 +6,84 @@
 package buildclient
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
 	"github.com/sylabs/sif/v2/pkg/integrity"
 	"github.com/sylabs/sif/v2/pkg/sif"
 )
 
+// SignatureLog records signatures in a transparency log.
+type SignatureLog interface {
+	// Publish records the signatures made since the last call, and returns the URLs of the entries
+	// created.
+	Publish(ctx context.Context) ([]string, error)
+}
+
+// publishSignatures records signatures made since the last call in app.signatureLog, if set.
+func (app *App) publishSignatures(ctx context.Context) error {
+	if app.signatureLog == nil {
+		return nil
+	}
+
+	urls, err := app.signatureLog.Publish(ctx)
+	for _, u := range urls {
+		fmt.Fprintf(app.stdout, "Signature recorded in transparency log: %v\n", u)
+	}
+	return err
+}
+
+var errSigningCheck = errors.New("signing configuration check failed")
+
+// checkSigning signs a throwaway in-memory image using app.signerOpts and, if set, verifies the
+// signature using app.verifierOpts. This reports a misconfigured key, such as one that has not
+// been decrypted, before a build is submitted rather than once it completes.
+//
+// Signing is not checked when signatures are published to a transparency log, since the check
+// would be published along with them.
+func (app *App) checkSigning() error {
+	if app.signerOpts == nil || app.signatureLog != nil {
+		return nil
+	}
+
+	di, err := sif.NewDescriptorInput(sif.DataGeneric, strings.NewReader("signing check"))
+	if err != nil {
+		return err
+	}
+
+	f, err := sif.CreateContainer(sif.NewBuffer(nil), sif.OptCreateWithDescriptors(di))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.UnloadContainer()
+	}()
+
+	is, err := integrity.NewSigner(f, app.signerOpts...)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errSigningCheck, err)
+	}
+	if err := is.Sign(); err != nil {
+		return fmt.Errorf("%w: %w", errSigningCheck, err)
+	}
+
+	if app.verifierOpts == nil {
+		return nil
+	}
+
+	iv, err := integrity.NewVerifier(f, app.verifierOpts...)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errSigningCheck, err)
+	}
+	if err := iv.Verify(); err != nil {
+		return fmt.Errorf("%w: %w", errSigningCheck, err)
+	}
+	return nil
+}
+
 func sign(fileName string, opts ...integrity.SignerOpt) error {
 	f, err := sif.LoadContainerFromPath(fileName)
 	if err != nil {
@@ -28,3 +102,20 @@ func sign(fileName string, opts ...integrity.SignerOpt) error {
 	}
 	return is.Sign()
 }
+
+// verify verifies the signatures of the named image, using opts.
+func verify(fileName string, opts ...integrity.VerifierOpt) error {
+	f, err := sif.LoadContainerFromPath(fileName, sif.OptLoadWithFlag(os.O_RDONLY))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.UnloadContainer()
+	}()
+
+	iv, err := integrity.NewVerifier(f, opts...)
+	if err != nil {
+		return err
+	}
+	return iv.Verify()
+}
@@ -0,0 +1,518 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/spf13/viper"
+	"github.com/sylabs/scs-build-client/internal/pkg/provenance"
+	"github.com/sylabs/sif/v2/pkg/integrity"
+	"github.com/sylabs/sif/v2/pkg/sif"
+)
+
+// newTestEntity returns a throwaway PGP entity.
+func newTestEntity(t *testing.T) *openpgp.Entity {
+	t.Helper()
+
+	e, err := openpgp.NewEntity("Test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// copyTestImage copies the test SIF image to dir, and returns its path.
+func copyTestImage(t *testing.T, dir string) string {
+	t.Helper()
+
+	b, err := os.ReadFile(filepath.Join("testdata", "deffile.sif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := filepath.Join(dir, "image.sif")
+	if err := os.WriteFile(name, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// corruptImage flips a bit in the first data object of the named image.
+func corruptImage(t *testing.T, name string) {
+	t.Helper()
+
+	f, err := sif.LoadContainerFromPath(name, sif.OptLoadWithFlag(os.O_RDONLY))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := f.GetDescriptor(sif.WithDataType(sif.DataDeffile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	off := d.Offset()
+	if err := f.UnloadContainer(); err != nil {
+		t.Fatal(err)
+	}
+
+	fp, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+
+	b := make([]byte, 1)
+	if _, err := fp.ReadAt(b, off); err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 1
+	if _, err := fp.WriteAt(b, off); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	e := newTestEntity(t)
+
+	tests := []struct {
+		name    string
+		corrupt bool
+		key     *openpgp.Entity
+		wantErr bool
+	}{
+		{"Success", false, e, false},
+		{"Corrupted", true, e, true},
+		{"WrongKey", false, newTestEntity(t), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := copyTestImage(t, t.TempDir())
+
+			if err := sign(name, integrity.OptSignWithEntity(e)); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.corrupt {
+				corruptImage(t, name)
+			}
+
+			err := verify(name, integrity.OptVerifyWithKeyRing(openpgp.EntityList{tt.key}))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApp_RunVerifySignature(t *testing.T) {
+	// Serve a SIF image, so that it can be signed.
+	b, err := os.ReadFile(filepath.Join("testdata", "deffile.sif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(b []byte) { mockImage = b }(mockImage)
+	mockImage = b
+
+	e := newTestEntity(t)
+
+	tests := []struct {
+		name         string
+		verifierOpts []integrity.VerifierOpt
+		wantErr      error
+	}{
+		{
+			name:         "Verified",
+			verifierOpts: []integrity.VerifierOpt{integrity.OptVerifyWithKeyRing(openpgp.EntityList{e})},
+		},
+		{
+			name: "SkipVerification",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			imageFile := filepath.Join(dir, "image.sif")
+
+			app, err := New(context.Background(), &Config{
+				URL:          m.frontend.URL,
+				BuildSpec:    defFile,
+				LibraryRef:   imageFile,
+				ArchsToBuild: []string{"amd64"},
+				SignerOpts:   []integrity.SignerOpt{integrity.OptSignWithEntity(e)},
+				VerifierOpts: tt.verifierOpts,
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			err = app.Run(context.Background())
+
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			// The image is only written to its destination once verified.
+			if _, err := os.Stat(imageFile); (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("unexpected destination state: %v", err)
+			}
+		})
+	}
+}
+
+// fakeKeylessSigner is a signature.SignerVerifier that records the signatures it makes in a fake
+// transparency log.
+type fakeKeylessSigner struct {
+	signature.SignerVerifier
+
+	mu        sync.Mutex
+	pending   int      // Number of signatures yet to be published.
+	published []string // URLs of entries published.
+	err       error    // If set, returned by Publish.
+}
+
+func newFakeKeylessSigner(t *testing.T) *fakeKeylessSigner {
+	t.Helper()
+
+	sv, _, err := signature.NewDefaultECDSASignerVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fakeKeylessSigner{SignerVerifier: sv}
+}
+
+func (s *fakeKeylessSigner) SignMessage(message io.Reader, opts ...signature.SignOption) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending++
+	return s.SignerVerifier.SignMessage(message, opts...)
+}
+
+func (s *fakeKeylessSigner) Publish(context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	var urls []string
+	for ; s.pending > 0; s.pending-- {
+		urls = append(urls, fmt.Sprintf("https://rekor.example.com/api/v1/log/entries/%d", len(s.published)))
+		s.published = append(s.published, urls[len(urls)-1])
+	}
+	return urls, nil
+}
+
+func TestApp_RunSignKeyless(t *testing.T) {
+	// Serve a SIF image, so that it can be signed.
+	b, err := os.ReadFile(filepath.Join("testdata", "deffile.sif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(b []byte) { mockImage = b }(mockImage)
+	mockImage = b
+
+	errPublish := errors.New("publish failed")
+
+	tests := []struct {
+		name          string
+		provenance    bool
+		publishErr    error
+		wantErr       error
+		wantPublished int
+	}{
+		{
+			name:          "Image",
+			wantPublished: 1,
+		},
+		{
+			name:          "ImageAndProvenance",
+			provenance:    true,
+			wantPublished: 2,
+		},
+		{
+			name:       "PublishFailed",
+			publishErr: errPublish,
+			wantErr:    errPublish,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			ks := newFakeKeylessSigner(t)
+			ks.err = tt.publishErr
+
+			cfg := &Config{
+				URL:          m.frontend.URL,
+				BuildSpec:    defFile,
+				LibraryRef:   filepath.Join(dir, "image.sif"),
+				ArchsToBuild: []string{"amd64"},
+				SignerOpts:   []integrity.SignerOpt{integrity.OptSignWithSigner(ks)},
+				VerifierOpts: []integrity.VerifierOpt{integrity.OptVerifyWithVerifier(ks)},
+				SignatureLog: ks,
+			}
+			if tt.provenance {
+				cfg.ProvenanceFile = filepath.Join(dir, "provenance.json")
+				cfg.ProvenanceSigner = provenance.NewKeySigner(ks)
+			}
+
+			app, err := New(context.Background(), cfg)
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			err = app.Run(context.Background())
+
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if got, want := len(ks.published), tt.wantPublished; got != want {
+				t.Errorf("got %v signatures published, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseSigningOptsKeyless(t *testing.T) {
+	// No identity token is needed until an image is signed.
+	t.Setenv("SIGSTORE_ID_TOKEN", "")
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_URL", "")
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN", "")
+
+	tests := []struct {
+		name    string
+		proxy   string
+		wantErr error
+	}{
+		{name: "NoCredentials"},
+		{name: "InvalidProxy", proxy: "ftp://proxy.example.com", wantErr: errInvalidProxy},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := viper.New()
+			v.Set(keySignKeyless, true)
+			v.Set(keyProxy, tt.proxy)
+
+			so, err := parseSigningOpts(v)
+
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if err == nil && so.signatureLog == nil {
+				t.Errorf("no signature log")
+			}
+		})
+	}
+}
+
+func TestBuildCommandSignKeylessExclusive(t *testing.T) {
+	rootCmd := buildTestRoot()
+
+	tests := []struct {
+		name string
+		flag []string
+	}{
+		{"Key", []string{"--key", "key.pem"}},
+		{"Keyring", []string{"--keyring", "keyring.gpg"}},
+		{"Fingerprint", []string{"--fingerprint", "abcd"}},
+		{"KeyIndex", []string{"--keyidx", "1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rootCmd.SetArgs(append([]string{"build", "--sign-keyless", "alpine.def", "library:user/project/image"}, tt.flag...))
+			rootCmd.SetOut(&bytes.Buffer{})
+
+			err := rootCmd.Execute()
+			if err == nil || !strings.Contains(err.Error(), "sign-keyless") {
+				t.Errorf("got error %v, want mutually exclusive flags error", err)
+			}
+
+			resetBuildFlags()
+		})
+	}
+}
+
+// writeKeyring writes a keyring containing e to dir, with its private key encrypted using
+// passphrase, if set, and returns its path.
+func writeKeyring(t *testing.T, dir string, e *openpgp.Entity, passphrase string) string {
+	t.Helper()
+
+	var b bytes.Buffer
+	if err := e.SerializePrivate(&b, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Encrypt a copy, so that e remains decrypted.
+	el, err := openpgp.ReadKeyRing(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if passphrase != "" {
+		if err := el[0].EncryptPrivateKeys([]byte(passphrase), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	name := filepath.Join(dir, "secring.gpg")
+
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := el[0].SerializePrivateWithoutSigning(f, nil); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestParseSigningOptsKeyring(t *testing.T) {
+	e := newTestEntity(t)
+
+	tests := []struct {
+		name       string
+		encrypt    string // If set, passphrase used to encrypt keyring.
+		passphrase string
+		fd         bool // If set, passphrase is read from a file descriptor.
+		missing    bool
+		wantErr    bool
+	}{
+		{name: "Unencrypted"},
+		{name: "Encrypted", encrypt: "secret", passphrase: "secret"},
+		{name: "EncryptedFD", encrypt: "secret", passphrase: "secret", fd: true},
+		{name: "WrongPassphrase", encrypt: "secret", passphrase: "wrong", wantErr: true},
+		{name: "MissingKeyring", missing: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			keyring := filepath.Join(dir, "missing.gpg")
+			if !tt.missing {
+				keyring = writeKeyring(t, dir, e, tt.encrypt)
+			}
+
+			v := viper.New()
+			v.Set(keyKeyring, keyring)
+			v.Set(keySigningKeyIndex, 0)
+			if tt.fd {
+				v.Set(keyPassphraseFD, passphraseFD(t, tt.passphrase+"\n"))
+			} else {
+				v.Set(keyPassphrase, tt.passphrase)
+			}
+
+			so, err := parseSigningOpts(v)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			// The key is decrypted once parsed, so signing does not prompt for the passphrase again.
+			app := &App{signerOpts: so.signerOpts, verifierOpts: so.verifierOpts}
+			if err := app.checkSigning(); err != nil {
+				t.Errorf("check failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestApp_RunCheckSigning(t *testing.T) {
+	e := newTestEntity(t)
+
+	// An entity whose private key remains encrypted cannot sign.
+	encrypted := newTestEntity(t)
+	if err := encrypted.EncryptPrivateKeys([]byte("secret"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		signerOpts   []integrity.SignerOpt
+		verifierOpts []integrity.VerifierOpt
+		wantErr      error
+	}{
+		{
+			name:         "Valid",
+			signerOpts:   []integrity.SignerOpt{integrity.OptSignWithEntity(e)},
+			verifierOpts: []integrity.VerifierOpt{integrity.OptVerifyWithKeyRing(openpgp.EntityList{e})},
+		},
+		{
+			name:       "EncryptedKey",
+			signerOpts: []integrity.SignerOpt{integrity.OptSignWithEntity(encrypted)},
+			wantErr:    errSigningCheck,
+		},
+		{
+			name:         "WrongVerifier",
+			signerOpts:   []integrity.SignerOpt{integrity.OptSignWithEntity(e)},
+			verifierOpts: []integrity.VerifierOpt{integrity.OptVerifyWithKeyRing(openpgp.EntityList{newTestEntity(t)})},
+			wantErr:      errSigningCheck,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+
+			_, err := New(context.Background(), &Config{
+				URL:          m.frontend.URL,
+				BuildSpec:    "docker://alpine",
+				LibraryRef:   filepath.Join(t.TempDir(), "image.sif"),
+				ArchsToBuild: []string{"amd64"},
+				SignerOpts:   tt.signerOpts,
+				VerifierOpts: tt.verifierOpts,
+			})
+
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if got := m.submits.Load(); got != 0 {
+				t.Errorf("got %v submits, want 0", got)
+			}
+		})
+	}
+}
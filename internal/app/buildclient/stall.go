@@ -0,0 +1,336 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultLibraryStallTimeout is the period without progress after which a library transfer is
+	// considered stalled.
+	defaultLibraryStallTimeout = 2 * time.Minute
+
+	// libraryTimeoutBase is the allowance made for each library operation, regardless of size.
+	libraryTimeoutBase = 5 * time.Minute
+
+	// minLibraryThroughput is the lowest throughput (in bytes per second) assumed when sizing the
+	// timeout of a library operation.
+	minLibraryThroughput = 1 << 20
+)
+
+var errTransferTimeout = errors.New("transfer timed out")
+
+// StallError is returned when a library transfer makes no progress for the stall timeout.
+type StallError struct {
+	Op          string        // Operation, such as "download" or "upload".
+	Transferred int64         // Bytes transferred before the transfer stalled.
+	Active      time.Duration // Period from the start of the transfer to the last progress.
+	Idle        time.Duration // Period without progress.
+}
+
+func (e *StallError) Error() string {
+	verb := "received"
+	if e.Op == "upload" {
+		verb = "sent"
+	}
+	return fmt.Sprintf("%v stalled (%v %v in %v, then no progress for %v)",
+		e.Op, verb, formatBytes(e.Transferred), formatSeconds(e.Active), formatSeconds(e.Idle))
+}
+
+// formatBytes returns n in human readable form, using decimal units.
+func formatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
+
+// formatSeconds returns d in whole seconds.
+func formatSeconds(d time.Duration) string {
+	return fmt.Sprintf("%ds", d.Round(time.Second)/time.Second)
+}
+
+// formatDuration returns d in the format of time.Duration.String, omitting trailing zero units, such
+// as "30m" rather than "30m0s".
+func formatDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// transferProgress describes the progress of a library transfer.
+type transferProgress struct {
+	Op          string // Operation, such as "download" or "upload".
+	Transferred int64  // Bytes transferred.
+	Total       int64  // Total bytes to transfer, or -1 if unknown.
+	Done        bool   // The transfer completed successfully.
+}
+
+func (p transferProgress) String() string {
+	op := p.Op
+	if op != "" {
+		op = strings.ToUpper(op[:1]) + op[1:]
+	}
+
+	// Servers that stream with chunked encoding do not report a length, so only the bytes
+	// transferred are known.
+	if p.Total < 0 {
+		return fmt.Sprintf("%v progress: %v (size unknown)", op, formatBytes(p.Transferred))
+	}
+
+	pct := int64(100)
+	if p.Total > 0 {
+		pct = min(100, 100*p.Transferred/p.Total)
+	}
+	return fmt.Sprintf("%v progress: %v of %v (%d%%)", op, formatBytes(p.Transferred), formatBytes(p.Total), pct)
+}
+
+// stallMonitor records the progress of a transfer, in order to detect when it stalls. Stalls are
+// detected by the time since the last progress, so transfers of unknown size are monitored in the
+// same way as those whose size is known.
+type stallMonitor struct {
+	op      string
+	timeout time.Duration
+	now     func() time.Time
+
+	mu    sync.Mutex
+	start time.Time // Start of the transfer.
+	last  time.Time // Time of the last progress.
+	n     int64     // Bytes transferred.
+	total int64     // Total bytes to transfer, or -1 if unknown.
+}
+
+// newStallMonitor returns a stallMonitor for the transfer op, which stalls once no progress is
+// made for timeout. The current time is obtained from now.
+func newStallMonitor(op string, timeout time.Duration, now func() time.Time) *stallMonitor {
+	t := now()
+	return &stallMonitor{op: op, timeout: timeout, now: now, start: t, last: t, total: -1}
+}
+
+// setTotal records the total size of the transfer, as reported by the server. A negative size
+// indicates the size is unknown.
+func (m *stallMonitor) setTotal(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.total = max(n, -1)
+}
+
+// snapshot returns the progress of the transfer.
+func (m *stallMonitor) snapshot() transferProgress {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return transferProgress{Op: m.op, Transferred: m.n, Total: m.total}
+}
+
+// progress records the transfer of n bytes.
+func (m *stallMonitor) progress(n int) {
+	if n <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.n += int64(n)
+	m.last = m.now()
+}
+
+// check returns a *StallError if no progress has been made for the stall timeout.
+func (m *stallMonitor) check() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if idle := m.now().Sub(m.last); idle >= m.timeout {
+		return &StallError{
+			Op:          m.op,
+			Transferred: m.n,
+			Active:      m.last.Sub(m.start),
+			Idle:        idle,
+		}
+	}
+	return nil
+}
+
+// watch checks for a stall each time tick fires, until ctx is done. If the transfer stalls, cancel
+// is called with the resulting error. Otherwise, if report is not nil, it is called with the
+// progress of the transfer.
+func (m *stallMonitor) watch(ctx context.Context, cancel context.CancelCauseFunc, tick <-chan time.Time, report func(transferProgress)) {
+	for {
+		select {
+		case <-tick:
+			if err := m.check(); err != nil {
+				cancel(err)
+				return
+			}
+			if report != nil {
+				report(m.snapshot())
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// stallReader is an io.Reader that reports progress to a stallMonitor.
+type stallReader struct {
+	r io.Reader
+	m *stallMonitor
+}
+
+func (sr *stallReader) Read(p []byte) (int, error) {
+	n, err := sr.r.Read(p)
+	sr.m.progress(n)
+	return n, err
+}
+
+// stallReadSeeker is an io.ReadSeeker that reports progress to a stallMonitor.
+type stallReadSeeker struct {
+	stallReader
+	s io.Seeker
+}
+
+// newStallReadSeeker returns an io.ReadSeeker that reads from rs, reporting progress to m.
+func newStallReadSeeker(rs io.ReadSeeker, m *stallMonitor) *stallReadSeeker {
+	return &stallReadSeeker{stallReader: stallReader{r: rs, m: m}, s: rs}
+}
+
+func (srs *stallReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return srs.s.Seek(offset, whence)
+}
+
+// stallWriter is an io.Writer that reports progress to a stallMonitor.
+type stallWriter struct {
+	w io.Writer
+	m *stallMonitor
+}
+
+func (sw *stallWriter) Write(p []byte) (int, error) {
+	n, err := sw.w.Write(p)
+	sw.m.progress(n)
+	return n, err
+}
+
+// stallProgressBar implements the library ProgressBar interface, reporting the progress of
+// concurrent downloads to a stallMonitor.
+type stallProgressBar struct {
+	m *stallMonitor
+
+	// If set, called with the size of the download, if reported by the server, before it begins.
+	preallocate func(size int64)
+}
+
+// Init records the size of the download. The size is negative if the server did not report it,
+// such as when the image is streamed using chunked encoding.
+func (pb *stallProgressBar) Init(size int64) {
+	pb.m.setTotal(size)
+
+	if size > 0 && pb.preallocate != nil {
+		pb.preallocate(size)
+	}
+}
+
+func (pb *stallProgressBar) ProxyReader(r io.Reader) io.ReadCloser {
+	return io.NopCloser(&stallReader{r: r, m: pb.m})
+}
+
+func (pb *stallProgressBar) IncrBy(n int) { pb.m.progress(n) }
+
+func (pb *stallProgressBar) Abort(bool) {}
+
+func (pb *stallProgressBar) Wait() {}
+
+// libraryOpTimeout returns the timeout for a library operation transferring size bytes. Unless
+// app.libraryTimeout is set, the timeout allows for the transfer to proceed at the minimum assumed
+// throughput.
+func (app *App) libraryOpTimeout(size int64) time.Duration {
+	if app.libraryTimeout > 0 {
+		return app.libraryTimeout
+	}
+	return libraryTimeoutBase + time.Duration(max(size, 0)/minLibraryThroughput)*time.Second
+}
+
+// reportProgress reports the progress of a library transfer. Unless app.progress is set, progress
+// of transfers underway is written to standard error.
+func (app *App) reportProgress(p transferProgress) {
+	if app.progress != nil {
+		app.progress(p)
+		return
+	}
+
+	if !p.Done {
+		fmt.Fprintf(os.Stderr, "%v\n", p)
+	}
+}
+
+// libraryTransfer derives a context from ctx for the library operation op, which transfers size
+// bytes. The context is cancelled if the operation does not complete within the library timeout,
+// or if no progress is reported to the returned stallMonitor for app.libraryStallTimeout.
+//
+// Progress is reported periodically while the operation is underway, and once it completes
+// successfully. The total size reported is that recorded using (*stallMonitor).setTotal, if any.
+//
+// The returned function must be called with the outcome of the operation once it completes. It
+// releases resources associated with the context, and if the operation failed due to a timeout or
+// stall, returns an error describing it in place of the cancellation error.
+func (app *App) libraryTransfer(ctx context.Context, op string, size int64) (context.Context, *stallMonitor, func(error) error) {
+	d := app.libraryOpTimeout(size)
+
+	tctx, cancelTimeout := context.WithTimeoutCause(ctx, d,
+		fmt.Errorf("%w: %v not complete after %v", errTransferTimeout, op, formatSeconds(d)))
+	tctx, cancel := context.WithCancelCause(tctx)
+
+	m := newStallMonitor(op, app.libraryStallTimeout, time.Now)
+
+	ticker := time.NewTicker(app.libraryStallTimeout / 4)
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		m.watch(tctx, cancel, ticker.C, app.reportProgress)
+	}()
+
+	return tctx, m, func(err error) error {
+		cause := context.Cause(tctx)
+
+		cancel(nil)
+		cancelTimeout()
+		ticker.Stop()
+		<-stopped
+
+		if err != nil && cause != nil && ctx.Err() == nil {
+			return cause
+		}
+
+		if err == nil {
+			p := m.snapshot()
+			p.Done = true
+			app.reportProgress(p)
+		}
+		return err
+	}
+}
@@ -0,0 +1,383 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only advances when instructed.
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.t = c.t.Add(d)
+}
+
+// fakeStream is a stream whose reads return the chunks sent on a channel, blocking until a chunk is
+// available, the channel is closed, or the context is done. The channel buffers a single chunk, so
+// that tests can deliver a chunk before reading it.
+type fakeStream struct {
+	ctx    context.Context //nolint:containedctx
+	chunks chan []byte
+}
+
+func newFakeStream(ctx context.Context) *fakeStream {
+	return &fakeStream{ctx: ctx, chunks: make(chan []byte, 1)}
+}
+
+func (s *fakeStream) Read(p []byte) (int, error) {
+	select {
+	case b, ok := <-s.chunks:
+		if !ok {
+			return 0, io.EOF
+		}
+		return copy(p, b), nil
+	case <-s.ctx.Done():
+		return 0, s.ctx.Err()
+	}
+}
+
+func TestStallError_Error(t *testing.T) {
+	tests := []struct {
+		name string
+		err  *StallError
+		want string
+	}{
+		{
+			name: "Download",
+			err:  &StallError{Op: "download", Transferred: 1_200_000_000, Active: 300 * time.Second, Idle: 120 * time.Second},
+			want: "download stalled (received 1.2GB in 300s, then no progress for 120s)",
+		},
+		{
+			name: "Upload",
+			err:  &StallError{Op: "upload", Transferred: 512, Active: 1400 * time.Millisecond, Idle: 2 * time.Minute},
+			want: "upload stalled (sent 512B in 1s, then no progress for 120s)",
+		},
+		{
+			name: "Nothing",
+			err:  &StallError{Op: "download", Idle: 2 * time.Minute},
+			want: "download stalled (received 0B in 0s, then no progress for 120s)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, want := tt.err.Error(), tt.want; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0B"},
+		{999, "999B"},
+		{1000, "1.0kB"},
+		{1_500_000, "1.5MB"},
+		{1_200_000_000, "1.2GB"},
+		{3_000_000_000_000, "3.0TB"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got, want := formatBytes(tt.n), tt.want; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestTransferProgress_String(t *testing.T) {
+	tests := []struct {
+		name string
+		p    transferProgress
+		want string
+	}{
+		{"Known", transferProgress{Op: "download", Transferred: 1_500_000, Total: 6_000_000}, "Download progress: 1.5MB of 6.0MB (25%)"},
+		{"Unknown", transferProgress{Op: "download", Transferred: 1_500_000, Total: -1}, "Download progress: 1.5MB (size unknown)"},
+		{"Empty", transferProgress{Op: "upload"}, "Upload progress: 0B of 0B (100%)"},
+		{"Exceeded", transferProgress{Op: "download", Transferred: 2000, Total: 1000}, "Download progress: 2.0kB of 1.0kB (100%)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, want := tt.p.String(), tt.want; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestStallProgressBar_Init(t *testing.T) {
+	tests := []struct {
+		name             string
+		size             int64
+		wantTotal        int64
+		wantPreallocated int64
+	}{
+		{"Known", 1234, 1234, 1234},
+		{"Unknown", -1, -1, 0},
+		{"Empty", 0, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newStallMonitor("download", time.Minute, time.Now)
+
+			var preallocated int64
+			pb := &stallProgressBar{m: m, preallocate: func(n int64) { preallocated = n }}
+			pb.Init(tt.size)
+
+			if got, want := m.snapshot().Total, tt.wantTotal; got != want {
+				t.Errorf("got total %v, want %v", got, want)
+			}
+			if got, want := preallocated, tt.wantPreallocated; got != want {
+				t.Errorf("got pre-allocation %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "0s"},
+		{200 * time.Millisecond, "200ms"},
+		{90 * time.Second, "1m30s"},
+		{30 * time.Minute, "30m"},
+		{time.Hour, "1h"},
+		{90 * time.Minute, "1h30m"},
+		{time.Hour + time.Second, "1h0m1s"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got, want := formatDuration(tt.d), tt.want; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestStallMonitor(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+
+	m := newStallMonitor("download", 120*time.Second, clock.now)
+
+	s := newFakeStream(context.Background())
+
+	var dst bytes.Buffer
+
+	// Transfer 4 chunks, 100s apart. Each is progress, so the transfer does not stall. Progress
+	// is reported alternately by the reader and the writer.
+	buf := make([]byte, 1000)
+	for i := 0; i < 4; i++ {
+		var r io.Reader = s
+		var w io.Writer = &dst
+		if i%2 == 0 {
+			r = &stallReader{r: s, m: m}
+		} else {
+			w = &stallWriter{w: &dst, m: m}
+		}
+
+		if i > 0 {
+			clock.advance(100 * time.Second)
+		}
+		s.chunks <- bytes.Repeat([]byte{'x'}, len(buf))
+
+		n, err := r.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(buf[:n]); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := m.check(); err != nil {
+			t.Fatalf("unexpected stall: %v", err)
+		}
+	}
+
+	// Advance the clock short of the stall timeout.
+	clock.advance(119 * time.Second)
+	if err := m.check(); err != nil {
+		t.Fatalf("unexpected stall: %v", err)
+	}
+
+	// Reach the stall timeout.
+	clock.advance(time.Second)
+
+	var se *StallError
+	if err := m.check(); !errors.As(err, &se) {
+		t.Fatalf("got error %v, want stall", err)
+	}
+
+	if got, want := se.Error(), "download stalled (received 4.0kB in 300s, then no progress for 120s)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got, want := dst.Len(), 4000; got != want {
+		t.Errorf("got %v bytes, want %v", got, want)
+	}
+}
+
+func TestStallMonitor_watch(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+
+	m := newStallMonitor("upload", time.Minute, clock.now)
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	tick := make(chan time.Time)
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		m.watch(ctx, cancel, tick, nil)
+	}()
+
+	// A tick before the stall timeout leaves the context intact.
+	clock.advance(30 * time.Second)
+	tick <- clock.now()
+
+	if err := ctx.Err(); err != nil {
+		t.Fatalf("unexpected cancellation: %v", context.Cause(ctx))
+	}
+
+	// A tick after the stall timeout cancels the context.
+	clock.advance(30 * time.Second)
+	tick <- clock.now()
+	<-stopped
+
+	var se *StallError
+	if err := context.Cause(ctx); !errors.As(err, &se) {
+		t.Fatalf("got cause %v, want stall", err)
+	}
+}
+
+func TestApp_libraryOpTimeout(t *testing.T) {
+	tests := []struct {
+		name           string
+		libraryTimeout time.Duration
+		size           int64
+		want           time.Duration
+	}{
+		{"Empty", 0, 0, libraryTimeoutBase},
+		{"UnknownSize", 0, -1, libraryTimeoutBase},
+		{"Sized", 0, 600 << 20, libraryTimeoutBase + 600*time.Second},
+		{"Configured", time.Minute, 600 << 20, time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &App{libraryTimeout: tt.libraryTimeout}
+
+			if got, want := app.libraryOpTimeout(tt.size), tt.want; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApp_libraryTransfer(t *testing.T) {
+	tests := []struct {
+		name         string
+		timeout      time.Duration
+		stallTimeout time.Duration
+		progress     bool
+		cancel       bool
+		wantErr      error
+		wantStall    bool
+	}{
+		{
+			name:         "Stalled",
+			timeout:      time.Minute,
+			stallTimeout: 40 * time.Millisecond,
+			wantStall:    true,
+		},
+		{
+			name:         "TimedOut",
+			timeout:      100 * time.Millisecond,
+			stallTimeout: time.Minute,
+			progress:     true,
+			wantErr:      errTransferTimeout,
+		},
+		{
+			name:         "Cancelled",
+			timeout:      time.Minute,
+			stallTimeout: time.Minute,
+			cancel:       true,
+			wantErr:      context.Canceled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &App{libraryTimeout: tt.timeout, libraryStallTimeout: tt.stallTimeout}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			tctx, m, done := app.libraryTransfer(ctx, "download", 0)
+
+			s := newFakeStream(tctx)
+			go func() {
+				for tt.progress {
+					select {
+					case s.chunks <- []byte("x"):
+					case <-tctx.Done():
+						return
+					}
+					time.Sleep(5 * time.Millisecond)
+				}
+			}()
+
+			if tt.cancel {
+				cancel()
+			}
+
+			_, err := io.Copy(io.Discard, &stallReader{r: s, m: m})
+			err = done(err)
+
+			var se *StallError
+			if got, want := errors.As(err, &se), tt.wantStall; got != want {
+				t.Fatalf("got error %v, want stall %v", err, want)
+			}
+
+			if tt.wantErr != nil {
+				if got, want := err, tt.wantErr; !errors.Is(got, want) {
+					t.Fatalf("got error %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
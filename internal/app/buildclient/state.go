@@ -0,0 +1,97 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	build "github.com/sylabs/scs-build-client/client"
+)
+
+// buildTransition describes a change in the state of a build.
+type buildTransition struct {
+	State       build.BuildState
+	Position    int           // Queue position, if HasPosition is set.
+	HasPosition bool          // The queue position of a queued build is reported.
+	Queued      time.Duration // Time a started build spent queued, if reported.
+}
+
+func (t buildTransition) String() string {
+	switch t.State {
+	case build.BuildStateQueued:
+		if t.HasPosition {
+			return fmt.Sprintf("Build queued (position %v)", t.Position)
+		}
+		return "Build queued"
+
+	case build.BuildStateBuilding:
+		if t.Queued > 0 {
+			return fmt.Sprintf("Build started after %v in queue", formatDuration(t.Queued.Round(time.Second)))
+		}
+		return "Build started"
+
+	case build.BuildStatePushing:
+		return "Build pushing image to library"
+
+	default:
+		return fmt.Sprintf("Build %v", t.State)
+	}
+}
+
+// reportTransition reports t using app.transitions if set, and otherwise to standard error.
+func (app *App) reportTransition(t buildTransition) {
+	if app.transitions != nil {
+		app.transitions(t)
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "%v\n", t)
+}
+
+// stateTracker reports transitions in the state of a build as its status is retrieved. Completion
+// is not reported, since the outcome of the build is reported separately.
+type stateTracker struct {
+	report func(buildTransition)
+	last   *buildTransition
+}
+
+// update reports the state of bi, if it differs from that previously reported. Older Build Service
+// versions do not report state, in which case nothing is reported.
+func (st *stateTracker) update(bi *build.BuildInfo) {
+	t := buildTransition{State: bi.State()}
+
+	switch t.State {
+	case build.BuildStateUnknown:
+		return
+
+	case build.BuildStateQueued:
+		t.Position, t.HasPosition = bi.QueuePosition()
+
+	case build.BuildStateBuilding:
+		if submitted, started := bi.SubmitTime(), bi.StartTime(); !submitted.IsZero() && !started.IsZero() {
+			t.Queued = started.Sub(submitted)
+		}
+	}
+
+	if st.last != nil && *st.last == t {
+		return
+	}
+	st.last = &t
+
+	if t.State != build.BuildStateSucceeded && t.State != build.BuildStateFailed {
+		st.report(t)
+	}
+}
+
+// buildFailureReason returns a description of the failure of the build described by bi.
+func buildFailureReason(bi *build.BuildInfo) string {
+	if code, ok := bi.ExitCode(); ok {
+		return fmt.Sprintf("failed to build image (exit code %v)", code)
+	}
+	return "failed to build image"
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	build "github.com/sylabs/scs-build-client/client"
+)
+
+func TestBuildTransition_String(t *testing.T) {
+	tests := []struct {
+		name string
+		t    buildTransition
+		want string
+	}{
+		{"Queued", buildTransition{State: build.BuildStateQueued}, "Build queued"},
+		{"QueuedPosition", buildTransition{State: build.BuildStateQueued, Position: 3, HasPosition: true}, "Build queued (position 3)"},
+		{"Building", buildTransition{State: build.BuildStateBuilding}, "Build started"},
+		{"BuildingQueued", buildTransition{State: build.BuildStateBuilding, Queued: 90 * time.Second}, "Build started after 1m30s in queue"},
+		{"Pushing", buildTransition{State: build.BuildStatePushing}, "Build pushing image to library"},
+		{"Other", buildTransition{State: "paused"}, "Build paused"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.t.String())
+		})
+	}
+}
+
+func TestApp_RunBuildState(t *testing.T) {
+	defer func(d time.Duration) { statusPollInterval = d }(statusPollInterval)
+	statusPollInterval = 10 * time.Millisecond
+
+	tests := []struct {
+		name            string
+		states          []build.BuildState
+		legacyState     bool
+		failBuild       bool
+		wantTransitions []string
+		wantErr         string
+	}{
+		{
+			name:   "Succeeded",
+			states: []build.BuildState{build.BuildStateQueued, build.BuildStateQueued, build.BuildStateBuilding, build.BuildStateSucceeded},
+			wantTransitions: []string{
+				"Build queued (position 2)",
+				"Build queued (position 1)",
+				"Build started after 1m30s in queue",
+			},
+		},
+		{
+			name:   "Failed",
+			states: []build.BuildState{build.BuildStateQueued, build.BuildStateBuilding, build.BuildStateFailed},
+			wantTransitions: []string{
+				"Build queued (position 1)",
+				"Build started after 1m30s in queue",
+			},
+			wantErr: "failed to build image (exit code 1)",
+		},
+		{
+			name:        "LegacySucceeded",
+			legacyState: true,
+		},
+		{
+			name:        "LegacyFailed",
+			legacyState: true,
+			failBuild:   true,
+			wantErr:     "failed to build image",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+			m.states = tt.states
+			m.legacyState = tt.legacyState
+			m.failBuild = tt.failBuild
+
+			// Hold the output stream open, so that status is polled.
+			m.lateOutput = []string{"late output\n"}
+			m.lateOutputDelay = 200 * time.Millisecond
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			app, err := New(context.Background(), &Config{
+				URL:          m.frontend.URL,
+				BuildSpec:    defFile,
+				LibraryRef:   filepath.Join(dir, "image.sif"),
+				ArchsToBuild: []string{"amd64"},
+				ResumeFile:   filepath.Join(dir, "metadata.json"),
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			var mu sync.Mutex
+			var got []string
+			app.transitions = func(bt buildTransition) {
+				mu.Lock()
+				defer mu.Unlock()
+				got = append(got, bt.String())
+			}
+
+			err = app.Run(context.Background())
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, tt.wantTransitions, got)
+		})
+	}
+}
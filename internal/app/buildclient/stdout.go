@@ -0,0 +1,112 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	build "github.com/sylabs/scs-build-client/client"
+)
+
+// stdoutDestination is the image path that writes the image to standard output. It likewise
+// denotes standard output as the build metadata file.
+const stdoutDestination = "-"
+
+var errStdoutDestination = errors.New("cannot write image to standard output")
+
+// checkStdoutDestination returns an error if the image is to be written to standard output, but
+// more than one image would be written, build metadata is also written there, or the image is to
+// be signed, which requires it to be written to a file.
+func (app *App) checkStdoutDestination() error {
+	if app.dstFileName != stdoutDestination {
+		return nil
+	}
+
+	if app.batch != nil {
+		return fmt.Errorf("%w: more than one definition specified", errStdoutDestination)
+	}
+
+	if app.metadataFile == stdoutDestination {
+		return fmt.Errorf("%w: build metadata is written to standard output", errStdoutDestination)
+	}
+
+	if len(app.archsToBuild) > 1 || slices.Contains(app.archsToBuild, archAll) {
+		return fmt.Errorf("%w: more than one architecture requested", errStdoutDestination)
+	}
+
+	if app.signerOpts != nil {
+		return fmt.Errorf("%w: signing requires the image to be written to a file", errStdoutDestination)
+	}
+
+	return nil
+}
+
+// streamArtifact downloads the image described by bi, built for arch, to app.artifactOut, and
+// returns the number of bytes written.
+//
+// The image is written as it is received, so it is downloaded in a single stream, and the artifact
+// cache is not used. The checksum is verified once the image has been written, so a corrupt image
+// results in an error, but is not withheld.
+func (app *App) streamArtifact(ctx context.Context, bi *build.BuildInfo, arch string) (int64, error) {
+	path, tag := splitLibraryRef(bi.LibraryRef())
+
+	cw := &countingWriter{w: app.artifactOut}
+
+	var w io.Writer = cw
+
+	h := app.downloadHash.newHash()
+	if h != nil {
+		w = io.MultiWriter(cw, h)
+	}
+
+	tctx, m, done := app.libraryTransfer(ctx, "download", bi.ImageSize())
+	w = &stallWriter{w: w, m: m}
+
+	copyImage := func(size int64, r io.Reader, w io.Writer) error {
+		m.setTotal(size)
+
+		_, err := io.Copy(w, r)
+		return err
+	}
+
+	err := done(app.withLibraryAuth(tctx, func() error {
+		return app.libraryClient.DownloadImage(tctx, w, arch, path, tag, copyImage)
+	}))
+	app.bytesDownloaded[arch] += m.snapshot().Transferred
+
+	// An image that is unavailable from the library is rejected before any of it is written, so it
+	// can be downloaded from the Build Service instead.
+	if isLibraryUnavailable(err) && cw.n == 0 {
+		fmt.Fprintf(os.Stderr, "Library download failed (%v), downloading image from Build Service\n", err)
+
+		err := app.buildClient.GetArtifact(ctx, bi.ID(), cw)
+		app.bytesDownloaded[arch] += cw.n
+		if err != nil {
+			return cw.n, fmt.Errorf("error downloading image %v from Build Service: %w", bi.ID(), app.wrapBuildErr(err))
+		}
+
+		reportArtifactVerified(bi)
+		return cw.n, nil
+	}
+	if err != nil {
+		return cw.n, fmt.Errorf("error downloading image %v: %w", bi.LibraryRef(), app.wrapLibraryErr(err))
+	}
+
+	if h != nil {
+		sum := h.Sum(nil)
+		if err := verifyChecksum(bi.ImageChecksum(), app.downloadHash, sum); err != nil {
+			return cw.n, fmt.Errorf("image written to standard output: %w", err)
+		}
+		app.downloadChecksums[arch] = app.downloadHash.formatChecksum(sum)
+	}
+
+	return cw.n, nil
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/sylabs/sif/v2/pkg/integrity"
+)
+
+func TestApp_RunStdout(t *testing.T) {
+	m := newMockServers(t)
+
+	dir := t.TempDir()
+	def := filepath.Join(dir, "alpine.def")
+	if err := os.WriteFile(def, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m.files = []string{def}
+
+	app, err := New(context.Background(), &Config{
+		URL:          m.frontend.URL,
+		BuildSpec:    def,
+		LibraryRef:   stdoutDestination,
+		ArchsToBuild: []string{"amd64"},
+	})
+	if err != nil {
+		t.Fatalf("initialization error: %v", err)
+	}
+
+	// Human-readable output is not written to standard output.
+	assert.Equal(t, os.Stderr, app.stdout)
+
+	var human, out bytes.Buffer
+	app.stdout = &human
+	app.artifactOut = &out
+
+	if err := app.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, mockImage, out.Bytes())
+	assert.Contains(t, human.String(), "Building for amd64...\n")
+	assert.Contains(t, human.String(), fmt.Sprintf("Wrote %d bytes to standard output\n", len(mockImage)))
+}
+
+func TestApp_CheckStdoutDestination(t *testing.T) {
+	tests := []struct {
+		name    string
+		app     *App
+		wantErr bool
+	}{
+		{
+			name: "File",
+			app:  &App{dstFileName: "image.sif", archsToBuild: []string{"amd64", "arm64"}},
+		},
+		{
+			name: "Stdout",
+			app:  &App{dstFileName: stdoutDestination, archsToBuild: []string{"amd64"}},
+		},
+		{
+			name:    "MultipleArchs",
+			app:     &App{dstFileName: stdoutDestination, archsToBuild: []string{"amd64", "arm64"}},
+			wantErr: true,
+		},
+		{
+			name:    "AllArchs",
+			app:     &App{dstFileName: stdoutDestination, archsToBuild: []string{archAll}},
+			wantErr: true,
+		},
+		{
+			name: "Signed",
+			app: &App{
+				dstFileName:  stdoutDestination,
+				archsToBuild: []string{"amd64"},
+				signerOpts:   []integrity.SignerOpt{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Metadata",
+			app: &App{
+				dstFileName:  stdoutDestination,
+				archsToBuild: []string{"amd64"},
+				metadataFile: stdoutDestination,
+			},
+			wantErr: true,
+		},
+		{
+			name: "Batch",
+			app: &App{
+				dstFileName:  stdoutDestination,
+				archsToBuild: []string{"amd64"},
+				batch:        &batch{},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.app.checkStdoutDestination()
+			if got, want := errors.Is(err, errStdoutDestination), tt.wantErr; got != want {
+				t.Errorf("got error %v, want error %v", err, want)
+			}
+		})
+	}
+}
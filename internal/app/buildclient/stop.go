@@ -0,0 +1,14 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+// Stop requests that the run end gracefully. Unlike cancelling the context passed to Run, the
+// architecture being built is completed, including retrieving, signing and pushing its image.
+// Architectures, and in batch mode definitions, not yet started are then skipped, and reported as
+// such, rather than as failures. Stop may be called from any goroutine, and before or during Run.
+func (app *App) Stop() {
+	app.stopped.Store(true)
+}
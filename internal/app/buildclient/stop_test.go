@@ -0,0 +1,140 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApp_RunStop(t *testing.T) {
+	tests := []struct {
+		name        string
+		cancel      bool // If set, the context is cancelled, rather than a stop requested.
+		wantErr     error
+		wantWritten bool
+	}{
+		{
+			name:        "Stop",
+			wantWritten: true,
+		},
+		{
+			name:    "Cancel",
+			cancel:  true,
+			wantErr: context.Canceled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			imageFile := filepath.Join(dir, "image.sif")
+			resumeFile := filepath.Join(dir, "metadata.json")
+
+			app, err := New(context.Background(), &Config{
+				URL:          m.frontend.URL,
+				BuildSpec:    defFile,
+				LibraryRef:   imageFile,
+				ArchsToBuild: []string{"amd64", "arm64"},
+				ResumeFile:   resumeFile,
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// Interrupt the run once the first build is submitted.
+			m.onSubmit = app.Stop
+			if tt.cancel {
+				m.onSubmit = cancel
+			}
+
+			if err := app.Run(ctx); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			// The remaining architecture is not built.
+			assert.Equal(t, int64(1), m.submits.Load())
+
+			// Once stopped, the image being built is completed; once cancelled, it is discarded.
+			_, err = os.Stat(filepath.Join(dir, "image-amd64.sif"))
+			if got, want := err == nil, tt.wantWritten; got != want {
+				t.Errorf("got image written %v, want %v (%v)", got, want, err)
+			}
+
+			md, err := readMetadata(resumeFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if am := md.arch("amd64"); assert.NotNil(t, am) {
+				assert.Equal(t, tt.wantWritten, am.Succeeded)
+			}
+
+			// A skipped architecture is recorded as such, rather than as failed.
+			if am := md.arch("arm64"); tt.cancel {
+				if am != nil {
+					assert.NotEmpty(t, am.Error)
+				}
+			} else if assert.NotNil(t, am) {
+				assert.True(t, am.Skipped)
+				assert.False(t, am.Succeeded)
+				assert.Empty(t, am.Error)
+			}
+		})
+	}
+}
+
+func TestApp_RunBatchStop(t *testing.T) {
+	m := newMockServers(t)
+
+	dir := t.TempDir()
+
+	var defs []string
+	for _, name := range []string{"one", "two"} {
+		def := filepath.Join(dir, name+".def")
+		if err := os.WriteFile(def, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		defs = append(defs, def)
+	}
+
+	app, err := New(context.Background(), &Config{
+		URL:          m.frontend.URL,
+		DefFiles:     defs,
+		LibraryRef:   filepath.Join(dir, "{name}.sif"),
+		ArchsToBuild: []string{"amd64"},
+	})
+	if err != nil {
+		t.Fatalf("initialization error: %v", err)
+	}
+
+	m.onSubmit = app.Stop
+
+	if err := app.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first definition is built, and the second skipped.
+	assert.Equal(t, int64(1), m.submits.Load())
+	assert.FileExists(t, filepath.Join(dir, "one.sif"))
+	assert.NoFileExists(t, filepath.Join(dir, "two.sif"))
+}
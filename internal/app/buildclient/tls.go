@@ -0,0 +1,194 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/sylabs/scs-build-client/internal/pkg/tlsdebug"
+)
+
+var (
+	errNoCACerts             = errors.New("no certificates found")
+	errIncompleteKeyPair     = errors.New("client certificate and key must be specified together")
+	errInvalidTLSVersion     = errors.New("invalid TLS version")
+	errInvalidCipherProfile  = errors.New("invalid TLS cipher profile")
+	errTLSVersionUnsupported = errors.New("server does not support minimum TLS version")
+)
+
+// cipherProfileStrict restricts TLS 1.2 connections to forward secret AEAD cipher suites.
+const cipherProfileStrict = "strict"
+
+// parseTLSVersion returns the TLS version named by value, such as "1.2".
+func parseTLSVersion(value string) (uint16, error) {
+	switch value {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("%w %q: expected 1.2 or 1.3", errInvalidTLSVersion, value)
+	}
+}
+
+// parseCipherProfile returns the cipher suites of the profile named by value. TLS 1.3 cipher
+// suites are not configurable, so the profile applies only to TLS 1.2 connections.
+func parseCipherProfile(value string) ([]uint16, error) {
+	switch value {
+	case cipherProfileStrict:
+		return []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w %q: expected %v", errInvalidCipherProfile, value, cipherProfileStrict)
+	}
+}
+
+// isTLSVersionErr returns true if err results from a TLS handshake that failed because the client
+// and server share no protocol version. Where the server rejects the versions offered, it sends a
+// protocol version alert. Where it selects one not offered, the client rejects it.
+func isTLSVersionErr(err error) bool {
+	var oe *net.OpError
+	if errors.As(err, &oe) && oe.Op == "remote error" && oe.Err != nil && oe.Err.Error() == "tls: protocol version not supported" {
+		return true
+	}
+	return err != nil && strings.Contains(err.Error(), "tls: server selected unsupported protocol version")
+}
+
+// tlsVersionError returns err, wrapped in errTLSVersionUnsupported if it results from a server
+// that does not support minVersion or later, so that the server is reported as such, rather than
+// as a failed handshake. Otherwise, err is returned as is.
+func tlsVersionError(err error, minVersion string) error {
+	if minVersion == "" || !isTLSVersionErr(err) {
+		return err
+	}
+	return fmt.Errorf("%w: TLS %v or later required: %w", errTLSVersionUnsupported, minVersion, err)
+}
+
+// translateTLSErr returns err, wrapped in errTLSVersionUnsupported if it results from a server that
+// does not support the minimum TLS version. See tlsVersionError.
+func (app *App) translateTLSErr(err error) error {
+	return tlsVersionError(err, app.tlsMinVersion)
+}
+
+// newTLSConfig returns the TLS configuration described by cfg. Certificates and keys are loaded
+// immediately, so that problems are reported before any connection is attempted.
+//
+// If cfg.CACertFile is set, the certificates it contains are trusted in addition to the system
+// certificate pool. If cfg.ClientCertFile and cfg.ClientKeyFile are set, the certificate is
+// presented to servers that request one.
+//
+// If cfg.TLSMinVersion is set, earlier versions are not negotiated. See tlsVersionError. If
+// cfg.TLSCipherProfile is set, TLS 1.2 connections are restricted to the cipher suites it names.
+func newTLSConfig(cfg *Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.SkipTLSVerify} //nolint:gosec
+
+	if cfg.TLSMinVersion != "" {
+		v, err := parseTLSVersion(cfg.TLSMinVersion)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.MinVersion = v
+	}
+
+	if cfg.TLSCipherProfile != "" {
+		suites, err := parseCipherProfile(cfg.TLSCipherProfile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.CipherSuites = suites
+	}
+
+	if cfg.CACertFile != "" {
+		b, err := os.ReadFile(cfg.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA certificates: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("error reading CA certificates from %v: %w", cfg.CACertFile, errNoCACerts)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if (cfg.ClientCertFile == "") != (cfg.ClientKeyFile == "") {
+		return nil, errIncompleteKeyPair
+	}
+
+	if cfg.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCertFile, cfg.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// tlsConfigDigest returns a digest identifying the TLS configuration described by cfg, including the
+// contents of the certificate and key files it names, so that a change to any of them is detected.
+func tlsConfigDigest(cfg *Config) string {
+	h := sha256.New()
+
+	fmt.Fprintf(h, "%v\x00%v\x00%v\x00", cfg.SkipTLSVerify, cfg.TLSMinVersion, cfg.TLSCipherProfile)
+
+	for _, name := range []string{cfg.CACertFile, cfg.ClientCertFile, cfg.ClientKeyFile} {
+		fmt.Fprintf(h, "%v\x00", name)
+
+		// Unreadable files are reported when the TLS configuration is loaded. See newTLSConfig.
+		if b, err := os.ReadFile(name); err == nil {
+			h.Write(b)
+		}
+		h.Write([]byte{0})
+	}
+
+	return "sha256." + hex.EncodeToString(h.Sum(nil))
+}
+
+// Roles of the servers the app connects to, used to annotate certificate verification errors.
+const (
+	roleFrontend    = "frontend"
+	roleBuild       = "Build Service"
+	roleBuildOutput = "Build Service output websocket"
+	roleLibrary     = "Library Service"
+)
+
+// wrapBuildErr annotates err with the Build Service URL, if it is a certificate verification error.
+func (app *App) wrapBuildErr(err error) error {
+	return tlsdebug.Wrap(err, roleBuild, app.buildURL)
+}
+
+// wrapLibraryErr annotates err with the Library Service URL, if it is a certificate verification
+// error.
+func (app *App) wrapLibraryErr(err error) error {
+	var u string
+	if app.libraryClient != nil && app.libraryClient.BaseURL != nil {
+		u = app.libraryClient.BaseURL.String()
+	}
+	return tlsdebug.Wrap(err, roleLibrary, u)
+}
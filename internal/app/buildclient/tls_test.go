@@ -0,0 +1,383 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sylabs/scs-build-client/internal/pkg/tlsdebug"
+)
+
+// writeClientCert writes a client certificate and corresponding private key in PEM format to files
+// in dir, and returns their paths. The certificate is self-signed, and a pool containing it is
+// returned, for servers to use to verify clients.
+func writeClientCert(t *testing.T, dir, name string) (certFile, keyFile string, pool *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, name+".crt")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	keyFile = filepath.Join(dir, name+".key")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return certFile, keyFile, pool
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	certFile, keyFile, _ := writeClientCert(t, dir, "client")
+	otherCertFile, _, _ := writeClientCert(t, dir, "other")
+
+	notPEM := filepath.Join(dir, "not.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	missing := filepath.Join(dir, "missing.pem")
+
+	tests := []struct {
+		name       string
+		cfg        Config
+		wantErr    error
+		wantAnyErr bool
+		wantRoots  bool
+		wantCerts  int
+		wantMin    uint16
+	}{
+		{name: "Default"},
+		{name: "CACert", cfg: Config{CACertFile: certFile}, wantRoots: true},
+		{name: "CACertMissing", cfg: Config{CACertFile: missing}, wantErr: os.ErrNotExist},
+		{name: "CACertNotPEM", cfg: Config{CACertFile: notPEM}, wantErr: errNoCACerts},
+		{name: "ClientCert", cfg: Config{ClientCertFile: certFile, ClientKeyFile: keyFile}, wantCerts: 1},
+		{name: "ClientCertOnly", cfg: Config{ClientCertFile: certFile}, wantErr: errIncompleteKeyPair},
+		{name: "ClientKeyOnly", cfg: Config{ClientKeyFile: keyFile}, wantErr: errIncompleteKeyPair},
+		{name: "ClientCertMissing", cfg: Config{ClientCertFile: missing, ClientKeyFile: keyFile}, wantErr: os.ErrNotExist},
+		{name: "ClientKeyMismatch", cfg: Config{ClientCertFile: otherCertFile, ClientKeyFile: keyFile}, wantAnyErr: true},
+		{name: "TLSMinVersion", cfg: Config{TLSMinVersion: "1.3"}, wantMin: tls.VersionTLS13},
+		{name: "TLSMinVersionInvalid", cfg: Config{TLSMinVersion: "1.1"}, wantErr: errInvalidTLSVersion},
+		{name: "TLSCipherProfile", cfg: Config{TLSCipherProfile: cipherProfileStrict}},
+		{name: "TLSCipherProfileInvalid", cfg: Config{TLSCipherProfile: "weak"}, wantErr: errInvalidCipherProfile},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsConfig, err := newTLSConfig(&tt.cfg)
+
+			if tt.wantAnyErr {
+				if err == nil {
+					t.Fatal("unexpected success")
+				}
+				return
+			}
+
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if got, want := tlsConfig.RootCAs != nil, tt.wantRoots; got != want {
+				t.Errorf("got root CAs %v, want %v", got, want)
+			}
+
+			if got, want := len(tlsConfig.Certificates), tt.wantCerts; got != want {
+				t.Errorf("got %v certificates, want %v", got, want)
+			}
+
+			if got, want := tlsConfig.MinVersion, tt.wantMin; got != want {
+				t.Errorf("got minimum version %v, want %v", tls.VersionName(got), tls.VersionName(want))
+			}
+		})
+	}
+}
+
+func TestTLSConfigDigest(t *testing.T) {
+	dir := t.TempDir()
+
+	certFile, keyFile, _ := writeClientCert(t, dir, "client")
+
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, []byte("ca1"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	base := Config{CACertFile: caFile, ClientCertFile: certFile, ClientKeyFile: keyFile}
+	want := tlsConfigDigest(&base)
+
+	if got := tlsConfigDigest(&base); got != want {
+		t.Errorf("got digest %v, want %v", got, want)
+	}
+
+	skip := base
+	skip.SkipTLSVerify = true
+
+	noClientCert := base
+	noClientCert.ClientCertFile, noClientCert.ClientKeyFile = "", ""
+
+	for name, cfg := range map[string]Config{"SkipTLSVerify": skip, "NoClientCert": noClientCert} {
+		if got := tlsConfigDigest(&cfg); got == want {
+			t.Errorf("%v: digest unchanged", name)
+		}
+	}
+
+	// Changing the contents of a file changes the digest, even though its name does not.
+	if err := os.WriteFile(caFile, []byte("ca2"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := tlsConfigDigest(&base); got == want {
+		t.Errorf("CACertChanged: digest unchanged")
+	}
+}
+
+func TestApp_RunMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	certFile, keyFile, clientCAs := writeClientCert(t, dir, "client")
+
+	m := newMockServersTLS(t, clientCAs)
+
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: m.frontend.Certificate().Raw,
+	}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	defFile := filepath.Join(dir, "alpine.def")
+	if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		caCertFile     string
+		clientCertFile string
+		clientKeyFile  string
+		wantErr        bool
+		wantTLSRole    string
+	}{
+		{"Success", caFile, certFile, keyFile, false, ""},
+		{"UntrustedServer", "", certFile, keyFile, true, roleFrontend},
+		{"NoClientCert", caFile, "", "", true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, err := New(context.Background(), &Config{
+				URL:            m.frontend.URL,
+				BuildSpec:      defFile,
+				LibraryRef:     filepath.Join(t.TempDir(), "image.sif"),
+				ArchsToBuild:   []string{"amd64"},
+				CACertFile:     tt.caCertFile,
+				ClientCertFile: tt.clientCertFile,
+				ClientKeyFile:  tt.clientKeyFile,
+			})
+			if err != nil {
+				if !tt.wantErr {
+					t.Fatalf("initialization error: %v", err)
+				}
+
+				if tt.wantTLSRole != "" {
+					var te *tlsdebug.Error
+					if !errors.As(err, &te) {
+						t.Fatalf("got error %v, want TLS error", err)
+					}
+
+					if got, want := te.Role, tt.wantTLSRole; got != want {
+						t.Errorf("got role %v, want %v", got, want)
+					}
+
+					if got, want := te.URL, m.frontend.URL; got != want {
+						t.Errorf("got URL %v, want %v", got, want)
+					}
+				}
+				return
+			}
+
+			// Exercises the build client, the build output websocket, and the library client.
+			if err := app.Run(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.wantErr {
+				t.Fatal("unexpected success")
+			}
+		})
+	}
+}
+
+// startTLSServer starts a server that negotiates only TLS version, and is closed when the test
+// completes.
+func startTLSServer(t *testing.T, version uint16, h http.Handler) *httptest.Server {
+	t.Helper()
+
+	s := httptest.NewUnstartedServer(h)
+	s.TLS = &tls.Config{MinVersion: version, MaxVersion: version}
+	s.StartTLS()
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func TestNewTransport_TLSMinVersion(t *testing.T) {
+	tests := []struct {
+		name          string
+		serverVersion uint16
+		minVersion    string
+		cipherProfile string
+		wantErr       error
+	}{
+		{"TLS10Min12", tls.VersionTLS10, "1.2", "", errTLSVersionUnsupported},
+		{"TLS12Min12", tls.VersionTLS12, "1.2", "", nil},
+		{"TLS12Min12Strict", tls.VersionTLS12, "1.2", cipherProfileStrict, nil},
+		{"TLS12Min13", tls.VersionTLS12, "1.3", "", errTLSVersionUnsupported},
+		{"TLS13Min12", tls.VersionTLS13, "1.2", "", nil},
+		{"TLS13Min13", tls.VersionTLS13, "1.3", "", nil},
+		{"TLS13Min13Strict", tls.VersionTLS13, "1.3", cipherProfileStrict, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := startTLSServer(t, tt.serverVersion, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}))
+
+			tr, err := newTransport(&Config{
+				SkipTLSVerify:    true,
+				TLSMinVersion:    tt.minVersion,
+				TLSCipherProfile: tt.cipherProfile,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(tr.CloseIdleConnections)
+
+			res, err := (&http.Client{Transport: tr}).Get(s.URL)
+			if err == nil {
+				res.Body.Close()
+			}
+
+			// The version is not negotiated, so the handshake fails, and is reported as such.
+			err = tlsVersionError(err, tt.minVersion)
+
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			// The minimum version is named, so that the cause is apparent.
+			if err != nil {
+				if want := "TLS " + tt.minVersion + " or later required"; !strings.Contains(err.Error(), want) {
+					t.Errorf("got error %v, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestApp_RunTLSMinVersion(t *testing.T) {
+	m := startMockServers(t, func(h http.Handler) *httptest.Server {
+		s := httptest.NewUnstartedServer(h)
+		s.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+		s.StartTLS()
+		return s
+	})
+
+	defFile := filepath.Join(t.TempDir(), "alpine.def")
+	if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		minVersion    string
+		cipherProfile string
+		wantErr       error
+	}{
+		{"Accepted", "1.2", cipherProfileStrict, nil},
+		{"Rejected", "1.3", "", errTLSVersionUnsupported},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, err := New(context.Background(), &Config{
+				URL:              m.frontend.URL,
+				BuildSpec:        defFile,
+				LibraryRef:       filepath.Join(t.TempDir(), "image.sif"),
+				ArchsToBuild:     []string{"amd64"},
+				SkipTLSVerify:    true,
+				TLSMinVersion:    tt.minVersion,
+				TLSCipherProfile: tt.cipherProfile,
+			})
+			if err == nil {
+				// Exercises the build client, the build output websocket, and the library client.
+				err = app.Run(context.Background())
+			}
+
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+		})
+	}
+}
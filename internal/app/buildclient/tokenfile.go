@@ -0,0 +1,126 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errEmptyTokenFile = errors.New("access token file is empty")
+
+// tokenFileRetryInterval and tokenFileRetries control how long a missing or empty token file is
+// retried, since a file being rotated may briefly be absent or empty.
+var (
+	tokenFileRetryInterval = 100 * time.Millisecond
+	tokenFileRetries       = 20
+)
+
+// tokenFile is a source of access tokens read from a file, which may be replaced during a run (for
+// example, by a sidecar that rotates a secret). The file is read once, and again whenever its
+// modification time, size or identity changes, so that a rotated token is used by the requests
+// that follow.
+type tokenFile struct {
+	path     string
+	onChange func() // If set, called when a token that differs from that previously read is read.
+
+	mu    sync.Mutex
+	fi    fs.FileInfo // Describes the file from which token was read.
+	token string
+}
+
+// changed returns true if fi does not describe the file from which the cached token was read.
+func (tf *tokenFile) changed(fi fs.FileInfo) bool {
+	return tf.fi == nil ||
+		!os.SameFile(tf.fi, fi) ||
+		!tf.fi.ModTime().Equal(fi.ModTime()) ||
+		tf.fi.Size() != fi.Size()
+}
+
+// read reads the token from the file, returning the token and a description of the file.
+func (tf *tokenFile) read() (string, fs.FileInfo, error) {
+	f, err := os.Open(tf.path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", nil, err
+	}
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return "", nil, err
+	}
+
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", nil, fmt.Errorf("%w: %v", errEmptyTokenFile, tf.path)
+	}
+	return token, fi, nil
+}
+
+// readWithRetry reads the token from the file. If the file is missing or empty, as it may briefly
+// be during rotation, the read is retried.
+func (tf *tokenFile) readWithRetry(ctx context.Context) (string, fs.FileInfo, error) {
+	for i := 0; ; i++ {
+		token, fi, err := tf.read()
+		if err == nil || i == tokenFileRetries || !(errors.Is(err, errEmptyTokenFile) || errors.Is(err, fs.ErrNotExist)) {
+			return token, fi, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", nil, fmt.Errorf("%w", ctx.Err())
+		case <-time.After(tokenFileRetryInterval):
+		}
+	}
+}
+
+// Token returns the token in the file, re-reading it if the file has changed since it was last
+// read, or refresh is set. It satisfies build.BearerTokenFunc.
+func (tf *tokenFile) Token(ctx context.Context, refresh bool) (string, error) {
+	tf.mu.Lock()
+	defer tf.mu.Unlock()
+
+	if !refresh && tf.fi != nil {
+		if fi, err := os.Stat(tf.path); err == nil && !tf.changed(fi) {
+			return tf.token, nil
+		}
+	}
+
+	token, fi, err := tf.readWithRetry(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error reading access token file: %w", err)
+	}
+
+	if tf.fi != nil && token != tf.token && tf.onChange != nil {
+		tf.onChange()
+	}
+	tf.fi, tf.token = fi, token
+
+	return token, nil
+}
+
+// reportTokenChange reports that the access token read from app.authTokenFile has changed, using
+// app.tokenChanges if set, and otherwise to standard error.
+func (app *App) reportTokenChange() {
+	if app.tokenChanges != nil {
+		app.tokenChanges()
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Access token in %v changed, using updated token\n", app.authTokenFile)
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTokenFile atomically replaces the file at path with one containing token, as a sidecar
+// rotating a secret would.
+func writeTokenFile(t *testing.T, path, token string) {
+	t.Helper()
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(token+"\n"), 0o600); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTokenFile_Token(t *testing.T) {
+	defer func(d time.Duration) { tokenFileRetryInterval = d }(tokenFileRetryInterval)
+	tokenFileRetryInterval = 10 * time.Millisecond
+
+	path := filepath.Join(t.TempDir(), "token")
+	writeTokenFile(t, path, "one")
+
+	var changes int
+	tf := &tokenFile{path: path, onChange: func() { changes++ }}
+
+	token := func(refresh bool) string {
+		t.Helper()
+
+		s, err := tf.Token(context.Background(), refresh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	assert.Equal(t, "one", token(false))
+
+	// The file is not re-read while it is unchanged, unless a refresh is requested.
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("two\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "one", token(false))
+	assert.Equal(t, "two", token(true))
+	assert.Equal(t, 1, changes)
+
+	// A rotated file is re-read.
+	writeTokenFile(t, path, "three")
+	assert.Equal(t, "three", token(false))
+	assert.Equal(t, 2, changes)
+
+	// A rotated file containing the same token is not reported as a change.
+	writeTokenFile(t, path, "three")
+	assert.Equal(t, "three", token(false))
+	assert.Equal(t, 2, changes)
+
+	// A file that is briefly empty is retried.
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(3 * tokenFileRetryInterval)
+		writeTokenFile(t, path, "four")
+	}()
+	assert.Equal(t, "four", token(false))
+	assert.Equal(t, 3, changes)
+}
+
+func TestTokenFile_TokenError(t *testing.T) {
+	defer func(d time.Duration, n int) {
+		tokenFileRetryInterval, tokenFileRetries = d, n
+	}(tokenFileRetryInterval, tokenFileRetries)
+	tokenFileRetryInterval, tokenFileRetries = time.Millisecond, 3
+
+	dir := t.TempDir()
+
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, []byte(" \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr error
+	}{
+		{"Empty", empty, errEmptyTokenFile},
+		{"NotExist", filepath.Join(dir, "missing"), os.ErrNotExist},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tf := &tokenFile{path: tt.path}
+
+			if _, err := tf.Token(context.Background(), false); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApp_RunTokenFile(t *testing.T) {
+	m := newMockServers(t)
+	m.acceptToken = "stale"
+	m.rotateToken = "fresh"
+
+	dir := t.TempDir()
+
+	tokenPath := filepath.Join(dir, "token")
+	writeTokenFile(t, tokenPath, "stale")
+
+	// The token file is rotated mid-run, along with the token accepted by the servers.
+	m.onRotate = func() { writeTokenFile(t, tokenPath, "fresh") }
+
+	defFile := filepath.Join(dir, "alpine.def")
+	if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m.files = []string{defFile}
+
+	dst := filepath.Join(dir, "image.sif")
+
+	app, err := New(context.Background(), &Config{
+		URL:           m.frontend.URL,
+		AuthTokenFile: tokenPath,
+		BuildSpec:     defFile,
+		LibraryRef:    dst,
+		ArchsToBuild:  []string{"amd64"},
+	})
+	if err != nil {
+		t.Fatalf("initialization error: %v", err)
+	}
+
+	var changes int
+	app.tokenChanges = func() { changes++ }
+
+	if err := app.Run(context.Background()); err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+
+	// Requests that follow rotation carry the new token, so none are rejected.
+	assert.Empty(t, m.rejected)
+	assert.Equal(t, 1, changes)
+
+	if err := verifyFileChecksum(dst, imageChecksum()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNew_AuthTokenFileConflict(t *testing.T) {
+	m := newMockServers(t)
+
+	_, err := New(context.Background(), &Config{
+		URL:           m.frontend.URL,
+		AuthToken:     "token",
+		AuthTokenFile: filepath.Join(t.TempDir(), "token"),
+		BuildSpec:     "alpine.def",
+		ArchsToBuild:  []string{"amd64"},
+	})
+	if !errors.Is(err, errConflictingClientConfig) {
+		t.Errorf("got error %v, want %v", err, errConflictingClientConfig)
+	}
+}
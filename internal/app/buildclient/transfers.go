@@ -0,0 +1,73 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// TransferMetrics records the bytes transferred by a run, summed across architectures. Bytes
+// transferred by attempts that fail are included.
+type TransferMetrics struct {
+	BytesUploaded   int64 // Build context and signed images uploaded.
+	BytesDownloaded int64 // Images downloaded.
+}
+
+func (tm TransferMetrics) String() string {
+	return fmt.Sprintf("Transferred %v up, %v down", formatBytes(tm.BytesUploaded), formatBytes(tm.BytesDownloaded))
+}
+
+// MetricsFunc receives the bytes transferred by a run once it completes.
+type MetricsFunc func(TransferMetrics)
+
+// countingWriter is an io.Writer that counts the bytes written to it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// resetTransfers discards the bytes transferred recorded by a previous run.
+func (app *App) resetTransfers() {
+	app.contextBytes.Store(0)
+	app.bytesUploaded = make(map[string]int64)
+	app.bytesDownloaded = make(map[string]int64)
+}
+
+// transferMetrics returns the bytes transferred by the run: the build context uploaded, and the
+// images uploaded and downloaded for each architecture.
+func (app *App) transferMetrics() TransferMetrics {
+	tm := TransferMetrics{BytesUploaded: app.contextBytes.Load()}
+	for _, n := range app.bytesUploaded {
+		tm.BytesUploaded += n
+	}
+	for _, n := range app.bytesDownloaded {
+		tm.BytesDownloaded += n
+	}
+	return tm
+}
+
+// reportTransfers records the bytes transferred by the run in the run metadata, and reports them
+// using app.metricsFunc if set, and otherwise to standard error.
+func (app *App) reportTransfers() {
+	tm := app.transferMetrics()
+
+	app.metadata.BytesUploaded = tm.BytesUploaded
+	app.metadata.BytesDownloaded = tm.BytesDownloaded
+
+	if app.metricsFunc != nil {
+		app.metricsFunc(tm)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%v\n", tm)
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/sylabs/sif/v2/pkg/integrity"
+)
+
+func TestApp_RunTransferMetrics(t *testing.T) {
+	// Serve a SIF image, so that it can be signed.
+	b, err := os.ReadFile(filepath.Join("testdata", "deffile.sif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(b []byte) { mockImage = b }(mockImage)
+	mockImage = b
+
+	archs := []string{"amd64", "arm64"}
+
+	tests := []struct {
+		name         string
+		libraryRef   bool
+		signed       bool
+		wantDownload bool
+		wantPush     bool
+	}{
+		{
+			name:         "Download",
+			wantDownload: true,
+		},
+		{
+			name:       "LibraryRef",
+			libraryRef: true,
+		},
+		{
+			name:         "SignedLibraryRef",
+			libraryRef:   true,
+			signed:       true,
+			wantDownload: true,
+			wantPush:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockServers(t)
+
+			dir := t.TempDir()
+
+			defFile := filepath.Join(dir, "alpine.def")
+			if err := os.WriteFile(defFile, []byte("bootstrap: docker\nfrom: alpine:3\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			m.files = []string{defFile}
+
+			dst := filepath.Join(dir, "image.sif")
+			if tt.libraryRef {
+				dst = "library:entity/collection/container:latest"
+			}
+
+			var signerOpts []integrity.SignerOpt
+			if tt.signed {
+				signerOpts = []integrity.SignerOpt{integrity.OptSignWithEntity(newTestEntity(t))}
+			}
+
+			resumeFile := filepath.Join(dir, "metadata.json")
+
+			var got []TransferMetrics
+
+			app, err := New(context.Background(), &Config{
+				URL:          m.frontend.URL,
+				BuildSpec:    defFile,
+				LibraryRef:   dst,
+				ArchsToBuild: archs,
+				SignerOpts:   signerOpts,
+				ResumeFile:   resumeFile,
+				MetricsFunc:  func(tm TransferMetrics) { got = append(got, tm) },
+			})
+			if err != nil {
+				t.Fatalf("initialization error: %v", err)
+			}
+
+			if err := app.Run(context.Background()); err != nil {
+				t.Fatalf("run error: %v", err)
+			}
+
+			if !assert.Len(t, m.archives, 1) {
+				return
+			}
+			contextSize := int64(len(m.archives[0]))
+
+			var wantDownloaded int64
+			if tt.wantDownload {
+				wantDownloaded = int64(len(archs) * len(mockImage))
+			}
+
+			want := TransferMetrics{
+				BytesUploaded:   contextSize + m.pushedBytes.Load(),
+				BytesDownloaded: wantDownloaded,
+			}
+			assert.Equal(t, []TransferMetrics{want}, got)
+
+			if got, want := m.pushedBytes.Load() > 0, tt.wantPush; got != want {
+				t.Errorf("got %v bytes pushed, want push %v", m.pushedBytes.Load(), want)
+			}
+
+			md, err := readMetadata(resumeFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, want.BytesUploaded, md.BytesUploaded)
+			assert.Equal(t, want.BytesDownloaded, md.BytesDownloaded)
+
+			// Each architecture accounts for an equal share of the images transferred.
+			for _, arch := range archs {
+				if am := md.arch(arch); assert.NotNil(t, am, arch) {
+					assert.Equal(t, m.pushedBytes.Load()/int64(len(archs)), am.BytesUploaded, arch)
+					assert.Equal(t, wantDownloaded/int64(len(archs)), am.BytesDownloaded, arch)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	build "github.com/sylabs/scs-build-client/client"
+)
+
+var errInvalidProxy = errors.New("invalid proxy URL")
+
+// parseProxy parses the proxy URL raw, which must include a scheme and host.
+func parseProxy(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidProxy, err)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("%w %q: expected http, https or socks5 scheme", errInvalidProxy, raw)
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("%w %q: missing host", errInvalidProxy, raw)
+	}
+
+	return u, nil
+}
+
+// newTransport returns the transport used for all requests, configured according to cfg. The
+// transport, including its TLS and proxy configuration, is also used by the build client to dial
+// the build output websocket.
+//
+// By default, the proxy is determined by the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment
+// variables. If cfg.Proxy is set, it is used for all requests instead.
+func newTransport(cfg *Config) (*http.Transport, error) {
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tr, _ := http.DefaultTransport.(*http.Transport)
+	tr = tr.Clone()
+	tr.TLSClientConfig = tlsConfig
+	tr.Proxy = http.ProxyFromEnvironment
+
+	if cfg.Proxy != "" {
+		u, err := parseProxy(cfg.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		tr.Proxy = http.ProxyURL(u)
+	}
+
+	return tr, nil
+}
+
+// buildResponseHeaderTimeout is the time limit for the Build Service to begin responding, once a
+// request is written.
+const buildResponseHeaderTimeout = 30 * time.Second
+
+// buildTransport returns a copy of tr for Build Service requests. The build client applies its
+// timeouts only to transports it creates, so the wait for responses to begin is limited here. The
+// transfer of request and response bodies is not time limited.
+func buildTransport(tr *http.Transport) *http.Transport {
+	tr = tr.Clone()
+	tr.ResponseHeaderTimeout = buildResponseHeaderTimeout
+	return tr
+}
+
+// traceTransport is an http.RoundTripper that injects the trace context of each request into its
+// headers using f, before passing it to next.
+type traceTransport struct {
+	next http.RoundTripper
+	f    build.TraceContextFunc
+}
+
+func (tt traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the request it is passed.
+	req = req.Clone(req.Context())
+	tt.f(req.Context(), req.Header)
+	return tt.next.RoundTrip(req)
+}
+
+// reportRateLimit reports to standard error that a Build Service request was rejected as rate
+// limited, and will be retried after wait, so that users understand the pause.
+func reportRateLimit(wait time.Duration) {
+	if wait >= time.Second {
+		wait = wait.Round(time.Second)
+	}
+	fmt.Fprintf(os.Stderr, "Rate limited, retrying in %v\n", wait)
+}
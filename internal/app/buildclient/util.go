@@ -7,9 +7,13 @@ package buildclient
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
+
+	"github.com/sylabs/sif/v2/pkg/sif"
 )
 
 // splitLibraryRef extracts path and tag from library reference.
@@ -45,12 +49,69 @@ func definitionFromURI(raw string) (def []byte, ok bool) {
 	return b.Bytes(), true
 }
 
-func getBuildDef(uri string) ([]byte, error) {
-	// Build spec could be a URI, or the path to a definition file.
-	if b, ok := definitionFromURI(uri); ok {
+// sifMagicOffset is the offset of the magic in a SIF image, which follows the launch script.
+const sifMagicOffset = 32
+
+// sifMagic is the magic identifying a SIF image.
+var sifMagic = []byte("SIF_MAGIC\x00")
+
+// isSIF returns true if b begins with a SIF header.
+func isSIF(b []byte) bool {
+	return len(b) >= sifMagicOffset+len(sifMagic) &&
+		bytes.Equal(b[sifMagicOffset:sifMagicOffset+len(sifMagic)], sifMagic)
+}
+
+var errNoSIFDefinition = errors.New("image contains no definition file, so cannot be rebuilt")
+
+// definitionFromSIF returns the definition embedded in the SIF image b.
+func definitionFromSIF(b []byte) ([]byte, error) {
+	f, err := sif.LoadContainer(sif.NewBuffer(b))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.UnloadContainer() }()
+
+	d, err := f.GetDescriptor(sif.WithDataType(sif.DataDeffile))
+	if errors.Is(err, sif.ErrNoObjects) || errors.Is(err, sif.ErrObjectNotFound) {
+		return nil, errNoSIFDefinition
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return d.GetData()
+}
+
+// getBuildDef returns the definition specified by uri. If uri is "-", the definition is read from
+// stdin. If the definition read is a SIF image, the definition embedded in the image is returned,
+// so that the image can be rebuilt.
+func getBuildDef(uri string, stdin io.Reader) ([]byte, error) {
+	var b []byte
+	var err error
+
+	if uri == "-" {
+		b, err = io.ReadAll(stdin)
+	} else if def, ok := definitionFromURI(uri); ok {
+		// Build spec could be a URI, or the path to a definition file.
+		return def, nil
+	} else {
+		// Attempt to read app.buildSpec as a file
+		b, err = readDefinitionFile(uri)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !isSIF(b) {
 		return b, nil
 	}
 
-	// Attempt to read app.buildSpec as a file
-	return os.ReadFile(uri)
+	def, err := definitionFromSIF(b)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", uri, err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Using definition embedded in %v\n", uri)
+
+	return def, nil
 }
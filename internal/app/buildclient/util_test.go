@@ -6,7 +6,10 @@
 package buildclient
 
 import (
+	"errors"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -93,6 +96,7 @@ func Test_getBuildDef(t *testing.T) {
 		{"basicError", false, "\n", "", true},
 		{"tempFile", true, "/tempfile", "bootstrap: docker\nfrom: alpine:3\n", false},
 		{"tempFileError", true, "", "", true},
+		{"stdin", false, "-", "bootstrap: docker\nfrom: alpine:3\n", false},
 	}
 
 	for _, tt := range tests {
@@ -115,7 +119,7 @@ func Test_getBuildDef(t *testing.T) {
 				result = tt.fileName
 			}
 
-			got, err := getBuildDef(result)
+			got, err := getBuildDef(result, strings.NewReader(tt.want))
 			if (err != nil) != tt.expectError {
 				t.Fatalf("Unexpected error: %v", err)
 			}
@@ -126,3 +130,70 @@ func Test_getBuildDef(t *testing.T) {
 		})
 	}
 }
+
+// sifDefinition is the definition embedded in testdata/deffile.sif.
+const sifDefinition = "bootstrap: docker\nfrom: alpine:3\n\n%post\n    apk add --no-cache curl\n"
+
+func Test_getBuildDefSIF(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		stdin   bool
+		want    string
+		wantErr error
+	}{
+		{"Deffile", filepath.Join("testdata", "deffile.sif"), false, sifDefinition, nil},
+		{"DeffileStdin", filepath.Join("testdata", "deffile.sif"), true, sifDefinition, nil},
+		{"NoDeffile", filepath.Join("testdata", "no-deffile.sif"), false, "", errNoSIFDefinition},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uri := tt.path
+
+			var stdin *os.File
+			if tt.stdin {
+				f, err := os.Open(tt.path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+
+				uri, stdin = "-", f
+			}
+
+			got, err := getBuildDef(uri, stdin)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if want := tt.want; string(got) != want {
+				t.Errorf("got %q, want %q", string(got), want)
+			}
+		})
+	}
+}
+
+func Test_isSIF(t *testing.T) {
+	sifImage, err := os.ReadFile(filepath.Join("testdata", "no-deffile.sif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		b    []byte
+		want bool
+	}{
+		{"SIF", sifImage, true},
+		{"Truncated", sifImage[:sifMagicOffset+4], false},
+		{"Definition", []byte(sifDefinition), false},
+		{"Empty", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isSIF(tt.b))
+		})
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var (
+	errFlagConflict       = errors.New("conflicting flags")
+	errFlagRepeated       = errors.New("flag may only be specified once")
+	errInvalidKeyIndex    = errors.New("invalid key index")
+	errInvalidFingerprint = errors.New("invalid fingerprint")
+)
+
+// onceValue is the value of a flag that may be specified only once, so that a repeated flag is
+// rejected, rather than its last value silently taking effect.
+type onceValue struct {
+	pflag.Value
+	f *pflag.Flag
+}
+
+func (o *onceValue) Set(s string) error {
+	if o.f.Changed {
+		return errFlagRepeated
+	}
+	return o.Value.Set(s)
+}
+
+// setOnce rejects repetition of the flags of cmd with the specified keys.
+func setOnce(cmd *cobra.Command, keys ...string) {
+	for _, key := range keys {
+		f := cmd.Flags().Lookup(key)
+		f.Value = &onceValue{Value: f.Value, f: f}
+	}
+}
+
+// flagConflict describes a pair of flags that may not be combined.
+type flagConflict struct {
+	key, other string // If both are set, key takes effect.
+	guidance   string // Explains the conflict, and how to resolve it.
+}
+
+// conflicts returns the conflicts between key and each of others, with guidance returned by fn.
+func conflicts(key string, others []string, fn func(key, other string) string) []flagConflict {
+	fcs := make([]flagConflict, 0, len(others))
+	for _, other := range others {
+		fcs = append(fcs, flagConflict{key, other, fn(key, other)})
+	}
+	return fcs
+}
+
+// pgpKeyFlags are the flags that select a PGP key, or supply its passphrase.
+var pgpKeyFlags = []string{keyKeyring, keyFingerprint, keySigningKeyIndex, keyPassphrase, keyPassphraseFD, keyPassphraseFile}
+
+// signingConflicts lists the signing flags that may not be combined. Keyless signing takes effect
+// over signing using a PEM key, which takes effect over PGP signing. See parseSigningOpts.
+var signingConflicts = slices.Concat(
+	conflicts(keySignKeyless, append([]string{keyPrivateSigningKey}, pgpKeyFlags...), keylessGuidance),
+	conflicts(keyPrivateSigningKey, pgpKeyFlags, pemGuidance),
+	conflicts(keyFingerprint, []string{keySigningKeyIndex}, keySelectionGuidance),
+	conflicts(keyPassphraseFD, []string{keyPassphraseFile, keyPassphrase}, passphraseGuidance),
+	conflicts(keyPassphraseFile, []string{keyPassphrase}, passphraseGuidance),
+)
+
+func keylessGuidance(key, other string) string {
+	return fmt.Sprintf("--%v signs using an ephemeral key certified for the ambient OIDC identity, and would "+
+		"take effect. To sign keyless, omit --%v. To sign using a key, omit --%v", key, other, key)
+}
+
+func pemGuidance(key, other string) string {
+	return fmt.Sprintf("--%v signs using a PEM private key, and would take effect, whereas --%v applies to PGP "+
+		"signing. To sign using a PEM key, specify --%v alone (the passphrase of an encrypted key is prompted for). "+
+		"To sign using a PGP key, specify --%v (or --%v), optionally with --%v, and omit --%v",
+		key, other, key, keyFingerprint, keySigningKeyIndex, keyKeyring, key)
+}
+
+func keySelectionGuidance(key, _ string) string {
+	return fmt.Sprintf("both select a PGP key from the keyring, and --%v would take effect. Specify --%v alone, "+
+		"since the index of a key changes as keys are added to the keyring", key, key)
+}
+
+func passphraseGuidance(key, _ string) string {
+	return fmt.Sprintf("both supply the passphrase of the PGP key, and --%v would take effect. Specify only one "+
+		"(--%v or --%v, since --%v is visible to other users)", key, keyPassphraseFD, keyPassphraseFile, keyPassphrase)
+}
+
+// validateArgs validates the flags of cmd set in v, before any action is taken. Conflicting flags
+// are named, along with guidance on the combination to use.
+func validateArgs(cmd *cobra.Command, v *viper.Viper) error {
+	for _, fc := range signingConflicts {
+		if v.IsSet(fc.key) && v.IsSet(fc.other) {
+			return &usageError{fmt.Errorf("%w: --%v and --%v: %v", errFlagConflict, fc.key, fc.other, fc.guidance)}
+		}
+	}
+
+	if v.IsSet(keySigningKeyIndex) {
+		if idx := v.GetInt(keySigningKeyIndex); idx < 0 {
+			return &usageError{fmt.Errorf("%w %v: --%v must be 0 or greater", errInvalidKeyIndex, idx, keySigningKeyIndex)}
+		}
+	}
+
+	if fp := v.GetString(keyFingerprint); fp != "" {
+		if b, err := hex.DecodeString(fp); err != nil || (len(b) != 20 && len(b) != 32) {
+			return &usageError{fmt.Errorf("%w %q: --%v must be 40 (or, for v5 keys, 64) hexadecimal digits, without spaces",
+				errInvalidFingerprint, fp, keyFingerprint)}
+		}
+	}
+
+	pgpSigning := cmd.Flag(keySigningKeyIndex).Changed || cmd.Flag(keyFingerprint).Changed
+
+	if v.GetString(keyPassphrase) != "" && !pgpSigning {
+		return &usageError{fmt.Errorf("--passphrase only effective when PGP signing enabled")}
+	}
+
+	for _, key := range []string{keyPassphraseFD, keyPassphraseFile} {
+		if v.IsSet(key) && !pgpSigning {
+			return &usageError{fmt.Errorf("--%v only effective when PGP signing enabled", key)}
+		}
+	}
+
+	if v.GetBool(keyCancelOnOutputLimit) && v.GetInt64(keyMaxOutputBytes) <= 0 {
+		return &usageError{fmt.Errorf("--%v only effective when --%v is set", keyCancelOnOutputLimit, keyMaxOutputBytes)}
+	}
+
+	return nil
+}
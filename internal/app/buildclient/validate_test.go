@@ -0,0 +1,155 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildclient
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+// newValidateTestCommand returns a command defining the flags validated by validateArgs.
+func newValidateTestCommand() *cobra.Command {
+	cmd := &cobra.Command{Use: "test"}
+	cmd.Flags().IntP(keySigningKeyIndex, "k", -1, "")
+	cmd.Flags().String(keyFingerprint, "", "")
+	cmd.Flags().String(keyKeyring, "", "")
+	cmd.Flags().String(keyPassphrase, "", "")
+	cmd.Flags().Int(keyPassphraseFD, -1, "")
+	cmd.Flags().String(keyPassphraseFile, "", "")
+	cmd.Flags().String(keyPrivateSigningKey, "", "")
+	cmd.Flags().Bool(keySignKeyless, false, "")
+	cmd.Flags().Int64(keyMaxOutputBytes, 0, "")
+	cmd.Flags().Bool(keyCancelOnOutputLimit, false, "")
+	setOnce(cmd, keySigningKeyIndex, keyFingerprint, keyPrivateSigningKey, keyKeyring)
+	return cmd
+}
+
+const testFingerprint = "0123456789abcdef0123456789abcdef01234567"
+
+func TestValidateArgs(t *testing.T) {
+	const (
+		keyIdxFingerprint = "conflicting flags: --fingerprint and --keyidx: both select a PGP key from the keyring, " +
+			"and --fingerprint would take effect. Specify --fingerprint alone, since the index of a key changes as keys " +
+			"are added to the keyring"
+		pemKeyIdx = "conflicting flags: --key and --keyidx: --key signs using a PEM private key, and would take effect, " +
+			"whereas --keyidx applies to PGP signing. To sign using a PEM key, specify --key alone (the passphrase of an " +
+			"encrypted key is prompted for). To sign using a PGP key, specify --fingerprint (or --keyidx), optionally " +
+			"with --keyring, and omit --key"
+		pemFingerprint = "conflicting flags: --key and --fingerprint: --key signs using a PEM private key, and would take " +
+			"effect, whereas --fingerprint applies to PGP signing. To sign using a PEM key, specify --key alone (the " +
+			"passphrase of an encrypted key is prompted for). To sign using a PGP key, specify --fingerprint (or " +
+			"--keyidx), optionally with --keyring, and omit --key"
+		pemKeyring = "conflicting flags: --key and --keyring: --key signs using a PEM private key, and would take effect, " +
+			"whereas --keyring applies to PGP signing. To sign using a PEM key, specify --key alone (the passphrase of an " +
+			"encrypted key is prompted for). To sign using a PGP key, specify --fingerprint (or --keyidx), optionally " +
+			"with --keyring, and omit --key"
+		pemPassphrase = "conflicting flags: --key and --passphrase: --key signs using a PEM private key, and would take " +
+			"effect, whereas --passphrase applies to PGP signing. To sign using a PEM key, specify --key alone (the " +
+			"passphrase of an encrypted key is prompted for). To sign using a PGP key, specify --fingerprint (or " +
+			"--keyidx), optionally with --keyring, and omit --key"
+		keylessPEM = "conflicting flags: --sign-keyless and --key: --sign-keyless signs using an ephemeral key certified " +
+			"for the ambient OIDC identity, and would take effect. To sign keyless, omit --key. To sign using a key, omit " +
+			"--sign-keyless"
+		keylessKeyIdx = "conflicting flags: --sign-keyless and --keyidx: --sign-keyless signs using an ephemeral key " +
+			"certified for the ambient OIDC identity, and would take effect. To sign keyless, omit --keyidx. To sign " +
+			"using a key, omit --sign-keyless"
+		passphraseFDFile = "conflicting flags: --passphrase-fd and --passphrase-file: both supply the passphrase of the " +
+			"PGP key, and --passphrase-fd would take effect. Specify only one (--passphrase-fd or --passphrase-file, " +
+			"since --passphrase is visible to other users)"
+		passphraseFile = "conflicting flags: --passphrase-file and --passphrase: both supply the passphrase of the PGP " +
+			"key, and --passphrase-file would take effect. Specify only one (--passphrase-fd or --passphrase-file, " +
+			"since --passphrase is visible to other users)"
+	)
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr error
+		wantMsg string
+	}{
+		{"None", nil, nil, ""},
+		{"KeyIdx", []string{"--keyidx", "1"}, nil, ""},
+		{"Fingerprint", []string{"--fingerprint", testFingerprint}, nil, ""},
+		{"FingerprintV5", []string{"--fingerprint", testFingerprint + "0123456789abcdef01234567"}, nil, ""},
+		{"FingerprintUpper", []string{"--fingerprint", "0123456789ABCDEF0123456789ABCDEF01234567"}, nil, ""},
+		{"PEM", []string{"--key", "key.pem"}, nil, ""},
+		{"Keyless", []string{"--sign-keyless"}, nil, ""},
+		{"PassphraseFD", []string{"--keyidx", "0", "--passphrase-fd", "3"}, nil, ""},
+		{"KeyIdxFingerprint", []string{"--keyidx", "0", "--fingerprint", testFingerprint}, errFlagConflict, keyIdxFingerprint},
+		{"PEMKeyIdx", []string{"--key", "key.pem", "-k", "0"}, errFlagConflict, pemKeyIdx},
+		{"PEMFingerprint", []string{"--key", "key.pem", "--fingerprint", testFingerprint}, errFlagConflict, pemFingerprint},
+		{"PEMKeyring", []string{"--key", "key.pem", "--keyring", "pgp-secret"}, errFlagConflict, pemKeyring},
+		{"PEMPassphrase", []string{"--key", "key.pem", "--passphrase", "secret"}, errFlagConflict, pemPassphrase},
+		{"KeylessPEM", []string{"--sign-keyless", "--key", "key.pem"}, errFlagConflict, keylessPEM},
+		{"KeylessKeyIdx", []string{"--sign-keyless", "--keyidx", "0"}, errFlagConflict, keylessKeyIdx},
+		{"PassphraseFDFile", []string{"--keyidx", "0", "--passphrase-fd", "3", "--passphrase-file", "f"}, errFlagConflict, passphraseFDFile},
+		{"PassphraseFile", []string{"--keyidx", "0", "--passphrase-file", "f", "--passphrase", "secret"}, errFlagConflict, passphraseFile},
+		{"NegativeKeyIdx", []string{"--keyidx", "-2"}, errInvalidKeyIndex, "invalid key index -2: --keyidx must be 0 or greater"},
+		{"FingerprintShort", []string{"--fingerprint", "12ab"}, errInvalidFingerprint, `invalid fingerprint "12ab": --fingerprint must be 40 (or, for v5 keys, 64) hexadecimal digits, without spaces`},
+		{"FingerprintSpaces", []string{"--fingerprint", "0123 4567 89ab cdef 0123 4567 89ab cdef 0123 4567"}, errInvalidFingerprint, `invalid fingerprint "0123 4567 89ab cdef 0123 4567 89ab cdef 0123 4567": --fingerprint must be 40 (or, for v5 keys, 64) hexadecimal digits, without spaces`},
+		{"PassphraseWithoutPGP", []string{"--passphrase", "secret"}, nil, "--passphrase only effective when PGP signing enabled"},
+		{"CancelWithoutLimit", []string{"--cancel-on-output-limit"}, nil, "--cancel-on-output-limit only effective when --max-output-bytes is set"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := newValidateTestCommand()
+			if err := cmd.ParseFlags(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			v, err := getConfig(cmd)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = validateArgs(cmd, v)
+			if tt.wantMsg == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("got no error, want %q", tt.wantMsg)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.wantMsg, err.Error())
+			assert.Equal(t, ExitUsage, ExitCode(err))
+		})
+	}
+}
+
+func TestSetOnce(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr error
+	}{
+		{"Once", []string{"--fingerprint", testFingerprint}, nil},
+		{"RepeatedFingerprint", []string{"--fingerprint", testFingerprint, "--fingerprint", testFingerprint}, errFlagRepeated},
+		{"RepeatedKeyIdx", []string{"--keyidx", "0", "-k", "1"}, errFlagRepeated},
+		{"RepeatedKey", []string{"--key", "a.pem", "--key", "b.pem"}, errFlagRepeated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The flag package does not wrap errors, so the error is matched by message.
+			err := newValidateTestCommand().ParseFlags(tt.args)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package artifactcache implements a local, content-addressed cache of downloaded artifacts.
+package artifactcache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sylabs/scs-build-client/internal/pkg/statedir"
+)
+
+var (
+	ErrInvalidKey   = errors.New("invalid artifact cache key")
+	ErrCorruptEntry = errors.New("corrupt artifact cache entry")
+)
+
+// keyPattern matches keys, which are checksums in "<algorithm>.<hex>" format.
+var keyPattern = regexp.MustCompile(`^[a-z0-9]+\.[0-9a-f]+$`)
+
+// staleTempAge is the age beyond which temporary files, left behind by runs that did not complete
+// populating an entry, are removed.
+const staleTempAge = 24 * time.Hour
+
+// VerifyFunc verifies that the contents read from r match key.
+type VerifyFunc func(key string, r io.Reader) error
+
+// Cache is a directory of artifacts, each named by its checksum. Entries are verified when read,
+// and written to a temporary file that is renamed into place once verified, so that a partially
+// written or corrupt entry is never used. Each entry is guarded by a lock, so that concurrent runs
+// populating the same entry do not clobber one another.
+//
+// The modification time of each entry records when it was last used, so that once the total size
+// of the entries exceeds the size cap, the least recently used entries are evicted.
+type Cache struct {
+	dir     string
+	maxSize int64
+	verify  VerifyFunc
+	locks   *statedir.Dir
+	now     func() time.Time
+}
+
+// Open opens the cache in dir, creating it if necessary. The total size of the entries is capped
+// at maxSize bytes. Entries are verified using verify.
+func Open(dir string, maxSize int64, verify VerifyFunc) (*Cache, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "entries"), 0o700); err != nil {
+		return nil, fmt.Errorf("error creating artifact cache: %w", err)
+	}
+
+	locks, err := statedir.Open(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error creating artifact cache: %w", err)
+	}
+
+	return &Cache{
+		dir:     dir,
+		maxSize: maxSize,
+		verify:  verify,
+		locks:   locks,
+		now:     time.Now,
+	}, nil
+}
+
+// checkKey checks that key is a checksum, and therefore safe to use as a file name.
+func checkKey(key string) error {
+	if !keyPattern.MatchString(key) {
+		return fmt.Errorf("%w %q", ErrInvalidKey, key)
+	}
+	return nil
+}
+
+// path returns the path of the entry for key.
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, "entries", key)
+}
+
+// lock acquires the lock guarding the entry for key.
+func (c *Cache) lock(ctx context.Context, key string) (*statedir.Lock, error) {
+	return c.locks.Lock(ctx, "artifact", key)
+}
+
+// Get opens the entry for key, which is verified, and marked as used. If there is no entry,
+// an error wrapping fs.ErrNotExist is returned. If the entry fails verification, it is removed,
+// and an error wrapping ErrCorruptEntry is returned.
+//
+// The returned file remains readable if the entry is subsequently evicted.
+func (c *Cache) Get(ctx context.Context, key string) (*os.File, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+
+	l, err := c.lock(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Unlock()
+
+	name := c.path(key)
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.verify(key, f); err != nil {
+		f.Close()
+
+		if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("error removing corrupt entry: %w", err)
+		}
+		return nil, fmt.Errorf("%w %v: %w", ErrCorruptEntry, key, err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	// Failing to mark the entry as used only affects the order of eviction.
+	now := c.now()
+	_ = os.Chtimes(name, now, now)
+
+	return f, nil
+}
+
+// Put adds a copy of the file with the specified name as the entry for key, unless the cache
+// already contains it. The copy is verified before it is added. Once added, least recently used
+// entries are evicted until the cache is within its size cap.
+//
+// The file is copied, rather than linked, so that subsequent changes to it do not affect the entry.
+func (c *Cache) Put(ctx context.Context, key, name string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	if err := c.put(ctx, key, name); err != nil {
+		return err
+	}
+
+	_, _, err := c.Prune(c.maxSize)
+	return err
+}
+
+// put adds a copy of the file with the specified name as the entry for key, holding the lock that
+// guards the entry.
+func (c *Cache) put(ctx context.Context, key, name string) error {
+	l, err := c.lock(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer l.Unlock()
+
+	dst := c.path(key)
+
+	// The entry was populated by a concurrent run.
+	if _, err := os.Stat(dst); err == nil {
+		now := c.now()
+		return os.Chtimes(dst, now, now)
+	}
+
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp, err := createTemp(dst)
+	if err != nil {
+		return err
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err := io.Copy(tmp, src); err != nil {
+		return fmt.Errorf("error copying %v to artifact cache: %w", name, err)
+	}
+
+	// Entries have the mode of the file they are copied from, so that they can be linked in place
+	// of files of that mode.
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tmp.Chmod(fi.Mode().Perm()); err != nil {
+		return err
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := c.verify(key, tmp); err != nil {
+		return fmt.Errorf("%w %v: %w", ErrCorruptEntry, key, err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return err
+	}
+	committed = true
+
+	now := c.now()
+	return os.Chtimes(dst, now, now)
+}
+
+// createTemp creates a temporary file alongside the entry at dst, so that it can be renamed into
+// place. Temporary files are hidden, so they are not mistaken for entries.
+func createTemp(dst string) (*os.File, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	name := filepath.Join(filepath.Dir(dst), fmt.Sprintf(".%v.%v.tmp", filepath.Base(dst), hex.EncodeToString(b)))
+	return os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0o770)
+}
+
+// entry describes an entry in the cache.
+type entry struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+// Prune evicts least recently used entries until the total size of the entries is at most
+// maxSize bytes, and removes stale temporary files. It returns the number of entries evicted, and
+// the number of bytes freed.
+//
+// Entries are evicted without holding their locks. An entry being read remains readable once
+// evicted, and an entry being populated is unaffected, as it is not renamed into place until
+// complete.
+func (c *Cache) Prune(maxSize int64) (int, int64, error) {
+	dir := filepath.Join(c.dir, "entries")
+
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error reading artifact cache: %w", err)
+	}
+
+	var (
+		entries []entry
+		total   int64
+	)
+
+	for _, de := range des {
+		fi, err := de.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // Removed in the meantime.
+		} else if err != nil {
+			return 0, 0, err
+		}
+
+		if strings.HasPrefix(de.Name(), ".") {
+			if c.now().Sub(fi.ModTime()) > staleTempAge {
+				_ = os.Remove(filepath.Join(dir, de.Name()))
+			}
+			continue
+		}
+
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+
+		entries = append(entries, entry{name: de.Name(), size: fi.Size(), modTime: fi.ModTime()})
+		total += fi.Size()
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+
+	var (
+		evicted int
+		freed   int64
+	)
+
+	for _, e := range entries {
+		if total <= maxSize {
+			break
+		}
+
+		if err := os.Remove(filepath.Join(dir, e.name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return evicted, freed, fmt.Errorf("error evicting %v: %w", e.name, err)
+		}
+
+		evicted++
+		freed += e.size
+		total -= e.size
+	}
+
+	return evicted, freed, nil
+}
@@ -0,0 +1,289 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package artifactcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errMismatch = errors.New("checksum mismatch")
+
+// verifySHA256 verifies that the contents read from r match key, in "sha256.<hex>" format.
+func verifySHA256(key string, r io.Reader) error {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+	if got := "sha256." + hex.EncodeToString(h.Sum(nil)); got != key {
+		return fmt.Errorf("%w (expecting %v, got %v)", errMismatch, key, got)
+	}
+	return nil
+}
+
+// writeArtifact writes an artifact containing b to dir, returning its key and path.
+func writeArtifact(t *testing.T, dir string, b []byte) (string, string) {
+	t.Helper()
+
+	sum := sha256.Sum256(b)
+	key := "sha256." + hex.EncodeToString(sum[:])
+
+	name := filepath.Join(dir, key+".sif")
+	if err := os.WriteFile(name, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return key, name
+}
+
+// readEntry returns the contents of the entry for key.
+func readEntry(t *testing.T, c *Cache, key string) ([]byte, error) {
+	t.Helper()
+
+	f, err := c.Get(context.Background(), key)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
+
+func TestCache_GetPut(t *testing.T) {
+	c, err := Open(t.TempDir(), 1<<20, verifySHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, name := writeArtifact(t, t.TempDir(), []byte("image"))
+
+	// Miss.
+	if _, err := readEntry(t, c, key); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("got error %v, want %v", err, fs.ErrNotExist)
+	}
+
+	if err := c.Put(context.Background(), key, name); err != nil {
+		t.Fatal(err)
+	}
+
+	// The entry is a copy, so is unaffected by changes to the file from which it was populated.
+	if err := os.WriteFile(name, []byte("signed image"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hit.
+	b, err := readEntry(t, c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "image"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// A file that does not match the key is not added.
+	if err := c.Put(context.Background(), "sha256."+hex.EncodeToString(make([]byte, 32)), name); !errors.Is(err, ErrCorruptEntry) {
+		t.Errorf("got error %v, want %v", err, ErrCorruptEntry)
+	}
+}
+
+func TestCache_PutMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file mode not supported on Windows")
+	}
+
+	c, err := Open(t.TempDir(), 1<<20, verifySHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, name := writeArtifact(t, t.TempDir(), []byte("image"))
+	if err := os.Chmod(name, 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Put(context.Background(), key, name); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := c.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The entry has the mode of the file from which it was populated.
+	if got, want := fi.Mode().Perm(), os.FileMode(0o640); got != want {
+		t.Errorf("got mode %v, want %v", got, want)
+	}
+}
+
+func TestCache_GetCorrupt(t *testing.T) {
+	dir := t.TempDir()
+
+	c, err := Open(dir, 1<<20, verifySHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, name := writeArtifact(t, t.TempDir(), []byte("image"))
+
+	if err := c.Put(context.Background(), key, name); err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt the entry, as a crash or disk error might.
+	if err := os.WriteFile(filepath.Join(dir, "entries", key), []byte("imag"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := readEntry(t, c, key); !errors.Is(err, ErrCorruptEntry) {
+		t.Fatalf("got error %v, want %v", err, ErrCorruptEntry)
+	}
+
+	// The corrupt entry is removed, so that it can be populated again.
+	if _, err := readEntry(t, c, key); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("got error %v, want %v", err, fs.ErrNotExist)
+	}
+
+	if err := c.Put(context.Background(), key, name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readEntry(t, c, key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCache_PutConcurrent(t *testing.T) {
+	dir := t.TempDir()
+
+	c, err := Open(dir, 1<<20, verifySHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, name := writeArtifact(t, t.TempDir(), []byte("image"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if err := c.Put(context.Background(), key, name); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// A single, complete entry is left behind, without temporary files.
+	des, err := os.ReadDir(filepath.Join(dir, "entries"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(des), 1; got != want {
+		t.Fatalf("got %v entries, want %v", got, want)
+	}
+	if _, err := readEntry(t, c, key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCache_Prune(t *testing.T) {
+	dir := t.TempDir()
+
+	// The cap accommodates two of the three entries.
+	c, err := Open(dir, 7, verifySHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	src := t.TempDir()
+
+	var keys []string
+	for _, s := range []string{"one", "two", "six"} {
+		key, name := writeArtifact(t, src, []byte(s))
+		keys = append(keys, key)
+
+		if err := c.Put(context.Background(), key, name); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Minute)
+
+		// Use the first entry, so that the second is least recently used.
+		if len(keys) == 2 {
+			if _, err := readEntry(t, c, keys[0]); err != nil {
+				t.Fatal(err)
+			}
+			now = now.Add(time.Minute)
+		}
+	}
+
+	if _, err := readEntry(t, c, keys[1]); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got error %v, want %v", err, fs.ErrNotExist)
+	}
+	for _, key := range []string{keys[0], keys[2]} {
+		if _, err := readEntry(t, c, key); err != nil {
+			t.Errorf("entry %v: %v", key, err)
+		}
+	}
+
+	// A stale temporary file is removed.
+	tmp := filepath.Join(dir, "entries", ".stale.tmp")
+	if err := os.WriteFile(tmp, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(tmp, now.Add(-2*staleTempAge), now.Add(-2*staleTempAge)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pruning to zero empties the cache.
+	evicted, freed, err := c.Prune(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := evicted, 2; got != want {
+		t.Errorf("got %v evicted, want %v", got, want)
+	}
+	if got, want := freed, int64(6); got != want {
+		t.Errorf("got %v bytes freed, want %v", got, want)
+	}
+	if _, err := os.Stat(tmp); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("temporary file not removed: %v", err)
+	}
+}
+
+func TestCache_InvalidKey(t *testing.T) {
+	c, err := Open(t.TempDir(), 1<<20, verifySHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"", "sha256", "../sha256.00", "sha256.0/0", "SHA256.00"} {
+		if _, err := c.Get(context.Background(), key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("key %q: got error %v, want %v", key, err, ErrInvalidKey)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package endpoints
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultConfigTTL is the default period for which frontend configuration is cached.
+const DefaultConfigTTL = 24 * time.Hour
+
+// configCacheVersion is the version of the configuration cache file format.
+const configCacheVersion = 1
+
+// configCacheFile is the on-disk format of a cached frontend configuration.
+type configCacheFile struct {
+	Version    int             `json:"version"`
+	URL        string          `json:"url"`
+	Fetched    time.Time       `json:"fetched"`
+	SkipVerify bool            `json:"skipVerify,omitempty"` // Certificate verification was skipped.
+	Config     *FrontendConfig `json:"config"`
+}
+
+// ConfigCache caches frontend configuration, so that the Build and Library Service URLs need not
+// be discovered on every run. Each frontend is cached in a separate file within a directory.
+//
+// The cache is advisory: missing, expired or corrupt entries are treated as absent.
+type ConfigCache struct {
+	dir string
+	ttl time.Duration
+	now func() time.Time
+}
+
+// NewConfigCache returns a ConfigCache stored in dir, which caches configuration for ttl.
+func NewConfigCache(dir string, ttl time.Duration) *ConfigCache {
+	return &ConfigCache{dir: dir, ttl: ttl, now: time.Now}
+}
+
+// path returns the path of the file in which the configuration of frontendURL is cached.
+func (c *ConfigCache) path(frontendURL string) string {
+	sum := sha256.Sum256([]byte(frontendKey(frontendURL)))
+	return filepath.Join(c.dir, "frontend-"+hex.EncodeToString(sum[:8])+".json")
+}
+
+// Get returns the cached configuration of frontendURL, if present and fetched within the TTL.
+// Configuration fetched with certificate verification skipped is returned only if skipVerify is
+// set.
+func (c *ConfigCache) Get(frontendURL string, skipVerify bool) (*FrontendConfig, bool) {
+	b, err := os.ReadFile(c.path(frontendURL))
+	if err != nil {
+		return nil, false
+	}
+
+	var f configCacheFile
+	if err := json.Unmarshal(b, &f); err != nil || f.Version != configCacheVersion {
+		return nil, false
+	}
+
+	if f.URL != frontendKey(frontendURL) || f.Config == nil {
+		return nil, false
+	}
+	if f.Config.LibraryAPI.URI == "" || f.Config.BuildAPI.URI == "" {
+		return nil, false
+	}
+	if f.SkipVerify && !skipVerify {
+		return nil, false
+	}
+	if age := c.now().Sub(f.Fetched); age < 0 || age >= c.ttl {
+		return nil, false
+	}
+
+	return f.Config, true
+}
+
+// Put caches cfg as the configuration of frontendURL, fetched with certificate verification
+// skipped if skipVerify is set.
+func (c *ConfigCache) Put(frontendURL string, skipVerify bool, cfg *FrontendConfig) error {
+	b, err := json.MarshalIndent(&configCacheFile{
+		Version:    configCacheVersion,
+		URL:        frontendKey(frontendURL),
+		Fetched:    c.now(),
+		SkipVerify: skipVerify,
+		Config:     cfg,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(c.path(frontendURL), b)
+}
+
+// Invalidate removes the cached configuration of frontendURL, if any.
+func (c *ConfigCache) Invalidate(frontendURL string) error {
+	if err := os.Remove(c.path(frontendURL)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package endpoints
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testFrontendURL = "https://cloud.example.com"
+
+var testFrontendConfig = &FrontendConfig{
+	LibraryAPI: URI{URI: "https://library.example.com"},
+	BuildAPI:   URI{URI: "https://build.example.com"},
+}
+
+// newTestConfigCache returns a ConfigCache in a temporary directory, with a clock that is advanced
+// by the returned function.
+func newTestConfigCache(t *testing.T, ttl time.Duration) (*ConfigCache, func(time.Duration)) {
+	t.Helper()
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	c := NewConfigCache(filepath.Join(t.TempDir(), "cache"), ttl)
+	c.now = func() time.Time { return now }
+
+	return c, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestConfigCache_Get(t *testing.T) {
+	const ttl = time.Hour
+
+	tests := []struct {
+		name       string
+		put        bool          // If set, configuration is cached.
+		putSkip    bool          // Certificate verification skipped when cached.
+		contents   string        // If set, written to the cache file in place of cached configuration.
+		advance    time.Duration // Time elapsed after configuration is cached.
+		url        string        // If set, URL looked up in place of testFrontendURL.
+		skipVerify bool
+		wantOK     bool
+	}{
+		{name: "Missing"},
+		{name: "Fresh", put: true, wantOK: true},
+		{name: "TrailingSlash", put: true, url: testFrontendURL + "/", wantOK: true},
+		{name: "OtherURL", put: true, url: "https://other.example.com"},
+		{name: "AlmostStale", put: true, advance: ttl - time.Second, wantOK: true},
+		{name: "Stale", put: true, advance: ttl},
+		{name: "Future", put: true, advance: -time.Second},
+		{name: "SkipVerify", put: true, putSkip: true, skipVerify: true, wantOK: true},
+		{name: "SkipVerifyNotApplied", put: true, putSkip: true},
+		{name: "VerifiedWithSkipVerify", put: true, skipVerify: true, wantOK: true},
+		{name: "Corrupt", contents: "{not json"},
+		{name: "Truncated", contents: `{"version":1,"url":"https://cloud.example.com","fetched":"2024-01-02T03:04:05Z"}`},
+		{name: "OtherVersion", contents: `{"version":2}`},
+		{name: "Empty", contents: " "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, advance := newTestConfigCache(t, ttl)
+
+			if tt.put {
+				if err := c.Put(testFrontendURL, tt.putSkip, testFrontendConfig); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if tt.contents != "" {
+				path := c.path(testFrontendURL)
+				if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(tt.contents), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			advance(tt.advance)
+
+			url := testFrontendURL
+			if tt.url != "" {
+				url = tt.url
+			}
+
+			got, ok := c.Get(url, tt.skipVerify)
+			if assert.Equal(t, tt.wantOK, ok) && ok {
+				assert.Equal(t, testFrontendConfig, got)
+			}
+		})
+	}
+}
+
+func TestConfigCache_Invalidate(t *testing.T) {
+	c, _ := newTestConfigCache(t, time.Hour)
+
+	// Invalidating a missing entry is not an error.
+	if err := c.Invalidate(testFrontendURL); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Put(testFrontendURL, false, testFrontendConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Invalidate(testFrontendURL); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := c.Get(testFrontendURL, false); ok {
+		t.Error("got cached configuration after invalidation")
+	}
+}
+
+func TestConfigCache_Concurrent(t *testing.T) {
+	c, _ := newTestConfigCache(t, time.Hour)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			cfg := &FrontendConfig{
+				LibraryAPI: URI{URI: fmt.Sprintf("https://library%v.example.com", i)},
+				BuildAPI:   URI{URI: fmt.Sprintf("https://build%v.example.com", i)},
+			}
+			if err := c.Put(testFrontendURL, false, cfg); err != nil {
+				t.Error(err)
+			}
+
+			// Readers never observe a partially written entry.
+			if _, ok := c.Get(testFrontendURL, false); !ok {
+				t.Error("cached configuration not found")
+			}
+		}()
+	}
+	wg.Wait()
+
+	// No temporary files remain.
+	names, err := filepath.Glob(filepath.Join(c.dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, names, 1)
+}
@@ -0,0 +1,253 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sylabs/scs-build-client/internal/pkg/tlsdebug"
+)
+
+// DefaultFailureTTL is the default period for which a hard failure of frontend discovery is
+// cached.
+const DefaultFailureTTL = 5 * time.Minute
+
+// failureCacheVersion is the version of the failure cache file format.
+const failureCacheVersion = 1
+
+// Kinds of failure that are cached.
+const (
+	failureKindDNS = "dns" // Host not found.
+	failureKindTLS = "tls" // Certificate verification failed.
+)
+
+// failureEntry records a hard failure of frontend discovery.
+type failureEntry struct {
+	Kind      string      `json:"kind"`
+	Cause     string      `json:"cause"`
+	TLSConfig string      `json:"tlsConfig,omitempty"` // TLS configuration in use, for certificate verification failures.
+	Failed    time.Time   `json:"failed"`              // Time at which discovery last failed.
+	Seen      []time.Time `json:"seen"`                // Times at which the failure was observed, or reported from the cache.
+}
+
+// failureCacheFile is the on-disk format of a FailureCache.
+type failureCacheFile struct {
+	Version int                      `json:"version"`
+	Entries map[string]*failureEntry `json:"entries"`
+}
+
+// FailureCache records hard failures of frontend discovery, so that repeated attempts to discover a
+// misconfigured frontend fail immediately rather than waiting out connection timeouts.
+//
+// Only failures that are unlikely to resolve themselves within the TTL are cached: host lookups
+// that find no such host, and certificate verification errors. Authentication errors, HTTP errors
+// and timeouts are never cached.
+type FailureCache struct {
+	path string
+	ttl  time.Duration
+	now  func() time.Time
+}
+
+// NewFailureCache returns a FailureCache stored in the file at path, which caches failures for
+// ttl.
+func NewFailureCache(path string, ttl time.Duration) *FailureCache {
+	return &FailureCache{path: path, ttl: ttl, now: time.Now}
+}
+
+// CachedFailureError is returned by Check when a cached failure applies to a frontend URL.
+type CachedFailureError struct {
+	URL   string
+	Cause string
+	Seen  int           // Number of times the failure was seen within TTL, including this one.
+	TTL   time.Duration // Period for which the failure is cached.
+}
+
+func (e *CachedFailureError) Error() string {
+	times := "times"
+	if e.Seen == 1 {
+		times = "time"
+	}
+	return fmt.Sprintf("%v (cached failure for %v, seen %v %v in the last %v)",
+		e.Cause, e.URL, e.Seen, times, formatTTL(e.TTL))
+}
+
+// formatTTL formats d in whole minutes or seconds, where possible.
+func formatTTL(d time.Duration) string {
+	unit, name := time.Minute, "minute"
+	if d%time.Minute != 0 {
+		unit, name = time.Second, "second"
+	}
+	if d%unit != 0 {
+		return d.String()
+	}
+	if n := int64(d / unit); n != 1 {
+		return fmt.Sprintf("%v %vs", n, name)
+	}
+	return "1 " + name
+}
+
+// failureKind returns the kind of failure err represents, or an empty string if it should not be
+// cached.
+func failureKind(err error) string {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return failureKindDNS
+	}
+
+	if tlsdebug.IsCertificateError(err) {
+		return failureKindTLS
+	}
+
+	return ""
+}
+
+// frontendKey returns the key under which failures and configuration of frontendURL are cached.
+func frontendKey(frontendURL string) string {
+	return strings.TrimSuffix(frontendURL, "/")
+}
+
+// read reads the cache file. A missing or unreadable cache is treated as empty.
+func (c *FailureCache) read() *failureCacheFile {
+	f := &failureCacheFile{Version: failureCacheVersion, Entries: make(map[string]*failureEntry)}
+
+	b, err := os.ReadFile(c.path)
+	if err != nil {
+		return f
+	}
+
+	var cached failureCacheFile
+	if err := json.Unmarshal(b, &cached); err != nil || cached.Version != failureCacheVersion {
+		return f
+	}
+
+	// Drop expired entries.
+	now := c.now()
+	for key, e := range cached.Entries {
+		if e == nil || now.Sub(e.Failed) >= c.ttl {
+			continue
+		}
+
+		var seen []time.Time
+		for _, t := range e.Seen {
+			if now.Sub(t) < c.ttl {
+				seen = append(seen, t)
+			}
+		}
+		e.Seen = seen
+
+		f.Entries[key] = e
+	}
+
+	return f
+}
+
+// write writes f to the cache file, replacing it atomically.
+func (c *FailureCache) write(f *failureCacheFile) error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(c.path, b)
+}
+
+// writeFileAtomic writes b to the file at path, creating its directory if necessary. The file is
+// written to a temporary file that is renamed into place, so that concurrent readers and writers
+// never observe a partially written file.
+func writeFileAtomic(path string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Check returns a *CachedFailureError if discovery of frontendURL failed within the TTL. The
+// tlsConfig argument identifies the TLS configuration in use, such as by a digest of the
+// certificates and settings involved. Cached certificate verification failures apply only where it
+// is unchanged.
+func (c *FailureCache) Check(frontendURL, tlsConfig string) error {
+	f := c.read()
+
+	key := frontendKey(frontendURL)
+
+	e, ok := f.Entries[key]
+	if !ok || (e.Kind == failureKindTLS && e.TLSConfig != tlsConfig) {
+		return nil
+	}
+
+	e.Seen = append(e.Seen, c.now())
+
+	// The cache is advisory, so failure to update it is not reported.
+	_ = c.write(f)
+
+	return &CachedFailureError{
+		URL:   frontendURL,
+		Cause: e.Cause,
+		Seen:  len(e.Seen),
+		TTL:   c.ttl,
+	}
+}
+
+// Record records the outcome of discovery of frontendURL, using the TLS configuration identified by
+// tlsConfig. If err is nil, any cached failure is removed. If err is a hard failure, it is cached.
+// Other errors are not cached.
+func (c *FailureCache) Record(frontendURL, tlsConfig string, err error) error {
+	key := frontendKey(frontendURL)
+
+	if err == nil {
+		f := c.read()
+		if _, ok := f.Entries[key]; !ok {
+			return nil
+		}
+		delete(f.Entries, key)
+		return c.write(f)
+	}
+
+	kind := failureKind(err)
+	if kind == "" {
+		return nil
+	}
+
+	f := c.read()
+
+	now := c.now()
+
+	e, ok := f.Entries[key]
+	if !ok || e.Kind != kind {
+		e = &failureEntry{Kind: kind}
+		f.Entries[key] = e
+	}
+	e.Cause = err.Error()
+	if kind == failureKindTLS {
+		e.TLSConfig = tlsConfig
+	}
+	e.Failed = now
+	e.Seen = append(e.Seen, now)
+
+	return c.write(f)
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package endpoints
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestFailureCache returns a FailureCache in a temporary directory, with a clock that is
+// advanced by the returned function.
+func newTestFailureCache(t *testing.T, ttl time.Duration) (*FailureCache, func(time.Duration)) {
+	t.Helper()
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	c := NewFailureCache(filepath.Join(t.TempDir(), "cache", "failures.json"), ttl)
+	c.now = func() time.Time { return now }
+
+	return c, func(d time.Duration) { now = now.Add(d) }
+}
+
+func Test_failureKind(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"NotFound", &net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}, failureKindDNS},
+		{"NotFoundWrapped", fmt.Errorf("get: %w", &net.DNSError{IsNotFound: true}), failureKindDNS},
+		{"DNSTemporary", &net.DNSError{Err: "server misbehaving", IsTemporary: true}, ""},
+		{"UnknownAuthority", x509.UnknownAuthorityError{}, failureKindTLS},
+		{"Hostname", fmt.Errorf("get: %w", x509.HostnameError{Host: "example.com"}), failureKindTLS},
+		{"CertificateInvalid", x509.CertificateInvalidError{Reason: x509.Expired}, failureKindTLS},
+		{"HTTPStatus", errors.New("error getting configuration (HTTP status code 503)"), ""},
+		{"Misconfigured", errServerMisconfigured, ""},
+		{"Timeout", context.DeadlineExceeded, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, failureKind(tt.err))
+		})
+	}
+}
+
+func Test_formatTTL(t *testing.T) {
+	tests := []struct {
+		name string
+		d    time.Duration
+		want string
+	}{
+		{"Minutes", 5 * time.Minute, "5 minutes"},
+		{"Minute", time.Minute, "1 minute"},
+		{"Seconds", 90 * time.Second, "90 seconds"},
+		{"Second", time.Second, "1 second"},
+		{"Fractional", 1500 * time.Millisecond, "1.5s"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, formatTTL(tt.d))
+		})
+	}
+}
+
+func TestFailureCache_EntryFormat(t *testing.T) {
+	c, advance := newTestFailureCache(t, DefaultFailureTTL)
+
+	dnsErr := &net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}
+	if assert.NoError(t, c.Record("https://example.invalid/", "", dnsErr)) {
+		advance(time.Minute)
+		assert.Error(t, c.Check("https://example.invalid", ""))
+	}
+
+	b, err := os.ReadFile(c.path)
+	if assert.NoError(t, err) {
+		assert.JSONEq(t, `{
+			"version": 1,
+			"entries": {
+				"https://example.invalid": {
+					"kind": "dns",
+					"cause": "lookup example.invalid: no such host",
+					"failed": "2024-01-02T03:04:05Z",
+					"seen": ["2024-01-02T03:04:05Z", "2024-01-02T03:05:05Z"]
+				}
+			}
+		}`, string(b))
+	}
+}
+
+func TestFailureCache_TTL(t *testing.T) {
+	c, advance := newTestFailureCache(t, DefaultFailureTTL)
+
+	const u = "https://example.invalid"
+
+	dnsErr := &net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}
+
+	// Nothing cached.
+	assert.NoError(t, c.Check(u, ""))
+
+	assert.NoError(t, c.Record(u, "", dnsErr))
+
+	// Repeats within the TTL fail, counting each occurrence.
+	advance(time.Minute)
+	err := c.Check(u, "")
+
+	var cfe *CachedFailureError
+	if assert.ErrorAs(t, err, &cfe) {
+		assert.Equal(t, 2, cfe.Seen)
+		assert.Equal(t, dnsErr.Error(), cfe.Cause)
+	}
+
+	advance(time.Minute)
+	if assert.ErrorAs(t, c.Check(u, ""), &cfe) {
+		assert.Equal(t, 3, cfe.Seen)
+		assert.EqualError(t, cfe, "lookup example.invalid: no such host "+
+			"(cached failure for https://example.invalid, seen 3 times in the last 5 minutes)")
+	}
+
+	// Cached failures expire a TTL after discovery last failed, regardless of repeats.
+	advance(3*time.Minute - time.Second)
+	assert.Error(t, c.Check(u, ""))
+
+	advance(time.Second)
+	assert.NoError(t, c.Check(u, ""))
+}
+
+func TestFailureCache_Record(t *testing.T) {
+	const u = "https://example.com"
+
+	tlsErr := fmt.Errorf("get: %w", x509.UnknownAuthorityError{})
+
+	t.Run("Success", func(t *testing.T) {
+		c, _ := newTestFailureCache(t, DefaultFailureTTL)
+
+		assert.NoError(t, c.Record(u, "", tlsErr))
+		assert.NoError(t, c.Record(u, "", nil))
+		assert.NoError(t, c.Check(u, ""))
+	})
+
+	t.Run("NotCached", func(t *testing.T) {
+		c, _ := newTestFailureCache(t, DefaultFailureTTL)
+
+		assert.NoError(t, c.Record(u, "", errors.New("error getting configuration (HTTP status code 401)")))
+		assert.NoError(t, c.Record(u, "", errors.New("error getting configuration (HTTP status code 503)")))
+		assert.NoError(t, c.Check(u, ""))
+
+		_, err := os.Stat(c.path)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("TLSConfigChanged", func(t *testing.T) {
+		c, _ := newTestFailureCache(t, DefaultFailureTTL)
+
+		assert.NoError(t, c.Record(u, "ca1", tlsErr))
+		assert.NoError(t, c.Check(u, "ca2"))
+		assert.NoError(t, c.Check(u, ""))
+		assert.Error(t, c.Check(u, "ca1"))
+	})
+
+	t.Run("TLSConfigChangedDNS", func(t *testing.T) {
+		c, _ := newTestFailureCache(t, DefaultFailureTTL)
+
+		// Host lookups do not depend on the TLS configuration.
+		dnsErr := &net.DNSError{Err: "no such host", Name: "example.com", IsNotFound: true}
+		assert.NoError(t, c.Record(u, "ca1", dnsErr))
+		assert.Error(t, c.Check(u, "ca2"))
+	})
+
+	t.Run("OtherURL", func(t *testing.T) {
+		c, _ := newTestFailureCache(t, DefaultFailureTTL)
+
+		assert.NoError(t, c.Record(u, "", tlsErr))
+		assert.NoError(t, c.Check("https://example.org", ""))
+	})
+
+	t.Run("Corrupt", func(t *testing.T) {
+		c, _ := newTestFailureCache(t, DefaultFailureTTL)
+
+		if assert.NoError(t, os.MkdirAll(filepath.Dir(c.path), 0o700)) {
+			assert.NoError(t, os.WriteFile(c.path, []byte("{"), 0o600))
+		}
+		assert.NoError(t, c.Check(u, ""))
+		assert.NoError(t, c.Record(u, "", tlsErr))
+		assert.Error(t, c.Check(u, ""))
+	})
+}
+
+func Test_failureKindCertificate(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	_, err := GetFrontendConfig(context.Background(), nil, ts.URL)
+	assert.Equal(t, failureKindTLS, failureKind(err))
+}
@@ -7,7 +7,6 @@ package endpoints
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -23,20 +22,28 @@ type URI struct {
 	URI string `json:"uri"`
 }
 
+// Notice is a message, such as a maintenance banner, published by a server for its users.
+type Notice struct {
+	Severity string `json:"severity,omitempty"` // For example, "info", "warning" or "critical".
+	Message  string `json:"message"`
+}
+
 type FrontendConfig struct {
-	LibraryAPI URI `json:"libraryAPI"`
-	BuildAPI   URI `json:"builderAPI"`
+	LibraryAPI URI      `json:"libraryAPI"`
+	BuildAPI   URI      `json:"builderAPI"`
+	Notices    []Notice `json:"notices,omitempty"` // Published by newer servers only.
 }
 
 func getFrontendConfigURL(frontendURL string) string {
 	return fmt.Sprintf("%v/%v", strings.TrimSuffix(frontendURL, "/"), frontendConfigPath)
 }
 
-func GetFrontendConfig(ctx context.Context, skipVerify bool, frontendURL string) (*FrontendConfig, error) {
-	tr := http.DefaultTransport.(*http.Transport).Clone()
-	tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: skipVerify}
-
-	httpClient := &http.Client{Transport: tr}
+// GetFrontendConfig retrieves the configuration of the frontend at frontendURL using httpClient. If
+// httpClient is nil, http.DefaultClient is used.
+func GetFrontendConfig(ctx context.Context, httpClient *http.Client, frontendURL string) (*FrontendConfig, error) {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, getFrontendConfigURL(frontendURL), nil)
 	if err != nil {
@@ -55,6 +55,21 @@ func TestGetFrontendConfig(t *testing.T) {
 			"https://build.sylabs.io",
 			nil,
 		},
+		{
+			"Notices",
+			&FrontendConfig{
+				LibraryAPI: URI{URI: "https://library.sylabs.io"},
+				BuildAPI:   URI{URI: "https://build.sylabs.io"},
+				Notices: []Notice{
+					{Severity: "info", Message: "new builders available"},
+					{Severity: "warning", Message: "builders draining for upgrade at 18:00 UTC"},
+					{Severity: "critical", Message: "builds are suspended"},
+				},
+			},
+			"https://library.sylabs.io",
+			"https://build.sylabs.io",
+			nil,
+		},
 		{
 			"Misconfigured",
 			&FrontendConfig{},
@@ -73,10 +88,11 @@ func TestGetFrontendConfig(t *testing.T) {
 			}))
 			defer ts.Close()
 
-			result, err := GetFrontendConfig(ctx, false, ts.URL)
+			result, err := GetFrontendConfig(ctx, nil, ts.URL)
 			if tt.expectedErr == nil && assert.NoError(t, err) {
 				assert.Equal(t, result.LibraryAPI.URI, tt.expectedLibraryURI)
 				assert.Equal(t, result.BuildAPI.URI, tt.expectedBuildURI)
+				assert.Equal(t, tt.cfg.Notices, result.Notices)
 			}
 			if tt.expectedErr != nil {
 				assert.Nil(t, result)
@@ -0,0 +1,388 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package keyless implements keyless signing, in which signatures are made using an ephemeral key
+// certified by Fulcio for an OIDC identity, and recorded in the Rekor transparency log.
+package keyless
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/signature"
+)
+
+const (
+	// DefaultFulcioURL is the URL of the public Fulcio instance.
+	DefaultFulcioURL = "https://fulcio.sigstore.dev"
+
+	// DefaultRekorURL is the URL of the public Rekor instance.
+	DefaultRekorURL = "https://rekor.sigstore.dev"
+
+	// audience is the audience of OIDC identity tokens requested for Sigstore.
+	audience = "sigstore"
+)
+
+var (
+	errNoCredentials    = errors.New("no ambient OIDC credentials found (set SIGSTORE_ID_TOKEN, or run in GitHub Actions with id-token permission)")
+	errMalformedToken   = errors.New("malformed OIDC identity token")
+	errUnexpectedStatus = errors.New("unexpected status")
+	errNoCertificate    = errors.New("no certificate issued")
+)
+
+// Config describes the services used for keyless signing.
+type Config struct {
+	FulcioURL  string       // Fulcio URL. Defaults to DefaultFulcioURL.
+	RekorURL   string       // Rekor URL. Defaults to DefaultRekorURL.
+	IDToken    string       // OIDC identity token. If not set, ambient credentials are used.
+	HTTPClient *http.Client // HTTP client. Defaults to http.DefaultClient.
+}
+
+// AmbientToken returns an OIDC identity token from the environment. The SIGSTORE_ID_TOKEN
+// environment variable is used if set. Otherwise, when running in GitHub Actions, a token is
+// requested from the Actions token service.
+func AmbientToken(ctx context.Context, c *http.Client) (string, error) {
+	if token := os.Getenv("SIGSTORE_ID_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	reqURL, reqToken := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL"), os.Getenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN")
+	if reqURL == "" || reqToken == "" {
+		return "", errNoCredentials
+	}
+
+	u, err := url.Parse(reqURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("audience", audience)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+reqToken)
+
+	var res struct {
+		Value string `json:"value"`
+	}
+	if err := doJSON(c, req, http.StatusOK, &res); err != nil {
+		return "", fmt.Errorf("error requesting GitHub Actions identity token: %w", err)
+	}
+	return res.Value, nil
+}
+
+// tokenSubject returns the identity asserted by the OIDC identity token, which is its email claim,
+// if present, or otherwise its subject claim. The token is not verified, since Fulcio does so.
+func tokenSubject(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errMalformedToken
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errMalformedToken, err)
+	}
+
+	var claims struct {
+		Subject string `json:"sub"`
+		Email   string `json:"email"`
+	}
+	if err := json.Unmarshal(b, &claims); err != nil {
+		return "", fmt.Errorf("%w: %w", errMalformedToken, err)
+	}
+
+	if claims.Email != "" {
+		return claims.Email, nil
+	}
+	if claims.Subject != "" {
+		return claims.Subject, nil
+	}
+	return "", fmt.Errorf("%w: no subject", errMalformedToken)
+}
+
+// doJSON sends req using c and, if the response has the wanted status code, decodes its body to v.
+func doJSON(c *http.Client, req *http.Request, want int, v any) error {
+	res, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != want {
+		b, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+		return fmt.Errorf("%w %v: %s", errUnexpectedStatus, res.Status, bytes.TrimSpace(b))
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// postJSON posts v in JSON format to rawURL using c, and decodes a response with the wanted status
+// code to r.
+func postJSON(ctx context.Context, c *http.Client, rawURL string, v any, want int, r any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	return doJSON(c, req, want, r)
+}
+
+// signedMessage is a signature made by a Signer, yet to be recorded in the transparency log.
+type signedMessage struct {
+	digest []byte // SHA-256 digest of the message.
+	sig    []byte // Signature over the message.
+}
+
+// Signer is a signature.SignerVerifier using an ephemeral key certified by Fulcio. The key is
+// certified when the first message is signed, so that no identity token is requested unless a
+// signature is made. The signatures it makes are recorded in the Rekor transparency log by Publish.
+type Signer struct {
+	signature.SignerVerifier
+
+	pub       []byte // PEM-encoded public key.
+	idToken   string
+	fulcioURL string
+	rekorURL  string
+	c         *http.Client
+
+	mu      sync.Mutex
+	cert    []byte // PEM-encoded certificate chain, leaf first, once certified.
+	pending []signedMessage
+}
+
+// NewSigner returns a Signer using an ephemeral key, to be certified by Fulcio for the identity in
+// the OIDC identity token in cfg, or obtained from ambient credentials.
+func NewSigner(cfg Config) (*Signer, error) {
+	c := cfg.HTTPClient
+	if c == nil {
+		c = http.DefaultClient
+	}
+
+	fulcioURL := cfg.FulcioURL
+	if fulcioURL == "" {
+		fulcioURL = DefaultFulcioURL
+	}
+
+	rekorURL := cfg.RekorURL
+	if rekorURL == "" {
+		rekorURL = DefaultRekorURL
+	}
+
+	sv, priv, err := signature.NewDefaultECDSASignerVerifier()
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := cryptoutils.MarshalPublicKeyToPEM(priv.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Signer{
+		SignerVerifier: sv,
+		pub:            pub,
+		idToken:        cfg.IDToken,
+		fulcioURL:      fulcioURL,
+		rekorURL:       strings.TrimSuffix(rekorURL, "/"),
+		c:              c,
+	}, nil
+}
+
+// certify obtains a certificate for the ephemeral key from Fulcio, if not already obtained. The
+// caller must hold s.mu.
+func (s *Signer) certify(ctx context.Context) error {
+	if s.cert != nil {
+		return nil
+	}
+
+	token := s.idToken
+	if token == "" {
+		var err error
+		if token, err = AmbientToken(ctx, s.c); err != nil {
+			return err
+		}
+	}
+
+	subject, err := tokenSubject(token)
+	if err != nil {
+		return err
+	}
+
+	// Fulcio requires proof of possession of the private key, in the form of a signature over the
+	// identity asserted by the token.
+	proof, err := s.SignerVerifier.SignMessage(strings.NewReader(subject))
+	if err != nil {
+		return err
+	}
+
+	cert, err := requestCertificate(ctx, s.c, s.fulcioURL, token, s.pub, proof)
+	if err != nil {
+		return fmt.Errorf("error requesting signing certificate from %v: %w", s.fulcioURL, err)
+	}
+
+	s.cert = cert
+	return nil
+}
+
+// requestCertificate requests a certificate for the PEM-encoded public key pub from Fulcio, and
+// returns the PEM-encoded certificate chain issued.
+func requestCertificate(ctx context.Context, c *http.Client, fulcioURL, token string, pub, proof []byte) ([]byte, error) {
+	type chain struct {
+		Certificates []string `json:"certificates"`
+	}
+	type signedCertificate struct {
+		Chain chain `json:"chain"`
+	}
+
+	req := map[string]any{
+		"credentials": map[string]any{
+			"oidcIdentityToken": token,
+		},
+		"publicKeyRequest": map[string]any{
+			"publicKey": map[string]any{
+				"algorithm": "ECDSA",
+				"content":   string(pub),
+			},
+			"proofOfPossession": proof,
+		},
+	}
+
+	var res struct {
+		EmbeddedSCT *signedCertificate `json:"signedCertificateEmbeddedSct"`
+		DetachedSCT *signedCertificate `json:"signedCertificateDetachedSct"`
+	}
+	if err := postJSON(ctx, c, strings.TrimSuffix(fulcioURL, "/")+"/api/v2/signingCert", req, http.StatusOK, &res); err != nil {
+		return nil, err
+	}
+
+	sc := res.EmbeddedSCT
+	if sc == nil {
+		sc = res.DetachedSCT
+	}
+	if sc == nil || len(sc.Chain.Certificates) == 0 {
+		return nil, errNoCertificate
+	}
+
+	return []byte(strings.Join(sc.Chain.Certificates, "")), nil
+}
+
+// Certificate returns the PEM-encoded certificate chain issued by Fulcio, leaf first. The key is
+// certified when the first message is signed, so nil is returned before then.
+func (s *Signer) Certificate() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.cert
+}
+
+// SignMessage signs message, and records the signature to be published. If the key is not yet
+// certified, it is certified first, using the context supplied by options.WithContext, if any.
+func (s *Signer) SignMessage(message io.Reader, opts ...signature.SignOption) ([]byte, error) {
+	ctx := context.Background()
+	for _, opt := range opts {
+		opt.ApplyContext(&ctx)
+	}
+
+	b, err := io.ReadAll(message)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.certify(ctx); err != nil {
+		return nil, err
+	}
+
+	sig, err := s.SignerVerifier.SignMessage(bytes.NewReader(b), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256(b)
+
+	s.pending = append(s.pending, signedMessage{digest: digest[:], sig: sig})
+
+	return sig, nil
+}
+
+// Publish records the signatures made since the last call in the Rekor transparency log, and
+// returns the URLs of the entries created.
+func (s *Signer) Publish(ctx context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var urls []string
+
+	for len(s.pending) > 0 {
+		u, err := s.createEntry(ctx, s.pending[0])
+		if err != nil {
+			return urls, fmt.Errorf("error recording signature in %v: %w", s.rekorURL, err)
+		}
+		urls = append(urls, u)
+
+		s.pending = s.pending[1:]
+	}
+
+	return urls, nil
+}
+
+// createEntry records sm in the transparency log as a hashedrekord entry, and returns its URL.
+func (s *Signer) createEntry(ctx context.Context, sm signedMessage) (string, error) {
+	req := map[string]any{
+		"apiVersion": "0.0.1",
+		"kind":       "hashedrekord",
+		"spec": map[string]any{
+			"signature": map[string]any{
+				"content": sm.sig,
+				"publicKey": map[string]any{
+					"content": s.cert,
+				},
+			},
+			"data": map[string]any{
+				"hash": map[string]any{
+					"algorithm": "sha256",
+					"value":     hex.EncodeToString(sm.digest),
+				},
+			},
+		},
+	}
+
+	// The response maps the UUID of the entry created to its contents.
+	var res map[string]json.RawMessage
+	if err := postJSON(ctx, s.c, s.rekorURL+"/api/v1/log/entries", req, http.StatusCreated, &res); err != nil {
+		return "", err
+	}
+
+	for uuid := range res {
+		return s.rekorURL + "/api/v1/log/entries/" + uuid, nil
+	}
+	return "", fmt.Errorf("%w: no entry created", errUnexpectedStatus)
+}
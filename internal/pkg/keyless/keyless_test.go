@@ -0,0 +1,398 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package keyless
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/sigstore/sigstore/pkg/signature/options"
+	"github.com/stretchr/testify/assert"
+)
+
+// testToken returns an unsigned OIDC identity token containing claims.
+func testToken(t *testing.T, claims map[string]string) string {
+	t.Helper()
+
+	b, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(`{"alg":"none"}`)) + "." + enc.EncodeToString(b) + ".sig"
+}
+
+// fakeFulcio issues certificates for public keys, once proof of possession is verified.
+type fakeFulcio struct {
+	t       *testing.T
+	subject string // Subject expected in proof of possession.
+	code    int    // If set, status code returned in place of a certificate.
+
+	requests atomic.Int64 // Number of certificate requests.
+
+	caKey  *ecdsa.PrivateKey
+	caCert *x509.Certificate
+}
+
+func newFakeFulcio(t *testing.T, subject string) *fakeFulcio {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Fake Fulcio"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &fakeFulcio{t: t, subject: subject, caKey: key, caCert: cert}
+}
+
+func (f *fakeFulcio) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests.Add(1)
+
+	if got, want := r.URL.Path, "/api/v2/signingCert"; got != want {
+		f.t.Errorf("got path %v, want %v", got, want)
+	}
+
+	if f.code != 0 {
+		http.Error(w, "rejected", f.code)
+		return
+	}
+
+	var req struct {
+		PublicKeyRequest struct {
+			PublicKey struct {
+				Content string `json:"content"`
+			} `json:"publicKey"`
+			ProofOfPossession []byte `json:"proofOfPossession"`
+		} `json:"publicKeyRequest"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		f.t.Fatal(err)
+	}
+
+	pub, err := cryptoutils.UnmarshalPEMToPublicKey([]byte(req.PublicKeyRequest.PublicKey.Content))
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	v, err := signature.LoadVerifier(pub, crypto.SHA256)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	if err := v.VerifySignature(bytes.NewReader(req.PublicKeyRequest.ProofOfPossession), strings.NewReader(f.subject)); err != nil {
+		http.Error(w, "invalid proof of possession", http.StatusBadRequest)
+		return
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:   big.NewInt(2),
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(10 * time.Minute),
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		EmailAddresses: []string{f.subject},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, f.caCert, pub, f.caKey)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	leaf := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	root := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.caCert.Raw})
+
+	res := map[string]any{
+		"signedCertificateEmbeddedSct": map[string]any{
+			"chain": map[string]any{
+				"certificates": []string{string(leaf), string(root)},
+			},
+		},
+	}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// fakeRekor records hashedrekord entries, once their signatures are verified.
+type fakeRekor struct {
+	t *testing.T
+
+	mu      sync.Mutex
+	digests []string // Digests of entries created.
+}
+
+func (f *fakeRekor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if got, want := r.URL.Path, "/api/v1/log/entries"; got != want {
+		f.t.Errorf("got path %v, want %v", got, want)
+	}
+
+	var req struct {
+		Kind string `json:"kind"`
+		Spec struct {
+			Signature struct {
+				Content   []byte `json:"content"`
+				PublicKey struct {
+					Content []byte `json:"content"`
+				} `json:"publicKey"`
+			} `json:"signature"`
+			Data struct {
+				Hash struct {
+					Algorithm string `json:"algorithm"`
+					Value     string `json:"value"`
+				} `json:"hash"`
+			} `json:"data"`
+		} `json:"spec"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		f.t.Fatal(err)
+	}
+
+	certs, err := cryptoutils.UnmarshalCertificatesFromPEM(req.Spec.Signature.PublicKey.Content)
+	if err != nil || len(certs) == 0 {
+		http.Error(w, "invalid certificate", http.StatusBadRequest)
+		return
+	}
+
+	v, err := signature.LoadVerifier(certs[0].PublicKey, crypto.SHA256)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	digest, err := hex.DecodeString(req.Spec.Data.Hash.Value)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	if err := v.VerifySignature(bytes.NewReader(req.Spec.Signature.Content), nil, options.WithDigest(digest)); err != nil {
+		http.Error(w, "invalid signature", http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.digests = append(f.digests, req.Spec.Data.Hash.Value)
+	uuid := hex.EncodeToString([]byte{byte(len(f.digests))})
+
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(map[string]any{uuid: map[string]any{"logIndex": len(f.digests)}}); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func TestSigner(t *testing.T) {
+	const subject = "ci@example.com"
+
+	tests := []struct {
+		name       string
+		claims     map[string]string
+		fulcioCode int
+		wantErr    error
+	}{
+		{
+			name:   "Email",
+			claims: map[string]string{"sub": "12345", "email": subject},
+		},
+		{
+			name:   "Subject",
+			claims: map[string]string{"sub": subject},
+		},
+		{
+			name:    "NoSubject",
+			claims:  map[string]string{},
+			wantErr: errMalformedToken,
+		},
+		{
+			name:       "FulcioRejected",
+			claims:     map[string]string{"sub": subject},
+			fulcioCode: http.StatusUnauthorized,
+			wantErr:    errUnexpectedStatus,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ff := newFakeFulcio(t, subject)
+			ff.code = tt.fulcioCode
+			fulcio := httptest.NewServer(ff)
+			t.Cleanup(fulcio.Close)
+
+			fr := &fakeRekor{t: t}
+			rekor := httptest.NewServer(fr)
+			t.Cleanup(rekor.Close)
+
+			s, err := NewSigner(Config{
+				FulcioURL: fulcio.URL,
+				RekorURL:  rekor.URL,
+				IDToken:   testToken(t, tt.claims),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The key is certified only once a message is signed.
+			assert.Nil(t, s.Certificate())
+			assert.Zero(t, ff.requests.Load())
+
+			_, err = s.SignMessage(strings.NewReader("first"))
+
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if err != nil {
+				return
+			}
+
+			certs, err := cryptoutils.UnmarshalCertificatesFromPEM(s.Certificate())
+			if assert.NoError(t, err) && assert.Len(t, certs, 2) {
+				assert.Equal(t, []string{subject}, certs[0].EmailAddresses)
+			}
+
+			messages := []string{"first", "second"}
+			for _, m := range messages[1:] {
+				sig, err := s.SignMessage(strings.NewReader(m))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				assert.NoError(t, s.VerifySignature(bytes.NewReader(sig), strings.NewReader(m)))
+			}
+
+			// The key is certified once, however many messages are signed.
+			assert.Equal(t, int64(1), ff.requests.Load())
+
+			urls, err := s.Publish(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if assert.Len(t, urls, len(messages)) {
+				for _, u := range urls {
+					assert.True(t, strings.HasPrefix(u, rekor.URL+"/api/v1/log/entries/"), "unexpected URL %v", u)
+				}
+			}
+
+			for i, m := range messages {
+				sum := sha256.Sum256([]byte(m))
+				assert.Equal(t, hex.EncodeToString(sum[:]), fr.digests[i])
+			}
+
+			// Signatures are only published once.
+			urls, err = s.Publish(context.Background())
+			assert.NoError(t, err)
+			assert.Empty(t, urls)
+		})
+	}
+}
+
+func TestAmbientToken(t *testing.T) {
+	actions := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer request-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		assert.Equal(t, audience, r.URL.Query().Get("audience"))
+
+		if err := json.NewEncoder(w).Encode(map[string]string{"value": "actions-token"}); err != nil {
+			t.Error(err)
+		}
+	}))
+	t.Cleanup(actions.Close)
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    string
+		wantErr error
+	}{
+		{
+			name: "SigstoreIDToken",
+			env:  map[string]string{"SIGSTORE_ID_TOKEN": "sigstore-token"},
+			want: "sigstore-token",
+		},
+		{
+			name: "GitHubActions",
+			env: map[string]string{
+				"ACTIONS_ID_TOKEN_REQUEST_URL":   actions.URL + "?api-version=2.0",
+				"ACTIONS_ID_TOKEN_REQUEST_TOKEN": "request-token",
+			},
+			want: "actions-token",
+		},
+		{
+			name: "GitHubActionsRejected",
+			env: map[string]string{
+				"ACTIONS_ID_TOKEN_REQUEST_URL":   actions.URL,
+				"ACTIONS_ID_TOKEN_REQUEST_TOKEN": "wrong-token",
+			},
+			wantErr: errUnexpectedStatus,
+		},
+		{
+			name:    "None",
+			wantErr: errNoCredentials,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range []string{"SIGSTORE_ID_TOKEN", "ACTIONS_ID_TOKEN_REQUEST_URL", "ACTIONS_ID_TOKEN_REQUEST_TOKEN"} {
+				t.Setenv(k, tt.env[k])
+			}
+
+			got, err := AmbientToken(context.Background(), http.DefaultClient)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
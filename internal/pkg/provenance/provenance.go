@@ -0,0 +1,219 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package provenance constructs in-toto statements containing SLSA provenance describing remote
+// builds.
+package provenance
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// StatementType is the type of an in-toto statement.
+	StatementType = "https://in-toto.io/Statement/v1"
+
+	// PredicateType is the type of a SLSA provenance predicate.
+	PredicateType = "https://slsa.dev/provenance/v1"
+
+	// BuildType describes how remote builds are performed, and how the parameters are interpreted.
+	BuildType = "https://github.com/sylabs/scs-build-client/buildtypes/remote-build/v1"
+)
+
+// Statement is an in-toto statement.
+type Statement struct {
+	Type          string               `json:"_type"`
+	Subject       []ResourceDescriptor `json:"subject"`
+	PredicateType string               `json:"predicateType"`
+	Predicate     Predicate            `json:"predicate"`
+}
+
+// ResourceDescriptor describes an artifact or dependency.
+type ResourceDescriptor struct {
+	Name   string            `json:"name,omitempty"`
+	URI    string            `json:"uri,omitempty"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+// Predicate is a SLSA provenance predicate.
+type Predicate struct {
+	BuildDefinition BuildDefinition `json:"buildDefinition"`
+	RunDetails      RunDetails      `json:"runDetails"`
+}
+
+// BuildDefinition describes the inputs to a build.
+type BuildDefinition struct {
+	BuildType            string               `json:"buildType"`
+	ExternalParameters   ExternalParameters   `json:"externalParameters"`
+	ResolvedDependencies []ResourceDescriptor `json:"resolvedDependencies,omitempty"`
+}
+
+// ExternalParameters describes the parameters of a build under the control of the invoker.
+type ExternalParameters struct {
+	Definition    ResourceDescriptor `json:"definition"`
+	Architectures []string           `json:"architectures"`
+	Requirements  map[string]string  `json:"requirements,omitempty"`
+}
+
+// RunDetails describes the execution of a build.
+type RunDetails struct {
+	Builder    Builder              `json:"builder"`
+	Metadata   BuildMetadata        `json:"metadata"`
+	Byproducts []ResourceDescriptor `json:"byproducts,omitempty"`
+}
+
+// Builder identifies the entity that executed a build.
+type Builder struct {
+	ID      string            `json:"id"`
+	Version map[string]string `json:"version,omitempty"`
+}
+
+// BuildMetadata describes a build invocation.
+type BuildMetadata struct {
+	InvocationID string    `json:"invocationId,omitempty"`
+	StartedOn    time.Time `json:"startedOn"`
+	FinishedOn   time.Time `json:"finishedOn"`
+}
+
+// Artifact describes the result of a build for a single architecture.
+type Artifact struct {
+	Arch             string // Architecture of the artifact.
+	Name             string // Library ref or file name of the artifact.
+	Digest           string // Checksum of the artifact, in "sha256.<hex>" format.
+	BuildID          string // ID of the build that produced the artifact.
+	DefinitionDigest string // Checksum of the definition built, as reported by the Build Service, if any.
+}
+
+// Build describes a remote build, from which provenance is generated.
+type Build struct {
+	FrontendURL         string            // URL of the Singularity Container Services frontend.
+	BuildServiceVersion string            // Version reported by the Build Service.
+	ClientVersion       string            // Version of the client requesting the build.
+	DefinitionName      string            // Name of the build definition.
+	DefinitionDigest    string            // Checksum of the build definition, in "sha256.<hex>" format.
+	Requirements        map[string]string // Builder requirements.
+	ContextDigest       string            // Checksum of the build context, in "sha256.<hex>" format.
+	ContextSource       string            // Git repository the build context was fetched from, if any.
+	ContextCommit       string            // Commit SHA of ContextSource.
+	BootstrapSource     string            // Source of the bootstrap image, if any.
+	Artifacts           []Artifact        // Per-architecture results.
+	StartedOn           time.Time         // Time at which the build was started.
+	FinishedOn          time.Time         // Time at which the build finished.
+}
+
+// digestSet converts a checksum in "<algorithm>.<hex>" format, as reported by the Build Service,
+// to an in-toto digest set. If the checksum is malformed, nil is returned.
+func digestSet(checksum string) map[string]string {
+	alg, value, ok := strings.Cut(checksum, ".")
+	if !ok || alg == "" || value == "" {
+		return nil
+	}
+	return map[string]string{strings.ToLower(alg): value}
+}
+
+// NewStatement returns an in-toto statement containing SLSA provenance describing b. Artifacts
+// with malformed digests are omitted from the subject.
+func NewStatement(b Build) *Statement {
+	s := &Statement{
+		Type:          StatementType,
+		Subject:       []ResourceDescriptor{},
+		PredicateType: PredicateType,
+	}
+
+	artifacts := append([]Artifact(nil), b.Artifacts...)
+	sort.Slice(artifacts, func(i, j int) bool { return artifacts[i].Arch < artifacts[j].Arch })
+
+	archs := []string{}
+	var buildIDs []string
+
+	for _, a := range artifacts {
+		archs = append(archs, a.Arch)
+
+		if a.BuildID != "" {
+			buildIDs = append(buildIDs, a.BuildID)
+		}
+
+		if d := digestSet(a.Digest); d != nil {
+			s.Subject = append(s.Subject, ResourceDescriptor{Name: a.Name, Digest: d})
+		}
+
+		// The definition built is recorded alongside that submitted, so that they may be compared.
+		if d := digestSet(a.DefinitionDigest); d != nil {
+			s.Predicate.RunDetails.Byproducts = append(s.Predicate.RunDetails.Byproducts, ResourceDescriptor{
+				Name:   "definition-built-" + a.Arch,
+				Digest: d,
+			})
+		}
+	}
+
+	bd := &s.Predicate.BuildDefinition
+	bd.BuildType = BuildType
+	bd.ExternalParameters = ExternalParameters{
+		Definition: ResourceDescriptor{
+			Name:   b.DefinitionName,
+			Digest: digestSet(b.DefinitionDigest),
+		},
+		Architectures: archs,
+		Requirements:  b.Requirements,
+	}
+
+	if d := digestSet(b.ContextDigest); d != nil {
+		bd.ResolvedDependencies = append(bd.ResolvedDependencies, ResourceDescriptor{
+			Name:   "build-context",
+			Digest: d,
+		})
+	}
+
+	if b.ContextSource != "" {
+		dep := ResourceDescriptor{
+			Name: "build-context-source",
+			URI:  b.ContextSource,
+		}
+		if b.ContextCommit != "" {
+			dep.Digest = map[string]string{"gitCommit": b.ContextCommit}
+		}
+		bd.ResolvedDependencies = append(bd.ResolvedDependencies, dep)
+	}
+
+	if b.BootstrapSource != "" {
+		bd.ResolvedDependencies = append(bd.ResolvedDependencies, ResourceDescriptor{
+			Name: "bootstrap",
+			URI:  b.BootstrapSource,
+		})
+	}
+
+	rd := &s.Predicate.RunDetails
+	rd.Builder.ID = b.FrontendURL
+
+	if b.BuildServiceVersion != "" || b.ClientVersion != "" {
+		rd.Builder.Version = make(map[string]string)
+
+		if b.BuildServiceVersion != "" {
+			rd.Builder.Version["buildService"] = b.BuildServiceVersion
+		}
+		if b.ClientVersion != "" {
+			rd.Builder.Version["client"] = b.ClientVersion
+		}
+	}
+
+	rd.Metadata = BuildMetadata{
+		InvocationID: strings.Join(buildIDs, ","),
+		StartedOn:    b.StartedOn.UTC(),
+		FinishedOn:   b.FinishedOn.UTC(),
+	}
+
+	return s
+}
+
+// Write writes the JSON encoding of s to w.
+func (s *Statement) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package provenance
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/sebdah/goldie/v2"
+)
+
+func TestNewStatement(t *testing.T) {
+	started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	finished := started.Add(5 * time.Minute)
+
+	tests := []struct {
+		name string
+		b    Build
+	}{
+		{
+			name: "Minimal",
+			b: Build{
+				FrontendURL:      "https://cloud.sylabs.io",
+				DefinitionDigest: "sha256.9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+				Artifacts: []Artifact{
+					{Arch: "amd64", Name: "image.sif", Digest: "sha256.2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", BuildID: "6387923149ab6b512d0326f3"},
+				},
+				StartedOn:  started,
+				FinishedOn: finished,
+			},
+		},
+		{
+			name: "Full",
+			b: Build{
+				FrontendURL:         "https://cloud.enterprise.local",
+				BuildServiceVersion: "1.2.3",
+				ClientVersion:       "scs-build/0.9.0",
+				DefinitionName:      "alpine.def",
+				DefinitionDigest:    "sha256.9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+				Requirements:        map[string]string{"gpu": "true", "region": "us-east"},
+				ContextDigest:       "sha256.fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9",
+				BootstrapSource:     "docker://alpine:3",
+				Artifacts: []Artifact{
+					{Arch: "arm64", Name: "library://user/project/image:tag", Digest: "sha256.fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9", BuildID: "6387923149ab6b512d0326f4"},
+					{Arch: "amd64", Name: "library://user/project/image:tag", Digest: "sha256.2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", BuildID: "6387923149ab6b512d0326f3"},
+				},
+				StartedOn:  started.In(time.FixedZone("EST", -5*60*60)),
+				FinishedOn: finished,
+			},
+		},
+		{
+			name: "GitContext",
+			b: Build{
+				FrontendURL:      "https://cloud.sylabs.io",
+				DefinitionName:   "app.def",
+				DefinitionDigest: "sha256.9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+				ContextDigest:    "sha256.fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9",
+				ContextSource:    "git+https://git.example.com/repo.git@v1",
+				ContextCommit:    "3f786850e387550fdab836ed7e6dc881de23001b",
+				Artifacts: []Artifact{
+					{Arch: "amd64", Name: "image.sif", Digest: "sha256.2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", BuildID: "6387923149ab6b512d0326f3"},
+				},
+				StartedOn:  started,
+				FinishedOn: finished,
+			},
+		},
+		{
+			name: "BuiltDefinition",
+			b: Build{
+				FrontendURL:      "https://cloud.sylabs.io",
+				DefinitionName:   "alpine.def",
+				DefinitionDigest: "sha256.9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+				Artifacts: []Artifact{
+					{Arch: "arm64", Name: "image.sif-arm64", Digest: "sha256.fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9", BuildID: "6387923149ab6b512d0326f4", DefinitionDigest: "sha256.9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"},
+					{Arch: "amd64", Name: "image.sif-amd64", Digest: "sha256.2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", BuildID: "6387923149ab6b512d0326f3"},
+				},
+				StartedOn:  started,
+				FinishedOn: finished,
+			},
+		},
+		{
+			name: "MalformedDigests",
+			b: Build{
+				FrontendURL:      "https://cloud.sylabs.io",
+				DefinitionDigest: "unknown",
+				ContextDigest:    "sha256.",
+				Artifacts: []Artifact{
+					{Arch: "amd64", Name: "image.sif", Digest: ""},
+				},
+				StartedOn:  started,
+				FinishedOn: finished,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := NewStatement(tt.b).Write(&b); err != nil {
+				t.Fatal(err)
+			}
+
+			g := goldie.New(t, goldie.WithTestNameForDir(true))
+			g.Assert(t, tt.name, b.Bytes())
+		})
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package provenance
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/sigstore/sigstore/pkg/signature"
+)
+
+// Signer produces detached signatures over serialized statements.
+type Signer interface {
+	// Sign returns a detached signature over payload.
+	Sign(payload []byte) ([]byte, error)
+}
+
+type pgpSigner struct {
+	e *openpgp.Entity
+}
+
+// NewPGPSigner returns a Signer that produces ASCII-armored PGP signatures using e.
+func NewPGPSigner(e *openpgp.Entity) Signer {
+	return pgpSigner{e: e}
+}
+
+// Sign returns an ASCII-armored detached PGP signature over payload.
+func (s pgpSigner) Sign(payload []byte) ([]byte, error) {
+	var b bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&b, s.e, bytes.NewReader(payload), nil); err != nil {
+		return nil, fmt.Errorf("failed to sign statement: %w", err)
+	}
+	b.WriteByte('\n')
+	return b.Bytes(), nil
+}
+
+type keySigner struct {
+	s signature.Signer
+}
+
+// NewKeySigner returns a Signer that produces base64-encoded signatures using s.
+func NewKeySigner(s signature.Signer) Signer {
+	return keySigner{s: s}
+}
+
+// Sign returns a base64-encoded detached signature over payload.
+func (s keySigner) Sign(payload []byte) ([]byte, error) {
+	sig, err := s.s.SignMessage(bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign statement: %w", err)
+	}
+	return []byte(base64.StdEncoding.EncodeToString(sig) + "\n"), nil
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package provenance
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/sigstore/sigstore/pkg/signature"
+)
+
+var payload = []byte(`{"_type":"https://in-toto.io/Statement/v1"}`)
+
+func TestPGPSigner(t *testing.T) {
+	e, err := openpgp.NewEntity("Test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig, err := NewPGPSigner(e).Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := openpgp.CheckArmoredDetachedSignature(openpgp.EntityList{e}, bytes.NewReader(payload), bytes.NewReader(sig), nil); err != nil {
+		t.Errorf("failed to verify signature: %v", err)
+	}
+
+	tampered := append([]byte(nil), payload...)
+	tampered[0] = '['
+
+	if _, err := openpgp.CheckArmoredDetachedSignature(openpgp.EntityList{e}, bytes.NewReader(tampered), bytes.NewReader(sig), nil); err == nil {
+		t.Errorf("verified signature over tampered payload")
+	}
+}
+
+func TestKeySigner(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sv, err := signature.LoadECDSASignerVerifier(key, crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := NewKeySigner(sv).Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sv.VerifySignature(bytes.NewReader(sig), bytes.NewReader(payload)); err != nil {
+		t.Errorf("failed to verify signature: %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package statedir
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var errLocked = errors.New("lock held by another process")
+
+// Lock is an acquired lock.
+type Lock struct {
+	release func() error
+}
+
+// Unlock releases the lock.
+func (l *Lock) Unlock() error {
+	if l == nil || l.release == nil {
+		return nil
+	}
+	release := l.release
+	l.release = nil
+	return release()
+}
+
+// owner identifies the process holding a lock. The start time of the process distinguishes it
+// from an unrelated process that has reused the same PID.
+type owner struct {
+	pid   int
+	start string // Empty if unknown.
+}
+
+// currentOwner returns the owner record for this process.
+func currentOwner() owner {
+	pid := os.Getpid()
+	start, _ := processStart(pid)
+	return owner{pid: pid, start: start}
+}
+
+func (o owner) String() string {
+	return fmt.Sprintf("%d %s\n", o.pid, o.start)
+}
+
+var errMalformedOwner = errors.New("malformed lock owner")
+
+// parseOwner parses an owner record, as written by owner.String.
+func parseOwner(s string) (owner, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 1 || len(fields) > 2 {
+		return owner{}, errMalformedOwner
+	}
+
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil || pid <= 0 {
+		return owner{}, errMalformedOwner
+	}
+
+	o := owner{pid: pid}
+	if len(fields) == 2 {
+		o.start = fields[1]
+	}
+	return o, nil
+}
+
+// stale returns true if the process identified by o is no longer running.
+func (o owner) stale() bool {
+	if !processAlive(o.pid) {
+		return true
+	}
+
+	// The PID is in use; if the start time differs, it has been reused by another process.
+	if o.start != "" {
+		if start, err := processStart(o.pid); err == nil && start != o.start {
+			return true
+		}
+	}
+	return false
+}
+
+// tryLockFile attempts to acquire the lock at path by exclusively creating it. If the lock file
+// exists but its owner is stale, the lock file is removed and acquisition retried. This is used
+// where advisory file locks are unavailable, and returns errLocked if the lock is held.
+func tryLockFile(path string) (*Lock, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err == nil {
+			if _, err := f.WriteString(currentOwner().String()); err != nil {
+				f.Close()
+				os.Remove(path)
+				return nil, err
+			}
+			if err := f.Close(); err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+			return &Lock{release: func() error { return os.Remove(path) }}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		b, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue // Released in the meantime.
+		} else if err != nil {
+			return nil, err
+		}
+
+		// A partially written record may belong to a process in the midst of acquiring the lock,
+		// so only a well-formed, stale record is removed.
+		o, err := parseOwner(string(b))
+		if err != nil || !o.stale() {
+			return nil, errLocked
+		}
+
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, errLocked
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+//go:build unix
+
+package statedir
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// tryLock attempts to acquire the lock at path using flock(2), returning errLocked if the lock is
+// held. As the kernel releases the lock when its holder exits, such locks are never stale. If the
+// filesystem does not support flock(2), tryLockFile is used instead.
+func tryLock(path string) (*Lock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, errLocked
+		}
+		if errors.Is(err, syscall.ENOLCK) || errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.EOPNOTSUPP) {
+			return tryLockFile(path + ".pid")
+		}
+		return nil, err
+	}
+
+	// Record the owner, to aid diagnosis.
+	if err := f.Truncate(0); err == nil {
+		_, _ = f.WriteAt([]byte(currentOwner().String()), 0)
+	}
+
+	return &Lock{release: f.Close}, nil
+}
+
+// processAlive returns true if a process with the specified PID exists.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,38 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package statedir
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var errMalformedStat = errors.New("malformed process stat")
+
+// processStart returns the start time of the process with the specified PID, in clock ticks since
+// boot.
+func processStart(pid int) (string, error) {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return "", err
+	}
+
+	// The command name is parenthesized and may contain spaces, so fields are counted from the
+	// final closing parenthesis. The start time is field 22; field 3 follows the command name.
+	s := string(b)
+	i := strings.LastIndexByte(s, ')')
+	if i < 0 {
+		return "", errMalformedStat
+	}
+
+	fields := strings.Fields(s[i+1:])
+	if len(fields) < 20 {
+		return "", errMalformedStat
+	}
+	return fields[19], nil
+}
@@ -0,0 +1,18 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+//go:build !linux
+
+package statedir
+
+import "errors"
+
+var errStartUnsupported = errors.New("process start time not supported on this platform")
+
+// processStart returns the start time of the process with the specified PID. This is not
+// supported on this platform.
+func processStart(int) (string, error) {
+	return "", errStartUnsupported
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package statedir implements a directory holding state shared between concurrent runs, along with
+// advisory locks that prevent those runs from clobbering shared outputs.
+package statedir
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var errNoStateHome = errors.New("unable to determine state directory: neither XDG_STATE_HOME nor HOME set")
+
+// Default returns the default state directory, $XDG_STATE_HOME/scs-build.
+func Default() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "scs-build"), nil
+	}
+
+	if home := os.Getenv("HOME"); home != "" {
+		return filepath.Join(home, ".local", "state", "scs-build"), nil
+	}
+
+	return "", errNoStateHome
+}
+
+// Dir is a state directory.
+type Dir struct {
+	path string
+}
+
+// Open opens the state directory at path, creating it if necessary.
+func Open(path string) (*Dir, error) {
+	if err := os.MkdirAll(filepath.Join(path, "locks"), 0o700); err != nil {
+		return nil, fmt.Errorf("error creating state directory: %w", err)
+	}
+	return &Dir{path: path}, nil
+}
+
+// Path returns the path of the state directory.
+func (d *Dir) Path() string {
+	return d.path
+}
+
+// pollInterval is the interval at which acquisition of a held lock is retried.
+const pollInterval = 100 * time.Millisecond
+
+// Lock acquires the lock named by kind and key, blocking until it is acquired or ctx is done.
+// The key may be any string, such as a path or digest.
+func (d *Dir) Lock(ctx context.Context, kind, key string) (*Lock, error) {
+	sum := sha256.Sum256([]byte(key))
+	path := filepath.Join(d.path, "locks", fmt.Sprintf("%v-%v.lock", kind, hex.EncodeToString(sum[:8])))
+
+	for {
+		l, err := tryLock(path)
+		if err == nil {
+			return l, nil
+		}
+		if !errors.Is(err, errLocked) {
+			return nil, fmt.Errorf("error acquiring %v lock: %w", kind, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("error acquiring %v lock: %w", kind, ctx.Err())
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// LockDestination acquires the lock for the destination dst, which may be a file path or
+// library ref.
+func (d *Dir) LockDestination(ctx context.Context, dst string) (*Lock, error) {
+	if abs, err := filepath.Abs(dst); err == nil && !isLibraryRef(dst) {
+		dst = abs
+	}
+	return d.Lock(ctx, "destination", dst)
+}
+
+// LockMetadata acquires the lock for the run metadata file at path.
+func (d *Dir) LockMetadata(ctx context.Context, path string) (*Lock, error) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return d.Lock(ctx, "metadata", path)
+}
+
+// LockContext acquires the lock for the build context identified by digest.
+func (d *Dir) LockContext(ctx context.Context, digest string) (*Lock, error) {
+	return d.Lock(ctx, "context", digest)
+}
+
+// isLibraryRef returns true if dst refers to a library, rather than a local file.
+func isLibraryRef(dst string) bool {
+	return strings.HasPrefix(dst, "library:")
+}
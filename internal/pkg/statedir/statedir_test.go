@@ -0,0 +1,303 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package statedir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDefault(t *testing.T) {
+	tests := []struct {
+		name         string
+		xdgStateHome string
+		home         string
+		want         string
+		wantErr      error
+	}{
+		{"XDGStateHome", "/xdg", "/home/user", "/xdg/scs-build", nil},
+		{"Home", "", "/home/user", "/home/user/.local/state/scs-build", nil},
+		{"Neither", "", "", "", errNoStateHome},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("XDG_STATE_HOME", tt.xdgStateHome)
+			t.Setenv("HOME", tt.home)
+
+			got, err := Default()
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if want := tt.want; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestDir_LockConcurrent(t *testing.T) {
+	d, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		wg      sync.WaitGroup
+		holders atomic.Int32
+		maxSeen atomic.Int32
+		count   int
+	)
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			l, err := d.LockDestination(context.Background(), "image.sif")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer l.Unlock()
+
+			n := holders.Add(1)
+			defer holders.Add(-1)
+
+			for m := maxSeen.Load(); n > m && !maxSeen.CompareAndSwap(m, n); m = maxSeen.Load() {
+			}
+
+			count++
+			time.Sleep(10 * time.Millisecond)
+		}()
+	}
+
+	wg.Wait()
+
+	if got, want := maxSeen.Load(), int32(1); got != want {
+		t.Errorf("got %v concurrent holders, want %v", got, want)
+	}
+
+	if got, want := count, 8; got != want {
+		t.Errorf("got %v acquisitions, want %v", got, want)
+	}
+}
+
+func TestDir_LockIndependent(t *testing.T) {
+	d, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	locks := []func() (*Lock, error){
+		func() (*Lock, error) { return d.LockDestination(ctx, "a.sif") },
+		func() (*Lock, error) { return d.LockDestination(ctx, "b.sif") },
+		func() (*Lock, error) { return d.LockMetadata(ctx, "a.sif") },
+		func() (*Lock, error) { return d.LockContext(ctx, "sha256.0123") },
+	}
+
+	for i, lock := range locks {
+		l, err := lock()
+		if err != nil {
+			t.Fatalf("lock %v: %v", i, err)
+		}
+		defer l.Unlock()
+	}
+}
+
+func TestDir_LockContextDone(t *testing.T) {
+	d, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := d.LockContext(context.Background(), "sha256.0123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*pollInterval)
+	defer cancel()
+
+	if _, err := d.LockContext(ctx, "sha256.0123"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if err := l.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err = d.LockContext(context.Background(), "sha256.0123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseOwner(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    owner
+		wantErr error
+	}{
+		{"PIDOnly", "123\n", owner{pid: 123}, nil},
+		{"PIDAndStart", "123 4567\n", owner{pid: 123, start: "4567"}, nil},
+		{"Empty", "", owner{}, errMalformedOwner},
+		{"BadPID", "abc 4567\n", owner{}, errMalformedOwner},
+		{"ZeroPID", "0 4567\n", owner{}, errMalformedOwner},
+		{"ExtraFields", "123 4567 8\n", owner{}, errMalformedOwner},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOwner(tt.s)
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if want := tt.want; got != want {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+// exitedPID returns the PID of a process that has exited.
+func exitedPID(t *testing.T) int {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.ProcessState.Pid()
+}
+
+func TestTryLockFile(t *testing.T) {
+	self := currentOwner()
+
+	tests := []struct {
+		name    string
+		record  func(t *testing.T) string // Existing lock file contents, if any.
+		wantErr error
+		linux   bool // Only on Linux, where process start time is available.
+	}{
+		{
+			name: "Unlocked",
+		},
+		{
+			name:    "HeldByLiveProcess",
+			record:  func(*testing.T) string { return self.String() },
+			wantErr: errLocked,
+		},
+		{
+			name:    "MalformedRecord",
+			record:  func(*testing.T) string { return "garbage" },
+			wantErr: errLocked,
+		},
+		{
+			name:   "StaleExited",
+			record: func(t *testing.T) string { return owner{pid: exitedPID(t)}.String() },
+		},
+		{
+			name:   "StalePIDReused",
+			record: func(*testing.T) string { return owner{pid: self.pid, start: "1"}.String() },
+			linux:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.linux && runtime.GOOS != "linux" {
+				t.Skip("process start time not supported")
+			}
+
+			path := filepath.Join(t.TempDir(), "test.lock")
+
+			if tt.record != nil {
+				if err := os.WriteFile(path, []byte(tt.record(t)), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			l, err := tryLockFile(path)
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+			if err != nil {
+				return
+			}
+
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := string(b), self.String(); got != want {
+				t.Errorf("got owner %q, want %q", got, want)
+			}
+
+			if _, err := tryLockFile(path); !errors.Is(err, errLocked) {
+				t.Errorf("got error %v, want %v", err, errLocked)
+			}
+
+			if err := l.Unlock(); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("lock file not removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestTryLockFileConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+
+	var (
+		wg       sync.WaitGroup
+		acquired atomic.Int32
+	)
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if _, err := tryLockFile(path); err == nil {
+				acquired.Add(1)
+			} else if !errors.Is(err, errLocked) {
+				t.Error(fmt.Errorf("unexpected error: %w", err))
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if got, want := acquired.Load(), int32(1); got != want {
+		t.Errorf("got %v acquisitions, want %v", got, want)
+	}
+}
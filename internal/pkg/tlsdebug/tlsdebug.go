@@ -0,0 +1,261 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package tlsdebug implements diagnostics for TLS certificate verification failures.
+package tlsdebug
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// IsCertificateError returns true if err indicates that the certificate presented by a server
+// could not be verified.
+func IsCertificateError(err error) bool {
+	var (
+		certErr      *tls.CertificateVerificationError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+	return errors.As(err, &certErr) || errors.As(err, &authorityErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &invalidErr)
+}
+
+// Error is a certificate verification error, annotated with the role and URL of the server that
+// presented the certificate.
+type Error struct {
+	Role string // Role of the server, such as "frontend" or "Build Service".
+	URL  string // URL of the server, in scheme://host[:port] format.
+	Err  error  // Underlying error.
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("TLS error connecting to %v at %v: %v (run 'scs-build debug tls %v' for details)",
+		e.Role, e.URL, e.Err, e.URL)
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Wrap annotates err with the role and URL of the server, if it is a certificate verification
+// error. If err carries the URL of the request that failed, that URL is used in place of rawURL,
+// since requests may be redirected to other hosts. Other errors are returned unchanged.
+func Wrap(err error, role, rawURL string) error {
+	if !IsCertificateError(err) {
+		return err
+	}
+
+	var te *Error
+	if errors.As(err, &te) {
+		return err
+	}
+
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		rawURL = ue.URL
+	}
+
+	return &Error{Role: role, URL: serverURL(rawURL), Err: err}
+}
+
+// serverURL returns the scheme and host of rawURL, in scheme://host[:port] format. Websocket
+// schemes are mapped to their HTTP equivalents. If rawURL cannot be parsed, it is returned as is.
+func serverURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+
+	switch u.Scheme {
+	case "wss":
+		u.Scheme = "https"
+	case "ws":
+		u.Scheme = "http"
+	}
+
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host}).String()
+}
+
+var errUnsupportedScheme = errors.New("unsupported URL scheme")
+
+// hostPort returns the host and port to connect to for rawURL. If rawURL contains no scheme, it
+// is assumed to be HTTPS. If rawURL contains no port, port 443 is used.
+func hostPort(rawURL string) (host, port string, err error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	if u.Scheme != "https" && u.Scheme != "wss" {
+		return "", "", fmt.Errorf("%w %q: expected https", errUnsupportedScheme, u.Scheme)
+	}
+
+	port = u.Port()
+	if port == "" {
+		port = "443"
+	}
+	return u.Hostname(), port, nil
+}
+
+// Certificate summarizes a certificate presented by a server.
+type Certificate struct {
+	Subject   string    // Subject distinguished name.
+	Issuer    string    // Issuer distinguished name.
+	SANs      []string  // Subject alternative names (DNS names, IP addresses and URIs).
+	NotBefore time.Time // Start of validity period.
+	NotAfter  time.Time // End of validity period.
+}
+
+func newCertificate(c *x509.Certificate) Certificate {
+	sans := append([]string(nil), c.DNSNames...)
+	for _, ip := range c.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, u := range c.URIs {
+		sans = append(sans, u.String())
+	}
+
+	return Certificate{
+		Subject:   c.Subject.String(),
+		Issuer:    c.Issuer.String(),
+		SANs:      sans,
+		NotBefore: c.NotBefore,
+		NotAfter:  c.NotAfter,
+	}
+}
+
+// Report describes the certificate chain presented by a server, and the outcome of verifying it.
+type Report struct {
+	Addr      string        // Address connected to, in host:port format.
+	Chain     []Certificate // Certificates presented by the server, leaf first.
+	VerifyErr error         // Verification error, or nil if the chain was verified.
+}
+
+// Inspect connects to the server at rawURL, and returns a report describing the certificate chain
+// it presents. The chain is verified against the roots in config, or the system roots if none are
+// set. Client certificates in config are presented to the server, if requested.
+func Inspect(ctx context.Context, rawURL string, config *tls.Config) (*Report, error) {
+	host, port, err := hostPort(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var c *tls.Config
+	if config != nil {
+		c = config.Clone()
+	} else {
+		c = &tls.Config{} //nolint:gosec
+	}
+
+	// Verification is performed below, so that the chain can be reported even if it is invalid.
+	c.InsecureSkipVerify = true //nolint:gosec
+	c.ServerName = host
+
+	addr := net.JoinHostPort(host, port)
+
+	conn, err := (&tls.Dialer{Config: c}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %v: %w", addr, err)
+	}
+	defer conn.Close()
+
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%v presented no certificates", addr)
+	}
+
+	r := &Report{Addr: addr}
+	for _, cert := range certs {
+		r.Chain = append(r.Chain, newCertificate(cert))
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, r.VerifyErr = certs[0].Verify(x509.VerifyOptions{
+		DNSName:       host,
+		Roots:         c.RootCAs,
+		Intermediates: intermediates,
+	})
+
+	return r, nil
+}
+
+// Advice returns advice on whether the --ca-cert or --skip-verify flags would allow a connection
+// to the server.
+func (r *Report) Advice() string {
+	var (
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+
+	switch {
+	case r.VerifyErr == nil:
+		return "The certificate chain is valid. Neither --ca-cert nor --skip-verify is required."
+
+	case errors.As(r.VerifyErr, &authorityErr):
+		return "The certificate chain is not signed by a trusted authority. If you trust the issuer, " +
+			"supplying its certificate using --ca-cert would help. --skip-verify would also allow a " +
+			"connection, but disables verification entirely."
+
+	case errors.As(r.VerifyErr, &hostnameErr):
+		return "The certificate is not valid for this host, so --ca-cert would not help. Check the " +
+			"URL, or ask the server administrator to include the host in the certificate. " +
+			"--skip-verify would allow a connection, but disables verification entirely."
+
+	case errors.As(r.VerifyErr, &invalidErr) && invalidErr.Reason == x509.Expired:
+		return "The certificate has expired or is not yet valid, so --ca-cert would not help. Check " +
+			"the system clock, or ask the server administrator to renew the certificate. " +
+			"--skip-verify would allow a connection, but disables verification entirely."
+
+	default:
+		return "--ca-cert is unlikely to help. --skip-verify would allow a connection, but disables " +
+			"verification entirely."
+	}
+}
+
+// Write writes r in human-readable form to w.
+func (r *Report) Write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Address:\t%v\n", r.Addr)
+
+	for i, c := range r.Chain {
+		fmt.Fprintf(tw, "\nCertificate %v:\t\n", i)
+		fmt.Fprintf(tw, "  Subject:\t%v\n", c.Subject)
+		fmt.Fprintf(tw, "  Issuer:\t%v\n", c.Issuer)
+		fmt.Fprintf(tw, "  SANs:\t%v\n", strings.Join(c.SANs, ", "))
+		fmt.Fprintf(tw, "  Valid:\t%v to %v\n", c.NotBefore.UTC().Format(time.RFC3339), c.NotAfter.UTC().Format(time.RFC3339))
+	}
+
+	verification := "OK"
+	if r.VerifyErr != nil {
+		verification = r.VerifyErr.Error()
+	}
+	fmt.Fprintf(tw, "\nVerification:\t%v\n", verification)
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "\n%v\n", r.Advice())
+	return err
+}
@@ -0,0 +1,255 @@
+// Copyright (c) 2024, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package tlsdebug
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testCA is a certificate authority used to issue server certificates.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCA{cert: cert, key: key}
+}
+
+// pool returns a pool containing the CA certificate.
+func (ca *testCA) pool() *x509.CertPool {
+	p := x509.NewCertPool()
+	p.AddCert(ca.cert)
+	return p
+}
+
+// issue returns a server certificate issued by ca, valid for the specified period and names.
+func (ca *testCA) issue(t *testing.T, notBefore, notAfter time.Time, dnsNames []string, ips []net.IP) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "Test Server"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     dnsNames,
+		IPAddresses:  ips,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der, ca.cert.Raw}, PrivateKey: key}
+}
+
+// newServer starts a TLS server presenting cert, which is closed when the test completes.
+func newServer(t *testing.T, cert tls.Certificate) *httptest.Server {
+	t.Helper()
+
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	s.TLS = &tls.Config{Certificates: []tls.Certificate{cert}} //nolint:gosec
+	s.StartTLS()
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func TestInspect(t *testing.T) {
+	ca := newTestCA(t)
+
+	now := time.Now()
+	localhost := []net.IP{net.ParseIP("127.0.0.1")}
+
+	tests := []struct {
+		name       string
+		cert       tls.Certificate
+		roots      *x509.CertPool
+		wantSANs   []string
+		wantVerify func(error) bool
+		wantAdvice string
+	}{
+		{
+			name:       "Valid",
+			cert:       ca.issue(t, now.Add(-time.Hour), now.Add(time.Hour), nil, localhost),
+			roots:      ca.pool(),
+			wantSANs:   []string{"127.0.0.1"},
+			wantVerify: func(err error) bool { return err == nil },
+			wantAdvice: "Neither --ca-cert nor --skip-verify is required",
+		},
+		{
+			name:     "UnknownAuthority",
+			cert:     ca.issue(t, now.Add(-time.Hour), now.Add(time.Hour), nil, localhost),
+			wantSANs: []string{"127.0.0.1"},
+			wantVerify: func(err error) bool {
+				var e x509.UnknownAuthorityError
+				return errors.As(err, &e)
+			},
+			wantAdvice: "--ca-cert would help",
+		},
+		{
+			name:     "Expired",
+			cert:     ca.issue(t, now.Add(-2*time.Hour), now.Add(-time.Hour), nil, localhost),
+			roots:    ca.pool(),
+			wantSANs: []string{"127.0.0.1"},
+			wantVerify: func(err error) bool {
+				var e x509.CertificateInvalidError
+				return errors.As(err, &e) && e.Reason == x509.Expired
+			},
+			wantAdvice: "has expired",
+		},
+		{
+			name:     "WrongSAN",
+			cert:     ca.issue(t, now.Add(-time.Hour), now.Add(time.Hour), []string{"other.example.com"}, nil),
+			roots:    ca.pool(),
+			wantSANs: []string{"other.example.com"},
+			wantVerify: func(err error) bool {
+				var e x509.HostnameError
+				return errors.As(err, &e)
+			},
+			wantAdvice: "not valid for this host",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newServer(t, tt.cert)
+
+			r, err := Inspect(context.Background(), s.URL, &tls.Config{RootCAs: tt.roots}) //nolint:gosec
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, s.Listener.Addr().String(), r.Addr)
+
+			if assert.Len(t, r.Chain, 2) {
+				assert.Equal(t, "CN=Test Server", r.Chain[0].Subject)
+				assert.Equal(t, "CN=Test CA", r.Chain[0].Issuer)
+				assert.Equal(t, tt.wantSANs, r.Chain[0].SANs)
+				assert.Equal(t, "CN=Test CA", r.Chain[1].Subject)
+			}
+
+			assert.True(t, tt.wantVerify(r.VerifyErr), "unexpected verification error: %v", r.VerifyErr)
+			assert.Contains(t, r.Advice(), tt.wantAdvice)
+
+			var b bytes.Buffer
+			if assert.NoError(t, r.Write(&b)) {
+				assert.Contains(t, b.String(), "CN=Test Server")
+				assert.Contains(t, b.String(), tt.wantAdvice)
+			}
+		})
+	}
+}
+
+func TestInspectErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		rawURL  string
+		wantErr error
+	}{
+		{"HTTP", "http://example.com", errUnsupportedScheme},
+		{"Malformed", "https://example.com:port", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Inspect(context.Background(), tt.rawURL, nil)
+			if assert.Error(t, err) && tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWrap(t *testing.T) {
+	certErr := &tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}
+	otherErr := errors.New("other")
+
+	tests := []struct {
+		name    string
+		err     error
+		rawURL  string
+		wantURL string
+	}{
+		{"Nil", nil, "https://build.example.com", ""},
+		{"Other", otherErr, "https://build.example.com", ""},
+		{"Certificate", certErr, "https://build.example.com/v1/build", "https://build.example.com"},
+		{"Websocket", certErr, "wss://build.example.com:8443/v1/build-ws/id", "https://build.example.com:8443"},
+		{"RequestURL", &url.Error{Op: "Get", URL: "https://objects.example.com/bucket/key", Err: certErr}, "https://library.example.com", "https://objects.example.com"},
+		{"Wrapped", fmt.Errorf("wrapped: %w", &Error{Role: "frontend", URL: "https://example.com", Err: certErr}), "https://other.example.com", "https://example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Wrap(tt.err, "Build Service", tt.rawURL)
+
+			var te *Error
+			if !errors.As(err, &te) {
+				assert.Equal(t, tt.err, err)
+				assert.Empty(t, tt.wantURL)
+				return
+			}
+
+			assert.Equal(t, tt.wantURL, te.URL)
+			assert.ErrorIs(t, err, certErr)
+			assert.True(t, strings.Contains(err.Error(), "scs-build debug tls "+tt.wantURL))
+		})
+	}
+}